@@ -12,3 +12,23 @@ select * from t where :. = 2#syntax error at position 24 near :
 select * from t where ::1 = 2#syntax error at position 25 near ::
 select * from t where ::. = 2#syntax error at position 25 near ::
 select /* aa#syntax error at position 13 near /* aa
+savepoint#syntax error at position 11
+savepoint a b#syntax error at position 14 near b
+savepoint a; select 1#syntax error at position 20 near select
+release a#syntax error at position 10 near a
+rollback#syntax error at position 10
+rollback to#syntax error at position 13
+rollback to a b#expecting savepoint at position 16 near b
+set global transaction isolation level read committed#syntax error at position 23 near transaction
+set transaction isolation level read#syntax error at position 38
+start transaction read#syntax error at position 24
+start transaction with consistent snapshot#syntax error at position 23 near with
+explain#syntax error at position 9
+show vitess_shards from#syntax error at position 25
+show warnings limit 1#syntax error at position 20 near limit
+show full#syntax error at position 11
+use#syntax error at position 5
+use a b#syntax error at position 8 near b
+kill connection 3#syntax error at position 16 near connection
+kill query#syntax error at position 12
+kill 3 4#syntax error at position 9 near 4
@@ -185,3 +185,44 @@ analyze table a#alter table a
 show foobar#other
 describe foobar#other
 explain foobar#other
+show create table t#other
+show full tables#other
+explain extended select * from t#other
+select 1#select 1 from dual
+select last_insert_id() as id from dual
+select * from t;#select * from t
+create temporary table a (id int)#create temporary table a
+create temporary table if not exists a select * from b#create temporary table a
+drop temporary table if exists a#drop temporary table a
+savepoint a
+savepoint savepoint
+SAVEPOINT `a`;#savepoint a
+/* comment */ savepoint A#savepoint A
+release savepoint a
+rollback to a#rollback to savepoint a
+rollback work to savepoint a#rollback to savepoint a
+Rollback To Savepoint savepoint#rollback to savepoint savepoint
+rollback to savepoint#rollback to savepoint savepoint
+set transaction isolation level read committed
+SET SESSION TRANSACTION ISOLATION LEVEL SERIALIZABLE;#set transaction isolation level serializable
+set /* comment */ transaction isolation level Repeatable Read#set /* comment */ transaction isolation level repeatable read
+set transaction isolation level read uncommitted
+start transaction
+START TRANSACTION READ ONLY#start transaction read only
+start transaction read write#start transaction
+explain select * from t
+EXPLAIN	update t set a = 1#explain update t set a = 1
+describe plan delete from t#explain delete from t
+show warnings
+show processlist
+SHOW FULL PROCESSLIST;#show full processlist
+show vitess_keyspaces
+/* comment */ show Vitess_Shards from user;#show vitess_shards from user
+show vitess_transactions from `main`#show vitess_transactions from main
+use user
+USE `user`;#use user
+kill 12
+/* comment */ KILL QUERY 3;#kill 3
+select * from savepoint where start = 1 and kill = 2 and level = 3
+select session, transaction, read, warnings from full
+rename table a to savepoint#rename table a savepoint
@@ -0,0 +1,13 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// Imports and registers the built-in vindexes.
+// Custom vindexes can be compiled in the same way,
+// by adding a plugin file that imports their package.
+
+import (
+	_ "github.com/youtube/vitess/go/vt/vtgate/vindexes"
+)
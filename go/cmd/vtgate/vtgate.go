@@ -6,6 +6,7 @@ package main
 
 import (
 	"flag"
+	"net/http"
 	"time"
 
 	log "github.com/golang/glog"
@@ -16,12 +17,17 @@ import (
 )
 
 var (
-	cell        = flag.String("cell", "test_nj", "cell to use")
-	schemaFile  = flag.String("schema-file", "", "JSON schema file")
-	retryDelay  = flag.Duration("retry-delay", 200*time.Millisecond, "retry delay")
-	retryCount  = flag.Int("retry-count", 10, "retry count")
-	timeout     = flag.Duration("timeout", 5*time.Second, "connection and call timeout")
-	maxInFlight = flag.Int("max-in-flight", 0, "maximum number of calls to allow simultaneously")
+	cell         = flag.String("cell", "test_nj", "cell to use")
+	schemaFile   = flag.String("schema-file", "", "JSON schema file")
+	schemaReload = flag.Duration("schema-reload-interval", 0, "how often to check the schema file for changes, 0 disables the reload")
+	topoSchema   = flag.Duration("topo-schema-reload-interval", 0, "if -schema-file is not set, load the schema from the vschemas of the keyspaces in the topology, and check them this often for changes, 0 disables it")
+	unsharded    = flag.Duration("unsharded-discovery-interval", 0, "route the tables of the unsharded keyspaces that have no schema, and discover them again this often, 0 disables it")
+	trackSchema  = flag.Duration("schema-tracking-interval", 0, "learn the columns of the tables from the tablets, and learn them again this often, 0 disables it")
+	tabletTypes  = flag.Duration("tablet-type-rules-reload-interval", 0, "apply the tablet type rules of the keyspaces in the topology, and check them this often for changes, 0 disables them")
+	retryDelay   = flag.Duration("retry-delay", 200*time.Millisecond, "retry delay")
+	retryCount   = flag.Int("retry-count", 10, "retry count")
+	timeout      = flag.Duration("timeout", 5*time.Second, "connection and call timeout")
+	maxInFlight  = flag.Int("max-in-flight", 0, "maximum number of calls to allow simultaneously")
 )
 
 var resilientSrvTopoServer *vtgate.ResilientSrvTopoServer
@@ -50,14 +56,38 @@ func main() {
 	ts := topo.GetServer()
 	defer topo.CloseServers()
 
-	resilientSrvTopoServer = vtgate.NewResilientSrvTopoServer(ts, "ResilientSrvTopoServer")
+	srvTopoBackend, err := vtgate.NewSrvTopoBackend(ts)
+	if err != nil {
+		log.Fatalf("Cannot create the SrvTopoBackend: %v", err)
+	}
+	resilientSrvTopoServer = vtgate.NewResilientSrvTopoServer(srvTopoBackend, "ResilientSrvTopoServer")
+	http.Handle("/debug/topology", resilientSrvTopoServer.HealthHandler())
+	srvTopoServer, err := vtgate.NewOverrideSrvTopoServer(resilientSrvTopoServer)
+	if err != nil {
+		log.Fatalf("Cannot load the serving graph override: %v", err)
+	}
 
 	// For the initial phase vtgate is exposing
 	// topoReader api. This will be subsumed by
 	// vtgate once vtgate's client functions become active.
-	topoReader = NewTopoReader(resilientSrvTopoServer)
+	topoReader = NewTopoReader(srvTopoServer)
 	servenv.Register("toporeader", topoReader)
 
-	vtgate.Init(resilientSrvTopoServer, schema, *cell, *retryDelay, *retryCount, *timeout, *maxInFlight)
+	vtgate.Init(srvTopoServer, schema, *cell, *retryDelay, *retryCount, *timeout, *maxInFlight)
+	if *schemaFile != "" && *schemaReload > 0 {
+		vtgate.RpcVTGate.WatchSchemaFile(*schemaFile, *schemaReload)
+	}
+	if *schemaFile == "" && *topoSchema > 0 {
+		vtgate.RpcVTGate.WatchTopoSchema(ts, *topoSchema)
+	}
+	if *unsharded > 0 {
+		vtgate.RpcVTGate.DiscoverUnshardedTables(*unsharded)
+	}
+	if *trackSchema > 0 {
+		vtgate.RpcVTGate.TrackSchema(*trackSchema)
+	}
+	if *tabletTypes > 0 {
+		vtgate.RpcVTGate.WatchTabletTypeRules(ts, *tabletTypes)
+	}
 	servenv.RunDefault()
 }
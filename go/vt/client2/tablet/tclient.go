@@ -156,7 +156,7 @@ func (conn *Conn) Begin() (db.Tx, error) {
 	if conn.TransactionId != 0 {
 		return &Tx{}, ErrNoNestedTxn
 	}
-	if transactionId, err := conn.tabletConn.Begin(context.TODO()); err != nil {
+	if transactionId, err := conn.tabletConn.Begin(context.TODO(), "", false, 0); err != nil {
 		return &Tx{}, conn.fmtErr(err)
 	} else {
 		conn.TransactionId = transactionId
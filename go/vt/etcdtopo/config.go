@@ -30,6 +30,7 @@ const (
 	srvKeyspaceFilename      = "_SrvKeyspace"
 	srvShardFilename         = "_SrvShard"
 	endPointsFilename        = "_EndPoints"
+	vschemaFilename          = "_VSchema"
 )
 
 var (
@@ -52,6 +53,10 @@ func keyspaceFilePath(keyspace string) string {
 	return path.Join(keyspaceDirPath(keyspace), keyspaceFilename)
 }
 
+func vschemaFilePath(keyspace string) string {
+	return path.Join(keyspaceDirPath(keyspace), vschemaFilename)
+}
+
 func shardsDirPath(keyspace string) string {
 	return keyspaceDirPath(keyspace)
 }
@@ -94,6 +94,24 @@ func (s *Server) GetKeyspaces() ([]string, error) {
 	return getNodeNames(resp)
 }
 
+// SaveVSchema implements topo.Server.
+func (s *Server) SaveVSchema(keyspace, vschema string) error {
+	_, err := s.getGlobal().Set(vschemaFilePath(keyspace), vschema, 0 /* ttl */)
+	return convertError(err)
+}
+
+// GetVSchema implements topo.Server.
+func (s *Server) GetVSchema(keyspace string) (string, error) {
+	resp, err := s.getGlobal().Get(vschemaFilePath(keyspace), false /* sort */, false /* recursive */)
+	if err != nil {
+		return "", convertError(err)
+	}
+	if resp.Node == nil {
+		return "", ErrBadResponse
+	}
+	return resp.Node.Value, nil
+}
+
 // DeleteKeyspaceShards implements topo.Server.
 func (s *Server) DeleteKeyspaceShards(keyspace string) error {
 	shards, err := s.GetShardNames(keyspace)
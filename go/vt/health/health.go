@@ -22,6 +22,11 @@ const (
 	// ReplicationLagHigh should be the value for any reporters
 	// indicating that the replication lag is too high.
 	ReplicationLagHigh = "high"
+
+	// ReplicationLagSeconds should be the key for any reporters
+	// reporting an upper bound of the MySQL replication lag, in
+	// seconds. It's not reported if the lag is under a second.
+	ReplicationLagSeconds = "replication_lag_seconds"
 )
 
 func init() {
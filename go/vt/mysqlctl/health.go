@@ -3,6 +3,7 @@ package mysqlctl
 import (
 	"fmt"
 	"html/template"
+	"strconv"
 
 	"github.com/youtube/vitess/go/vt/health"
 	"github.com/youtube/vitess/go/vt/topo"
@@ -26,10 +27,24 @@ func (mrl *mysqlReplicationLag) Report(tabletType topo.TabletType, shouldQuerySe
 	if !slaveStatus.SlaveRunning() || int(slaveStatus.SecondsBehindMaster) > mrl.allowedLagInSeconds {
 		return map[string]string{health.ReplicationLag: health.ReplicationLagHigh}, nil
 	}
+	if slaveStatus.SecondsBehindMaster > 0 {
+		return map[string]string{health.ReplicationLagSeconds: strconv.Itoa(lagUpperBound(int(slaveStatus.SecondsBehindMaster)))}, nil
+	}
 
 	return nil, nil
 }
 
+// lagUpperBound rounds lag up to a power of two. Every change of
+// the health of a tablet updates its record in the topology, so
+// the lag is only reported at that granularity.
+func lagUpperBound(lag int) int {
+	bound := 1
+	for bound < lag {
+		bound *= 2
+	}
+	return bound
+}
+
 func (mrl *mysqlReplicationLag) HTMLName() template.HTML {
 	return template.HTML(fmt.Sprintf("MySQLReplicationLag(allowedLag=%v)", mrl.allowedLagInSeconds))
 }
@@ -0,0 +1,23 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import "testing"
+
+func TestLagUpperBound(t *testing.T) {
+	for _, tcase := range []struct {
+		lag, want int
+	}{
+		{1, 1},
+		{2, 2},
+		{3, 4},
+		{8, 8},
+		{9, 16},
+	} {
+		if got := lagUpperBound(tcase.lag); got != tcase.want {
+			t.Errorf("lagUpperBound(%v): %v, want %v", tcase.lag, got, tcase.want)
+		}
+	}
+}
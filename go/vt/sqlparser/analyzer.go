@@ -119,3 +119,10 @@ func StringIn(str string, values ...string) bool {
 	}
 	return false
 }
+
+// FirstKeyword returns the token of the first keyword of sql, like
+// the parser sees it, or ID if sql doesn't start with a keyword.
+// Callers can use it to only parse the statements they handle.
+func FirstKeyword(sql string) int {
+	return NewStringTokenizer(sql).Lex(new(yySymType))
+}
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/youtube/vitess/go/sqltypes"
 )
@@ -53,14 +54,21 @@ type Statement interface {
 	SQLNode
 }
 
-func (*Union) IStatement()  {}
-func (*Select) IStatement() {}
-func (*Insert) IStatement() {}
-func (*Update) IStatement() {}
-func (*Delete) IStatement() {}
-func (*Set) IStatement()    {}
-func (*DDL) IStatement()    {}
-func (*Other) IStatement()  {}
+func (*Union) IStatement()            {}
+func (*Select) IStatement()           {}
+func (*Insert) IStatement()           {}
+func (*Update) IStatement()           {}
+func (*Delete) IStatement()           {}
+func (*Set) IStatement()              {}
+func (*DDL) IStatement()              {}
+func (*Other) IStatement()            {}
+func (*Savepoint) IStatement()        {}
+func (*SetTransaction) IStatement()   {}
+func (*StartTransaction) IStatement() {}
+func (*Explain) IStatement()          {}
+func (*Show) IStatement()             {}
+func (*Use) IStatement()              {}
+func (*Kill) IStatement()             {}
 
 // SelectStatement any SELECT statement.
 type SelectStatement interface {
@@ -194,10 +202,12 @@ func (node *Set) Format(buf *TrackedBuffer) {
 // DDL represents a CREATE, ALTER, DROP or RENAME statement.
 // Table is set for AST_ALTER, AST_DROP, AST_RENAME.
 // NewName is set for AST_ALTER, AST_CREATE, AST_RENAME.
+// Temporary is set for CREATE and DROP TEMPORARY TABLE.
 type DDL struct {
-	Action  string
-	Table   []byte
-	NewName []byte
+	Action    string
+	Table     []byte
+	NewName   []byte
+	Temporary bool
 }
 
 const (
@@ -208,13 +218,17 @@ const (
 )
 
 func (node *DDL) Format(buf *TrackedBuffer) {
+	temporary := ""
+	if node.Temporary {
+		temporary = "temporary "
+	}
 	switch node.Action {
 	case AST_CREATE:
-		buf.Myprintf("%s table %s", node.Action, node.NewName)
+		buf.Myprintf("%s %stable %s", node.Action, temporary, node.NewName)
 	case AST_RENAME:
 		buf.Myprintf("%s table %s %s", node.Action, node.Table, node.NewName)
 	default:
-		buf.Myprintf("%s table %s", node.Action, node.Table)
+		buf.Myprintf("%s %stable %s", node.Action, temporary, node.Table)
 	}
 }
 
@@ -227,6 +241,127 @@ func (node *Other) Format(buf *TrackedBuffer) {
 	buf.WriteString("other")
 }
 
+// Savepoint represents a SAVEPOINT, ROLLBACK TO SAVEPOINT
+// or RELEASE SAVEPOINT statement.
+type Savepoint struct {
+	Action string
+	Name   []byte
+}
+
+const (
+	SavepointCreate   = "savepoint"
+	SavepointRollback = "rollback"
+	SavepointRelease  = "release"
+)
+
+func (node *Savepoint) Format(buf *TrackedBuffer) {
+	switch node.Action {
+	case SavepointRollback:
+		buf.Myprintf("rollback to savepoint %s", node.Name)
+	case SavepointRelease:
+		buf.Myprintf("release savepoint %s", node.Name)
+	default:
+		buf.Myprintf("savepoint %s", node.Name)
+	}
+}
+
+// SetTransaction represents a SET [SESSION] TRANSACTION
+// ISOLATION LEVEL statement.
+type SetTransaction struct {
+	Comments  Comments
+	Isolation string
+}
+
+const (
+	IsolationReadUncommitted = "READ UNCOMMITTED"
+	IsolationReadCommitted   = "READ COMMITTED"
+	IsolationRepeatableRead  = "REPEATABLE READ"
+	IsolationSerializable    = "SERIALIZABLE"
+)
+
+// IsIsolationLevel returns true if level is one of
+// the isolation levels of MySQL, in upper case.
+func IsIsolationLevel(level string) bool {
+	return StringIn(level, IsolationReadUncommitted, IsolationReadCommitted, IsolationRepeatableRead, IsolationSerializable)
+}
+
+func (node *SetTransaction) Format(buf *TrackedBuffer) {
+	buf.Myprintf("set %vtransaction isolation level %s", node.Comments, strings.ToLower(node.Isolation))
+}
+
+// StartTransaction represents a START TRANSACTION statement,
+// with an optional READ ONLY or READ WRITE access mode.
+type StartTransaction struct {
+	ReadOnly bool
+}
+
+func (node *StartTransaction) Format(buf *TrackedBuffer) {
+	if node.ReadOnly {
+		buf.WriteString("start transaction read only")
+		return
+	}
+	buf.WriteString("start transaction")
+}
+
+// Explain represents an EXPLAIN or DESCRIBE PLAN statement,
+// which asks for the plan of Statement. The EXPLAIN statements
+// of tables are parsed as Other.
+type Explain struct {
+	Statement Statement
+}
+
+func (node *Explain) Format(buf *TrackedBuffer) {
+	buf.Myprintf("explain %v", node.Statement)
+}
+
+// Show represents the SHOW statements that are answered by
+// vtgate: SHOW WARNINGS, SHOW [FULL] PROCESSLIST, and the
+// SHOW VITESS_* statements, which can be restricted to
+// Keyspace. The other SHOW statements are parsed as Other.
+type Show struct {
+	Type     string
+	Full     bool
+	Keyspace []byte
+}
+
+const (
+	ShowWarnings           = "warnings"
+	ShowProcesslist        = "processlist"
+	ShowVitessKeyspaces    = "vitess_keyspaces"
+	ShowVitessShards       = "vitess_shards"
+	ShowVitessTransactions = "vitess_transactions"
+)
+
+func (node *Show) Format(buf *TrackedBuffer) {
+	full := ""
+	if node.Full {
+		full = "full "
+	}
+	buf.Myprintf("show %s%s", full, node.Type)
+	if node.Keyspace != nil {
+		buf.Myprintf(" from %s", node.Keyspace)
+	}
+}
+
+// Use represents a USE statement.
+type Use struct {
+	DBName []byte
+}
+
+func (node *Use) Format(buf *TrackedBuffer) {
+	buf.Myprintf("use %s", node.DBName)
+}
+
+// Kill represents a KILL [QUERY] statement. KILL CONNECTION
+// is not supported.
+type Kill struct {
+	ID int64
+}
+
+func (node *Kill) Format(buf *TrackedBuffer) {
+	buf.Myprintf("kill %s", strconv.FormatInt(node.ID, 10))
+}
+
 // Comments represents a list of comments.
 type Comments [][]byte
 
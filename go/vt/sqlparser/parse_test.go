@@ -40,6 +40,25 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestFirstKeyword(t *testing.T) {
+	testcases := []struct {
+		sql  string
+		want int
+	}{
+		{"select * from t", SELECT},
+		{"/* comment */ savepoint a", SAVEPOINT},
+		{"Kill 3", KILL},
+		{"start", START},
+		{"savepoints", ID},
+		{"", 0},
+	}
+	for _, tcase := range testcases {
+		if got := FirstKeyword(tcase.sql); got != tcase.want {
+			t.Errorf("FirstKeyword(%q): %d, want %d", tcase.sql, got, tcase.want)
+		}
+	}
+}
+
 func BenchmarkParse1(b *testing.B) {
 	sql := "select 'abcd', 20, 30.0, eid from a where 1=eid and name='3'"
 	for i := 0; i < b.N; i++ {
@@ -0,0 +1,66 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlparser
+
+import "bytes"
+
+// Session functions returned by SessionFunctions.
+const (
+	LastInsertID = "last_insert_id"
+	FoundRows    = "found_rows"
+)
+
+// SessionFunction is a column of a select that only reads
+// session functions.
+type SessionFunction struct {
+	// Name is LastInsertID or FoundRows.
+	Name string
+	// Column is the name of the column in the result: its
+	// alias, or the call.
+	Column string
+}
+
+// SessionFunctions returns the columns of sel, in order, if it only
+// reads the LAST_INSERT_ID() and FOUND_ROWS() functions of the
+// session from dual. ok is false if sel is not such a select.
+func SessionFunctions(sel *Select) (funcs []SessionFunction, ok bool) {
+	if sel.Distinct != "" || sel.Where != nil || sel.GroupBy != nil || sel.Having != nil ||
+		sel.OrderBy != nil || sel.Limit != nil || sel.Lock != "" || !isDual(sel.From) {
+		return nil, false
+	}
+	for _, expr := range sel.SelectExprs {
+		nonStar, ok := expr.(*NonStarExpr)
+		if !ok {
+			return nil, false
+		}
+		f, ok := nonStar.Expr.(*FuncExpr)
+		if !ok || f.Distinct || len(f.Exprs) != 0 {
+			return nil, false
+		}
+		name := string(f.Name)
+		if name != LastInsertID && name != FoundRows {
+			return nil, false
+		}
+		column := String(f)
+		if nonStar.As != nil {
+			column = string(nonStar.As)
+		}
+		funcs = append(funcs, SessionFunction{Name: name, Column: column})
+	}
+	return funcs, true
+}
+
+// isDual returns true if from is only the dual table.
+func isDual(from TableExprs) bool {
+	if len(from) != 1 {
+		return false
+	}
+	table, ok := from[0].(*AliasedTableExpr)
+	if !ok || table.As != nil || table.Hints != nil {
+		return false
+	}
+	name, ok := table.Expr.(*TableName)
+	return ok && name.Qualifier == nil && bytes.Equal(bytes.ToLower(name.Name), DUAL_BYTES)
+}
@@ -0,0 +1,40 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlparser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSessionFunctions(t *testing.T) {
+	testcases := []struct {
+		sql   string
+		funcs []SessionFunction
+		ok    bool
+	}{
+		{"select last_insert_id()", []SessionFunction{{LastInsertID, "last_insert_id()"}}, true},
+		{"SELECT LAST_INSERT_ID() from DUAL;", []SessionFunction{{LastInsertID, "last_insert_id()"}}, true},
+		{"/* comment */ select found_rows() as n, last_insert_id() id", []SessionFunction{{FoundRows, "n"}, {LastInsertID, "id"}}, true},
+		{"select last_insert_id(1)", nil, false},
+		{"select last_insert_id() from user", nil, false},
+		{"select last_insert_id() from dual where 1 = 1", nil, false},
+		{"select last_insert_id(), 1", nil, false},
+		{"select now()", nil, false},
+		{"select * from user", nil, false},
+		{"select found_rows", nil, false},
+	}
+	for _, tcase := range testcases {
+		stmt, err := Parse(tcase.sql)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tcase.sql, err)
+			continue
+		}
+		funcs, ok := SessionFunctions(stmt.(*Select))
+		if !reflect.DeepEqual(funcs, tcase.funcs) || ok != tcase.ok {
+			t.Errorf("SessionFunctions(%q): %v, %v, want %v, %v", tcase.sql, funcs, ok, tcase.funcs, tcase.ok)
+		}
+	}
+}
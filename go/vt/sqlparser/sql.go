@@ -1,10 +1,16 @@
+// Code generated by goyacc -o sql.go sql.y. DO NOT EDIT.
+
 //line sql.y:6
 package sqlparser
 
 import __yyfmt__ "fmt"
 
 //line sql.y:6
-import "bytes"
+
+import (
+	"bytes"
+	"strconv"
+)
 
 func SetParseTree(yylex interface{}, stmt Statement) {
 	yylex.(*Tokenizer).ParseTree = stmt
@@ -19,13 +25,15 @@ func ForceEOF(yylex interface{}) {
 }
 
 var (
-	SHARE        = []byte("share")
-	MODE         = []byte("mode")
-	IF_BYTES     = []byte("if")
-	VALUES_BYTES = []byte("values")
+	SHARE           = []byte("share")
+	MODE            = []byte("mode")
+	IF_BYTES        = []byte("if")
+	VALUES_BYTES    = []byte("values")
+	SAVEPOINT_BYTES = []byte("savepoint")
+	DUAL_BYTES      = []byte("dual")
 )
 
-//line sql.y:31
+//line sql.y:36
 type yySymType struct {
 	yys         int
 	empty       struct{}
@@ -145,8 +153,37 @@ const USING = 57424
 const SHOW = 57425
 const DESCRIBE = 57426
 const EXPLAIN = 57427
+const SAVEPOINT = 57428
+const ROLLBACK = 57429
+const RELEASE = 57430
+const WORK = 57431
+const START = 57432
+const TRANSACTION = 57433
+const SESSION = 57434
+const ISOLATION = 57435
+const LEVEL = 57436
+const READ = 57437
+const WRITE = 57438
+const ONLY = 57439
+const COMMITTED = 57440
+const UNCOMMITTED = 57441
+const REPEATABLE = 57442
+const SERIALIZABLE = 57443
+const WARNINGS = 57444
+const PROCESSLIST = 57445
+const FULL = 57446
+const VITESS_KEYSPACES = 57447
+const VITESS_SHARDS = 57448
+const VITESS_TRANSACTIONS = 57449
+const KILL = 57450
+const QUERY = 57451
+const PLAN = 57452
+const TEMPORARY = 57453
 
-var yyToknames = []string{
+var yyToknames = [...]string{
+	"$end",
+	"error",
+	"$unk",
 	"LEX_ERROR",
 	"SELECT",
 	"INSERT",
@@ -189,16 +226,16 @@ var yyToknames = []string{
 	"GE",
 	"NE",
 	"NULL_SAFE_EQUAL",
-	" (",
-	" =",
-	" <",
-	" >",
-	" ~",
+	"'('",
+	"'='",
+	"'<'",
+	"'>'",
+	"'~'",
 	"UNION",
 	"MINUS",
 	"EXCEPT",
 	"INTERSECT",
-	" ,",
+	"','",
 	"JOIN",
 	"STRAIGHT_JOIN",
 	"LEFT",
@@ -213,15 +250,15 @@ var yyToknames = []string{
 	"OR",
 	"AND",
 	"NOT",
-	" &",
-	" |",
-	" ^",
-	" +",
-	" -",
-	" *",
-	" /",
-	" %",
-	" .",
+	"'&'",
+	"'|'",
+	"'^'",
+	"'+'",
+	"'-'",
+	"'*'",
+	"'/'",
+	"'%'",
+	"'.'",
 	"UNARY",
 	"CASE",
 	"WHEN",
@@ -244,290 +281,358 @@ var yyToknames = []string{
 	"SHOW",
 	"DESCRIBE",
 	"EXPLAIN",
+	"SAVEPOINT",
+	"ROLLBACK",
+	"RELEASE",
+	"WORK",
+	"START",
+	"TRANSACTION",
+	"SESSION",
+	"ISOLATION",
+	"LEVEL",
+	"READ",
+	"WRITE",
+	"ONLY",
+	"COMMITTED",
+	"UNCOMMITTED",
+	"REPEATABLE",
+	"SERIALIZABLE",
+	"WARNINGS",
+	"PROCESSLIST",
+	"FULL",
+	"VITESS_KEYSPACES",
+	"VITESS_SHARDS",
+	"VITESS_TRANSACTIONS",
+	"KILL",
+	"QUERY",
+	"PLAN",
+	"TEMPORARY",
+	"')'",
+	"';'",
 }
-var yyStatenames = []string{}
+
+var yyStatenames = [...]string{}
 
 const yyEofCode = 1
 const yyErrCode = 2
-const yyMaxDepth = 200
+const yyInitialStackSize = 16
 
 //line yacctab:1
-var yyExca = []int{
+var yyExca = [...]int16{
 	-1, 1,
 	1, -1,
 	-2, 0,
+	-1, 191,
+	36, 251,
+	-2, 46,
 }
 
-const yyNprod = 203
 const yyPrivate = 57344
 
-var yyTokenNames []string
-var yyStates []string
-
-const yyLast = 652
-
-var yyAct = []int{
-
-	94, 294, 159, 364, 91, 85, 331, 248, 62, 162,
-	92, 198, 286, 239, 80, 209, 63, 178, 90, 161,
-	3, 373, 373, 81, 344, 373, 50, 103, 259, 260,
-	261, 262, 263, 186, 264, 265, 130, 76, 65, 230,
-	292, 70, 64, 130, 73, 130, 68, 53, 77, 136,
-	135, 255, 51, 52, 28, 29, 30, 31, 86, 230,
-	311, 313, 124, 38, 72, 40, 342, 375, 374, 41,
-	120, 372, 43, 315, 44, 240, 46, 47, 48, 128,
-	341, 49, 320, 228, 132, 317, 291, 192, 340, 280,
-	312, 278, 163, 69, 158, 160, 164, 121, 45, 322,
-	123, 240, 270, 284, 229, 231, 190, 136, 135, 134,
-	193, 117, 172, 65, 113, 218, 65, 64, 182, 181,
-	64, 176, 324, 119, 71, 168, 146, 147, 148, 149,
-	150, 135, 180, 86, 204, 182, 148, 149, 150, 337,
-	208, 206, 207, 216, 217, 183, 220, 221, 222, 223,
-	224, 225, 226, 227, 203, 196, 115, 202, 287, 219,
-	189, 191, 188, 205, 136, 135, 211, 287, 232, 86,
-	86, 251, 127, 59, 339, 65, 65, 338, 309, 64,
-	246, 234, 236, 244, 348, 250, 305, 252, 308, 237,
-	303, 306, 307, 243, 247, 304, 115, 179, 179, 143,
-	144, 145, 146, 147, 148, 149, 150, 230, 14, 15,
-	16, 17, 269, 232, 253, 256, 129, 273, 274, 349,
-	271, 28, 29, 30, 31, 14, 326, 281, 202, 272,
-	201, 212, 111, 277, 75, 114, 18, 210, 86, 116,
-	200, 211, 257, 115, 359, 174, 285, 358, 357, 112,
-	279, 165, 283, 289, 170, 293, 201, 290, 175, 169,
-	351, 352, 130, 110, 167, 166, 200, 268, 71, 301,
-	302, 66, 316, 259, 260, 261, 262, 263, 319, 264,
-	265, 314, 298, 78, 267, 202, 202, 323, 19, 20,
-	22, 21, 23, 65, 346, 328, 297, 327, 329, 332,
-	321, 24, 25, 26, 143, 144, 145, 146, 147, 148,
-	149, 150, 143, 144, 145, 146, 147, 148, 149, 150,
-	195, 343, 194, 133, 333, 177, 318, 345, 143, 144,
-	145, 146, 147, 148, 149, 150, 102, 347, 125, 232,
-	71, 354, 353, 356, 122, 118, 355, 276, 99, 100,
-	101, 361, 332, 60, 79, 363, 362, 74, 365, 365,
-	365, 65, 366, 367, 325, 64, 235, 14, 97, 368,
-	370, 58, 377, 102, 378, 184, 108, 126, 379, 213,
-	380, 214, 215, 98, 84, 99, 100, 101, 371, 56,
-	242, 32, 54, 295, 89, 336, 296, 275, 106, 143,
-	144, 145, 146, 147, 148, 149, 150, 34, 35, 36,
-	37, 249, 335, 300, 179, 61, 33, 88, 376, 360,
-	14, 104, 105, 82, 185, 39, 254, 187, 109, 97,
-	42, 67, 245, 173, 102, 369, 350, 108, 330, 334,
-	299, 282, 171, 107, 98, 84, 99, 100, 101, 233,
-	238, 96, 93, 95, 288, 89, 241, 137, 87, 106,
-	310, 199, 258, 197, 83, 14, 266, 131, 55, 27,
-	57, 13, 12, 11, 10, 9, 8, 7, 88, 6,
-	97, 5, 104, 105, 82, 102, 4, 2, 108, 109,
-	1, 0, 0, 0, 0, 98, 66, 99, 100, 101,
-	97, 0, 0, 0, 107, 102, 89, 0, 108, 0,
-	106, 0, 0, 0, 0, 98, 66, 99, 100, 101,
-	0, 0, 0, 0, 0, 14, 89, 0, 0, 88,
-	106, 0, 0, 104, 105, 0, 0, 0, 0, 0,
-	109, 0, 0, 0, 0, 102, 0, 0, 108, 88,
-	0, 0, 0, 104, 105, 107, 66, 99, 100, 101,
-	109, 0, 0, 0, 0, 102, 165, 0, 108, 0,
-	106, 0, 0, 0, 0, 107, 66, 99, 100, 101,
-	0, 0, 0, 0, 0, 0, 165, 0, 0, 0,
-	106, 0, 0, 104, 105, 138, 142, 140, 141, 0,
-	109, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-	0, 0, 0, 104, 105, 107, 154, 155, 156, 157,
-	109, 151, 152, 153, 0, 0, 0, 0, 0, 0,
-	0, 0, 0, 0, 0, 107, 0, 0, 0, 0,
-	0, 0, 0, 139, 143, 144, 145, 146, 147, 148,
-	149, 150,
+const yyLast = 712
+
+var yyAct = [...]int16{
+	151, 367, 225, 444, 103, 142, 408, 318, 148, 228,
+	149, 268, 359, 309, 137, 125, 279, 244, 147, 40,
+	105, 202, 201, 227, 3, 160, 138, 424, 453, 305,
+	453, 154, 42, 43, 44, 45, 159, 453, 196, 165,
+	61, 62, 63, 300, 412, 110, 155, 141, 156, 157,
+	158, 78, 108, 365, 196, 113, 82, 146, 196, 117,
+	107, 163, 71, 121, 300, 94, 332, 333, 334, 335,
+	336, 92, 337, 338, 411, 410, 64, 371, 135, 321,
+	145, 298, 372, 373, 161, 162, 139, 194, 193, 88,
+	89, 166, 247, 109, 130, 52, 132, 54, 119, 143,
+	455, 56, 454, 82, 253, 71, 164, 173, 299, 452,
+	397, 72, 90, 178, 120, 394, 74, 73, 111, 422,
+	388, 390, 327, 187, 100, 364, 353, 183, 133, 179,
+	351, 55, 421, 182, 192, 420, 301, 392, 303, 112,
+	79, 198, 189, 68, 69, 67, 75, 76, 77, 229,
+	389, 224, 226, 230, 72, 123, 58, 93, 59, 74,
+	73, 180, 122, 116, 106, 260, 115, 65, 60, 238,
+	108, 202, 201, 108, 242, 399, 249, 248, 107, 310,
+	234, 107, 81, 310, 258, 357, 401, 127, 261, 214,
+	215, 216, 250, 246, 212, 213, 214, 215, 216, 143,
+	274, 249, 343, 264, 200, 175, 278, 276, 277, 286,
+	287, 170, 290, 291, 292, 293, 294, 295, 296, 297,
+	114, 272, 188, 273, 168, 288, 177, 171, 431, 432,
+	281, 202, 201, 201, 302, 143, 143, 66, 257, 259,
+	256, 108, 108, 417, 360, 314, 172, 304, 306, 107,
+	316, 320, 322, 186, 323, 307, 245, 360, 195, 275,
+	317, 382, 131, 313, 419, 418, 383, 386, 385, 289,
+	324, 325, 209, 210, 211, 212, 213, 214, 215, 216,
+	384, 328, 342, 302, 380, 329, 172, 346, 347, 381,
+	344, 332, 333, 334, 335, 336, 272, 337, 338, 245,
+	345, 330, 300, 350, 196, 126, 429, 403, 143, 281,
+	354, 271, 282, 428, 20, 174, 358, 439, 280, 438,
+	352, 270, 356, 362, 437, 366, 231, 363, 209, 210,
+	211, 212, 213, 214, 215, 216, 42, 43, 44, 45,
+	236, 240, 378, 379, 172, 271, 235, 233, 232, 159,
+	136, 396, 167, 341, 241, 270, 272, 272, 114, 199,
+	400, 156, 157, 158, 109, 393, 108, 391, 405, 375,
+	340, 406, 409, 398, 404, 395, 114, 209, 210, 211,
+	212, 213, 214, 215, 216, 20, 21, 22, 23, 374,
+	413, 266, 265, 20, 21, 22, 23, 263, 423, 262,
+	254, 243, 191, 348, 425, 209, 210, 211, 212, 213,
+	214, 215, 216, 24, 427, 190, 169, 184, 181, 302,
+	176, 434, 433, 436, 80, 101, 435, 134, 124, 118,
+	91, 441, 409, 86, 450, 443, 442, 426, 445, 445,
+	445, 108, 446, 447, 37, 448, 402, 349, 99, 107,
+	457, 251, 451, 283, 458, 284, 285, 185, 459, 97,
+	460, 20, 95, 368, 416, 25, 26, 28, 27, 29,
+	209, 210, 211, 212, 213, 214, 215, 216, 30, 31,
+	32, 33, 34, 35, 312, 36, 369, 46, 319, 154,
+	415, 377, 245, 129, 159, 102, 456, 165, 20, 21,
+	22, 23, 440, 38, 155, 141, 156, 157, 158, 48,
+	49, 50, 51, 20, 47, 146, 85, 6, 128, 163,
+	84, 5, 83, 4, 70, 20, 370, 104, 87, 39,
+	252, 53, 326, 255, 57, 315, 239, 449, 145, 430,
+	154, 407, 161, 162, 139, 159, 414, 376, 165, 166,
+	355, 237, 308, 153, 150, 155, 109, 156, 157, 158,
+	154, 152, 361, 311, 164, 159, 146, 203, 165, 144,
+	163, 387, 269, 331, 267, 155, 109, 156, 157, 158,
+	140, 339, 197, 96, 41, 20, 146, 98, 19, 145,
+	163, 18, 17, 161, 162, 16, 15, 14, 13, 12,
+	166, 11, 10, 9, 8, 159, 7, 2, 165, 145,
+	1, 0, 0, 161, 162, 164, 109, 156, 157, 158,
+	166, 0, 0, 0, 0, 159, 231, 0, 165, 0,
+	163, 0, 0, 0, 0, 164, 109, 156, 157, 158,
+	0, 0, 0, 0, 0, 0, 231, 0, 0, 0,
+	163, 0, 0, 161, 162, 204, 208, 206, 207, 0,
+	166, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 161, 162, 164, 220, 221, 222, 223,
+	166, 217, 218, 219, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 164, 0, 0, 0, 0,
+	0, 0, 0, 205, 209, 210, 211, 212, 213, 214,
+	215, 216,
 }
-var yyPact = []int{
-
-	203, -1000, -1000, 170, -1000, -1000, -1000, -1000, -1000, -1000,
-	-1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -27,
-	-20, 8, -14, -9, -1000, -1000, -1000, 415, 375, -1000,
-	-1000, -1000, 371, -1000, 342, 317, 406, 235, -49, 2,
-	232, -1000, -26, 232, -1000, 321, -58, 232, -58, 318,
-	-1000, -1000, -1000, -1000, -1000, 409, -1000, 222, 317, 216,
-	36, 317, 141, -1000, 192, -1000, 33, 309, 54, 232,
-	-1000, -1000, 308, -1000, -31, 302, 357, 106, 232, -1000,
-	207, -1000, -1000, 304, 31, 97, 574, -1000, 480, 460,
-	-1000, -1000, -1000, 540, 219, 218, -1000, 213, 208, -1000,
-	-1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, 540,
-	-1000, 212, 235, 289, 404, 235, 540, 232, -1000, 355,
-	-64, -1000, 74, -1000, 286, -1000, -1000, 284, -1000, 194,
-	409, -1000, -1000, 232, 88, 480, 480, 540, 191, 358,
-	540, 540, 90, 540, 540, 540, 540, 540, 540, 540,
-	540, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, 574,
-	-18, 3, 4, 574, -1000, 520, 348, 409, -1000, 415,
-	311, -6, 242, 362, 235, 235, 188, -1000, 398, 480,
-	-1000, 242, -1000, -1000, -1000, 105, 232, -1000, -42, -1000,
-	-1000, -1000, -1000, -1000, -1000, -1000, -1000, 187, 217, 248,
-	220, 24, -1000, -1000, -1000, -1000, -1000, 63, 242, -1000,
-	520, -1000, -1000, 191, 540, 540, 242, 329, -1000, 322,
-	53, 53, 53, 61, 61, -1000, -1000, -1000, -1000, -1000,
-	540, -1000, 242, -1000, -10, 409, -12, 172, 20, -1000,
-	480, 92, 205, 170, 101, -15, -1000, 398, 378, 382,
-	97, 260, -1000, -1000, 246, -1000, 402, 194, 194, -1000,
-	-1000, 134, 130, 136, 132, 122, -4, -1000, 245, -28,
-	236, -16, -1000, 242, 258, 540, -1000, 242, -1000, -19,
-	-1000, 311, 15, -1000, 540, 40, -1000, 334, 171, -1000,
-	-1000, -1000, 235, 378, -1000, 540, 540, -1000, -1000, 400,
-	381, 217, 73, -1000, 121, -1000, 118, -1000, -1000, -1000,
-	-1000, -3, -11, -25, -1000, -1000, -1000, -1000, 540, 242,
-	-1000, -77, -1000, 242, 540, 263, 205, -1000, -1000, 129,
-	164, -1000, 234, -1000, 398, 480, 540, 480, -1000, -1000,
-	202, 201, 198, 242, -1000, 242, 412, -1000, 540, 540,
-	-1000, -1000, -1000, 378, 97, 152, 97, 232, 232, 232,
-	235, 242, -1000, 354, -30, -1000, -33, -34, 141, -1000,
-	411, 351, -1000, 232, -1000, -1000, -1000, 232, -1000, 232,
+
+var yyPact = [...]int16{
+	380, -1000, -109, 285, -1000, -1000, -1000, -1000, -1000, -1000,
+	-1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000,
+	-1000, -1000, -1000, -1000, -1000, 5, 64, 78, -50, 77,
+	26, 15, 388, 397, -15, -11, 6, 394, 33, -1000,
+	-1000, 508, 445, -1000, -1000, -1000, 441, -1000, 419, 389,
+	486, 57, 23, 48, 322, 76, -1000, 73, 322, -1000,
+	393, 19, 322, 19, 65, 392, -1000, 69, -1000, -1000,
+	484, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, 493,
+	-1000, -1000, 285, -1000, -1000, -1000, -1000, 35, -1000, 391,
+	-32, -1000, -1000, 312, -1000, -1000, 469, -1000, 311, 389,
+	383, 133, 389, 231, 1, -1000, -1000, 268, -1000, 127,
+	384, 157, 322, -1000, -1000, 23, 382, -1000, 34, 381,
+	437, 187, 322, 19, -1000, -1000, -1000, -1000, -1000, 379,
+	-1000, -1000, -1000, 366, -1000, -24, -1000, 249, -1000, -1000,
+	340, 126, 164, 634, -1000, 540, 520, -1000, -1000, -1000,
+	600, 302, 301, -1000, 300, 294, -1000, -1000, -1000, -1000,
+	-1000, -1000, -1000, -1000, -1000, -1000, 600, -1000, 308, 328,
+	365, 482, 328, -16, 600, 322, -1000, 431, 7, -1000,
+	364, 152, -1000, 363, -1000, -1000, 361, -1000, 356, -1000,
+	-1000, -1000, 355, -1000, -1000, 275, 469, -1000, -1000, 322,
+	184, 540, 540, 600, 272, 432, 600, 600, 200, 600,
+	600, 600, 600, 600, 600, 600, 600, -1000, -1000, -1000,
+	-1000, -1000, -1000, -1000, -1000, 634, -46, -19, 9, 634,
+	-1000, 580, 11, 469, -1000, 508, 324, 98, 400, 456,
+	328, 328, 289, -1000, 475, 540, -1000, -30, 400, -1000,
+	-1000, -1000, 186, 322, -1000, -1000, 29, -1000, -1000, -1000,
+	-1000, -1000, -1000, -1000, -1000, -1000, -1000, 246, 235, 334,
+	309, 124, -1000, -1000, -1000, -1000, -1000, 165, 400, -1000,
+	580, -1000, -1000, 272, 600, 600, 400, 335, -1000, 422,
+	121, 121, 121, 114, 114, -1000, -1000, -1000, -1000, -1000,
+	600, -1000, 400, -1000, 3, 469, -1, 255, 102, -1000,
+	540, 178, 280, 285, 191, -2, -1000, 475, 448, 472,
+	164, -33, 353, -1000, -1000, -1000, 333, -1000, -1000, 480,
+	275, 275, -1000, -1000, 228, 205, 224, 212, 211, 56,
+	-1000, 331, 10, 329, -12, -1000, 400, 307, 600, -1000,
+	400, -1000, -17, -1000, 324, 91, -1000, 600, 104, -1000,
+	416, 252, -1000, -1000, -1000, 328, 448, -1000, 600, 600,
+	-1000, -39, -66, -1000, -1000, -1000, 478, 450, 235, 177,
+	-1000, 209, -1000, 208, -1000, -1000, -1000, -1000, 44, 41,
+	28, -1000, -1000, -1000, -1000, 600, 400, -1000, -100, -1000,
+	400, 600, 406, 280, -1000, -1000, 258, 251, -1000, 202,
+	-1000, -1000, -1000, -1000, 475, 540, 600, 540, -1000, -1000,
+	278, 273, 271, 400, -1000, 400, 495, -1000, 600, 600,
+	-1000, -1000, -1000, 448, 164, 247, 164, 322, 322, 322,
+	328, 400, -1000, 418, -18, -1000, -25, -27, 231, -1000,
+	489, 429, -1000, 322, -1000, -1000, -1000, 322, -1000, 322,
 	-1000,
 }
-var yyPgo = []int{
-
-	0, 490, 487, 19, 486, 481, 479, 477, 476, 475,
-	474, 473, 472, 471, 391, 470, 469, 468, 14, 23,
-	467, 466, 464, 463, 11, 462, 461, 173, 460, 3,
-	17, 5, 458, 457, 456, 18, 2, 15, 9, 454,
-	10, 453, 27, 452, 4, 451, 450, 13, 442, 441,
-	440, 439, 7, 438, 6, 436, 1, 435, 433, 432,
-	12, 8, 16, 234, 431, 430, 427, 426, 425, 424,
-	0, 26, 416,
+
+var yyPgo = [...]int16{
+	0, 610, 607, 23, 522, 520, 516, 606, 604, 603,
+	602, 601, 599, 598, 597, 596, 595, 182, 592, 591,
+	588, 487, 587, 584, 583, 14, 26, 582, 581, 580,
+	574, 11, 573, 572, 124, 571, 3, 17, 5, 569,
+	567, 563, 18, 2, 16, 9, 562, 10, 561, 25,
+	554, 8, 553, 552, 13, 551, 550, 547, 546, 7,
+	541, 6, 539, 1, 537, 536, 535, 12, 4, 20,
+	98, 45, 534, 533, 532, 531, 530, 529, 528, 527,
+	237, 526, 524, 518, 0, 15, 514,
 }
-var yyR1 = []int{
 
+var yyR1 = [...]int8{
 	0, 1, 2, 2, 2, 2, 2, 2, 2, 2,
-	2, 2, 2, 3, 3, 4, 4, 5, 6, 7,
-	8, 8, 8, 9, 9, 9, 10, 11, 11, 11,
-	12, 13, 13, 13, 72, 14, 15, 15, 16, 16,
-	16, 16, 16, 17, 17, 18, 18, 19, 19, 19,
-	22, 22, 20, 20, 20, 23, 23, 24, 24, 24,
-	24, 21, 21, 21, 25, 25, 25, 25, 25, 25,
-	25, 25, 25, 26, 26, 26, 27, 27, 28, 28,
-	28, 28, 29, 29, 30, 30, 31, 31, 31, 31,
-	31, 32, 32, 32, 32, 32, 32, 32, 32, 32,
-	32, 32, 33, 33, 33, 33, 33, 33, 33, 37,
-	37, 37, 42, 38, 38, 36, 36, 36, 36, 36,
-	36, 36, 36, 36, 36, 36, 36, 36, 36, 36,
-	36, 36, 41, 41, 43, 43, 43, 45, 48, 48,
-	46, 46, 47, 49, 49, 44, 44, 35, 35, 35,
-	35, 50, 50, 51, 51, 52, 52, 53, 53, 54,
-	55, 55, 55, 56, 56, 56, 57, 57, 57, 58,
-	58, 59, 59, 60, 60, 34, 34, 39, 39, 40,
-	40, 61, 61, 62, 63, 63, 64, 64, 65, 65,
-	66, 66, 66, 66, 66, 67, 67, 68, 68, 69,
-	69, 70, 71,
+	2, 2, 2, 2, 2, 2, 2, 2, 2, 3,
+	3, 3, 4, 4, 5, 6, 7, 7, 8, 8,
+	8, 8, 9, 9, 9, 10, 11, 11, 11, 11,
+	12, 13, 13, 13, 13, 14, 14, 14, 14, 15,
+	15, 15, 16, 16, 17, 17, 17, 17, 18, 18,
+	18, 18, 19, 20, 20, 86, 21, 22, 22, 23,
+	23, 23, 23, 23, 24, 24, 25, 25, 26, 26,
+	26, 29, 29, 27, 27, 27, 30, 30, 31, 31,
+	31, 31, 28, 28, 28, 32, 32, 32, 32, 32,
+	32, 32, 32, 32, 33, 33, 33, 34, 34, 35,
+	35, 35, 35, 36, 36, 37, 37, 38, 38, 38,
+	38, 38, 39, 39, 39, 39, 39, 39, 39, 39,
+	39, 39, 39, 40, 40, 40, 40, 40, 40, 40,
+	44, 44, 44, 49, 45, 45, 43, 43, 43, 43,
+	43, 43, 43, 43, 43, 43, 43, 43, 43, 43,
+	43, 43, 43, 48, 48, 50, 50, 50, 52, 55,
+	55, 53, 53, 54, 56, 56, 51, 51, 42, 42,
+	42, 42, 57, 57, 58, 58, 59, 59, 60, 60,
+	61, 62, 62, 62, 63, 63, 63, 64, 64, 64,
+	65, 65, 66, 66, 67, 67, 41, 41, 46, 46,
+	47, 47, 68, 68, 69, 70, 70, 71, 71, 77,
+	77, 78, 78, 79, 79, 81, 81, 81, 81, 80,
+	80, 80, 80, 82, 82, 82, 83, 83, 72, 72,
+	73, 73, 73, 73, 73, 74, 74, 75, 75, 76,
+	76, 84, 85,
 }
-var yyR2 = []int{
-
-	0, 1, 1, 1, 1, 1, 1, 1, 1, 1,
-	1, 1, 1, 12, 3, 7, 7, 8, 7, 3,
-	5, 8, 4, 6, 7, 4, 5, 4, 5, 5,
-	3, 2, 2, 2, 0, 2, 0, 2, 1, 2,
-	1, 1, 1, 0, 1, 1, 3, 1, 2, 3,
-	1, 1, 0, 1, 2, 1, 3, 3, 3, 3,
-	5, 0, 1, 2, 1, 1, 2, 3, 2, 3,
-	2, 2, 2, 1, 3, 1, 1, 3, 0, 5,
-	5, 5, 1, 3, 0, 2, 1, 3, 3, 2,
-	3, 3, 3, 4, 3, 4, 5, 6, 3, 4,
-	2, 6, 1, 1, 1, 1, 1, 1, 1, 3,
-	1, 1, 3, 1, 3, 1, 1, 1, 3, 3,
-	3, 3, 3, 3, 3, 3, 2, 3, 4, 5,
-	4, 1, 1, 1, 1, 1, 1, 5, 0, 1,
-	1, 2, 4, 0, 2, 1, 3, 1, 1, 1,
-	1, 0, 3, 0, 2, 0, 3, 1, 3, 2,
-	0, 1, 1, 0, 2, 4, 0, 2, 4, 0,
-	3, 1, 3, 0, 5, 2, 1, 1, 3, 3,
-	1, 1, 3, 3, 0, 2, 0, 3, 0, 1,
+
+var yyR2 = [...]int8{
+	0, 2, 1, 1, 1, 1, 1, 1, 1, 1,
+	1, 1, 1, 1, 1, 1, 1, 1, 1, 12,
+	4, 3, 7, 7, 8, 7, 3, 7, 5, 8,
+	4, 6, 6, 7, 4, 5, 4, 5, 5, 6,
+	3, 3, 4, 3, 3, 2, 4, 5, 3, 2,
+	4, 4, 2, 3, 1, 1, 1, 1, 2, 2,
+	3, 3, 2, 2, 3, 0, 2, 0, 2, 1,
+	2, 1, 1, 1, 0, 1, 1, 3, 1, 2,
+	3, 1, 1, 0, 1, 2, 1, 3, 3, 3,
+	3, 5, 0, 1, 2, 1, 1, 2, 3, 2,
+	3, 2, 2, 2, 1, 3, 1, 1, 3, 0,
+	5, 5, 5, 1, 3, 0, 2, 1, 3, 3,
+	2, 3, 3, 3, 4, 3, 4, 5, 6, 3,
+	4, 2, 6, 1, 1, 1, 1, 1, 1, 1,
+	3, 1, 1, 3, 1, 3, 1, 1, 1, 3,
+	3, 3, 3, 3, 3, 3, 3, 2, 3, 4,
+	5, 4, 1, 1, 1, 1, 1, 1, 5, 0,
+	1, 1, 2, 4, 0, 2, 1, 3, 1, 1,
+	1, 1, 0, 3, 0, 2, 0, 3, 1, 3,
+	2, 0, 1, 1, 0, 2, 4, 0, 2, 4,
+	0, 3, 1, 3, 0, 5, 2, 1, 1, 3,
+	3, 1, 1, 3, 3, 0, 2, 0, 3, 0,
+	1, 0, 1, 0, 1, 2, 2, 2, 1, 1,
+	1, 1, 1, 1, 1, 1, 0, 2, 0, 1,
 	1, 1, 1, 1, 1, 0, 1, 0, 1, 0,
 	2, 1, 0,
 }
-var yyChk = []int{
 
+var yyChk = [...]int16{
 	-1000, -1, -2, -3, -4, -5, -6, -7, -8, -9,
-	-10, -11, -12, -13, 5, 6, 7, 8, 33, 85,
-	86, 88, 87, 89, 98, 99, 100, -16, 51, 52,
-	53, 54, -14, -72, -14, -14, -14, -14, 90, -68,
-	92, 96, -65, 92, 94, 90, 90, 91, 92, 90,
-	-71, -71, -71, -3, 17, -17, 18, -15, 29, -27,
-	36, 9, -61, -62, -44, -70, 36, -64, 95, 91,
-	-70, 36, 90, -70, 36, -63, 95, -70, -63, 36,
-	-18, -19, 75, -22, 36, -31, -36, -32, 69, 46,
-	-35, -44, -40, -43, -70, -41, -45, 20, 35, 37,
-	38, 39, 25, -42, 73, 74, 50, 95, 28, 80,
-	41, -27, 33, 78, -27, 55, 47, 78, 36, 69,
-	-70, -71, 36, -71, 93, 36, 20, 66, -70, 9,
-	55, -20, -70, 19, 78, 68, 67, -33, 21, 69,
-	23, 24, 22, 70, 71, 72, 73, 74, 75, 76,
-	77, 47, 48, 49, 42, 43, 44, 45, -31, -36,
-	-31, -3, -38, -36, -36, 46, 46, 46, -42, 46,
-	46, -48, -36, -58, 33, 46, -61, 36, -30, 10,
-	-62, -36, -70, -71, 20, -69, 97, -66, 88, 86,
-	32, 87, 13, 36, 36, 36, -71, -23, -24, -26,
-	46, 36, -42, -19, -70, 75, -31, -31, -36, -37,
-	46, -42, 40, 21, 23, 24, -36, -36, 25, 69,
-	-36, -36, -36, -36, -36, -36, -36, -36, 101, 101,
-	55, 101, -36, 101, -18, 18, -18, -35, -46, -47,
-	81, -34, 28, -3, -61, -59, -44, -30, -52, 13,
-	-31, 66, -70, -71, -67, 93, -30, 55, -25, 56,
-	57, 58, 59, 60, 62, 63, -21, 36, 19, -24,
-	78, -38, -37, -36, -36, 68, 25, -36, 101, -18,
-	101, 55, -49, -47, 83, -31, -60, 66, -39, -40,
-	-60, 101, 55, -52, -56, 15, 14, 36, 36, -50,
-	11, -24, -24, 56, 61, 56, 61, 56, 56, 56,
-	-28, 64, 94, 65, 36, 101, 36, 101, 68, -36,
-	101, -35, 84, -36, 82, 30, 55, -44, -56, -36,
-	-53, -54, -36, -71, -51, 12, 14, 66, 56, 56,
-	91, 91, 91, -36, 101, -36, 31, -40, 55, 55,
-	-55, 26, 27, -52, -31, -38, -31, 46, 46, 46,
-	7, -36, -54, -56, -29, -70, -29, -29, -61, -57,
-	16, 34, 101, 55, 101, 101, 7, 21, -70, -70,
-	-70,
+	-10, -11, -12, -13, -14, -15, -16, -18, -19, -20,
+	5, 6, 7, 8, 33, 85, 86, 88, 87, 89,
+	98, 99, 100, 101, 102, 103, 105, 64, 123, -77,
+	128, -23, 51, 52, 53, 54, -21, -86, -21, -21,
+	-21, -21, 90, -75, 92, 126, 96, -72, 92, 94,
+	90, 90, 91, 92, 126, 90, -80, 119, 117, 118,
+	-82, 36, 85, 91, 90, 120, 121, 122, 36, 125,
+	36, -17, -3, -4, -5, -6, 36, -78, 104, 101,
+	106, 36, 38, 124, -3, 17, -24, 18, -22, 29,
+	-34, 36, 9, -68, -79, -69, 107, -51, -84, 36,
+	-71, 95, 91, -84, 36, 90, 90, -84, 36, -70,
+	95, -84, -70, 90, 36, -85, -80, 118, -83, 9,
+	-85, -17, -85, 93, 36, 110, 38, -25, -26, 75,
+	-29, 36, -38, -43, -39, 69, 46, -42, -51, -47,
+	-50, -84, -48, -52, 20, 35, 37, 38, 39, 25,
+	-49, 73, 74, 50, 95, 28, 80, 41, -34, 33,
+	78, -34, 55, 106, 47, 78, 36, 69, -84, -85,
+	-71, 36, -85, 93, 36, 20, 66, -84, -70, -85,
+	36, 36, -84, 112, 111, 9, 55, -27, -84, 19,
+	78, 68, 67, -40, 21, 69, 23, 24, 22, 70,
+	71, 72, 73, 74, 75, 76, 77, 47, 48, 49,
+	42, 43, 44, 45, -38, -43, -38, -3, -45, -43,
+	-43, 46, 46, 46, -49, 46, 46, -55, -43, -65,
+	33, 46, -68, 36, -37, 10, -69, 108, -43, -84,
+	-85, 20, -76, 97, 36, -73, 88, 86, 32, 87,
+	13, 36, 36, 36, -85, 36, 36, -30, -31, -33,
+	46, 36, -49, -26, -84, 75, -38, -38, -43, -44,
+	46, -49, 40, 21, 23, 24, -43, -43, 25, 69,
+	-43, -43, -43, -43, -43, -43, -43, -43, 127, 127,
+	55, 127, -43, 127, -25, 18, -25, -42, -53, -54,
+	81, -41, 28, -3, -68, -66, -51, -37, -59, 13,
+	-38, 109, 66, -84, -85, -85, -74, 93, -85, -37,
+	55, -32, 56, 57, 58, 59, 60, 62, 63, -28,
+	36, 19, -31, 78, -45, -44, -43, -43, 68, 25,
+	-43, 127, -25, 127, 55, -56, -54, 83, -38, -67,
+	66, -46, -47, -67, 127, 55, -59, -63, 15, 14,
+	-81, 110, 115, 116, 36, 36, -57, 11, -31, -31,
+	56, 61, 56, 61, 56, 56, 56, -35, 64, 94,
+	65, 36, 127, 36, 127, 68, -43, 127, -42, 84,
+	-43, 82, 30, 55, -51, -63, -43, -60, -61, -43,
+	114, 113, 110, -85, -58, 12, 14, 66, 56, 56,
+	91, 91, 91, -43, 127, -43, 31, -47, 55, 55,
+	-62, 26, 27, -59, -38, -45, -38, 46, 46, 46,
+	7, -43, -61, -63, -36, -84, -36, -36, -68, -64,
+	16, 34, 127, 55, 127, 127, 7, 21, -84, -84,
+	-84,
 }
-var yyDef = []int{
-
-	0, -2, 1, 2, 3, 4, 5, 6, 7, 8,
-	9, 10, 11, 12, 34, 34, 34, 34, 34, 197,
-	188, 0, 0, 0, 202, 202, 202, 0, 38, 40,
-	41, 42, 43, 36, 0, 0, 0, 0, 186, 0,
-	0, 198, 0, 0, 189, 0, 184, 0, 184, 0,
-	31, 32, 33, 14, 39, 0, 44, 35, 0, 0,
-	76, 0, 19, 181, 0, 145, 201, 0, 0, 0,
-	202, 201, 0, 202, 0, 0, 0, 0, 0, 30,
-	0, 45, 47, 52, 201, 50, 51, 86, 0, 0,
-	115, 116, 117, 0, 145, 0, 131, 0, 0, 147,
-	148, 149, 150, 180, 134, 135, 136, 132, 133, 138,
-	37, 169, 0, 0, 84, 0, 0, 0, 202, 0,
-	199, 22, 0, 25, 0, 27, 185, 0, 202, 0,
-	0, 48, 53, 0, 0, 0, 0, 0, 0, 0,
+
+var yyDef = [...]int16{
+	0, -2, 219, 2, 3, 4, 5, 6, 7, 8,
+	9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+	65, 65, 65, 65, 65, 247, 238, 0, 0, 0,
+	0, 0, 0, 0, 221, 0, 0, 0, 0, 1,
+	220, 0, 69, 71, 72, 73, 74, 67, 0, 0,
+	0, 223, 217, 0, 0, 0, 248, 0, 0, 239,
+	0, 215, 0, 215, 0, 0, 252, 0, 58, 59,
+	236, 229, 230, 231, 232, 233, 234, 235, 252, 0,
+	252, 52, 54, 55, 56, 57, 45, 0, 222, 0,
+	49, 62, 63, 0, 21, 70, 0, 75, 66, 0,
+	0, 107, 0, 26, 0, 212, 224, 0, 176, 251,
+	0, 0, 0, 252, 251, 217, 0, 252, 0, 0,
+	0, 0, 0, 215, 40, 41, 252, 60, 61, 0,
+	43, 53, 44, 0, 48, 0, 64, 20, 76, 78,
+	83, 251, 81, 82, 117, 0, 0, 146, 147, 148,
+	0, 176, 0, 162, 0, 0, 178, 179, 180, 181,
+	211, 165, 166, 167, 163, 164, 169, 68, 200, 0,
+	0, 115, 0, 0, 0, 0, 252, 0, 249, 30,
+	0, 0, 34, 0, 36, 216, 0, 252, 0, 42,
+	237, -2, 0, 50, 51, 0, 0, 79, 84, 0,
 	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-	0, 102, 103, 104, 105, 106, 107, 108, 89, 0,
-	0, 0, 0, 113, 126, 0, 0, 0, 100, 0,
-	0, 0, 139, 0, 0, 0, 84, 77, 155, 0,
-	182, 183, 146, 20, 187, 0, 0, 202, 195, 190,
-	191, 192, 193, 194, 26, 28, 29, 84, 55, 61,
-	0, 73, 75, 46, 54, 49, 87, 88, 91, 92,
-	0, 110, 111, 0, 0, 0, 94, 0, 98, 0,
-	118, 119, 120, 121, 122, 123, 124, 125, 90, 112,
-	0, 179, 113, 127, 0, 0, 0, 0, 143, 140,
-	0, 173, 0, 176, 173, 0, 171, 155, 163, 0,
-	85, 0, 200, 23, 0, 196, 151, 0, 0, 64,
-	65, 0, 0, 0, 0, 0, 78, 62, 0, 0,
-	0, 0, 93, 95, 0, 0, 99, 114, 128, 0,
-	130, 0, 0, 141, 0, 0, 15, 0, 175, 177,
-	16, 170, 0, 163, 18, 0, 0, 202, 24, 153,
-	0, 56, 59, 66, 0, 68, 0, 70, 71, 72,
-	57, 0, 0, 0, 63, 58, 74, 109, 0, 96,
-	129, 0, 137, 144, 0, 0, 0, 172, 17, 164,
-	156, 157, 160, 21, 155, 0, 0, 0, 67, 69,
-	0, 0, 0, 97, 101, 142, 0, 178, 0, 0,
-	159, 161, 162, 163, 154, 152, 60, 0, 0, 0,
-	0, 165, 158, 166, 0, 82, 0, 0, 174, 13,
-	0, 0, 79, 0, 80, 81, 167, 0, 83, 0,
-	168,
+	0, 0, 0, 0, 0, 0, 0, 133, 134, 135,
+	136, 137, 138, 139, 120, 0, 0, 0, 0, 144,
+	157, 0, 0, 0, 131, 0, 0, 0, 170, 0,
+	0, 0, 115, 108, 186, 0, 213, 0, 214, 177,
+	28, 218, 0, 0, 252, 252, 245, 240, 241, 242,
+	243, 244, 35, 37, 38, 252, 47, 115, 86, 92,
+	0, 104, 106, 77, 85, 80, 118, 119, 122, 123,
+	0, 141, 142, 0, 0, 0, 125, 0, 129, 0,
+	149, 150, 151, 152, 153, 154, 155, 156, 121, 143,
+	0, 210, 144, 158, 0, 0, 0, 0, 174, 171,
+	0, 204, 0, 207, 204, 0, 202, 186, 194, 0,
+	116, 0, 0, 250, 31, 32, 0, 246, 39, 182,
+	0, 0, 95, 96, 0, 0, 0, 0, 0, 109,
+	93, 0, 0, 0, 0, 124, 126, 0, 0, 130,
+	145, 159, 0, 161, 0, 0, 172, 0, 0, 22,
+	0, 206, 208, 23, 201, 0, 194, 25, 0, 0,
+	27, 0, 0, 228, 252, 33, 184, 0, 87, 90,
+	97, 0, 99, 0, 101, 102, 103, 88, 0, 0,
+	0, 94, 89, 105, 140, 0, 127, 160, 0, 168,
+	175, 0, 0, 0, 203, 24, 195, 187, 188, 191,
+	225, 226, 227, 29, 186, 0, 0, 0, 98, 100,
+	0, 0, 0, 128, 132, 173, 0, 209, 0, 0,
+	190, 192, 193, 194, 185, 183, 91, 0, 0, 0,
+	0, 196, 189, 197, 0, 113, 0, 0, 205, 19,
+	0, 0, 110, 0, 111, 112, 198, 0, 114, 0,
+	199,
 }
-var yyTok1 = []int{
 
+var yyTok1 = [...]uint8{
 	1, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 77, 70, 3,
-	46, 101, 75, 73, 55, 74, 78, 76, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	46, 127, 75, 73, 55, 74, 78, 76, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 128,
 	48, 47, 49, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
@@ -536,8 +641,8 @@ var yyTok1 = []int{
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 71, 3, 50,
 }
-var yyTok2 = []int{
 
+var yyTok2 = [...]int8{
 	2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
 	12, 13, 14, 15, 16, 17, 18, 19, 20, 21,
 	22, 23, 24, 25, 26, 27, 28, 29, 30, 31,
@@ -546,30 +651,60 @@ var yyTok2 = []int{
 	58, 59, 60, 61, 62, 63, 64, 65, 66, 67,
 	68, 69, 79, 80, 81, 82, 83, 84, 85, 86,
 	87, 88, 89, 90, 91, 92, 93, 94, 95, 96,
-	97, 98, 99, 100,
+	97, 98, 99, 100, 101, 102, 103, 104, 105, 106,
+	107, 108, 109, 110, 111, 112, 113, 114, 115, 116,
+	117, 118, 119, 120, 121, 122, 123, 124, 125, 126,
 }
-var yyTok3 = []int{
+
+var yyTok3 = [...]int8{
 	0,
 }
 
+var yyErrorMessages = [...]struct {
+	state int
+	token int
+	msg   string
+}{}
+
 //line yaccpar:1
 
 /*	parser for yacc output	*/
 
-var yyDebug = 0
+var (
+	yyDebug        = 0
+	yyErrorVerbose = false
+)
 
 type yyLexer interface {
 	Lex(lval *yySymType) int
 	Error(s string)
 }
 
+type yyParser interface {
+	Parse(yyLexer) int
+	Lookahead() int
+}
+
+type yyParserImpl struct {
+	lval  yySymType
+	stack [yyInitialStackSize]yySymType
+	char  int
+}
+
+func (p *yyParserImpl) Lookahead() int {
+	return p.char
+}
+
+func yyNewParser() yyParser {
+	return &yyParserImpl{}
+}
+
 const yyFlag = -1000
 
 func yyTokname(c int) string {
-	// 4 is TOKSTART above
-	if c >= 4 && c-4 < len(yyToknames) {
-		if yyToknames[c-4] != "" {
-			return yyToknames[c-4]
+	if c >= 1 && c-1 < len(yyToknames) {
+		if yyToknames[c-1] != "" {
+			return yyToknames[c-1]
 		}
 	}
 	return __yyfmt__.Sprintf("tok-%v", c)
@@ -584,51 +719,127 @@ func yyStatname(s int) string {
 	return __yyfmt__.Sprintf("state-%v", s)
 }
 
-func yylex1(lex yyLexer, lval *yySymType) int {
-	c := 0
-	char := lex.Lex(lval)
+func yyErrorMessage(state, lookAhead int) string {
+	const TOKSTART = 4
+
+	if !yyErrorVerbose {
+		return "syntax error"
+	}
+
+	for _, e := range yyErrorMessages {
+		if e.state == state && e.token == lookAhead {
+			return "syntax error: " + e.msg
+		}
+	}
+
+	res := "syntax error: unexpected " + yyTokname(lookAhead)
+
+	// To match Bison, suggest at most four expected tokens.
+	expected := make([]int, 0, 4)
+
+	// Look for shiftable tokens.
+	base := int(yyPact[state])
+	for tok := TOKSTART; tok-1 < len(yyToknames); tok++ {
+		if n := base + tok; n >= 0 && n < yyLast && int(yyChk[int(yyAct[n])]) == tok {
+			if len(expected) == cap(expected) {
+				return res
+			}
+			expected = append(expected, tok)
+		}
+	}
+
+	if yyDef[state] == -2 {
+		i := 0
+		for yyExca[i] != -1 || int(yyExca[i+1]) != state {
+			i += 2
+		}
+
+		// Look for tokens that we accept or reduce.
+		for i += 2; yyExca[i] >= 0; i += 2 {
+			tok := int(yyExca[i])
+			if tok < TOKSTART || yyExca[i+1] == 0 {
+				continue
+			}
+			if len(expected) == cap(expected) {
+				return res
+			}
+			expected = append(expected, tok)
+		}
+
+		// If the default action is to accept or reduce, give up.
+		if yyExca[i+1] != 0 {
+			return res
+		}
+	}
+
+	for i, tok := range expected {
+		if i == 0 {
+			res += ", expecting "
+		} else {
+			res += " or "
+		}
+		res += yyTokname(tok)
+	}
+	return res
+}
+
+func yylex1(lex yyLexer, lval *yySymType) (char, token int) {
+	token = 0
+	char = lex.Lex(lval)
 	if char <= 0 {
-		c = yyTok1[0]
+		token = int(yyTok1[0])
 		goto out
 	}
 	if char < len(yyTok1) {
-		c = yyTok1[char]
+		token = int(yyTok1[char])
 		goto out
 	}
 	if char >= yyPrivate {
 		if char < yyPrivate+len(yyTok2) {
-			c = yyTok2[char-yyPrivate]
+			token = int(yyTok2[char-yyPrivate])
 			goto out
 		}
 	}
 	for i := 0; i < len(yyTok3); i += 2 {
-		c = yyTok3[i+0]
-		if c == char {
-			c = yyTok3[i+1]
+		token = int(yyTok3[i+0])
+		if token == char {
+			token = int(yyTok3[i+1])
 			goto out
 		}
 	}
 
 out:
-	if c == 0 {
-		c = yyTok2[1] /* unknown char */
+	if token == 0 {
+		token = int(yyTok2[1]) /* unknown char */
 	}
 	if yyDebug >= 3 {
-		__yyfmt__.Printf("lex %s(%d)\n", yyTokname(c), uint(char))
+		__yyfmt__.Printf("lex %s(%d)\n", yyTokname(token), uint(char))
 	}
-	return c
+	return char, token
 }
 
 func yyParse(yylex yyLexer) int {
+	return yyNewParser().Parse(yylex)
+}
+
+func (yyrcvr *yyParserImpl) Parse(yylex yyLexer) int {
 	var yyn int
-	var yylval yySymType
 	var yyVAL yySymType
-	yyS := make([]yySymType, yyMaxDepth)
+	var yyDollar []yySymType
+	_ = yyDollar // silence set and not used
+	yyS := yyrcvr.stack[:]
 
 	Nerrs := 0   /* number of errors */
 	Errflag := 0 /* error recovery flag */
 	yystate := 0
-	yychar := -1
+	yyrcvr.char = -1
+	yytoken := -1 // yyrcvr.char translated into internal numbering
+	defer func() {
+		// Make sure we report no lookahead when not parsing.
+		yystate = -1
+		yyrcvr.char = -1
+		yytoken = -1
+	}()
 	yyp := -1
 	goto yystack
 
@@ -641,7 +852,7 @@ ret1:
 yystack:
 	/* put a state and value onto the stack */
 	if yyDebug >= 4 {
-		__yyfmt__.Printf("char %v in %v\n", yyTokname(yychar), yyStatname(yystate))
+		__yyfmt__.Printf("char %v in %v\n", yyTokname(yytoken), yyStatname(yystate))
 	}
 
 	yyp++
@@ -654,21 +865,22 @@ yystack:
 	yyS[yyp].yys = yystate
 
 yynewstate:
-	yyn = yyPact[yystate]
+	yyn = int(yyPact[yystate])
 	if yyn <= yyFlag {
 		goto yydefault /* simple state */
 	}
-	if yychar < 0 {
-		yychar = yylex1(yylex, &yylval)
+	if yyrcvr.char < 0 {
+		yyrcvr.char, yytoken = yylex1(yylex, &yyrcvr.lval)
 	}
-	yyn += yychar
+	yyn += yytoken
 	if yyn < 0 || yyn >= yyLast {
 		goto yydefault
 	}
-	yyn = yyAct[yyn]
-	if yyChk[yyn] == yychar { /* valid shift */
-		yychar = -1
-		yyVAL = yylval
+	yyn = int(yyAct[yyn])
+	if int(yyChk[yyn]) == yytoken { /* valid shift */
+		yyrcvr.char = -1
+		yytoken = -1
+		yyVAL = yyrcvr.lval
 		yystate = yyn
 		if Errflag > 0 {
 			Errflag--
@@ -678,27 +890,27 @@ yynewstate:
 
 yydefault:
 	/* default state action */
-	yyn = yyDef[yystate]
+	yyn = int(yyDef[yystate])
 	if yyn == -2 {
-		if yychar < 0 {
-			yychar = yylex1(yylex, &yylval)
+		if yyrcvr.char < 0 {
+			yyrcvr.char, yytoken = yylex1(yylex, &yyrcvr.lval)
 		}
 
 		/* look through exception table */
 		xi := 0
 		for {
-			if yyExca[xi+0] == -1 && yyExca[xi+1] == yystate {
+			if yyExca[xi+0] == -1 && int(yyExca[xi+1]) == yystate {
 				break
 			}
 			xi += 2
 		}
 		for xi += 2; ; xi += 2 {
-			yyn = yyExca[xi+0]
-			if yyn < 0 || yyn == yychar {
+			yyn = int(yyExca[xi+0])
+			if yyn < 0 || yyn == yytoken {
 				break
 			}
 		}
-		yyn = yyExca[xi+1]
+		yyn = int(yyExca[xi+1])
 		if yyn < 0 {
 			goto ret0
 		}
@@ -707,11 +919,11 @@ yydefault:
 		/* error ... attempt to resume parsing */
 		switch Errflag {
 		case 0: /* brand new error */
-			yylex.Error("syntax error")
+			yylex.Error(yyErrorMessage(yystate, yytoken))
 			Nerrs++
 			if yyDebug >= 1 {
 				__yyfmt__.Printf("%s", yyStatname(yystate))
-				__yyfmt__.Printf(" saw %s\n", yyTokname(yychar))
+				__yyfmt__.Printf(" saw %s\n", yyTokname(yytoken))
 			}
 			fallthrough
 
@@ -720,10 +932,10 @@ yydefault:
 
 			/* find a state where "error" is a legal shift action */
 			for yyp >= 0 {
-				yyn = yyPact[yyS[yyp].yys] + yyErrCode
+				yyn = int(yyPact[yyS[yyp].yys]) + yyErrCode
 				if yyn >= 0 && yyn < yyLast {
-					yystate = yyAct[yyn] /* simulate a shift of "error" */
-					if yyChk[yystate] == yyErrCode {
+					yystate = int(yyAct[yyn]) /* simulate a shift of "error" */
+					if int(yyChk[yystate]) == yyErrCode {
 						goto yystack
 					}
 				}
@@ -739,12 +951,13 @@ yydefault:
 
 		case 3: /* no shift yet; clobber input char */
 			if yyDebug >= 2 {
-				__yyfmt__.Printf("error recovery discards %s\n", yyTokname(yychar))
+				__yyfmt__.Printf("error recovery discards %s\n", yyTokname(yytoken))
 			}
-			if yychar == yyEofCode {
+			if yytoken == yyEofCode {
 				goto ret1
 			}
-			yychar = -1
+			yyrcvr.char = -1
+			yytoken = -1
 			goto yynewstate /* try again in the same state */
 		}
 	}
@@ -758,1028 +971,1465 @@ yydefault:
 	yypt := yyp
 	_ = yypt // guard against "declared and not used"
 
-	yyp -= yyR2[yyn]
+	yyp -= int(yyR2[yyn])
+	// yyp is now the index of $0. Perform the default action. Iff the
+	// reduced production is ε, $1 is possibly out of range.
+	if yyp+1 >= len(yyS) {
+		nyys := make([]yySymType, len(yyS)*2)
+		copy(nyys, yyS)
+		yyS = nyys
+	}
 	yyVAL = yyS[yyp+1]
 
 	/* consult goto table to find next state */
-	yyn = yyR1[yyn]
-	yyg := yyPgo[yyn]
+	yyn = int(yyR1[yyn])
+	yyg := int(yyPgo[yyn])
 	yyj := yyg + yyS[yyp].yys + 1
 
 	if yyj >= yyLast {
-		yystate = yyAct[yyg]
+		yystate = int(yyAct[yyg])
 	} else {
-		yystate = yyAct[yyj]
-		if yyChk[yystate] != -yyn {
-			yystate = yyAct[yyg]
+		yystate = int(yyAct[yyj])
+		if int(yyChk[yystate]) != -yyn {
+			yystate = int(yyAct[yyg])
 		}
 	}
 	// dummy call; replaced with literal code
 	switch yynt {
 
 	case 1:
-		//line sql.y:151
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:167
 		{
-			SetParseTree(yylex, yyS[yypt-0].statement)
+			SetParseTree(yylex, yyDollar[1].statement)
 		}
 	case 2:
-		//line sql.y:157
-		{
-			yyVAL.statement = yyS[yypt-0].selStmt
-		}
-	case 3:
-		yyVAL.statement = yyS[yypt-0].statement
-	case 4:
-		yyVAL.statement = yyS[yypt-0].statement
-	case 5:
-		yyVAL.statement = yyS[yypt-0].statement
-	case 6:
-		yyVAL.statement = yyS[yypt-0].statement
-	case 7:
-		yyVAL.statement = yyS[yypt-0].statement
-	case 8:
-		yyVAL.statement = yyS[yypt-0].statement
-	case 9:
-		yyVAL.statement = yyS[yypt-0].statement
-	case 10:
-		yyVAL.statement = yyS[yypt-0].statement
-	case 11:
-		yyVAL.statement = yyS[yypt-0].statement
-	case 12:
-		yyVAL.statement = yyS[yypt-0].statement
-	case 13:
-		//line sql.y:173
-		{
-			yyVAL.selStmt = &Select{Comments: Comments(yyS[yypt-10].bytes2), Distinct: yyS[yypt-9].str, SelectExprs: yyS[yypt-8].selectExprs, From: yyS[yypt-6].tableExprs, Where: NewWhere(AST_WHERE, yyS[yypt-5].boolExpr), GroupBy: GroupBy(yyS[yypt-4].valExprs), Having: NewWhere(AST_HAVING, yyS[yypt-3].boolExpr), OrderBy: yyS[yypt-2].orderBy, Limit: yyS[yypt-1].limit, Lock: yyS[yypt-0].str}
-		}
-	case 14:
-		//line sql.y:177
-		{
-			yyVAL.selStmt = &Union{Type: yyS[yypt-1].str, Left: yyS[yypt-2].selStmt, Right: yyS[yypt-0].selStmt}
-		}
-	case 15:
-		//line sql.y:183
-		{
-			yyVAL.statement = &Insert{Comments: Comments(yyS[yypt-5].bytes2), Table: yyS[yypt-3].tableName, Columns: yyS[yypt-2].columns, Rows: yyS[yypt-1].insRows, OnDup: OnDup(yyS[yypt-0].updateExprs)}
-		}
-	case 16:
-		//line sql.y:187
-		{
-			cols := make(Columns, 0, len(yyS[yypt-1].updateExprs))
-			vals := make(ValTuple, 0, len(yyS[yypt-1].updateExprs))
-			for _, col := range yyS[yypt-1].updateExprs {
-				cols = append(cols, &NonStarExpr{Expr: col.Name})
-				vals = append(vals, col.Expr)
-			}
-			yyVAL.statement = &Insert{Comments: Comments(yyS[yypt-5].bytes2), Table: yyS[yypt-3].tableName, Columns: cols, Rows: Values{vals}, OnDup: OnDup(yyS[yypt-0].updateExprs)}
-		}
-	case 17:
-		//line sql.y:199
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:173
 		{
-			yyVAL.statement = &Update{Comments: Comments(yyS[yypt-6].bytes2), Table: yyS[yypt-5].tableName, Exprs: yyS[yypt-3].updateExprs, Where: NewWhere(AST_WHERE, yyS[yypt-2].boolExpr), OrderBy: yyS[yypt-1].orderBy, Limit: yyS[yypt-0].limit}
-		}
-	case 18:
-		//line sql.y:205
-		{
-			yyVAL.statement = &Delete{Comments: Comments(yyS[yypt-5].bytes2), Table: yyS[yypt-3].tableName, Where: NewWhere(AST_WHERE, yyS[yypt-2].boolExpr), OrderBy: yyS[yypt-1].orderBy, Limit: yyS[yypt-0].limit}
+			yyVAL.statement = yyDollar[1].selStmt
 		}
 	case 19:
-		//line sql.y:211
+		yyDollar = yyS[yypt-12 : yypt+1]
+//line sql.y:195
 		{
-			yyVAL.statement = &Set{Comments: Comments(yyS[yypt-1].bytes2), Exprs: yyS[yypt-0].updateExprs}
+			yyVAL.selStmt = &Select{Comments: Comments(yyDollar[2].bytes2), Distinct: yyDollar[3].str, SelectExprs: yyDollar[4].selectExprs, From: yyDollar[6].tableExprs, Where: NewWhere(AST_WHERE, yyDollar[7].boolExpr), GroupBy: GroupBy(yyDollar[8].valExprs), Having: NewWhere(AST_HAVING, yyDollar[9].boolExpr), OrderBy: yyDollar[10].orderBy, Limit: yyDollar[11].limit, Lock: yyDollar[12].str}
 		}
 	case 20:
-		//line sql.y:217
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line sql.y:199
 		{
-			yyVAL.statement = &DDL{Action: AST_CREATE, NewName: yyS[yypt-1].bytes}
+			// Like MySQL, a select without tables selects from dual.
+			yyVAL.selStmt = &Select{Comments: Comments(yyDollar[2].bytes2), Distinct: yyDollar[3].str, SelectExprs: yyDollar[4].selectExprs, From: TableExprs{&AliasedTableExpr{Expr: &TableName{Name: DUAL_BYTES}}}}
 		}
 	case 21:
-		//line sql.y:221
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:204
 		{
-			// Change this to an alter statement
-			yyVAL.statement = &DDL{Action: AST_ALTER, Table: yyS[yypt-1].bytes, NewName: yyS[yypt-1].bytes}
+			yyVAL.selStmt = &Union{Type: yyDollar[2].str, Left: yyDollar[1].selStmt, Right: yyDollar[3].selStmt}
 		}
 	case 22:
-		//line sql.y:226
+		yyDollar = yyS[yypt-7 : yypt+1]
+//line sql.y:210
 		{
-			yyVAL.statement = &DDL{Action: AST_CREATE, NewName: yyS[yypt-1].bytes}
+			yyVAL.statement = &Insert{Comments: Comments(yyDollar[2].bytes2), Table: yyDollar[4].tableName, Columns: yyDollar[5].columns, Rows: yyDollar[6].insRows, OnDup: OnDup(yyDollar[7].updateExprs)}
 		}
 	case 23:
-		//line sql.y:232
+		yyDollar = yyS[yypt-7 : yypt+1]
+//line sql.y:214
 		{
-			yyVAL.statement = &DDL{Action: AST_ALTER, Table: yyS[yypt-2].bytes, NewName: yyS[yypt-2].bytes}
+			cols := make(Columns, 0, len(yyDollar[6].updateExprs))
+			vals := make(ValTuple, 0, len(yyDollar[6].updateExprs))
+			for _, col := range yyDollar[6].updateExprs {
+				cols = append(cols, &NonStarExpr{Expr: col.Name})
+				vals = append(vals, col.Expr)
+			}
+			yyVAL.statement = &Insert{Comments: Comments(yyDollar[2].bytes2), Table: yyDollar[4].tableName, Columns: cols, Rows: Values{vals}, OnDup: OnDup(yyDollar[7].updateExprs)}
 		}
 	case 24:
-		//line sql.y:236
+		yyDollar = yyS[yypt-8 : yypt+1]
+//line sql.y:226
 		{
-			// Change this to a rename statement
-			yyVAL.statement = &DDL{Action: AST_RENAME, Table: yyS[yypt-3].bytes, NewName: yyS[yypt-0].bytes}
+			yyVAL.statement = &Update{Comments: Comments(yyDollar[2].bytes2), Table: yyDollar[3].tableName, Exprs: yyDollar[5].updateExprs, Where: NewWhere(AST_WHERE, yyDollar[6].boolExpr), OrderBy: yyDollar[7].orderBy, Limit: yyDollar[8].limit}
 		}
 	case 25:
-		//line sql.y:241
+		yyDollar = yyS[yypt-7 : yypt+1]
+//line sql.y:232
 		{
-			yyVAL.statement = &DDL{Action: AST_ALTER, Table: yyS[yypt-1].bytes, NewName: yyS[yypt-1].bytes}
+			yyVAL.statement = &Delete{Comments: Comments(yyDollar[2].bytes2), Table: yyDollar[4].tableName, Where: NewWhere(AST_WHERE, yyDollar[5].boolExpr), OrderBy: yyDollar[6].orderBy, Limit: yyDollar[7].limit}
 		}
 	case 26:
-		//line sql.y:247
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:238
 		{
-			yyVAL.statement = &DDL{Action: AST_RENAME, Table: yyS[yypt-2].bytes, NewName: yyS[yypt-0].bytes}
+			yyVAL.statement = &Set{Comments: Comments(yyDollar[2].bytes2), Exprs: yyDollar[3].updateExprs}
 		}
 	case 27:
-		//line sql.y:253
+		yyDollar = yyS[yypt-7 : yypt+1]
+//line sql.y:242
 		{
-			yyVAL.statement = &DDL{Action: AST_DROP, Table: yyS[yypt-0].bytes}
+			yyVAL.statement = &SetTransaction{Comments: Comments(yyDollar[2].bytes2), Isolation: yyDollar[7].str}
 		}
 	case 28:
-		//line sql.y:257
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line sql.y:248
 		{
-			// Change this to an alter statement
-			yyVAL.statement = &DDL{Action: AST_ALTER, Table: yyS[yypt-0].bytes, NewName: yyS[yypt-0].bytes}
+			yyVAL.statement = &DDL{Action: AST_CREATE, NewName: yyDollar[4].bytes}
 		}
 	case 29:
-		//line sql.y:262
+		yyDollar = yyS[yypt-8 : yypt+1]
+//line sql.y:252
 		{
-			yyVAL.statement = &DDL{Action: AST_DROP, Table: yyS[yypt-1].bytes}
+			// Change this to an alter statement
+			yyVAL.statement = &DDL{Action: AST_ALTER, Table: yyDollar[7].bytes, NewName: yyDollar[7].bytes}
 		}
 	case 30:
-		//line sql.y:268
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line sql.y:257
 		{
-			yyVAL.statement = &DDL{Action: AST_ALTER, Table: yyS[yypt-0].bytes, NewName: yyS[yypt-0].bytes}
+			yyVAL.statement = &DDL{Action: AST_CREATE, NewName: yyDollar[3].bytes}
 		}
 	case 31:
-		//line sql.y:274
+		yyDollar = yyS[yypt-6 : yypt+1]
+//line sql.y:261
 		{
-			yyVAL.statement = &Other{}
+			yyVAL.statement = &DDL{Action: AST_CREATE, NewName: yyDollar[5].bytes, Temporary: true}
 		}
 	case 32:
-		//line sql.y:278
+		yyDollar = yyS[yypt-6 : yypt+1]
+//line sql.y:267
 		{
-			yyVAL.statement = &Other{}
+			yyVAL.statement = &DDL{Action: AST_ALTER, Table: yyDollar[4].bytes, NewName: yyDollar[4].bytes}
 		}
 	case 33:
-		//line sql.y:282
+		yyDollar = yyS[yypt-7 : yypt+1]
+//line sql.y:271
 		{
-			yyVAL.statement = &Other{}
+			// Change this to a rename statement
+			yyVAL.statement = &DDL{Action: AST_RENAME, Table: yyDollar[4].bytes, NewName: yyDollar[7].bytes}
 		}
 	case 34:
-		//line sql.y:287
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line sql.y:276
 		{
-			SetAllowComments(yylex, true)
+			yyVAL.statement = &DDL{Action: AST_ALTER, Table: yyDollar[3].bytes, NewName: yyDollar[3].bytes}
 		}
 	case 35:
-		//line sql.y:291
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line sql.y:282
 		{
-			yyVAL.bytes2 = yyS[yypt-0].bytes2
-			SetAllowComments(yylex, false)
+			yyVAL.statement = &DDL{Action: AST_RENAME, Table: yyDollar[3].bytes, NewName: yyDollar[5].bytes}
 		}
 	case 36:
-		//line sql.y:297
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line sql.y:288
 		{
-			yyVAL.bytes2 = nil
+			yyVAL.statement = &DDL{Action: AST_DROP, Table: yyDollar[4].bytes}
 		}
 	case 37:
-		//line sql.y:301
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line sql.y:292
 		{
-			yyVAL.bytes2 = append(yyS[yypt-1].bytes2, yyS[yypt-0].bytes)
+			// Change this to an alter statement
+			yyVAL.statement = &DDL{Action: AST_ALTER, Table: yyDollar[5].bytes, NewName: yyDollar[5].bytes}
 		}
 	case 38:
-		//line sql.y:307
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line sql.y:297
 		{
-			yyVAL.str = AST_UNION
+			yyVAL.statement = &DDL{Action: AST_DROP, Table: yyDollar[4].bytes}
 		}
 	case 39:
-		//line sql.y:311
+		yyDollar = yyS[yypt-6 : yypt+1]
+//line sql.y:301
 		{
-			yyVAL.str = AST_UNION_ALL
+			yyVAL.statement = &DDL{Action: AST_DROP, Table: yyDollar[5].bytes, Temporary: true}
 		}
 	case 40:
-		//line sql.y:315
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:307
 		{
-			yyVAL.str = AST_SET_MINUS
+			yyVAL.statement = &DDL{Action: AST_ALTER, Table: yyDollar[3].bytes, NewName: yyDollar[3].bytes}
 		}
 	case 41:
-		//line sql.y:319
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:313
 		{
-			yyVAL.str = AST_EXCEPT
+			yyVAL.statement = &Other{}
 		}
 	case 42:
-		//line sql.y:323
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line sql.y:317
 		{
-			yyVAL.str = AST_INTERSECT
+			yyVAL.statement = &Other{}
 		}
 	case 43:
-		//line sql.y:328
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:321
 		{
-			yyVAL.str = ""
+			yyVAL.statement = &Other{}
 		}
 	case 44:
-		//line sql.y:332
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:325
 		{
-			yyVAL.str = AST_DISTINCT
+			yyVAL.statement = &Other{}
 		}
 	case 45:
-		//line sql.y:338
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:331
 		{
-			yyVAL.selectExprs = SelectExprs{yyS[yypt-0].selectExpr}
+			yyVAL.statement = &Savepoint{Action: SavepointCreate, Name: yyDollar[2].bytes}
 		}
 	case 46:
-		//line sql.y:342
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line sql.y:335
 		{
-			yyVAL.selectExprs = append(yyVAL.selectExprs, yyS[yypt-0].selectExpr)
+			yyVAL.statement = &Savepoint{Action: SavepointRollback, Name: yyDollar[4].bytes}
 		}
 	case 47:
-		//line sql.y:348
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line sql.y:339
 		{
-			yyVAL.selectExpr = &StarExpr{}
+			if !bytes.Equal(yyDollar[4].bytes, SAVEPOINT_BYTES) {
+				yylex.Error("expecting savepoint")
+				return 1
+			}
+			yyVAL.statement = &Savepoint{Action: SavepointRollback, Name: yyDollar[5].bytes}
 		}
 	case 48:
-		//line sql.y:352
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:347
 		{
-			yyVAL.selectExpr = &NonStarExpr{Expr: yyS[yypt-1].expr, As: yyS[yypt-0].bytes}
+			yyVAL.statement = &Savepoint{Action: SavepointRelease, Name: yyDollar[3].bytes}
 		}
 	case 49:
-		//line sql.y:356
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:353
 		{
-			yyVAL.selectExpr = &StarExpr{TableName: yyS[yypt-2].bytes}
+			yyVAL.statement = &StartTransaction{}
 		}
 	case 50:
-		//line sql.y:362
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line sql.y:357
 		{
-			yyVAL.expr = yyS[yypt-0].boolExpr
+			yyVAL.statement = &StartTransaction{ReadOnly: true}
 		}
 	case 51:
-		//line sql.y:366
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line sql.y:361
 		{
-			yyVAL.expr = yyS[yypt-0].valExpr
+			yyVAL.statement = &StartTransaction{}
 		}
 	case 52:
-		//line sql.y:371
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:367
 		{
-			yyVAL.bytes = nil
+			yyVAL.statement = &Explain{Statement: yyDollar[2].statement}
 		}
 	case 53:
-		//line sql.y:375
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:371
 		{
-			yyVAL.bytes = yyS[yypt-0].bytes
+			yyVAL.statement = &Explain{Statement: yyDollar[3].statement}
 		}
 	case 54:
-		//line sql.y:379
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:377
 		{
-			yyVAL.bytes = yyS[yypt-0].bytes
-		}
-	case 55:
-		//line sql.y:385
-		{
-			yyVAL.tableExprs = TableExprs{yyS[yypt-0].tableExpr}
-		}
-	case 56:
-		//line sql.y:389
-		{
-			yyVAL.tableExprs = append(yyVAL.tableExprs, yyS[yypt-0].tableExpr)
-		}
-	case 57:
-		//line sql.y:395
-		{
-			yyVAL.tableExpr = &AliasedTableExpr{Expr: yyS[yypt-2].smTableExpr, As: yyS[yypt-1].bytes, Hints: yyS[yypt-0].indexHints}
+			yyVAL.statement = yyDollar[1].selStmt
 		}
 	case 58:
-		//line sql.y:399
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:386
 		{
-			yyVAL.tableExpr = &ParenTableExpr{Expr: yyS[yypt-1].tableExpr}
+			yyVAL.statement = &Show{Type: ShowWarnings}
 		}
 	case 59:
-		//line sql.y:403
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:390
 		{
-			yyVAL.tableExpr = &JoinTableExpr{LeftExpr: yyS[yypt-2].tableExpr, Join: yyS[yypt-1].str, RightExpr: yyS[yypt-0].tableExpr}
+			yyVAL.statement = &Show{Type: ShowProcesslist}
 		}
 	case 60:
-		//line sql.y:407
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:394
 		{
-			yyVAL.tableExpr = &JoinTableExpr{LeftExpr: yyS[yypt-4].tableExpr, Join: yyS[yypt-3].str, RightExpr: yyS[yypt-2].tableExpr, On: yyS[yypt-0].boolExpr}
+			yyVAL.statement = &Show{Type: ShowProcesslist, Full: true}
 		}
 	case 61:
-		//line sql.y:412
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:398
 		{
-			yyVAL.bytes = nil
+			yyVAL.statement = &Show{Type: yyDollar[2].str, Keyspace: yyDollar[3].bytes}
 		}
 	case 62:
-		//line sql.y:416
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:404
 		{
-			yyVAL.bytes = yyS[yypt-0].bytes
+			yyVAL.statement = &Use{DBName: yyDollar[2].bytes}
 		}
 	case 63:
-		//line sql.y:420
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:410
 		{
-			yyVAL.bytes = yyS[yypt-0].bytes
+			id, err := strconv.ParseInt(string(yyDollar[2].bytes), 10, 64)
+			if err != nil {
+				yylex.Error("invalid query id")
+				return 1
+			}
+			yyVAL.statement = &Kill{ID: id}
 		}
 	case 64:
-		//line sql.y:426
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:419
 		{
-			yyVAL.str = AST_JOIN
+			id, err := strconv.ParseInt(string(yyDollar[3].bytes), 10, 64)
+			if err != nil {
+				yylex.Error("invalid query id")
+				return 1
+			}
+			yyVAL.statement = &Kill{ID: id}
 		}
 	case 65:
-		//line sql.y:430
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:429
 		{
-			yyVAL.str = AST_STRAIGHT_JOIN
+			SetAllowComments(yylex, true)
 		}
 	case 66:
-		//line sql.y:434
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:433
 		{
-			yyVAL.str = AST_LEFT_JOIN
+			yyVAL.bytes2 = yyDollar[2].bytes2
+			SetAllowComments(yylex, false)
 		}
 	case 67:
-		//line sql.y:438
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:439
 		{
-			yyVAL.str = AST_LEFT_JOIN
+			yyVAL.bytes2 = nil
 		}
 	case 68:
-		//line sql.y:442
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:443
 		{
-			yyVAL.str = AST_RIGHT_JOIN
+			yyVAL.bytes2 = append(yyDollar[1].bytes2, yyDollar[2].bytes)
 		}
 	case 69:
-		//line sql.y:446
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:449
 		{
-			yyVAL.str = AST_RIGHT_JOIN
+			yyVAL.str = AST_UNION
 		}
 	case 70:
-		//line sql.y:450
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:453
 		{
-			yyVAL.str = AST_JOIN
+			yyVAL.str = AST_UNION_ALL
 		}
 	case 71:
-		//line sql.y:454
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:457
 		{
-			yyVAL.str = AST_CROSS_JOIN
+			yyVAL.str = AST_SET_MINUS
 		}
 	case 72:
-		//line sql.y:458
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:461
 		{
-			yyVAL.str = AST_NATURAL_JOIN
+			yyVAL.str = AST_EXCEPT
 		}
 	case 73:
-		//line sql.y:464
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:465
 		{
-			yyVAL.smTableExpr = &TableName{Name: yyS[yypt-0].bytes}
+			yyVAL.str = AST_INTERSECT
 		}
 	case 74:
-		//line sql.y:468
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:470
 		{
-			yyVAL.smTableExpr = &TableName{Qualifier: yyS[yypt-2].bytes, Name: yyS[yypt-0].bytes}
+			yyVAL.str = ""
 		}
 	case 75:
-		//line sql.y:472
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:474
 		{
-			yyVAL.smTableExpr = yyS[yypt-0].subquery
+			yyVAL.str = AST_DISTINCT
 		}
 	case 76:
-		//line sql.y:478
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:480
 		{
-			yyVAL.tableName = &TableName{Name: yyS[yypt-0].bytes}
+			yyVAL.selectExprs = SelectExprs{yyDollar[1].selectExpr}
 		}
 	case 77:
-		//line sql.y:482
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:484
 		{
-			yyVAL.tableName = &TableName{Qualifier: yyS[yypt-2].bytes, Name: yyS[yypt-0].bytes}
+			yyVAL.selectExprs = append(yyVAL.selectExprs, yyDollar[3].selectExpr)
 		}
 	case 78:
-		//line sql.y:487
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:490
 		{
-			yyVAL.indexHints = nil
+			yyVAL.selectExpr = &StarExpr{}
 		}
 	case 79:
-		//line sql.y:491
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:494
 		{
-			yyVAL.indexHints = &IndexHints{Type: AST_USE, Indexes: yyS[yypt-1].bytes2}
+			yyVAL.selectExpr = &NonStarExpr{Expr: yyDollar[1].expr, As: yyDollar[2].bytes}
 		}
 	case 80:
-		//line sql.y:495
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:498
 		{
-			yyVAL.indexHints = &IndexHints{Type: AST_IGNORE, Indexes: yyS[yypt-1].bytes2}
+			yyVAL.selectExpr = &StarExpr{TableName: yyDollar[1].bytes}
 		}
 	case 81:
-		//line sql.y:499
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:504
 		{
-			yyVAL.indexHints = &IndexHints{Type: AST_FORCE, Indexes: yyS[yypt-1].bytes2}
+			yyVAL.expr = yyDollar[1].boolExpr
 		}
 	case 82:
-		//line sql.y:505
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:508
 		{
-			yyVAL.bytes2 = [][]byte{yyS[yypt-0].bytes}
+			yyVAL.expr = yyDollar[1].valExpr
 		}
 	case 83:
-		//line sql.y:509
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:513
 		{
-			yyVAL.bytes2 = append(yyS[yypt-2].bytes2, yyS[yypt-0].bytes)
+			yyVAL.bytes = nil
 		}
 	case 84:
-		//line sql.y:514
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:517
 		{
-			yyVAL.boolExpr = nil
+			yyVAL.bytes = yyDollar[1].bytes
 		}
 	case 85:
-		//line sql.y:518
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:521
 		{
-			yyVAL.boolExpr = yyS[yypt-0].boolExpr
+			yyVAL.bytes = yyDollar[2].bytes
 		}
 	case 86:
-		yyVAL.boolExpr = yyS[yypt-0].boolExpr
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:527
+		{
+			yyVAL.tableExprs = TableExprs{yyDollar[1].tableExpr}
+		}
 	case 87:
-		//line sql.y:525
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:531
 		{
-			yyVAL.boolExpr = &AndExpr{Left: yyS[yypt-2].boolExpr, Right: yyS[yypt-0].boolExpr}
+			yyVAL.tableExprs = append(yyVAL.tableExprs, yyDollar[3].tableExpr)
 		}
 	case 88:
-		//line sql.y:529
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:537
 		{
-			yyVAL.boolExpr = &OrExpr{Left: yyS[yypt-2].boolExpr, Right: yyS[yypt-0].boolExpr}
+			yyVAL.tableExpr = &AliasedTableExpr{Expr: yyDollar[1].smTableExpr, As: yyDollar[2].bytes, Hints: yyDollar[3].indexHints}
 		}
 	case 89:
-		//line sql.y:533
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:541
 		{
-			yyVAL.boolExpr = &NotExpr{Expr: yyS[yypt-0].boolExpr}
+			yyVAL.tableExpr = &ParenTableExpr{Expr: yyDollar[2].tableExpr}
 		}
 	case 90:
-		//line sql.y:537
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:545
 		{
-			yyVAL.boolExpr = &ParenBoolExpr{Expr: yyS[yypt-1].boolExpr}
+			yyVAL.tableExpr = &JoinTableExpr{LeftExpr: yyDollar[1].tableExpr, Join: yyDollar[2].str, RightExpr: yyDollar[3].tableExpr}
 		}
 	case 91:
-		//line sql.y:543
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line sql.y:549
 		{
-			yyVAL.boolExpr = &ComparisonExpr{Left: yyS[yypt-2].valExpr, Operator: yyS[yypt-1].str, Right: yyS[yypt-0].valExpr}
+			yyVAL.tableExpr = &JoinTableExpr{LeftExpr: yyDollar[1].tableExpr, Join: yyDollar[2].str, RightExpr: yyDollar[3].tableExpr, On: yyDollar[5].boolExpr}
 		}
 	case 92:
-		//line sql.y:547
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:554
 		{
-			yyVAL.boolExpr = &ComparisonExpr{Left: yyS[yypt-2].valExpr, Operator: AST_IN, Right: yyS[yypt-0].colTuple}
+			yyVAL.bytes = nil
 		}
 	case 93:
-		//line sql.y:551
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:558
 		{
-			yyVAL.boolExpr = &ComparisonExpr{Left: yyS[yypt-3].valExpr, Operator: AST_NOT_IN, Right: yyS[yypt-0].colTuple}
+			yyVAL.bytes = yyDollar[1].bytes
 		}
 	case 94:
-		//line sql.y:555
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:562
 		{
-			yyVAL.boolExpr = &ComparisonExpr{Left: yyS[yypt-2].valExpr, Operator: AST_LIKE, Right: yyS[yypt-0].valExpr}
+			yyVAL.bytes = yyDollar[2].bytes
 		}
 	case 95:
-		//line sql.y:559
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:568
 		{
-			yyVAL.boolExpr = &ComparisonExpr{Left: yyS[yypt-3].valExpr, Operator: AST_NOT_LIKE, Right: yyS[yypt-0].valExpr}
+			yyVAL.str = AST_JOIN
 		}
 	case 96:
-		//line sql.y:563
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:572
 		{
-			yyVAL.boolExpr = &RangeCond{Left: yyS[yypt-4].valExpr, Operator: AST_BETWEEN, From: yyS[yypt-2].valExpr, To: yyS[yypt-0].valExpr}
+			yyVAL.str = AST_STRAIGHT_JOIN
 		}
 	case 97:
-		//line sql.y:567
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:576
 		{
-			yyVAL.boolExpr = &RangeCond{Left: yyS[yypt-5].valExpr, Operator: AST_NOT_BETWEEN, From: yyS[yypt-2].valExpr, To: yyS[yypt-0].valExpr}
+			yyVAL.str = AST_LEFT_JOIN
 		}
 	case 98:
-		//line sql.y:571
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:580
 		{
-			yyVAL.boolExpr = &NullCheck{Operator: AST_IS_NULL, Expr: yyS[yypt-2].valExpr}
+			yyVAL.str = AST_LEFT_JOIN
 		}
 	case 99:
-		//line sql.y:575
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:584
 		{
-			yyVAL.boolExpr = &NullCheck{Operator: AST_IS_NOT_NULL, Expr: yyS[yypt-3].valExpr}
+			yyVAL.str = AST_RIGHT_JOIN
 		}
 	case 100:
-		//line sql.y:579
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:588
 		{
-			yyVAL.boolExpr = &ExistsExpr{Subquery: yyS[yypt-0].subquery}
+			yyVAL.str = AST_RIGHT_JOIN
 		}
 	case 101:
-		//line sql.y:583
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:592
 		{
-			yyVAL.boolExpr = &KeyrangeExpr{Start: yyS[yypt-3].valExpr, End: yyS[yypt-1].valExpr}
+			yyVAL.str = AST_JOIN
 		}
 	case 102:
-		//line sql.y:589
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:596
 		{
-			yyVAL.str = AST_EQ
+			yyVAL.str = AST_CROSS_JOIN
 		}
 	case 103:
-		//line sql.y:593
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:600
 		{
-			yyVAL.str = AST_LT
+			yyVAL.str = AST_NATURAL_JOIN
 		}
 	case 104:
-		//line sql.y:597
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:606
 		{
-			yyVAL.str = AST_GT
+			yyVAL.smTableExpr = &TableName{Name: yyDollar[1].bytes}
 		}
 	case 105:
-		//line sql.y:601
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:610
 		{
-			yyVAL.str = AST_LE
+			yyVAL.smTableExpr = &TableName{Qualifier: yyDollar[1].bytes, Name: yyDollar[3].bytes}
 		}
 	case 106:
-		//line sql.y:605
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:614
 		{
-			yyVAL.str = AST_GE
+			yyVAL.smTableExpr = yyDollar[1].subquery
 		}
 	case 107:
-		//line sql.y:609
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:620
 		{
-			yyVAL.str = AST_NE
+			yyVAL.tableName = &TableName{Name: yyDollar[1].bytes}
 		}
 	case 108:
-		//line sql.y:613
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:624
 		{
-			yyVAL.str = AST_NSE
+			yyVAL.tableName = &TableName{Qualifier: yyDollar[1].bytes, Name: yyDollar[3].bytes}
 		}
 	case 109:
-		//line sql.y:619
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:629
 		{
-			yyVAL.colTuple = ValTuple(yyS[yypt-1].valExprs)
+			yyVAL.indexHints = nil
 		}
 	case 110:
-		//line sql.y:623
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line sql.y:633
 		{
-			yyVAL.colTuple = yyS[yypt-0].subquery
+			yyVAL.indexHints = &IndexHints{Type: AST_USE, Indexes: yyDollar[4].bytes2}
 		}
 	case 111:
-		//line sql.y:627
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line sql.y:637
 		{
-			yyVAL.colTuple = ListArg(yyS[yypt-0].bytes)
+			yyVAL.indexHints = &IndexHints{Type: AST_IGNORE, Indexes: yyDollar[4].bytes2}
 		}
 	case 112:
-		//line sql.y:633
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line sql.y:641
 		{
-			yyVAL.subquery = &Subquery{yyS[yypt-1].selStmt}
+			yyVAL.indexHints = &IndexHints{Type: AST_FORCE, Indexes: yyDollar[4].bytes2}
 		}
 	case 113:
-		//line sql.y:639
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:647
 		{
-			yyVAL.valExprs = ValExprs{yyS[yypt-0].valExpr}
+			yyVAL.bytes2 = [][]byte{yyDollar[1].bytes}
 		}
 	case 114:
-		//line sql.y:643
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:651
 		{
-			yyVAL.valExprs = append(yyS[yypt-2].valExprs, yyS[yypt-0].valExpr)
+			yyVAL.bytes2 = append(yyDollar[1].bytes2, yyDollar[3].bytes)
 		}
 	case 115:
-		//line sql.y:649
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:656
 		{
-			yyVAL.valExpr = yyS[yypt-0].valExpr
+			yyVAL.boolExpr = nil
 		}
 	case 116:
-		//line sql.y:653
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:660
 		{
-			yyVAL.valExpr = yyS[yypt-0].colName
-		}
-	case 117:
-		//line sql.y:657
-		{
-			yyVAL.valExpr = yyS[yypt-0].rowTuple
+			yyVAL.boolExpr = yyDollar[2].boolExpr
 		}
 	case 118:
-		//line sql.y:661
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:667
 		{
-			yyVAL.valExpr = &BinaryExpr{Left: yyS[yypt-2].valExpr, Operator: AST_BITAND, Right: yyS[yypt-0].valExpr}
+			yyVAL.boolExpr = &AndExpr{Left: yyDollar[1].boolExpr, Right: yyDollar[3].boolExpr}
 		}
 	case 119:
-		//line sql.y:665
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:671
 		{
-			yyVAL.valExpr = &BinaryExpr{Left: yyS[yypt-2].valExpr, Operator: AST_BITOR, Right: yyS[yypt-0].valExpr}
+			yyVAL.boolExpr = &OrExpr{Left: yyDollar[1].boolExpr, Right: yyDollar[3].boolExpr}
 		}
 	case 120:
-		//line sql.y:669
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:675
 		{
-			yyVAL.valExpr = &BinaryExpr{Left: yyS[yypt-2].valExpr, Operator: AST_BITXOR, Right: yyS[yypt-0].valExpr}
+			yyVAL.boolExpr = &NotExpr{Expr: yyDollar[2].boolExpr}
 		}
 	case 121:
-		//line sql.y:673
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:679
 		{
-			yyVAL.valExpr = &BinaryExpr{Left: yyS[yypt-2].valExpr, Operator: AST_PLUS, Right: yyS[yypt-0].valExpr}
+			yyVAL.boolExpr = &ParenBoolExpr{Expr: yyDollar[2].boolExpr}
 		}
 	case 122:
-		//line sql.y:677
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:685
 		{
-			yyVAL.valExpr = &BinaryExpr{Left: yyS[yypt-2].valExpr, Operator: AST_MINUS, Right: yyS[yypt-0].valExpr}
+			yyVAL.boolExpr = &ComparisonExpr{Left: yyDollar[1].valExpr, Operator: yyDollar[2].str, Right: yyDollar[3].valExpr}
 		}
 	case 123:
-		//line sql.y:681
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:689
 		{
-			yyVAL.valExpr = &BinaryExpr{Left: yyS[yypt-2].valExpr, Operator: AST_MULT, Right: yyS[yypt-0].valExpr}
+			yyVAL.boolExpr = &ComparisonExpr{Left: yyDollar[1].valExpr, Operator: AST_IN, Right: yyDollar[3].colTuple}
 		}
 	case 124:
-		//line sql.y:685
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line sql.y:693
 		{
-			yyVAL.valExpr = &BinaryExpr{Left: yyS[yypt-2].valExpr, Operator: AST_DIV, Right: yyS[yypt-0].valExpr}
+			yyVAL.boolExpr = &ComparisonExpr{Left: yyDollar[1].valExpr, Operator: AST_NOT_IN, Right: yyDollar[4].colTuple}
 		}
 	case 125:
-		//line sql.y:689
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:697
 		{
-			yyVAL.valExpr = &BinaryExpr{Left: yyS[yypt-2].valExpr, Operator: AST_MOD, Right: yyS[yypt-0].valExpr}
+			yyVAL.boolExpr = &ComparisonExpr{Left: yyDollar[1].valExpr, Operator: AST_LIKE, Right: yyDollar[3].valExpr}
 		}
 	case 126:
-		//line sql.y:693
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line sql.y:701
 		{
-			if num, ok := yyS[yypt-0].valExpr.(NumVal); ok {
-				switch yyS[yypt-1].byt {
-				case '-':
-					yyVAL.valExpr = append(NumVal("-"), num...)
-				case '+':
-					yyVAL.valExpr = num
-				default:
-					yyVAL.valExpr = &UnaryExpr{Operator: yyS[yypt-1].byt, Expr: yyS[yypt-0].valExpr}
-				}
-			} else {
-				yyVAL.valExpr = &UnaryExpr{Operator: yyS[yypt-1].byt, Expr: yyS[yypt-0].valExpr}
-			}
+			yyVAL.boolExpr = &ComparisonExpr{Left: yyDollar[1].valExpr, Operator: AST_NOT_LIKE, Right: yyDollar[4].valExpr}
 		}
 	case 127:
-		//line sql.y:708
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line sql.y:705
 		{
-			yyVAL.valExpr = &FuncExpr{Name: yyS[yypt-2].bytes}
+			yyVAL.boolExpr = &RangeCond{Left: yyDollar[1].valExpr, Operator: AST_BETWEEN, From: yyDollar[3].valExpr, To: yyDollar[5].valExpr}
 		}
 	case 128:
-		//line sql.y:712
+		yyDollar = yyS[yypt-6 : yypt+1]
+//line sql.y:709
 		{
-			yyVAL.valExpr = &FuncExpr{Name: yyS[yypt-3].bytes, Exprs: yyS[yypt-1].selectExprs}
+			yyVAL.boolExpr = &RangeCond{Left: yyDollar[1].valExpr, Operator: AST_NOT_BETWEEN, From: yyDollar[4].valExpr, To: yyDollar[6].valExpr}
 		}
 	case 129:
-		//line sql.y:716
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:713
 		{
-			yyVAL.valExpr = &FuncExpr{Name: yyS[yypt-4].bytes, Distinct: true, Exprs: yyS[yypt-1].selectExprs}
+			yyVAL.boolExpr = &NullCheck{Operator: AST_IS_NULL, Expr: yyDollar[1].valExpr}
 		}
 	case 130:
-		//line sql.y:720
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line sql.y:717
 		{
-			yyVAL.valExpr = &FuncExpr{Name: yyS[yypt-3].bytes, Exprs: yyS[yypt-1].selectExprs}
+			yyVAL.boolExpr = &NullCheck{Operator: AST_IS_NOT_NULL, Expr: yyDollar[1].valExpr}
 		}
 	case 131:
-		//line sql.y:724
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:721
 		{
-			yyVAL.valExpr = yyS[yypt-0].caseExpr
+			yyVAL.boolExpr = &ExistsExpr{Subquery: yyDollar[2].subquery}
 		}
 	case 132:
-		//line sql.y:730
+		yyDollar = yyS[yypt-6 : yypt+1]
+//line sql.y:725
 		{
-			yyVAL.bytes = IF_BYTES
+			yyVAL.boolExpr = &KeyrangeExpr{Start: yyDollar[3].valExpr, End: yyDollar[5].valExpr}
 		}
 	case 133:
-		//line sql.y:734
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:731
 		{
-			yyVAL.bytes = VALUES_BYTES
+			yyVAL.str = AST_EQ
 		}
 	case 134:
-		//line sql.y:740
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:735
 		{
-			yyVAL.byt = AST_UPLUS
+			yyVAL.str = AST_LT
 		}
 	case 135:
-		//line sql.y:744
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:739
 		{
-			yyVAL.byt = AST_UMINUS
+			yyVAL.str = AST_GT
 		}
 	case 136:
-		//line sql.y:748
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:743
 		{
-			yyVAL.byt = AST_TILDA
+			yyVAL.str = AST_LE
 		}
 	case 137:
-		//line sql.y:754
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:747
 		{
-			yyVAL.caseExpr = &CaseExpr{Expr: yyS[yypt-3].valExpr, Whens: yyS[yypt-2].whens, Else: yyS[yypt-1].valExpr}
+			yyVAL.str = AST_GE
 		}
 	case 138:
-		//line sql.y:759
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:751
 		{
-			yyVAL.valExpr = nil
+			yyVAL.str = AST_NE
 		}
 	case 139:
-		//line sql.y:763
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:755
 		{
-			yyVAL.valExpr = yyS[yypt-0].valExpr
+			yyVAL.str = AST_NSE
 		}
 	case 140:
-		//line sql.y:769
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:761
 		{
-			yyVAL.whens = []*When{yyS[yypt-0].when}
+			yyVAL.colTuple = ValTuple(yyDollar[2].valExprs)
 		}
 	case 141:
-		//line sql.y:773
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:765
 		{
-			yyVAL.whens = append(yyS[yypt-1].whens, yyS[yypt-0].when)
+			yyVAL.colTuple = yyDollar[1].subquery
 		}
 	case 142:
-		//line sql.y:779
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:769
 		{
-			yyVAL.when = &When{Cond: yyS[yypt-2].boolExpr, Val: yyS[yypt-0].valExpr}
+			yyVAL.colTuple = ListArg(yyDollar[1].bytes)
 		}
 	case 143:
-		//line sql.y:784
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:775
 		{
-			yyVAL.valExpr = nil
+			yyVAL.subquery = &Subquery{yyDollar[2].selStmt}
 		}
 	case 144:
-		//line sql.y:788
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:781
 		{
-			yyVAL.valExpr = yyS[yypt-0].valExpr
+			yyVAL.valExprs = ValExprs{yyDollar[1].valExpr}
 		}
 	case 145:
-		//line sql.y:794
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:785
 		{
-			yyVAL.colName = &ColName{Name: yyS[yypt-0].bytes}
+			yyVAL.valExprs = append(yyDollar[1].valExprs, yyDollar[3].valExpr)
 		}
 	case 146:
-		//line sql.y:798
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:791
 		{
-			yyVAL.colName = &ColName{Qualifier: yyS[yypt-2].bytes, Name: yyS[yypt-0].bytes}
+			yyVAL.valExpr = yyDollar[1].valExpr
 		}
 	case 147:
-		//line sql.y:804
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:795
 		{
-			yyVAL.valExpr = StrVal(yyS[yypt-0].bytes)
+			yyVAL.valExpr = yyDollar[1].colName
 		}
 	case 148:
-		//line sql.y:808
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:799
 		{
-			yyVAL.valExpr = NumVal(yyS[yypt-0].bytes)
+			yyVAL.valExpr = yyDollar[1].rowTuple
 		}
 	case 149:
-		//line sql.y:812
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:803
 		{
-			yyVAL.valExpr = ValArg(yyS[yypt-0].bytes)
+			yyVAL.valExpr = &BinaryExpr{Left: yyDollar[1].valExpr, Operator: AST_BITAND, Right: yyDollar[3].valExpr}
 		}
 	case 150:
-		//line sql.y:816
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:807
 		{
-			yyVAL.valExpr = &NullVal{}
+			yyVAL.valExpr = &BinaryExpr{Left: yyDollar[1].valExpr, Operator: AST_BITOR, Right: yyDollar[3].valExpr}
 		}
 	case 151:
-		//line sql.y:821
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:811
 		{
-			yyVAL.valExprs = nil
+			yyVAL.valExpr = &BinaryExpr{Left: yyDollar[1].valExpr, Operator: AST_BITXOR, Right: yyDollar[3].valExpr}
 		}
 	case 152:
-		//line sql.y:825
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:815
 		{
-			yyVAL.valExprs = yyS[yypt-0].valExprs
+			yyVAL.valExpr = &BinaryExpr{Left: yyDollar[1].valExpr, Operator: AST_PLUS, Right: yyDollar[3].valExpr}
 		}
 	case 153:
-		//line sql.y:830
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:819
 		{
-			yyVAL.boolExpr = nil
+			yyVAL.valExpr = &BinaryExpr{Left: yyDollar[1].valExpr, Operator: AST_MINUS, Right: yyDollar[3].valExpr}
 		}
 	case 154:
-		//line sql.y:834
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:823
 		{
-			yyVAL.boolExpr = yyS[yypt-0].boolExpr
+			yyVAL.valExpr = &BinaryExpr{Left: yyDollar[1].valExpr, Operator: AST_MULT, Right: yyDollar[3].valExpr}
 		}
 	case 155:
-		//line sql.y:839
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:827
 		{
-			yyVAL.orderBy = nil
+			yyVAL.valExpr = &BinaryExpr{Left: yyDollar[1].valExpr, Operator: AST_DIV, Right: yyDollar[3].valExpr}
 		}
 	case 156:
-		//line sql.y:843
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:831
 		{
-			yyVAL.orderBy = yyS[yypt-0].orderBy
+			yyVAL.valExpr = &BinaryExpr{Left: yyDollar[1].valExpr, Operator: AST_MOD, Right: yyDollar[3].valExpr}
 		}
 	case 157:
-		//line sql.y:849
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:835
 		{
-			yyVAL.orderBy = OrderBy{yyS[yypt-0].order}
+			if num, ok := yyDollar[2].valExpr.(NumVal); ok {
+				switch yyDollar[1].byt {
+				case '-':
+					yyVAL.valExpr = append(NumVal("-"), num...)
+				case '+':
+					yyVAL.valExpr = num
+				default:
+					yyVAL.valExpr = &UnaryExpr{Operator: yyDollar[1].byt, Expr: yyDollar[2].valExpr}
+				}
+			} else {
+				yyVAL.valExpr = &UnaryExpr{Operator: yyDollar[1].byt, Expr: yyDollar[2].valExpr}
+			}
 		}
 	case 158:
-		//line sql.y:853
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:850
 		{
-			yyVAL.orderBy = append(yyS[yypt-2].orderBy, yyS[yypt-0].order)
+			yyVAL.valExpr = &FuncExpr{Name: yyDollar[1].bytes}
 		}
 	case 159:
-		//line sql.y:859
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line sql.y:854
 		{
-			yyVAL.order = &Order{Expr: yyS[yypt-1].valExpr, Direction: yyS[yypt-0].str}
+			yyVAL.valExpr = &FuncExpr{Name: yyDollar[1].bytes, Exprs: yyDollar[3].selectExprs}
 		}
 	case 160:
-		//line sql.y:864
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line sql.y:858
 		{
-			yyVAL.str = AST_ASC
+			yyVAL.valExpr = &FuncExpr{Name: yyDollar[1].bytes, Distinct: true, Exprs: yyDollar[4].selectExprs}
 		}
 	case 161:
-		//line sql.y:868
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line sql.y:862
 		{
-			yyVAL.str = AST_ASC
+			yyVAL.valExpr = &FuncExpr{Name: yyDollar[1].bytes, Exprs: yyDollar[3].selectExprs}
 		}
 	case 162:
-		//line sql.y:872
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:866
 		{
-			yyVAL.str = AST_DESC
+			yyVAL.valExpr = yyDollar[1].caseExpr
 		}
 	case 163:
-		//line sql.y:877
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:872
 		{
-			yyVAL.limit = nil
+			yyVAL.bytes = IF_BYTES
 		}
 	case 164:
-		//line sql.y:881
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:876
 		{
-			yyVAL.limit = &Limit{Rowcount: yyS[yypt-0].valExpr}
+			yyVAL.bytes = VALUES_BYTES
 		}
 	case 165:
-		//line sql.y:885
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:882
 		{
-			yyVAL.limit = &Limit{Offset: yyS[yypt-2].valExpr, Rowcount: yyS[yypt-0].valExpr}
+			yyVAL.byt = AST_UPLUS
 		}
 	case 166:
-		//line sql.y:890
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:886
 		{
-			yyVAL.str = ""
+			yyVAL.byt = AST_UMINUS
 		}
 	case 167:
-		//line sql.y:894
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:890
 		{
-			yyVAL.str = AST_FOR_UPDATE
+			yyVAL.byt = AST_TILDA
 		}
 	case 168:
-		//line sql.y:898
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line sql.y:896
 		{
-			if !bytes.Equal(yyS[yypt-1].bytes, SHARE) {
-				yylex.Error("expecting share")
-				return 1
-			}
-			if !bytes.Equal(yyS[yypt-0].bytes, MODE) {
-				yylex.Error("expecting mode")
-				return 1
-			}
-			yyVAL.str = AST_SHARE_MODE
+			yyVAL.caseExpr = &CaseExpr{Expr: yyDollar[2].valExpr, Whens: yyDollar[3].whens, Else: yyDollar[4].valExpr}
 		}
 	case 169:
-		//line sql.y:911
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:901
 		{
-			yyVAL.columns = nil
+			yyVAL.valExpr = nil
 		}
 	case 170:
-		//line sql.y:915
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:905
 		{
-			yyVAL.columns = yyS[yypt-1].columns
+			yyVAL.valExpr = yyDollar[1].valExpr
 		}
 	case 171:
-		//line sql.y:921
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:911
 		{
-			yyVAL.columns = Columns{&NonStarExpr{Expr: yyS[yypt-0].colName}}
+			yyVAL.whens = []*When{yyDollar[1].when}
 		}
 	case 172:
-		//line sql.y:925
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:915
 		{
-			yyVAL.columns = append(yyVAL.columns, &NonStarExpr{Expr: yyS[yypt-0].colName})
+			yyVAL.whens = append(yyDollar[1].whens, yyDollar[2].when)
 		}
 	case 173:
-		//line sql.y:930
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line sql.y:921
 		{
-			yyVAL.updateExprs = nil
+			yyVAL.when = &When{Cond: yyDollar[2].boolExpr, Val: yyDollar[4].valExpr}
 		}
 	case 174:
-		//line sql.y:934
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:926
 		{
-			yyVAL.updateExprs = yyS[yypt-0].updateExprs
+			yyVAL.valExpr = nil
 		}
 	case 175:
-		//line sql.y:940
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:930
 		{
-			yyVAL.insRows = yyS[yypt-0].values
+			yyVAL.valExpr = yyDollar[2].valExpr
 		}
 	case 176:
-		//line sql.y:944
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:936
 		{
-			yyVAL.insRows = yyS[yypt-0].selStmt
+			yyVAL.colName = &ColName{Name: yyDollar[1].bytes}
 		}
 	case 177:
-		//line sql.y:950
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:940
 		{
-			yyVAL.values = Values{yyS[yypt-0].rowTuple}
+			yyVAL.colName = &ColName{Qualifier: yyDollar[1].bytes, Name: yyDollar[3].bytes}
 		}
 	case 178:
-		//line sql.y:954
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:946
 		{
-			yyVAL.values = append(yyS[yypt-2].values, yyS[yypt-0].rowTuple)
+			yyVAL.valExpr = StrVal(yyDollar[1].bytes)
 		}
 	case 179:
-		//line sql.y:960
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:950
 		{
-			yyVAL.rowTuple = ValTuple(yyS[yypt-1].valExprs)
+			yyVAL.valExpr = NumVal(yyDollar[1].bytes)
 		}
 	case 180:
-		//line sql.y:964
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:954
 		{
-			yyVAL.rowTuple = yyS[yypt-0].subquery
+			yyVAL.valExpr = ValArg(yyDollar[1].bytes)
 		}
 	case 181:
-		//line sql.y:970
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:958
 		{
-			yyVAL.updateExprs = UpdateExprs{yyS[yypt-0].updateExpr}
+			yyVAL.valExpr = &NullVal{}
 		}
 	case 182:
-		//line sql.y:974
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:963
 		{
-			yyVAL.updateExprs = append(yyS[yypt-2].updateExprs, yyS[yypt-0].updateExpr)
+			yyVAL.valExprs = nil
 		}
 	case 183:
-		//line sql.y:980
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:967
 		{
-			yyVAL.updateExpr = &UpdateExpr{Name: yyS[yypt-2].colName, Expr: yyS[yypt-0].valExpr}
+			yyVAL.valExprs = yyDollar[3].valExprs
 		}
 	case 184:
-		//line sql.y:985
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:972
 		{
-			yyVAL.empty = struct{}{}
+			yyVAL.boolExpr = nil
 		}
 	case 185:
-		//line sql.y:987
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:976
 		{
-			yyVAL.empty = struct{}{}
+			yyVAL.boolExpr = yyDollar[2].boolExpr
 		}
 	case 186:
-		//line sql.y:990
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:981
 		{
-			yyVAL.empty = struct{}{}
+			yyVAL.orderBy = nil
 		}
 	case 187:
-		//line sql.y:992
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:985
 		{
-			yyVAL.empty = struct{}{}
+			yyVAL.orderBy = yyDollar[3].orderBy
 		}
 	case 188:
-		//line sql.y:995
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:991
 		{
-			yyVAL.empty = struct{}{}
+			yyVAL.orderBy = OrderBy{yyDollar[1].order}
 		}
 	case 189:
-		//line sql.y:997
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:995
 		{
-			yyVAL.empty = struct{}{}
+			yyVAL.orderBy = append(yyDollar[1].orderBy, yyDollar[3].order)
 		}
 	case 190:
-		//line sql.y:1001
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:1001
 		{
-			yyVAL.empty = struct{}{}
+			yyVAL.order = &Order{Expr: yyDollar[1].valExpr, Direction: yyDollar[2].str}
 		}
 	case 191:
-		//line sql.y:1003
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:1006
 		{
-			yyVAL.empty = struct{}{}
+			yyVAL.str = AST_ASC
 		}
 	case 192:
-		//line sql.y:1005
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1010
 		{
-			yyVAL.empty = struct{}{}
+			yyVAL.str = AST_ASC
 		}
 	case 193:
-		//line sql.y:1007
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1014
 		{
-			yyVAL.empty = struct{}{}
+			yyVAL.str = AST_DESC
 		}
 	case 194:
-		//line sql.y:1009
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:1019
 		{
-			yyVAL.empty = struct{}{}
+			yyVAL.limit = nil
 		}
 	case 195:
-		//line sql.y:1012
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:1023
 		{
-			yyVAL.empty = struct{}{}
+			yyVAL.limit = &Limit{Rowcount: yyDollar[2].valExpr}
 		}
 	case 196:
-		//line sql.y:1014
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line sql.y:1027
 		{
-			yyVAL.empty = struct{}{}
+			yyVAL.limit = &Limit{Offset: yyDollar[2].valExpr, Rowcount: yyDollar[4].valExpr}
 		}
 	case 197:
-		//line sql.y:1017
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:1032
 		{
-			yyVAL.empty = struct{}{}
+			yyVAL.str = ""
 		}
 	case 198:
-		//line sql.y:1019
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:1036
 		{
-			yyVAL.empty = struct{}{}
+			yyVAL.str = AST_FOR_UPDATE
 		}
 	case 199:
-		//line sql.y:1022
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line sql.y:1040
 		{
-			yyVAL.empty = struct{}{}
+			if !bytes.Equal(yyDollar[3].bytes, SHARE) {
+				yylex.Error("expecting share")
+				return 1
+			}
+			if !bytes.Equal(yyDollar[4].bytes, MODE) {
+				yylex.Error("expecting mode")
+				return 1
+			}
+			yyVAL.str = AST_SHARE_MODE
 		}
 	case 200:
-		//line sql.y:1024
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:1053
 		{
-			yyVAL.empty = struct{}{}
+			yyVAL.columns = nil
 		}
 	case 201:
-		//line sql.y:1028
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:1057
 		{
-			yyVAL.bytes = bytes.ToLower(yyS[yypt-0].bytes)
+			yyVAL.columns = yyDollar[2].columns
 		}
 	case 202:
-		//line sql.y:1033
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1063
+		{
+			yyVAL.columns = Columns{&NonStarExpr{Expr: yyDollar[1].colName}}
+		}
+	case 203:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:1067
+		{
+			yyVAL.columns = append(yyVAL.columns, &NonStarExpr{Expr: yyDollar[3].colName})
+		}
+	case 204:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:1072
+		{
+			yyVAL.updateExprs = nil
+		}
+	case 205:
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line sql.y:1076
+		{
+			yyVAL.updateExprs = yyDollar[5].updateExprs
+		}
+	case 206:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:1082
+		{
+			yyVAL.insRows = yyDollar[2].values
+		}
+	case 207:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1086
+		{
+			yyVAL.insRows = yyDollar[1].selStmt
+		}
+	case 208:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1092
+		{
+			yyVAL.values = Values{yyDollar[1].rowTuple}
+		}
+	case 209:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:1096
+		{
+			yyVAL.values = append(yyDollar[1].values, yyDollar[3].rowTuple)
+		}
+	case 210:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:1102
+		{
+			yyVAL.rowTuple = ValTuple(yyDollar[2].valExprs)
+		}
+	case 211:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1106
+		{
+			yyVAL.rowTuple = yyDollar[1].subquery
+		}
+	case 212:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1112
+		{
+			yyVAL.updateExprs = UpdateExprs{yyDollar[1].updateExpr}
+		}
+	case 213:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:1116
+		{
+			yyVAL.updateExprs = append(yyDollar[1].updateExprs, yyDollar[3].updateExpr)
+		}
+	case 214:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:1122
+		{
+			yyVAL.updateExpr = &UpdateExpr{Name: yyDollar[1].colName, Expr: yyDollar[3].valExpr}
+		}
+	case 215:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:1127
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 216:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:1129
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 217:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:1132
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 218:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line sql.y:1134
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 219:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:1137
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 220:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1139
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 221:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:1142
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 222:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1144
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 223:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:1147
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 224:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1149
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 225:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:1153
+		{
+			yyVAL.str = IsolationReadUncommitted
+		}
+	case 226:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:1157
+		{
+			yyVAL.str = IsolationReadCommitted
+		}
+	case 227:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:1161
+		{
+			yyVAL.str = IsolationRepeatableRead
+		}
+	case 228:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1165
+		{
+			yyVAL.str = IsolationSerializable
+		}
+	case 229:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1171
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 230:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1173
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 231:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1175
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 232:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1177
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 233:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1181
+		{
+			yyVAL.str = ShowVitessKeyspaces
+		}
+	case 234:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1185
+		{
+			yyVAL.str = ShowVitessShards
+		}
+	case 235:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1189
+		{
+			yyVAL.str = ShowVitessTransactions
+		}
+	case 236:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:1194
+		{
+			yyVAL.bytes = nil
+		}
+	case 237:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:1198
+		{
+			yyVAL.bytes = yyDollar[2].bytes
+		}
+	case 238:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:1203
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 239:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1205
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 240:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1209
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 241:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1211
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 242:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1213
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 243:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1215
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 244:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1217
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 245:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:1220
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 246:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1222
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 247:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:1225
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 248:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1227
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 249:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:1230
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 250:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line sql.y:1232
+		{
+			yyVAL.empty = struct{}{}
+		}
+	case 251:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line sql.y:1236
+		{
+			yyVAL.bytes = bytes.ToLower(yyDollar[1].bytes)
+		}
+	case 252:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line sql.y:1241
 		{
 			ForceEOF(yylex)
 		}
@@ -5,7 +5,10 @@
 %{
 package sqlparser
 
-import "bytes"
+import (
+  "bytes"
+  "strconv"
+)
 
 func SetParseTree(yylex interface{}, stmt Statement) {
   yylex.(*Tokenizer).ParseTree = stmt
@@ -24,6 +27,8 @@ var (
   MODE  =        []byte("mode")
   IF_BYTES =     []byte("if")
   VALUES_BYTES = []byte("values")
+  SAVEPOINT_BYTES = []byte("savepoint")
+  DUAL_BYTES = []byte("dual")
 )
 
 %}
@@ -91,6 +96,12 @@ var (
 %token <empty> TABLE INDEX VIEW TO IGNORE IF UNIQUE USING
 %token <empty> SHOW DESCRIBE EXPLAIN
 
+// Context keywords: see contextKeywords.
+%token <empty> SAVEPOINT ROLLBACK RELEASE WORK START TRANSACTION SESSION ISOLATION LEVEL
+%token <empty> READ WRITE ONLY COMMITTED UNCOMMITTED REPEATABLE SERIALIZABLE
+%token <empty> WARNINGS PROCESSLIST FULL VITESS_KEYSPACES VITESS_SHARDS VITESS_TRANSACTIONS
+%token <empty> KILL QUERY PLAN TEMPORARY
+
 %start any_command
 
 %type <statement> command
@@ -98,6 +109,8 @@ var (
 %type <statement> insert_statement update_statement delete_statement set_statement
 %type <statement> create_statement alter_statement rename_statement drop_statement
 %type <statement> analyze_statement other_statement
+%type <statement> savepoint_statement start_statement explain_statement explainable_statement
+%type <statement> show_statement use_statement kill_statement
 %type <bytes2> comment_opt comment_list
 %type <str> union_op
 %type <str> distinct_opt
@@ -141,13 +154,16 @@ var (
 %type <updateExprs> update_list
 %type <updateExpr> update_expression
 %type <empty> exists_opt not_exists_opt ignore_opt non_rename_operation to_opt constraint_opt using_opt
+%type <empty> semicolon_opt work_opt session_opt show_other
+%type <str> isolation_level show_vitess
+%type <bytes> from_keyspace_opt
 %type <bytes> sql_id
 %type <empty> force_eof
 
 %%
 
 any_command:
-  command
+  command semicolon_opt
   {
     SetParseTree(yylex, $1)
   }
@@ -167,12 +183,23 @@ command:
 | drop_statement
 | analyze_statement
 | other_statement
+| savepoint_statement
+| start_statement
+| explain_statement
+| show_statement
+| use_statement
+| kill_statement
 
 select_statement:
   SELECT comment_opt distinct_opt select_expression_list FROM table_expression_list where_expression_opt group_by_opt having_opt order_by_opt limit_opt lock_opt
   {
     $$ = &Select{Comments: Comments($2), Distinct: $3, SelectExprs: $4, From: $6, Where: NewWhere(AST_WHERE, $7), GroupBy: GroupBy($8), Having: NewWhere(AST_HAVING, $9), OrderBy: $10, Limit: $11, Lock: $12}
   }
+| SELECT comment_opt distinct_opt select_expression_list
+  {
+    // Like MySQL, a select without tables selects from dual.
+    $$ = &Select{Comments: Comments($2), Distinct: $3, SelectExprs: $4, From: TableExprs{&AliasedTableExpr{Expr: &TableName{Name: DUAL_BYTES}}}}
+  }
 | select_statement union_op select_statement %prec UNION
   {
     $$ = &Union{Type: $2, Left: $1, Right: $3}
@@ -211,6 +238,10 @@ set_statement:
   {
     $$ = &Set{Comments: Comments($2), Exprs: $3}
   }
+| SET comment_opt session_opt TRANSACTION ISOLATION LEVEL isolation_level
+  {
+    $$ = &SetTransaction{Comments: Comments($2), Isolation: $7}
+  }
 
 create_statement:
   CREATE TABLE not_exists_opt ID force_eof
@@ -226,6 +257,10 @@ create_statement:
   {
     $$ = &DDL{Action: AST_CREATE, NewName: $3}
   }
+| CREATE TEMPORARY TABLE not_exists_opt ID force_eof
+  {
+    $$ = &DDL{Action: AST_CREATE, NewName: $5, Temporary: true}
+  }
 
 alter_statement:
   ALTER ignore_opt TABLE ID non_rename_operation force_eof
@@ -262,6 +297,10 @@ drop_statement:
   {
     $$ = &DDL{Action: AST_DROP, Table: $4}
   }
+| DROP TEMPORARY TABLE exists_opt ID force_eof
+  {
+    $$ = &DDL{Action: AST_DROP, Table: $5, Temporary: true}
+  }
 
 analyze_statement:
   ANALYZE TABLE ID
@@ -270,19 +309,122 @@ analyze_statement:
   }
 
 other_statement:
-  SHOW force_eof
+  SHOW show_other force_eof
+  {
+    $$ = &Other{}
+  }
+| SHOW FULL show_other force_eof
   {
     $$ = &Other{}
   }
-| DESCRIBE force_eof
+| DESCRIBE ID force_eof
   {
     $$ = &Other{}
   }
-| EXPLAIN force_eof
+| EXPLAIN ID force_eof
   {
     $$ = &Other{}
   }
 
+savepoint_statement:
+  SAVEPOINT ID
+  {
+    $$ = &Savepoint{Action: SavepointCreate, Name: $2}
+  }
+| ROLLBACK work_opt TO ID
+  {
+    $$ = &Savepoint{Action: SavepointRollback, Name: $4}
+  }
+| ROLLBACK work_opt TO sql_id ID
+  {
+    if !bytes.Equal($4, SAVEPOINT_BYTES) {
+      yylex.Error("expecting savepoint")
+      return 1
+    }
+    $$ = &Savepoint{Action: SavepointRollback, Name: $5}
+  }
+| RELEASE SAVEPOINT ID
+  {
+    $$ = &Savepoint{Action: SavepointRelease, Name: $3}
+  }
+
+start_statement:
+  START TRANSACTION
+  {
+    $$ = &StartTransaction{}
+  }
+| START TRANSACTION READ ONLY
+  {
+    $$ = &StartTransaction{ReadOnly: true}
+  }
+| START TRANSACTION READ WRITE
+  {
+    $$ = &StartTransaction{}
+  }
+
+explain_statement:
+  EXPLAIN explainable_statement
+  {
+    $$ = &Explain{Statement: $2}
+  }
+| DESCRIBE PLAN explainable_statement
+  {
+    $$ = &Explain{Statement: $3}
+  }
+
+explainable_statement:
+  select_statement
+  {
+    $$ = $1
+  }
+| insert_statement
+| update_statement
+| delete_statement
+
+show_statement:
+  SHOW WARNINGS
+  {
+    $$ = &Show{Type: ShowWarnings}
+  }
+| SHOW PROCESSLIST
+  {
+    $$ = &Show{Type: ShowProcesslist}
+  }
+| SHOW FULL PROCESSLIST
+  {
+    $$ = &Show{Type: ShowProcesslist, Full: true}
+  }
+| SHOW show_vitess from_keyspace_opt
+  {
+    $$ = &Show{Type: $2, Keyspace: $3}
+  }
+
+use_statement:
+  USE ID
+  {
+    $$ = &Use{DBName: $2}
+  }
+
+kill_statement:
+  KILL NUMBER
+  {
+    id, err := strconv.ParseInt(string($2), 10, 64)
+    if err != nil {
+      yylex.Error("invalid query id")
+      return 1
+    }
+    $$ = &Kill{ID: id}
+  }
+| KILL QUERY NUMBER
+  {
+    id, err := strconv.ParseInt(string($3), 10, 64)
+    if err != nil {
+      yylex.Error("invalid query id")
+      return 1
+    }
+    $$ = &Kill{ID: id}
+  }
+
 comment_opt:
   {
     SetAllowComments(yylex, true)
@@ -991,6 +1133,72 @@ not_exists_opt:
 | IF NOT EXISTS
   { $$ = struct{}{} }
 
+semicolon_opt:
+  { $$ = struct{}{} }
+| ';'
+  { $$ = struct{}{} }
+
+work_opt:
+  { $$ = struct{}{} }
+| WORK
+  { $$ = struct{}{} }
+
+session_opt:
+  { $$ = struct{}{} }
+| SESSION
+  { $$ = struct{}{} }
+
+isolation_level:
+  READ UNCOMMITTED
+  {
+    $$ = IsolationReadUncommitted
+  }
+| READ COMMITTED
+  {
+    $$ = IsolationReadCommitted
+  }
+| REPEATABLE READ
+  {
+    $$ = IsolationRepeatableRead
+  }
+| SERIALIZABLE
+  {
+    $$ = IsolationSerializable
+  }
+
+show_other:
+  ID
+  { $$ = struct{}{} }
+| CREATE
+  { $$ = struct{}{} }
+| INDEX
+  { $$ = struct{}{} }
+| TABLE
+  { $$ = struct{}{} }
+
+show_vitess:
+  VITESS_KEYSPACES
+  {
+    $$ = ShowVitessKeyspaces
+  }
+| VITESS_SHARDS
+  {
+    $$ = ShowVitessShards
+  }
+| VITESS_TRANSACTIONS
+  {
+    $$ = ShowVitessTransactions
+  }
+
+from_keyspace_opt:
+  {
+    $$ = nil
+  }
+| FROM ID
+  {
+    $$ = $2
+  }
+
 ignore_opt:
   { $$ = struct{}{} }
 | IGNORE
@@ -25,6 +25,7 @@ type Tokenizer struct {
 	errorToken    []byte
 	LastError     string
 	posVarIndex   int
+	lastTyp       int
 	ParseTree     Statement
 }
 
@@ -107,6 +108,43 @@ var keywords = map[string]int{
 	"where":         WHERE,
 }
 
+// contextKeywords are the words that are keywords only after the
+// token they're listed under, 0 being the start of the statement.
+// Everywhere else they're identifiers, so that they can still be
+// used as the names of tables and columns.
+var contextKeywords = map[int]map[string]int{
+	0: {
+		"kill":      KILL,
+		"release":   RELEASE,
+		"rollback":  ROLLBACK,
+		"savepoint": SAVEPOINT,
+		"start":     START,
+	},
+	CREATE:      {"temporary": TEMPORARY},
+	DESCRIBE:    {"plan": PLAN},
+	DROP:        {"temporary": TEMPORARY},
+	FULL:        {"processlist": PROCESSLIST},
+	ISOLATION:   {"level": LEVEL},
+	KILL:        {"query": QUERY},
+	LEVEL:       {"read": READ, "repeatable": REPEATABLE, "serializable": SERIALIZABLE},
+	READ:        {"committed": COMMITTED, "only": ONLY, "uncommitted": UNCOMMITTED, "write": WRITE},
+	RELEASE:     {"savepoint": SAVEPOINT},
+	REPEATABLE:  {"read": READ},
+	ROLLBACK:    {"work": WORK},
+	SESSION:     {"transaction": TRANSACTION},
+	SET:         {"session": SESSION, "transaction": TRANSACTION},
+	START:       {"transaction": TRANSACTION},
+	TRANSACTION: {"isolation": ISOLATION, "read": READ},
+	SHOW: {
+		"full":                FULL,
+		"processlist":         PROCESSLIST,
+		"vitess_keyspaces":    VITESS_KEYSPACES,
+		"vitess_shards":       VITESS_SHARDS,
+		"vitess_transactions": VITESS_TRANSACTIONS,
+		"warnings":            WARNINGS,
+	},
+}
+
 // Lex returns the next token form the Tokenizer.
 // This function is used by go yacc.
 func (tkn *Tokenizer) Lex(lval *yySymType) int {
@@ -117,6 +155,16 @@ func (tkn *Tokenizer) Lex(lval *yySymType) int {
 		}
 		typ, val = tkn.Scan()
 	}
+	if typ == ID {
+		if words, ok := contextKeywords[tkn.lastTyp]; ok {
+			if keyword, ok := words[strings.ToLower(string(val))]; ok {
+				typ = keyword
+			}
+		}
+	}
+	if typ != COMMENT {
+		tkn.lastTyp = typ
+	}
 	switch typ {
 	case ID, STRING, NUMBER, VALUE_ARG, LIST_ARG, COMMENT:
 		lval.bytes = val
@@ -183,6 +183,7 @@ const (
 	KEYSPACE_ACTION_SET_SHARDING_INFO   = "SetKeyspaceShardingInfo"
 	KEYSPACE_ACTION_MIGRATE_SERVED_FROM = "MigrateServedFrom"
 	KEYSPACE_ACTION_SET_SERVED_FROM     = "SetKeyspaceServedFrom"
+	KEYSPACE_ACTION_SET_TABLET_TYPE     = "SetKeyspaceTabletTypeRule"
 
 	//
 	// SrvShard actions - very local locking, for consistency.
@@ -187,6 +187,12 @@ func SetKeyspaceServedFrom() *ActionNode {
 	}).SetGuid()
 }
 
+func SetKeyspaceTabletTypeRule() *ActionNode {
+	return (&ActionNode{
+		Action: KEYSPACE_ACTION_SET_TABLET_TYPE,
+	}).SetGuid()
+}
+
 func ApplySchemaKeyspace(change string, simple bool) *ActionNode {
 	return (&ActionNode{
 		Action: KEYSPACE_ACTION_APPLY_SCHEMA,
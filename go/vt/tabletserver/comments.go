@@ -5,6 +5,8 @@
 package tabletserver
 
 import (
+	"fmt"
+
 	"github.com/youtube/vitess/go/vt/tabletserver/proto"
 )
 
@@ -38,6 +40,20 @@ func restoreTrailing(sql []byte, bindVars map[string]interface{}) []byte {
 	return sql
 }
 
+// addRoutingComment appends the routing comment for the keyspace id
+// supplied in the proto.RoutingKeyspaceId bind var, if any. The
+// comment is added here rather than by vtgate, so that the queries
+// vtgate sends don't differ by keyspace id.
+func addRoutingComment(sql []byte, bindVars map[string]interface{}) []byte {
+	switch ksid := bindVars[proto.RoutingKeyspaceId].(type) {
+	case string:
+		sql = append(sql, fmt.Sprintf(" /* _routing keyspace_id:%x */", ksid)...)
+	case []byte:
+		sql = append(sql, fmt.Sprintf(" /* _routing keyspace_id:%x */", ksid)...)
+	}
+	return sql
+}
+
 // matchComments matches trailing comments. If no comment was found,
 // it returns -1. Otherwise, it returns the position where the query ends
 // before the trailing comments begin.
@@ -85,3 +85,25 @@ func TestComments(t *testing.T) {
 		}
 	}
 }
+
+func TestAddRoutingComment(t *testing.T) {
+	testcases := []struct {
+		bindVars map[string]interface{}
+		want     string
+	}{{
+		bindVars: map[string]interface{}{},
+		want:     "update a set b = 1",
+	}, {
+		bindVars: map[string]interface{}{proto.RoutingKeyspaceId: "\x16k@\xb4J\xbaK\xd6"},
+		want:     "update a set b = 1 /* _routing keyspace_id:166b40b44aba4bd6 */",
+	}, {
+		bindVars: map[string]interface{}{proto.RoutingKeyspaceId: []byte("\x16k@\xb4J\xbaK\xd6")},
+		want:     "update a set b = 1 /* _routing keyspace_id:166b40b44aba4bd6 */",
+	}}
+	for _, tcase := range testcases {
+		got := string(addRoutingComment([]byte("update a set b = 1"), tcase.bindVars))
+		if got != tcase.want {
+			t.Errorf("addRoutingComment(%v): %q, want %q", tcase.bindVars, got, tcase.want)
+		}
+	}
+}
@@ -20,8 +20,16 @@ func (sq *SqlQuery) GetSessionId(sessionParams *proto.SessionParams, sessionInfo
 	return sq.server.GetSessionId(sessionParams, sessionInfo)
 }
 
-func (sq *SqlQuery) Begin(ctx context.Context, session *proto.Session, txInfo *proto.TransactionInfo) error {
-	return sq.server.Begin(ctx, session, txInfo)
+func (sq *SqlQuery) Begin(ctx context.Context, req *proto.BeginRequest, txInfo *proto.TransactionInfo) error {
+	return sq.server.Begin(ctx, req, txInfo)
+}
+
+func (sq *SqlQuery) Reserve(ctx context.Context, session *proto.Session, reservedInfo *proto.ReservedInfo) error {
+	return sq.server.Reserve(ctx, session, reservedInfo)
+}
+
+func (sq *SqlQuery) Release(ctx context.Context, req *proto.ReservedInfo, noOutput *string) error {
+	return sq.server.Release(ctx, req)
 }
 
 func (sq *SqlQuery) Commit(ctx context.Context, session *proto.Session, noOutput *string) error {
@@ -32,6 +40,42 @@ func (sq *SqlQuery) Rollback(ctx context.Context, session *proto.Session, noOutp
 	return sq.server.Rollback(ctx, session)
 }
 
+func (sq *SqlQuery) Prepare(ctx context.Context, req *proto.DtidRequest, noOutput *string) error {
+	return sq.server.Prepare(ctx, req)
+}
+
+func (sq *SqlQuery) CommitPrepared(ctx context.Context, req *proto.DtidRequest, noOutput *string) error {
+	return sq.server.CommitPrepared(ctx, req)
+}
+
+func (sq *SqlQuery) RollbackPrepared(ctx context.Context, req *proto.DtidRequest, noOutput *string) error {
+	return sq.server.RollbackPrepared(ctx, req)
+}
+
+func (sq *SqlQuery) CreateTransaction(ctx context.Context, req *proto.CreateTransactionRequest, noOutput *string) error {
+	return sq.server.CreateTransaction(ctx, req)
+}
+
+func (sq *SqlQuery) StartCommit(ctx context.Context, req *proto.DtidRequest, noOutput *string) error {
+	return sq.server.StartCommit(ctx, req)
+}
+
+func (sq *SqlQuery) SetRollback(ctx context.Context, req *proto.DtidRequest, noOutput *string) error {
+	return sq.server.SetRollback(ctx, req)
+}
+
+func (sq *SqlQuery) ConcludeTransaction(ctx context.Context, req *proto.DtidRequest, noOutput *string) error {
+	return sq.server.ConcludeTransaction(ctx, req)
+}
+
+func (sq *SqlQuery) UnresolvedTransactions(ctx context.Context, req *proto.UnresolvedTransactionsRequest, reply *proto.UnresolvedTransactionsResult) error {
+	return sq.server.UnresolvedTransactions(ctx, req, reply)
+}
+
+func (sq *SqlQuery) LockWaits(ctx context.Context, req *proto.LockWaitsRequest, reply *proto.LockWaitsResult) error {
+	return sq.server.LockWaits(ctx, req, reply)
+}
+
 func (sq *SqlQuery) Execute(ctx context.Context, query *proto.Query, reply *mproto.QueryResult) error {
 	return sq.server.Execute(ctx, query, reply)
 }
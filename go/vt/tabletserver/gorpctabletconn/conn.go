@@ -153,21 +153,55 @@ func (conn *TabletBson) StreamExecute(ctx context.Context, query string, bindVar
 }
 
 // Begin starts a transaction.
-func (conn *TabletBson) Begin(ctx context.Context) (transactionID int64, err error) {
+func (conn *TabletBson) Begin(ctx context.Context, isolation string, readOnly bool, reservedID int64) (transactionID int64, err error) {
 	conn.mu.RLock()
 	defer conn.mu.RUnlock()
 	if conn.rpcClient == nil {
 		return 0, tabletconn.CONN_CLOSED
 	}
 
-	req := &tproto.Session{
-		SessionId: conn.sessionID,
+	req := &tproto.BeginRequest{
+		SessionId:  conn.sessionID,
+		Isolation:  isolation,
+		ReadOnly:   readOnly,
+		ReservedId: reservedID,
 	}
 	var txInfo tproto.TransactionInfo
 	err = conn.rpcClient.Call(ctx, "SqlQuery.Begin", req, &txInfo)
 	return txInfo.TransactionId, tabletError(err)
 }
 
+// Reserve reserves a connection for the session.
+func (conn *TabletBson) Reserve(ctx context.Context) (reservedID int64, err error) {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return 0, tabletconn.CONN_CLOSED
+	}
+
+	req := &tproto.Session{
+		SessionId: conn.sessionID,
+	}
+	var reservedInfo tproto.ReservedInfo
+	err = conn.rpcClient.Call(ctx, "SqlQuery.Reserve", req, &reservedInfo)
+	return reservedInfo.ReservedId, tabletError(err)
+}
+
+// Release releases a reserved connection.
+func (conn *TabletBson) Release(ctx context.Context, reservedID int64) error {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return tabletconn.CONN_CLOSED
+	}
+
+	req := &tproto.ReservedInfo{
+		SessionId:  conn.sessionID,
+		ReservedId: reservedID,
+	}
+	return tabletError(conn.rpcClient.Call(ctx, "SqlQuery.Release", req, &rpc.Unused{}))
+}
+
 // Commit commits the ongoing transaction.
 func (conn *TabletBson) Commit(ctx context.Context, transactionID int64) error {
 	conn.mu.RLock()
@@ -198,6 +232,153 @@ func (conn *TabletBson) Rollback(ctx context.Context, transactionID int64) error
 	return tabletError(conn.rpcClient.Call(ctx, "SqlQuery.Rollback", req, &rpc.Unused{}))
 }
 
+// Prepare prepares the transaction for a two-phase commit.
+func (conn *TabletBson) Prepare(ctx context.Context, transactionID int64, dtid string) error {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return tabletconn.CONN_CLOSED
+	}
+
+	req := &tproto.DtidRequest{
+		SessionId:     conn.sessionID,
+		TransactionId: transactionID,
+		Dtid:          dtid,
+	}
+	return tabletError(conn.rpcClient.Call(ctx, "SqlQuery.Prepare", req, &rpc.Unused{}))
+}
+
+// CommitPrepared commits the prepared transaction.
+func (conn *TabletBson) CommitPrepared(ctx context.Context, dtid string) error {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return tabletconn.CONN_CLOSED
+	}
+
+	req := &tproto.DtidRequest{
+		SessionId: conn.sessionID,
+		Dtid:      dtid,
+	}
+	return tabletError(conn.rpcClient.Call(ctx, "SqlQuery.CommitPrepared", req, &rpc.Unused{}))
+}
+
+// RollbackPrepared rolls back the prepared transaction.
+func (conn *TabletBson) RollbackPrepared(ctx context.Context, dtid string, originalID int64) error {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return tabletconn.CONN_CLOSED
+	}
+
+	req := &tproto.DtidRequest{
+		SessionId:     conn.sessionID,
+		TransactionId: originalID,
+		Dtid:          dtid,
+	}
+	return tabletError(conn.rpcClient.Call(ctx, "SqlQuery.RollbackPrepared", req, &rpc.Unused{}))
+}
+
+// CreateTransaction records the distributed transaction.
+func (conn *TabletBson) CreateTransaction(ctx context.Context, dtid string, participants []tproto.Participant) error {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return tabletconn.CONN_CLOSED
+	}
+
+	req := &tproto.CreateTransactionRequest{
+		SessionId:    conn.sessionID,
+		Dtid:         dtid,
+		Participants: participants,
+	}
+	return tabletError(conn.rpcClient.Call(ctx, "SqlQuery.CreateTransaction", req, &rpc.Unused{}))
+}
+
+// StartCommit commits the transaction and the distributed transaction.
+func (conn *TabletBson) StartCommit(ctx context.Context, transactionID int64, dtid string) error {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return tabletconn.CONN_CLOSED
+	}
+
+	req := &tproto.DtidRequest{
+		SessionId:     conn.sessionID,
+		TransactionId: transactionID,
+		Dtid:          dtid,
+	}
+	return tabletError(conn.rpcClient.Call(ctx, "SqlQuery.StartCommit", req, &rpc.Unused{}))
+}
+
+// SetRollback rolls back the transaction and the distributed transaction.
+func (conn *TabletBson) SetRollback(ctx context.Context, dtid string, transactionID int64) error {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return tabletconn.CONN_CLOSED
+	}
+
+	req := &tproto.DtidRequest{
+		SessionId:     conn.sessionID,
+		TransactionId: transactionID,
+		Dtid:          dtid,
+	}
+	return tabletError(conn.rpcClient.Call(ctx, "SqlQuery.SetRollback", req, &rpc.Unused{}))
+}
+
+// ConcludeTransaction forgets the distributed transaction.
+func (conn *TabletBson) ConcludeTransaction(ctx context.Context, dtid string) error {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return tabletconn.CONN_CLOSED
+	}
+
+	req := &tproto.DtidRequest{
+		SessionId: conn.sessionID,
+		Dtid:      dtid,
+	}
+	return tabletError(conn.rpcClient.Call(ctx, "SqlQuery.ConcludeTransaction", req, &rpc.Unused{}))
+}
+
+// UnresolvedTransactions returns the abandoned distributed transactions.
+func (conn *TabletBson) UnresolvedTransactions(ctx context.Context, abandonAge time.Duration) ([]tproto.DistributedTx, error) {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return nil, tabletconn.CONN_CLOSED
+	}
+
+	req := &tproto.UnresolvedTransactionsRequest{
+		SessionId:  conn.sessionID,
+		AbandonAge: int64(abandonAge),
+	}
+	reply := new(tproto.UnresolvedTransactionsResult)
+	if err := conn.rpcClient.Call(ctx, "SqlQuery.UnresolvedTransactions", req, reply); err != nil {
+		return nil, tabletError(err)
+	}
+	return reply.Transactions, nil
+}
+
+// LockWaits returns the lock waits between the transactions of the tablet.
+func (conn *TabletBson) LockWaits(ctx context.Context) ([]tproto.LockWait, error) {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return nil, tabletconn.CONN_CLOSED
+	}
+
+	req := &tproto.LockWaitsRequest{
+		SessionId: conn.sessionID,
+	}
+	reply := new(tproto.LockWaitsResult)
+	if err := conn.rpcClient.Call(ctx, "SqlQuery.LockWaits", req, reply); err != nil {
+		return nil, tabletError(err)
+	}
+	return reply.LockWaits, nil
+}
+
 // SplitQuery is the stub for SqlQuery.SplitQuery RPC
 func (conn *TabletBson) SplitQuery(ctx context.Context, query tproto.BoundQuery, splitCount int) (queries []tproto.QuerySplit, err error) {
 	conn.mu.RLock()
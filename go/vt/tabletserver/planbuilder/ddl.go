@@ -17,8 +17,9 @@ func DDLParse(sql string) (plan *DDLPlan) {
 	if err != nil {
 		return &DDLPlan{Action: ""}
 	}
+	// The temporary tables are not part of the schema.
 	stmt, ok := statement.(*sqlparser.DDL)
-	if !ok {
+	if !ok || stmt.Temporary {
 		return &DDLPlan{Action: ""}
 	}
 	return &DDLPlan{
@@ -139,8 +139,13 @@ func analyzeSQL(statement sqlparser.Statement, getTable TableGetter) (plan *Exec
 	case *sqlparser.Set:
 		return analyzeSet(stmt), nil
 	case *sqlparser.DDL:
+		if stmt.Temporary {
+			return &ExecPlan{PlanId: PLAN_TEMPORARY_DDL}, nil
+		}
 		return analyzeDDL(stmt, getTable), nil
-	case *sqlparser.Other:
+	case *sqlparser.Savepoint:
+		return &ExecPlan{PlanId: PLAN_SAVEPOINT}, nil
+	case *sqlparser.Other, *sqlparser.Show, *sqlparser.Explain:
 		return &ExecPlan{PlanId: PLAN_OTHER}, nil
 	}
 	return nil, errors.New("invalid SQL")
@@ -43,6 +43,11 @@ const (
 	PLAN_SELECT_STREAM
 	// PLAN_OTHER is for SHOW, DESCRIBE & EXPLAIN statements
 	PLAN_OTHER
+	// PLAN_SAVEPOINT is for SAVEPOINT, ROLLBACK TO and RELEASE SAVEPOINT
+	PLAN_SAVEPOINT
+	// PLAN_TEMPORARY_DDL is for CREATE and DROP TEMPORARY TABLE,
+	// which are only allowed on reserved connections
+	PLAN_TEMPORARY_DDL
 	NumPlans
 )
 
@@ -61,6 +66,8 @@ var planName = []string{
 	"DDL",
 	"SELECT_STREAM",
 	"OTHER",
+	"SAVEPOINT",
+	"TEMPORARY_DDL",
 }
 
 func (pt PlanType) String() string {
@@ -105,6 +112,8 @@ var tableAclRoles = map[PlanType]tableacl.Role{
 	PLAN_DDL:             tableacl.ADMIN,
 	PLAN_SELECT_STREAM:   tableacl.READER,
 	PLAN_OTHER:           tableacl.ADMIN,
+	PLAN_SAVEPOINT:       tableacl.READER,
+	PLAN_TEMPORARY_DDL:   tableacl.WRITER,
 }
 
 type ReasonType int
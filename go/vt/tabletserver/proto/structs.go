@@ -11,6 +11,11 @@ import (
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 )
 
+// RoutingKeyspaceId is the bind var through which vtgate supplies
+// the keyspace id of the rows changed by a DML. vttablet appends it
+// to the executed statement as a routing comment.
+const RoutingKeyspaceId = "_routingKeyspaceId"
+
 type SessionParams struct {
 	Keyspace string
 	Shard    string
@@ -74,6 +79,25 @@ type Session struct {
 	TransactionId int64
 }
 
+// BeginRequest is the request to begin a transaction. Isolation
+// is the isolation level of the transaction, like "READ COMMITTED".
+// The isolation level of the connection is used if it's empty.
+// ReadOnly begins a read only transaction. If ReservedId is set,
+// the transaction begins on that reserved connection.
+type BeginRequest struct {
+	SessionId  int64
+	Isolation  string
+	ReadOnly   bool
+	ReservedId int64
+}
+
+// ReservedInfo identifies a connection reserved for a client
+// session, which keeps its session state across queries.
+type ReservedInfo struct {
+	SessionId  int64
+	ReservedId int64
+}
+
 type TransactionInfo struct {
 	TransactionId int64
 }
@@ -96,3 +120,79 @@ type QuerySplit struct {
 type SplitQueryResult struct {
 	Queries []QuerySplit
 }
+
+// States of a distributed transaction.
+const (
+	// DT_PREPARE means the participants are being prepared.
+	DT_PREPARE = "PREPARE"
+	// DT_COMMIT means the transaction is committed, and the
+	// prepared participants must be committed.
+	DT_COMMIT = "COMMIT"
+	// DT_ROLLBACK means the transaction is rolled back, and the
+	// prepared participants must be rolled back.
+	DT_ROLLBACK = "ROLLBACK"
+)
+
+// Participant is a shard that takes part in a distributed transaction.
+type Participant struct {
+	Keyspace string
+	Shard    string
+}
+
+// DistributedTx is the metadata of a distributed transaction, as
+// kept by the shard that coordinates its two-phase commit.
+// TimeCreated is in nanoseconds since the epoch.
+type DistributedTx struct {
+	Dtid         string
+	State        string
+	TimeCreated  int64
+	Participants []Participant
+}
+
+// DtidRequest is the request of the two-phase commit calls that
+// act on a distributed transaction. TransactionId is only used by
+// the calls that also act on a local transaction.
+type DtidRequest struct {
+	SessionId     int64
+	TransactionId int64
+	Dtid          string
+}
+
+// CreateTransactionRequest is the request to record a new
+// distributed transaction on the shard that coordinates it.
+type CreateTransactionRequest struct {
+	SessionId    int64
+	Dtid         string
+	Participants []Participant
+}
+
+// UnresolvedTransactionsRequest is the request for the distributed
+// transactions that are older than AbandonAge nanoseconds.
+type UnresolvedTransactionsRequest struct {
+	SessionId  int64
+	AbandonAge int64
+}
+
+// UnresolvedTransactionsResult is the result of an
+// UnresolvedTransactionsRequest.
+type UnresolvedTransactionsResult struct {
+	Transactions []DistributedTx
+}
+
+// LockWait is a transaction blocked on a row lock held
+// by another transaction of the same tablet.
+type LockWait struct {
+	WaitingId  int64
+	BlockingId int64
+}
+
+// LockWaitsRequest is the request for the lock waits
+// between the transactions of a tablet.
+type LockWaitsRequest struct {
+	SessionId int64
+}
+
+// LockWaitsResult is the result of a LockWaitsRequest.
+type LockWaitsResult struct {
+	LockWaits []LockWait
+}
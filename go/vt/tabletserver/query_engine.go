@@ -49,6 +49,8 @@ type QueryEngine struct {
 
 	// Services
 	txPool       *TxPool
+	reservedPool *ReservedPool
+	twoPC        *TwoPC
 	consolidator *Consolidator
 	invalidator  *RowcacheInvalidator
 	streamQList  *QueryList
@@ -151,6 +153,15 @@ func NewQueryEngine(config Config) *QueryEngine {
 		time.Duration(config.TxPoolTimeout*1e9),
 		time.Duration(config.IdleTimeout*1e9),
 	)
+	qe.reservedPool = NewReservedPool(
+		"ReservedPool",
+		config.ReservedPoolSize,
+		time.Duration(config.ReservedTimeout*1e9),
+		time.Duration(config.TxPoolTimeout*1e9),
+		time.Duration(config.IdleTimeout*1e9),
+		&qe.txPool.lastId,
+	)
+	qe.twoPC = NewTwoPC(config.TwoPCEnable, time.Duration(config.IdleTimeout*1e9))
 	qe.connKiller = NewConnectionKiller(1, time.Duration(config.IdleTimeout*1e9))
 	qe.consolidator = NewConsolidator()
 	qe.invalidator = NewRowcacheInvalidator(qe)
@@ -236,6 +247,10 @@ func (qe *QueryEngine) Open(dbconfigs *dbconfigs.DBConfigs, schemaOverrides []Sc
 	qe.connPool.Open(connFactory)
 	qe.streamConnPool.Open(connFactory)
 	qe.txPool.Open(connFactory)
+	if qe.twoPC.Open(dbaConnFactory) {
+		PrepareAllFromRedo(qe)
+	}
+	qe.reservedPool.Open(connFactory)
 	qe.connKiller.Open(dbaConnFactory)
 }
 
@@ -272,6 +287,8 @@ func (qe *QueryEngine) Close() {
 	qe.tasks.Wait()
 	// Close in reverse order of Open.
 	qe.connKiller.Close()
+	qe.reservedPool.Close()
+	qe.twoPC.Close()
 	qe.txPool.Close()
 	qe.streamConnPool.Close()
 	qe.connPool.Close()
@@ -6,6 +6,7 @@ package tabletserver
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	log "github.com/golang/glog"
@@ -50,6 +51,9 @@ func (qre *QueryExecutor) Execute() (reply *mproto.QueryResult) {
 
 	qre.checkPermissions()
 
+	if qre.transactionID != 0 && qre.qe.reservedPool.IsReserved(qre.transactionID) {
+		return qre.execReserved()
+	}
 	if qre.plan.PlanId == planbuilder.PLAN_DDL {
 		return qre.execDDL()
 	}
@@ -77,8 +81,10 @@ func (qre *QueryExecutor) Execute() (reply *mproto.QueryResult) {
 			reply = qre.execDMLPK(conn, invalidator)
 		case planbuilder.PLAN_DML_SUBQUERY:
 			reply = qre.execDMLSubquery(conn, invalidator)
-		case planbuilder.PLAN_OTHER:
+		case planbuilder.PLAN_OTHER, planbuilder.PLAN_SAVEPOINT:
 			reply = qre.execSQL(conn, qre.query, true)
+		case planbuilder.PLAN_TEMPORARY_DDL:
+			panic(NewTabletError(FAIL, "Temporary tables are only allowed on reserved connections"))
 		default: // select or set in a transaction, just count as select
 			reply = qre.execDirect(conn)
 		}
@@ -99,6 +105,10 @@ func (qre *QueryExecutor) Execute() (reply *mproto.QueryResult) {
 			conn := qre.getConn(qre.qe.connPool)
 			defer conn.Recycle()
 			reply = qre.execSQL(conn, qre.query, true)
+		case planbuilder.PLAN_SAVEPOINT:
+			panic(NewTabletError(NOT_IN_TX, "Savepoints not allowed outside of transactions"))
+		case planbuilder.PLAN_TEMPORARY_DDL:
+			panic(NewTabletError(FAIL, "Temporary tables are only allowed on reserved connections"))
 		default:
 			panic(NewTabletError(NOT_IN_TX, "DMLs not allowed outside of transactions"))
 		}
@@ -106,6 +116,33 @@ func (qre *QueryExecutor) Execute() (reply *mproto.QueryResult) {
 	return reply
 }
 
+// execReserved executes the query on a reserved connection,
+// outside of a transaction. Like in a transaction, the queries
+// bypass the rowcache and the consolidator, so that they see
+// the session state of the connection.
+func (qre *QueryExecutor) execReserved() *mproto.QueryResult {
+	conn := qre.qe.reservedPool.Get(qre.transactionID)
+	defer conn.Recycle()
+	switch qre.plan.PlanId {
+	case planbuilder.PLAN_SET:
+		if strings.HasPrefix(qre.plan.SetKey, "vt_") {
+			return qre.execSet()
+		}
+		return qre.directFetch(conn, qre.plan.FullQuery, qre.bindVars, nil)
+	case planbuilder.PLAN_OTHER, planbuilder.PLAN_TEMPORARY_DDL:
+		return qre.execSQL(conn, qre.query, true)
+	case planbuilder.PLAN_DDL:
+		panic(NewTabletError(FAIL, "DDLs not allowed on reserved connections"))
+	case planbuilder.PLAN_SAVEPOINT:
+		panic(NewTabletError(NOT_IN_TX, "Savepoints not allowed outside of transactions"))
+	case planbuilder.PLAN_PASS_DML, planbuilder.PLAN_DML_PK, planbuilder.PLAN_DML_SUBQUERY,
+		planbuilder.PLAN_INSERT_PK, planbuilder.PLAN_INSERT_SUBQUERY:
+		panic(NewTabletError(NOT_IN_TX, "DMLs not allowed outside of transactions"))
+	default:
+		return qre.execDirect(conn)
+	}
+}
+
 // Stream performs a streaming query execution.
 func (qre *QueryExecutor) Stream(sendReply func(*mproto.QueryResult) error) {
 	qre.logStats.OriginalSql = qre.query
@@ -156,7 +193,7 @@ func (qre *QueryExecutor) execDDL() *mproto.QueryResult {
 		panic(NewTabletError(FAIL, "DDL is not understood"))
 	}
 
-	txid := qre.qe.txPool.Begin()
+	txid := qre.qe.txPool.Begin("", false)
 	defer qre.qe.txPool.SafeCommit(txid)
 
 	// Stolen from Execute
@@ -454,6 +491,8 @@ func (qre *QueryExecutor) execSet() (result *mproto.QueryResult) {
 	case "vt_txpool_timeout":
 		t := getDuration(qre.plan.SetValue)
 		qre.qe.txPool.SetPoolTimeout(t)
+	case "vt_reserved_timeout":
+		qre.qe.reservedPool.SetTimeout(getDuration(qre.plan.SetValue))
 	default:
 		conn := qre.getConn(qre.qe.connPool)
 		defer conn.Recycle()
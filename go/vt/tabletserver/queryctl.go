@@ -41,9 +41,12 @@ func init() {
 	flag.Float64Var(&qsConfig.QueryTimeout, "queryserver-config-query-timeout", DefaultQsConfig.QueryTimeout, "query server query timeout")
 	flag.Float64Var(&qsConfig.TxPoolTimeout, "queryserver-config-txpool-timeout", DefaultQsConfig.TxPoolTimeout, "query server transaction pool timeout")
 	flag.Float64Var(&qsConfig.IdleTimeout, "queryserver-config-idle-timeout", DefaultQsConfig.IdleTimeout, "query server idle timeout")
+	flag.IntVar(&qsConfig.ReservedPoolSize, "queryserver-config-reserved-pool-size", DefaultQsConfig.ReservedPoolSize, "query server maximum number of connections reserved for client sessions")
+	flag.Float64Var(&qsConfig.ReservedTimeout, "queryserver-config-reserved-timeout", DefaultQsConfig.ReservedTimeout, "query server time after which an idle reserved connection is closed")
 	flag.Float64Var(&qsConfig.SpotCheckRatio, "queryserver-config-spot-check-ratio", DefaultQsConfig.SpotCheckRatio, "query server rowcache spot check frequency")
 	flag.BoolVar(&qsConfig.StrictMode, "queryserver-config-strict-mode", DefaultQsConfig.StrictMode, "allow only predictable DMLs and enforces MySQL's STRICT_TRANS_TABLES")
 	flag.BoolVar(&qsConfig.StrictTableAcl, "queryserver-config-strict-table-acl", DefaultQsConfig.StrictTableAcl, "only allow queries that pass table acl checks")
+	flag.BoolVar(&qsConfig.TwoPCEnable, "queryserver-config-twopc-enable", DefaultQsConfig.TwoPCEnable, "allow two-phase commits, whose state is kept in the _vt database")
 	flag.StringVar(&qsConfig.RowCache.Binary, "rowcache-bin", DefaultQsConfig.RowCache.Binary, "rowcache binary file")
 	flag.IntVar(&qsConfig.RowCache.Memory, "rowcache-memory", DefaultQsConfig.RowCache.Memory, "rowcache max memory usage in MB")
 	flag.StringVar(&qsConfig.RowCache.Socket, "rowcache-socket", DefaultQsConfig.RowCache.Socket, "rowcache socket path to listen on")
@@ -104,10 +107,13 @@ type Config struct {
 	QueryTimeout       float64
 	TxPoolTimeout      float64
 	IdleTimeout        float64
+	ReservedPoolSize   int
+	ReservedTimeout    float64
 	RowCache           RowCacheConfig
 	SpotCheckRatio     float64
 	StrictMode         bool
 	StrictTableAcl     bool
+	TwoPCEnable        bool
 }
 
 // DefaultQSConfig is the default value for the query service config.
@@ -130,11 +136,14 @@ var DefaultQsConfig = Config{
 	QueryTimeout:       0,
 	TxPoolTimeout:      1,
 	IdleTimeout:        30 * 60,
+	ReservedPoolSize:   20,
+	ReservedTimeout:    5 * 60,
 	StreamBufferSize:   32 * 1024,
 	RowCache:           RowCacheConfig{Memory: -1, TcpPort: -1, Connections: -1, Threads: -1},
 	SpotCheckRatio:     0,
 	StrictMode:         true,
 	StrictTableAcl:     false,
+	TwoPCEnable:        false,
 }
 
 var qsConfig Config
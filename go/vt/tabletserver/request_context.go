@@ -93,6 +93,7 @@ func (rqc *RequestContext) generateFinalSql(parsedQuery *sqlparser.ParsedQuery,
 	}
 	// undo hack done by stripTrailing
 	sql = restoreTrailing(sql, bindVars)
+	sql = addRoutingComment(sql, bindVars)
 	return hack.String(sql)
 }
 
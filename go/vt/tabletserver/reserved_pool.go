@@ -0,0 +1,181 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/pools"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/timer"
+	"github.com/youtube/vitess/go/vt/dbconnpool"
+)
+
+// ReservedPool keeps the connections that are reserved for a client
+// session, so that the session state of the connection, like user
+// variables, temporary tables and named locks, lasts across queries.
+// A reserved connection that's not used for longer than the idle
+// timeout is closed, which drops its session state.
+//
+// Reserved connections share their ids with the transactions of
+// the TxPool: Execute accepts either. A transaction can begin on
+// a reserved connection, which can't be used outside of it until
+// the transaction is concluded.
+type ReservedPool struct {
+	pool        *dbconnpool.ConnectionPool
+	activePool  *pools.Numbered
+	lastId      *sync2.AtomicInt64
+	timeout     sync2.AtomicDuration
+	poolTimeout sync2.AtomicDuration
+	ticks       *timer.Timer
+	stats       *stats.Timings
+
+	mu  sync.Mutex
+	ids map[int64]bool
+}
+
+// NewReservedPool creates a ReservedPool of capacity connections.
+// timeout is the idle timeout of the reserved connections, and
+// idleTimeout the one of the connections of the pool. lastId is
+// the id generator shared with the TxPool.
+func NewReservedPool(name string, capacity int, timeout, poolTimeout, idleTimeout time.Duration, lastId *sync2.AtomicInt64) *ReservedPool {
+	rp := &ReservedPool{
+		pool:        dbconnpool.NewConnectionPool(name, capacity, idleTimeout),
+		activePool:  pools.NewNumbered(),
+		lastId:      lastId,
+		timeout:     sync2.AtomicDuration(timeout),
+		poolTimeout: sync2.AtomicDuration(poolTimeout),
+		ticks:       timer.NewTimer(timeout / 10),
+		stats:       stats.NewTimings("ReservedConnections"),
+		ids:         make(map[int64]bool),
+	}
+	// Careful: pool also exports name+"xxx" vars,
+	// but we know it doesn't export Active or Timeout.
+	stats.Publish(name+"Active", stats.IntFunc(rp.activePool.Size))
+	stats.Publish(name+"Timeout", stats.DurationFunc(rp.timeout.Get))
+	return rp
+}
+
+func (rp *ReservedPool) Open(connFactory dbconnpool.CreateConnectionFunc) {
+	rp.pool.Open(connFactory)
+	rp.ticks.Start(func() { rp.IdleKiller() })
+}
+
+func (rp *ReservedPool) Close() {
+	rp.ticks.Stop()
+	for _, v := range rp.activePool.GetOutdated(time.Duration(0), "for closing") {
+		conn := v.(*ReservedConnection)
+		log.Infof("releasing reserved connection %d for shutdown", conn.ReservedID)
+		conn.Close()
+		conn.discard("Closed")
+	}
+	rp.pool.Close()
+}
+
+// IdleKiller closes the reserved connections that
+// were idle for longer than the timeout.
+func (rp *ReservedPool) IdleKiller() {
+	defer logError()
+	for _, v := range rp.activePool.GetIdle(rp.timeout.Get(), "for idle kill") {
+		conn := v.(*ReservedConnection)
+		log.Infof("closing idle reserved connection %d", conn.ReservedID)
+		killStats.Add("ReservedConnections", 1)
+		conn.Close()
+		conn.discard("Killed")
+	}
+}
+
+// Reserve reserves a connection, and returns its id.
+func (rp *ReservedPool) Reserve() int64 {
+	conn, err := rp.pool.Get(rp.poolTimeout.Get())
+	if err != nil {
+		switch err {
+		case dbconnpool.CONN_POOL_CLOSED_ERR:
+			panic(connPoolClosedErr)
+		case pools.TIMEOUT_ERR:
+			panic(NewTabletError(TX_POOL_FULL, "Reserved connection limit exceeded"))
+		}
+		panic(NewTabletErrorSql(FATAL, err))
+	}
+	reservedID := rp.lastId.Add(1)
+	rp.mu.Lock()
+	rp.ids[reservedID] = true
+	rp.mu.Unlock()
+	rp.activePool.Register(reservedID, &ReservedConnection{
+		PoolConnection: conn,
+		ReservedID:     reservedID,
+		pool:           rp,
+		StartTime:      time.Now(),
+	})
+	return reservedID
+}
+
+// IsReserved returns true if id is a reserved connection.
+func (rp *ReservedPool) IsReserved(id int64) bool {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	return rp.ids[id]
+}
+
+// Get locks the reserved connection reservedID for use.
+// You must call Recycle on the ReservedConnection once done.
+func (rp *ReservedPool) Get(reservedID int64) *ReservedConnection {
+	v, err := rp.activePool.Get(reservedID, "for query")
+	if err != nil {
+		panic(NewTabletError(NOT_IN_TX, "Reserved connection %d: %v", reservedID, err))
+	}
+	return v.(*ReservedConnection)
+}
+
+// Release returns the reserved connection reservedID to the pool.
+// Its session state is dropped.
+func (rp *ReservedPool) Release(reservedID int64) {
+	conn := rp.Get(reservedID)
+	// The session state can't be reset on the connection,
+	// so it's closed and the pool opens a new one.
+	conn.Close()
+	conn.discard("Released")
+}
+
+func (rp *ReservedPool) Timeout() time.Duration {
+	return rp.timeout.Get()
+}
+
+func (rp *ReservedPool) SetTimeout(timeout time.Duration) {
+	rp.timeout.Set(timeout)
+	rp.ticks.SetInterval(timeout / 10)
+}
+
+// ReservedConnection is a connection reserved for a client session.
+// It can be used as the connection of a transaction: Recycle puts it
+// back in the reserved pool instead of the connection pool.
+type ReservedConnection struct {
+	dbconnpool.PoolConnection
+	ReservedID int64
+	pool       *ReservedPool
+	StartTime  time.Time
+}
+
+func (rc *ReservedConnection) Recycle() {
+	if rc.IsClosed() {
+		rc.discard("Closed")
+	} else {
+		rc.pool.activePool.Put(rc.ReservedID)
+	}
+}
+
+func (rc *ReservedConnection) discard(reason string) {
+	rc.pool.stats.Add(reason, time.Now().Sub(rc.StartTime))
+	rc.pool.activePool.Unregister(rc.ReservedID)
+	rc.pool.mu.Lock()
+	delete(rc.pool.ids, rc.ReservedID)
+	rc.pool.mu.Unlock()
+	rc.PoolConnection.Recycle()
+	// Ensure PoolConnection won't be accessed after Recycle.
+	rc.PoolConnection = nil
+}
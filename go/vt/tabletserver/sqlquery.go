@@ -236,7 +236,7 @@ func (sq *SqlQuery) GetSessionId(sessionParams *proto.SessionParams, sessionInfo
 }
 
 // Begin starts a new transaction. This is allowed only if the state is SERVING.
-func (sq *SqlQuery) Begin(context context.Context, session *proto.Session, txInfo *proto.TransactionInfo) (err error) {
+func (sq *SqlQuery) Begin(context context.Context, req *proto.BeginRequest, txInfo *proto.TransactionInfo) (err error) {
 	logStats := newSqlQueryStats("Begin", context)
 	logStats.OriginalSql = "begin"
 	sq.mu.RLock()
@@ -246,15 +246,52 @@ func (sq *SqlQuery) Begin(context context.Context, session *proto.Session, txInf
 		return NewTabletError(RETRY, "cannot begin transaction in state %s", sq.GetState())
 	}
 	// state is SERVING
-	if session.SessionId == 0 || session.SessionId != sq.sessionId {
-		return NewTabletError(RETRY, "Invalid session Id %v", session.SessionId)
+	if req.SessionId == 0 || req.SessionId != sq.sessionId {
+		return NewTabletError(RETRY, "Invalid session Id %v", req.SessionId)
 	}
 	defer queryStats.Record("BEGIN", time.Now())
-	txInfo.TransactionId = sq.qe.txPool.Begin()
+	if req.ReservedId != 0 {
+		conn := sq.qe.reservedPool.Get(req.ReservedId)
+		txInfo.TransactionId = sq.qe.txPool.BeginReserved(conn, req.Isolation, req.ReadOnly)
+	} else {
+		txInfo.TransactionId = sq.qe.txPool.Begin(req.Isolation, req.ReadOnly)
+	}
 	logStats.TransactionID = txInfo.TransactionId
 	return nil
 }
 
+// Reserve reserves a connection for the session of the client.
+// Queries sent with its id as transaction id are executed on it.
+func (sq *SqlQuery) Reserve(context context.Context, session *proto.Session, reservedInfo *proto.ReservedInfo) (err error) {
+	logStats := newSqlQueryStats("Reserve", context)
+	logStats.OriginalSql = "reserve"
+	if err = sq.startRequest(session.SessionId, false); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, logStats)
+	defer queryStats.Record("RESERVE", time.Now())
+	reservedInfo.SessionId = session.SessionId
+	reservedInfo.ReservedId = sq.qe.reservedPool.Reserve()
+	logStats.TransactionID = reservedInfo.ReservedId
+	return nil
+}
+
+// Release releases the reserved connection of req.
+func (sq *SqlQuery) Release(context context.Context, req *proto.ReservedInfo) (err error) {
+	logStats := newSqlQueryStats("Release", context)
+	logStats.OriginalSql = "release"
+	logStats.TransactionID = req.ReservedId
+	if err = sq.startRequest(req.SessionId, true); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, logStats)
+	defer queryStats.Record("RELEASE", time.Now())
+	sq.qe.reservedPool.Release(req.ReservedId)
+	return nil
+}
+
 // Commit commits the specified transaction.
 func (sq *SqlQuery) Commit(context context.Context, session *proto.Session) (err error) {
 	logStats := newSqlQueryStats("Commit", context)
@@ -285,6 +322,134 @@ func (sq *SqlQuery) Rollback(context context.Context, session *proto.Session) (e
 	return nil
 }
 
+// Prepare prepares the specified transaction for a two-phase
+// commit of the distributed transaction req.Dtid.
+func (sq *SqlQuery) Prepare(context context.Context, req *proto.DtidRequest) (err error) {
+	logStats := newSqlQueryStats("Prepare", context)
+	logStats.OriginalSql = "prepare"
+	logStats.TransactionID = req.TransactionId
+	if err = sq.startRequest(req.SessionId, true); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, logStats)
+	defer queryStats.Record("PREPARE", time.Now())
+	Prepare(sq.qe, req.TransactionId, req.Dtid)
+	return nil
+}
+
+// CommitPrepared commits the transaction prepared for req.Dtid.
+func (sq *SqlQuery) CommitPrepared(context context.Context, req *proto.DtidRequest) (err error) {
+	logStats := newSqlQueryStats("CommitPrepared", context)
+	logStats.OriginalSql = "commit prepared"
+	if err = sq.startRequest(req.SessionId, true); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, logStats)
+	CommitPrepared(logStats, sq.qe, req.Dtid)
+	return nil
+}
+
+// RollbackPrepared rolls back the transaction prepared for req.Dtid,
+// or req.TransactionId if it wasn't prepared yet.
+func (sq *SqlQuery) RollbackPrepared(context context.Context, req *proto.DtidRequest) (err error) {
+	logStats := newSqlQueryStats("RollbackPrepared", context)
+	logStats.OriginalSql = "rollback prepared"
+	logStats.TransactionID = req.TransactionId
+	if err = sq.startRequest(req.SessionId, true); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, logStats)
+	defer queryStats.Record("ROLLBACK_PREPARED", time.Now())
+	RollbackPrepared(sq.qe, req.Dtid, req.TransactionId)
+	return nil
+}
+
+// CreateTransaction records the distributed transaction req.Dtid
+// on the shard that coordinates its two-phase commit.
+func (sq *SqlQuery) CreateTransaction(context context.Context, req *proto.CreateTransactionRequest) (err error) {
+	logStats := newSqlQueryStats("CreateTransaction", context)
+	logStats.OriginalSql = "create transaction"
+	if err = sq.startRequest(req.SessionId, true); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, logStats)
+	sq.qe.twoPC.CreateTransaction(req.Dtid, req.Participants)
+	return nil
+}
+
+// StartCommit commits the specified transaction, and with it
+// the decision to commit the distributed transaction req.Dtid.
+func (sq *SqlQuery) StartCommit(context context.Context, req *proto.DtidRequest) (err error) {
+	logStats := newSqlQueryStats("StartCommit", context)
+	logStats.OriginalSql = "start commit"
+	logStats.TransactionID = req.TransactionId
+	if err = sq.startRequest(req.SessionId, true); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, logStats)
+	StartCommit(logStats, sq.qe, req.TransactionId, req.Dtid)
+	return nil
+}
+
+// SetRollback records the decision to roll back the distributed
+// transaction req.Dtid, and rolls back the specified transaction.
+func (sq *SqlQuery) SetRollback(context context.Context, req *proto.DtidRequest) (err error) {
+	logStats := newSqlQueryStats("SetRollback", context)
+	logStats.OriginalSql = "set rollback"
+	logStats.TransactionID = req.TransactionId
+	if err = sq.startRequest(req.SessionId, true); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, logStats)
+	sq.qe.twoPC.SetRollback(req.Dtid)
+	sq.qe.txPool.RollbackPrepared(req.Dtid, req.TransactionId)
+	return nil
+}
+
+// ConcludeTransaction forgets the distributed transaction req.Dtid
+// once all its participants are resolved.
+func (sq *SqlQuery) ConcludeTransaction(context context.Context, req *proto.DtidRequest) (err error) {
+	logStats := newSqlQueryStats("ConcludeTransaction", context)
+	logStats.OriginalSql = "conclude transaction"
+	if err = sq.startRequest(req.SessionId, true); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, logStats)
+	sq.qe.twoPC.Conclude(req.Dtid)
+	return nil
+}
+
+// UnresolvedTransactions returns the distributed transactions
+// coordinated by this shard that are older than req.AbandonAge.
+func (sq *SqlQuery) UnresolvedTransactions(context context.Context, req *proto.UnresolvedTransactionsRequest, reply *proto.UnresolvedTransactionsResult) (err error) {
+	if err = sq.startRequest(req.SessionId, true); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, nil)
+	reply.Transactions = sq.qe.twoPC.Unresolved(time.Duration(req.AbandonAge))
+	return nil
+}
+
+// LockWaits returns the transactions of the tablet that
+// wait for a row lock held by another of its transactions.
+func (sq *SqlQuery) LockWaits(context context.Context, req *proto.LockWaitsRequest, reply *proto.LockWaitsResult) (err error) {
+	if err = sq.startRequest(req.SessionId, true); err != nil {
+		return err
+	}
+	defer sq.endRequest()
+	defer handleError(&err, nil)
+	reply.LockWaits = sq.qe.txPool.LockWaits(sq.qe.connKiller.connPool)
+	return nil
+}
+
 // handleExecError handles panics during query execution and sets
 // the supplied error return value.
 func handleExecError(query *proto.Query, err *error, logStats *SQLQueryStats) {
@@ -411,7 +576,7 @@ func (sq *SqlQuery) ExecuteBatch(context context.Context, queryList *proto.Query
 				panic(NewTabletError(FAIL, "Nested transactions disallowed"))
 			}
 			var txInfo proto.TransactionInfo
-			if err = sq.Begin(context, &session, &txInfo); err != nil {
+			if err = sq.Begin(context, &proto.BeginRequest{SessionId: session.SessionId}, &txInfo); err != nil {
 				return err
 			}
 			session.TransactionId = txInfo.TransactionId
@@ -70,11 +70,37 @@ type TabletConn interface {
 	// to see if the stream ended normally or due to a failure.
 	StreamExecute(context context.Context, query string, bindVars map[string]interface{}, transactionId int64) (<-chan *mproto.QueryResult, ErrFunc, error)
 
-	// Transaction support
-	Begin(context context.Context) (transactionId int64, err error)
+	// Transaction support. Begin uses the isolation level and the
+	// access mode of vttablet, unless isolation or readOnly are set.
+	// The transaction begins on the reserved connection reservedId
+	// if it's not 0.
+	Begin(context context.Context, isolation string, readOnly bool, reservedId int64) (transactionId int64, err error)
 	Commit(context context.Context, transactionId int64) error
 	Rollback(context context.Context, transactionId int64) error
 
+	// Reserved connections keep the session state of a client, like
+	// its user variables, temporary tables and named locks. Queries
+	// are executed on a reserved connection by passing its id as
+	// their transactionId.
+	Reserve(context context.Context) (reservedId int64, err error)
+	Release(context context.Context, reservedId int64) error
+
+	// Two-phase commit support. Prepare, CommitPrepared and
+	// RollbackPrepared act on the participants of a distributed
+	// transaction. The other calls act on the shard that coordinates it.
+	Prepare(context context.Context, transactionId int64, dtid string) error
+	CommitPrepared(context context.Context, dtid string) error
+	RollbackPrepared(context context.Context, dtid string, originalId int64) error
+	CreateTransaction(context context.Context, dtid string, participants []tproto.Participant) error
+	StartCommit(context context.Context, transactionId int64, dtid string) error
+	SetRollback(context context.Context, dtid string, transactionId int64) error
+	ConcludeTransaction(context context.Context, dtid string) error
+	UnresolvedTransactions(context context.Context, abandonAge time.Duration) ([]tproto.DistributedTx, error)
+
+	// LockWaits returns the transactions of the tablet that
+	// wait for a row lock held by another of its transactions.
+	LockWaits(context context.Context) ([]tproto.LockWait, error)
+
 	// Close must be called for releasing resources.
 	Close()
 
@@ -4,12 +4,129 @@
 
 package tabletserver
 
-import "time"
+import (
+	"time"
+
+	log "github.com/golang/glog"
+)
 
 // Commit commits the specified transaction.
 func Commit(logStats *SQLQueryStats, qe *QueryEngine, transactionID int64) {
 	defer queryStats.Record("COMMIT", time.Now())
 	dirtyTables, err := qe.txPool.SafeCommit(transactionID)
+	invalidateDirtyKeys(logStats, qe, dirtyTables)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Prepare prepares the specified transaction for a two-phase commit
+// under dtid. Its statements are saved in the redo log first, so that
+// it can be prepared again if vttablet restarts.
+func Prepare(qe *QueryEngine, transactionID int64, dtid string) {
+	conn := qe.txPool.Get(transactionID)
+	if _, ok := conn.PoolConnection.(*ReservedConnection); ok {
+		conn.Recycle()
+		panic(NewTabletError(FAIL, "Transaction %d uses a reserved connection, it cannot be prepared", transactionID))
+	}
+	defer func() {
+		if x := recover(); x != nil {
+			conn.Recycle()
+			panic(x)
+		}
+	}()
+	qe.twoPC.SaveRedo(dtid, conn.Redo)
+	qe.txPool.Prepare(conn, dtid)
+}
+
+// CommitPrepared commits the transaction prepared under dtid. If it
+// was already committed, it succeeds. If vttablet restarted or failed
+// to commit it, it's prepared again from the redo log first.
+func CommitPrepared(logStats *SQLQueryStats, qe *QueryEngine, dtid string) {
+	defer queryStats.Record("COMMIT_PREPARED", time.Now())
+	if !qe.txPool.IsPrepared(dtid) {
+		switch state := qe.twoPC.RedoState(dtid); state {
+		case redoCommitted:
+			return
+		case redoPrepared:
+			if err := prepareFromRedo(qe, dtid, qe.twoPC.ReadRedo(dtid)); err != nil {
+				panic(err)
+			}
+		case "":
+			panic(NewTabletError(FAIL, "Transaction %s is not prepared", dtid))
+		default:
+			panic(NewTabletError(FAIL, "Transaction %s is in state %s in the redo log, it cannot be committed", dtid, state))
+		}
+	}
+	dirtyTables, err := qe.txPool.SafeCommitPrepared(dtid, qe.twoPC.MarkCommitted)
+	invalidateDirtyKeys(logStats, qe, dirtyTables)
+	if err != nil {
+		panic(err)
+	}
+	qe.twoPC.PurgeCommitted()
+}
+
+// RollbackPrepared rolls back the transaction prepared under dtid,
+// or originalID if it wasn't prepared yet, and removes it from
+// the redo log. It fails if dtid is already committed.
+func RollbackPrepared(qe *QueryEngine, dtid string, originalID int64) {
+	qe.twoPC.DeleteRedo(dtid)
+	qe.txPool.RollbackPrepared(dtid, originalID)
+}
+
+// StartCommit commits the specified transaction of the coordinator
+// of dtid, and records the decision to commit dtid in it. If the
+// commit fails, dtid is moved to the ROLLBACK state.
+func StartCommit(logStats *SQLQueryStats, qe *QueryEngine, transactionID int64, dtid string) {
+	conn := qe.txPool.Get(transactionID)
+	func() {
+		defer conn.Recycle()
+		qe.twoPC.StartCommit(conn, dtid)
+	}()
+	defer func() {
+		if x := recover(); x != nil {
+			func() {
+				defer logError()
+				qe.twoPC.SetRollback(dtid)
+			}()
+			panic(x)
+		}
+	}()
+	Commit(logStats, qe, transactionID)
+}
+
+// PrepareAllFromRedo prepares again the transactions of the redo log.
+func PrepareAllFromRedo(qe *QueryEngine) {
+	for dtid, statements := range qe.twoPC.ReadAllRedo() {
+		if err := prepareFromRedo(qe, dtid, statements); err == nil {
+			log.Infof("prepared transaction %s again from the redo log", dtid)
+		}
+	}
+}
+
+// prepareFromRedo prepares dtid again with the statements of its redo
+// log. If they fail, dtid is marked failed: it needs to be resolved
+// by hand.
+func prepareFromRedo(qe *QueryEngine, dtid string, statements []string) (err error) {
+	defer handleError(&err, nil)
+	defer func() {
+		if x := recover(); x != nil {
+			log.Errorf("could not prepare transaction %s again from the redo log: %v", dtid, x)
+			internalErrors.Add("RedoFailed", 1)
+			func() {
+				defer logError()
+				qe.twoPC.MarkFailed(dtid)
+			}()
+			panic(x)
+		}
+	}()
+	qe.txPool.PrepareStatements(dtid, statements)
+	return nil
+}
+
+// invalidateDirtyKeys removes the rows changed by
+// a transaction from the rowcache.
+func invalidateDirtyKeys(logStats *SQLQueryStats, qe *QueryEngine, dirtyTables map[string]DirtyKeys) {
 	for tableName, invalidList := range dirtyTables {
 		tableInfo := qe.schemaInfo.GetTable(tableName)
 		if tableInfo == nil {
@@ -23,7 +140,4 @@ func Commit(logStats *SQLQueryStats, qe *QueryEngine, transactionID int64) {
 		logStats.CacheInvalidations += invalidations
 		tableInfo.invalidations.Add(invalidations)
 	}
-	if err != nil {
-		panic(err)
-	}
 }
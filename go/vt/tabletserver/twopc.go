@@ -0,0 +1,392 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/vt/dbconnpool"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	"github.com/youtube/vitess/go/vt/tabletserver/proto"
+)
+
+// The states of a transaction in the redo log.
+const (
+	redoPrepared  = "PREPARED"
+	redoCommitted = "COMMITTED"
+	// redoFailed is the state of a transaction that
+	// could not be prepared again from its statements.
+	redoFailed = "FAILED"
+)
+
+// redoRetention is how long the redo log remembers that a transaction
+// was committed, so that CommitPrepared can be retried until then.
+const redoRetention = 24 * time.Hour
+
+var twoPCSchema = []string{
+	"create database if not exists _vt",
+	`create table if not exists _vt.redo_log_transaction(
+  dtid varbinary(512) not null,
+  state varbinary(20) not null,
+  time_created bigint not null,
+  primary key(dtid)
+) engine=InnoDB`,
+	`create table if not exists _vt.redo_log_statement(
+  dtid varbinary(512) not null,
+  id bigint not null,
+  statement mediumblob not null,
+  primary key(dtid, id)
+) engine=InnoDB`,
+	`create table if not exists _vt.dt_state(
+  dtid varbinary(512) not null,
+  state varbinary(20) not null,
+  time_created bigint not null,
+  primary key(dtid)
+) engine=InnoDB`,
+	`create table if not exists _vt.dt_participant(
+  dtid varbinary(512) not null,
+  id bigint not null,
+  keyspace varchar(256) not null,
+  shard varchar(256) not null,
+  primary key(dtid, id)
+) engine=InnoDB`,
+}
+
+// TwoPC keeps the state of the two-phase commits in the _vt database,
+// so that it survives a restart of vttablet.
+//
+// As a participant, a shard saves the statements of a transaction in
+// the redo log when it's prepared, and marks it committed in the
+// transaction itself. A prepared transaction is rolled back by mysql
+// if vttablet restarts, and is prepared again from the redo log.
+//
+// As the coordinator, a shard keeps the metadata of the distributed
+// transaction: vtgate records it before it prepares the participants,
+// and the decision to commit is recorded in the transaction of the
+// coordinator. The transactions that are not concluded are resolved
+// by vtgate after they're abandoned.
+//
+// The application user needs write access to the _vt database.
+type TwoPC struct {
+	enabled   bool
+	pool      *dbconnpool.ConnectionPool
+	lastPurge sync2.AtomicInt64
+}
+
+// NewTwoPC creates a TwoPC. If it's not enabled,
+// the two-phase commits fail.
+func NewTwoPC(enabled bool, idleTimeout time.Duration) *TwoPC {
+	return &TwoPC{
+		enabled: enabled,
+		pool:    dbconnpool.NewConnectionPool("", 2, idleTimeout),
+	}
+}
+
+// Open creates the tables of TwoPC if they don't exist. It returns
+// false if mysql is read-only, in which case the tables are left
+// to replication, and the redo log must not be replayed.
+func (tpc *TwoPC) Open(connFactory dbconnpool.CreateConnectionFunc) bool {
+	if !tpc.enabled {
+		return false
+	}
+	tpc.pool.Open(connFactory)
+	conn := tpc.getConn()
+	defer conn.Recycle()
+	qr := tpc.exec(conn, "select @@global.read_only")
+	if len(qr.Rows) == 1 && qr.Rows[0][0].String() != "0" {
+		log.Infof("mysql is read-only, the transactions of the redo log are not prepared")
+		return false
+	}
+	for _, query := range twoPCSchema {
+		tpc.exec(conn, query)
+	}
+	return true
+}
+
+// Close closes the connections of TwoPC.
+func (tpc *TwoPC) Close() {
+	tpc.pool.Close()
+}
+
+// SaveRedo records the statements of the transaction prepared under
+// dtid in the redo log. It's done in a transaction of its own, before
+// the transaction is prepared.
+func (tpc *TwoPC) SaveRedo(dtid string, statements []string) {
+	tpc.checkEnabled()
+	tpc.inTransaction(func(conn dbconnpool.PoolConnection) {
+		tpc.exec(conn, buildQuery("insert into _vt.redo_log_transaction(dtid, state, time_created) values (%v, %v, %v)", dtid, redoPrepared, time.Now().UnixNano()))
+		if len(statements) == 0 {
+			return
+		}
+		values := make([]string, len(statements))
+		for i, statement := range statements {
+			values[i] = buildQuery("(%v, %v, %v)", dtid, i+1, statement)
+		}
+		tpc.exec(conn, "insert into _vt.redo_log_statement(dtid, id, statement) values "+strings.Join(values, ", "))
+	})
+}
+
+// MarkCommitted marks dtid committed in the redo log. It's done
+// in the prepared transaction conn, right before its commit.
+func (tpc *TwoPC) MarkCommitted(conn dbconnpool.PoolConnection, dtid string) {
+	qr := tpc.exec(conn, buildQuery("update _vt.redo_log_transaction set state = %v where dtid = %v and state = %v", redoCommitted, dtid, redoPrepared))
+	if qr.RowsAffected != 1 {
+		panic(NewTabletError(FAIL, "Transaction %s is not prepared", dtid))
+	}
+	tpc.exec(conn, buildQuery("delete from _vt.redo_log_statement where dtid = %v", dtid))
+}
+
+// DeleteRedo removes dtid from the redo log before it's rolled back.
+// It fails if dtid is already committed.
+func (tpc *TwoPC) DeleteRedo(dtid string) {
+	tpc.checkEnabled()
+	tpc.inTransaction(func(conn dbconnpool.PoolConnection) {
+		// The row is locked until the rollback is recorded, in
+		// case the transaction is being committed concurrently.
+		qr := tpc.exec(conn, buildQuery("select state from _vt.redo_log_transaction where dtid = %v for update", dtid))
+		if len(qr.Rows) != 0 && qr.Rows[0][0].String() == redoCommitted {
+			panic(NewTabletError(FAIL, "Transaction %s is already committed", dtid))
+		}
+		tpc.exec(conn, buildQuery("delete from _vt.redo_log_transaction where dtid = %v", dtid))
+		tpc.exec(conn, buildQuery("delete from _vt.redo_log_statement where dtid = %v", dtid))
+	})
+}
+
+// MarkFailed marks dtid failed in the redo log, after it could
+// not be prepared again.
+func (tpc *TwoPC) MarkFailed(dtid string) {
+	tpc.inTransaction(func(conn dbconnpool.PoolConnection) {
+		tpc.exec(conn, buildQuery("update _vt.redo_log_transaction set state = %v where dtid = %v and state = %v", redoFailed, dtid, redoPrepared))
+	})
+}
+
+// RedoState returns the state of dtid in the redo log,
+// or "" if it's not in it.
+func (tpc *TwoPC) RedoState(dtid string) string {
+	tpc.checkEnabled()
+	conn := tpc.getConn()
+	defer conn.Recycle()
+	return tpc.redoState(conn, dtid)
+}
+
+func (tpc *TwoPC) redoState(conn dbconnpool.PoolConnection, dtid string) string {
+	qr := tpc.exec(conn, buildQuery("select state from _vt.redo_log_transaction where dtid = %v", dtid))
+	if len(qr.Rows) == 0 {
+		return ""
+	}
+	return qr.Rows[0][0].String()
+}
+
+// ReadRedo returns the statements of the prepared transaction dtid.
+func (tpc *TwoPC) ReadRedo(dtid string) []string {
+	conn := tpc.getConn()
+	defer conn.Recycle()
+	return tpc.readRedo(conn, dtid)
+}
+
+func (tpc *TwoPC) readRedo(conn dbconnpool.PoolConnection, dtid string) []string {
+	qr := tpc.exec(conn, buildQuery("select statement from _vt.redo_log_statement where dtid = %v order by id", dtid))
+	statements := make([]string, len(qr.Rows))
+	for i, row := range qr.Rows {
+		statements[i] = row[0].String()
+	}
+	return statements
+}
+
+// ReadAllRedo returns the statements of all the
+// prepared transactions of the redo log, by dtid.
+func (tpc *TwoPC) ReadAllRedo() map[string][]string {
+	conn := tpc.getConn()
+	defer conn.Recycle()
+	qr := tpc.exec(conn, buildQuery("select dtid from _vt.redo_log_transaction where state = %v", redoPrepared))
+	prepared := make(map[string][]string, len(qr.Rows))
+	for _, row := range qr.Rows {
+		dtid := row[0].String()
+		prepared[dtid] = tpc.readRedo(conn, dtid)
+	}
+	return prepared
+}
+
+// PurgeCommitted removes the committed transactions older than
+// redoRetention from the redo log. It does it at most once a minute.
+// Its errors are only logged.
+func (tpc *TwoPC) PurgeCommitted() {
+	defer logError()
+	now := time.Now()
+	last := tpc.lastPurge.Get()
+	if now.Sub(time.Unix(0, last)) < time.Minute || !tpc.lastPurge.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	conn := tpc.getConn()
+	defer conn.Recycle()
+	tpc.exec(conn, buildQuery("delete from _vt.redo_log_transaction where state = %v and time_created < %v", redoCommitted, now.Add(-redoRetention).UnixNano()))
+}
+
+// CreateTransaction records dtid in the PREPARE state.
+func (tpc *TwoPC) CreateTransaction(dtid string, participants []proto.Participant) {
+	tpc.checkEnabled()
+	tpc.inTransaction(func(conn dbconnpool.PoolConnection) {
+		if tpc.state(conn, dtid) != "" {
+			panic(NewTabletError(FAIL, "Transaction %s already exists", dtid))
+		}
+		tpc.exec(conn, buildQuery("insert into _vt.dt_state(dtid, state, time_created) values (%v, %v, %v)", dtid, proto.DT_PREPARE, time.Now().UnixNano()))
+		if len(participants) == 0 {
+			return
+		}
+		values := make([]string, len(participants))
+		for i, participant := range participants {
+			values[i] = buildQuery("(%v, %v, %v, %v)", dtid, i+1, participant.Keyspace, participant.Shard)
+		}
+		tpc.exec(conn, "insert into _vt.dt_participant(dtid, id, keyspace, shard) values "+strings.Join(values, ", "))
+	})
+}
+
+// StartCommit moves dtid from the PREPARE to the COMMIT state in the
+// transaction conn of the coordinator. The decision to commit is taken
+// when that transaction commits. Until then, the row of dtid is locked,
+// so that SetRollback waits for the outcome.
+func (tpc *TwoPC) StartCommit(conn dbconnpool.PoolConnection, dtid string) {
+	tpc.checkEnabled()
+	qr := tpc.exec(conn, buildQuery("update _vt.dt_state set state = %v where dtid = %v and state = %v", proto.DT_COMMIT, dtid, proto.DT_PREPARE))
+	if qr.RowsAffected != 1 {
+		panic(tpc.stateError(conn, dtid, "cannot commit"))
+	}
+}
+
+// SetRollback moves dtid from the PREPARE to the ROLLBACK state.
+// It's not an error if it's already in the ROLLBACK state.
+func (tpc *TwoPC) SetRollback(dtid string) {
+	tpc.checkEnabled()
+	tpc.inTransaction(func(conn dbconnpool.PoolConnection) {
+		qr := tpc.exec(conn, buildQuery("update _vt.dt_state set state = %v where dtid = %v and state = %v", proto.DT_ROLLBACK, dtid, proto.DT_PREPARE))
+		if qr.RowsAffected != 1 && tpc.state(conn, dtid) != proto.DT_ROLLBACK {
+			panic(tpc.stateError(conn, dtid, "cannot roll back"))
+		}
+	})
+}
+
+// stateError returns the error of a transition of dtid
+// that doesn't apply to its current state.
+func (tpc *TwoPC) stateError(conn dbconnpool.PoolConnection, dtid, action string) *TabletError {
+	switch state := tpc.state(conn, dtid); state {
+	case "":
+		return NewTabletError(FAIL, "Transaction %s not found", dtid)
+	case proto.DT_COMMIT:
+		return NewTabletError(FAIL, "Transaction %s is already committed, %s", dtid, action)
+	default:
+		return NewTabletError(FAIL, "Transaction %s is in state %s, %s", dtid, state, action)
+	}
+}
+
+func (tpc *TwoPC) state(conn dbconnpool.PoolConnection, dtid string) string {
+	qr := tpc.exec(conn, buildQuery("select state from _vt.dt_state where dtid = %v", dtid))
+	if len(qr.Rows) == 0 {
+		return ""
+	}
+	return qr.Rows[0][0].String()
+}
+
+// Conclude forgets dtid. It's not an error if it doesn't exist.
+func (tpc *TwoPC) Conclude(dtid string) {
+	tpc.checkEnabled()
+	tpc.inTransaction(func(conn dbconnpool.PoolConnection) {
+		tpc.exec(conn, buildQuery("delete from _vt.dt_state where dtid = %v", dtid))
+		tpc.exec(conn, buildQuery("delete from _vt.dt_participant where dtid = %v", dtid))
+	})
+}
+
+// Unresolved returns the transactions created more than
+// abandonAge ago.
+func (tpc *TwoPC) Unresolved(abandonAge time.Duration) []proto.DistributedTx {
+	tpc.checkEnabled()
+	conn := tpc.getConn()
+	defer conn.Recycle()
+	cutoff := time.Now().Add(-abandonAge).UnixNano()
+	qr := tpc.exec(conn, buildQuery("select dtid, state, time_created from _vt.dt_state where time_created <= %v", cutoff))
+	var txs []proto.DistributedTx
+	for _, row := range qr.Rows {
+		timeCreated, err := strconv.ParseInt(row[2].String(), 10, 64)
+		if err != nil {
+			panic(NewTabletError(FAIL, "Invalid time_created of transaction %s: %v", row[0].String(), err))
+		}
+		dt := proto.DistributedTx{
+			Dtid:        row[0].String(),
+			State:       row[1].String(),
+			TimeCreated: timeCreated,
+		}
+		pqr := tpc.exec(conn, buildQuery("select keyspace, shard from _vt.dt_participant where dtid = %v order by id", dt.Dtid))
+		for _, prow := range pqr.Rows {
+			dt.Participants = append(dt.Participants, proto.Participant{
+				Keyspace: prow[0].String(),
+				Shard:    prow[1].String(),
+			})
+		}
+		txs = append(txs, dt)
+	}
+	return txs
+}
+
+func (tpc *TwoPC) checkEnabled() {
+	if !tpc.enabled {
+		panic(NewTabletError(FAIL, "Two-phase commit is disabled, see queryserver-config-twopc-enable"))
+	}
+}
+
+// inTransaction runs f in a transaction of its own. The transaction
+// is rolled back if f panics.
+func (tpc *TwoPC) inTransaction(f func(conn dbconnpool.PoolConnection)) {
+	conn := tpc.getConn()
+	defer conn.Recycle()
+	tpc.exec(conn, BEGIN)
+	defer func() {
+		if x := recover(); x != nil {
+			if _, err := conn.ExecuteFetch(ROLLBACK, 1, false); err != nil {
+				conn.Close()
+			}
+			panic(x)
+		}
+	}()
+	f(conn)
+	tpc.exec(conn, COMMIT)
+}
+
+func (tpc *TwoPC) getConn() dbconnpool.PoolConnection {
+	conn, err := tpc.pool.Get(0)
+	if err != nil {
+		if err == dbconnpool.CONN_POOL_CLOSED_ERR {
+			panic(connPoolClosedErr)
+		}
+		panic(NewTabletErrorSql(FATAL, err))
+	}
+	return conn
+}
+
+func (tpc *TwoPC) exec(conn dbconnpool.PoolConnection, query string) *mproto.QueryResult {
+	qr, err := conn.ExecuteFetch(query, 10000, false)
+	if err != nil {
+		panic(NewTabletErrorSql(FAIL, err))
+	}
+	return qr
+}
+
+// buildQuery formats query with its values encoded as SQL.
+func buildQuery(query string, values ...interface{}) string {
+	encoded := make([]interface{}, len(values))
+	for i, value := range values {
+		buf := &bytes.Buffer{}
+		if err := sqlparser.EncodeValue(buf, value); err != nil {
+			panic(NewTabletError(FAIL, "%v", err))
+		}
+		encoded[i] = buf.String()
+	}
+	return fmt.Sprintf(query, encoded...)
+}
@@ -0,0 +1,559 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/pools"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/timer"
+	"github.com/youtube/vitess/go/vt/dbconnpool"
+	"github.com/youtube/vitess/go/vt/tabletserver/proto"
+)
+
+func TestTwoPCStartCommit(t *testing.T) {
+	db := newFakeDB()
+	qe := newTwoPCQueryEngine(db)
+	participants := []proto.Participant{{Keyspace: "ks", Shard: "40-80"}, {Keyspace: "ks", Shard: "80-"}}
+	qe.twoPC.CreateTransaction("ks:-40:1", participants)
+	transactionID := qe.txPool.Begin("", false)
+	StartCommit(nil, qe, transactionID, "ks:-40:1")
+
+	// The state survives a restart.
+	qe = restartTwoPC(qe, db)
+	txs := qe.twoPC.Unresolved(0)
+	if len(txs) != 1 {
+		t.Fatalf("Unresolved: %+v, want 1 transaction", txs)
+	}
+	txs[0].TimeCreated = 0
+	want := proto.DistributedTx{
+		Dtid:         "ks:-40:1",
+		State:        proto.DT_COMMIT,
+		Participants: participants,
+	}
+	if !reflect.DeepEqual(txs[0], want) {
+		t.Errorf("Unresolved: %+v, want %+v", txs[0], want)
+	}
+	qe.twoPC.Conclude("ks:-40:1")
+	if txs := qe.twoPC.Unresolved(0); len(txs) != 0 {
+		t.Errorf("Unresolved: %+v, want none", txs)
+	}
+}
+
+func TestTwoPCStartCommitFail(t *testing.T) {
+	db := newFakeDB()
+	qe := newTwoPCQueryEngine(db)
+	qe.twoPC.CreateTransaction("ks:-40:1", nil)
+	transactionID := qe.txPool.Begin("", false)
+	db.failNext("commit")
+	err := tpcError(func() { StartCommit(nil, qe, transactionID, "ks:-40:1") })
+	if err == "" {
+		t.Errorf("StartCommit did not fail")
+	}
+	if state := qe.twoPC.Unresolved(0)[0].State; state != proto.DT_ROLLBACK {
+		t.Errorf("state: %s, want %s", state, proto.DT_ROLLBACK)
+	}
+}
+
+func TestTwoPCSetRollback(t *testing.T) {
+	qe := newTwoPCQueryEngine(newFakeDB())
+	tpc := qe.twoPC
+	tpc.CreateTransaction("ks:-40:1", nil)
+	tpc.SetRollback("ks:-40:1")
+	tpc.SetRollback("ks:-40:1")
+	if state := tpc.Unresolved(0)[0].State; state != proto.DT_ROLLBACK {
+		t.Errorf("state: %s, want %s", state, proto.DT_ROLLBACK)
+	}
+	transactionID := qe.txPool.Begin("", false)
+	err := tpcError(func() { StartCommit(nil, qe, transactionID, "ks:-40:1") })
+	want := "error: Transaction ks:-40:1 is in state ROLLBACK, cannot commit"
+	if err != want {
+		t.Errorf("StartCommit: %s, want %s", err, want)
+	}
+	qe.txPool.Rollback(transactionID)
+
+	tpc.CreateTransaction("ks:-40:2", nil)
+	StartCommit(nil, qe, qe.txPool.Begin("", false), "ks:-40:2")
+	err = tpcError(func() { tpc.SetRollback("ks:-40:2") })
+	want = "error: Transaction ks:-40:2 is already committed, cannot roll back"
+	if err != want {
+		t.Errorf("SetRollback: %s, want %s", err, want)
+	}
+
+	err = tpcError(func() { tpc.SetRollback("ks:-40:3") })
+	want = "error: Transaction ks:-40:3 not found"
+	if err != want {
+		t.Errorf("SetRollback: %s, want %s", err, want)
+	}
+}
+
+func TestTwoPCUnresolved(t *testing.T) {
+	tpc := newTwoPCQueryEngine(newFakeDB()).twoPC
+	tpc.CreateTransaction("ks:-40:1", nil)
+	if txs := tpc.Unresolved(time.Hour); len(txs) != 0 {
+		t.Errorf("Unresolved: %+v, want none", txs)
+	}
+	err := tpcError(func() { tpc.CreateTransaction("ks:-40:1", nil) })
+	want := "error: Transaction ks:-40:1 already exists"
+	if err != want {
+		t.Errorf("CreateTransaction: %s, want %s", err, want)
+	}
+}
+
+func TestTwoPCCommitPrepared(t *testing.T) {
+	db := newFakeDB()
+	qe := newTwoPCQueryEngine(db)
+	transactionID := qe.txPool.Begin("", false)
+	execInTx(t, qe, transactionID, "insert into t values (1)", "select * from t", "update t set a = 'it''s'")
+	Prepare(qe, transactionID, "ks:-40:1")
+	if _, err := qe.txPool.activePool.Get(transactionID, "for test"); err == nil {
+		t.Errorf("the prepared transaction is still active")
+	}
+
+	// vttablet restarts before the transaction is committed.
+	qe = restartTwoPC(qe, db)
+	if !qe.txPool.IsPrepared("ks:-40:1") {
+		t.Fatalf("the transaction was not prepared again")
+	}
+	CommitPrepared(nil, qe, "ks:-40:1")
+	want := []string{"insert into t values (1)", "update t set a = 'it''s'"}
+	if !reflect.DeepEqual(db.committed, want) {
+		t.Errorf("committed: %q, want %q", db.committed, want)
+	}
+
+	// A retry succeeds, but an unknown transaction fails.
+	CommitPrepared(nil, qe, "ks:-40:1")
+	if !reflect.DeepEqual(db.committed, want) {
+		t.Errorf("committed: %q, want %q", db.committed, want)
+	}
+	err := tpcError(func() { CommitPrepared(nil, qe, "ks:-40:2") })
+	if want := "error: Transaction ks:-40:2 is not prepared"; err != want {
+		t.Errorf("CommitPrepared: %s, want %s", err, want)
+	}
+	err = tpcError(func() { RollbackPrepared(qe, "ks:-40:1", 0) })
+	if want := "error: Transaction ks:-40:1 is already committed"; err != want {
+		t.Errorf("RollbackPrepared: %s, want %s", err, want)
+	}
+}
+
+func TestTwoPCCommitPreparedFail(t *testing.T) {
+	db := newFakeDB()
+	qe := newTwoPCQueryEngine(db)
+	transactionID := qe.txPool.Begin("", false)
+	execInTx(t, qe, transactionID, "delete from t")
+	Prepare(qe, transactionID, "ks:-40:1")
+	db.failNext("commit")
+	if err := tpcError(func() { CommitPrepared(nil, qe, "ks:-40:1") }); err == "" {
+		t.Errorf("CommitPrepared did not fail")
+	}
+	if len(db.committed) != 0 {
+		t.Errorf("committed: %q, want none", db.committed)
+	}
+	// The transaction is prepared again to be committed.
+	CommitPrepared(nil, qe, "ks:-40:1")
+	if want := []string{"delete from t"}; !reflect.DeepEqual(db.committed, want) {
+		t.Errorf("committed: %q, want %q", db.committed, want)
+	}
+}
+
+func TestTwoPCRollbackPrepared(t *testing.T) {
+	db := newFakeDB()
+	qe := newTwoPCQueryEngine(db)
+	transactionID := qe.txPool.Begin("", false)
+	execInTx(t, qe, transactionID, "insert into t values (1)")
+	Prepare(qe, transactionID, "ks:-40:1")
+	RollbackPrepared(qe, "ks:-40:1", transactionID)
+	qe = restartTwoPC(qe, db)
+	if qe.txPool.IsPrepared("ks:-40:1") {
+		t.Errorf("the rolled back transaction was prepared again")
+	}
+	err := tpcError(func() { CommitPrepared(nil, qe, "ks:-40:1") })
+	if want := "error: Transaction ks:-40:1 is not prepared"; err != want {
+		t.Errorf("CommitPrepared: %s, want %s", err, want)
+	}
+	if len(db.committed) != 0 {
+		t.Errorf("committed: %q, want none", db.committed)
+	}
+}
+
+func TestTwoPCDisabled(t *testing.T) {
+	tpc := NewTwoPC(false, 0)
+	if tpc.Open(newFakeDB().connFactory) {
+		t.Errorf("Open: true, want false")
+	}
+	err := tpcError(func() { tpc.CreateTransaction("ks:-40:1", nil) })
+	if want := "error: Two-phase commit is disabled, see queryserver-config-twopc-enable"; err != want {
+		t.Errorf("CreateTransaction: %s, want %s", err, want)
+	}
+}
+
+func TestTxPoolLockWaits(t *testing.T) {
+	db := newFakeDB()
+	qe := newTwoPCQueryEngine(db)
+	tx1 := qe.txPool.Begin("", false)
+	tx2 := qe.txPool.Begin("", false)
+	conn1 := qe.txPool.Get(tx1)
+	conn2 := qe.txPool.Get(tx2)
+	id1, id2 := conn1.ConnID, conn2.ConnID
+	conn1.Recycle()
+	conn2.Recycle()
+
+	// The waits of the connections that don't run a
+	// transaction of the pool are ignored.
+	db.lockWaits = [][2]int64{{id1, id2}, {id2, 1000}, {1000, id1}}
+	dbaPool := dbconnpool.NewConnectionPool("", 1, 0)
+	dbaPool.Open(db.connFactory)
+	defer dbaPool.Close()
+	want := []proto.LockWait{{WaitingId: tx1, BlockingId: tx2}}
+	if got := qe.txPool.LockWaits(dbaPool); !reflect.DeepEqual(got, want) {
+		t.Errorf("LockWaits: %+v, want %+v", got, want)
+	}
+}
+
+func TestIsRedoStatement(t *testing.T) {
+	testcases := []struct {
+		query string
+		want  bool
+	}{
+		{"insert into t values (1)", true},
+		{"  UPDATE t set a = 1", true},
+		{"/* comment */ delete from t", true},
+		{"replace into t values (1)", true},
+		{"savepoint a", true},
+		{"rollback to savepoint a", true},
+		{"release savepoint a", true},
+		{"rollback", false},
+		{"rollback work", false},
+		{"commit", false},
+		{"select * from t for update", false},
+		{"/* unterminated", false},
+		{"", false},
+	}
+	for _, tcase := range testcases {
+		if got := isRedoStatement(tcase.query); got != tcase.want {
+			t.Errorf("isRedoStatement(%q): %v, want %v", tcase.query, got, tcase.want)
+		}
+	}
+}
+
+// tpcError returns the error f panics with.
+func tpcError(f func()) (err string) {
+	defer func() {
+		if x := recover(); x != nil {
+			err = x.(*TabletError).Error()
+		}
+	}()
+	f()
+	return ""
+}
+
+// newTwoPCQueryEngine returns a QueryEngine with only
+// a TxPool and a TwoPC, connected to db.
+func newTwoPCQueryEngine(db *fakeDB) *QueryEngine {
+	if queryStats == nil {
+		queryStats = stats.NewTimings("")
+		killStats = stats.NewCounters("")
+		infoErrors = stats.NewCounters("")
+		errorStats = stats.NewCounters("")
+		internalErrors = stats.NewCounters("")
+	}
+	qe := &QueryEngine{
+		txPool: &TxPool{
+			pool:       dbconnpool.NewConnectionPool("", 4, 0),
+			activePool: pools.NewNumbered(),
+			timeout:    30 * 1e9,
+			ticks:      timer.NewTimer(time.Hour),
+			txStats:    stats.NewTimings(""),
+			prepared:   make(map[string]*TxConnection),
+		},
+		twoPC: NewTwoPC(true, 0),
+	}
+	qe.txPool.Open(db.connFactory)
+	if qe.twoPC.Open(db.connFactory) {
+		PrepareAllFromRedo(qe)
+	}
+	return qe
+}
+
+// restartTwoPC closes qe, which rolls back its
+// transactions, and opens a new one on db.
+func restartTwoPC(qe *QueryEngine, db *fakeDB) *QueryEngine {
+	qe.twoPC.Close()
+	qe.txPool.Close()
+	return newTwoPCQueryEngine(db)
+}
+
+func execInTx(t *testing.T, qe *QueryEngine, transactionID int64, queries ...string) {
+	conn := qe.txPool.Get(transactionID)
+	defer conn.Recycle()
+	for _, query := range queries {
+		if _, err := conn.ExecuteFetch(query, 10000, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fakeDB is an in-memory database that understands the queries TwoPC
+// sends to the tables of the _vt database. The other statements are
+// only recorded when they're committed.
+type fakeDB struct {
+	mu        sync.Mutex
+	tables    map[string][]*fakeRow
+	committed []string
+	// The next query equal to fail fails.
+	fail string
+	// lockWaits are the thread ids of the connections
+	// that wait for a lock, and of the ones that hold it.
+	lockWaits [][2]int64
+	lastID    int64
+}
+
+type fakeRow struct {
+	cols map[string]string
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{tables: make(map[string][]*fakeRow)}
+}
+
+func (db *fakeDB) failNext(query string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.fail = query
+}
+
+func (db *fakeDB) connFactory(pool *dbconnpool.ConnectionPool) (dbconnpool.PoolConnection, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.lastID++
+	return &fakeConn{db: db, pool: pool, id: db.lastID}, nil
+}
+
+var (
+	fakeInsert = regexp.MustCompile(`^insert into (_vt\.\w+)\((.*?)\) values (.*)$`)
+	fakeSelect = regexp.MustCompile(`^select (.*?) from (_vt\.\w+)(?: where (.*?))?(?: order by id)?(?: for update)?$`)
+	fakeUpdate = regexp.MustCompile(`^update (_vt\.\w+) set (\w+) = (.*?) where (.*)$`)
+	fakeDelete = regexp.MustCompile(`^delete from (_vt\.\w+) where (.*)$`)
+	fakeCond   = regexp.MustCompile(`^(\w+) (=|<=|<) (.*)$`)
+)
+
+// fakeConn is a connection to a fakeDB. The changes of its transaction
+// are applied right away, and undone if it's rolled back.
+type fakeConn struct {
+	db      *fakeDB
+	id      int64
+	pool    *dbconnpool.ConnectionPool
+	closed  bool
+	inTx    bool
+	undo    []func()
+	pending []string
+}
+
+func (fc *fakeConn) ExecuteFetch(query string, maxrows int, wantfields bool) (*mproto.QueryResult, error) {
+	db := fc.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if query == db.fail {
+		db.fail = ""
+		fc.rollback()
+		return nil, fmt.Errorf("fake error on %s", query)
+	}
+	qr := &mproto.QueryResult{}
+	switch {
+	case query == BEGIN:
+		fc.inTx = true
+	case query == COMMIT:
+		db.committed = append(db.committed, fc.pending...)
+		fc.inTx, fc.undo, fc.pending = false, nil, nil
+	case query == ROLLBACK:
+		fc.rollback()
+	case query == "select @@global.read_only":
+		qr.Rows = [][]sqltypes.Value{{sqltypes.MakeString([]byte("0"))}}
+	case query == lockWaitsQuery:
+		for _, wait := range db.lockWaits {
+			qr.Rows = append(qr.Rows, []sqltypes.Value{
+				sqltypes.MakeString([]byte(strconv.FormatInt(wait[0], 10))),
+				sqltypes.MakeString([]byte(strconv.FormatInt(wait[1], 10))),
+			})
+		}
+	case strings.HasPrefix(query, "create "):
+	case fakeInsert.MatchString(query):
+		m := fakeInsert.FindStringSubmatch(query)
+		cols := strings.Split(m[2], ", ")
+		for _, values := range fakeTuples(m[3]) {
+			row := &fakeRow{cols: make(map[string]string)}
+			for i, col := range cols {
+				row.cols[col] = values[i]
+			}
+			db.tables[m[1]] = append(db.tables[m[1]], row)
+			table := m[1]
+			fc.addUndo(func() { db.remove(table, row) })
+			qr.RowsAffected++
+		}
+	case fakeSelect.MatchString(query):
+		m := fakeSelect.FindStringSubmatch(query)
+		for _, row := range db.tables[m[2]] {
+			if !fakeMatch(row, m[3]) {
+				continue
+			}
+			var values []sqltypes.Value
+			for _, col := range strings.Split(m[1], ", ") {
+				values = append(values, sqltypes.MakeString([]byte(row.cols[col])))
+			}
+			qr.Rows = append(qr.Rows, values)
+		}
+	case fakeUpdate.MatchString(query):
+		m := fakeUpdate.FindStringSubmatch(query)
+		for _, row := range db.tables[m[1]] {
+			if !fakeMatch(row, m[4]) {
+				continue
+			}
+			row, col, old := row, m[2], row.cols[m[2]]
+			row.cols[col] = fakeTuples("(" + m[3] + ")")[0][0]
+			fc.addUndo(func() { row.cols[col] = old })
+			qr.RowsAffected++
+		}
+	case fakeDelete.MatchString(query):
+		m := fakeDelete.FindStringSubmatch(query)
+		for _, row := range append([]*fakeRow(nil), db.tables[m[1]]...) {
+			if !fakeMatch(row, m[2]) {
+				continue
+			}
+			table, row := m[1], row
+			db.remove(table, row)
+			fc.addUndo(func() { db.tables[table] = append(db.tables[table], row) })
+			qr.RowsAffected++
+		}
+	case strings.Contains(query, "_vt."):
+		return nil, fmt.Errorf("fake query not supported: %s", query)
+	default:
+		if fc.inTx {
+			fc.pending = append(fc.pending, query)
+		} else {
+			db.committed = append(db.committed, query)
+		}
+	}
+	return qr, nil
+}
+
+func (fc *fakeConn) addUndo(undo func()) {
+	if fc.inTx {
+		fc.undo = append(fc.undo, undo)
+	}
+}
+
+// rollback requires the caller to hold a lock on db.mu.
+func (fc *fakeConn) rollback() {
+	for i := len(fc.undo) - 1; i >= 0; i-- {
+		fc.undo[i]()
+	}
+	fc.inTx, fc.undo, fc.pending = false, nil, nil
+}
+
+func (fc *fakeConn) ExecuteStreamFetch(query string, callback func(*mproto.QueryResult) error, streamBufferSize int) error {
+	return fmt.Errorf("not implemented")
+}
+
+func (fc *fakeConn) Id() int64 {
+	return fc.id
+}
+
+func (fc *fakeConn) Close() {
+	fc.db.mu.Lock()
+	defer fc.db.mu.Unlock()
+	fc.rollback()
+	fc.closed = true
+}
+
+func (fc *fakeConn) IsClosed() bool {
+	return fc.closed
+}
+
+func (fc *fakeConn) Recycle() {
+	if fc.closed {
+		fc.pool.Put(nil)
+	} else {
+		fc.pool.Put(fc)
+	}
+}
+
+// remove requires the caller to hold a lock on db.mu.
+func (db *fakeDB) remove(table string, row *fakeRow) {
+	rows := db.tables[table]
+	for i, r := range rows {
+		if r == row {
+			db.tables[table] = append(rows[:i:i], rows[i+1:]...)
+			return
+		}
+	}
+}
+
+// fakeMatch returns true if row matches where, a list of
+// comparisons joined by and.
+func fakeMatch(row *fakeRow, where string) bool {
+	if where == "" {
+		return true
+	}
+	for _, cond := range strings.Split(where, " and ") {
+		m := fakeCond.FindStringSubmatch(cond)
+		value := fakeTuples("(" + m[3] + ")")[0][0]
+		if m[2] == "=" {
+			if row.cols[m[1]] != value {
+				return false
+			}
+			continue
+		}
+		have, _ := strconv.ParseInt(row.cols[m[1]], 10, 64)
+		limit, _ := strconv.ParseInt(value, 10, 64)
+		if have > limit || (m[2] == "<" && have == limit) {
+			return false
+		}
+	}
+	return true
+}
+
+// fakeTuples decodes a list of tuples of SQL values.
+func fakeTuples(list string) [][]string {
+	var tuples [][]string
+	var tuple []string
+	var value []byte
+	inString, inTuple := false, false
+	for i := 0; i < len(list); i++ {
+		ch := list[i]
+		switch {
+		case inString && ch == '\\':
+			i++
+			value = append(value, sqltypes.SqlDecodeMap[list[i]])
+		case inString && ch == '\'':
+			inString = false
+		case inString:
+			value = append(value, ch)
+		case ch == '\'':
+			inString = true
+		case ch == '(':
+			tuple, inTuple = nil, true
+		case inTuple && (ch == ',' || ch == ')'):
+			tuple = append(tuple, string(value))
+			value = nil
+			if ch == ')' {
+				tuples = append(tuples, tuple)
+				inTuple = false
+			}
+		case inTuple && ch != ' ':
+			value = append(value, ch)
+		}
+	}
+	return tuples
+}
@@ -7,17 +7,21 @@ package tabletserver
 import (
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	log "github.com/golang/glog"
+	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/pools"
 	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/streamlog"
 	"github.com/youtube/vitess/go/sync2"
 	"github.com/youtube/vitess/go/timer"
 	"github.com/youtube/vitess/go/vt/dbconnpool"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	"github.com/youtube/vitess/go/vt/tabletserver/proto"
 )
 
 /* Function naming convention:
@@ -32,9 +36,10 @@ SafeFunctions() return os.Error instead of throwing exceptions
 var TxLogger = streamlog.New("TxLog", 10)
 
 var (
-	BEGIN    = "begin"
-	COMMIT   = "commit"
-	ROLLBACK = "rollback"
+	BEGIN           = "begin"
+	BEGIN_READ_ONLY = "start transaction read only"
+	COMMIT          = "commit"
+	ROLLBACK        = "rollback"
 )
 
 const (
@@ -55,6 +60,15 @@ type TxPool struct {
 	ticks       *timer.Timer
 	txStats     *stats.Timings
 
+	// prepared holds the transactions prepared for a two-phase
+	// commit, by dtid. They can't be rolled back without the
+	// decision of their coordinator, so they're not subject to
+	// the timeout: the ones that exceed it are only reported.
+	// They're rolled back by mysql if vttablet restarts, and
+	// prepared again from their redo log.
+	preparedMu sync.Mutex
+	prepared   map[string]*TxConnection
+
 	// Tracking culprits that cause tx pool full errors.
 	logMu   sync.Mutex
 	lastLog time.Time
@@ -69,6 +83,7 @@ func NewTxPool(name string, capacity int, timeout, poolTimeout, idleTimeout time
 		poolTimeout: sync2.AtomicDuration(poolTimeout),
 		ticks:       timer.NewTimer(timeout / 10),
 		txStats:     stats.NewTimings("Transactions"),
+		prepared:    make(map[string]*TxConnection),
 	}
 	// Careful: pool also exports name+"xxx" vars,
 	// but we know it doesn't export Timeout.
@@ -92,6 +107,14 @@ func (axp *TxPool) Close() {
 		conn.Close()
 		conn.discard(TX_CLOSE)
 	}
+	axp.preparedMu.Lock()
+	for dtid, conn := range axp.prepared {
+		log.Infof("closing prepared transaction %s for shutdown, it will be prepared again from the redo log: %s", dtid, conn.Format(nil))
+		conn.Close()
+		conn.discard(TX_CLOSE)
+	}
+	axp.prepared = make(map[string]*TxConnection)
+	axp.preparedMu.Unlock()
 	axp.pool.Close()
 }
 
@@ -108,9 +131,23 @@ func (axp *TxPool) TransactionKiller() {
 		conn.Close()
 		conn.discard(TX_KILL)
 	}
+	axp.preparedMu.Lock()
+	defer axp.preparedMu.Unlock()
+	for dtid, conn := range axp.prepared {
+		if time.Now().Sub(conn.StartTime) > axp.Timeout() {
+			log.Warningf("prepared transaction %s is waiting for its coordinator: %s", dtid, conn.Format(nil))
+			internalErrors.Add("AbandonedPrepared", 1)
+		}
+	}
 }
 
-func (axp *TxPool) Begin() int64 {
+// Begin begins a transaction with the isolation level and the
+// access mode of the connection, unless they're overridden by
+// isolation and readOnly.
+func (axp *TxPool) Begin(isolation string, readOnly bool) int64 {
+	if isolation != "" && !sqlparser.IsIsolationLevel(isolation) {
+		panic(NewTabletError(FAIL, "Invalid isolation level %s", isolation))
+	}
 	conn, err := axp.pool.Get(axp.poolTimeout.Get())
 	if err != nil {
 		switch err {
@@ -122,7 +159,34 @@ func (axp *TxPool) Begin() int64 {
 		}
 		panic(NewTabletErrorSql(FATAL, err))
 	}
-	if _, err := conn.ExecuteFetch(BEGIN, 1, false); err != nil {
+	return axp.begin(conn, isolation, readOnly)
+}
+
+// BeginReserved is like Begin, but the transaction uses the reserved
+// connection conn, which goes back to the ReservedPool once the
+// transaction is concluded. It doesn't count against the capacity
+// of the pool.
+func (axp *TxPool) BeginReserved(conn *ReservedConnection, isolation string, readOnly bool) int64 {
+	if isolation != "" && !sqlparser.IsIsolationLevel(isolation) {
+		conn.Recycle()
+		panic(NewTabletError(FAIL, "Invalid isolation level %s", isolation))
+	}
+	return axp.begin(conn, isolation, readOnly)
+}
+
+func (axp *TxPool) begin(conn dbconnpool.PoolConnection, isolation string, readOnly bool) int64 {
+	begin := BEGIN
+	if readOnly {
+		begin = BEGIN_READ_ONLY
+	}
+	if isolation != "" {
+		// This only applies to the next transaction of the connection.
+		if _, err := conn.ExecuteFetch("set transaction isolation level "+isolation, 1, false); err != nil {
+			conn.Recycle()
+			panic(NewTabletErrorSql(FAIL, err))
+		}
+	}
+	if _, err := conn.ExecuteFetch(begin, 1, false); err != nil {
 		conn.Recycle()
 		panic(NewTabletErrorSql(FAIL, err))
 	}
@@ -156,6 +220,109 @@ func (axp *TxPool) Rollback(transactionId int64) {
 	}
 }
 
+// Prepare prepares the transaction conn, obtained with Get, for a
+// two-phase commit under dtid. A prepared transaction can't be used
+// for queries any more. It can only be concluded by SafeCommitPrepared
+// or RollbackPrepared.
+func (axp *TxPool) Prepare(conn *TxConnection, dtid string) {
+	axp.preparedMu.Lock()
+	defer axp.preparedMu.Unlock()
+	if _, ok := axp.prepared[dtid]; ok {
+		conn.Recycle()
+		panic(NewTabletError(FAIL, "Transaction %s is already prepared", dtid))
+	}
+	axp.activePool.Unregister(conn.TransactionID)
+	axp.prepared[dtid] = conn
+}
+
+// PrepareStatements begins a transaction, executes statements in it,
+// and prepares it under dtid. It prepares a transaction again from
+// its redo log.
+func (axp *TxPool) PrepareStatements(dtid string, statements []string) {
+	conn := axp.Get(axp.Begin("", false))
+	for _, statement := range statements {
+		if _, err := conn.ExecuteFetch(statement, 1, false); err != nil {
+			conn.Recycle()
+			axp.Rollback(conn.TransactionID)
+			panic(NewTabletErrorSql(FAIL, err))
+		}
+	}
+	axp.Prepare(conn, dtid)
+}
+
+// IsPrepared returns true if a transaction is prepared under dtid.
+func (axp *TxPool) IsPrepared(dtid string) bool {
+	axp.preparedMu.Lock()
+	defer axp.preparedMu.Unlock()
+	_, ok := axp.prepared[dtid]
+	return ok
+}
+
+// SafeCommitPrepared commits the transaction prepared under dtid.
+// markCommitted is called in the transaction before its commit.
+// If it panics, the transaction is rolled back.
+func (axp *TxPool) SafeCommitPrepared(dtid string, markCommitted func(conn dbconnpool.PoolConnection, dtid string)) (invalidList map[string]DirtyKeys, err error) {
+	defer handleError(&err, nil)
+
+	conn := axp.takePrepared(dtid)
+	if conn == nil {
+		panic(NewTabletError(FAIL, "Transaction %s is not prepared", dtid))
+	}
+	defer func() {
+		if x := recover(); x != nil {
+			conn.Close()
+			conn.discard(TX_ROLLBACK)
+			panic(x)
+		}
+	}()
+	markCommitted(conn, dtid)
+	defer conn.discard(TX_COMMIT)
+	invalidList = conn.dirtyTables
+	axp.txStats.Add("Completed", time.Now().Sub(conn.StartTime))
+	if _, fetchErr := conn.ExecuteFetch(COMMIT, 1, false); fetchErr != nil {
+		conn.Close()
+		err = NewTabletErrorSql(FAIL, fetchErr)
+	}
+	return
+}
+
+// RollbackPrepared rolls back the transaction prepared under dtid.
+// If the transaction wasn't prepared yet, originalId identifies it
+// in the pool of active transactions instead. It's not an error
+// if neither exists.
+func (axp *TxPool) RollbackPrepared(dtid string, originalId int64) {
+	conn := axp.takePrepared(dtid)
+	if conn == nil {
+		if originalId == 0 {
+			return
+		}
+		v, err := axp.activePool.Get(originalId, "for rollback")
+		if err != nil {
+			return
+		}
+		conn = v.(*TxConnection)
+	}
+	defer conn.discard(TX_ROLLBACK)
+	axp.txStats.Add("Aborted", time.Now().Sub(conn.StartTime))
+	if _, err := conn.ExecuteFetch(ROLLBACK, 1, false); err != nil {
+		conn.Close()
+		panic(NewTabletErrorSql(FAIL, err))
+	}
+}
+
+// takePrepared removes the transaction prepared under dtid
+// from the pool and returns it. It returns nil if there's none.
+func (axp *TxPool) takePrepared(dtid string) *TxConnection {
+	axp.preparedMu.Lock()
+	defer axp.preparedMu.Unlock()
+	conn, ok := axp.prepared[dtid]
+	if !ok {
+		return nil
+	}
+	delete(axp.prepared, dtid)
+	return conn
+}
+
 // You must call Recycle on TxConnection once done.
 func (axp *TxPool) Get(transactionId int64) (conn *TxConnection) {
 	v, err := axp.activePool.Get(transactionId, "for query")
@@ -180,6 +347,51 @@ func (axp *TxPool) LogActive() {
 	}
 }
 
+// lockWaitsQuery returns the MySQL thread ids of the transactions
+// that wait for a row lock, and of the transactions that hold it.
+const lockWaitsQuery = "select r.trx_mysql_thread_id, b.trx_mysql_thread_id " +
+	"from information_schema.innodb_lock_waits w " +
+	"join information_schema.innodb_trx r on r.trx_id = w.requesting_trx_id " +
+	"join information_schema.innodb_trx b on b.trx_id = w.blocking_trx_id"
+
+// LockWaits returns the transactions of the pool that wait for a row
+// lock held by another transaction of the pool, as InnoDB reports
+// them. The locks are queried with a connection of dbaPool.
+func (axp *TxPool) LockWaits(dbaPool *dbconnpool.ConnectionPool) []proto.LockWait {
+	conn := getOrPanic(dbaPool)
+	defer conn.Recycle()
+	qr, err := conn.ExecuteFetch(lockWaitsQuery, 10000, false)
+	if err != nil {
+		panic(NewTabletErrorSql(FAIL, err))
+	}
+	transactionIds := make(map[int64]int64)
+	for _, v := range axp.activePool.GetAll() {
+		txc := v.(*TxConnection)
+		transactionIds[txc.ConnID] = txc.TransactionID
+	}
+	var waits []proto.LockWait
+	for _, row := range qr.Rows {
+		waitingConn, err := strconv.ParseInt(row[0].String(), 10, 64)
+		if err != nil {
+			panic(NewTabletError(FAIL, "invalid thread id %q: %v", row[0].String(), err))
+		}
+		blockingConn, err := strconv.ParseInt(row[1].String(), 10, 64)
+		if err != nil {
+			panic(NewTabletError(FAIL, "invalid thread id %q: %v", row[1].String(), err))
+		}
+		waiting, ok := transactionIds[waitingConn]
+		if !ok {
+			continue
+		}
+		blocking, ok := transactionIds[blockingConn]
+		if !ok {
+			continue
+		}
+		waits = append(waits, proto.LockWait{WaitingId: waiting, BlockingId: blocking})
+	}
+	return waits
+}
+
 func (axp *TxPool) Timeout() time.Duration {
 	return axp.timeout.Get()
 }
@@ -196,6 +408,7 @@ func (axp *TxPool) SetPoolTimeout(timeout time.Duration) {
 type TxConnection struct {
 	dbconnpool.PoolConnection
 	TransactionID int64
+	ConnID        int64
 	pool          *TxPool
 	inUse         bool
 	StartTime     time.Time
@@ -204,12 +417,17 @@ type TxConnection struct {
 	Queries       []string
 	Conclusion    string
 	LogToFile     sync2.AtomicInt32
+
+	// Redo has the statements that changed data, with
+	// which the transaction can be prepared again.
+	Redo []string
 }
 
 func newTxConnection(conn dbconnpool.PoolConnection, transactionId int64, pool *TxPool) *TxConnection {
 	return &TxConnection{
 		PoolConnection: conn,
 		TransactionID:  transactionId,
+		ConnID:         conn.Id(),
 		pool:           pool,
 		StartTime:      time.Now(),
 		dirtyTables:    make(map[string]DirtyKeys),
@@ -234,6 +452,41 @@ func (txc *TxConnection) Recycle() {
 	}
 }
 
+// ExecuteFetch executes query in the transaction,
+// and records it in Redo if it changes data.
+func (txc *TxConnection) ExecuteFetch(query string, maxrows int, wantfields bool) (*mproto.QueryResult, error) {
+	qr, err := txc.PoolConnection.ExecuteFetch(query, maxrows, wantfields)
+	if err == nil && isRedoStatement(query) {
+		txc.Redo = append(txc.Redo, query)
+	}
+	return qr, err
+}
+
+// isRedoStatement returns true if query must be replayed
+// to prepare its transaction again.
+func isRedoStatement(query string) bool {
+	query = strings.TrimSpace(query)
+	for strings.HasPrefix(query, "/*") {
+		end := strings.Index(query, "*/")
+		if end == -1 {
+			return false
+		}
+		query = strings.TrimSpace(query[end+2:])
+	}
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return false
+	}
+	switch words[0] {
+	case "insert", "update", "delete", "replace", "savepoint", "release":
+		return true
+	case "rollback":
+		// Only a rollback to a savepoint stays in the transaction.
+		return len(words) > 1 && words[1] != "work"
+	}
+	return false
+}
+
 func (txc *TxConnection) RecordQuery(query string) {
 	txc.Queries = append(txc.Queries, query)
 }
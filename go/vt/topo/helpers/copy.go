@@ -40,7 +40,19 @@ func CopyKeyspaces(fromTS, toTS topo.Server) {
 					log.Warningf("keyspace %v already exists", keyspace)
 				} else {
 					rec.RecordError(fmt.Errorf("CreateKeyspace(%v): %v", keyspace, err))
+					return
+				}
+			}
+
+			vschema, err := fromTS.GetVSchema(keyspace)
+			switch err {
+			case nil:
+				if err := toTS.SaveVSchema(keyspace, vschema); err != nil {
+					rec.RecordError(fmt.Errorf("SaveVSchema(%v): %v", keyspace, err))
 				}
+			case topo.ErrNoNode:
+			default:
+				rec.RecordError(fmt.Errorf("GetVSchema(%v): %v", keyspace, err))
 			}
 		}(keyspace)
 	}
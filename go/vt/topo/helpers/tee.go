@@ -197,6 +197,22 @@ func (tee *Tee) DeleteKeyspaceShards(keyspace string) error {
 	return nil
 }
 
+func (tee *Tee) SaveVSchema(keyspace, vschema string) error {
+	if err := tee.primary.SaveVSchema(keyspace, vschema); err != nil {
+		return err
+	}
+
+	if err := tee.secondary.SaveVSchema(keyspace, vschema); err != nil {
+		// not critical enough to fail
+		log.Warningf("secondary.SaveVSchema(%v) failed: %v", keyspace, err)
+	}
+	return nil
+}
+
+func (tee *Tee) GetVSchema(keyspace string) (string, error) {
+	return tee.readFrom.GetVSchema(keyspace)
+}
+
 //
 // Shard management, global.
 //
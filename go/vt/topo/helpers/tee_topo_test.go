@@ -52,6 +52,11 @@ func TestKeyspace(t *testing.T) {
 	test.CheckKeyspace(t, ts)
 }
 
+func TestVSchema(t *testing.T) {
+	ts := newFakeTeeServer(t)
+	test.CheckVSchema(t, ts)
+}
+
 func TestShard(t *testing.T) {
 	ts := newFakeTeeServer(t)
 	test.CheckShard(t, ts)
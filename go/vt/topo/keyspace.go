@@ -50,6 +50,13 @@ type Keyspace struct {
 	// That way we can guarantee a query that is targeted to 1/N of the
 	// keyspace will land on just one shard.
 	SplitShardCount int32
+
+	// TabletTypeRules maps the tablet types requested for
+	// this keyspace to the tablet types vtgate routes them
+	// to instead, e.g. to send the replica reads to rdonly
+	// tablets during an incident. The master is never
+	// overridden.
+	TabletTypeRules map[TabletType]TabletType
 }
 
 // KeyspaceInfo is a meta struct that contains metadata to give the
@@ -92,6 +92,14 @@ type Server interface {
 	// Use with caution.
 	DeleteKeyspaceShards(keyspace string) error
 
+	// SaveVSchema saves the JSON vschema of a keyspace, which
+	// describes its tables and vindexes for the V3 API of vtgate.
+	SaveVSchema(keyspace, vschema string) error
+
+	// GetVSchema returns the JSON vschema of a keyspace.
+	// Can return ErrNoNode if the keyspace has no vschema.
+	GetVSchema(keyspace string) (string, error)
+
 	//
 	// Shard management, global.
 	//
@@ -281,6 +289,18 @@ type Server interface {
 	GetSubprocessFlags() []string
 }
 
+// SrvKeyspaceWatcher is implemented by the Server implementations
+// that can notify their clients of the changes of a SrvKeyspace,
+// so that they don't have to poll it.
+type SrvKeyspaceWatcher interface {
+	// WatchSrvKeyspace starts watching the SrvKeyspace of keyspace
+	// in cell. The current value is sent on notifications right
+	// away, and then every new value as it changes. A nil value
+	// means that the SrvKeyspace doesn't exist. notifications is
+	// closed when the watch fails, or when stopWatching is closed.
+	WatchSrvKeyspace(cell, keyspace string) (notifications <-chan *SrvKeyspace, stopWatching chan<- struct{}, err error)
+}
+
 // Registry for Server implementations.
 var serverImpls map[string]Server = make(map[string]Server)
 
@@ -0,0 +1,50 @@
+// package test contains utilities to test topo.Server
+// implementations. If you are testing your implementation, you will
+// want to call CheckAll in your test method. For an example, look at
+// the tests in github.com/youtube/vitess/go/vt/zktopo.
+package test
+
+import (
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+// CheckVSchema runs the tests on the VSchema part of the API
+func CheckVSchema(t *testing.T, ts topo.Server) {
+	if err := ts.CreateKeyspace("test_keyspace", &topo.Keyspace{}); err != nil {
+		t.Fatalf("CreateKeyspace: %v", err)
+	}
+	if _, err := ts.GetVSchema("test_keyspace"); err != topo.ErrNoNode {
+		t.Errorf("GetVSchema(not there): %v", err)
+	}
+
+	vschema := `{"Sharded": true}`
+	if err := ts.SaveVSchema("test_keyspace", vschema); err != nil {
+		t.Fatalf("SaveVSchema: %v", err)
+	}
+	got, err := ts.GetVSchema("test_keyspace")
+	if err != nil {
+		t.Fatalf("GetVSchema: %v", err)
+	}
+	if got != vschema {
+		t.Errorf("GetVSchema: %q, want %q", got, vschema)
+	}
+
+	vschema = `{"Sharded": false}`
+	if err := ts.SaveVSchema("test_keyspace", vschema); err != nil {
+		t.Fatalf("SaveVSchema(again): %v", err)
+	}
+	if got, err = ts.GetVSchema("test_keyspace"); err != nil || got != vschema {
+		t.Errorf("GetVSchema(again): %q, %v, want %q", got, err, vschema)
+	}
+
+	// The vschema is not a shard of the keyspace.
+	shards, err := ts.GetShardNames("test_keyspace")
+	if err != nil && err != topo.ErrNoNode {
+		t.Errorf("GetShardNames: %v", err)
+	}
+	if len(shards) != 0 {
+		t.Errorf("GetShardNames: %v, want none", shards)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtctl
+
+import (
+	"flag"
+	"fmt"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/vtgate/backfill"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/wrangler"
+	"golang.org/x/net/context"
+)
+
+func init() {
+	addCommand("Schema, Version, Permissions", command{
+		"BackfillVindex",
+		commandBackfillVindex,
+		"[-batch_size=1000] [-rows_per_second=0] <vtgate schema file> <table> <vindex>",
+		"Creates the entries of a lookup vindex for the existing rows of the table that owns it. The vindex should be WriteOnly in the vtgate schema until the backfill is done. The lookup table must be in an unsharded keyspace."})
+}
+
+func commandBackfillVindex(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) error {
+	batchSize := subFlags.Int("batch_size", 1000, "number of rows to scan per query")
+	rowsPerSecond := subFlags.Int("rows_per_second", 0, "maximum number of rows to scan per second in each shard, 0 for no limit")
+	if err := subFlags.Parse(args); err != nil {
+		return err
+	}
+	if subFlags.NArg() != 3 {
+		return fmt.Errorf("action BackfillVindex requires <vtgate schema file> <table> <vindex>")
+	}
+	schema, err := planbuilder.LoadSchemaJSON(subFlags.Arg(0))
+	if err != nil {
+		return err
+	}
+	bf, err := backfill.NewBackfiller(schema, subFlags.Arg(1), subFlags.Arg(2), &backfillExecutor{wr: wr, maxRows: *batchSize}, wr.Logger())
+	if err != nil {
+		return err
+	}
+	bf.BatchSize = *batchSize
+	bf.RowsPerSecond = *rowsPerSecond
+	err = bf.Run(wr.Context())
+	for _, progress := range bf.Progress() {
+		wr.Logger().Printf("%v: %v rows scanned, %v entries created, done: %v\n", progress.Shard, progress.Rows, progress.Created, progress.Done)
+	}
+	return err
+}
+
+// backfillExecutor executes the queries of a backfill
+// on the master tablets, through the tablet manager.
+type backfillExecutor struct {
+	wr      *wrangler.Wrangler
+	maxRows int
+}
+
+func (be *backfillExecutor) Shards(ctx context.Context, keyspace string) ([]backfill.Shard, error) {
+	names, err := be.wr.TopoServer().GetShardNames(keyspace)
+	if err != nil {
+		return nil, err
+	}
+	shards := make([]backfill.Shard, 0, len(names))
+	for _, name := range names {
+		si, err := be.wr.TopoServer().GetShard(keyspace, name)
+		if err != nil {
+			return nil, err
+		}
+		shards = append(shards, backfill.Shard{Name: name, KeyRange: si.KeyRange})
+	}
+	return shards, nil
+}
+
+func (be *backfillExecutor) ExecuteShard(ctx context.Context, keyspace, shard string, query *tproto.BoundQuery) (*mproto.QueryResult, error) {
+	si, err := be.wr.TopoServer().GetShard(keyspace, shard)
+	if err != nil {
+		return nil, err
+	}
+	if si.MasterAlias.IsZero() {
+		return nil, fmt.Errorf("shard %v/%v has no master", keyspace, shard)
+	}
+	ti, err := be.wr.TopoServer().GetTablet(si.MasterAlias)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := sqlparser.Parse(query.Sql)
+	if err != nil {
+		return nil, err
+	}
+	buf := sqlparser.NewTrackedBuffer(nil)
+	buf.Myprintf("%v", stmt)
+	sql, err := buf.ParsedQuery().GenerateQuery(query.BindVariables)
+	if err != nil {
+		return nil, err
+	}
+	return be.wr.TabletManagerClient().ExecuteFetch(ctx, ti, string(sql), be.maxRows, true, false)
+}
@@ -5,6 +5,7 @@
 package vtctl
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -26,6 +27,7 @@ import (
 	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/topotools"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
 	"github.com/youtube/vitess/go/vt/wrangler"
 )
 
@@ -176,12 +178,24 @@ var commands = []commandGroup{
 			command{"GetKeyspace", commandGetKeyspace,
 				"<keyspace>",
 				"Outputs the json version of Keyspace to stdout."},
+			command{"GetVSchema", commandGetVSchema,
+				"<keyspace>",
+				"Outputs the json vschema of the keyspace to stdout."},
+			command{"ApplyVSchema", commandApplyVSchema,
+				"{-vschema=<vschema> || -vschema-file=<filename>} <keyspace>",
+				"Validates the json vschema of the keyspace, which describes its tables and vindexes for the V3 API of vtgate, and saves it in the topology. The vtgates that load their schema from the topology pick it up."},
+			command{"ValidateVSchema", commandValidateVSchema,
+				"{-vschema=<vschema> || -vschema-file=<filename>} <keyspace>",
+				"Checks the json vschema of the keyspace against the schema of the databases, as read from the master of the first shard of each keyspace, without saving it. It reports the missing tables and vindex columns, the columns whose type the vindexes cannot map, and the lookup tables that don't resolve."},
 			command{"SetKeyspaceShardingInfo", commandSetKeyspaceShardingInfo,
 				"[-force] [-split_shard_count=N] <keyspace name> [<column name>] [<column type>]",
 				"Updates the sharding info for a keyspace"},
 			command{"SetKeyspaceServedFrom", commandSetKeyspaceServedFrom,
 				"[-source=<source keyspace name>] [-remove] [-cells=c1,c2,...] <keyspace name> <tablet type>",
 				"Manually change the ServedFromMap. Only use this for an emergency fix. MigrateServedFrom will set this field appropriately already. Does not rebuild the serving graph."},
+			command{"SetKeyspaceTabletTypeRule", commandSetKeyspaceTabletTypeRule,
+				"<keyspace name> <tablet type> [<target tablet type>]",
+				"Makes vtgate route the queries of the tablet type to the tablets of the target tablet type instead, e.g. to move the replica reads to rdonly during an incident. Without a target tablet type, removes the rule. The vtgates that watch the tablet type rules pick it up."},
 			command{"RebuildKeyspaceGraph", commandRebuildKeyspaceGraph,
 				"[-cells=a,b] <keyspace> ...",
 				"Rebuild the serving data for all shards in this keyspace. This may trigger an update to all connected clients."},
@@ -1431,6 +1445,178 @@ func commandGetKeyspace(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []st
 	return err
 }
 
+func commandGetVSchema(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) error {
+	if err := subFlags.Parse(args); err != nil {
+		return err
+	}
+	if subFlags.NArg() != 1 {
+		return fmt.Errorf("action GetVSchema requires <keyspace>")
+	}
+
+	vschema, err := wr.TopoServer().GetVSchema(subFlags.Arg(0))
+	if err == nil {
+		wr.Logger().Printf("%v\n", vschema)
+	}
+	return err
+}
+
+func commandApplyVSchema(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) error {
+	vschema := subFlags.String("vschema", "", "json vschema of the keyspace")
+	vschemaFile := subFlags.String("vschema-file", "", "file containing the json vschema of the keyspace")
+	if err := subFlags.Parse(args); err != nil {
+		return err
+	}
+	if subFlags.NArg() != 1 {
+		return fmt.Errorf("action ApplyVSchema requires <keyspace>")
+	}
+	keyspace := subFlags.Arg(0)
+	data, err := getFileParam(*vschema, *vschemaFile, "vschema")
+	if err != nil {
+		return err
+	}
+	if _, err := wr.TopoServer().GetKeyspace(keyspace); err != nil {
+		return fmt.Errorf("cannot get keyspace %v: %v", keyspace, err)
+	}
+	if _, err := planbuilder.BuildKeyspaceSchemas(map[string]string{keyspace: data}); err != nil {
+		return err
+	}
+	return wr.TopoServer().SaveVSchema(keyspace, data)
+}
+
+func commandValidateVSchema(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) error {
+	vschema := subFlags.String("vschema", "", "json vschema of the keyspace")
+	vschemaFile := subFlags.String("vschema-file", "", "file containing the json vschema of the keyspace")
+	if err := subFlags.Parse(args); err != nil {
+		return err
+	}
+	if subFlags.NArg() != 1 {
+		return fmt.Errorf("action ValidateVSchema requires <keyspace>")
+	}
+	keyspace := subFlags.Arg(0)
+	data, err := getFileParam(*vschema, *vschemaFile, "vschema")
+	if err != nil {
+		return err
+	}
+	if _, err := wr.TopoServer().GetKeyspace(keyspace); err != nil {
+		return fmt.Errorf("cannot get keyspace %v: %v", keyspace, err)
+	}
+
+	// The proposed vschema is validated along with the
+	// current vschemas of the other keyspaces, which may
+	// contain its lookup tables.
+	keyspaces, err := wr.TopoServer().GetKeyspaces()
+	if err != nil {
+		return err
+	}
+	vschemas := map[string]string{keyspace: data}
+	for _, ks := range keyspaces {
+		if ks == keyspace {
+			continue
+		}
+		vs, err := wr.TopoServer().GetVSchema(ks)
+		switch err {
+		case nil:
+			vschemas[ks] = vs
+		case topo.ErrNoNode:
+		default:
+			return fmt.Errorf("cannot get the vschema of keyspace %v: %v", ks, err)
+		}
+	}
+	source := &planbuilder.SchemaFormal{Keyspaces: make(map[string]planbuilder.KeyspaceFormal, len(vschemas))}
+	databases := make(map[string]planbuilder.DBColumns, len(vschemas))
+	for ks, vs := range vschemas {
+		var formal planbuilder.KeyspaceFormal
+		if err := json.Unmarshal([]byte(vs), &formal); err != nil {
+			return fmt.Errorf("invalid vschema for keyspace %v: %v", ks, err)
+		}
+		source.Keyspaces[ks] = formal
+		columns, err := keyspaceColumns(wr, ks)
+		if err != nil {
+			return err
+		}
+		databases[ks] = columns
+	}
+
+	problems := planbuilder.ValidateSchema(source, nil, databases)
+	for _, problem := range problems {
+		wr.Logger().Printf("%v\n", problem)
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("vschema of keyspace %v has %v problem(s)", keyspace, len(problems))
+	}
+	return nil
+}
+
+// keyspaceColumns returns the columns of the tables of
+// keyspace, as read from the master of its first shard.
+func keyspaceColumns(wr *wrangler.Wrangler, keyspace string) (planbuilder.DBColumns, error) {
+	shards, err := wr.TopoServer().GetShardNames(keyspace)
+	if err != nil {
+		return nil, err
+	}
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("keyspace %v has no shards", keyspace)
+	}
+	sort.Strings(shards)
+	si, err := wr.TopoServer().GetShard(keyspace, shards[0])
+	if err != nil {
+		return nil, err
+	}
+	if si.MasterAlias.IsZero() {
+		return nil, fmt.Errorf("shard %v/%v has no master", keyspace, shards[0])
+	}
+	sd, err := wr.GetSchema(si.MasterAlias, nil, nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the schema of %v: %v", si.MasterAlias, err)
+	}
+	columns := make(planbuilder.DBColumns, len(sd.TableDefinitions))
+	for _, td := range sd.TableDefinitions {
+		columns[td.Name] = columnTypes(td.Schema)
+	}
+	return columns, nil
+}
+
+// columnTypes returns the types of the columns defined by the
+// lines of a CREATE TABLE statement, as printed by MySQL, like
+// "`id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,". The
+// attributes that follow the type are left out.
+func columnTypes(createTable string) map[string]string {
+	types := make(map[string]string)
+	for _, line := range strings.Split(createTable, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "`") {
+			continue
+		}
+		end := strings.Index(line[1:], "`")
+		if end == -1 {
+			continue
+		}
+		name := line[1 : end+1]
+		var words []string
+		for _, word := range strings.Fields(strings.TrimSuffix(line[end+2:], ",")) {
+			if columnAttributes[word] {
+				break
+			}
+			words = append(words, word)
+		}
+		types[name] = strings.Join(words, " ")
+	}
+	return types
+}
+
+// columnAttributes are the keywords that may follow
+// the type of a column in a CREATE TABLE statement.
+var columnAttributes = map[string]bool{
+	"NOT":            true,
+	"NULL":           true,
+	"DEFAULT":        true,
+	"AUTO_INCREMENT": true,
+	"COMMENT":        true,
+	"CHARACTER":      true,
+	"COLLATE":        true,
+	"ON":             true,
+}
+
 func commandSetKeyspaceShardingInfo(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) error {
 	force := subFlags.Bool("force", false, "will update the fields even if they're already set, use with care")
 	splitShardCount := subFlags.Int("split_shard_count", 0, "number of shards to use for data splits")
@@ -1457,6 +1643,27 @@ func commandSetKeyspaceShardingInfo(wr *wrangler.Wrangler, subFlags *flag.FlagSe
 	return wr.SetKeyspaceShardingInfo(keyspace, columnName, kit, int32(*splitShardCount), *force)
 }
 
+func commandSetKeyspaceTabletTypeRule(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) error {
+	if err := subFlags.Parse(args); err != nil {
+		return err
+	}
+	if subFlags.NArg() < 2 || subFlags.NArg() > 3 {
+		return fmt.Errorf("action SetKeyspaceTabletTypeRule requires <keyspace name> <tablet type> [<target tablet type>]")
+	}
+	keyspace := subFlags.Arg(0)
+	tabletType, err := parseTabletType(subFlags.Arg(1), []topo.TabletType{topo.TYPE_REPLICA, topo.TYPE_RDONLY})
+	if err != nil {
+		return err
+	}
+	var targetType topo.TabletType
+	if subFlags.NArg() == 3 {
+		if targetType, err = parseTabletType(subFlags.Arg(2), []topo.TabletType{topo.TYPE_REPLICA, topo.TYPE_RDONLY}); err != nil {
+			return err
+		}
+	}
+	return wr.SetKeyspaceTabletTypeRule(keyspace, tabletType, targetType)
+}
+
 func commandSetKeyspaceServedFrom(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) error {
 	source := subFlags.String("source", "", "source keyspace name")
 	remove := subFlags.Bool("remove", false, "remove the served from record instead of adding it")
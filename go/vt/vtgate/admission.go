@@ -0,0 +1,305 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+var (
+	keyspaceInFlightLimit = flag.Int("keyspace_in_flight_limit", 0, "maximum number of queries in flight through vtgate on each keyspace, 0 means no limit")
+	inFlightLimits        = flag.String("in_flight_limits", "", "comma separated limits that override keyspace_in_flight_limit, as keyspace:limit for a keyspace, or keyspace.table:limit for the queries on a table")
+	inFlightQueueTimeout  = flag.Duration("in_flight_queue_timeout", 1*time.Second, "time a query waits for the in-flight limits of its keyspace and tables before it fails")
+)
+
+// inFlightRejections counts the queries that failed
+// to get under an in-flight limit, by limit.
+var inFlightRejections = stats.NewCounters("VtgateInFlightRejections")
+
+// The priority classes of the queries. When the queries of a
+// keyspace or table are over their in-flight limit, the queued
+// queries of a class are admitted before those of the next ones.
+const (
+	classOLTP = iota
+	classBatch
+	numClasses
+)
+
+// classNames are the names of the classes in the stats.
+var classNames = [numClasses]string{"OLTP", "Batch"}
+
+// admissionQueueDepth is the number of queries waiting
+// for an in-flight limit, by priority class.
+var admissionQueueDepth = stats.NewCounters("VtgateAdmissionQueueDepth")
+
+// ResourceExhaustedError is returned for the queries that waited
+// for longer than the queue timeout for the in-flight limit of
+// their keyspace or table.
+type ResourceExhaustedError struct {
+	// Limit is the keyspace, or the keyspace.table, whose limit
+	// was exceeded.
+	Limit string
+}
+
+func (e *ResourceExhaustedError) Error() string {
+	return fmt.Sprintf("resource_exhausted: too many queries in flight on %s", e.Limit)
+}
+
+// admission caps the queries in flight through a ScatterConn
+// on each keyspace, and on the tables that have a limit. A query
+// that's over a limit waits for up to the queue timeout.
+type admission struct {
+	defaultLimit int
+	limits       map[string]int
+	tableLimits  bool
+	timeout      time.Duration
+
+	mu   sync.Mutex
+	sems map[string]*prioritySemaphore
+}
+
+// newAdmission creates an admission. defaultLimit is the limit of the
+// keyspaces, and spec lists the limits of keyspaces and tables as
+// described by the in_flight_limits flag. It returns nil if there
+// are no limits.
+func newAdmission(defaultLimit int, spec string, timeout time.Duration) (*admission, error) {
+	limits, err := parseInFlightLimits(spec)
+	if err != nil {
+		return nil, err
+	}
+	if defaultLimit <= 0 && len(limits) == 0 {
+		return nil, nil
+	}
+	adm := &admission{
+		defaultLimit: defaultLimit,
+		limits:       limits,
+		timeout:      timeout,
+		sems:         make(map[string]*prioritySemaphore),
+	}
+	for key := range limits {
+		if strings.Contains(key, ".") {
+			adm.tableLimits = true
+		}
+	}
+	return adm, nil
+}
+
+// parseInFlightLimits parses the value of the in_flight_limits flag.
+func parseInFlightLimits(spec string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid in-flight limit %q, want keyspace:limit or keyspace.table:limit", entry)
+		}
+		limit, err := strconv.Atoi(entry[i+1:])
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid in-flight limit %q: %v is not a valid limit", entry, entry[i+1:])
+		}
+		limits[entry[:i]] = limit
+	}
+	return limits, nil
+}
+
+// acquire waits until the queries on keyspace are under the limits
+// of keyspace and of their tables. They're queued in the priority
+// class of ctx. The returned function must be called once they're
+// done.
+func (adm *admission) acquire(ctx context.Context, keyspace string, queries []string) (release func(), err error) {
+	if adm == nil {
+		return func() {}, nil
+	}
+	class := priorityClass(ctx)
+	keys := []string{keyspace}
+	if adm.tableLimits {
+		tables := make(map[string]bool)
+		for _, query := range queries {
+			if table := queryTable(query); table != "" && !tables[table] {
+				tables[table] = true
+				keys = append(keys, keyspace+"."+table)
+			}
+		}
+		// A fixed order prevents queries on the same
+		// tables from waiting for each other.
+		sort.Strings(keys[1:])
+	}
+	var acquired []*prioritySemaphore
+	release = func() {
+		for _, sem := range acquired {
+			sem.release()
+		}
+	}
+	for _, key := range keys {
+		sem := adm.semaphore(key)
+		if sem == nil {
+			continue
+		}
+		if !sem.acquire(class) {
+			release()
+			inFlightRejections.Add(key, 1)
+			return nil, &ResourceExhaustedError{Limit: key}
+		}
+		acquired = append(acquired, sem)
+	}
+	return release, nil
+}
+
+// semaphore returns the semaphore of the limit of key,
+// or nil if key has no limit.
+func (adm *admission) semaphore(key string) *prioritySemaphore {
+	adm.mu.Lock()
+	defer adm.mu.Unlock()
+	if sem, ok := adm.sems[key]; ok {
+		return sem
+	}
+	limit, ok := adm.limits[key]
+	if !ok && !strings.Contains(key, ".") {
+		limit = adm.defaultLimit
+	}
+	var sem *prioritySemaphore
+	if limit > 0 {
+		sem = newPrioritySemaphore(limit, adm.timeout)
+	}
+	adm.sems[key] = sem
+	return sem
+}
+
+// prioritySemaphore is a counting semaphore whose waiters are
+// queued by priority class: a released slot goes to the waiter
+// of the first class that has one, in the order they came.
+type prioritySemaphore struct {
+	timeout time.Duration
+
+	mu     sync.Mutex
+	slots  int
+	queues [numClasses][]chan struct{}
+}
+
+// newPrioritySemaphore creates a prioritySemaphore with count
+// slots. A timeout of zero means that there is no timeout.
+func newPrioritySemaphore(count int, timeout time.Duration) *prioritySemaphore {
+	return &prioritySemaphore{
+		timeout: timeout,
+		slots:   count,
+	}
+}
+
+// acquire returns true on successful acquisition by
+// a waiter of class, and false on a timeout.
+func (sem *prioritySemaphore) acquire(class int) bool {
+	sem.mu.Lock()
+	if sem.slots > 0 {
+		sem.slots--
+		sem.mu.Unlock()
+		return true
+	}
+	ready := make(chan struct{})
+	sem.queues[class] = append(sem.queues[class], ready)
+	sem.mu.Unlock()
+
+	admissionQueueDepth.Add(classNames[class], 1)
+	defer admissionQueueDepth.Add(classNames[class], -1)
+	var timeout <-chan time.Time
+	if sem.timeout != 0 {
+		tm := time.NewTimer(sem.timeout)
+		defer tm.Stop()
+		timeout = tm.C
+	}
+	select {
+	case <-ready:
+		return true
+	case <-timeout:
+	}
+
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+	queue := sem.queues[class]
+	for i, waiter := range queue {
+		if waiter == ready {
+			sem.queues[class] = append(queue[:i:i], queue[i+1:]...)
+			return false
+		}
+	}
+	// The slot was handed over as the timeout fired.
+	return true
+}
+
+// release releases an acquired slot.
+func (sem *prioritySemaphore) release() {
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+	for class, queue := range sem.queues {
+		if len(queue) != 0 {
+			sem.queues[class] = queue[1:]
+			close(queue[0])
+			return
+		}
+	}
+	sem.slots++
+}
+
+type contextKey int
+
+const priorityKey contextKey = 0
+
+// withPriority returns a context that carries the priority
+// of a query, for the admission of its shard queries.
+func withPriority(ctx context.Context, priority string) (context.Context, error) {
+	switch priority {
+	case proto.PriorityOLTP:
+		return ctx, nil
+	case proto.PriorityBatch:
+		return context.WithValue(ctx, priorityKey, classBatch), nil
+	}
+	return nil, fmt.Errorf("invalid priority %q, want %q or %q", priority, proto.PriorityOLTP, proto.PriorityBatch)
+}
+
+// priorityClass returns the priority class of ctx.
+func priorityClass(ctx context.Context) int {
+	if class, ok := ctx.Value(priorityKey).(int); ok {
+		return class
+	}
+	return classOLTP
+}
+
+// queryTable returns the table of query, or "" if
+// it's not a simple statement on a single table.
+func queryTable(query string) string {
+	stmt, err := sqlparser.Parse(query)
+	if err != nil {
+		return ""
+	}
+	switch stmt := stmt.(type) {
+	case *sqlparser.Select:
+		if len(stmt.From) != 1 {
+			return ""
+		}
+		if aliased, ok := stmt.From[0].(*sqlparser.AliasedTableExpr); ok {
+			return sqlparser.GetTableName(aliased.Expr)
+		}
+	case *sqlparser.Insert:
+		return string(stmt.Table.Name)
+	case *sqlparser.Update:
+		return string(stmt.Table.Name)
+	case *sqlparser.Delete:
+		return string(stmt.Table.Name)
+	}
+	return ""
+}
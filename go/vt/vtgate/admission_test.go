@@ -0,0 +1,181 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// This file uses the sandbox_test framework.
+
+func TestParseInFlightLimits(t *testing.T) {
+	limits, err := parseInFlightLimits("ks1:10, ks1.t1:2,ks2:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"ks1": 10, "ks1.t1": 2, "ks2": 0}
+	if !reflect.DeepEqual(limits, want) {
+		t.Errorf("parseInFlightLimits: %v, want %v", limits, want)
+	}
+	for _, spec := range []string{"ks1", "ks1:a", ":10", "ks1:-1"} {
+		if _, err := parseInFlightLimits(spec); err == nil {
+			t.Errorf("parseInFlightLimits(%q): nil, want error", spec)
+		}
+	}
+}
+
+func TestQueryTable(t *testing.T) {
+	testcases := map[string]string{
+		"select * from t1 where id = 1":    "t1",
+		"select * from t1, t2":             "",
+		"select * from t1 join t2":         "",
+		"insert into t1(id) values (1)":    "t1",
+		"update t1 set a = 1 where id = 1": "t1",
+		"delete from t1 where id = 1":      "t1",
+		"set autocommit = 1":               "",
+		"not a query":                      "",
+	}
+	for query, want := range testcases {
+		if got := queryTable(query); got != want {
+			t.Errorf("queryTable(%s): %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	adm, err := newAdmission(0, "", 10*time.Millisecond)
+	if adm != nil || err != nil {
+		t.Errorf("newAdmission without limits: %v, %v, want nil, nil", adm, err)
+	}
+
+	adm, err = newAdmission(2, "ks2:0,ks1.t1:1", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release1, err := adm.acquire(context.Background(), "ks1", []string{"select * from t1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// t1 is at its limit.
+	_, err = adm.acquire(context.Background(), "ks1", []string{"select * from t1"})
+	if e, ok := err.(*ResourceExhaustedError); !ok || e.Limit != "ks1.t1" {
+		t.Errorf("acquire: %v, want ResourceExhaustedError on ks1.t1", err)
+	}
+	release2, err := adm.acquire(context.Background(), "ks1", []string{"select * from t2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ks1 is at its limit.
+	_, err = adm.acquire(context.Background(), "ks1", []string{"select * from t2"})
+	if e, ok := err.(*ResourceExhaustedError); !ok || e.Limit != "ks1" {
+		t.Errorf("acquire: %v, want ResourceExhaustedError on ks1", err)
+	}
+	// ks2 has no limit.
+	for i := 0; i < 3; i++ {
+		if _, err := adm.acquire(context.Background(), "ks2", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	release1()
+	release2()
+	if _, err := adm.acquire(context.Background(), "ks1", []string{"select * from t1"}); err != nil {
+		t.Errorf("acquire after release: %v", err)
+	}
+}
+
+func TestScatterConnInFlightLimit(t *testing.T) {
+	s := createSandbox("TestScatterConnInFlightLimit")
+	sbc := &sandboxConn{mustDelay: 50 * time.Millisecond}
+	s.MapTestConn("0", sbc)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Second)
+	stc.admission, _ = newAdmission(1, "", 10*time.Millisecond)
+
+	done := make(chan error)
+	go func() {
+		_, err := stc.Execute(context.Background(), "query", nil, "TestScatterConnInFlightLimit", []string{"0"}, "", nil)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	_, err := stc.Execute(context.Background(), "query", nil, "TestScatterConnInFlightLimit", []string{"0"}, "", nil)
+	if _, ok := err.(*ResourceExhaustedError); !ok {
+		t.Errorf("Execute: %v, want ResourceExhaustedError", err)
+	}
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if _, err := stc.Execute(context.Background(), "query", nil, "TestScatterConnInFlightLimit", []string{"0"}, "", nil); err != nil {
+		t.Errorf("Execute after the first query is done: %v", err)
+	}
+}
+
+func TestWithPriority(t *testing.T) {
+	ctx, err := withPriority(context.Background(), proto.PriorityBatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if class := priorityClass(ctx); class != classBatch {
+		t.Errorf("priorityClass: %d, want %d", class, classBatch)
+	}
+	if class := priorityClass(context.Background()); class != classOLTP {
+		t.Errorf("priorityClass: %d, want %d", class, classOLTP)
+	}
+	if _, err := withPriority(context.Background(), "urgent"); err == nil {
+		t.Errorf("withPriority(urgent): nil, want error")
+	}
+}
+
+// queued returns the number of waiters of sem.
+func queued(sem *prioritySemaphore) int {
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+	n := 0
+	for _, queue := range sem.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+func TestPrioritySemaphore(t *testing.T) {
+	sem := newPrioritySemaphore(1, 1*time.Second)
+	if !sem.acquire(classBatch) {
+		t.Fatalf("acquire: false, want true")
+	}
+	admitted := make(chan int, 2)
+	wait := func(class, waiters int) {
+		go func() {
+			if sem.acquire(class) {
+				admitted <- class
+			}
+		}()
+		for queued(sem) != waiters {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// The OLTP query that's queued last goes first.
+	wait(classBatch, 1)
+	wait(classOLTP, 2)
+	sem.release()
+	if class := <-admitted; class != classOLTP {
+		t.Errorf("first admitted: %d, want %d", class, classOLTP)
+	}
+	sem.release()
+	if class := <-admitted; class != classBatch {
+		t.Errorf("second admitted: %d, want %d", class, classBatch)
+	}
+
+	sem = newPrioritySemaphore(1, 10*time.Millisecond)
+	sem.acquire(classOLTP)
+	if sem.acquire(classBatch) {
+		t.Errorf("acquire on full semaphore: true, want false")
+	}
+	if queued(sem) != 0 {
+		t.Errorf("waiters after timeout: %d, want 0", queued(sem))
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// aggregateResult merges the partial aggregates returned by
+// the shards into a single row. aggregates specifies the merge
+// operation for each column.
+func aggregateResult(result *mproto.QueryResult, aggregates []string) (*mproto.QueryResult, error) {
+	if len(result.Rows) == 0 {
+		return result, nil
+	}
+	if len(result.Fields) != len(aggregates) {
+		return nil, fmt.Errorf("aggregate: column count mismatch: %d fields, %d aggregates", len(result.Fields), len(aggregates))
+	}
+	row := make([]sqltypes.Value, len(aggregates))
+	copy(row, result.Rows[0])
+	for _, next := range result.Rows[1:] {
+		for i, op := range aggregates {
+			val, err := aggregateValue(op, result.Fields[i].Type, row[i], next[i])
+			if err != nil {
+				return nil, err
+			}
+			row[i] = val
+		}
+	}
+	return &mproto.QueryResult{
+		Fields:       result.Fields,
+		RowsAffected: 1,
+		Rows:         [][]sqltypes.Value{row},
+	}, nil
+}
+
+// aggregateValue combines two partial aggregates.
+// NULL values are ignored, like in MySQL.
+func aggregateValue(op string, mysqlType int64, v1, v2 sqltypes.Value) (sqltypes.Value, error) {
+	if v2.IsNull() {
+		return v1, nil
+	}
+	if v1.IsNull() {
+		return v2, nil
+	}
+	switch op {
+	case planbuilder.AggregateCount, planbuilder.AggregateSum:
+		return addValues(mysqlType, v1, v2)
+	case planbuilder.AggregateMin, planbuilder.AggregateMax:
+		cmp, err := compareValues(mysqlType, v1, v2)
+		if err != nil {
+			return sqltypes.Value{}, err
+		}
+		if (op == planbuilder.AggregateMin) == (cmp <= 0) {
+			return v1, nil
+		}
+		return v2, nil
+	}
+	return sqltypes.Value{}, fmt.Errorf("aggregate: unsupported operation %s", op)
+}
+
+// addValues adds two numbers. The integers and the DECIMALs, which
+// is what MySQL returns for the SUM of integers, are added exactly,
+// and the result keeps the scale of the column. The other numbers
+// are added as floats.
+func addValues(mysqlType int64, v1, v2 sqltypes.Value) (sqltypes.Value, error) {
+	if mysqlType != mproto.VT_FLOAT && mysqlType != mproto.VT_DOUBLE {
+		if d1, d2, ok := parseDecimals(v1, v2); ok {
+			sum := d1.add(d2)
+			if sum.scale == 0 && isIntegerType(mysqlType) {
+				return sqltypes.MakeNumeric(sum.format()), nil
+			}
+			return sqltypes.MakeFractional(sum.format()), nil
+		}
+	}
+	f1, f2, err := parseFloats(v1, v2)
+	if err != nil {
+		return sqltypes.Value{}, err
+	}
+	return sqltypes.MakeFractional(strconv.AppendFloat(nil, f1+f2, 'f', -1, 64)), nil
+}
+
+// compareValues compares two values. Numbers are compared
+// numerically, everything else is compared byte-wise.
+func compareValues(mysqlType int64, v1, v2 sqltypes.Value) (int, error) {
+	n1, n2, err := convertValues(mysqlType, v1, v2)
+	if err != nil {
+		return 0, err
+	}
+	switch n1 := n1.(type) {
+	case int64:
+		if n2, ok := n2.(int64); ok {
+			return compareInt64(n1, n2), nil
+		}
+	case uint64:
+		if n2, ok := n2.(uint64); ok {
+			return compareUint64(n1, n2), nil
+		}
+	case []byte:
+		if v1.IsString() || v2.IsString() {
+			return bytes.Compare(v1.Raw(), v2.Raw()), nil
+		}
+		if d1, d2, ok := parseDecimals(v1, v2); ok {
+			return d1.cmp(d2), nil
+		}
+	}
+	f1, f2, err := parseFloats(v1, v2)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case f1 < f2:
+		return -1, nil
+	case f1 > f2:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func convertValues(mysqlType int64, v1, v2 sqltypes.Value) (n1, n2 interface{}, err error) {
+	if n1, err = mproto.Convert(mysqlType, v1); err != nil {
+		return nil, nil, err
+	}
+	if n2, err = mproto.Convert(mysqlType, v2); err != nil {
+		return nil, nil, err
+	}
+	return n1, n2, nil
+}
+
+func parseFloats(v1, v2 sqltypes.Value) (f1, f2 float64, err error) {
+	if f1, err = strconv.ParseFloat(v1.String(), 64); err != nil {
+		return 0, 0, err
+	}
+	if f2, err = strconv.ParseFloat(v2.String(), 64); err != nil {
+		return 0, 0, err
+	}
+	return f1, f2, nil
+}
+
+func isIntegerType(mysqlType int64) bool {
+	switch mysqlType {
+	case mproto.VT_TINY, mproto.VT_SHORT, mproto.VT_LONG, mproto.VT_LONGLONG, mproto.VT_INT24:
+		return true
+	}
+	return false
+}
+
+// decimal is an exact decimal number, unscaled / 10^scale.
+type decimal struct {
+	unscaled *big.Int
+	scale    int
+}
+
+// parseDecimal parses the text of an integer or a DECIMAL.
+func parseDecimal(s string) (decimal, bool) {
+	digits, scale := s, 0
+	if i := strings.IndexByte(s, '.'); i != -1 {
+		digits, scale = s[:i]+s[i+1:], len(s)-i-1
+	}
+	unscaled, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return decimal{}, false
+	}
+	return decimal{unscaled: unscaled, scale: scale}, true
+}
+
+func parseDecimals(v1, v2 sqltypes.Value) (d1, d2 decimal, ok bool) {
+	if d1, ok = parseDecimal(v1.String()); !ok {
+		return decimal{}, decimal{}, false
+	}
+	if d2, ok = parseDecimal(v2.String()); !ok {
+		return decimal{}, decimal{}, false
+	}
+	return d1, d2, true
+}
+
+// rescale returns d with scale digits after the point, if it has fewer.
+func (d decimal) rescale(scale int) decimal {
+	if scale <= d.scale {
+		return d
+	}
+	factor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale-d.scale)), nil)
+	return decimal{unscaled: factor.Mul(factor, d.unscaled), scale: scale}
+}
+
+func (d decimal) add(other decimal) decimal {
+	d, other = d.rescale(other.scale), other.rescale(d.scale)
+	return decimal{unscaled: new(big.Int).Add(d.unscaled, other.unscaled), scale: d.scale}
+}
+
+func (d decimal) cmp(other decimal) int {
+	d, other = d.rescale(other.scale), other.rescale(d.scale)
+	return d.unscaled.Cmp(other.unscaled)
+}
+
+// format returns the text of d, with scale digits after the point.
+func (d decimal) format() []byte {
+	digits := new(big.Int).Abs(d.unscaled).String()
+	if d.scale > 0 {
+		if len(digits) <= d.scale {
+			digits = strings.Repeat("0", d.scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-d.scale] + "." + digits[len(digits)-d.scale:]
+	}
+	if d.unscaled.Sign() < 0 {
+		digits = "-" + digits
+	}
+	return []byte(digits)
+}
+
+func compareInt64(n1, n2 int64) int {
+	switch {
+	case n1 < n2:
+		return -1
+	case n1 > n2:
+		return 1
+	}
+	return 0
+}
+
+func compareUint64(n1, n2 uint64) int {
+	switch {
+	case n1 < n2:
+		return -1
+	case n1 > n2:
+		return 1
+	}
+	return 0
+}
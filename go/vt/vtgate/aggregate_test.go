@@ -0,0 +1,125 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"testing"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+func TestAggregateValue(t *testing.T) {
+	null := sqltypes.Value{}
+	testcases := []struct {
+		op     string
+		typ    int64
+		v1, v2 sqltypes.Value
+		out    sqltypes.Value
+	}{{
+		op:  planbuilder.AggregateCount,
+		typ: mproto.VT_LONGLONG,
+		v1:  sqltypes.MakeNumeric([]byte("2")),
+		v2:  sqltypes.MakeNumeric([]byte("3")),
+		out: sqltypes.MakeNumeric([]byte("5")),
+	}, {
+		op:  planbuilder.AggregateSum,
+		typ: mproto.VT_LONGLONG,
+		v1:  sqltypes.MakeNumeric([]byte("18446744073709551614")),
+		v2:  sqltypes.MakeNumeric([]byte("1")),
+		out: sqltypes.MakeNumeric([]byte("18446744073709551615")),
+	}, {
+		op:  planbuilder.AggregateSum,
+		typ: mproto.VT_LONGLONG,
+		v1:  sqltypes.MakeNumeric([]byte("9223372036854775807")),
+		v2:  sqltypes.MakeNumeric([]byte("-9223372036854775808")),
+		out: sqltypes.MakeNumeric([]byte("-1")),
+	}, {
+		// The SUM of an integer column is a DECIMAL.
+		op:  planbuilder.AggregateSum,
+		typ: mproto.VT_NEWDECIMAL,
+		v1:  sqltypes.MakeFractional([]byte("9007199254740993")),
+		v2:  sqltypes.MakeFractional([]byte("18446744073709551615")),
+		out: sqltypes.MakeFractional([]byte("18455751272964292608")),
+	}, {
+		op:  planbuilder.AggregateSum,
+		typ: mproto.VT_NEWDECIMAL,
+		v1:  sqltypes.MakeFractional([]byte("0.10")),
+		v2:  sqltypes.MakeFractional([]byte("0.20")),
+		out: sqltypes.MakeFractional([]byte("0.30")),
+	}, {
+		op:  planbuilder.AggregateSum,
+		typ: mproto.VT_NEWDECIMAL,
+		v1:  sqltypes.MakeFractional([]byte("-1.005")),
+		v2:  sqltypes.MakeFractional([]byte("0.5")),
+		out: sqltypes.MakeFractional([]byte("-0.505")),
+	}, {
+		op:  planbuilder.AggregateMax,
+		typ: mproto.VT_NEWDECIMAL,
+		v1:  sqltypes.MakeFractional([]byte("9007199254740993.1")),
+		v2:  sqltypes.MakeFractional([]byte("9007199254740993.2")),
+		out: sqltypes.MakeFractional([]byte("9007199254740993.2")),
+	}, {
+		op:  planbuilder.AggregateSum,
+		typ: mproto.VT_DOUBLE,
+		v1:  sqltypes.MakeFractional([]byte("1.25")),
+		v2:  sqltypes.MakeFractional([]byte("2.5")),
+		out: sqltypes.MakeFractional([]byte("3.75")),
+	}, {
+		op:  planbuilder.AggregateSum,
+		typ: mproto.VT_NEWDECIMAL,
+		v1:  null,
+		v2:  sqltypes.MakeFractional([]byte("2.5")),
+		out: sqltypes.MakeFractional([]byte("2.5")),
+	}, {
+		op:  planbuilder.AggregateSum,
+		typ: mproto.VT_NEWDECIMAL,
+		v1:  sqltypes.MakeFractional([]byte("2.5")),
+		v2:  null,
+		out: sqltypes.MakeFractional([]byte("2.5")),
+	}, {
+		op:  planbuilder.AggregateMin,
+		typ: mproto.VT_LONGLONG,
+		v1:  sqltypes.MakeNumeric([]byte("10")),
+		v2:  sqltypes.MakeNumeric([]byte("9")),
+		out: sqltypes.MakeNumeric([]byte("9")),
+	}, {
+		op:  planbuilder.AggregateMax,
+		typ: mproto.VT_LONGLONG,
+		v1:  sqltypes.MakeNumeric([]byte("10")),
+		v2:  sqltypes.MakeNumeric([]byte("9")),
+		out: sqltypes.MakeNumeric([]byte("10")),
+	}, {
+		op:  planbuilder.AggregateMax,
+		typ: mproto.VT_NEWDECIMAL,
+		v1:  sqltypes.MakeFractional([]byte("10.5")),
+		v2:  sqltypes.MakeFractional([]byte("9.5")),
+		out: sqltypes.MakeFractional([]byte("10.5")),
+	}, {
+		op:  planbuilder.AggregateMin,
+		typ: mproto.VT_VAR_STRING,
+		v1:  sqltypes.MakeString([]byte("b")),
+		v2:  sqltypes.MakeString([]byte("a")),
+		out: sqltypes.MakeString([]byte("a")),
+	}}
+	for _, tcase := range testcases {
+		out, err := aggregateValue(tcase.op, tcase.typ, tcase.v1, tcase.v2)
+		if err != nil {
+			t.Errorf("aggregateValue(%s, %v, %v): %v", tcase.op, tcase.v1, tcase.v2, err)
+			continue
+		}
+		if !reflect.DeepEqual(out, tcase.out) {
+			t.Errorf("aggregateValue(%s, %v, %v): %v, want %v", tcase.op, tcase.v1, tcase.v2, out, tcase.out)
+		}
+	}
+
+	_, err := aggregateValue("avg", mproto.VT_LONGLONG, sqltypes.MakeNumeric([]byte("1")), sqltypes.MakeNumeric([]byte("1")))
+	want := "aggregate: unsupported operation avg"
+	if err == nil || err.Error() != want {
+		t.Errorf("aggregateValue(avg): %v, want %s", err, want)
+	}
+}
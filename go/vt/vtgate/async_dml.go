@@ -0,0 +1,361 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/bson"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+var (
+	asyncDMLDir         = flag.String("async_dml_dir", "", "directory of the journal of the asynchronous DML queue, the ExecuteAsync API is disabled if empty")
+	asyncDMLMaxQueue    = flag.Int("async_dml_max_queue", 10000, "maximum number of asynchronous DMLs waiting to be applied")
+	asyncDMLOverflow    = flag.String("async_dml_overflow", "reject", "what to do with an asynchronous DML when the queue is full: reject it, or drop_oldest to drop the oldest queued DML")
+	asyncDMLRetryDelay  = flag.Duration("async_dml_retry_delay", 1*time.Second, "time to wait before retrying an asynchronous DML that failed")
+	asyncDMLMaxAttempts = flag.Int("async_dml_max_attempts", 10, "number of times an asynchronous DML is tried before it's dropped, 0 means no limit")
+	asyncDMLTimeout     = flag.Duration("async_dml_timeout", 30*time.Second, "timeout of each attempt to apply an asynchronous DML")
+)
+
+const (
+	overflowReject     = "reject"
+	overflowDropOldest = "drop_oldest"
+)
+
+// asyncDMLStats counts the asynchronous DMLs that were enqueued,
+// applied, retried, dropped after their last attempt, and rejected
+// or dropped because the queue was full.
+var asyncDMLStats = stats.NewCounters("VtgateAsyncDML")
+
+// asyncDMLRecord is a record of the journal. A record with
+// a Query enqueues it, a record without marks the DML of
+// the Id as done.
+type asyncDMLRecord struct {
+	Id       int64
+	Query    *proto.Query
+	Enqueued int64
+}
+
+// asyncDML is a DML waiting to be applied.
+type asyncDML struct {
+	id       int64
+	query    *proto.Query
+	enqueued time.Time
+}
+
+// AsyncDMLQueue accepts DMLs that the client doesn't wait for,
+// like audit counters, and applies them in the background in the
+// order they were accepted. Every DML is recorded in a journal
+// before it's accepted, so that the DMLs that were not applied yet
+// are applied after vtgate restarts. A DML that fails is retried
+// up to maxAttempts times, and a DML that arrives when the queue
+// is full is handled according to the overflow policy.
+type AsyncDMLQueue struct {
+	execute     func(ctx context.Context, query *proto.Query) error
+	maxQueue    int
+	overflow    string
+	retryDelay  time.Duration
+	maxAttempts int
+	timeout     time.Duration
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	entries []*asyncDML
+	nextID  int64
+	journal *os.File
+	closed  bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewAsyncDMLQueue creates an AsyncDMLQueue that applies the DMLs
+// with execute, and loads the DMLs left in the journal in dir.
+// If statsName is not empty, the depth of the queue and the age
+// of its oldest DML are exported under that prefix.
+func NewAsyncDMLQueue(dir, statsName string, maxQueue int, overflow string, retryDelay time.Duration, maxAttempts int, timeout time.Duration, execute func(ctx context.Context, query *proto.Query) error) (*AsyncDMLQueue, error) {
+	if overflow != overflowReject && overflow != overflowDropOldest {
+		return nil, fmt.Errorf("invalid async DML overflow policy: %s", overflow)
+	}
+	q := &AsyncDMLQueue{
+		execute:     execute,
+		maxQueue:    maxQueue,
+		overflow:    overflow,
+		retryDelay:  retryDelay,
+		maxAttempts: maxAttempts,
+		timeout:     timeout,
+		done:        make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	if err := q.load(path.Join(dir, "async_dml.journal")); err != nil {
+		return nil, err
+	}
+	if statsName != "" {
+		stats.Publish(statsName+"Depth", stats.IntFunc(q.Depth))
+		stats.Publish(statsName+"OldestAge", stats.DurationFunc(q.OldestAge))
+	}
+	return q, nil
+}
+
+// load reads the DMLs that were not applied from the journal
+// at name, and rewrites the journal with only those DMLs.
+func (q *AsyncDMLQueue) load(name string) error {
+	pending := make(map[int64]*asyncDML)
+	var order []int64
+	if file, err := os.Open(name); err == nil {
+		reader := bufio.NewReader(file)
+		for {
+			var record asyncDMLRecord
+			err := bson.UnmarshalFromStream(reader, &record)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				// A record cut short by a crash was
+				// never acknowledged to the client.
+				log.Warningf("Ignoring the end of the async DML journal %s: %v", name, err)
+				break
+			}
+			if record.Id >= q.nextID {
+				q.nextID = record.Id + 1
+			}
+			if record.Query == nil {
+				delete(pending, record.Id)
+				continue
+			}
+			pending[record.Id] = &asyncDML{
+				id:       record.Id,
+				query:    record.Query,
+				enqueued: time.Unix(0, record.Enqueued),
+			}
+			order = append(order, record.Id)
+		}
+		file.Close()
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("cannot open the async DML journal: %v", err)
+	}
+	for _, id := range order {
+		if entry, ok := pending[id]; ok {
+			q.entries = append(q.entries, entry)
+		}
+	}
+
+	tmpName := name + ".tmp"
+	journal, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot create the async DML journal: %v", err)
+	}
+	q.journal = journal
+	for _, entry := range q.entries {
+		if err := q.write(asyncDMLRecord{Id: entry.id, Query: entry.query, Enqueued: entry.enqueued.UnixNano()}); err != nil {
+			journal.Close()
+			return err
+		}
+	}
+	if err := os.Rename(tmpName, name); err != nil {
+		journal.Close()
+		return fmt.Errorf("cannot create the async DML journal: %v", err)
+	}
+	if len(q.entries) != 0 {
+		log.Infof("Loaded %d async DMLs from %s", len(q.entries), name)
+	}
+	return nil
+}
+
+// write appends record to the journal, and syncs it to disk.
+// It must be called with mu held.
+func (q *AsyncDMLQueue) write(record asyncDMLRecord) error {
+	data, err := bson.Marshal(&record)
+	if err != nil {
+		return fmt.Errorf("cannot encode async DML: %v", err)
+	}
+	if _, err := q.journal.Write(data); err != nil {
+		return fmt.Errorf("cannot write the async DML journal: %v", err)
+	}
+	if err := q.journal.Sync(); err != nil {
+		return fmt.Errorf("cannot sync the async DML journal: %v", err)
+	}
+	return nil
+}
+
+// Open starts applying the DMLs of the queue.
+func (q *AsyncDMLQueue) Open() {
+	q.wg.Add(1)
+	go q.run()
+}
+
+// Close stops applying the DMLs. The DMLs left in the
+// queue are applied when the journal is loaded again.
+func (q *AsyncDMLQueue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.done)
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	q.wg.Wait()
+	q.journal.Close()
+}
+
+// Enqueue records query in the journal and queues it. It returns
+// as soon as the query is recorded, before it's applied. Only
+// DMLs outside of a transaction can be queued.
+func (q *AsyncDMLQueue) Enqueue(query *proto.Query) error {
+	if query.Session != nil && query.Session.InTransaction {
+		return fmt.Errorf("async DML cannot be in a transaction")
+	}
+	stmt, err := sqlparser.Parse(query.Sql)
+	if err != nil {
+		return err
+	}
+	switch stmt.(type) {
+	case *sqlparser.Insert, *sqlparser.Update, *sqlparser.Delete:
+	default:
+		return fmt.Errorf("async DML must be an insert, update or delete: %s", query.Sql)
+	}
+	// The queued DML doesn't carry the session of the client.
+	queued := *query
+	queued.Session = nil
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return fmt.Errorf("async DML queue is closed")
+	}
+	if q.maxQueue > 0 && len(q.entries) >= q.maxQueue {
+		if q.overflow == overflowReject {
+			asyncDMLStats.Add("Rejected", 1)
+			return fmt.Errorf("async_dml_queue_full: %d DMLs are queued", len(q.entries))
+		}
+		dropped := q.entries[0]
+		if err := q.write(asyncDMLRecord{Id: dropped.id}); err != nil {
+			return err
+		}
+		q.entries = q.entries[1:]
+		asyncDMLStats.Add("Dropped", 1)
+		log.Warningf("Async DML queue is full, dropping the oldest DML: %s", dropped.query.Sql)
+	}
+	entry := &asyncDML{
+		id:       q.nextID,
+		query:    &queued,
+		enqueued: time.Now(),
+	}
+	if err := q.write(asyncDMLRecord{Id: entry.id, Query: entry.query, Enqueued: entry.enqueued.UnixNano()}); err != nil {
+		return err
+	}
+	q.nextID++
+	q.entries = append(q.entries, entry)
+	asyncDMLStats.Add("Enqueued", 1)
+	q.cond.Signal()
+	return nil
+}
+
+// Depth returns the number of DMLs in the queue.
+func (q *AsyncDMLQueue) Depth() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return int64(len(q.entries))
+}
+
+// OldestAge returns the time the oldest DML of the queue has waited.
+func (q *AsyncDMLQueue) OldestAge() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) == 0 {
+		return 0
+	}
+	return time.Now().Sub(q.entries[0].enqueued)
+}
+
+func (q *AsyncDMLQueue) run() {
+	defer q.wg.Done()
+	for {
+		entry := q.next()
+		if entry == nil {
+			return
+		}
+		for attempt := 1; ; attempt++ {
+			err := q.apply(entry)
+			if err == nil {
+				asyncDMLStats.Add("Applied", 1)
+				break
+			}
+			if q.maxAttempts > 0 && attempt >= q.maxAttempts {
+				asyncDMLStats.Add("Failed", 1)
+				log.Errorf("Dropping async DML after %d attempts: %v, query: %s", attempt, err, entry.query.Sql)
+				break
+			}
+			asyncDMLStats.Add("Retries", 1)
+			select {
+			case <-q.done:
+				return
+			case <-time.After(q.retryDelay):
+			}
+		}
+		q.finish(entry)
+	}
+}
+
+// next returns the oldest DML, or nil if the queue is closed.
+func (q *AsyncDMLQueue) next() *asyncDML {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.entries) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil
+	}
+	return q.entries[0]
+}
+
+// apply executes a copy of the query of entry, which is
+// left untouched by the routing for the next attempts.
+func (q *AsyncDMLQueue) apply(entry *asyncDML) error {
+	query := *entry.query
+	query.BindVariables = make(map[string]interface{}, len(entry.query.BindVariables))
+	for k, v := range entry.query.BindVariables {
+		query.BindVariables[k] = v
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), q.timeout)
+	defer cancel()
+	return q.execute(ctx, &query)
+}
+
+// finish removes entry from the queue, unless the overflow
+// policy already dropped it, and marks it done in the journal.
+// The journal is truncated when the queue becomes empty.
+func (q *AsyncDMLQueue) finish(entry *asyncDML) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) == 0 || q.entries[0] != entry {
+		return
+	}
+	q.entries = q.entries[1:]
+	if len(q.entries) == 0 {
+		if err := q.journal.Truncate(0); err == nil {
+			if _, err := q.journal.Seek(0, 0); err == nil {
+				return
+			}
+		}
+	}
+	if err := q.write(asyncDMLRecord{Id: entry.id}); err != nil {
+		log.Errorf("Async DML %d is applied, but it could not be marked done, it will be applied again after a restart: %v", entry.id, err)
+	}
+}
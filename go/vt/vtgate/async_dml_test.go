@@ -0,0 +1,183 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// fakeDMLExecutor records the DMLs it applies, and fails
+// the ones that match failSql.
+type fakeDMLExecutor struct {
+	mu      sync.Mutex
+	failSql string
+	applied []string
+	calls   int
+}
+
+func (fde *fakeDMLExecutor) execute(ctx context.Context, query *proto.Query) error {
+	fde.mu.Lock()
+	defer fde.mu.Unlock()
+	fde.calls++
+	if query.Sql == fde.failSql {
+		return fmt.Errorf("failed")
+	}
+	fde.applied = append(fde.applied, query.Sql)
+	return nil
+}
+
+func (fde *fakeDMLExecutor) result() ([]string, int) {
+	fde.mu.Lock()
+	defer fde.mu.Unlock()
+	return fde.applied, fde.calls
+}
+
+func waitForEmptyQueue(t *testing.T, q *AsyncDMLQueue) {
+	for i := 0; q.Depth() != 0; i++ {
+		if i == 100 {
+			t.Fatalf("Depth: %d, want 0", q.Depth())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAsyncDMLQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "async_dml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fde := &fakeDMLExecutor{failSql: "update b set c = 1"}
+	q, err := NewAsyncDMLQueue(dir, "", 10, overflowReject, 1*time.Millisecond, 3, 1*time.Second, fde.execute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(&proto.Query{Sql: "select * from a"}); err == nil || !strings.Contains(err.Error(), "must be an insert") {
+		t.Errorf("Enqueue(select): %v, want must be an insert", err)
+	}
+	if err := q.Enqueue(&proto.Query{Sql: "update a set c = 1", Session: &proto.Session{InTransaction: true}}); err == nil {
+		t.Errorf("Enqueue in a transaction: nil, want error")
+	}
+	q.Open()
+	defer q.Close()
+	for _, sql := range []string{"update a set c = 1", "update b set c = 1", "delete from a"} {
+		if err := q.Enqueue(&proto.Query{Sql: sql}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForEmptyQueue(t, q)
+	applied, calls := fde.result()
+	want := []string{"update a set c = 1", "delete from a"}
+	if !reflect.DeepEqual(applied, want) {
+		t.Errorf("applied: %v, want %v", applied, want)
+	}
+	// The failing DML was tried 3 times.
+	if calls != 5 {
+		t.Errorf("calls: %d, want 5", calls)
+	}
+	if age := q.OldestAge(); age != 0 {
+		t.Errorf("OldestAge: %v, want 0", age)
+	}
+}
+
+func TestAsyncDMLQueueOverflow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "async_dml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fde := &fakeDMLExecutor{}
+	if _, err := NewAsyncDMLQueue(dir, "", 2, "block", 0, 0, 0, fde.execute); err == nil {
+		t.Errorf("NewAsyncDMLQueue with an invalid overflow policy: nil, want error")
+	}
+
+	q, err := NewAsyncDMLQueue(dir, "", 2, overflowReject, 0, 0, 1*time.Second, fde.execute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Enqueue(&proto.Query{Sql: "delete from a where id = 1"})
+	q.Enqueue(&proto.Query{Sql: "delete from a where id = 2"})
+	if err := q.Enqueue(&proto.Query{Sql: "delete from a where id = 3"}); err == nil || !strings.Contains(err.Error(), "async_dml_queue_full") {
+		t.Errorf("Enqueue on a full queue: %v, want async_dml_queue_full", err)
+	}
+	q.Close()
+
+	q, err = NewAsyncDMLQueue(dir, "", 2, overflowDropOldest, 0, 0, 1*time.Second, fde.execute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(&proto.Query{Sql: "delete from a where id = 3"}); err != nil {
+		t.Fatal(err)
+	}
+	q.Open()
+	defer q.Close()
+	waitForEmptyQueue(t, q)
+	applied, _ := fde.result()
+	want := []string{"delete from a where id = 2", "delete from a where id = 3"}
+	if !reflect.DeepEqual(applied, want) {
+		t.Errorf("applied: %v, want %v", applied, want)
+	}
+}
+
+func TestAsyncDMLQueueJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "async_dml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fde := &fakeDMLExecutor{}
+	q, err := NewAsyncDMLQueue(dir, "", 10, overflowReject, 0, 0, 1*time.Second, fde.execute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Open()
+	if err := q.Enqueue(&proto.Query{Sql: "update a set c = :c", BindVariables: map[string]interface{}{"c": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	waitForEmptyQueue(t, q)
+	q.Close()
+
+	// A queue that's not open only records the DML.
+	q, err = NewAsyncDMLQueue(dir, "", 10, overflowReject, 0, 0, 1*time.Second, fde.execute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Depth() != 0 {
+		t.Errorf("Depth: %d, want 0", q.Depth())
+	}
+	if err := q.Enqueue(&proto.Query{Sql: "update a set c = :c", BindVariables: map[string]interface{}{"c": 2}}); err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+
+	// A new queue loads the DML that was not applied.
+	q, err = NewAsyncDMLQueue(dir, "", 10, overflowReject, 0, 0, 1*time.Second, fde.execute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Depth() != 1 {
+		t.Fatalf("Depth: %d, want 1", q.Depth())
+	}
+	if c := q.entries[0].query.BindVariables["c"]; c != int64(2) {
+		t.Errorf("bind var c: %#v, want 2", c)
+	}
+	q.Open()
+	defer q.Close()
+	waitForEmptyQueue(t, q)
+	applied, _ := fde.result()
+	if len(applied) != 2 {
+		t.Errorf("applied: %v, want 2 DMLs", applied)
+	}
+}
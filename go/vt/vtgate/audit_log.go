@@ -0,0 +1,342 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/callinfo"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+)
+
+// The audit log records the DMLs and the DDLs that vtgate executes
+// for its clients, with the identity of the caller, the keyspaces
+// and shards they were sent to, and the rows they affected. The
+// entries are written to the sink named by the audit_log_sink flag
+// before the result is returned to the client. The queries that
+// vtgate runs on its own, like the vindex lookups, are recorded as
+// a part of the statement they're executed for.
+
+var (
+	auditLogSink      = flag.String("audit_log_sink", "", "sink the audit log of the DMLs and DDLs is written to: file, syslog, or another registered sink, empty disables it")
+	auditLogKeyspaces = flag.String("audit_log_keyspaces", "", "comma separated list of the keyspaces whose DMLs and DDLs are audited, empty audits all of them")
+
+	auditLogCounts = stats.NewCounters("VtgateAuditLog")
+)
+
+const auditKey contextKey = 6
+
+// AuditEntry is an entry of the audit log.
+type AuditEntry struct {
+	Time       time.Time
+	Username   string
+	RemoteAddr string
+	Method     string
+	// StatementType is the first keyword of the statement,
+	// like INSERT or ALTER.
+	StatementType string
+	Sql           string
+	TabletType    topo.TabletType
+	Keyspaces     []string
+	// Shards are the keyspace/shard the statement was sent to.
+	Shards       []string
+	RowsAffected uint64
+	Error        string
+}
+
+// AuditSink writes the entries of the audit log. Its methods
+// are not called concurrently.
+type AuditSink interface {
+	Write(entry *AuditEntry) error
+	Close() error
+}
+
+// AuditSinkFactory creates an AuditSink.
+type AuditSinkFactory func() (AuditSink, error)
+
+// Registry for AuditSink implementations.
+var auditSinks = make(map[string]AuditSinkFactory)
+
+// RegisterAuditSink adds an implementation for an AuditSink.
+// If an implementation with that name already exists, panics.
+// Call this in the 'init' function in your module.
+func RegisterAuditSink(name string, factory AuditSinkFactory) {
+	if auditSinks[name] != nil {
+		panic(fmt.Errorf("Duplicate AuditSink registration for %v", name))
+	}
+	auditSinks[name] = factory
+}
+
+// auditStatementTypes are the statement types that are audited.
+var auditStatementTypes = map[string]bool{
+	"INSERT":   true,
+	"UPDATE":   true,
+	"DELETE":   true,
+	"REPLACE":  true,
+	"CREATE":   true,
+	"ALTER":    true,
+	"DROP":     true,
+	"RENAME":   true,
+	"TRUNCATE": true,
+}
+
+// auditStatementType returns the first keyword of sql, after its
+// comments, in upper case, if sql is a DML or a DDL. It returns an
+// empty string otherwise.
+func auditStatementType(sql string) string {
+	for {
+		sql = strings.TrimLeft(sql, " \t\r\n(")
+		switch {
+		case strings.HasPrefix(sql, "/*"):
+			end := strings.Index(sql, "*/")
+			if end == -1 {
+				return ""
+			}
+			sql = sql[end+2:]
+		case strings.HasPrefix(sql, "--"), strings.HasPrefix(sql, "#"):
+			end := strings.Index(sql, "\n")
+			if end == -1 {
+				return ""
+			}
+			sql = sql[end+1:]
+		default:
+			end := strings.IndexAny(sql, " \t\r\n(/")
+			if end == -1 {
+				end = len(sql)
+			}
+			if keyword := strings.ToUpper(sql[:end]); auditStatementTypes[keyword] {
+				return keyword
+			}
+			return ""
+		}
+	}
+}
+
+// auditLog writes the entries of the audited keyspaces to sink.
+type auditLog struct {
+	// keyspaces are the audited keyspaces. All of
+	// them are audited if it's empty.
+	keyspaces map[string]bool
+
+	// mu serializes the writes to sink.
+	mu   sync.Mutex
+	sink AuditSink
+}
+
+func newAuditLog(sink AuditSink, keyspaces []string) *auditLog {
+	al := &auditLog{
+		keyspaces: make(map[string]bool),
+		sink:      sink,
+	}
+	for _, keyspace := range keyspaces {
+		if keyspace != "" {
+			al.keyspaces[keyspace] = true
+		}
+	}
+	return al
+}
+
+// auditRecord collects the keyspaces and shards that the
+// statements of an RPC are sent to, until they're done.
+type auditRecord struct {
+	log        *auditLog
+	ctx        context.Context
+	method     string
+	tabletType topo.TabletType
+	// statements are the audited statements of the RPC,
+	// at the index of their result.
+	statements map[int]AuditEntry
+
+	mu        sync.Mutex
+	keyspaces map[string]bool
+	shards    map[string]bool
+}
+
+// start returns a context that collects the shards the queries of
+// an RPC are sent to, and the record to finish when they're done.
+// keyspace is the keyspace of the RPC, if it has one. It returns
+// ctx and a nil record if al is nil or no query is a DML or a DDL.
+func (al *auditLog) start(ctx context.Context, method, keyspace string, tabletType topo.TabletType, sqls ...string) (context.Context, *auditRecord) {
+	if al == nil {
+		return ctx, nil
+	}
+	var ar *auditRecord
+	for i, sql := range sqls {
+		statementType := auditStatementType(sql)
+		if statementType == "" {
+			continue
+		}
+		if ar == nil {
+			ar = &auditRecord{
+				log:        al,
+				ctx:        ctx,
+				method:     method,
+				tabletType: tabletType,
+				statements: make(map[int]AuditEntry),
+				keyspaces:  make(map[string]bool),
+				shards:     make(map[string]bool),
+			}
+		}
+		ar.statements[i] = AuditEntry{StatementType: statementType, Sql: sql}
+	}
+	if ar == nil {
+		return ctx, nil
+	}
+	if keyspace != "" {
+		ar.keyspaces[keyspace] = true
+	}
+	return context.WithValue(ctx, auditKey, ar), ar
+}
+
+// addAuditShards records that the statements of the RPC
+// of ctx, if it's audited, were sent to shards of keyspace.
+func addAuditShards(ctx context.Context, keyspace string, shards map[string]struct{}) {
+	ar, ok := ctx.Value(auditKey).(*auditRecord)
+	if !ok {
+		return
+	}
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	ar.keyspaces[keyspace] = true
+	for shard := range shards {
+		ar.shards[keyspace+"/"+shard] = true
+	}
+}
+
+// finish writes the entries of the statements of the RPC, if
+// one of the keyspaces they were sent to is audited. rowsAffected
+// are the rows affected by each query of the RPC, if it succeeded,
+// and err its error otherwise. It does nothing if ar is nil.
+func (ar *auditRecord) finish(rowsAffected []uint64, err error) {
+	if ar == nil {
+		return
+	}
+	ar.mu.Lock()
+	keyspaces := sortedKeys(ar.keyspaces)
+	shards := sortedKeys(ar.shards)
+	ar.mu.Unlock()
+	if !ar.log.audits(keyspaces) {
+		return
+	}
+	info := callinfo.FromContext(ar.ctx)
+	now := time.Now()
+	indexes := make([]int, 0, len(ar.statements))
+	for i := range ar.statements {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		entry := ar.statements[i]
+		entry.Time = now
+		entry.Username = info.Username()
+		entry.RemoteAddr = info.RemoteAddr()
+		entry.Method = ar.method
+		entry.TabletType = ar.tabletType
+		entry.Keyspaces = keyspaces
+		entry.Shards = shards
+		if err != nil {
+			entry.Error = err.Error()
+		} else if i < len(rowsAffected) {
+			entry.RowsAffected = rowsAffected[i]
+		}
+		ar.log.write(&entry)
+	}
+}
+
+// finishResult finishes ar with the result of a single query.
+func (ar *auditRecord) finishResult(qr *mproto.QueryResult, err error) {
+	var rowsAffected []uint64
+	if qr != nil {
+		rowsAffected = []uint64{qr.RowsAffected}
+	}
+	ar.finish(rowsAffected, err)
+}
+
+// finishList finishes ar with the results of a batch of queries.
+func (ar *auditRecord) finishList(qrs *tproto.QueryResultList, err error) {
+	if ar == nil {
+		return
+	}
+	var rowsAffected []uint64
+	if qrs != nil {
+		rowsAffected = make([]uint64, len(qrs.List))
+		for i, qr := range qrs.List {
+			rowsAffected[i] = qr.RowsAffected
+		}
+	}
+	ar.finish(rowsAffected, err)
+}
+
+// boundQuerySqls returns the sql of each of queries.
+func boundQuerySqls(queries []tproto.BoundQuery) []string {
+	sqls := make([]string, len(queries))
+	for i, query := range queries {
+		sqls[i] = query.Sql
+	}
+	return sqls
+}
+
+// audits returns true if one of keyspaces is audited. The
+// statements that weren't sent to any keyspace are only
+// audited if all the keyspaces are.
+func (al *auditLog) audits(keyspaces []string) bool {
+	if len(al.keyspaces) == 0 {
+		return true
+	}
+	for _, keyspace := range keyspaces {
+		if al.keyspaces[keyspace] {
+			return true
+		}
+	}
+	return false
+}
+
+func (al *auditLog) write(entry *AuditEntry) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if err := al.sink.Write(entry); err != nil {
+		auditLogCounts.Add("WriteErrors", 1)
+		log.Errorf("Cannot write to the audit log: %v, entry: %+v", err, entry)
+		return
+	}
+	auditLogCounts.Add("Written", 1)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// initAuditLog returns the audit log that writes to the sink named
+// by the audit_log_sink flag, or nil if the flag is empty.
+func initAuditLog() *auditLog {
+	if *auditLogSink == "" {
+		return nil
+	}
+	factory := auditSinks[*auditLogSink]
+	if factory == nil {
+		log.Fatalf("No AuditSink named %v", *auditLogSink)
+	}
+	sink, err := factory()
+	if err != nil {
+		log.Fatalf("Cannot create the audit log sink %v: %v", *auditLogSink, err)
+	}
+	log.Infof("Using AuditSink: %v", *auditLogSink)
+	return newAuditLog(sink, strings.Split(*auditLogKeyspaces, ","))
+}
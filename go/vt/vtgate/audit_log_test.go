@@ -0,0 +1,157 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	rpcproto "github.com/youtube/vitess/go/rpcwrap/proto"
+	"github.com/youtube/vitess/go/sync2"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+type fakeAuditSink struct {
+	entries []*AuditEntry
+}
+
+func (fas *fakeAuditSink) Write(entry *AuditEntry) error {
+	fas.entries = append(fas.entries, entry)
+	return nil
+}
+
+func (fas *fakeAuditSink) Close() error {
+	return nil
+}
+
+func TestAuditStatementType(t *testing.T) {
+	testcases := []struct {
+		sql  string
+		want string
+	}{
+		{"insert into t values (1)", "INSERT"},
+		{"  Update t set a = 1", "UPDATE"},
+		{"/* comment */ delete from t", "DELETE"},
+		{"-- comment\nreplace into t values (1)", "REPLACE"},
+		{"create table t(id int)", "CREATE"},
+		{"alter table t add column a int", "ALTER"},
+		{"drop table t", "DROP"},
+		{"truncate t", "TRUNCATE"},
+		{"select * from t", ""},
+		{"/* insert */ select 1", ""},
+		{"/* unterminated", ""},
+		{"inserted", ""},
+		{"", ""},
+	}
+	for _, tcase := range testcases {
+		if got := auditStatementType(tcase.sql); got != tcase.want {
+			t.Errorf("auditStatementType(%q): %q, want %q", tcase.sql, got, tcase.want)
+		}
+	}
+}
+
+// auditLogRuns numbers the runs of TestVTGateAuditLog. RpcVTGate keeps
+// the connections to the shards it used, so every run needs its own
+// keyspace for its sandboxConns to be used.
+var auditLogRuns sync2.AtomicInt64
+
+func TestVTGateAuditLog(t *testing.T) {
+	keyspace := fmt.Sprintf("TestVTGateAuditLog%d", auditLogRuns.Add(1))
+	s := createSandbox(keyspace)
+	sbc0 := &sandboxConn{}
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("-20", sbc0)
+	s.MapTestConn("20-40", sbc1)
+	createSandbox("TestVTGateAuditLogOther").MapTestConn("0", &sandboxConn{})
+
+	sink := &fakeAuditSink{}
+	defer func(audit *auditLog) { RpcVTGate.audit = audit }(RpcVTGate.audit)
+	RpcVTGate.audit = newAuditLog(sink, []string{keyspace})
+
+	ctx := rpcproto.NewContext("10.0.0.1:1234")
+	rpcproto.SetUsername(ctx, "alice")
+	q := &proto.QueryShard{
+		Sql:        "insert into t values (1)",
+		Keyspace:   keyspace,
+		Shards:     []string{"-20", "20-40", "-20"},
+		TabletType: topo.TYPE_MASTER,
+	}
+	if err := RpcVTGate.ExecuteShard(ctx, q, new(proto.QueryResult)); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(sink.entries))
+	}
+	entry := sink.entries[0]
+	if entry.Time.IsZero() {
+		t.Errorf("entry.Time is zero")
+	}
+	want := &AuditEntry{
+		Time:          entry.Time,
+		Username:      "alice",
+		RemoteAddr:    "10.0.0.1:1234",
+		Method:        "ExecuteShard",
+		StatementType: "INSERT",
+		Sql:           q.Sql,
+		TabletType:    topo.TYPE_MASTER,
+		Keyspaces:     []string{keyspace},
+		Shards:        []string{keyspace + "/-20", keyspace + "/20-40"},
+		RowsAffected:  singleRowResult.RowsAffected * 2,
+	}
+	if !reflect.DeepEqual(entry, want) {
+		t.Errorf("entry:\n%+v, want\n%+v", entry, want)
+	}
+
+	// The queries that aren't DMLs or DDLs are not audited.
+	q.Sql = "select * from t"
+	if err := RpcVTGate.ExecuteShard(ctx, q, new(proto.QueryResult)); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.entries) != 1 {
+		t.Errorf("got %d entries, want 1", len(sink.entries))
+	}
+
+	// Neither are the keyspaces that aren't audited.
+	q = &proto.QueryShard{
+		Sql:        "delete from t",
+		Keyspace:   "TestVTGateAuditLogOther",
+		Shards:     []string{"0"},
+		TabletType: topo.TYPE_MASTER,
+	}
+	if err := RpcVTGate.ExecuteShard(ctx, q, new(proto.QueryResult)); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.entries) != 1 {
+		t.Errorf("got %d entries, want 1", len(sink.entries))
+	}
+
+	// A batch has an entry per DML, and the failures are recorded.
+	sbc1.mustFailServer = 1
+	bq := &proto.BatchQueryShard{
+		Queries: []tproto.BoundQuery{
+			{Sql: "update t set a = 1"},
+			{Sql: "select * from t"},
+			{Sql: "delete from t"},
+		},
+		Keyspace:   keyspace,
+		Shards:     []string{"20-40"},
+		TabletType: topo.TYPE_MASTER,
+	}
+	if err := RpcVTGate.ExecuteBatchShard(ctx, bq, new(proto.QueryResultList)); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(sink.entries))
+	}
+	for i, sql := range []string{"update t set a = 1", "delete from t"} {
+		entry := sink.entries[i+1]
+		if entry.Sql != sql || entry.Method != "ExecuteBatchShard" || entry.Error == "" {
+			t.Errorf("entry %d: %+v, want the failed %q", i+1, entry, sql)
+		}
+	}
+}
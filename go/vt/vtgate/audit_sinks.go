@@ -0,0 +1,78 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/syslog"
+)
+
+// The built-in audit log sinks: file writes the entries to a file as
+// JSON, one per line, rotated like the query log, and syslog sends
+// them as JSON to the local syslog daemon.
+
+var (
+	auditLogFile     = flag.String("audit_log_file", "", "file the audit log is written to by the file sink")
+	auditLogMaxSize  = flag.Int64("audit_log_max_size", 100*1024*1024, "size in bytes beyond which the audit log file is rotated")
+	auditLogMaxFiles = flag.Int("audit_log_max_files", 10, "number of rotated audit log files that are kept")
+	auditLogTag      = flag.String("audit_log_syslog_tag", "vtgate_audit", "tag of the audit log entries sent to syslog by the syslog sink")
+)
+
+func init() {
+	RegisterAuditSink("file", newFileAuditSink)
+	RegisterAuditSink("syslog", newSyslogAuditSink)
+}
+
+// fileAuditSink writes the entries to the audit_log_file.
+type fileAuditSink struct {
+	qlw *queryLogWriter
+}
+
+func newFileAuditSink() (AuditSink, error) {
+	if *auditLogFile == "" {
+		return nil, fmt.Errorf("the file audit log sink needs an audit_log_file")
+	}
+	qlw, err := newQueryLogWriter(*auditLogFile, "json", *auditLogMaxSize, *auditLogMaxFiles, auditLogCounts)
+	if err != nil {
+		return nil, err
+	}
+	return &fileAuditSink{qlw: qlw}, nil
+}
+
+func (fas *fileAuditSink) Write(entry *AuditEntry) error {
+	return fas.qlw.write(entry)
+}
+
+func (fas *fileAuditSink) Close() error {
+	return fas.qlw.close()
+}
+
+// syslogAuditSink sends the entries to syslog with the
+// notice severity and the auth facility.
+type syslogAuditSink struct {
+	writer *syslog.Writer
+}
+
+func newSyslogAuditSink() (AuditSink, error) {
+	writer, err := syslog.New(syslog.LOG_NOTICE|syslog.LOG_AUTH, *auditLogTag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogAuditSink{writer: writer}, nil
+}
+
+func (sas *syslogAuditSink) Write(entry *AuditEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return sas.writer.Notice(string(b))
+}
+
+func (sas *syslogAuditSink) Close() error {
+	return sas.writer.Close()
+}
@@ -0,0 +1,261 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package backfill populates a lookup vindex from the
+// existing rows of the table that owns it.
+//
+// A new lookup vindex is first added to the schema as WriteOnly,
+// so that vtgate maintains its entries for the rows that are
+// inserted and deleted from then on, without routing queries
+// through it. The Backfiller then creates the entries of the
+// rows that already existed. Once it's done, the WriteOnly flag
+// can be removed.
+package backfill
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/logutil"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"golang.org/x/net/context"
+)
+
+// Shard is a shard of a keyspace, and the keyrange it covers.
+type Shard struct {
+	Name     string
+	KeyRange key.KeyRange
+}
+
+// Executor executes the queries of a backfill.
+type Executor interface {
+	// Shards returns the shards of keyspace.
+	Shards(ctx context.Context, keyspace string) ([]Shard, error)
+	// ExecuteShard executes query on the master of a shard.
+	ExecuteShard(ctx context.Context, keyspace, shard string, query *tproto.BoundQuery) (*mproto.QueryResult, error)
+}
+
+// Progress is the progress of a backfill in one shard.
+type Progress struct {
+	Shard    string
+	KeyRange key.KeyRange
+	// Rows is the number of rows scanned, and Created
+	// is the number of entries that were created for them.
+	// The other rows already had their entries.
+	Rows, Created int64
+	Done          bool
+}
+
+// Backfiller creates the entries of a lookup vindex
+// for the rows of its owner table. The table is scanned
+// one shard at a time, in batches of BatchSize rows.
+// If RowsPerSecond is set, the scan is throttled to it.
+type Backfiller struct {
+	BatchSize     int
+	RowsPerSecond int
+
+	schema    *planbuilder.Schema
+	table     *planbuilder.Table
+	primary   *planbuilder.ColVindex
+	colVindex *planbuilder.ColVindex
+	executor  Executor
+	logger    logutil.Logger
+
+	// scanFirst and scanNext select the first batch
+	// of rows, and the batch after the last row.
+	scanFirst, scanNext string
+
+	mu       sync.Mutex
+	progress []Progress
+}
+
+// NewBackfiller creates a Backfiller for the vindex
+// named vindexName of table tableName.
+func NewBackfiller(schema *planbuilder.Schema, tableName, vindexName string, executor Executor, logger logutil.Logger) (*Backfiller, error) {
+	table, reason := schema.FindTable(tableName)
+	if table == nil {
+		return nil, fmt.Errorf("backfill: %s", reason)
+	}
+	var colVindex *planbuilder.ColVindex
+	for _, cv := range table.Owned {
+		if cv.Name == vindexName {
+			colVindex = cv
+		}
+	}
+	if colVindex == nil {
+		return nil, fmt.Errorf("backfill: vindex %s is not owned by table %s", vindexName, tableName)
+	}
+	if _, ok := colVindex.Vindex.(planbuilder.Lookup); !ok {
+		return nil, fmt.Errorf("backfill: vindex %s is not a lookup vindex", vindexName)
+	}
+	primary := table.ColVindexes[0]
+	// The rows are scanned in the order of the primary vindex
+	// column, and then the vindex column. Rows that have the same
+	// values for both share the same entry, so they don't need to
+	// be told apart.
+	sel := fmt.Sprintf("select %s, %s from %s where %s is not null", primary.Col, colVindex.Col, tableName, colVindex.Col)
+	order := fmt.Sprintf(" order by %s, %s limit :batch_size", primary.Col, colVindex.Col)
+	next := fmt.Sprintf(" and (%s > :last_primary or %s = :last_primary and %s > :last_value)", primary.Col, primary.Col, colVindex.Col)
+	return &Backfiller{
+		BatchSize: 1000,
+		schema:    schema,
+		table:     table,
+		primary:   primary,
+		colVindex: colVindex,
+		executor:  executor,
+		logger:    logger,
+		scanFirst: sel + order,
+		scanNext:  sel + next + order,
+	}, nil
+}
+
+// Run backfills the vindex in all the shards of the table.
+func (bf *Backfiller) Run(ctx context.Context) error {
+	shards, err := bf.executor.Shards(ctx, bf.table.Keyspace.Name)
+	if err != nil {
+		return err
+	}
+	bf.mu.Lock()
+	bf.progress = make([]Progress, len(shards))
+	for i, shard := range shards {
+		bf.progress[i] = Progress{Shard: shard.Name, KeyRange: shard.KeyRange}
+	}
+	bf.mu.Unlock()
+	for i, shard := range shards {
+		if err := bf.backfillShard(ctx, i, shard); err != nil {
+			return fmt.Errorf("backfill: shard %s: %v", shard.Name, err)
+		}
+	}
+	return nil
+}
+
+// Progress returns the progress of each shard.
+func (bf *Backfiller) Progress() []Progress {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+	return append([]Progress(nil), bf.progress...)
+}
+
+func (bf *Backfiller) backfillShard(ctx context.Context, index int, shard Shard) error {
+	vcursor := &vcursor{ctx: ctx, bf: bf}
+	lookup := bf.colVindex.Vindex.(planbuilder.Lookup)
+	mapper := bf.primary.Vindex.(planbuilder.Unique)
+	query := &tproto.BoundQuery{
+		Sql: bf.scanFirst,
+		BindVariables: map[string]interface{}{
+			"batch_size": bf.BatchSize,
+		},
+	}
+	start := time.Now()
+	var rows int64
+	for {
+		result, err := bf.executor.ExecuteShard(ctx, bf.table.Keyspace.Name, shard.Name, query)
+		if err != nil {
+			return err
+		}
+		var created int64
+		for _, row := range result.Rows {
+			primary, err := mproto.Convert(result.Fields[0].Type, row[0])
+			if err != nil {
+				return err
+			}
+			value, err := mproto.Convert(result.Fields[1].Type, row[1])
+			if err != nil {
+				return err
+			}
+			ksids, err := mapper.Map(vcursor, []interface{}{primary})
+			if err != nil {
+				return err
+			}
+			if err := lookup.Create(vcursor, value, ksids[0]); err != nil {
+				if !isDuplicate(err) {
+					return err
+				}
+			} else {
+				created++
+			}
+			query.BindVariables["last_primary"] = primary
+			query.BindVariables["last_value"] = value
+		}
+		query.Sql = bf.scanNext
+		rows += int64(len(result.Rows))
+
+		bf.mu.Lock()
+		bf.progress[index].Rows = rows
+		bf.progress[index].Created += created
+		if len(result.Rows) < bf.BatchSize {
+			bf.progress[index].Done = true
+		}
+		progress := bf.progress[index]
+		bf.mu.Unlock()
+		bf.logger.Infof("backfill %s: shard %s: %d rows scanned, %d entries created", bf.colVindex.Name, shard.Name, progress.Rows, progress.Created)
+		if progress.Done {
+			return nil
+		}
+		if err := bf.throttle(ctx, start, rows); err != nil {
+			return err
+		}
+	}
+}
+
+// throttle waits until scanning rows since start
+// doesn't exceed RowsPerSecond.
+func (bf *Backfiller) throttle(ctx context.Context, start time.Time, rows int64) error {
+	var wait time.Duration
+	if bf.RowsPerSecond > 0 {
+		wait = start.Add(time.Duration(rows) * time.Second / time.Duration(bf.RowsPerSecond)).Sub(time.Now())
+	}
+	if wait <= 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			return nil
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// isDuplicate returns true if err is a MySQL duplicate
+// key error, which means that the entry already exists.
+func isDuplicate(err error) bool {
+	return strings.Contains(err.Error(), "(errno 1062)")
+}
+
+// vcursor executes the queries of the vindexes. Lookup tables
+// must be in an unsharded keyspace.
+type vcursor struct {
+	ctx context.Context
+	bf  *Backfiller
+}
+
+func (vc *vcursor) Execute(query *tproto.BoundQuery) (*mproto.QueryResult, error) {
+	plan := planbuilder.BuildPlan(query.Sql, vc.bf.schema)
+	if plan.Table == nil {
+		return nil, fmt.Errorf("cannot route query: %s: %s", query.Sql, plan.Reason)
+	}
+	if plan.Table.Keyspace.Sharded {
+		return nil, fmt.Errorf("lookup table %s must be in an unsharded keyspace", plan.Table.Name)
+	}
+	shards, err := vc.bf.executor.Shards(vc.ctx, plan.Table.Keyspace.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(shards) != 1 {
+		return nil, fmt.Errorf("unsharded keyspace %s has %d shards", plan.Table.Keyspace.Name, len(shards))
+	}
+	return vc.bf.executor.ExecuteShard(vc.ctx, plan.Table.Keyspace.Name, shards[0].Name, query)
+}
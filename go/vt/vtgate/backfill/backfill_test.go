@@ -0,0 +1,222 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backfill
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/logutil"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	_ "github.com/youtube/vitess/go/vt/vtgate/vindexes"
+	"golang.org/x/net/context"
+)
+
+func buildSchema(t *testing.T) *planbuilder.Schema {
+	schema, err := planbuilder.BuildSchema(&planbuilder.SchemaFormal{
+		Keyspaces: map[string]planbuilder.KeyspaceFormal{
+			"user": {
+				Sharded: true,
+				Vindexes: map[string]planbuilder.VindexFormal{
+					"user_index": {
+						Type: "hash",
+					},
+					"name_user_map": {
+						Type: "lookup_hash_multi",
+						Params: map[string]interface{}{
+							"Table": "name_user_map",
+							"From":  "name",
+							"To":    "user_id",
+						},
+						Owner:     "user",
+						WriteOnly: true,
+					},
+				},
+				Tables: map[string]planbuilder.TableFormal{
+					"user": {
+						ColVindexes: []planbuilder.ColVindexFormal{
+							{Col: "id", Name: "user_index"},
+							{Col: "name", Name: "name_user_map"},
+						},
+					},
+				},
+			},
+			"lookup": {
+				Tables: map[string]planbuilder.TableFormal{
+					"name_user_map": {},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+// fakeExecutor returns the rows of the user table from shard -80,
+// and records the queries sent to the lookup keyspace.
+type fakeExecutor struct {
+	rows    [][]sqltypes.Value
+	scans   []*tproto.BoundQuery
+	inserts []map[string]interface{}
+}
+
+func (fe *fakeExecutor) Shards(ctx context.Context, keyspace string) ([]Shard, error) {
+	if keyspace == "lookup" {
+		return []Shard{{Name: "0"}}, nil
+	}
+	return []Shard{{Name: "-80"}, {Name: "80-"}}, nil
+}
+
+func (fe *fakeExecutor) ExecuteShard(ctx context.Context, keyspace, shard string, query *tproto.BoundQuery) (*mproto.QueryResult, error) {
+	if keyspace == "lookup" {
+		bv := make(map[string]interface{})
+		for k, v := range query.BindVariables {
+			bv[k] = v
+		}
+		fe.inserts = append(fe.inserts, bv)
+		if name, _ := bv["name"].([]byte); string(name) == "dup" {
+			return nil, errors.New("Duplicate entry 'dup' for key 'PRIMARY' (errno 1062)")
+		}
+		return &mproto.QueryResult{}, nil
+	}
+	result := &mproto.QueryResult{
+		Fields: []mproto.Field{{Type: mproto.VT_LONG}, {Type: mproto.VT_VAR_STRING}},
+	}
+	if shard != "-80" {
+		return result, nil
+	}
+	bv := make(map[string]interface{})
+	for k, v := range query.BindVariables {
+		bv[k] = v
+	}
+	fe.scans = append(fe.scans, &tproto.BoundQuery{Sql: query.Sql, BindVariables: bv})
+	start := 0
+	if _, ok := bv["last_primary"]; ok {
+		start = (len(fe.scans) - 1) * bv["batch_size"].(int)
+	}
+	end := start + bv["batch_size"].(int)
+	if end > len(fe.rows) {
+		end = len(fe.rows)
+	}
+	result.Rows = fe.rows[start:end]
+	return result, nil
+}
+
+func makeRow(id int, name string) []sqltypes.Value {
+	return []sqltypes.Value{
+		sqltypes.MakeNumeric([]byte(fmt.Sprintf("%d", id))),
+		sqltypes.MakeString([]byte(name)),
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	fe := &fakeExecutor{
+		rows: [][]sqltypes.Value{
+			makeRow(1, "a"),
+			makeRow(1, "b"),
+			makeRow(2, "dup"),
+		},
+	}
+	bf, err := NewBackfiller(buildSchema(t), "user", "name_user_map", fe, logutil.NewMemoryLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bf.BatchSize = 2
+	if err := bf.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	wantScans := []*tproto.BoundQuery{{
+		Sql: "select id, name from user where name is not null order by id, name limit :batch_size",
+		BindVariables: map[string]interface{}{
+			"batch_size": 2,
+		},
+	}, {
+		Sql: "select id, name from user where name is not null and (id > :last_primary or id = :last_primary and name > :last_value) order by id, name limit :batch_size",
+		BindVariables: map[string]interface{}{
+			"batch_size":   2,
+			"last_primary": int64(1),
+			"last_value":   []byte("b"),
+		},
+	}}
+	if !reflect.DeepEqual(fe.scans, wantScans) {
+		t.Errorf("scans:\n%+v, want\n%+v", fe.scans, wantScans)
+	}
+	wantInserts := []map[string]interface{}{
+		{"name": []byte("a"), "user_id": int64(1)},
+		{"name": []byte("b"), "user_id": int64(1)},
+		{"name": []byte("dup"), "user_id": int64(2)},
+	}
+	if !reflect.DeepEqual(fe.inserts, wantInserts) {
+		t.Errorf("inserts:\n%+v, want\n%+v", fe.inserts, wantInserts)
+	}
+	wantProgress := []Progress{
+		{Shard: "-80", Rows: 3, Created: 2, Done: true},
+		{Shard: "80-", Done: true},
+	}
+	if got := bf.Progress(); !reflect.DeepEqual(got, wantProgress) {
+		t.Errorf("Progress(): %+v, want %+v", got, wantProgress)
+	}
+}
+
+func TestBackfillThrottle(t *testing.T) {
+	fe := &fakeExecutor{
+		rows: [][]sqltypes.Value{
+			makeRow(1, "a"),
+			makeRow(2, "b"),
+		},
+	}
+	bf, err := NewBackfiller(buildSchema(t), "user", "name_user_map", fe, logutil.NewMemoryLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bf.BatchSize = 1
+	bf.RowsPerSecond = 20
+	start := time.Now()
+	if err := bf.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The scan waits 50ms after each of the first two rows.
+	if elapsed := time.Now().Sub(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Run took %v, want at least 100ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = bf.Run(ctx)
+	want := "backfill: shard -80: context canceled"
+	if err == nil || err.Error() != want {
+		t.Errorf("Run: %v, want %s", err, want)
+	}
+}
+
+func TestNewBackfiller(t *testing.T) {
+	schema := buildSchema(t)
+	testcases := []struct {
+		table, vindex, err string
+	}{{
+		table:  "nouser",
+		vindex: "name_user_map",
+		err:    "backfill: table nouser not found",
+	}, {
+		table:  "user",
+		vindex: "user_index",
+		err:    "backfill: vindex user_index is not owned by table user",
+	}}
+	for _, tcase := range testcases {
+		_, err := NewBackfiller(schema, tcase.table, tcase.vindex, &fakeExecutor{}, logutil.NewMemoryLogger())
+		if err == nil || err.Error() != tcase.err {
+			t.Errorf("NewBackfiller(%s, %s): %v, want %s", tcase.table, tcase.vindex, err, tcase.err)
+		}
+	}
+}
@@ -30,12 +30,17 @@ type Balancer struct {
 	getEndPoints       GetEndPointsFunc
 	retryDelay         time.Duration
 	resetDownConnDelay time.Duration
+	// policy picks the endpoint Get returns.
+	policy SelectionPolicy
+	// healthWindow is the window of the stats of the endpoints.
+	healthWindow time.Duration
 }
 
 type addressStatus struct {
 	endPoint  topo.EndPoint
 	timeRetry time.Time
 	balancer  *Balancer
+	stats     tabletStats
 }
 
 // NewBalancer creates a Balancer. getAddresses is the function
@@ -48,10 +53,13 @@ func NewBalancer(getEndPoints GetEndPointsFunc, retryDelay time.Duration) *Balan
 	blc.getEndPoints = getEndPoints
 	blc.retryDelay = retryDelay
 	blc.resetDownConnDelay = *resetDownConnDelay
+	blc.policy = GetSelectionPolicy()
+	blc.healthWindow = *tabletHealthWindow
 	return blc
 }
 
-// Get returns a single endpoint that was not recently marked down.
+// Get returns a single endpoint that was not recently marked down,
+// as picked by the selection policy of the Balancer.
 // If it finds an address that was down for longer than retryDelay,
 // it refreshes the list of addresses and returns the next available
 // node. If all addresses are marked down, it waits and retries.
@@ -73,6 +81,19 @@ func (blc *Balancer) Get() (endPoint topo.EndPoint, err error) {
 		return topo.EndPoint{}, err
 	}
 
+	// The endpoints that are not marked down come first.
+	now := time.Now()
+	var candidates []TabletHealth
+	for _, addrNode := range blc.addressNodes {
+		if addrNode.timeRetry.After(now) {
+			break
+		}
+		candidates = append(candidates, addrNode.stats.health(now, blc.healthWindow, addrNode.endPoint))
+	}
+	if len(candidates) != 0 {
+		return candidates[blc.policy.Select(candidates)].EndPoint, nil
+	}
+
 	// Return the first endpoint, sleep if we need to
 	addrNode := blc.addressNodes[0]
 	if addrNode.timeRetry.After(time.Now()) {
@@ -84,6 +105,52 @@ func (blc *Balancer) Get() (endPoint topo.EndPoint, err error) {
 	return addrNode.endPoint, nil
 }
 
+// GetAlternate returns an endpoint other than uid that was not
+// recently marked down, for a request that's sent to two endpoints.
+// Unlike Get, it doesn't wait. ok is false if there's none.
+func (blc *Balancer) GetAlternate(uid uint32) (endPoint topo.EndPoint, ok bool) {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	now := time.Now()
+	for _, addrNode := range blc.addressNodes {
+		if addrNode.endPoint.Uid != uid && !addrNode.timeRetry.After(now) {
+			return addrNode.endPoint, true
+		}
+	}
+	return topo.EndPoint{}, false
+}
+
+// Available returns true if the endpoint uid is known
+// and was not recently marked down.
+func (blc *Balancer) Available(uid uint32) bool {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	index := findAddrNode(blc.addressNodes, uid)
+	return index != -1 && !blc.addressNodes[index].timeRetry.After(time.Now())
+}
+
+// Record records the result of a query sent to the endpoint
+// uid, for the health the selection policy picks endpoints by.
+func (blc *Balancer) Record(uid uint32, err error) {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	if index := findAddrNode(blc.addressNodes, uid); index != -1 {
+		blc.addressNodes[index].stats.record(time.Now(), blc.healthWindow, tabletFailed(err))
+	}
+}
+
+// Health returns the health of the endpoints of the Balancer.
+func (blc *Balancer) Health() []TabletHealth {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	now := time.Now()
+	health := make([]TabletHealth, 0, len(blc.addressNodes))
+	for _, addrNode := range blc.addressNodes {
+		health = append(health, addrNode.stats.health(now, blc.healthWindow, addrNode.endPoint))
+	}
+	return health
+}
+
 // MarkDown marks the specified address down. Such addresses
 // will not be used by Balancer for the duration of retryDelay.
 func (blc *Balancer) MarkDown(uid uint32, reason string) {
@@ -0,0 +1,56 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/youtube/vitess/go/stats"
+	"golang.org/x/net/context"
+)
+
+// The deadline of a query is its timeout budget. Planning, the
+// vindex lookups and the execution on the shards all draw from it:
+// each vindex lookup can only use a fraction of the time that's
+// left, so that a slow lookup doesn't leave the query itself without
+// time, and each call to a tablet is limited to the time that's left.
+
+var lookupBudgetFraction = flag.Float64("lookup_budget_fraction", 0.5, "maximum fraction of the time left before the deadline of a query that each of its vindex lookups can use, 0 means no limit")
+
+// budgetExhausted counts the queries that ran out of
+// time before or during a phase, by phase.
+var budgetExhausted = stats.NewCounters("VtgateBudgetExhausted")
+
+// lookupContext returns the context of a vindex lookup made for a
+// query with context ctx. The lookup can use fraction of the time
+// left before the deadline of ctx. The returned function must be
+// called once the lookup is done.
+func lookupContext(ctx context.Context, fraction float64) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || fraction <= 0 || fraction >= 1 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(float64(deadline.Sub(time.Now()))*fraction))
+}
+
+// budgetUsed returns true if the deadline of ctx has passed.
+func budgetUsed(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && !time.Now().Before(deadline)
+}
+
+// checkBudget returns an error if the deadline of
+// ctx passed before the phase of the query.
+func checkBudget(ctx context.Context, phase string) error {
+	if budgetUsed(ctx) {
+		budgetExhausted.Add(phase, 1)
+		return fmt.Errorf("deadline_exceeded: no time left for the %s phase of the query", phase)
+	}
+	return nil
+}
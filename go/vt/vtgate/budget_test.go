@@ -0,0 +1,101 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// This file uses the sandbox_test framework.
+
+func TestLookupContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	parentDeadline, _ := ctx.Deadline()
+
+	lookupCtx, lookupCancel := lookupContext(ctx, 0.5)
+	defer lookupCancel()
+	deadline, ok := lookupCtx.Deadline()
+	if !ok {
+		t.Fatalf("lookup context has no deadline")
+	}
+	if remaining := deadline.Sub(time.Now()); remaining > 50*time.Millisecond || remaining < 30*time.Millisecond {
+		t.Errorf("lookup deadline in %v, want about 50ms", remaining)
+	}
+
+	lookupCtx, lookupCancel = lookupContext(ctx, 0)
+	defer lookupCancel()
+	if deadline, _ := lookupCtx.Deadline(); deadline != parentDeadline {
+		t.Errorf("lookup deadline with no fraction: %v, want %v", deadline, parentDeadline)
+	}
+
+	lookupCtx, lookupCancel = lookupContext(context.Background(), 0.5)
+	defer lookupCancel()
+	if _, ok := lookupCtx.Deadline(); ok {
+		t.Errorf("lookup context of a query without deadline has a deadline")
+	}
+}
+
+func TestCheckBudget(t *testing.T) {
+	if err := checkBudget(context.Background(), "Execute"); err != nil {
+		t.Errorf("checkBudget without deadline: %v, want nil", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	err := checkBudget(ctx, "Execute")
+	if err == nil || !strings.Contains(err.Error(), "deadline_exceeded") {
+		t.Errorf("checkBudget after deadline: %v, want deadline_exceeded", err)
+	}
+}
+
+func TestShardConnCallTimeout(t *testing.T) {
+	sdc := NewShardConn(context.Background(), new(sandboxTopo), "aa", "TestShardConnCallTimeout", "0", "", 1*time.Millisecond, 3, 1*time.Second)
+	if timeout := sdc.callTimeout(context.Background()); timeout != 1*time.Second {
+		t.Errorf("callTimeout without deadline: %v, want 1s", timeout)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if timeout := sdc.callTimeout(ctx); timeout > 10*time.Millisecond {
+		t.Errorf("callTimeout: %v, want at most 10ms", timeout)
+	}
+}
+
+func TestLookupBudget(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	s.MapTestConn("-20", &sandboxConn{})
+	l := createSandbox(TEST_UNSHARDED)
+	sbclookup := &sandboxConn{mustDelay: 200 * time.Millisecond}
+	l.MapTestConn("0", sbclookup)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Millisecond, 0, 1*time.Second)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	router.lookupBudgetFraction = 0.5
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	startTime := time.Now()
+	_, err = router.Execute(ctx, &proto.Query{
+		Sql:        "select * from user where name = 'foo'",
+		TabletType: topo.TYPE_MASTER,
+	})
+	if err == nil || !strings.Contains(err.Error(), "deadline_exceeded") {
+		t.Errorf("Execute: %v, want deadline_exceeded", err)
+	}
+	// The lookup gave up when it used half of the deadline.
+	if d := time.Now().Sub(startTime); d >= 100*time.Millisecond {
+		t.Errorf("Execute took %v, want less than 100ms", d)
+	}
+}
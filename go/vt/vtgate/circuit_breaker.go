@@ -0,0 +1,192 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+)
+
+var (
+	circuitBreakerErrorRate   = flag.Float64("circuit_breaker_error_rate", 0, "fraction of the queries to a shard that must fail within a window to open its circuit breaker, 0 disables the circuit breakers")
+	circuitBreakerMinRequests = flag.Int("circuit_breaker_min_requests", 20, "minimum number of queries to a shard within a window before its circuit breaker can open")
+	circuitBreakerWindow      = flag.Duration("circuit_breaker_window", 10*time.Second, "time window over which the error rate of a shard is computed")
+	circuitBreakerOpenTime    = flag.Duration("circuit_breaker_open_time", 5*time.Second, "time an open circuit breaker fails the queries to its shard before it lets a probe through")
+	circuitBreakerSlowTime    = flag.Duration("circuit_breaker_slow_time", 0, "queries to a shard that take longer than this count as failures for its circuit breaker, 0 means only errors do")
+)
+
+// The states of a circuit breaker, as exported by breakerStates.
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var (
+	// breakerStates is the state of the circuit breaker of each shard.
+	breakerStates = stats.NewCounters("VtgateCircuitBreakerStates")
+	// breakerErrorRates is the percentage of the queries that
+	// failed in the current window, by shard.
+	breakerErrorRates = stats.NewCounters("VtgateCircuitBreakerErrorRates")
+	// breakerLatencies is the average latency of the queries
+	// in the current window in milliseconds, by shard.
+	breakerLatencies = stats.NewCounters("VtgateCircuitBreakerLatencies")
+	// breakerTrips counts how often the breakers opened, by shard.
+	breakerTrips = stats.NewCounters("VtgateCircuitBreakerTrips")
+	// breakerRejections counts the queries that failed
+	// fast because of an open breaker, by shard.
+	breakerRejections = stats.NewCounters("VtgateCircuitBreakerRejections")
+)
+
+// circuitBreakers keeps a circuit breaker per cell, shard and
+// tablet type. When too many of the queries to a shard fail within a
+// window, its breaker opens, and the queries to the shard fail
+// right away. After the open time, a single probe query is let
+// through: if it succeeds the breaker closes, otherwise it stays
+// open for another open time.
+type circuitBreakers struct {
+	errorRate   float64
+	minRequests int64
+	window      time.Duration
+	openTime    time.Duration
+	slowTime    time.Duration
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+type circuitBreaker struct {
+	state int
+	// windowStart is the start of the window of
+	// requests, errors and latency.
+	windowStart time.Time
+	requests    int64
+	errors      int64
+	latency     time.Duration
+	// openedAt is the time the breaker last opened.
+	openedAt time.Time
+}
+
+// newCircuitBreakers creates a circuitBreakers. It returns
+// nil if errorRate is 0, which disables the breakers.
+func newCircuitBreakers(errorRate float64, minRequests int, window, openTime, slowTime time.Duration) *circuitBreakers {
+	if errorRate <= 0 {
+		return nil
+	}
+	return &circuitBreakers{
+		errorRate:   errorRate,
+		minRequests: int64(minRequests),
+		window:      window,
+		openTime:    openTime,
+		slowTime:    slowTime,
+		breakers:    make(map[string]*circuitBreaker),
+	}
+}
+
+// allow returns an error if the breaker of key is open. Otherwise,
+// the returned function must be called with the result of the query.
+func (cbs *circuitBreakers) allow(key string) (done func(err error), err error) {
+	if cbs == nil {
+		return func(error) {}, nil
+	}
+	cbs.mu.Lock()
+	defer cbs.mu.Unlock()
+	cb, ok := cbs.breakers[key]
+	if !ok {
+		cb = &circuitBreaker{windowStart: time.Now()}
+		cbs.breakers[key] = cb
+	}
+	probe := false
+	switch cb.state {
+	case breakerOpen:
+		if time.Now().Sub(cb.openedAt) < cbs.openTime {
+			breakerRejections.Add(key, 1)
+			return nil, circuitOpenError(key)
+		}
+		cb.state = breakerHalfOpen
+		breakerStates.Set(key, breakerHalfOpen)
+		probe = true
+	case breakerHalfOpen:
+		// A probe is in flight.
+		breakerRejections.Add(key, 1)
+		return nil, circuitOpenError(key)
+	}
+	startTime := time.Now()
+	return func(err error) {
+		cbs.record(key, cb, probe, err, time.Now().Sub(startTime))
+	}, nil
+}
+
+// record records the result of a query that the breaker cb of key
+// let through. probe is true if it was the probe of an open breaker.
+func (cbs *circuitBreakers) record(key string, cb *circuitBreaker, probe bool, err error, latency time.Duration) {
+	cbs.mu.Lock()
+	defer cbs.mu.Unlock()
+	failed := shardUnavailable(err) || (cbs.slowTime > 0 && latency > cbs.slowTime)
+	now := time.Now()
+	if probe {
+		if failed {
+			cb.state = breakerOpen
+			cb.openedAt = now
+			breakerStates.Set(key, breakerOpen)
+			return
+		}
+		cb.state = breakerClosed
+		cb.reset(now)
+		breakerStates.Set(key, breakerClosed)
+	}
+	if cb.state != breakerClosed {
+		return
+	}
+	if now.Sub(cb.windowStart) > cbs.window {
+		cb.reset(now)
+	}
+	cb.requests++
+	cb.latency += latency
+	if failed {
+		cb.errors++
+	}
+	breakerErrorRates.Set(key, cb.errors*100/cb.requests)
+	breakerLatencies.Set(key, int64(cb.latency/time.Millisecond)/cb.requests)
+	if cb.requests >= cbs.minRequests && float64(cb.errors) >= cbs.errorRate*float64(cb.requests) {
+		cb.state = breakerOpen
+		cb.openedAt = now
+		breakerStates.Set(key, breakerOpen)
+		breakerTrips.Add(key, 1)
+	}
+}
+
+// reset starts a new window at now.
+func (cb *circuitBreaker) reset(now time.Time) {
+	cb.windowStart = now
+	cb.requests = 0
+	cb.errors = 0
+	cb.latency = 0
+}
+
+// circuitOpenError returns the error of the queries rejected by the
+// open breaker of key. Like the failures that opened the breaker,
+// it means that vtgate can't reach the tablets of the shard.
+func circuitOpenError(key string) error {
+	return &ShardConnError{
+		Code:            tabletconn.ERR_NORMAL,
+		ShardIdentifier: key,
+		Err:             "circuit_open: too many queries failed, not sending queries to the shard",
+	}
+}
+
+// shardUnavailable returns true if err means that vtgate couldn't
+// reach the tablets of a shard, or that they weren't serving.
+func shardUnavailable(err error) bool {
+	connError, ok := err.(*ShardConnError)
+	if !ok {
+		return false
+	}
+	return !connError.ServerError || connError.Code == tabletconn.ERR_RETRY || connError.Code == tabletconn.ERR_FATAL
+}
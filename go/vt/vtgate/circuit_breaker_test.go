@@ -0,0 +1,124 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"golang.org/x/net/context"
+)
+
+// This file uses the sandbox_test framework.
+
+var (
+	unreachableErr = &ShardConnError{Err: "error: conn"}
+	serverErr      = &ShardConnError{Code: tabletconn.ERR_NORMAL, Err: "error: err", ServerError: true}
+)
+
+// breakerResult lets a query through the breaker of key,
+// and records err as its result.
+func breakerResult(t *testing.T, cbs *circuitBreakers, key string, err error) {
+	done, aerr := cbs.allow(key)
+	if aerr != nil {
+		t.Fatalf("allow(%s): %v, want nil", key, aerr)
+	}
+	done(err)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cbs := newCircuitBreakers(0.5, 4, 1*time.Second, 20*time.Millisecond, 0)
+	key := "TestCircuitBreaker"
+	// The counters are global, reset them in case the test is repeated.
+	breakerTrips.Set(key, 0)
+	// The errors returned by vttablet don't count.
+	for i := 0; i < 4; i++ {
+		breakerResult(t, cbs, key, serverErr)
+	}
+	breakerResult(t, cbs, key, nil)
+	for i := 0; i < 5; i++ {
+		breakerResult(t, cbs, key, unreachableErr)
+	}
+	trips := breakerTrips.Counts()[key]
+	if trips != 1 {
+		t.Errorf("breakerTrips: %d, want 1", trips)
+	}
+	if _, err := cbs.allow(key); err == nil || !strings.Contains(err.Error(), "circuit_open") {
+		t.Fatalf("allow on open breaker: %v, want circuit_open", err)
+	}
+
+	// After the open time, one probe is let through.
+	time.Sleep(25 * time.Millisecond)
+	done, err := cbs.allow(key)
+	if err != nil {
+		t.Fatalf("allow probe: %v", err)
+	}
+	if _, err := cbs.allow(key); err == nil {
+		t.Errorf("allow during probe: nil, want error")
+	}
+	// The failed probe opens the breaker again.
+	done(unreachableErr)
+	if _, err := cbs.allow(key); err == nil {
+		t.Errorf("allow after failed probe: nil, want error")
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	breakerResult(t, cbs, key, nil)
+	if state := breakerStates.Counts()[key]; state != breakerClosed {
+		t.Errorf("state after probe: %d, want closed", state)
+	}
+	breakerResult(t, cbs, key, nil)
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	cbs := newCircuitBreakers(0, 4, 1*time.Second, 20*time.Millisecond, 0)
+	if cbs != nil {
+		t.Fatalf("newCircuitBreakers with no error rate: %v, want nil", cbs)
+	}
+	for i := 0; i < 10; i++ {
+		breakerResult(t, cbs, "TestCircuitBreakerDisabled", unreachableErr)
+	}
+}
+
+func TestScatterConnCircuitBreaker(t *testing.T) {
+	s := createSandbox("TestScatterConnCircuitBreaker")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{mustFailConn: 1000}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 0, 1*time.Second)
+	stc.breakers = newCircuitBreakers(0.5, 2, 1*time.Second, 1*time.Second, 0)
+	shardVars := map[string]map[string]interface{}{"0": nil, "1": nil}
+	session := NewSafeSession(nil)
+	for i := 0; i < 2; i++ {
+		if _, err := stc.ExecuteMulti(context.Background(), "select * from t", "TestScatterConnCircuitBreaker", shardVars, "", session); err == nil {
+			t.Fatalf("ExecuteMulti: nil, want error")
+		}
+	}
+	if sbc1.ExecCount.Get() != 2 {
+		t.Errorf("ExecCount: %d, want 2", sbc1.ExecCount.Get())
+	}
+
+	// The open breaker fails shard 1 without sending the query,
+	// which is skipped if partial results are allowed.
+	qr, shardErrors, err := stc.ExecuteMultiPartial(context.Background(), "select * from t", "TestScatterConnCircuitBreaker", shardVars, "", session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qr.Rows) != 1 {
+		t.Errorf("rows: %v, want 1 row", qr.Rows)
+	}
+	if len(shardErrors) != 1 || !strings.Contains(shardErrors[0].Error(), "circuit_open") {
+		t.Errorf("shardErrors: %v, want circuit_open", shardErrors)
+	}
+	if sbc1.ExecCount.Get() != 2 {
+		t.Errorf("ExecCount: %d, want 2", sbc1.ExecCount.Get())
+	}
+	if sbc0.ExecCount.Get() != 3 {
+		t.Errorf("ExecCount of shard 0: %d, want 3", sbc0.ExecCount.Get())
+	}
+}
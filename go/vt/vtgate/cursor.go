@@ -0,0 +1,149 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+var (
+	cursorIdleTimeout = flag.Duration("cursor_idle_timeout", 5*time.Minute, "time after which a cursor that's not fetched from is closed")
+	cursorFetchRows   = flag.Int("cursor_fetch_rows", 1000, "number of rows after which a cursor fetch returns")
+)
+
+// cursor is a streaming query whose results
+// are fetched by the client a page at a time.
+type cursor struct {
+	// mu serializes the fetches.
+	mu      sync.Mutex
+	results <-chan *mproto.QueryResult
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	// err is the error returned by the streaming
+	// query. It's set before results is closed.
+	err error
+}
+
+// cursorRegistry keeps track of the open cursors.
+type cursorRegistry struct {
+	router      *Router
+	idleTimeout time.Duration
+	fetchRows   int
+	lastID      sync2.AtomicInt64
+
+	mu      sync.Mutex
+	cursors map[int64]*cursor
+}
+
+func newCursorRegistry(router *Router, idleTimeout time.Duration, fetchRows int) *cursorRegistry {
+	return &cursorRegistry{
+		router:      router,
+		idleTimeout: idleTimeout,
+		fetchRows:   fetchRows,
+		cursors:     make(map[int64]*cursor),
+	}
+}
+
+// begin starts the streaming query and returns the id of
+// its cursor. The query keeps running after the request that
+// started it ends. It's canceled if the cursor is finished.
+func (cr *cursorRegistry) begin(query *proto.Query) int64 {
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan *mproto.QueryResult, 1)
+	c := &cursor{
+		results: results,
+		cancel:  cancel,
+	}
+	go func() {
+		c.err = cr.router.StreamExecute(ctx, query, func(qr *mproto.QueryResult) error {
+			select {
+			case results <- qr:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		close(results)
+	}()
+	id := cr.lastID.Add(1)
+	cr.mu.Lock()
+	cr.cursors[id] = c
+	cr.mu.Unlock()
+	c.timer = time.AfterFunc(cr.idleTimeout, func() {
+		cr.finish(id)
+	})
+	return id
+}
+
+// fetch returns the next page of results of the cursor. A page
+// ends once it has at least fetchRows rows. done is true if there
+// are no more results, in which case the cursor is removed, or
+// if the cursor doesn't exist.
+func (cr *cursorRegistry) fetch(id int64) (qr *mproto.QueryResult, done bool, err error) {
+	c := cr.get(id)
+	if c == nil {
+		return nil, true, fmt.Errorf("cursor %d not found", id)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timer.Stop()
+	qr = new(mproto.QueryResult)
+	for len(qr.Rows) < cr.fetchRows {
+		result, ok := <-c.results
+		if !ok {
+			cr.remove(id)
+			if c.err != nil {
+				return nil, true, c.err
+			}
+			qr.RowsAffected = uint64(len(qr.Rows))
+			return qr, true, nil
+		}
+		if qr.Fields == nil {
+			qr.Fields = result.Fields
+		}
+		qr.Rows = append(qr.Rows, result.Rows...)
+	}
+	qr.RowsAffected = uint64(len(qr.Rows))
+	c.timer.Reset(cr.idleTimeout)
+	return qr, false, nil
+}
+
+// finish cancels the query of the cursor, and removes it.
+func (cr *cursorRegistry) finish(id int64) {
+	c := cr.remove(id)
+	if c == nil {
+		return
+	}
+	c.timer.Stop()
+	c.cancel()
+	go func() {
+		for range c.results {
+		}
+	}()
+}
+
+func (cr *cursorRegistry) get(id int64) *cursor {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.cursors[id]
+}
+
+func (cr *cursorRegistry) remove(id int64) *cursor {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	c := cr.cursors[id]
+	delete(cr.cursors, id)
+	return c
+}
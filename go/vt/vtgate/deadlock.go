@@ -0,0 +1,441 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/timer"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+var (
+	deadlockCheckInterval = flag.Duration("deadlock_check_interval", 0, "how often vtgate looks for transactions that deadlock across shards, 0 disables it")
+	deadlockTimeout       = flag.Duration("deadlock_timeout", 0, "time after which vtgate rolls back a transaction whose statement is blocked while it holds other shards, 0 means no timeout")
+)
+
+// DeadlockError is returned for the statements of the transactions
+// vtgate rolls back to break a deadlock between shards. The
+// transaction can be restarted.
+type DeadlockError struct {
+	Reason string
+}
+
+func (e *DeadlockError) Error() string {
+	return fmt.Sprintf("deadlock found across shards (%s): transaction was rolled back, try restarting transaction", e.Reason)
+}
+
+// deadlockDetector breaks the deadlocks between transactions that
+// span multiple shards, which MySQL can't detect. It tracks the
+// statements that transactions are executing, and periodically
+// builds the graph of the transactions that wait for each other:
+// when a statement has been running on a shard for a whole check
+// interval while its transaction holds other shards, the tablet is
+// asked for the row locks its transaction waits for, and which
+// transactions hold them. The transactions of a single shard are
+// ignored, since MySQL detects their deadlocks. The youngest
+// transaction of each cycle is rolled back, as in the wait-die
+// scheme, and so are the transactions that are blocked for more
+// than the deadlock timeout while they hold other shards.
+//
+// The victim is rolled back on the shards it's not executing a
+// statement on, which releases their locks. The statements it is
+// executing can't be interrupted: they return a DeadlockError once
+// they are done, and the transaction is rolled back on all shards.
+type deadlockDetector struct {
+	scatterConn *ScatterConn
+
+	mu sync.Mutex
+	// interval is 0 if the detector is not open.
+	interval time.Duration
+	timeout  time.Duration
+	ticks    *timer.Timer
+	waits    map[*lockWait]bool
+	// started is when each transaction was first seen executing
+	// a statement. It's the age used to pick the victims.
+	started map[string]time.Time
+}
+
+// lockWait is a statement in flight of a transaction.
+type lockWait struct {
+	key     string
+	session *SafeSession
+	start   time.Time
+
+	mu sync.Mutex
+	// inFlight are the shards the statement is executing on.
+	inFlight map[string]bool
+	// victim is set if the transaction was rolled back.
+	victim *DeadlockError
+}
+
+func newDeadlockDetector(scatterConn *ScatterConn) *deadlockDetector {
+	return &deadlockDetector{
+		scatterConn: scatterConn,
+		waits:       make(map[*lockWait]bool),
+		started:     make(map[string]time.Time),
+	}
+}
+
+// Open starts looking for deadlocks every interval.
+func (dd *deadlockDetector) Open(interval, timeout time.Duration) {
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	if dd.interval != 0 {
+		return
+	}
+	dd.interval = interval
+	dd.timeout = timeout
+	dd.ticks = timer.NewTimer(interval)
+	dd.ticks.Start(func() {
+		dd.check(time.Now())
+	})
+}
+
+// Close stops looking for deadlocks.
+func (dd *deadlockDetector) Close() {
+	dd.mu.Lock()
+	ticks := dd.ticks
+	dd.interval = 0
+	dd.ticks = nil
+	dd.waits = make(map[*lockWait]bool)
+	dd.started = make(map[string]time.Time)
+	dd.mu.Unlock()
+	if ticks != nil {
+		ticks.Stop()
+	}
+}
+
+// begin records that the transaction of session starts executing
+// a statement. It returns nil if the detector is closed, or if
+// the transaction doesn't hold any shard yet, since it can't be
+// part of a deadlock.
+func (dd *deadlockDetector) begin(session *SafeSession) *lockWait {
+	if !session.InTransaction() {
+		return nil
+	}
+	key, _ := session.txKey()
+	if key == "" {
+		return nil
+	}
+	now := time.Now()
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	if dd.interval == 0 {
+		return nil
+	}
+	if _, ok := dd.started[key]; !ok {
+		dd.started[key] = now
+	}
+	w := &lockWait{
+		key:      key,
+		session:  session,
+		start:    now,
+		inFlight: make(map[string]bool),
+	}
+	dd.waits[w] = true
+	return w
+}
+
+// end records that the statement of w is done. It returns
+// a DeadlockError if its transaction was rolled back.
+func (dd *deadlockDetector) end(w *lockWait) error {
+	if w == nil {
+		return nil
+	}
+	dd.mu.Lock()
+	delete(dd.waits, w)
+	dd.mu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.victim != nil {
+		return w.victim
+	}
+	return nil
+}
+
+// forget drops the transaction of session, which is concluded.
+func (dd *deadlockDetector) forget(session *SafeSession) {
+	key, _ := session.txKey()
+	if key == "" {
+		return
+	}
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	delete(dd.started, key)
+}
+
+// enter records that the statement of w starts executing on
+// the shard identified by key. It returns a DeadlockError if
+// the transaction was rolled back.
+func (w *lockWait) enter(key string) error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.victim != nil {
+		return w.victim
+	}
+	w.inFlight[key] = true
+	return nil
+}
+
+// leave records that the statement of w is done on the
+// shard identified by key.
+func (w *lockWait) leave(key string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.inFlight, key)
+}
+
+// waitingTx is a transaction whose statement is blocked.
+type waitingTx struct {
+	wait    *lockWait
+	started time.Time
+	// waitingOn are the shards the statement is blocked on,
+	// and held are the shards the transaction holds.
+	waitingOn map[string]bool
+	held      map[string]*proto.ShardSession
+	// blockedBy are the transactions that hold the row
+	// locks tx waits for, as reported by the tablets.
+	blockedBy map[*waitingTx]bool
+}
+
+// waitsFor returns true if tx waits for a row lock other holds.
+func (tx *waitingTx) waitsFor(other *waitingTx) bool {
+	return tx.blockedBy[other]
+}
+
+// holdsOthers returns true if tx holds shards
+// other than the ones it's blocked on.
+func (tx *waitingTx) holdsOthers() bool {
+	for key := range tx.held {
+		if !tx.waitingOn[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// check rolls back the transactions that deadlock at now.
+func (dd *deadlockDetector) check(now time.Time) {
+	dd.mu.Lock()
+	if dd.interval == 0 {
+		dd.mu.Unlock()
+		return
+	}
+	var waits []*lockWait
+	active := make(map[string]bool)
+	for w := range dd.waits {
+		active[w.key] = true
+		if now.Sub(w.start) >= dd.interval {
+			waits = append(waits, w)
+		}
+	}
+	for key, started := range dd.started {
+		// Forget the transactions that were never concluded
+		// through this vtgate.
+		if !active[key] && now.Sub(started) > abortedRetention {
+			delete(dd.started, key)
+		}
+	}
+	txs := make([]*waitingTx, 0, len(waits))
+	for _, w := range waits {
+		txs = append(txs, &waitingTx{wait: w, started: dd.started[w.key]})
+	}
+	interval, timeout := dd.interval, dd.timeout
+	dd.mu.Unlock()
+
+	var multiShard []*waitingTx
+	for _, tx := range txs {
+		_, shardSessions := tx.wait.session.txKey()
+		tx.held = make(map[string]*proto.ShardSession, len(shardSessions))
+		for _, shardSession := range shardSessions {
+			tx.held[shardKey(shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)] = shardSession
+		}
+		tx.waitingOn = make(map[string]bool)
+		tx.wait.mu.Lock()
+		for key := range tx.wait.inFlight {
+			tx.waitingOn[key] = true
+		}
+		tx.wait.mu.Unlock()
+		if tx.holdsOthers() {
+			multiShard = append(multiShard, tx)
+		}
+	}
+
+	victims := make(map[*lockWait]string)
+	var remaining []*waitingTx
+	for _, tx := range multiShard {
+		if timeout > 0 && now.Sub(tx.wait.start) >= timeout {
+			victims[tx.wait] = fmt.Sprintf("blocked for more than %v", timeout)
+			continue
+		}
+		remaining = append(remaining, tx)
+	}
+	dd.addLockWaits(remaining, interval)
+	for {
+		cycle := findCycle(remaining)
+		if cycle == nil {
+			break
+		}
+		victim := youngest(cycle)
+		victims[victim.wait] = fmt.Sprintf("cycle of %d transactions", len(cycle))
+		for i, tx := range remaining {
+			if tx == victim {
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				break
+			}
+		}
+	}
+	for w, reason := range victims {
+		dd.abort(w, reason)
+	}
+}
+
+// addLockWaits asks the tablets of the shards txs are blocked
+// on for their lock waits, and records which transactions of txs
+// block each other there. A tablet that can't be reached within
+// interval reports no lock waits.
+func (dd *deadlockDetector) addLockWaits(txs []*waitingTx, interval time.Duration) {
+	// holders are the transactions of txs by shard,
+	// and by their transaction id on that shard.
+	holders := make(map[string]map[int64]*waitingTx)
+	blocked := make(map[string]*proto.ShardSession)
+	for _, tx := range txs {
+		for key, shardSession := range tx.held {
+			if holders[key] == nil {
+				holders[key] = make(map[int64]*waitingTx)
+			}
+			holders[key][shardSession.TransactionId] = tx
+			if tx.waitingOn[key] {
+				blocked[key] = shardSession
+			}
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), interval)
+	defer cancel()
+	for key, shardSession := range blocked {
+		sdc := dd.scatterConn.getConnection(ctx, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		waits, err := sdc.LockWaits(ctx)
+		if err != nil {
+			log.Warningf("Could not get the lock waits of %s/%s: %v", shardSession.Keyspace, shardSession.Shard, err)
+			continue
+		}
+		for _, wait := range waits {
+			waiting, blocking := holders[key][wait.WaitingId], holders[key][wait.BlockingId]
+			if waiting == nil || blocking == nil || waiting == blocking {
+				continue
+			}
+			if waiting.blockedBy == nil {
+				waiting.blockedBy = make(map[*waitingTx]bool)
+			}
+			waiting.blockedBy[blocking] = true
+		}
+	}
+}
+
+// findCycle returns the transactions of a cycle of txs,
+// or nil if they don't wait for each other in a cycle.
+func findCycle(txs []*waitingTx) []*waitingTx {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(txs))
+	var stack []int
+	var visit func(i int) []*waitingTx
+	visit = func(i int) []*waitingTx {
+		state[i] = visiting
+		stack = append(stack, i)
+		for j := range txs {
+			if !txs[i].waitsFor(txs[j]) {
+				continue
+			}
+			switch state[j] {
+			case visiting:
+				// j is on the stack, and it's the start of the cycle.
+				var cycle []*waitingTx
+				for k := len(stack) - 1; ; k-- {
+					cycle = append(cycle, txs[stack[k]])
+					if stack[k] == j {
+						return cycle
+					}
+				}
+			case unvisited:
+				if cycle := visit(j); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[i] = visited
+		return nil
+	}
+	for i := range txs {
+		if state[i] == unvisited {
+			if cycle := visit(i); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// youngest returns the transaction of txs that started last.
+func youngest(txs []*waitingTx) *waitingTx {
+	victim := txs[0]
+	for _, tx := range txs[1:] {
+		if tx.started.After(victim.started) || (tx.started.Equal(victim.started) && tx.wait.key > victim.wait.key) {
+			victim = tx
+		}
+	}
+	return victim
+}
+
+// abort marks the transaction of w as a victim, and rolls it
+// back on the shards its statement is not executing on.
+func (dd *deadlockDetector) abort(w *lockWait, reason string) {
+	w.mu.Lock()
+	if w.victim != nil {
+		w.mu.Unlock()
+		return
+	}
+	w.victim = &DeadlockError{Reason: reason}
+	inFlight := make(map[string]bool, len(w.inFlight))
+	for key := range w.inFlight {
+		inFlight[key] = true
+	}
+	w.mu.Unlock()
+
+	log.Warningf("Rolling back transaction %s to break a deadlock: %s", w.key, reason)
+	_, shardSessions := w.session.txKey()
+	ctx := context.Background()
+	for _, shardSession := range shardSessions {
+		if inFlight[shardKey(shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)] {
+			continue
+		}
+		sdc := dd.scatterConn.getConnection(ctx, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		if err := sdc.Rollback(ctx, shardSession.TransactionId); err != nil {
+			log.Warningf("Could not roll back transaction %s on %s/%s: %v", w.key, shardSession.Keyspace, shardSession.Shard, err)
+		}
+	}
+}
+
+// shardKey identifies a shard session of a transaction.
+func shardKey(keyspace, shard string, tabletType topo.TabletType) string {
+	return fmt.Sprintf("%s.%s.%s", keyspace, shard, tabletType)
+}
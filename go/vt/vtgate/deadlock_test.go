@@ -0,0 +1,193 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// This file uses the sandbox_test framework.
+
+func TestDeadlockCycle(t *testing.T) {
+	s := createSandbox("TestDeadlockCycle")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Second)
+	dd := stc.deadlocks
+	dd.Open(1*time.Hour, 0)
+	defer dd.Close()
+
+	older := NewSafeSession(&proto.Session{InTransaction: true})
+	if _, err := stc.Execute(context.Background(), "query1", nil, "TestDeadlockCycle", []string{"0", "1"}, "", older); err != nil {
+		t.Fatal(err)
+	}
+	younger := NewSafeSession(&proto.Session{InTransaction: true})
+	if _, err := stc.Execute(context.Background(), "query1", nil, "TestDeadlockCycle", []string{"0", "1"}, "", younger); err != nil {
+		t.Fatal(err)
+	}
+
+	// older waits for a row lock of shard 1, which younger holds,
+	// and younger for a row lock of shard 0, which older holds.
+	olderWait := dd.begin(older)
+	if err := olderWait.enter(shardKey("TestDeadlockCycle", "1", "")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1 * time.Millisecond)
+	youngerWait := dd.begin(younger)
+	if err := youngerWait.enter(shardKey("TestDeadlockCycle", "0", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The statements are not blocked for a whole interval yet.
+	dd.check(time.Now())
+	if youngerWait.victim != nil || olderWait.victim != nil {
+		t.Fatalf("victims: %v, %v, want none", olderWait.victim, youngerWait.victim)
+	}
+
+	// The statements are slow, but the tablets don't
+	// report that they wait for each other.
+	dd.check(time.Now().Add(2 * time.Hour))
+	if youngerWait.victim != nil || olderWait.victim != nil {
+		t.Fatalf("victims: %v, %v, want none", olderWait.victim, youngerWait.victim)
+	}
+
+	sbc0.Waits = []tproto.LockWait{{
+		WaitingId:  shardTransactionId(younger, "0"),
+		BlockingId: shardTransactionId(older, "0"),
+	}}
+	sbc1.Waits = []tproto.LockWait{{
+		WaitingId:  shardTransactionId(older, "1"),
+		BlockingId: shardTransactionId(younger, "1"),
+	}}
+
+	dd.check(time.Now().Add(2 * time.Hour))
+	if olderWait.victim != nil {
+		t.Errorf("older transaction was rolled back: %v", olderWait.victim)
+	}
+	if youngerWait.victim == nil {
+		t.Fatalf("younger transaction was not rolled back")
+	}
+	// younger is rolled back on the shard it holds, but
+	// not on the shard it's executing a statement on.
+	if sbc1.RollbackCount.Get() != 1 || sbc0.RollbackCount.Get() != 0 {
+		t.Errorf("RollbackCount: %d, %d, want 0, 1", sbc0.RollbackCount.Get(), sbc1.RollbackCount.Get())
+	}
+	if err := youngerWait.enter(shardKey("TestDeadlockCycle", "1", "")); err != youngerWait.victim {
+		t.Errorf("enter: %v, want %v", err, youngerWait.victim)
+	}
+	youngerWait.leave(shardKey("TestDeadlockCycle", "0", ""))
+	if err := dd.end(youngerWait); err == nil {
+		t.Errorf("end: nil, want DeadlockError")
+	}
+	olderWait.leave(shardKey("TestDeadlockCycle", "1", ""))
+	if err := dd.end(olderWait); err != nil {
+		t.Errorf("end: %v, want nil", err)
+	}
+}
+
+func TestDeadlockSingleShard(t *testing.T) {
+	s := createSandbox("TestDeadlockSingleShard")
+	sbc := &sandboxConn{}
+	s.MapTestConn("0", sbc)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Second)
+	dd := stc.deadlocks
+	dd.Open(1*time.Hour, 1*time.Hour)
+	defer dd.Close()
+
+	// Two slow statements on the same shard are not a
+	// deadlock across shards, even if they block each other.
+	var waits []*lockWait
+	for i := 0; i < 2; i++ {
+		session := NewSafeSession(&proto.Session{InTransaction: true})
+		if _, err := stc.Execute(context.Background(), "query1", nil, "TestDeadlockSingleShard", []string{"0"}, "", session); err != nil {
+			t.Fatal(err)
+		}
+		w := dd.begin(session)
+		if err := w.enter(shardKey("TestDeadlockSingleShard", "0", "")); err != nil {
+			t.Fatal(err)
+		}
+		waits = append(waits, w)
+	}
+	sbc.Waits = []tproto.LockWait{
+		{WaitingId: 1, BlockingId: 2},
+		{WaitingId: 2, BlockingId: 1},
+	}
+	dd.check(time.Now().Add(2 * time.Hour))
+	for _, w := range waits {
+		if w.victim != nil {
+			t.Errorf("transaction %s was rolled back: %v", w.key, w.victim)
+		}
+	}
+	if sbc.RollbackCount.Get() != 0 {
+		t.Errorf("RollbackCount: %d, want 0", sbc.RollbackCount.Get())
+	}
+}
+
+func TestDeadlockTimeout(t *testing.T) {
+	s := createSandbox("TestDeadlockTimeout")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{mustDelay: 50 * time.Millisecond}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Second)
+	dd := stc.deadlocks
+	dd.Open(1*time.Hour, 1*time.Hour)
+	defer dd.Close()
+
+	session := NewSafeSession(&proto.Session{InTransaction: true})
+	if _, err := stc.Execute(context.Background(), "query1", nil, "TestDeadlockTimeout", []string{"0"}, "", session); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		dd.check(time.Now().Add(2 * time.Hour))
+	}()
+	_, err := stc.Execute(context.Background(), "query1", nil, "TestDeadlockTimeout", []string{"1"}, "", session)
+	if _, ok := err.(*DeadlockError); !ok {
+		t.Fatalf("Execute: %v, want DeadlockError", err)
+	}
+	if session.InTransaction() {
+		t.Errorf("session: %v, want reset", session.Session)
+	}
+	if sbc0.RollbackCount.Get() == 0 || sbc1.RollbackCount.Get() != 1 {
+		t.Errorf("RollbackCount: %d, %d, want rolled back", sbc0.RollbackCount.Get(), sbc1.RollbackCount.Get())
+	}
+}
+
+func TestFindCycle(t *testing.T) {
+	tx := func(key string) *waitingTx {
+		return &waitingTx{
+			wait:      &lockWait{key: key},
+			blockedBy: make(map[*waitingTx]bool),
+		}
+	}
+	a, b, c := tx("a"), tx("b"), tx("c")
+	a.blockedBy[b] = true
+	b.blockedBy[c] = true
+	if cycle := findCycle([]*waitingTx{a, b, c}); cycle != nil {
+		t.Errorf("findCycle: %v, want nil", cycle)
+	}
+	c.blockedBy[a] = true
+	if cycle := findCycle([]*waitingTx{a, b, c}); len(cycle) != 3 {
+		t.Errorf("findCycle: %v, want 3 transactions", cycle)
+	}
+}
+
+// shardTransactionId returns the transaction id of session on shard.
+func shardTransactionId(session *SafeSession, shard string) int64 {
+	for _, shardSession := range session.ShardSessions {
+		if shardSession.Shard == shard {
+			return shardSession.TransactionId
+		}
+	}
+	return 0
+}
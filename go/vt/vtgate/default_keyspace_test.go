@@ -0,0 +1,84 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestRouterUse(t *testing.T) {
+	schema, err := planbuilder.BuildSchema(&planbuilder.SchemaFormal{
+		Keyspaces: map[string]planbuilder.KeyspaceFormal{
+			TEST_UNSHARDED: {
+				Tables: map[string]planbuilder.TableFormal{"t": {}},
+			},
+			"TestRouter": {
+				Sharded:  true,
+				Vindexes: map[string]planbuilder.VindexFormal{"hash": {Type: "hash"}},
+				Tables: map[string]planbuilder.TableFormal{
+					"t": {ColVindexes: []planbuilder.ColVindexFormal{{Col: "id", Name: "hash"}}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsharded := &sandboxConn{}
+	createSandbox(TEST_UNSHARDED).MapTestConn("0", unsharded)
+	sharded := &sandboxConn{}
+	createSandbox("TestRouter").MapTestConn("-20", sharded)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Millisecond, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	ctx := context.Background()
+
+	q := &proto.Query{
+		Sql:        "select * from t where id = 1",
+		TabletType: topo.TYPE_MASTER,
+	}
+	if _, err := router.Execute(ctx, q); err == nil || !strings.Contains(err.Error(), "table t is ambiguous") {
+		t.Errorf("Execute without a default keyspace: %v, want ambiguous table error", err)
+	}
+
+	for _, tcase := range []struct {
+		keyspace string
+		want     *sandboxConn
+	}{
+		{TEST_UNSHARDED, unsharded},
+		{"TestRouter", sharded},
+	} {
+		q.Sql = "use " + tcase.keyspace
+		if _, err := router.Execute(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+		if q.Session.DefaultKeyspace != tcase.keyspace {
+			t.Errorf("DefaultKeyspace: %q, want %q", q.Session.DefaultKeyspace, tcase.keyspace)
+		}
+		count := tcase.want.ExecCount.Get()
+		q.Sql = "select * from t where id = 1"
+		if _, err := router.Execute(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+		if got := tcase.want.ExecCount.Get() - count; got != 1 {
+			t.Errorf("queries of %s: %d more, want 1", tcase.keyspace, got)
+		}
+	}
+
+	q.Sql = "use nosuch"
+	if _, err := router.Execute(ctx, q); err == nil || err.Error() != "unknown keyspace: nosuch" {
+		t.Errorf("use nosuch: %v, want unknown keyspace error", err)
+	}
+	if q.Session.DefaultKeyspace != "TestRouter" {
+		t.Errorf("DefaultKeyspace: %q, want it unchanged", q.Session.DefaultKeyspace)
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// keyspacesFields are the columns of SHOW VITESS_KEYSPACES.
+var keyspacesFields = []mproto.Field{
+	{Name: "Keyspace", Type: mproto.VT_VAR_STRING},
+	{Name: "ShardingColumnName", Type: mproto.VT_VAR_STRING},
+	{Name: "ShardingColumnType", Type: mproto.VT_VAR_STRING},
+	{Name: "ServedFrom", Type: mproto.VT_VAR_STRING},
+	{Name: "TabletTypes", Type: mproto.VT_VAR_STRING},
+}
+
+// shardsFields are the columns of SHOW VITESS_SHARDS.
+var shardsFields = []mproto.Field{
+	{Name: "Keyspace", Type: mproto.VT_VAR_STRING},
+	{Name: "Shard", Type: mproto.VT_VAR_STRING},
+	{Name: "KeyRange", Type: mproto.VT_VAR_STRING},
+	{Name: "TabletTypes", Type: mproto.VT_VAR_STRING},
+}
+
+// DescribeKeyspaces returns the keyspaces of the serving graph of
+// the cell of the router, sorted by name, or only keyspace if it's
+// not empty. The shards of each keyspace are sorted by key range,
+// with the tablet types they serve.
+func (rtr *Router) DescribeKeyspaces(ctx context.Context, keyspace string) ([]proto.KeyspaceDescription, error) {
+	var names []string
+	if keyspace != "" {
+		names = []string{keyspace}
+	} else {
+		var err error
+		names, err = rtr.serv.GetSrvKeyspaceNames(ctx, rtr.cell)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(names)
+	}
+	descriptions := make([]proto.KeyspaceDescription, 0, len(names))
+	for _, name := range names {
+		ks, err := rtr.serv.GetSrvKeyspace(ctx, rtr.cell, name)
+		if err != nil {
+			return nil, fmt.Errorf("keyspace %s fetch error: %v", name, err)
+		}
+		descriptions = append(descriptions, describeKeyspace(name, ks))
+	}
+	return descriptions, nil
+}
+
+// describeKeyspace returns the description of the SrvKeyspace ks.
+func describeKeyspace(name string, ks *topo.SrvKeyspace) proto.KeyspaceDescription {
+	desc := proto.KeyspaceDescription{
+		Name:               name,
+		ShardingColumnName: ks.ShardingColumnName,
+		ShardingColumnType: ks.ShardingColumnType,
+		ServedFrom:         ks.ServedFrom,
+		TabletTypes:        ks.TabletTypes,
+	}
+	shards := make(map[string]topo.SrvShard)
+	tabletTypes := make(map[string][]topo.TabletType)
+	for tabletType, partition := range ks.Partitions {
+		for _, shard := range partition.Shards {
+			// While a keyspace is resharded, its partitions list
+			// the shards of both sides, which only serve the
+			// tablet types of their ServedTypes.
+			if len(shard.ServedTypes) != 0 && !topo.IsTypeInList(tabletType, shard.ServedTypes) {
+				continue
+			}
+			name := shard.ShardName()
+			shards[name] = shard
+			tabletTypes[name] = append(tabletTypes[name], tabletType)
+		}
+	}
+	// The keyspaces built before the partitions only have a list
+	// of shards.
+	if len(ks.Partitions) == 0 {
+		for _, shard := range ks.Shards {
+			name := shard.ShardName()
+			shards[name] = shard
+			tabletTypes[name] = append([]topo.TabletType(nil), shard.ServedTypes...)
+		}
+	}
+	sorted := make([]topo.SrvShard, 0, len(shards))
+	for _, shard := range shards {
+		sorted = append(sorted, shard)
+	}
+	sort.Sort(byKeyRange(sorted))
+	for _, shard := range sorted {
+		types := tabletTypes[shard.ShardName()]
+		sort.Sort(tabletTypeList(types))
+		desc.Shards = append(desc.Shards, proto.ShardDescription{
+			Name:        shard.ShardName(),
+			KeyRange:    shard.KeyRange,
+			TabletTypes: types,
+		})
+	}
+	return desc
+}
+
+// byKeyRange sorts shards by the start of their key range, and
+// then by decreasing end, so the source shard of a split comes
+// before its destination shards.
+type byKeyRange []topo.SrvShard
+
+func (bk byKeyRange) Len() int      { return len(bk) }
+func (bk byKeyRange) Swap(i, j int) { bk[i], bk[j] = bk[j], bk[i] }
+func (bk byKeyRange) Less(i, j int) bool {
+	if bk[i].KeyRange.Start != bk[j].KeyRange.Start {
+		return bk[i].KeyRange.Start < bk[j].KeyRange.Start
+	}
+	// An empty end is the end of the key space.
+	if bk[i].KeyRange.End == key.MaxKey || bk[j].KeyRange.End == key.MaxKey {
+		return bk[i].KeyRange.End == key.MaxKey && bk[j].KeyRange.End != key.MaxKey
+	}
+	return bk[i].KeyRange.End > bk[j].KeyRange.End
+}
+
+// tabletTypeList sorts tablet types by name.
+type tabletTypeList []topo.TabletType
+
+func (tl tabletTypeList) Len() int           { return len(tl) }
+func (tl tabletTypeList) Swap(i, j int)      { tl[i], tl[j] = tl[j], tl[i] }
+func (tl tabletTypeList) Less(i, j int) bool { return tl[i] < tl[j] }
+
+// joinTabletTypes returns tabletTypes as a comma-separated list.
+func joinTabletTypes(tabletTypes []topo.TabletType) string {
+	names := make([]string, len(tabletTypes))
+	for i, tabletType := range tabletTypes {
+		names[i] = string(tabletType)
+	}
+	return strings.Join(names, ",")
+}
+
+// execShow returns the result of the SHOW VITESS_KEYSPACES and
+// SHOW VITESS_SHARDS statements: one row per keyspace, or per
+// shard, of the serving graph of the cell of the router.
+func (rtr *Router) execShow(vcursor *requestContext, what, keyspace string) (*mproto.QueryResult, error) {
+	keyspaces, err := rtr.DescribeKeyspaces(vcursor.ctx, keyspace)
+	if err != nil {
+		return nil, err
+	}
+	result := &mproto.QueryResult{}
+	switch what {
+	case sqlparser.ShowVitessKeyspaces:
+		result.Fields = keyspacesFields
+		for _, ks := range keyspaces {
+			servedFrom := make([]string, 0, len(ks.ServedFrom))
+			for tabletType, source := range ks.ServedFrom {
+				servedFrom = append(servedFrom, fmt.Sprintf("%s:%s", tabletType, source))
+			}
+			sort.Strings(servedFrom)
+			result.Rows = append(result.Rows, []sqltypes.Value{
+				sqltypes.MakeString([]byte(ks.Name)),
+				sqltypes.MakeString([]byte(ks.ShardingColumnName)),
+				sqltypes.MakeString([]byte(ks.ShardingColumnType)),
+				sqltypes.MakeString([]byte(strings.Join(servedFrom, ","))),
+				sqltypes.MakeString([]byte(joinTabletTypes(ks.TabletTypes))),
+			})
+		}
+	case sqlparser.ShowVitessShards:
+		result.Fields = shardsFields
+		for _, ks := range keyspaces {
+			for _, shard := range ks.Shards {
+				result.Rows = append(result.Rows, []sqltypes.Value{
+					sqltypes.MakeString([]byte(ks.Name)),
+					sqltypes.MakeString([]byte(shard.Name)),
+					sqltypes.MakeString([]byte(shard.KeyRange.String())),
+					sqltypes.MakeString([]byte(joinTabletTypes(shard.TabletTypes))),
+				})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported show: %s", what)
+	}
+	result.RowsAffected = uint64(len(result.Rows))
+	return result, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestDescribeKeyspaces(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 80- is being split into 80-c0 and c0-, which
+	// only serve rdonly so far.
+	krs, err := key.ParseShardingSpec("-80-c0-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := key.ParseShardingSpec("80-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := []topo.SrvShard{
+		{Name: "-80", KeyRange: krs[0]},
+		{Name: "80-", KeyRange: parent[0], ServedTypes: []topo.TabletType{topo.TYPE_MASTER}},
+		{Name: "80-c0", KeyRange: krs[1], ServedTypes: []topo.TabletType{topo.TYPE_RDONLY}},
+		{Name: "c0-", KeyRange: krs[2], ServedTypes: []topo.TabletType{topo.TYPE_RDONLY}},
+	}
+	serv := &srvKeyspaceTopo{srvKeyspace: &topo.SrvKeyspace{
+		Partitions: map[topo.TabletType]*topo.KeyspacePartition{
+			topo.TYPE_MASTER: &topo.KeyspacePartition{Shards: shards},
+			topo.TYPE_RDONLY: &topo.KeyspacePartition{Shards: shards},
+		},
+		TabletTypes:        []topo.TabletType{topo.TYPE_MASTER, topo.TYPE_RDONLY},
+		ShardingColumnName: "user_id",
+		ShardingColumnType: key.KIT_UINT64,
+		ServedFrom:         map[topo.TabletType]string{topo.TYPE_REPLICA: "main", topo.TYPE_RDONLY: "main"},
+	}}
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Millisecond, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	got, err := router.DescribeKeyspaces(context.Background(), "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []proto.KeyspaceDescription{{
+		Name:               "user",
+		ShardingColumnName: "user_id",
+		ShardingColumnType: key.KIT_UINT64,
+		ServedFrom:         serv.srvKeyspace.ServedFrom,
+		TabletTypes:        serv.srvKeyspace.TabletTypes,
+		Shards: []proto.ShardDescription{
+			{Name: "-80", KeyRange: krs[0], TabletTypes: []topo.TabletType{topo.TYPE_MASTER, topo.TYPE_RDONLY}},
+			{Name: "80-", KeyRange: parent[0], TabletTypes: []topo.TabletType{topo.TYPE_MASTER}},
+			{Name: "80-c0", KeyRange: krs[1], TabletTypes: []topo.TabletType{topo.TYPE_RDONLY}},
+			{Name: "c0-", KeyRange: krs[2], TabletTypes: []topo.TabletType{topo.TYPE_RDONLY}},
+		},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DescribeKeyspaces:\n%+v, want\n%+v", got, want)
+	}
+
+	result, err := router.Execute(context.Background(), &proto.Query{
+		Sql:        "show vitess_keyspaces from user",
+		TabletType: topo.TYPE_MASTER,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantResult := &mproto.QueryResult{
+		Fields: keyspacesFields,
+		Rows: [][]sqltypes.Value{{
+			sqltypes.MakeString([]byte("user")),
+			sqltypes.MakeString([]byte("user_id")),
+			sqltypes.MakeString([]byte("uint64")),
+			sqltypes.MakeString([]byte("rdonly:main,replica:main")),
+			sqltypes.MakeString([]byte("master,rdonly")),
+		}},
+		RowsAffected: 1,
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("show vitess_keyspaces:\n%+v, want\n%+v", result, wantResult)
+	}
+
+	result, err = router.Execute(context.Background(), &proto.Query{
+		Sql:        "show vitess_shards from user",
+		TabletType: topo.TYPE_MASTER,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var shardNames []string
+	for _, row := range result.Rows {
+		shardNames = append(shardNames, row[1].String()+" "+row[3].String())
+	}
+	wantShards := []string{"-80 master,rdonly", "80- master", "80-c0 rdonly", "c0- rdonly"}
+	if !reflect.DeepEqual(shardNames, wantShards) {
+		t.Errorf("show vitess_shards: %v, want %v", shardNames, wantShards)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/youtube/vitess/go/sqltypes"
+)
+
+// distinctFilter removes duplicate rows. It remembers every
+// distinct row seen so far, and fails once they take up more
+// than maxBytes. A maxBytes of 0 means no limit.
+type distinctFilter struct {
+	seen     map[string]bool
+	size     int
+	maxBytes int
+}
+
+func newDistinctFilter(maxBytes int) *distinctFilter {
+	return &distinctFilter{
+		seen:     make(map[string]bool),
+		maxBytes: maxBytes,
+	}
+}
+
+// isNew returns true if row was not seen before.
+func (df *distinctFilter) isNew(row []sqltypes.Value) (bool, error) {
+	key := rowKey(row)
+	if df.seen[key] {
+		return false, nil
+	}
+	df.size += len(key)
+	if df.maxBytes > 0 && df.size > df.maxBytes {
+		return false, fmt.Errorf("distinct: rows exceed the memory limit of %d bytes", df.maxBytes)
+	}
+	df.seen[key] = true
+	return true, nil
+}
+
+// rowKey returns a string that uniquely identifies
+// the values of a row.
+func rowKey(row []sqltypes.Value) string {
+	var buf bytes.Buffer
+	for _, val := range row {
+		if val.IsNull() {
+			buf.WriteString("N")
+			continue
+		}
+		raw := val.Raw()
+		fmt.Fprintf(&buf, "%d:", len(raw))
+		buf.Write(raw)
+	}
+	return buf.String()
+}
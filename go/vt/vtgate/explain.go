@@ -0,0 +1,291 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"fmt"
+	"sort"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// explainFields are the columns of the result of an EXPLAIN.
+var explainFields = []mproto.Field{
+	{Name: "Plan", Type: mproto.VT_VAR_STRING},
+	{Name: "Keyspace", Type: mproto.VT_VAR_STRING},
+	{Name: "Shard", Type: mproto.VT_VAR_STRING},
+	{Name: "Query", Type: mproto.VT_VAR_STRING},
+	{Name: "Vindex", Type: mproto.VT_VAR_STRING},
+	{Name: "VindexChoice", Type: mproto.VT_VAR_STRING},
+}
+
+// RouteExplanation is how a plan, or a part of it, is routed.
+type RouteExplanation struct {
+	PlanID planbuilder.PlanID
+	// Keyspace is the keyspace of the route, after the redirections
+	// of the keyspaces that are served from another keyspace.
+	Keyspace string
+	// Query is the query that's sent to the shards.
+	Query        string
+	Vindex       string
+	VindexChoice string
+	// VindexValues are the values of the vindex, with the
+	// keyspace ids they map to and the shards of those.
+	VindexValues []VindexRoute `json:",omitempty"`
+	// KeyRanges are the keyranges the route is restricted to.
+	KeyRanges []key.KeyRange `json:",omitempty"`
+	// Resolved is false if the shards of the route are only known
+	// when the query is executed, like for the right side of a join.
+	Resolved bool
+	Shards   []string
+}
+
+// VindexRoute is the routing of a value of a vindex. A value
+// that maps to no keyspace id matches no row, and isn't sent
+// to any shard.
+type VindexRoute struct {
+	Value       interface{}
+	KeyspaceIds []key.KeyspaceId
+	Shards      []string
+}
+
+// routeRecorder records the routing decisions of the
+// router while the routes of a query are explained.
+type routeRecorder struct {
+	vindexValues []VindexRoute
+	keyRanges    []key.KeyRange
+}
+
+// recordVindexRoute records that value maps to ksids, if the
+// routes of vc are explained. allShards are the shards of the
+// keyspace the ksids are in.
+func (vc *requestContext) recordVindexRoute(value interface{}, ksids []key.KeyspaceId, allShards []topo.SrvShard) {
+	if vc.routes == nil {
+		return
+	}
+	route := VindexRoute{Value: value}
+	for _, ksid := range ksids {
+		if ksid == key.MinKey {
+			continue
+		}
+		shard, err := getShardForKeyspaceId(allShards, ksid)
+		if err != nil {
+			// The routing itself fails with this error.
+			continue
+		}
+		route.KeyspaceIds = append(route.KeyspaceIds, ksid)
+		route.Shards = append(route.Shards, shard)
+	}
+	vc.routes.vindexValues = append(vc.routes.vindexValues, route)
+}
+
+// recordKeyRange records that the routes of vc,
+// if they're explained, are restricted to kr.
+func (vc *requestContext) recordKeyRange(kr key.KeyRange) {
+	if vc.routes == nil {
+		return
+	}
+	vc.routes.keyRanges = append(vc.routes.keyRanges, kr)
+}
+
+// execExplain returns the plan of query instead of executing it:
+// one row per shard the query would be sent to, with the rewritten
+// query, the vindex used for the routing and why it was chosen
+// if other vindexes could have been used. The shards are resolved
+// with the current topology, and the lookup vindexes are queried.
+// The plans that can only be routed with the results of another
+// query, like the right side of a join, have a row with no shard.
+func (rtr *Router) execExplain(vcursor *requestContext, query string) (*mproto.QueryResult, error) {
+	explained := *vcursor.query
+	explained.Sql = query
+	explained.BindVariables = make(map[string]interface{}, len(vcursor.query.BindVariables))
+	for k, v := range vcursor.query.BindVariables {
+		explained.BindVariables[k] = v
+	}
+	_, routes, err := rtr.explainQuery(newRequestContext(vcursor.ctx, &explained, rtr))
+	if err != nil {
+		return nil, err
+	}
+	result := &mproto.QueryResult{Fields: explainFields}
+	for _, route := range routes {
+		if len(route.Shards) == 0 {
+			addExplainRow(result, route, "")
+			continue
+		}
+		for _, shard := range route.Shards {
+			addExplainRow(result, route, shard)
+		}
+	}
+	result.RowsAffected = uint64(len(result.Rows))
+	return result, nil
+}
+
+// explainQuery plans the query of vcursor, and returns
+// its plan and the routes of the plan and its sub-plans.
+func (rtr *Router) explainQuery(vcursor *requestContext) (*planbuilder.Plan, []*RouteExplanation, error) {
+	query := vcursor.query
+	rtr.normalize(query)
+	plan, err := planbuilder.ForTenant(rtr.planner.GetPlanIn(query.Sql, sessionKeyspace(query.Session)), sessionTenant(query.Session))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := applyHints(vcursor, plan); err != nil {
+		return nil, nil, err
+	}
+	var routes []*RouteExplanation
+	if err := rtr.explainPlan(vcursor, plan, true, &routes); err != nil {
+		return nil, nil, err
+	}
+	return plan, routes, nil
+}
+
+// explainPlan adds the routes of plan and its sub-plans to routes.
+// The shards of plan are resolved only if resolve is true.
+func (rtr *Router) explainPlan(vcursor *requestContext, plan *planbuilder.Plan, resolve bool, routes *[]*RouteExplanation) error {
+	if plan.ID == planbuilder.NoPlan {
+		return fmt.Errorf("cannot explain %q: %s", plan.Original, plan.Reason)
+	}
+	for _, sq := range plan.Subqueries {
+		if err := rtr.explainPlan(vcursor, sq.Plan, resolve, routes); err != nil {
+			return err
+		}
+	}
+	switch plan.ID {
+	case planbuilder.SelectJoin, planbuilder.SelectSemiJoin, planbuilder.SelectAntiJoin, planbuilder.InsertSelect:
+		// The right side depends on the rows of the left side.
+		*routes = append(*routes, newRouteExplanation(plan, "", plan.Original))
+		if plan.Left != nil {
+			if err := rtr.explainPlan(vcursor, plan.Left, resolve, routes); err != nil {
+				return err
+			}
+		}
+		return rtr.explainPlan(vcursor, plan.Right, false, routes)
+	case planbuilder.SelectUnion, planbuilder.SelectUnionAll:
+		*routes = append(*routes, newRouteExplanation(plan, "", plan.Original))
+		if err := rtr.explainPlan(vcursor, plan.Left, resolve, routes); err != nil {
+			return err
+		}
+		return rtr.explainPlan(vcursor, plan.Right, resolve, routes)
+	}
+	var params *scatterParams
+	recorder := &routeRecorder{}
+	if resolve && plan.Subqueries == nil {
+		vcursor.routes = recorder
+		var err error
+		params, err = rtr.paramsExplain(vcursor, plan)
+		vcursor.routes = nil
+		if err != nil {
+			return err
+		}
+	}
+	if params == nil || len(params.shardVars) == 0 {
+		var ks string
+		if plan.Table != nil {
+			ks = plan.Table.Keyspace.Name
+		}
+		route := newRouteExplanation(plan, ks, plan.Rewritten)
+		route.Resolved = params != nil
+		route.VindexValues = recorder.vindexValues
+		route.KeyRanges = recorder.keyRanges
+		*routes = append(*routes, route)
+		return nil
+	}
+	route := newRouteExplanation(plan, params.ks, params.query)
+	route.Resolved = true
+	route.VindexValues = recorder.vindexValues
+	route.KeyRanges = recorder.keyRanges
+	for shard := range params.shardVars {
+		route.Shards = append(route.Shards, shard)
+	}
+	sort.Strings(route.Shards)
+	*routes = append(*routes, route)
+	return nil
+}
+
+func newRouteExplanation(plan *planbuilder.Plan, ks, query string) *RouteExplanation {
+	route := &RouteExplanation{
+		PlanID:       plan.ID,
+		Keyspace:     ks,
+		Query:        query,
+		VindexChoice: plan.VindexChoice,
+	}
+	if plan.ColVindex != nil {
+		route.Vindex = plan.ColVindex.Name
+	}
+	return route
+}
+
+// paramsExplain returns the scatterParams of plan, or nil if its
+// shards are only known when it's executed, like for the inserts
+// into a sharded table, whose keyspace ids may be generated.
+func (rtr *Router) paramsExplain(vcursor *requestContext, plan *planbuilder.Plan) (*scatterParams, error) {
+	if plan.NeedsMerge() {
+		return rtr.paramsMerge(vcursor, plan)
+	}
+	switch plan.ID {
+	case planbuilder.SelectUnsharded, planbuilder.UpdateUnsharded,
+		planbuilder.DeleteUnsharded, planbuilder.InsertUnsharded:
+		return rtr.paramsUnsharded(vcursor, plan)
+	case planbuilder.SelectEqual:
+		return rtr.paramsSelectEqual(vcursor, plan)
+	case planbuilder.SelectIN:
+		return rtr.paramsSelectIN(vcursor, plan)
+	case planbuilder.SelectKeyrange:
+		return rtr.paramsSelectKeyrange(vcursor, plan)
+	case planbuilder.SelectRange:
+		return rtr.paramsSelectRange(vcursor, plan)
+	case planbuilder.SelectScatter:
+		return rtr.paramsSelectScatter(vcursor, plan)
+	case planbuilder.UpdateEqual, planbuilder.DeleteEqual:
+		return rtr.paramsDMLEqual(vcursor, plan)
+	case planbuilder.UpdateIn, planbuilder.DeleteIn:
+		keys, err := rtr.resolveList(plan.Values, vcursor.query.BindVariables)
+		if err != nil {
+			return nil, err
+		}
+		var ks string
+		var shards []string
+		for _, k := range keys {
+			newKeyspace, shard, ksid, err := rtr.resolveSingleShard(vcursor, k, plan)
+			if err != nil {
+				return nil, err
+			}
+			if ksid == key.MinKey {
+				continue
+			}
+			ks = newKeyspace
+			shards = append(shards, shard)
+		}
+		return newScatterParams(plan.Rewritten, ks, vcursor.query.BindVariables, shards), nil
+	case planbuilder.UpdateScatter, planbuilder.DeleteScatter:
+		ks, allShards, err := getKeyspaceShards(vcursor.ctx, rtr.serv, rtr.cell, plan.Table.Keyspace.Name, vcursor.query.TabletType)
+		if err != nil {
+			return nil, err
+		}
+		shards := make([]string, 0, len(allShards))
+		for _, shard := range allShards {
+			shards = append(shards, shard.ShardName())
+		}
+		return newScatterParams(plan.Rewritten, ks, vcursor.query.BindVariables, shards), nil
+	}
+	return nil, nil
+}
+
+func addExplainRow(result *mproto.QueryResult, route *RouteExplanation, shard string) {
+	result.Rows = append(result.Rows, []sqltypes.Value{
+		sqltypes.MakeString([]byte(route.PlanID.String())),
+		sqltypes.MakeString([]byte(route.Keyspace)),
+		sqltypes.MakeString([]byte(shard)),
+		sqltypes.MakeString([]byte(route.Query)),
+		sqltypes.MakeString([]byte(route.Vindex)),
+		sqltypes.MakeString([]byte(route.VindexChoice)),
+	})
+}
@@ -0,0 +1,103 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// This file uses the sandbox_test framework.
+
+// explainRows returns the rows of an EXPLAIN as strings.
+func explainRows(result *mproto.QueryResult) [][]string {
+	var rows [][]string
+	for _, row := range result.Rows {
+		var cols []string
+		for _, val := range row {
+			cols = append(cols, val.String())
+		}
+		rows = append(rows, cols)
+	}
+	return rows
+}
+
+func TestExplain(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	testcases := []struct {
+		sql  string
+		want [][]string
+	}{{
+		sql: "explain select * from user where id = 1",
+		want: [][]string{
+			{"SelectEqual", "TestRouter", "-20", "select * from user where id = 1", "user_index", ""},
+		},
+	}, {
+		sql: "EXPLAIN select * from user where id in (1, 3)",
+		want: [][]string{
+			{"SelectIN", "TestRouter", "-20", "select * from user where id in ::_vals", "user_index", ""},
+			{"SelectIN", "TestRouter", "40-60", "select * from user where id in ::_vals", "user_index", ""},
+		},
+	}, {
+		sql: "describe plan update user set a=2 where id = 3",
+		want: [][]string{
+			{"UpdateEqual", "TestRouter", "40-60", "update user set a = 2 where id = 3", "user_index", ""},
+		},
+	}, {
+		sql: "explain select * from user where name = 'foo' and id = 1",
+		want: [][]string{
+			{"SelectEqual", "TestRouter", "-20", "select * from user where name = 'foo' and id = 1", "user_index", "chose user_index on id (SelectEqual, unique, cost 1) over name_user_map on name (SelectEqual, non-unique, cost 20)"},
+		},
+	}, {
+		sql: "explain insert into user(id, name) values (1, 'a')",
+		want: [][]string{
+			{"InsertSharded", "TestRouter", "", "insert into user(id, name) values (:_id, :_name)", "", ""},
+		},
+	}}
+	for _, tcase := range testcases {
+		result, err := router.Execute(context.Background(), &proto.Query{
+			Sql:        tcase.sql,
+			TabletType: topo.TYPE_MASTER,
+		})
+		if err != nil {
+			t.Errorf("Execute(%s): %v", tcase.sql, err)
+			continue
+		}
+		if got := explainRows(result); !reflect.DeepEqual(got, tcase.want) {
+			t.Errorf("Execute(%s):\n%v, want\n%v", tcase.sql, got, tcase.want)
+		}
+	}
+	if sbc1.ExecCount != 0 || sbc2.ExecCount != 0 {
+		t.Errorf("ExecCount: %v, %v, want 0, 0", sbc1.ExecCount, sbc2.ExecCount)
+	}
+
+	_, err = router.Execute(context.Background(), &proto.Query{
+		Sql:        "explain select * from no_such_table",
+		TabletType: topo.TYPE_MASTER,
+	})
+	if err == nil || !strings.Contains(err.Error(), "cannot explain") {
+		t.Errorf("Execute: %v, want cannot explain", err)
+	}
+}
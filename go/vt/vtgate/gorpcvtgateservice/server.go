@@ -45,6 +45,12 @@ func (vtg *VTGate) ExecuteBatchKeyspaceIds(ctx context.Context, batchQuery *prot
 	return vtg.server.ExecuteBatchKeyspaceIds(ctx, batchQuery, reply)
 }
 
+func (vtg *VTGate) StreamExecute(ctx context.Context, query *proto.Query, sendReply func(interface{}) error) error {
+	return vtg.server.StreamExecute(ctx, query, func(value *proto.QueryResult) error {
+		return sendReply(value)
+	})
+}
+
 func (vtg *VTGate) StreamExecuteShard(ctx context.Context, query *proto.QueryShard, sendReply func(interface{}) error) error {
 	return vtg.server.StreamExecuteShard(ctx, query, func(value *proto.QueryResult) error {
 		return sendReply(value)
@@ -63,6 +69,38 @@ func (vtg *VTGate) StreamExecuteKeyspaceIds(ctx context.Context, query *proto.Ke
 	})
 }
 
+func (vtg *VTGate) BeginStream(ctx context.Context, query *proto.Query, reply *proto.QueryResult) error {
+	return vtg.server.BeginStream(ctx, query, reply)
+}
+
+func (vtg *VTGate) FetchNext(ctx context.Context, inSession *proto.Session, reply *proto.QueryResult) error {
+	return vtg.server.FetchNext(ctx, inSession, reply)
+}
+
+func (vtg *VTGate) FinishStream(ctx context.Context, inSession *proto.Session, outSession *proto.Session) error {
+	err := vtg.server.FinishStream(ctx, inSession)
+	*outSession = *inSession
+	return err
+}
+
+func (vtg *VTGate) Prepare(ctx context.Context, req *proto.PrepareRequest, reply *proto.PrepareResult) error {
+	return vtg.server.Prepare(ctx, req, reply)
+}
+
+func (vtg *VTGate) CommitPrepared(ctx context.Context, req *proto.ResolvePreparedRequest, noOutput *rpc.Unused) error {
+	return vtg.server.CommitPrepared(ctx, req)
+}
+
+func (vtg *VTGate) RollbackPrepared(ctx context.Context, req *proto.ResolvePreparedRequest, noOutput *rpc.Unused) error {
+	return vtg.server.RollbackPrepared(ctx, req)
+}
+
+func (vtg *VTGate) Release(ctx context.Context, inSession *proto.Session, outSession *proto.Session) error {
+	err := vtg.server.Release(ctx, inSession)
+	*outSession = *inSession
+	return err
+}
+
 func (vtg *VTGate) Begin(ctx context.Context, noInput *rpc.Unused, outSession *proto.Session) error {
 	return vtg.server.Begin(ctx, outSession)
 }
@@ -79,6 +117,38 @@ func (vtg *VTGate) SplitQuery(ctx context.Context, req *proto.SplitQueryRequest,
 	return vtg.server.SplitQuery(ctx, req, reply)
 }
 
+func (vtg *VTGate) MapKeyspaceId(ctx context.Context, req *proto.MapKeyspaceIdRequest, reply *proto.MapKeyspaceIdResult) error {
+	return vtg.server.MapKeyspaceId(ctx, req, reply)
+}
+
+func (vtg *VTGate) DescribeKeyspaces(ctx context.Context, req *proto.DescribeKeyspacesRequest, reply *proto.DescribeKeyspacesResult) error {
+	return vtg.server.DescribeKeyspaces(ctx, req, reply)
+}
+
+func (vtg *VTGate) ValidateVSchema(ctx context.Context, req *proto.ValidateVSchemaRequest, reply *proto.ValidateVSchemaResult) error {
+	return vtg.server.ValidateVSchema(ctx, req, reply)
+}
+
+func (vtg *VTGate) BulkInsert(ctx context.Context, req *proto.BulkInsertRequest, reply *proto.QueryResult) error {
+	return vtg.server.BulkInsert(ctx, req, reply)
+}
+
+func (vtg *VTGate) ExecuteAsync(ctx context.Context, query *proto.Query, reply *proto.QueryResult) error {
+	return vtg.server.ExecuteAsync(ctx, query, reply)
+}
+
+func (vtg *VTGate) PrepareQuery(ctx context.Context, req *proto.PrepareQueryRequest, reply *proto.PrepareQueryResult) error {
+	return vtg.server.PrepareQuery(ctx, req, reply)
+}
+
+func (vtg *VTGate) ExecutePrepared(ctx context.Context, req *proto.ExecutePreparedRequest, reply *proto.QueryResult) error {
+	return vtg.server.ExecutePrepared(ctx, req, reply)
+}
+
+func (vtg *VTGate) ClosePrepared(ctx context.Context, req *proto.ClosePreparedRequest, noOutput *rpc.Unused) error {
+	return vtg.server.ClosePrepared(ctx, req)
+}
+
 func init() {
 	vtgate.RegisterVTGates = append(vtgate.RegisterVTGates, func(vtGate *vtgate.VTGate) {
 		servenv.Register("vtgateservice", &VTGate{vtGate})
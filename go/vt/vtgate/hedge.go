@@ -0,0 +1,138 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+)
+
+var hedgeDelay = flag.Duration("hedge_delay", 0, "time after which vtgate also sends a read-only single-shard replica query to a second tablet, and takes the first response, 0 disables it")
+
+// hedgedReads counts the queries that were sent to a second
+// tablet, and the ones the second tablet answered first.
+var hedgedReads = stats.NewCounters("VtgateHedgedReads")
+
+// canHedge returns true if the query of session can be sent
+// to a second replica tablet of its shard: it must be a read
+// outside of a transaction, which executes on a single shard.
+func (stc *ScatterConn) canHedge(query string, shards []string, tabletType topo.TabletType, session *SafeSession) bool {
+	if stc.hedgeDelay <= 0 || tabletType != topo.TYPE_REPLICA || len(unique(shards)) != 1 || session.InTransaction() {
+		return false
+	}
+	if reserveModeFor(query) == needReserved {
+		return false
+	}
+	stmt, err := sqlparser.Parse(query)
+	if err != nil {
+		return false
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	return ok && sel.Lock == ""
+}
+
+// ExecuteHedged is like Execute outside of a transaction, but if
+// vttablet didn't answer after delay, the query is also sent to
+// another tablet of the shard. The first successful result is
+// returned. A failure of the second tablet is ignored.
+func (sdc *ShardConn) ExecuteHedged(ctx context.Context, query string, bindVars map[string]interface{}, delay time.Duration) (*mproto.QueryResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		qr     *mproto.QueryResult
+		err    error
+		hedged bool
+	}
+	results := make(chan result, 2)
+	go func() {
+		qr, err := sdc.Execute(ctx, query, bindVars, 0)
+		results <- result{qr: qr, err: err}
+	}()
+	tmr := time.NewTimer(delay)
+	defer tmr.Stop()
+	select {
+	case r := <-results:
+		return r.qr, r.err
+	case <-tmr.C:
+	}
+
+	conn, ok := sdc.getHedgeConn(ctx)
+	if !ok {
+		r := <-results
+		return r.qr, r.err
+	}
+	hedgedReads.Add("Hedged", 1)
+	go func() {
+		qr, err := conn.Execute(ctx, query, bindVars, 0)
+		results <- result{qr: qr, err: err, hedged: true}
+	}()
+	for {
+		r := <-results
+		switch {
+		case r.err == nil:
+			if r.hedged {
+				hedgedReads.Add("HedgedFirst", 1)
+			}
+			return r.qr, nil
+		case r.hedged:
+			// Wait for the first tablet, which retries on its own.
+			if _, ok := r.err.(*tabletconn.ServerError); !ok {
+				sdc.dropHedgeConn(conn, r.err.Error())
+			}
+		default:
+			return nil, r.err
+		}
+	}
+}
+
+// getHedgeConn returns a connection to a tablet other than the one
+// of the current connection. ok is false if there's none available.
+func (sdc *ShardConn) getHedgeConn(ctx context.Context) (conn tabletconn.TabletConn, ok bool) {
+	sdc.mu.Lock()
+	defer sdc.mu.Unlock()
+	if sdc.conn == nil {
+		return nil, false
+	}
+	uid := sdc.conn.EndPoint().Uid
+	if sdc.hedgeConn != nil {
+		if sdc.hedgeConn.EndPoint().Uid != uid {
+			return sdc.hedgeConn, true
+		}
+		// The current connection moved to its tablet.
+		go sdc.hedgeConn.Close()
+		sdc.hedgeConn = nil
+	}
+	endPoint, ok := sdc.balancer.GetAlternate(uid)
+	if !ok {
+		return nil, false
+	}
+	conn, err := tabletconn.GetDialer()(ctx, endPoint, sdc.keyspace, sdc.shard, sdc.timeout)
+	if err != nil {
+		sdc.balancer.MarkDown(endPoint.Uid, err.Error())
+		return nil, false
+	}
+	sdc.hedgeConn = conn
+	return conn, true
+}
+
+// dropHedgeConn closes conn, which failed, and temporarily
+// marks its end point as unusable.
+func (sdc *ShardConn) dropHedgeConn(conn tabletconn.TabletConn, reason string) {
+	sdc.mu.Lock()
+	defer sdc.mu.Unlock()
+	if conn != sdc.hedgeConn {
+		return
+	}
+	sdc.balancer.MarkDown(conn.EndPoint().Uid, reason)
+	go sdc.hedgeConn.Close()
+	sdc.hedgeConn = nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// This file uses the sandbox_test framework.
+
+func TestCanHedge(t *testing.T) {
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+	stc.hedgeDelay = 10 * time.Millisecond
+	testcases := []struct {
+		query      string
+		shards     []string
+		tabletType topo.TabletType
+		session    *SafeSession
+		want       bool
+	}{
+		{"select * from t", []string{"0"}, topo.TYPE_REPLICA, nil, true},
+		{"select * from t", []string{"0", "0"}, topo.TYPE_REPLICA, nil, true},
+		{"select * from t", []string{"0", "1"}, topo.TYPE_REPLICA, nil, false},
+		{"select * from t", []string{"0"}, topo.TYPE_MASTER, nil, false},
+		{"select * from t", []string{"0"}, topo.TYPE_REPLICA, NewSafeSession(&proto.Session{InTransaction: true}), false},
+		{"select * from t for update", []string{"0"}, topo.TYPE_REPLICA, nil, false},
+		{"select get_lock('a', 10) from dual", []string{"0"}, topo.TYPE_REPLICA, nil, false},
+		{"update t set a = 1", []string{"0"}, topo.TYPE_REPLICA, nil, false},
+	}
+	for _, tcase := range testcases {
+		if got := stc.canHedge(tcase.query, tcase.shards, tcase.tabletType, tcase.session); got != tcase.want {
+			t.Errorf("canHedge(%s, %v, %s): %v, want %v", tcase.query, tcase.shards, tcase.tabletType, got, tcase.want)
+		}
+	}
+	stc.hedgeDelay = 0
+	if stc.canHedge("select * from t", []string{"0"}, topo.TYPE_REPLICA, nil) {
+		t.Errorf("canHedge with hedging disabled: true, want false")
+	}
+}
+
+// hedgeSetup returns a ScatterConn that hedges reads after 10ms,
+// with its connection to the first tablet of shard 0 of keyspace,
+// and the tablets of the shard in the order they're used.
+func hedgeSetup(t *testing.T, keyspace string) (*ScatterConn, *sandboxConn, *sandboxConn) {
+	s := createSandbox(keyspace)
+	sbcs := []*sandboxConn{{}, {}}
+	s.MapTestConn("0", sbcs[0])
+	s.MapTestConn("0", sbcs[1])
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Second)
+	stc.hedgeDelay = 10 * time.Millisecond
+	sdc := stc.getConnection(context.Background(), keyspace, "0", topo.TYPE_REPLICA)
+	if err := sdc.Dial(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if sdc.conn.EndPoint().Uid == 0 {
+		return stc, sbcs[0], sbcs[1]
+	}
+	return stc, sbcs[1], sbcs[0]
+}
+
+func TestScatterConnHedged(t *testing.T) {
+	stc, first, second := hedgeSetup(t, "TestScatterConnHedged")
+	first.mustDelay = 200 * time.Millisecond
+	startTime := time.Now()
+	qr, err := stc.Execute(context.Background(), "select * from t", nil, "TestScatterConnHedged", []string{"0"}, topo.TYPE_REPLICA, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qr.Rows) != 1 {
+		t.Errorf("rows: %v, want 1 row", qr.Rows)
+	}
+	if d := time.Now().Sub(startTime); d >= 200*time.Millisecond {
+		t.Errorf("Execute took %v, want less than 200ms", d)
+	}
+	if first.ExecCount.Get() != 1 || second.ExecCount.Get() != 1 {
+		t.Errorf("ExecCount: %d, %d, want 1, 1", first.ExecCount.Get(), second.ExecCount.Get())
+	}
+}
+
+func TestScatterConnHedgeNotNeeded(t *testing.T) {
+	stc, first, second := hedgeSetup(t, "TestScatterConnHedgeNotNeeded")
+	if _, err := stc.Execute(context.Background(), "select * from t", nil, "TestScatterConnHedgeNotNeeded", []string{"0"}, topo.TYPE_REPLICA, nil); err != nil {
+		t.Fatal(err)
+	}
+	if first.ExecCount.Get() != 1 || second.ExecCount.Get() != 0 {
+		t.Errorf("ExecCount: %d, %d, want 1, 0", first.ExecCount.Get(), second.ExecCount.Get())
+	}
+}
+
+func TestScatterConnHedgeFailed(t *testing.T) {
+	stc, first, second := hedgeSetup(t, "TestScatterConnHedgeFailed")
+	first.mustDelay = 50 * time.Millisecond
+	second.mustFailConn = 1
+	// The failure of the second tablet is ignored.
+	if _, err := stc.Execute(context.Background(), "select * from t", nil, "TestScatterConnHedgeFailed", []string{"0"}, topo.TYPE_REPLICA, nil); err != nil {
+		t.Fatal(err)
+	}
+	if first.ExecCount.Get() != 1 || second.ExecCount.Get() != 1 {
+		t.Errorf("ExecCount: %d, %d, want 1, 1", first.ExecCount.Get(), second.ExecCount.Get())
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/youtube/vitess/go/vt/concurrency"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// keyspaceSchemas composes the schema of a Router from the
+// schemas of its keyspaces, which are updated independently.
+// A keyspace whose new schema is rejected keeps its previous
+// one, so that the queries of the other keyspaces are still
+// planned.
+//
+// The schemas of the keyspaces come from the vschemas, or are
+// discovered from the tables of the unsharded keyspaces. The
+// explicit schema of a keyspace hides its discovered schema,
+// and the discovered tables that are defined by an explicit
+// schema are left out. The columns learned from the tablets are
+// added to the tables of both.
+type keyspaceSchemas struct {
+	planner *Planner
+
+	mu         sync.Mutex
+	explicit   map[string]*planbuilder.KeyspaceSchema
+	discovered map[string]*planbuilder.KeyspaceSchema
+	columns    map[string]map[string][]planbuilder.Column
+}
+
+func newKeyspaceSchemas(planner *Planner) *keyspaceSchemas {
+	return &keyspaceSchemas{
+		planner:    planner,
+		explicit:   splitSchema(planner.Schema()),
+		discovered: make(map[string]*planbuilder.KeyspaceSchema),
+		columns:    make(map[string]map[string][]planbuilder.Column),
+	}
+}
+
+// splitSchema returns the schemas of the keyspaces of schema.
+func splitSchema(schema *planbuilder.Schema) map[string]*planbuilder.KeyspaceSchema {
+	keyspaces := make(map[string]*planbuilder.KeyspaceSchema)
+	if schema == nil {
+		return keyspaces
+	}
+	add := func(tname string, table *planbuilder.Table) {
+		ks, ok := keyspaces[table.Keyspace.Name]
+		if !ok {
+			ks = &planbuilder.KeyspaceSchema{
+				Keyspace: table.Keyspace,
+				Tables:   make(map[string]*planbuilder.Table),
+			}
+			keyspaces[table.Keyspace.Name] = ks
+		}
+		ks.Tables[tname] = table
+	}
+	for tname, table := range schema.Tables {
+		add(tname, table)
+	}
+	for tname, tables := range schema.Ambiguous {
+		for _, table := range tables {
+			add(tname, table)
+		}
+	}
+	return keyspaces
+}
+
+// set replaces the explicit schemas with the ones of schema,
+// and keeps the discovered ones and the learned columns.
+func (kss *keyspaceSchemas) set(schema *planbuilder.Schema) {
+	kss.mu.Lock()
+	defer kss.mu.Unlock()
+	kss.explicit = splitSchema(schema)
+	if len(kss.discovered) != 0 || len(kss.columns) != 0 {
+		// The discovered schemas were merged before,
+		// and they leave out the explicit tables.
+		if merged, err := kss.merge(); err == nil {
+			schema = merged
+		}
+	}
+	kss.planner.SetSchema(schema)
+}
+
+// names returns the sorted names of the keyspaces that have an
+// explicit or discovered schema. The template keyspaces are left
+// out, since they don't exist in the topology.
+func (kss *keyspaceSchemas) names() []string {
+	kss.mu.Lock()
+	defer kss.mu.Unlock()
+	var names []string
+	for name, ks := range kss.explicit {
+		if ks.Keyspace.Template {
+			continue
+		}
+		names = append(names, name)
+	}
+	for name := range kss.discovered {
+		if _, ok := kss.explicit[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// updateColumns replaces the columns of the tables of the
+// keyspaces of changed, and removes the ones that map to nil.
+// The schema of the router is replaced if any keyspace changed.
+func (kss *keyspaceSchemas) updateColumns(changed map[string]map[string][]planbuilder.Column, rec *concurrency.AllErrorRecorder) {
+	if len(changed) == 0 {
+		return
+	}
+	kss.mu.Lock()
+	defer kss.mu.Unlock()
+	for name, columns := range changed {
+		if columns != nil {
+			kss.columns[name] = columns
+		} else {
+			delete(kss.columns, name)
+		}
+	}
+	schema, err := kss.merge()
+	if err != nil {
+		rec.RecordError(err)
+		return
+	}
+	kss.planner.SetSchema(schema)
+}
+
+// isExplicit returns true if keyspace has an explicit schema.
+func (kss *keyspaceSchemas) isExplicit(keyspace string) bool {
+	kss.mu.Lock()
+	defer kss.mu.Unlock()
+	_, ok := kss.explicit[keyspace]
+	return ok
+}
+
+// update replaces the explicit or discovered schemas of the
+// keyspaces of changed, and removes the ones that map to nil.
+// The schemas that define a table of another keyspace are
+// rejected, and reported in rec. The schema of the router is
+// replaced if any keyspace changed, or if force is true.
+func (kss *keyspaceSchemas) update(changed map[string]*planbuilder.KeyspaceSchema, discovered, force bool, rec *concurrency.AllErrorRecorder) {
+	kss.mu.Lock()
+	defer kss.mu.Unlock()
+	target := kss.explicit
+	if discovered {
+		target = kss.discovered
+	}
+	names := make([]string, 0, len(changed))
+	for name := range changed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	applied := 0
+	for _, name := range names {
+		previous, existed := target[name]
+		if ks := changed[name]; ks != nil {
+			target[name] = ks
+		} else {
+			delete(target, name)
+		}
+		if _, err := kss.merge(); err != nil {
+			rec.RecordError(fmt.Errorf("keyspace %s: %v", name, err))
+			if existed {
+				target[name] = previous
+			} else {
+				delete(target, name)
+			}
+			continue
+		}
+		applied++
+	}
+	if applied == 0 && !force {
+		return
+	}
+	schema, err := kss.merge()
+	if err != nil {
+		// The accepted keyspaces were merged one at a time.
+		rec.RecordError(err)
+		return
+	}
+	kss.planner.SetSchema(schema)
+}
+
+// merge builds the schema of the explicit and discovered
+// keyspaces. It must be called with mu held.
+func (kss *keyspaceSchemas) merge() (*planbuilder.Schema, error) {
+	keyspaces := make([]*planbuilder.KeyspaceSchema, 0, len(kss.explicit)+len(kss.discovered))
+	tables := make(map[string]bool)
+	for name, ks := range kss.explicit {
+		keyspaces = append(keyspaces, kss.withColumns(name, ks))
+		for tname := range ks.Tables {
+			tables[tname] = true
+		}
+	}
+	for name, ks := range kss.discovered {
+		if _, ok := kss.explicit[name]; ok {
+			continue
+		}
+		visible := &planbuilder.KeyspaceSchema{
+			Keyspace: ks.Keyspace,
+			Tables:   make(map[string]*planbuilder.Table, len(ks.Tables)),
+		}
+		for tname, table := range ks.Tables {
+			if !tables[tname] {
+				visible.Tables[tname] = table
+			}
+		}
+		keyspaces = append(keyspaces, kss.withColumns(name, visible))
+	}
+	return planbuilder.MergeKeyspaces(keyspaces)
+}
+
+// withColumns returns ks with the columns learned for keyspace
+// name. It must be called with mu held.
+func (kss *keyspaceSchemas) withColumns(name string, ks *planbuilder.KeyspaceSchema) *planbuilder.KeyspaceSchema {
+	columns, ok := kss.columns[name]
+	if !ok {
+		return ks
+	}
+	return ks.WithColumns(columns)
+}
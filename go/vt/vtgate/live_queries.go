@@ -0,0 +1,281 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/youtube/vitess/go/acl"
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// The queries routed by the Router are registered while they're in
+// flight. They're listed by SHOW PROCESSLIST and /debug/queries, and
+// can be killed by KILL QUERY, which cancels their context, and with
+// it their calls to the shards. The vindex lookups and the other
+// queries that are a part of a statement are not registered: their
+// shards are listed with the statement, and killing it kills them.
+
+const liveQueryKey contextKey = 5
+
+// processlistInfoLength is the length the queries are cut to
+// by SHOW PROCESSLIST, if it's not FULL, like MySQL does.
+const processlistInfoLength = 100
+
+var killedQueries = stats.NewInt("VtgateKilledQueries")
+
+var processlistFields = []mproto.Field{
+	{Name: "Id", Type: mproto.VT_LONGLONG},
+	{Name: "TabletType", Type: mproto.VT_VAR_STRING},
+	{Name: "Time", Type: mproto.VT_LONGLONG},
+	{Name: "Shards", Type: mproto.VT_VAR_STRING},
+	{Name: "Info", Type: mproto.VT_VAR_STRING},
+}
+
+// liveQuery is a query in flight.
+type liveQuery struct {
+	id            int64
+	sql           string
+	tabletType    topo.TabletType
+	inTransaction bool
+	startTime     time.Time
+	cancel        context.CancelFunc
+
+	// mu protects the fields below.
+	mu     sync.Mutex
+	shards map[string]struct{}
+	killed bool
+}
+
+// LiveQueryInfo describes a query in flight.
+type LiveQueryInfo struct {
+	ID            int64
+	Sql           string
+	TabletType    topo.TabletType
+	InTransaction bool
+	StartTime     time.Time
+	// Shards are the keyspace/shard the query was sent to so far.
+	Shards []string
+	Killed bool
+}
+
+func (lq *liveQuery) info() LiveQueryInfo {
+	lq.mu.Lock()
+	defer lq.mu.Unlock()
+	shards := make([]string, 0, len(lq.shards))
+	for shard := range lq.shards {
+		shards = append(shards, shard)
+	}
+	sort.Strings(shards)
+	return LiveQueryInfo{
+		ID:            lq.id,
+		Sql:           lq.sql,
+		TabletType:    lq.tabletType,
+		InTransaction: lq.inTransaction,
+		StartTime:     lq.startTime,
+		Shards:        shards,
+		Killed:        lq.killed,
+	}
+}
+
+// killedErr returns the error of a killed query that failed
+// with err. err is returned as is if the query wasn't killed.
+// It does nothing if lq is nil.
+func (lq *liveQuery) killedErr(err error) error {
+	if lq == nil || err == nil {
+		return err
+	}
+	lq.mu.Lock()
+	defer lq.mu.Unlock()
+	if !lq.killed {
+		return err
+	}
+	return fmt.Errorf("query %d was killed: %v", lq.id, err)
+}
+
+// addLiveShards records that the query of ctx,
+// if any, was sent to shards of keyspace.
+func addLiveShards(ctx context.Context, keyspace string, shards map[string]struct{}) {
+	lq, ok := ctx.Value(liveQueryKey).(*liveQuery)
+	if !ok {
+		return
+	}
+	lq.mu.Lock()
+	defer lq.mu.Unlock()
+	for shard := range shards {
+		lq.shards[keyspace+"/"+shard] = struct{}{}
+	}
+}
+
+// liveQueryRegistry holds the queries in flight.
+type liveQueryRegistry struct {
+	mu      sync.Mutex
+	lastID  int64
+	queries map[int64]*liveQuery
+}
+
+func newLiveQueryRegistry() *liveQueryRegistry {
+	return &liveQueryRegistry{queries: make(map[int64]*liveQuery)}
+}
+
+// register registers query, and returns the context it's executed
+// with, which is canceled if it's killed. It returns ctx and a nil
+// query if ctx already belongs to a statement the query is a part of.
+func (lqr *liveQueryRegistry) register(ctx context.Context, query *proto.Query) (context.Context, *liveQuery) {
+	if _, ok := ctx.Value(liveQueryKey).(*liveQuery); ok {
+		return ctx, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	lq := &liveQuery{
+		sql:        query.Sql,
+		tabletType: query.TabletType,
+		startTime:  time.Now(),
+		cancel:     cancel,
+		shards:     make(map[string]struct{}),
+	}
+	if query.Session != nil {
+		lq.inTransaction = query.Session.InTransaction
+	}
+	lqr.mu.Lock()
+	lqr.lastID++
+	lq.id = lqr.lastID
+	lqr.queries[lq.id] = lq
+	lqr.mu.Unlock()
+	return context.WithValue(ctx, liveQueryKey, lq), lq
+}
+
+// unregister removes a query that's done from the registry.
+// It does nothing if lq is nil.
+func (lqr *liveQueryRegistry) unregister(lq *liveQuery) {
+	if lq == nil {
+		return
+	}
+	lqr.mu.Lock()
+	delete(lqr.queries, lq.id)
+	lqr.mu.Unlock()
+	lq.cancel()
+}
+
+// kill cancels the context of the query id.
+func (lqr *liveQueryRegistry) kill(id int64) error {
+	lqr.mu.Lock()
+	lq, ok := lqr.queries[id]
+	lqr.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown query id: %d", id)
+	}
+	lq.mu.Lock()
+	lq.killed = true
+	lq.mu.Unlock()
+	lq.cancel()
+	killedQueries.Add(1)
+	return nil
+}
+
+// list returns the queries in flight, sorted by id.
+func (lqr *liveQueryRegistry) list() []LiveQueryInfo {
+	lqr.mu.Lock()
+	queries := make([]*liveQuery, 0, len(lqr.queries))
+	for _, lq := range lqr.queries {
+		queries = append(queries, lq)
+	}
+	lqr.mu.Unlock()
+	infos := make([]LiveQueryInfo, len(queries))
+	for i, lq := range queries {
+		infos[i] = lq.info()
+	}
+	sort.Sort(byQueryID(infos))
+	return infos
+}
+
+type byQueryID []LiveQueryInfo
+
+func (b byQueryID) Len() int           { return len(b) }
+func (b byQueryID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byQueryID) Less(i, j int) bool { return b[i].ID < b[j].ID }
+
+// ServeHTTP serves the queries in flight on /debug/queries. The
+// query of the kill parameter is killed first, if it's set.
+func (lqr *liveQueryRegistry) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	if err := acl.CheckAccessHTTP(request, acl.DEBUGGING); err != nil {
+		acl.SendError(response, err)
+		return
+	}
+	if kill := request.FormValue("kill"); kill != "" {
+		if err := acl.CheckAccessHTTP(request, acl.ADMIN); err != nil {
+			acl.SendError(response, err)
+			return
+		}
+		id, err := strconv.ParseInt(kill, 10, 64)
+		if err == nil {
+			err = lqr.kill(id)
+		}
+		if err != nil {
+			http.Error(response, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	response.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if b, err := json.MarshalIndent(lqr.list(), "", "  "); err != nil {
+		response.Write([]byte(err.Error()))
+	} else {
+		response.Write(b)
+	}
+}
+
+// Queries returns the queries in flight, sorted by id.
+func (rtr *Router) Queries() []LiveQueryInfo {
+	return rtr.live.list()
+}
+
+// KillQuery kills the query id in flight: its context is canceled,
+// which cancels its calls to the shards, and it fails.
+func (rtr *Router) KillQuery(id int64) error {
+	return rtr.live.kill(id)
+}
+
+// execShowProcesslist returns the queries in flight. Their sql is
+// cut to processlistInfoLength bytes, unless full is true.
+func (rtr *Router) execShowProcesslist(vcursor *requestContext, full bool) (*mproto.QueryResult, error) {
+	result := &mproto.QueryResult{Fields: processlistFields}
+	now := time.Now()
+	for _, info := range rtr.live.list() {
+		sql := info.Sql
+		if !full && len(sql) > processlistInfoLength {
+			sql = sql[:processlistInfoLength]
+		}
+		result.Rows = append(result.Rows, []sqltypes.Value{
+			sqltypes.MakeNumeric([]byte(strconv.FormatInt(info.ID, 10))),
+			sqltypes.MakeString([]byte(info.TabletType)),
+			sqltypes.MakeNumeric([]byte(strconv.FormatInt(int64(now.Sub(info.StartTime)/time.Second), 10))),
+			sqltypes.MakeString([]byte(strings.Join(info.Shards, ","))),
+			sqltypes.MakeString([]byte(sql)),
+		})
+	}
+	result.RowsAffected = uint64(len(result.Rows))
+	return result, nil
+}
+
+// execKill kills the query id.
+func (rtr *Router) execKill(vcursor *requestContext, id int64) (*mproto.QueryResult, error) {
+	if err := rtr.live.kill(id); err != nil {
+		return nil, err
+	}
+	return &mproto.QueryResult{}, nil
+}
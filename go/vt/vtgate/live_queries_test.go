@@ -0,0 +1,143 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestRouterKillQuery(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{mustDelay: 2 * time.Second}
+	s.MapTestConn("-20", sbc)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Millisecond, 0, 10*time.Second)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	ctx := context.Background()
+
+	sql := "select * from user where id = 1"
+	done := make(chan error)
+	go func() {
+		_, err := router.Execute(ctx, &proto.Query{Sql: sql, TabletType: topo.TYPE_MASTER})
+		done <- err
+	}()
+	var info LiveQueryInfo
+	for {
+		if queries := router.Queries(); len(queries) == 1 && len(queries[0].Shards) == 1 {
+			info = queries[0]
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if info.Sql != sql || info.Shards[0] != "TestRouter/-20" || info.Killed {
+		t.Errorf("Queries: %+v, want %s on TestRouter/-20", info, sql)
+	}
+
+	// SHOW PROCESSLIST lists itself too.
+	result, err := router.Execute(ctx, &proto.Query{Sql: "show processlist", TabletType: topo.TYPE_MASTER})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRow := []sqltypes.Value{
+		sqltypes.MakeNumeric([]byte(fmt.Sprintf("%d", info.ID))),
+		sqltypes.MakeString([]byte(topo.TYPE_MASTER)),
+		sqltypes.MakeNumeric([]byte("0")),
+		sqltypes.MakeString([]byte("TestRouter/-20")),
+		sqltypes.MakeString([]byte(sql)),
+	}
+	if len(result.Rows) != 2 || !reflect.DeepEqual(result.Rows[0], wantRow) {
+		t.Errorf("show processlist: %v, want 2 rows starting with %v", result.Rows, wantRow)
+	}
+
+	count := killedQueries.Get()
+	startTime := time.Now()
+	if _, err := router.Execute(ctx, &proto.Query{Sql: fmt.Sprintf("kill query %d", info.ID), TabletType: topo.TYPE_MASTER}); err != nil {
+		t.Fatal(err)
+	}
+	err = <-done
+	if want := fmt.Sprintf("query %d was killed", info.ID); err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("killed query: %v, want %s", err, want)
+	}
+	if elapsed := time.Now().Sub(startTime); elapsed >= sbc.mustDelay {
+		t.Errorf("killed query returned after %v, want it to not wait for the shard", elapsed)
+	}
+	if got := killedQueries.Get() - count; got != 1 {
+		t.Errorf("VtgateKilledQueries: %d more, want 1", got)
+	}
+	if queries := router.Queries(); len(queries) != 0 {
+		t.Errorf("Queries: %+v, want none", queries)
+	}
+
+	want := fmt.Sprintf("unknown query id: %d", info.ID)
+	if err := router.KillQuery(info.ID); err == nil || err.Error() != want {
+		t.Errorf("KillQuery: %v, want %s", err, want)
+	}
+}
+
+func TestRouterLiveQueryLookup(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{mustDelay: 100 * time.Millisecond}
+	s.MapTestConn("-20", sbc)
+	l := createSandbox("TestUnsharded")
+	l.MapTestConn("0", &sandboxConn{})
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Millisecond, 0, 10*time.Second)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	ctx := context.Background()
+
+	// The id of music is looked up in music_user_map first.
+	sql := "select * from music where id = 1"
+	done := make(chan error)
+	go func() {
+		_, err := router.Execute(ctx, &proto.Query{Sql: sql, TabletType: topo.TYPE_MASTER})
+		done <- err
+	}()
+	// The statement is sent to TestRouter/-20 once the lookup is done.
+	var queries []LiveQueryInfo
+	for i := 0; ; i++ {
+		queries = router.Queries()
+		if len(queries) == 1 && len(queries[0].Shards) != 0 && queries[0].Shards[0] == "TestRouter/-20" {
+			break
+		}
+		if i == 1000 {
+			t.Fatalf("Queries: %+v, want the statement on TestRouter/-20", queries)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	want := []string{"TestRouter/-20", "TestUnsharded/0"}
+	if queries[0].ID != 1 || queries[0].Sql != sql || !reflect.DeepEqual(queries[0].Shards, want) {
+		t.Errorf("Queries: %+v, want %s on %v", queries, sql, want)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// The lookup didn't take an id of its own.
+	result, err := router.Execute(ctx, &proto.Query{Sql: "show processlist", TabletType: topo.TYPE_MASTER})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rows) != 1 || result.Rows[0][0].String() != "2" {
+		t.Errorf("show processlist: %v, want itself with id 2", result.Rows)
+	}
+}
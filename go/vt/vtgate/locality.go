@@ -0,0 +1,80 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+
+	"github.com/youtube/vitess/go/flagutil"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+)
+
+// The replica and rdonly reads of the V3 API are sent to the
+// tablets of the cell of the client, as set in its session, or
+// else of the cell of vtgate. A shard that has no healthy tablet
+// of the type in that cell is read from the first of the remote
+// read cells that has one, which costs a cross-cell round-trip.
+
+var remoteReadCells flagutil.StringListValue
+
+func init() {
+	flag.Var(&remoteReadCells, "remote_read_cells", "comma-separated list of cells, in order of preference, to read from the replica and rdonly tablets of a shard that has no healthy tablet of the type in the local cell")
+}
+
+// remoteReads counts the reads sent to a remote cell.
+var remoteReads = stats.NewMultiCounters("VtgateRemoteReads", []string{"Cell", "RemoteCell", "DbType"})
+
+// remoteCellFallback is a SrvTopoServer that returns the endpoints
+// of the first of cells that has healthy ones, when the requested
+// cell doesn't.
+type remoteCellFallback struct {
+	SrvTopoServer
+	cells []string
+}
+
+// GetEndPoints returns the endpoints of the shard in cell,
+// unless none of them is healthy.
+func (f remoteCellFallback) GetEndPoints(ctx context.Context, cell, keyspace, shard string, tabletType topo.TabletType) (*topo.EndPoints, error) {
+	endPoints, err := f.SrvTopoServer.GetEndPoints(ctx, cell, keyspace, shard, tabletType)
+	if err == nil && hasHealthyEndPoint(endPoints) {
+		return endPoints, nil
+	}
+	for _, remoteCell := range f.cells {
+		if remoteCell == cell {
+			continue
+		}
+		remoteEndPoints, remoteErr := f.SrvTopoServer.GetEndPoints(ctx, remoteCell, keyspace, shard, tabletType)
+		if remoteErr != nil || !hasHealthyEndPoint(remoteEndPoints) {
+			continue
+		}
+		remoteReads.Add([]string{cell, remoteCell, string(tabletType)}, 1)
+		return remoteEndPoints, nil
+	}
+	return endPoints, err
+}
+
+// hasHealthyEndPoint returns true if endPoints has at least one
+// healthy endpoint. The endpoints of ResilientSrvTopoServer are
+// all healthy or all degraded, so the first one is representative.
+func hasHealthyEndPoint(endPoints *topo.EndPoints) bool {
+	return endPoints != nil && len(endPoints.Entries) != 0 && endPointIsHealthy(endPoints.Entries[0])
+}
+
+// withRemoteReads returns the ScatterConn that sends the queries
+// to the tablets of the cell of stc, or to those of its remote read
+// cells for the shards that have no healthy tablet in it.
+func (stc *ScatterConn) withRemoteReads() *ScatterConn {
+	if len(stc.remoteReadCells) == 0 {
+		return stc
+	}
+	stc.mu.Lock()
+	defer stc.mu.Unlock()
+	if stc.remoteReadConn == nil {
+		stc.remoteReadConn = stc.derive(remoteCellFallback{stc.toposerv, stc.remoteReadCells}, stc.cell)
+	}
+	return stc.remoteReadConn
+}
@@ -0,0 +1,106 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/health"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// cellTopo is a SrvTopoServer that returns the endpoints of
+// its map by cell.
+type cellTopo struct {
+	sandboxTopo
+	endPoints map[string]*topo.EndPoints
+}
+
+func (ct *cellTopo) GetEndPoints(ctx context.Context, cell, keyspace, shard string, tabletType topo.TabletType) (*topo.EndPoints, error) {
+	endPoints, ok := ct.endPoints[cell]
+	if !ok {
+		return nil, fmt.Errorf("no endpoints in %v", cell)
+	}
+	return endPoints, nil
+}
+
+func TestRemoteCellFallback(t *testing.T) {
+	healthy := &topo.EndPoints{Entries: []topo.EndPoint{{Uid: 1}}}
+	lagging := &topo.EndPoints{Entries: []topo.EndPoint{{Uid: 2, Health: map[string]string{health.ReplicationLag: health.ReplicationLagHigh}}}}
+	remote := &topo.EndPoints{Entries: []topo.EndPoint{{Uid: 3}}}
+	ct := &cellTopo{endPoints: map[string]*topo.EndPoints{
+		"healthy": healthy,
+		"lagging": lagging,
+		"empty":   &topo.EndPoints{},
+		"remote":  remote,
+	}}
+	f := remoteCellFallback{ct, []string{"down", "lagging", "remote"}}
+	for _, tcase := range []struct {
+		cell string
+		want *topo.EndPoints
+	}{
+		{"healthy", healthy},
+		{"lagging", remote},
+		{"empty", remote},
+		{"down", remote},
+		{"remote", remote},
+	} {
+		got, err := f.GetEndPoints(context.Background(), tcase.cell, "ks", "0", topo.TYPE_REPLICA)
+		if err != nil || got != tcase.want {
+			t.Errorf("GetEndPoints(%v): %v, %v, want %v", tcase.cell, got, err, tcase.want)
+		}
+	}
+
+	// No remote cell has healthy endpoints.
+	f.cells = []string{"lagging"}
+	if got, err := f.GetEndPoints(context.Background(), "down", "ks", "0", topo.TYPE_REPLICA); err == nil {
+		t.Errorf("GetEndPoints(down): %v, want the error of the local cell", got)
+	}
+	if got, err := f.GetEndPoints(context.Background(), "empty", "ks", "0", topo.TYPE_REPLICA); err != nil || len(got.Entries) != 0 {
+		t.Errorf("GetEndPoints(empty): %v, %v, want the empty endpoints of the local cell", got, err)
+	}
+}
+
+func TestRouterSessionCell(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	s.MapTestConn("-20", &sandboxConn{})
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Millisecond, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	sql := "select * from user where id = 1"
+	for _, tcase := range []struct {
+		tabletType topo.TabletType
+		want       string
+	}{
+		{topo.TYPE_REPLICA, "bb"},
+		{topo.TYPE_RDONLY, "bb"},
+		{topo.TYPE_MASTER, "aa"},
+	} {
+		query := &proto.Query{
+			Sql:        sql,
+			TabletType: tcase.tabletType,
+			Session:    &proto.Session{Cell: "bb"},
+		}
+		if _, err := router.Execute(context.Background(), query); err != nil {
+			t.Fatal(err)
+		}
+		s.sandmu.Lock()
+		cell := s.EndPointCell
+		s.sandmu.Unlock()
+		if cell != tcase.want {
+			t.Errorf("cell of the %v read: %v, want %v", tcase.tabletType, cell, tcase.want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"container/heap"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// streamMergeBatchSize is the number of rows sent
+// in each reply by streamMergeSort.
+const streamMergeBatchSize = 100
+
+// mergeSort performs a k-way merge of the results returned by the
+// shards, each of which is expected to be sorted by orderBy. If
+// orderBy is nil, the results are concatenated. If distinct is
+// not nil, duplicate rows are skipped. Only the rows specified
+// by limit are returned.
+func mergeSort(results []*mproto.QueryResult, orderBy []planbuilder.OrderByCol, limit *planbuilder.Limit, distinct *distinctFilter) (*mproto.QueryResult, error) {
+	streams := make([]<-chan *mproto.QueryResult, 0, len(results))
+	for _, result := range results {
+		stream := make(chan *mproto.QueryResult, 1)
+		stream <- result
+		close(stream)
+		streams = append(streams, stream)
+	}
+	qr := new(mproto.QueryResult)
+	err := streamMergeSort(streams, orderBy, limit, distinct, func(reply *mproto.QueryResult) error {
+		if reply.Fields != nil {
+			qr.Fields = reply.Fields
+		}
+		qr.Rows = append(qr.Rows, reply.Rows...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	qr.RowsAffected = uint64(len(qr.Rows))
+	return qr, nil
+}
+
+// streamMergeSort is like mergeSort, but the results are read
+// from a stream per shard, and the merged rows are sent as soon
+// as they're known, in batches of streamMergeBatchSize. The fields
+// are sent first, in a reply of their own. A stream is read only
+// when its next row is needed. So, a slow sendReply slows down
+// the reading of the streams instead of causing rows to pile up.
+// The caller is responsible for draining the streams if an error
+// is returned or the limit is reached.
+func streamMergeSort(streams []<-chan *mproto.QueryResult, orderBy []planbuilder.OrderByCol, limit *planbuilder.Limit, distinct *distinctFilter, sendReply func(*mproto.QueryResult) error) error {
+	mh := &mergeHeap{orderBy: orderBy}
+	for _, stream := range streams {
+		mc := &mergeCursor{stream: stream}
+		if mc.fill(&mh.fields) {
+			mh.cursors = append(mh.cursors, mc)
+		}
+	}
+	if mh.fields != nil {
+		if err := sendReply(&mproto.QueryResult{Fields: mh.fields}); err != nil {
+			return err
+		}
+	}
+	skip, count := int64(0), int64(-1)
+	if limit != nil {
+		skip, count = limit.Offset, limit.Rowcount
+	}
+	if orderBy != nil {
+		heap.Init(mh)
+	}
+	var rows [][]sqltypes.Value
+	for mh.Len() != 0 && count != 0 {
+		mc := mh.cursors[0]
+		row := mc.rows[0]
+		mc.rows = mc.rows[1:]
+		if !mc.fill(&mh.fields) {
+			heap.Remove(mh, 0)
+		} else if orderBy != nil {
+			heap.Fix(mh, 0)
+		}
+		if mh.err != nil {
+			return mh.err
+		}
+		if distinct != nil {
+			isNew, err := distinct.isNew(row)
+			if err != nil {
+				return err
+			}
+			if !isNew {
+				continue
+			}
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		rows = append(rows, row)
+		count--
+		if len(rows) == streamMergeBatchSize {
+			if err := sendRows(rows, sendReply); err != nil {
+				return err
+			}
+			rows = nil
+		}
+	}
+	if rows == nil {
+		return nil
+	}
+	return sendRows(rows, sendReply)
+}
+
+func sendRows(rows [][]sqltypes.Value, sendReply func(*mproto.QueryResult) error) error {
+	return sendReply(&mproto.QueryResult{
+		RowsAffected: uint64(len(rows)),
+		Rows:         rows,
+	})
+}
+
+// mergeCursor holds the unconsumed rows of a shard.
+type mergeCursor struct {
+	stream <-chan *mproto.QueryResult
+	rows   [][]sqltypes.Value
+}
+
+// fill reads from the stream until there are rows available.
+// It returns false if the stream has ended. The first fields
+// received are saved in fields.
+func (mc *mergeCursor) fill(fields *[]mproto.Field) bool {
+	for len(mc.rows) == 0 {
+		result, ok := <-mc.stream
+		if !ok {
+			return false
+		}
+		if *fields == nil && result.Fields != nil {
+			*fields = result.Fields
+		}
+		mc.rows = result.Rows
+	}
+	return true
+}
+
+// mergeHeap is a heap of the shard cursors,
+// ordered by their first row.
+type mergeHeap struct {
+	fields  []mproto.Field
+	orderBy []planbuilder.OrderByCol
+	cursors []*mergeCursor
+	// err is the first error encountered while comparing rows.
+	err error
+}
+
+func (mh *mergeHeap) Len() int {
+	return len(mh.cursors)
+}
+
+func (mh *mergeHeap) Less(i, j int) bool {
+	cmp, err := compareRows(mh.fields, mh.orderBy, mh.cursors[i].rows[0], mh.cursors[j].rows[0])
+	if err != nil && mh.err == nil {
+		mh.err = err
+	}
+	return cmp < 0
+}
+
+func (mh *mergeHeap) Swap(i, j int) {
+	mh.cursors[i], mh.cursors[j] = mh.cursors[j], mh.cursors[i]
+}
+
+func (mh *mergeHeap) Push(x interface{}) {
+	mh.cursors = append(mh.cursors, x.(*mergeCursor))
+}
+
+func (mh *mergeHeap) Pop() interface{} {
+	last := mh.cursors[len(mh.cursors)-1]
+	mh.cursors = mh.cursors[:len(mh.cursors)-1]
+	return last
+}
+
+// compareRows compares two rows using orderBy.
+// NULL values sort before everything else, like in MySQL.
+func compareRows(fields []mproto.Field, orderBy []planbuilder.OrderByCol, row1, row2 []sqltypes.Value) (int, error) {
+	for _, order := range orderBy {
+		v1, v2 := row1[order.Col], row2[order.Col]
+		var cmp int
+		switch {
+		case v1.IsNull() && v2.IsNull():
+		case v1.IsNull():
+			cmp = -1
+		case v2.IsNull():
+			cmp = 1
+		default:
+			var err error
+			if cmp, err = compareValues(fields[order.Col].Type, v1, v2); err != nil {
+				return 0, err
+			}
+		}
+		if order.Desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp, nil
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// numberStream returns a stream that sends the fields,
+// and then the numbers from start to end in steps of
+// step, one row per result.
+func numberStream(start, end, step int) <-chan *mproto.QueryResult {
+	stream := make(chan *mproto.QueryResult)
+	go func() {
+		stream <- &mproto.QueryResult{
+			Fields: []mproto.Field{{"n", mproto.VT_LONGLONG}},
+		}
+		for i := start; i < end; i += step {
+			stream <- &mproto.QueryResult{
+				Rows: [][]sqltypes.Value{{sqltypes.MakeNumeric([]byte(fmt.Sprintf("%d", i)))}},
+			}
+		}
+		close(stream)
+	}()
+	return stream
+}
+
+func TestStreamMergeSort(t *testing.T) {
+	streams := []<-chan *mproto.QueryResult{
+		numberStream(0, 300, 3),
+		numberStream(1, 300, 3),
+		numberStream(2, 300, 3),
+	}
+	var replies []*mproto.QueryResult
+	err := streamMergeSort(streams, []planbuilder.OrderByCol{{Col: 0}}, nil, nil, func(qr *mproto.QueryResult) error {
+		replies = append(replies, qr)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fields are followed by 3 full batches.
+	if len(replies) != 4 {
+		t.Fatalf("len(replies): %d, want 4", len(replies))
+	}
+	if replies[0].Fields == nil || replies[0].Rows != nil {
+		t.Errorf("replies[0]: %+v, want fields only", replies[0])
+	}
+	n := 0
+	for _, reply := range replies[1:] {
+		if len(reply.Rows) != streamMergeBatchSize {
+			t.Errorf("len(reply.Rows): %d, want %d", len(reply.Rows), streamMergeBatchSize)
+		}
+		for _, row := range reply.Rows {
+			want := fmt.Sprintf("%d", n)
+			if got := row[0].String(); got != want {
+				t.Fatalf("row %d: %s, want %s", n, got, want)
+			}
+			n++
+		}
+	}
+}
+
+func TestStreamMergeSortLimit(t *testing.T) {
+	streams := []<-chan *mproto.QueryResult{
+		numberStream(0, 300, 2),
+		numberStream(1, 300, 2),
+	}
+	var rows [][]sqltypes.Value
+	limit := &planbuilder.Limit{Offset: 5, Rowcount: 3}
+	err := streamMergeSort(streams, []planbuilder.OrderByCol{{Col: 0}}, limit, nil, func(qr *mproto.QueryResult) error {
+		rows = append(rows, qr.Rows...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]sqltypes.Value{
+		{sqltypes.MakeNumeric([]byte("5"))},
+		{sqltypes.MakeNumeric([]byte("6"))},
+		{sqltypes.MakeNumeric([]byte("7"))},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows: %v, want %v", rows, want)
+	}
+	// Release the goroutines of the unread rows.
+	for _, stream := range streams {
+		for range stream {
+		}
+	}
+}
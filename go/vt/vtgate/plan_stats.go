@@ -0,0 +1,146 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/youtube/vitess/go/acl"
+	"github.com/youtube/vitess/go/cache"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"golang.org/x/net/context"
+)
+
+var (
+	slowPlanTime   = flag.Duration("slow_plan_time", 0, "queries that take longer than this are recorded in the slow plan log, 0 disables the threshold")
+	slowPlanShards = flag.Int("slow_plan_shards", 0, "queries that are sent to at least this many shards are recorded in the slow plan log, 0 disables the threshold")
+	slowPlansMax   = flag.Int("slow_plans_max", 100, "maximum number of queries in the slow plan log, beyond which the least recently recorded ones are dropped")
+
+	slowPlanCounts = stats.NewCounters("VtgateSlowPlans")
+)
+
+const shardCountKey contextKey = 1
+
+// withShardCount returns a context that counts the shards
+// the queries executed with it are sent to.
+func withShardCount(ctx context.Context) (context.Context, *sync2.AtomicInt64) {
+	count := new(sync2.AtomicInt64)
+	return context.WithValue(ctx, shardCountKey, count), count
+}
+
+// addShardCount adds n to the shard count of ctx, if it has one.
+func addShardCount(ctx context.Context, n int) {
+	if count, ok := ctx.Value(shardCountKey).(*sync2.AtomicInt64); ok {
+		count.Add(int64(n))
+	}
+}
+
+// slowPlan is an entry of the slow plan log. The queries are
+// recorded by their plan cache key, which is their normalized
+// sql if the router normalizes queries.
+type slowPlan struct {
+	Query         string
+	Plan          planbuilder.PlanID
+	Count         int64
+	MaxTime       time.Duration
+	MaxShardCount int64
+	LastTime      time.Duration
+	LastShards    int64
+	LastSeen      time.Time
+}
+
+// Size is part of the cache.Value interface.
+func (sp *slowPlan) Size() int {
+	return 1
+}
+
+// slowPlanLog records the queries that exceed the latency or
+// shard fan-out thresholds, and serves them on /debug/slow_plans.
+type slowPlanLog struct {
+	slowTime   time.Duration
+	slowShards int64
+
+	// mu protects the entries of plans.
+	mu    sync.Mutex
+	plans *cache.LRUCache
+}
+
+func newSlowPlanLog(slowTime time.Duration, slowShards, capacity int) *slowPlanLog {
+	return &slowPlanLog{
+		slowTime:   slowTime,
+		slowShards: int64(slowShards),
+		plans:      cache.NewLRUCache(int64(capacity)),
+	}
+}
+
+// record adds the execution of sql with plan to the log
+// if it exceeded one of the thresholds.
+func (spl *slowPlanLog) record(sql string, plan *planbuilder.Plan, duration time.Duration, shardCount int64) {
+	slow := spl.slowTime != 0 && duration >= spl.slowTime
+	fanout := spl.slowShards != 0 && shardCount >= spl.slowShards
+	if !slow && !fanout {
+		return
+	}
+	if slow {
+		slowPlanCounts.Add("Time", 1)
+	}
+	if fanout {
+		slowPlanCounts.Add("Shards", 1)
+	}
+	spl.mu.Lock()
+	defer spl.mu.Unlock()
+	var sp *slowPlan
+	if v, ok := spl.plans.Get(sql); ok {
+		sp = v.(*slowPlan)
+	} else {
+		sp = &slowPlan{Query: sql}
+		spl.plans.Set(sql, sp)
+	}
+	sp.Plan = plan.ID
+	sp.Count++
+	if duration > sp.MaxTime {
+		sp.MaxTime = duration
+	}
+	if shardCount > sp.MaxShardCount {
+		sp.MaxShardCount = shardCount
+	}
+	sp.LastTime = duration
+	sp.LastShards = shardCount
+	sp.LastSeen = time.Now()
+}
+
+// entries returns a copy of the entries of the log,
+// the most recently recorded first.
+func (spl *slowPlanLog) entries() []slowPlan {
+	spl.mu.Lock()
+	defer spl.mu.Unlock()
+	items := spl.plans.Items()
+	entries := make([]slowPlan, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, *item.Value.(*slowPlan))
+	}
+	return entries
+}
+
+func (spl *slowPlanLog) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	if err := acl.CheckAccessHTTP(request, acl.DEBUGGING); err != nil {
+		acl.SendError(response, err)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if b, err := json.MarshalIndent(spl.entries(), "", "  "); err != nil {
+		response.Write([]byte(err.Error()))
+	} else {
+		response.Write(b)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// This file uses the sandbox_test framework.
+
+func TestRouterPlanStats(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	for _, shard := range shards {
+		s.MapTestConn(shard, &sandboxConn{})
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	router.slowPlans = newSlowPlanLog(0, 2, 10)
+
+	equal := "select * from user where id = 1"
+	scatter := "select * from user"
+	for _, sql := range []string{equal, scatter, scatter} {
+		if _, err := router.Execute(context.Background(), &proto.Query{
+			Sql:        sql,
+			TabletType: topo.TYPE_MASTER,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = router.StreamExecute(context.Background(), &proto.Query{
+		Sql:        equal,
+		TabletType: topo.TYPE_MASTER,
+	}, func(*mproto.QueryResult) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tcase := range []struct {
+		sql                    string
+		queryCount, shardCount int64
+	}{
+		{equal, 2, 2},
+		{scatter, 2, 16},
+	} {
+		result, ok := router.planner.plans.Get(tcase.sql)
+		if !ok {
+			t.Fatalf("plan of %q not cached", tcase.sql)
+		}
+		queryCount, _, _, shardCount, _ := result.(*cachedPlan).stats()
+		if queryCount != tcase.queryCount || shardCount != tcase.shardCount {
+			t.Errorf("stats of %q: %d queries, %d shards, want %d, %d", tcase.sql, queryCount, shardCount, tcase.queryCount, tcase.shardCount)
+		}
+	}
+
+	// Only the scatter query exceeds the fan-out threshold.
+	entries := router.slowPlans.entries()
+	if len(entries) != 1 {
+		t.Fatalf("slow plans: %+v, want 1", entries)
+	}
+	if got := entries[0]; got.Query != scatter || got.Plan != planbuilder.SelectScatter || got.Count != 2 || got.MaxShardCount != 8 {
+		t.Errorf("slow plan: %+v, want 2 scatters to 8 shards", got)
+	}
+
+	response := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/debug/slow_plans", nil)
+	router.slowPlans.ServeHTTP(response, request)
+	var served []struct {
+		Query string
+		Count int64
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &served); err != nil {
+		t.Fatalf("%v: %s", err, response.Body.String())
+	}
+	if len(served) != 1 || served[0].Query != scatter || served[0].Count != 2 {
+		t.Errorf("slow_plans: %s, want the scatter query", response.Body.String())
+	}
+}
+
+func TestSlowPlanLogTime(t *testing.T) {
+	spl := newSlowPlanLog(10*time.Millisecond, 0, 1)
+	plan := &planbuilder.Plan{ID: planbuilder.SelectEqual}
+	spl.record("fast", plan, 5*time.Millisecond, 100)
+	spl.record("slow1", plan, 20*time.Millisecond, 1)
+	spl.record("slow2", plan, 10*time.Millisecond, 1)
+	entries := spl.entries()
+	// The log holds a single entry, the most recent one.
+	if len(entries) != 1 || entries[0].Query != "slow2" || entries[0].MaxTime != 10*time.Millisecond {
+		t.Errorf("slow plans: %+v, want slow2", entries)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package planbuilder
+
+import "github.com/youtube/vitess/go/vt/sqlparser"
+
+// resolveAliases replaces the table aliases of the schema that
+// statement refers to with the names of their tables, which is
+// what the databases know them as. The alias is kept as the
+// alias of the table in the from clause of a select, so that
+// the columns qualified with it are still valid. The qualified
+// columns of the other statements must use the table name.
+// It returns true if statement was changed.
+func resolveAliases(statement sqlparser.Statement, schema *Schema) bool {
+	if schema == nil {
+		return false
+	}
+	ar := &aliasResolver{schema: schema}
+	switch statement := statement.(type) {
+	case sqlparser.SelectStatement:
+		ar.selectStatement(statement)
+	case *sqlparser.Insert:
+		ar.tableName(statement.Table)
+		if rows, ok := statement.Rows.(sqlparser.SelectStatement); ok {
+			ar.selectStatement(rows)
+		}
+	case *sqlparser.Update:
+		ar.tableName(statement.Table)
+		for _, expr := range statement.Exprs {
+			ar.expr(expr.Expr)
+		}
+		ar.where(statement.Where)
+	case *sqlparser.Delete:
+		ar.tableName(statement.Table)
+		ar.where(statement.Where)
+	}
+	return ar.changed
+}
+
+type aliasResolver struct {
+	schema  *Schema
+	changed bool
+}
+
+// resolve returns the name of the table of alias
+// if it's an alias, or "" otherwise.
+func (ar *aliasResolver) resolve(alias []byte) string {
+	table, _ := ar.schema.FindTable(string(alias))
+	if table == nil || table.Name == string(alias) {
+		return ""
+	}
+	ar.changed = true
+	return table.Name
+}
+
+func (ar *aliasResolver) tableName(node *sqlparser.TableName) {
+	if node == nil || node.Qualifier != nil {
+		return
+	}
+	if name := ar.resolve(node.Name); name != "" {
+		node.Name = []byte(name)
+	}
+}
+
+func (ar *aliasResolver) selectStatement(node sqlparser.SelectStatement) {
+	switch node := node.(type) {
+	case *sqlparser.Select:
+		for _, expr := range node.SelectExprs {
+			if expr, ok := expr.(*sqlparser.NonStarExpr); ok {
+				ar.expr(expr.Expr)
+			}
+		}
+		for _, expr := range node.From {
+			ar.tableExpr(expr)
+		}
+		ar.where(node.Where)
+		ar.where(node.Having)
+	case *sqlparser.Union:
+		ar.selectStatement(node.Left)
+		ar.selectStatement(node.Right)
+	}
+}
+
+func (ar *aliasResolver) tableExpr(node sqlparser.TableExpr) {
+	switch node := node.(type) {
+	case *sqlparser.AliasedTableExpr:
+		switch expr := node.Expr.(type) {
+		case *sqlparser.TableName:
+			if expr.Qualifier != nil {
+				return
+			}
+			if name := ar.resolve(expr.Name); name != "" {
+				if node.As == nil {
+					node.As = expr.Name
+				}
+				expr.Name = []byte(name)
+			}
+		case *sqlparser.Subquery:
+			ar.selectStatement(expr.Select)
+		}
+	case *sqlparser.ParenTableExpr:
+		ar.tableExpr(node.Expr)
+	case *sqlparser.JoinTableExpr:
+		ar.tableExpr(node.LeftExpr)
+		ar.tableExpr(node.RightExpr)
+		ar.expr(node.On)
+	}
+}
+
+func (ar *aliasResolver) where(node *sqlparser.Where) {
+	if node != nil {
+		ar.expr(node.Expr)
+	}
+}
+
+// expr resolves the aliases of the subqueries of node.
+func (ar *aliasResolver) expr(node sqlparser.Expr) {
+	switch node := node.(type) {
+	case *sqlparser.AndExpr:
+		ar.expr(node.Left)
+		ar.expr(node.Right)
+	case *sqlparser.OrExpr:
+		ar.expr(node.Left)
+		ar.expr(node.Right)
+	case *sqlparser.NotExpr:
+		ar.expr(node.Expr)
+	case *sqlparser.ParenBoolExpr:
+		ar.expr(node.Expr)
+	case *sqlparser.ComparisonExpr:
+		ar.expr(node.Left)
+		ar.expr(node.Right)
+	case *sqlparser.RangeCond:
+		ar.expr(node.Left)
+		ar.expr(node.From)
+		ar.expr(node.To)
+	case *sqlparser.NullCheck:
+		ar.expr(node.Expr)
+	case *sqlparser.ExistsExpr:
+		ar.selectStatement(node.Subquery.Select)
+	case *sqlparser.Subquery:
+		ar.selectStatement(node.Select)
+	case sqlparser.ValTuple:
+		for _, expr := range node {
+			ar.expr(expr)
+		}
+	case *sqlparser.BinaryExpr:
+		ar.expr(node.Left)
+		ar.expr(node.Right)
+	case *sqlparser.UnaryExpr:
+		ar.expr(node.Expr)
+	case *sqlparser.FuncExpr:
+		for _, expr := range node.Exprs {
+			if expr, ok := expr.(*sqlparser.NonStarExpr); ok {
+				ar.expr(expr.Expr)
+			}
+		}
+	case *sqlparser.CaseExpr:
+		ar.expr(node.Expr)
+		ar.expr(node.Else)
+		for _, when := range node.Whens {
+			ar.expr(when.Cond)
+			ar.expr(when.Val)
+		}
+	}
+}
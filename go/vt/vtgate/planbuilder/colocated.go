@@ -0,0 +1,129 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package planbuilder
+
+import (
+	"fmt"
+
+	"github.com/youtube/vitess/go/vt/sqlparser"
+)
+
+// buildColocatedPlan builds a single route for a join between a
+// table and its Parent, if they are joined on the columns of their
+// primary vindex. The rows that such a join matches are on the same
+// shard, so the query can be sent as is. The route is chosen with
+// the vindexes of either table, from the conditions that only
+// reference that table. It returns nil if the join is not co-located.
+func buildColocatedPlan(sel *sqlparser.Select, jb *joinBuilder, conditions []sqlparser.BoolExpr) *Plan {
+	parent, child := jb.left, jb.right
+	if !isParent(parent.table, child.table) {
+		parent, child = child, parent
+		if !isParent(parent.table, child.table) {
+			return nil
+		}
+	}
+	var leftConds, rightConds []sqlparser.BoolExpr
+	joined := false
+	for _, cond := range conditions {
+		side, err := jb.findSides(cond)
+		if err != nil {
+			return &Plan{ID: NoPlan, Reason: err.Error()}
+		}
+		switch side {
+		case sideNone:
+			leftConds = append(leftConds, cond)
+			rightConds = append(rightConds, cond)
+		case sideLeft:
+			leftConds = append(leftConds, cond)
+		case sideRight:
+			rightConds = append(rightConds, cond)
+		default:
+			if isPrimaryJoin(cond, parent, child) {
+				joined = true
+			}
+		}
+	}
+	if !joined {
+		return nil
+	}
+	parentConds, childConds := leftConds, rightConds
+	if parent != jb.left {
+		parentConds, childConds = rightConds, leftConds
+	}
+	plan := &Plan{ID: NoPlan, Table: parent.table}
+	getWhereRouting(sqlparser.NewWhere(sqlparser.AST_WHERE, joinAnd(parentConds)), plan, false)
+	if plan.ID == SelectScatter {
+		childPlan := &Plan{ID: NoPlan, Table: child.table}
+		getWhereRouting(sqlparser.NewWhere(sqlparser.AST_WHERE, joinAnd(childConds)), childPlan, false)
+		if childPlan.ID != SelectScatter {
+			plan = childPlan
+		}
+	}
+	if plan.ID == NoPlan {
+		return plan
+	}
+	if plan.IsMulti() && hasPostProcessing(sel) {
+		plan.Reason = buildMerge(sel, plan)
+		if plan.Reason != "" {
+			plan.ID = NoPlan
+			return plan
+		}
+	}
+	// The where clause might have changed.
+	plan.Rewritten = generateQuery(sel)
+	return plan
+}
+
+// isParent returns true if parent is the Parent of child.
+func isParent(parent, child *Table) bool {
+	return child.Parent != "" && child.Parent == parent.Name && child.Keyspace.Name == parent.Keyspace.Name
+}
+
+// isPrimaryJoin returns true if cond is an equality between
+// the primary vindex columns of parent and child.
+func isPrimaryJoin(cond sqlparser.BoolExpr, parent, child *joinTable) bool {
+	comparison, ok := cond.(*sqlparser.ComparisonExpr)
+	if !ok || comparison.Operator != sqlparser.AST_EQ {
+		return false
+	}
+	left, ok := comparison.Left.(*sqlparser.ColName)
+	if !ok {
+		return false
+	}
+	right, ok := comparison.Right.(*sqlparser.ColName)
+	if !ok {
+		return false
+	}
+	if string(left.Qualifier) != parent.alias {
+		left, right = right, left
+	}
+	return isPrimaryCol(left, parent) && isPrimaryCol(right, child)
+}
+
+// isPrimaryCol returns true if col is the primary vindex
+// column of jt.
+func isPrimaryCol(col *sqlparser.ColName, jt *joinTable) bool {
+	return string(col.Qualifier) == jt.alias && string(col.Name) == jt.table.ColVindexes[0].Col
+}
+
+// checkParent returns an error if the table tname of a sharded
+// keyspace can't have the table parent as its Parent. The child
+// must have the primary vindex of its parent as its own primary
+// vindex, so that their rows with the same value are on the same
+// shard.
+func checkParent(tname, parent string, tables map[string]*Table) error {
+	if parent == tname {
+		return fmt.Errorf("table %s cannot be its own parent", tname)
+	}
+	p, ok := tables[parent]
+	if !ok {
+		return fmt.Errorf("parent %s not found for table %s", parent, tname)
+	}
+	child := tables[tname]
+	if len(p.ColVindexes) == 0 || len(child.ColVindexes) == 0 || child.ColVindexes[0].Name != p.ColVindexes[0].Name {
+		return fmt.Errorf("table %s must have the primary vindex of its parent %s", tname, parent)
+	}
+	return nil
+}
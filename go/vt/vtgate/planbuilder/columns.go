@@ -0,0 +1,70 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package planbuilder
+
+// Column is a column of a table, with its MySQL type.
+type Column struct {
+	Name string
+	Type string
+}
+
+// HasColumns returns true if the columns of t are known.
+func (t *Table) HasColumns() bool {
+	return t.Columns != nil
+}
+
+// FindColumn returns true if t has a column named name.
+// It must only be called if the columns of t are known.
+func (t *Table) FindColumn(name string) bool {
+	for _, col := range t.Columns {
+		if col.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WithColumns returns a copy of schema whose tables have the
+// columns of the same name in columns. The other tables keep
+// their columns, like the ambiguous ones.
+func (schema *Schema) WithColumns(columns map[string][]Column) *Schema {
+	return &Schema{
+		Tables:    tablesWithColumns(schema.Tables, columns),
+		Ambiguous: schema.Ambiguous,
+		keyspace:  schema.keyspace,
+	}
+}
+
+// WithColumns returns a copy of ks whose tables have the
+// columns of the same name in columns. The other tables keep
+// their columns.
+func (ks *KeyspaceSchema) WithColumns(columns map[string][]Column) *KeyspaceSchema {
+	return &KeyspaceSchema{
+		Keyspace: ks.Keyspace,
+		Tables:   tablesWithColumns(ks.Tables, columns),
+	}
+}
+
+// tablesWithColumns copies tables, and sets the columns of the
+// copies. A table is copied once, so the aliases of a table still
+// share its Table. A table is looked up in columns by its real name.
+func tablesWithColumns(tables map[string]*Table, columns map[string][]Column) map[string]*Table {
+	copies := make(map[*Table]*Table)
+	result := make(map[string]*Table, len(tables))
+	for tname, table := range tables {
+		cp, ok := copies[table]
+		if !ok {
+			cp = table
+			if cols, ok := columns[table.Name]; ok {
+				t := *table
+				t.Columns = cols
+				cp = &t
+			}
+			copies[table] = cp
+		}
+		result[tname] = cp
+	}
+	return result
+}
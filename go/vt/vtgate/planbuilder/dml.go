@@ -7,6 +7,7 @@ package planbuilder
 import (
 	"bytes"
 	"fmt"
+	"strings"
 
 	"github.com/youtube/vitess/go/vt/sqlparser"
 )
@@ -21,6 +22,10 @@ func buildUpdatePlan(upd *sqlparser.Update, schema *Schema) *Plan {
 	if plan.Reason != "" {
 		return plan
 	}
+	if plan.Table.View {
+		plan.Reason = fmt.Sprintf("view %s is read-only", tablename)
+		return plan
+	}
 	if !plan.Table.Keyspace.Sharded {
 		plan.ID = UpdateUnsharded
 		return plan
@@ -30,20 +35,89 @@ func buildUpdatePlan(upd *sqlparser.Update, schema *Schema) *Plan {
 	switch plan.ID {
 	case SelectEqual:
 		plan.ID = UpdateEqual
-	case SelectIN, SelectScatter, SelectKeyrange:
-		plan.ID = NoPlan
-		plan.Reason = "too complex"
-		return plan
+	case SelectIN:
+		plan.ID = UpdateIn
+		plan.Rewritten = generateQuery(upd)
+	case SelectRange, SelectScatter, SelectKeyrange:
+		plan.ID = UpdateScatter
+		plan.ColVindex = nil
+		plan.Values = nil
 	default:
 		panic("unexpected")
 	}
+	if isIndexChanging(upd.Exprs, plan.Table.ColVindexes[:1]) {
+		buildMigratePlan(upd, plan)
+		return plan
+	}
 	if isIndexChanging(upd.Exprs, plan.Table.ColVindexes) {
-		plan.ID = NoPlan
-		plan.Reason = "index is changing"
+		buildVindexUpdatePlan(upd, plan)
 	}
 	return plan
 }
 
+// buildVindexUpdatePlan builds the plan of an update that changes
+// the columns of owned non-primary vindexes. Subquery selects the
+// old values of the columns, so that their vindex entries can be
+// replaced with the new values in Assignments. The columns are
+// selected in the order of the table's vindexes.
+func buildVindexUpdatePlan(upd *sqlparser.Update, plan *Plan) {
+	if plan.ID != UpdateEqual {
+		plan.ID = NoPlan
+		plan.Reason = "index is changing in a multi-shard update"
+		return
+	}
+	assignments := make(map[string]interface{})
+	var cols []string
+	for _, colVindex := range plan.Table.ColVindexes[1:] {
+		for _, assignment := range upd.Exprs {
+			if string(assignment.Name.Name) != colVindex.Col {
+				continue
+			}
+			if !colVindex.Owned {
+				plan.ID = NoPlan
+				plan.Reason = fmt.Sprintf("index %s is changing, and it's not owned by %s", colVindex.Name, plan.Table.Name)
+				return
+			}
+			val, err := asInterface(assignment.Expr)
+			if err != nil {
+				plan.ID = NoPlan
+				plan.Reason = fmt.Sprintf("index is changing, and %s is not a value", sqlparser.String(assignment.Expr))
+				return
+			}
+			assignments[colVindex.Col] = val
+			cols = append(cols, colVindex.Col)
+		}
+	}
+	plan.Assignments = assignments
+	plan.Subquery = fmt.Sprintf("select %s from %s%s for update", strings.Join(cols, ", "), plan.Table.Name, sqlparser.String(upd.Where))
+}
+
+// buildMigratePlan builds the plan of an update that changes
+// the primary vindex column, which moves the row to another
+// shard. The row is selected and deleted with Subquery and
+// Rewritten, and then inserted with the new values.
+func buildMigratePlan(upd *sqlparser.Update, plan *Plan) {
+	if plan.ID != UpdateEqual {
+		plan.ID = NoPlan
+		plan.Reason = "primary index is changing in a multi-shard update"
+		return
+	}
+	plan.Assignments = make(map[string]interface{}, len(upd.Exprs))
+	for _, assignment := range upd.Exprs {
+		val, err := asInterface(assignment.Expr)
+		if err != nil {
+			plan.ID = NoPlan
+			plan.Reason = fmt.Sprintf("primary index is changing, and %s is not a value", sqlparser.String(assignment.Expr))
+			plan.Assignments = nil
+			return
+		}
+		plan.Assignments[string(assignment.Name.Name)] = val
+	}
+	plan.ID = UpdateMigrate
+	plan.Subquery = fmt.Sprintf("select * from %s%s for update", plan.Table.Name, sqlparser.String(upd.Where))
+	plan.Rewritten = fmt.Sprintf("delete from %s%s", plan.Table.Name, sqlparser.String(upd.Where))
+}
+
 func isIndexChanging(setClauses sqlparser.UpdateExprs, colVindexes []*ColVindex) bool {
 	vindexCols := make([]string, len(colVindexes))
 	for i, index := range colVindexes {
@@ -67,6 +141,10 @@ func buildDeletePlan(del *sqlparser.Delete, schema *Schema) *Plan {
 	if plan.Reason != "" {
 		return plan
 	}
+	if plan.Table.View {
+		plan.Reason = fmt.Sprintf("view %s is read-only", tablename)
+		return plan
+	}
 	if !plan.Table.Keyspace.Sharded {
 		plan.ID = DeleteUnsharded
 		return plan
@@ -77,9 +155,21 @@ func buildDeletePlan(del *sqlparser.Delete, schema *Schema) *Plan {
 	case SelectEqual:
 		plan.ID = DeleteEqual
 		plan.Subquery = generateDeleteSubquery(del, plan.Table)
-	case SelectIN, SelectScatter, SelectKeyrange:
-		plan.ID = NoPlan
-		plan.Reason = "too complex"
+	case SelectIN:
+		plan.ID = DeleteIn
+		plan.Rewritten = generateQuery(del)
+		plan.Subquery = generateDeleteSubquery(del, plan.Table)
+	case SelectRange, SelectScatter, SelectKeyrange:
+		// The vindex entries of the deleted rows cannot
+		// be cleaned up if the delete is sent to all shards.
+		if len(plan.Table.Owned) != 0 {
+			plan.ID = NoPlan
+			plan.Reason = "scatter delete on table with owned vindexes"
+			return plan
+		}
+		plan.ID = DeleteScatter
+		plan.ColVindex = nil
+		plan.Values = nil
 	default:
 		panic("unexpected")
 	}
@@ -0,0 +1,141 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package planbuilder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The functions of this file let users check the plans of their
+// queries offline: WritePlans writes the plans of a list of queries
+// for a schema, and CheckPlans compares the plans of such a file,
+// usually committed as a golden file, with the current planner.
+//
+// The format is the one of the test cases of this package: each
+// query is on its own line as a JSON string, followed by its plan
+// in indented JSON. Empty lines and lines starting with # are
+// ignored.
+
+// MarshalPlan returns the JSON of the plan of a golden file. It
+// only depends on the query and the schema. The fields that are
+// left over by a failed planning are omitted.
+func MarshalPlan(plan *Plan) ([]byte, error) {
+	if plan.ID == NoPlan {
+		noplan := *plan
+		noplan.Rewritten = ""
+		noplan.ColVindex = nil
+		noplan.Values = nil
+		plan = &noplan
+	}
+	return json.Marshal(plan)
+}
+
+// WritePlans builds the plans of queries with schema, and
+// writes them to w.
+func WritePlans(w io.Writer, schema *Schema, queries []string) error {
+	for _, query := range queries {
+		bquery, err := json.Marshal(query)
+		if err != nil {
+			return err
+		}
+		bplan, err := MarshalPlan(BuildPlan(query, schema))
+		if err != nil {
+			return fmt.Errorf("cannot marshal the plan of %q: %v", query, err)
+		}
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, bplan, "", "  "); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s\n%s\n\n", bquery, indented.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PlanCase is a query of a golden file, with the
+// compact JSON of its expected plan.
+type PlanCase struct {
+	// Line is the line of the query in the file.
+	Line  int
+	Query string
+	Plan  string
+}
+
+// ReadPlans reads the queries and plans written by WritePlans.
+func ReadPlans(r io.Reader) ([]PlanCase, error) {
+	var cases []PlanCase
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || line[0] == '#' {
+			continue
+		}
+		pc := PlanCase{Line: lineno}
+		if err := json.Unmarshal([]byte(line), &pc.Query); err != nil {
+			return nil, fmt.Errorf("line %d: invalid query: %v", lineno, err)
+		}
+		var plan bytes.Buffer
+		for {
+			if !scanner.Scan() {
+				return nil, fmt.Errorf("line %d: missing plan of %q", pc.Line, pc.Query)
+			}
+			lineno++
+			line := scanner.Text()
+			plan.WriteString(line)
+			if line == "}" {
+				break
+			}
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, plan.Bytes()); err != nil {
+			return nil, fmt.Errorf("line %d: invalid plan of %q: %v", pc.Line, pc.Query, err)
+		}
+		pc.Plan = compact.String()
+		cases = append(cases, pc)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cases, nil
+}
+
+// PlanMismatch is a query of a golden file whose plan changed.
+type PlanMismatch struct {
+	PlanCase
+	// Got is the JSON of the current plan.
+	Got string
+}
+
+func (pm *PlanMismatch) String() string {
+	return fmt.Sprintf("line %d: plan of %q changed:\nwant: %s\n got: %s", pm.Line, pm.Query, pm.Plan, pm.Got)
+}
+
+// CheckPlans builds the plans of the queries read from r with
+// schema, and returns the ones that differ from their plans in r.
+func CheckPlans(r io.Reader, schema *Schema) ([]PlanMismatch, error) {
+	cases, err := ReadPlans(r)
+	if err != nil {
+		return nil, err
+	}
+	var mismatches []PlanMismatch
+	for _, pc := range cases {
+		got, err := MarshalPlan(BuildPlan(pc.Query, schema))
+		if err != nil {
+			return nil, fmt.Errorf("cannot marshal the plan of %q: %v", pc.Query, err)
+		}
+		if string(got) != pc.Plan {
+			mismatches = append(mismatches, PlanMismatch{PlanCase: pc, Got: string(got)})
+		}
+	}
+	return mismatches, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package planbuilder
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheckPlans(t *testing.T) {
+	schema, err := LoadSchemaJSON(locateFile("schema_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"select_cases.txt", "dml_cases.txt", "insert_cases.txt", "join_cases.txt", "union_cases.txt", "subquery_cases.txt", "hint_cases.txt", "alias_cases.txt", "sequence_cases.txt", "parent_cases.txt"} {
+		f, err := os.Open(locateFile(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mismatches, err := CheckPlans(f, schema)
+		f.Close()
+		if err != nil {
+			t.Fatalf("CheckPlans(%s): %v", name, err)
+		}
+		for _, m := range mismatches {
+			t.Errorf("CheckPlans(%s): %s", name, &m)
+		}
+	}
+}
+
+func TestWritePlans(t *testing.T) {
+	schema, err := LoadSchemaJSON(locateFile("schema_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := []string{
+		"select * from user where id = 1",
+		"update user set a = 1 where name = 'a'",
+		"select * from no_such_table",
+	}
+	var buf bytes.Buffer
+	if err := WritePlans(&buf, schema, queries); err != nil {
+		t.Fatal(err)
+	}
+	golden := buf.String()
+
+	cases, err := ReadPlans(strings.NewReader(golden))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) != len(queries) {
+		t.Fatalf("ReadPlans: %d cases, want %d", len(cases), len(queries))
+	}
+	for i, pc := range cases {
+		if pc.Query != queries[i] {
+			t.Errorf("ReadPlans query %d: %q, want %q", i, pc.Query, queries[i])
+		}
+	}
+	if want := `{"ID":"NoPlan","Reason":"table no_such_table not found","Table":"","Original":"select * from no_such_table","Rewritten":"","Subquery":"","Vindex":"","Col":"","Values":null}`; cases[2].Plan != want {
+		t.Errorf("ReadPlans plan: %s, want %s", cases[2].Plan, want)
+	}
+
+	// The plans are the same when built again.
+	var again bytes.Buffer
+	if err := WritePlans(&again, schema, queries); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != golden {
+		t.Errorf("WritePlans is not deterministic:\n%s\n%s", golden, again.String())
+	}
+	mismatches, err := CheckPlans(strings.NewReader(golden), schema)
+	if err != nil || len(mismatches) != 0 {
+		t.Errorf("CheckPlans: %v, %v, want no mismatches", mismatches, err)
+	}
+
+	// A plan that changed is reported.
+	changed := strings.Replace(golden, `"Vindex": "user_index"`, `"Vindex": "name_user_map"`, 1)
+	mismatches, err = CheckPlans(strings.NewReader(changed), schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].Line != 1 || mismatches[0].Query != queries[0] {
+		t.Errorf("CheckPlans: %+v, want a mismatch of line 1", mismatches)
+	}
+
+	if _, err := ReadPlans(strings.NewReader("\"select 1\"\n{\n")); err == nil || !strings.Contains(err.Error(), "missing plan") {
+		t.Errorf("ReadPlans of a truncated file: %v, want missing plan", err)
+	}
+}
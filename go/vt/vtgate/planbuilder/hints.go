@@ -0,0 +1,158 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package planbuilder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+// hintPrefix starts the comments that contain vtgate hints.
+const hintPrefix = "/*vt+"
+
+// Hints override the routing chosen by the planner for a statement.
+// They're specified in a comment that follows the first keyword of
+// the statement, as comma or space separated NAME=value pairs:
+//
+//	select /*vt+ SCATTER=true, TABLET_TYPE=replica */ * from user
+type Hints struct {
+	// Scatter sends a select to all the shards of its keyspace.
+	Scatter bool `json:",omitempty"`
+	// KeyRange sends a select to the shards that overlap it.
+	KeyRange *key.KeyRange `json:",omitempty"`
+	// TabletType is the type of the tablets that execute
+	// the statement, if it's not the requested one.
+	TabletType topo.TabletType `json:",omitempty"`
+	// SkipCache prevents the plan from being cached.
+	SkipCache bool `json:",omitempty"`
+	// MaxStaleness is the maximum replication lag of the
+	// replica and rdonly tablets the statement is sent to.
+	MaxStaleness time.Duration `json:",omitempty"`
+}
+
+// hintTabletTypes are the tablet types a hint can select.
+var hintTabletTypes = []topo.TabletType{topo.TYPE_MASTER, topo.TYPE_REPLICA, topo.TYPE_RDONLY}
+
+// parseHints returns the hints of comments, or nil if there are none.
+func parseHints(comments sqlparser.Comments) (*Hints, error) {
+	var hints *Hints
+	for _, comment := range comments {
+		text := string(comment)
+		if !strings.HasPrefix(text, hintPrefix) {
+			continue
+		}
+		if hints == nil {
+			hints = &Hints{}
+		}
+		text = strings.TrimSuffix(strings.TrimPrefix(text, hintPrefix), "*/")
+		fields := strings.FieldsFunc(text, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\n'
+		})
+		for _, field := range fields {
+			if err := hints.set(field); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if hints != nil && hints.Scatter && hints.KeyRange != nil {
+		return nil, fmt.Errorf("hints SCATTER and KEYRANGE cannot be combined")
+	}
+	return hints, nil
+}
+
+// set sets the hint specified by field, which is NAME=value.
+func (hints *Hints) set(field string) error {
+	parts := strings.SplitN(field, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid hint %s: want NAME=value", field)
+	}
+	name, value := strings.ToUpper(parts[0]), parts[1]
+	var err error
+	switch name {
+	case "SCATTER":
+		hints.Scatter, err = strconv.ParseBool(value)
+	case "SKIP_CACHE":
+		hints.SkipCache, err = strconv.ParseBool(value)
+	case "KEYRANGE":
+		var krs []key.KeyRange
+		krs, err = key.ParseShardingSpec(value)
+		if err == nil && len(krs) != 1 {
+			err = fmt.Errorf("want a single keyrange")
+		}
+		if err == nil {
+			hints.KeyRange = &krs[0]
+		}
+	case "MAX_STALENESS":
+		hints.MaxStaleness, err = time.ParseDuration(value)
+		if err == nil && hints.MaxStaleness <= 0 {
+			err = fmt.Errorf("want a positive duration")
+		}
+	case "TABLET_TYPE":
+		hints.TabletType = topo.TabletType(strings.ToLower(value))
+		if !topo.IsTypeInList(hints.TabletType, hintTabletTypes) {
+			err = fmt.Errorf("want one of %v", hintTabletTypes)
+		}
+	default:
+		return fmt.Errorf("unknown hint %s", name)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid hint %s: %v", field, err)
+	}
+	return nil
+}
+
+// statementComments returns the comments that follow
+// the first keyword of statement.
+func statementComments(statement sqlparser.Statement) sqlparser.Comments {
+	switch statement := statement.(type) {
+	case *sqlparser.Select:
+		return statement.Comments
+	case *sqlparser.Union:
+		return statementComments(statement.Left)
+	case *sqlparser.Insert:
+		return statement.Comments
+	case *sqlparser.Update:
+		return statement.Comments
+	case *sqlparser.Delete:
+		return statement.Comments
+	}
+	return nil
+}
+
+// routes returns true if hints override the routing of a select.
+func (hints *Hints) routes() bool {
+	return hints != nil && (hints.Scatter || hints.KeyRange != nil)
+}
+
+// getHintedRouting is the getWhereRouting of a select whose
+// routing is specified by hints. The where clause is only
+// checked for a keyrange, which conflicts with the hints.
+func getHintedRouting(where *sqlparser.Where, plan *Plan, hints *Hints) {
+	if where != nil {
+		values, err := getKeyrangeMatch(where)
+		if err != nil {
+			plan.ID = NoPlan
+			plan.Reason = err.Error()
+			return
+		}
+		if values != nil {
+			plan.ID = NoPlan
+			plan.Reason = "routing hints cannot be combined with a keyrange"
+			return
+		}
+	}
+	if hints.Scatter {
+		plan.ID = SelectScatter
+		return
+	}
+	plan.ID = SelectKeyrange
+	plan.Values = []interface{}{[]byte(hints.KeyRange.Start), []byte(hints.KeyRange.End)}
+}
@@ -6,6 +6,7 @@ package planbuilder
 
 import (
 	"fmt"
+	"unicode"
 
 	"github.com/youtube/vitess/go/vt/sqlparser"
 )
@@ -20,20 +21,37 @@ func buildInsertPlan(ins *sqlparser.Insert, schema *Schema) *Plan {
 	if plan.Reason != "" {
 		return plan
 	}
+	if plan.Table.View {
+		plan.Reason = fmt.Sprintf("view %s is read-only", tablename)
+		return plan
+	}
 	if !plan.Table.Keyspace.Sharded {
 		plan.ID = InsertUnsharded
 		return plan
 	}
 
 	if len(ins.Columns) == 0 {
-		plan.Reason = "no column list"
-		return plan
+		if !plan.Table.HasColumns() {
+			plan.Reason = "no column list"
+			return plan
+		}
+		// The values are in the order of the columns of the table.
+		for _, col := range plan.Table.Columns {
+			ins.Columns = append(ins.Columns, &sqlparser.NonStarExpr{Expr: &sqlparser.ColName{Name: []byte(col.Name)}})
+		}
+	} else if plan.Table.HasColumns() {
+		for _, column := range ins.Columns {
+			name := sqlparser.GetColName(column.(*sqlparser.NonStarExpr).Expr)
+			if !plan.Table.FindColumn(name) {
+				plan.Reason = fmt.Sprintf("column %s not found in table %s", name, tablename)
+				return plan
+			}
+		}
 	}
 	var values sqlparser.Values
 	switch rows := ins.Rows.(type) {
 	case *sqlparser.Select, *sqlparser.Union:
-		plan.Reason = "subqueries not allowed"
-		return plan
+		return buildInsertSelectPlan(ins, rows.(sqlparser.SelectStatement), schema, plan)
 	case sqlparser.Values:
 		values = rows
 	default:
@@ -53,7 +71,13 @@ func buildInsertPlan(ins *sqlparser.Insert, schema *Schema) *Plan {
 		plan.Reason = "column list doesn't match values"
 		return plan
 	}
-	colVindexes := schema.Tables[tablename].ColVindexes
+	if plan.Table.AutoIncrement != nil {
+		if err := buildGeneratePlan(ins, plan.Table.AutoIncrement, plan); err != nil {
+			plan.Reason = err.Error()
+			return plan
+		}
+	}
+	colVindexes := plan.Table.ColVindexes
 	plan.ID = InsertSharded
 	plan.Values = make([]interface{}, 0, len(colVindexes))
 	for _, index := range colVindexes {
@@ -89,3 +113,115 @@ func buildIndexPlan(ins *sqlparser.Insert, tablename string, colVindex *ColVinde
 	row[pos] = sqlparser.ValArg([]byte(fmt.Sprintf(":_%s", colVindex.Col)))
 	return nil
 }
+
+// buildGeneratePlan sets up the generation of the auto-increment
+// column of ins. The column is added to ins if it's missing, and
+// its value is replaced with the bind var SeqVarName, which is set
+// to the supplied value or to the next value of the sequence. The
+// column can also be a vindex column, whose value is then resolved
+// from SeqVarName.
+func buildGeneratePlan(ins *sqlparser.Insert, autoinc *AutoIncrement, plan *Plan) error {
+	pos := -1
+	for i, column := range ins.Columns {
+		if autoinc.Column == sqlparser.GetColName(column.(*sqlparser.NonStarExpr).Expr) {
+			pos = i
+			break
+		}
+	}
+	if pos == -1 {
+		pos = len(ins.Columns)
+		ins.Columns = append(ins.Columns, &sqlparser.NonStarExpr{Expr: &sqlparser.ColName{Name: []byte(autoinc.Column)}})
+		ins.Rows.(sqlparser.Values)[0] = append(ins.Rows.(sqlparser.Values)[0].(sqlparser.ValTuple), &sqlparser.NullVal{})
+	}
+	row := ins.Rows.(sqlparser.Values)[0].(sqlparser.ValTuple)
+	val, err := asInterface(row[pos])
+	if err != nil {
+		return fmt.Errorf("auto-increment value %s is not a value", sqlparser.String(row[pos]))
+	}
+	plan.Generate = &Generate{
+		Sequence: autoinc.Sequence,
+		Value:    val,
+	}
+	row[pos] = sqlparser.ValArg([]byte(":" + SeqVarName))
+	return nil
+}
+
+// buildInsertSelectPlan builds an InsertSelect plan. Subquery
+// selects the rows, and Right inserts each of them. The value of
+// the i-th column of a row is supplied to Right as the bind var _i.
+func buildInsertSelectPlan(ins *sqlparser.Insert, sel sqlparser.SelectStatement, schema *Schema, plan *Plan) *Plan {
+	if sel, ok := sel.(*sqlparser.Select); ok && !hasStar(sel) && len(sel.SelectExprs) != len(ins.Columns) {
+		plan.Reason = "column list doesn't match values"
+		return plan
+	}
+	right := buildRowInsertPlan(&sqlparser.Insert{
+		Comments: ins.Comments,
+		Table:    ins.Table,
+		Columns:  append(sqlparser.Columns(nil), ins.Columns...),
+		OnDup:    ins.OnDup,
+	}, schema)
+	if right.ID != InsertSharded {
+		plan.Reason = right.Reason
+		return plan
+	}
+	plan.ID = InsertSelect
+	plan.Subquery = generateQuery(sel)
+	plan.Right = right
+	return plan
+}
+
+// BuildBulkInsertPlan builds the plan that inserts one row into
+// table, given the values of columns. The value of the i-th column
+// is supplied as the bind var _i. The plan is built without parsing
+// any SQL, and it can be reused for any number of rows.
+func BuildBulkInsertPlan(table string, columns []string, schema *Schema) *Plan {
+	if len(columns) == 0 {
+		return &Plan{ID: NoPlan, Reason: "no column list"}
+	}
+	ins := &sqlparser.Insert{
+		Table:   &sqlparser.TableName{Name: []byte(table)},
+		Columns: make(sqlparser.Columns, 0, len(columns)),
+	}
+	for _, col := range columns {
+		if !isIdentifier(col) {
+			return &Plan{ID: NoPlan, Reason: fmt.Sprintf("invalid column name %q", col)}
+		}
+		ins.Columns = append(ins.Columns, &sqlparser.NonStarExpr{Expr: &sqlparser.ColName{Name: []byte(col)}})
+	}
+	resolveAliases(ins, schema)
+	return buildRowInsertPlan(ins, schema)
+}
+
+// buildRowInsertPlan builds the plan of an insert of a single row,
+// whose column values are the bind vars _0, _1, etc.
+func buildRowInsertPlan(ins *sqlparser.Insert, schema *Schema) *Plan {
+	row := make(sqlparser.ValTuple, len(ins.Columns))
+	for i := range row {
+		row[i] = sqlparser.ValArg([]byte(fmt.Sprintf(":_%d", i)))
+	}
+	ins.Rows = sqlparser.Values{row}
+	return buildInsertPlan(ins, schema)
+}
+
+// isIdentifier returns true if name can be used
+// as a column name without quoting.
+func isIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c != '_' && c != '$' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasStar(sel *sqlparser.Select) bool {
+	for _, expr := range sel.SelectExprs {
+		if _, ok := expr.(*sqlparser.StarExpr); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,473 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package planbuilder
+
+import (
+	"fmt"
+
+	"github.com/youtube/vitess/go/vt/sqlparser"
+)
+
+// Sides of a join that an expression can reference.
+const (
+	sideNone  = 0
+	sideLeft  = 1
+	sideRight = 2
+)
+
+// joinTable represents one side of a two-table join.
+type joinTable struct {
+	expr  *sqlparser.AliasedTableExpr
+	alias string
+	table *Table
+}
+
+// buildJoinPlan builds a SelectJoin plan for a two-table inner join.
+// The query is split into a left and a right query, each of which is
+// planned independently. The right query is parameterized with the
+// values of the left result that it depends on. Queries that join
+// two unsharded tables of the same keyspace, or a table and its
+// Parent on their primary vindex, are sent as is.
+func buildJoinPlan(sel *sqlparser.Select, join *sqlparser.JoinTableExpr, schema *Schema) *Plan {
+	plan := &Plan{ID: NoPlan}
+	switch join.Join {
+	case sqlparser.AST_JOIN, sqlparser.AST_STRAIGHT_JOIN, sqlparser.AST_CROSS_JOIN:
+	default:
+		plan.Reason = fmt.Sprintf("unsupported join type: %s", join.Join)
+		return plan
+	}
+	var left, right *joinTable
+	left, plan.Reason = newJoinTable(join.LeftExpr, schema)
+	if plan.Reason != "" {
+		return plan
+	}
+	right, plan.Reason = newJoinTable(join.RightExpr, schema)
+	if plan.Reason != "" {
+		return plan
+	}
+	if left.alias == right.alias {
+		plan.Reason = fmt.Sprintf("duplicate table alias: %s", left.alias)
+		return plan
+	}
+	if !left.table.Keyspace.Sharded && !right.table.Keyspace.Sharded && left.table.Keyspace.Name == right.table.Keyspace.Name {
+		plan.ID = SelectUnsharded
+		plan.Table = left.table
+		return plan
+	}
+
+	var conditions []sqlparser.BoolExpr
+	conditions = splitAnd(join.On, conditions)
+	if sel.Where != nil {
+		conditions = splitAnd(sel.Where.Expr, conditions)
+	}
+	for _, cond := range conditions {
+		if hasSubquery(cond) {
+			plan.Reason = "has subquery"
+			return plan
+		}
+	}
+
+	builder := &joinBuilder{
+		left:     left,
+		right:    right,
+		joinVars: make(map[string]int),
+	}
+	if colocated := buildColocatedPlan(sel, builder, conditions); colocated != nil {
+		return colocated
+	}
+	if hasPostProcessing(sel) {
+		plan.Reason = "too complex"
+		return plan
+	}
+	for _, expr := range sel.SelectExprs {
+		if err := builder.addSelectExpr(expr); err != nil {
+			plan.Reason = err.Error()
+			return plan
+		}
+	}
+	for _, cond := range conditions {
+		if err := builder.addCondition(cond); err != nil {
+			plan.Reason = err.Error()
+			return plan
+		}
+	}
+
+	leftPlan := buildJoinSubplan(builder.leftSelect(sel), schema)
+	if leftPlan.ID == NoPlan {
+		plan.Reason = leftPlan.Reason
+		return plan
+	}
+	rightPlan := buildJoinSubplan(builder.rightSelect(sel), schema)
+	if rightPlan.ID == NoPlan {
+		plan.Reason = rightPlan.Reason
+		return plan
+	}
+	plan.ID = SelectJoin
+	plan.Table = left.table
+	plan.Left = leftPlan
+	plan.Right = rightPlan
+	plan.JoinVars = builder.joinVars
+	plan.Cols = builder.cols
+	return plan
+}
+
+func newJoinTable(node sqlparser.TableExpr, schema *Schema) (jt *joinTable, reason string) {
+	expr, ok := node.(*sqlparser.AliasedTableExpr)
+	if !ok {
+		return nil, "complex table expression"
+	}
+	tablename := sqlparser.GetTableName(expr.Expr)
+	table, reason := schema.FindTable(tablename)
+	if reason != "" {
+		return nil, reason
+	}
+	alias := tablename
+	if expr.As != nil {
+		alias = string(expr.As)
+	}
+	return &joinTable{
+		expr:  expr,
+		alias: alias,
+		table: table,
+	}, ""
+}
+
+// buildJoinSubplan plans one side of a join.
+func buildJoinSubplan(sel *sqlparser.Select, schema *Schema) *Plan {
+	original := generateQuery(sel)
+	plan := buildSelectPlan(sel, schema, nil)
+	plan.Original = original
+	return plan
+}
+
+// joinBuilder accumulates the parts of the left and right
+// queries of a join.
+type joinBuilder struct {
+	left, right *joinTable
+
+	leftExprs, rightExprs sqlparser.SelectExprs
+	leftConds, rightConds []sqlparser.BoolExpr
+
+	// cols and joinVars have the same meaning as
+	// Plan.Cols and Plan.JoinVars.
+	cols     []int
+	joinVars map[string]int
+}
+
+func (jb *joinBuilder) addSelectExpr(node sqlparser.SelectExpr) error {
+	expr, ok := node.(*sqlparser.NonStarExpr)
+	if !ok {
+		return jb.addStarExpr(node.(*sqlparser.StarExpr))
+	}
+	side, err := jb.findSides(expr.Expr)
+	if err != nil {
+		return err
+	}
+	switch side {
+	case sideNone, sideLeft:
+		jb.leftExprs = append(jb.leftExprs, expr)
+		jb.cols = append(jb.cols, -len(jb.leftExprs))
+	case sideRight:
+		jb.rightExprs = append(jb.rightExprs, expr)
+		jb.cols = append(jb.cols, len(jb.rightExprs))
+	default:
+		return fmt.Errorf("select expression references both tables of join: %s", sqlparser.String(expr))
+	}
+	return nil
+}
+
+// addStarExpr expands star into the columns of the tables it
+// refers to, qualified by their aliases. The columns of the
+// tables must be known.
+func (jb *joinBuilder) addStarExpr(star *sqlparser.StarExpr) error {
+	var tables []*joinTable
+	switch string(star.TableName) {
+	case "":
+		tables = []*joinTable{jb.left, jb.right}
+	case jb.left.alias:
+		tables = []*joinTable{jb.left}
+	case jb.right.alias:
+		tables = []*joinTable{jb.right}
+	default:
+		return fmt.Errorf("symbol %s not found", sqlparser.String(star))
+	}
+	for _, jt := range tables {
+		if !jt.table.HasColumns() {
+			return fmt.Errorf("* expressions not allowed in join: the columns of table %s are unknown", jt.table.Name)
+		}
+	}
+	for _, jt := range tables {
+		for _, col := range jt.table.Columns {
+			expr := &sqlparser.NonStarExpr{Expr: &sqlparser.ColName{
+				Qualifier: []byte(jt.alias),
+				Name:      []byte(col.Name),
+			}}
+			if err := jb.addSelectExpr(expr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (jb *joinBuilder) addCondition(cond sqlparser.BoolExpr) error {
+	side, err := jb.findSides(cond)
+	if err != nil {
+		return err
+	}
+	switch side {
+	case sideNone, sideLeft:
+		jb.leftConds = append(jb.leftConds, cond)
+		return nil
+	case sideRight:
+		jb.rightConds = append(jb.rightConds, cond)
+		return nil
+	}
+	// The condition references both sides. Replace the
+	// left columns with bind vars that get their values
+	// from the left result.
+	newcond, err := rewriteColNames(cond, func(col *sqlparser.ColName) (sqlparser.ValExpr, error) {
+		if string(col.Qualifier) != jb.left.alias {
+			return nil, nil
+		}
+		return sqlparser.ValArg(":" + jb.joinVar(col)), nil
+	})
+	if err != nil {
+		return err
+	}
+	// Keep the column on the left side of the comparison
+	// so that the right query can be routed by its value.
+	if comparison, ok := newcond.(*sqlparser.ComparisonExpr); ok && comparison.Operator == sqlparser.AST_EQ {
+		if _, ok := comparison.Left.(sqlparser.ValArg); ok {
+			comparison.Left, comparison.Right = comparison.Right, comparison.Left
+		}
+	}
+	jb.rightConds = append(jb.rightConds, newcond.(sqlparser.BoolExpr))
+	return nil
+}
+
+// joinVar returns the name of the bind var that will carry
+// the value of col from the left result. The column is added
+// to the left select list if it's not already there.
+func (jb *joinBuilder) joinVar(col *sqlparser.ColName) string {
+	name := fmt.Sprintf("_%s_%s", col.Qualifier, col.Name)
+	if _, ok := jb.joinVars[name]; ok {
+		return name
+	}
+	for i, expr := range jb.leftExprs {
+		if sqlparser.String(expr) == sqlparser.String(col) {
+			jb.joinVars[name] = i
+			return name
+		}
+	}
+	jb.leftExprs = append(jb.leftExprs, &sqlparser.NonStarExpr{Expr: col})
+	jb.joinVars[name] = len(jb.leftExprs) - 1
+	return name
+}
+
+// findSides returns the sides of the join referenced by node.
+// Every column must be qualified by one of the join table aliases.
+func (jb *joinBuilder) findSides(node sqlparser.Expr) (side int, err error) {
+	_, err = rewriteColNames(node, func(col *sqlparser.ColName) (sqlparser.ValExpr, error) {
+		switch string(col.Qualifier) {
+		case "":
+			return nil, fmt.Errorf("column %s must be qualified in join", col.Name)
+		case jb.left.alias:
+			side |= sideLeft
+			return nil, checkColumn(jb.left.table, col)
+		case jb.right.alias:
+			side |= sideRight
+			return nil, checkColumn(jb.right.table, col)
+		}
+		return nil, fmt.Errorf("symbol %s not found", sqlparser.String(col))
+	})
+	return side, err
+}
+
+func (jb *joinBuilder) leftSelect(sel *sqlparser.Select) *sqlparser.Select {
+	return &sqlparser.Select{
+		Comments:    sel.Comments,
+		SelectExprs: jb.leftExprs,
+		From:        sqlparser.TableExprs{jb.left.expr},
+		Where:       sqlparser.NewWhere(sqlparser.AST_WHERE, joinAnd(jb.leftConds)),
+		Lock:        sel.Lock,
+	}
+}
+
+func (jb *joinBuilder) rightSelect(sel *sqlparser.Select) *sqlparser.Select {
+	exprs := jb.rightExprs
+	if len(exprs) == 0 {
+		// The right side is only used for filtering.
+		exprs = sqlparser.SelectExprs{&sqlparser.NonStarExpr{Expr: sqlparser.NumVal("1")}}
+	}
+	return &sqlparser.Select{
+		Comments:    sel.Comments,
+		SelectExprs: exprs,
+		From:        sqlparser.TableExprs{jb.right.expr},
+		Where:       sqlparser.NewWhere(sqlparser.AST_WHERE, joinAnd(jb.rightConds)),
+		Lock:        sel.Lock,
+	}
+}
+
+// splitAnd breaks up the BoolExpr into AND-separated conditions
+// and appends them to filters.
+func splitAnd(node sqlparser.BoolExpr, filters []sqlparser.BoolExpr) []sqlparser.BoolExpr {
+	if node == nil {
+		return filters
+	}
+	switch node := node.(type) {
+	case *sqlparser.AndExpr:
+		filters = splitAnd(node.Left, filters)
+		return splitAnd(node.Right, filters)
+	case *sqlparser.ParenBoolExpr:
+		if and, ok := node.Expr.(*sqlparser.AndExpr); ok {
+			return splitAnd(and, filters)
+		}
+	}
+	return append(filters, node)
+}
+
+// joinAnd is the inverse of splitAnd.
+func joinAnd(filters []sqlparser.BoolExpr) sqlparser.BoolExpr {
+	var result sqlparser.BoolExpr
+	for _, filter := range filters {
+		if result == nil {
+			result = filter
+			continue
+		}
+		result = &sqlparser.AndExpr{Left: result, Right: filter}
+	}
+	return result
+}
+
+// rewriteColNames walks node and calls fn for every ColName
+// it finds. If fn returns a non-nil value, the ColName is
+// replaced by it. The rewritten node is returned.
+func rewriteColNames(node sqlparser.Expr, fn func(*sqlparser.ColName) (sqlparser.ValExpr, error)) (sqlparser.Expr, error) {
+	boolExpr := func(node sqlparser.BoolExpr) (sqlparser.BoolExpr, error) {
+		if node == nil {
+			return nil, nil
+		}
+		newnode, err := rewriteColNames(node, fn)
+		if err != nil {
+			return nil, err
+		}
+		return newnode.(sqlparser.BoolExpr), nil
+	}
+	valExpr := func(node sqlparser.ValExpr) (sqlparser.ValExpr, error) {
+		if node == nil {
+			return nil, nil
+		}
+		newnode, err := rewriteColNames(node, fn)
+		if err != nil {
+			return nil, err
+		}
+		return newnode.(sqlparser.ValExpr), nil
+	}
+	var err error
+	switch node := node.(type) {
+	case *sqlparser.AndExpr:
+		if node.Left, err = boolExpr(node.Left); err != nil {
+			return nil, err
+		}
+		if node.Right, err = boolExpr(node.Right); err != nil {
+			return nil, err
+		}
+	case *sqlparser.OrExpr:
+		if node.Left, err = boolExpr(node.Left); err != nil {
+			return nil, err
+		}
+		if node.Right, err = boolExpr(node.Right); err != nil {
+			return nil, err
+		}
+	case *sqlparser.NotExpr:
+		if node.Expr, err = boolExpr(node.Expr); err != nil {
+			return nil, err
+		}
+	case *sqlparser.ParenBoolExpr:
+		if node.Expr, err = boolExpr(node.Expr); err != nil {
+			return nil, err
+		}
+	case *sqlparser.ComparisonExpr:
+		if node.Left, err = valExpr(node.Left); err != nil {
+			return nil, err
+		}
+		if node.Right, err = valExpr(node.Right); err != nil {
+			return nil, err
+		}
+	case *sqlparser.RangeCond:
+		if node.Left, err = valExpr(node.Left); err != nil {
+			return nil, err
+		}
+		if node.From, err = valExpr(node.From); err != nil {
+			return nil, err
+		}
+		if node.To, err = valExpr(node.To); err != nil {
+			return nil, err
+		}
+	case *sqlparser.NullCheck:
+		if node.Expr, err = valExpr(node.Expr); err != nil {
+			return nil, err
+		}
+	case *sqlparser.ColName:
+		newnode, err := fn(node)
+		if err != nil {
+			return nil, err
+		}
+		if newnode != nil {
+			return newnode, nil
+		}
+	case sqlparser.ValTuple:
+		for i, val := range node {
+			if node[i], err = valExpr(val); err != nil {
+				return nil, err
+			}
+		}
+	case *sqlparser.BinaryExpr:
+		if node.Left, err = rewriteColNames(node.Left, fn); err != nil {
+			return nil, err
+		}
+		if node.Right, err = rewriteColNames(node.Right, fn); err != nil {
+			return nil, err
+		}
+	case *sqlparser.UnaryExpr:
+		if node.Expr, err = rewriteColNames(node.Expr, fn); err != nil {
+			return nil, err
+		}
+	case *sqlparser.FuncExpr:
+		for _, expr := range node.Exprs {
+			if expr, ok := expr.(*sqlparser.NonStarExpr); ok {
+				if expr.Expr, err = rewriteColNames(expr.Expr, fn); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case *sqlparser.CaseExpr:
+		if node.Expr, err = valExpr(node.Expr); err != nil {
+			return nil, err
+		}
+		for _, when := range node.Whens {
+			if when.Cond, err = boolExpr(when.Cond); err != nil {
+				return nil, err
+			}
+			if when.Val, err = valExpr(when.Val); err != nil {
+				return nil, err
+			}
+		}
+		if node.Else, err = valExpr(node.Else); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// checkColumn returns an error if the columns of table
+// are known, and col is not one of them.
+func checkColumn(table *Table, col *sqlparser.ColName) error {
+	if table.HasColumns() && !table.FindColumn(string(col.Name)) {
+		return fmt.Errorf("column %s not found in table %s", col.Name, table.Name)
+	}
+	return nil
+}
@@ -19,13 +19,25 @@ const (
 	SelectEqual
 	SelectIN
 	SelectKeyrange
+	SelectRange
 	SelectScatter
+	SelectJoin
+	SelectUnion
+	SelectUnionAll
+	SelectSemiJoin
+	SelectAntiJoin
 	UpdateUnsharded
 	UpdateEqual
+	UpdateIn
+	UpdateScatter
+	UpdateMigrate
 	DeleteUnsharded
 	DeleteEqual
+	DeleteIn
+	DeleteScatter
 	InsertUnsharded
 	InsertSharded
+	InsertSelect
 	NumPlans
 )
 
@@ -36,13 +48,25 @@ var planName = [NumPlans]string{
 	"SelectEqual",
 	"SelectIN",
 	"SelectKeyrange",
+	"SelectRange",
 	"SelectScatter",
+	"SelectJoin",
+	"SelectUnion",
+	"SelectUnionAll",
+	"SelectSemiJoin",
+	"SelectAntiJoin",
 	"UpdateUnsharded",
 	"UpdateEqual",
+	"UpdateIn",
+	"UpdateScatter",
+	"UpdateMigrate",
 	"DeleteUnsharded",
 	"DeleteEqual",
+	"DeleteIn",
+	"DeleteScatter",
 	"InsertUnsharded",
 	"InsertSharded",
+	"InsertSelect",
 }
 
 type Plan struct {
@@ -54,8 +78,88 @@ type Plan struct {
 	Subquery  string
 	ColVindex *ColVindex
 	Values    interface{}
+	// VindexChoice explains why ColVindex was chosen when
+	// more than one ColVindex could route the query.
+	VindexChoice string
+	// Aliased is true if the query refers to tables by their
+	// aliases. The queries sent to the shards then use the
+	// table names, even for the unsharded plans, which are
+	// otherwise sent as is.
+	Aliased bool
+
+	// Left and Right are the sub-plans of a SelectJoin,
+	// SelectUnion, SelectUnionAll, SelectSemiJoin or
+	// SelectAntiJoin. Right is also the InsertSharded plan
+	// that inserts the rows of an InsertSelect.
+	Left, Right *Plan
+	// JoinVars maps the bind vars required by Right to
+	// the column numbers of the Left result. For SelectSemiJoin
+	// and SelectAntiJoin, the columns are numbered starting from
+	// the first of the columns appended to the select list of Left.
+	JoinVars map[string]int
+	// Cols specifies the output columns of a SelectJoin.
+	// Negative values are 1-based column numbers of Left.
+	// Positive values are 1-based column numbers of Right.
+	Cols []int
+	// Aggregates specifies how the rows returned by the shards
+	// must be merged into a single row. There is one entry per
+	// output column.
+	Aggregates []string
+	// Distinct is true if the duplicate rows returned
+	// by the shards must be removed.
+	Distinct bool
+	// OrderBy specifies how the rows returned by the shards
+	// must be merge-sorted.
+	OrderBy []OrderByCol
+	// Limit is applied to the merged rows.
+	Limit *Limit
+	// Subqueries are executed before the plan, and
+	// their results are supplied as bind vars.
+	Subqueries []*Subquery
+	// Assignments are the new values of the columns
+	// of the rows moved by an UpdateMigrate.
+	Assignments map[string]interface{}
+	// Hints are the vtgate hints of the statement.
+	Hints *Hints
+	// Generate is the value of the auto-increment column
+	// of an InsertSharded.
+	Generate *Generate
+}
+
+// SeqVarName is the bind var that supplies the value of the
+// auto-increment column to an InsertSharded.
+const SeqVarName = "__seq"
+
+// Generate specifies the value of the auto-increment column
+// of an insert. If Value resolves to NULL, the next value of
+// Sequence is used instead.
+type Generate struct {
+	Sequence string
+	Value    interface{}
+}
+
+// OrderByCol specifies a column used for merge-sorting
+// the results of a multi-shard query.
+type OrderByCol struct {
+	Col  int
+	Desc bool
 }
 
+// Limit specifies the rows to be returned after merging
+// the results of a multi-shard query.
+type Limit struct {
+	Offset, Rowcount int64
+}
+
+// Aggregate operations that can be performed on the results of
+// a multi-shard query.
+const (
+	AggregateCount = "count"
+	AggregateSum   = "sum"
+	AggregateMin   = "min"
+	AggregateMax   = "max"
+)
+
 func (pln *Plan) Size() int {
 	return 1
 }
@@ -70,33 +174,74 @@ func (pln *Plan) MarshalJSON() ([]byte, error) {
 		col = pln.ColVindex.Col
 	}
 	marshalPlan := struct {
-		ID        PlanID
-		Reason    string
-		Table     string
-		Original  string
-		Rewritten string
-		Subquery  string
-		Vindex    string
-		Col       string
-		Values    interface{}
+		ID           PlanID
+		Reason       string
+		Table        string
+		Original     string
+		Rewritten    string
+		Subquery     string
+		Vindex       string
+		Col          string
+		Values       interface{}
+		VindexChoice string                 `json:",omitempty"`
+		Aliased      bool                   `json:",omitempty"`
+		Left         *Plan                  `json:",omitempty"`
+		Right        *Plan                  `json:",omitempty"`
+		JoinVars     map[string]int         `json:",omitempty"`
+		Cols         []int                  `json:",omitempty"`
+		Aggregates   []string               `json:",omitempty"`
+		Distinct     bool                   `json:",omitempty"`
+		OrderBy      []OrderByCol           `json:",omitempty"`
+		Limit        *Limit                 `json:",omitempty"`
+		Subqueries   []*Subquery            `json:",omitempty"`
+		Assignments  map[string]interface{} `json:",omitempty"`
+		Hints        *Hints                 `json:",omitempty"`
+		Generate     *Generate              `json:",omitempty"`
 	}{
-		ID:        pln.ID,
-		Reason:    pln.Reason,
-		Table:     tname,
-		Original:  pln.Original,
-		Rewritten: pln.Rewritten,
-		Subquery:  pln.Subquery,
-		Vindex:    vindexName,
-		Col:       col,
-		Values:    pln.Values,
+		ID:           pln.ID,
+		Reason:       pln.Reason,
+		Table:        tname,
+		Original:     pln.Original,
+		Rewritten:    pln.Rewritten,
+		Subquery:     pln.Subquery,
+		Vindex:       vindexName,
+		Col:          col,
+		Values:       pln.Values,
+		VindexChoice: pln.VindexChoice,
+		Aliased:      pln.Aliased,
+		Left:         pln.Left,
+		Right:        pln.Right,
+		JoinVars:     pln.JoinVars,
+		Cols:         pln.Cols,
+		Aggregates:   pln.Aggregates,
+		Distinct:     pln.Distinct,
+		OrderBy:      pln.OrderBy,
+		Limit:        pln.Limit,
+		Subqueries:   pln.Subqueries,
+		Assignments:  pln.Assignments,
+		Hints:        pln.Hints,
+		Generate:     pln.Generate,
 	}
 	return json.Marshal(marshalPlan)
 }
 
+// NeedsMerge returns true if the rows returned by the
+// shards have to be post-processed by vtgate.
+func (pln *Plan) NeedsMerge() bool {
+	return pln.Aggregates != nil || pln.Distinct || pln.OrderBy != nil || pln.Limit != nil
+}
+
+// IsSelect returns true if the plan is for a SELECT query.
+func (pln *Plan) IsSelect() bool {
+	return pln.ID >= SelectUnsharded && pln.ID <= SelectAntiJoin
+}
+
 // IsMulti returns true if the SELECT query can potentially
 // be sent to more than one shard.
 func (pln *Plan) IsMulti() bool {
-	if pln.ID == SelectIN || pln.ID == SelectScatter {
+	if pln.ID == SelectIN || pln.ID == SelectRange || pln.ID == SelectScatter || pln.ID == SelectJoin ||
+		pln.ID == SelectUnion || pln.ID == SelectUnionAll ||
+		pln.ID == SelectSemiJoin || pln.ID == SelectAntiJoin {
 		return true
 	}
 	if pln.ID == SelectEqual && !IsUnique(pln.ColVindex.Vindex) {
@@ -139,22 +284,43 @@ func BuildPlan(query string, schema *Schema) *Plan {
 		Reason:   "too complex",
 		Original: query,
 	}
+	hints, err := parseHints(statementComments(statement))
+	if err != nil {
+		noplan.Reason = err.Error()
+		return noplan
+	}
+	if _, ok := statement.(*sqlparser.Select); !ok && hints.routes() {
+		noplan.Reason = "routing hints are only supported for selects"
+		return noplan
+	}
+	aliased := resolveAliases(statement, schema)
 	var plan *Plan
 	switch statement := statement.(type) {
 	case *sqlparser.Select:
-		plan = buildSelectPlan(statement, schema)
+		plan = buildSelectPlan(statement, schema, hints)
 	case *sqlparser.Insert:
 		plan = buildInsertPlan(statement, schema)
 	case *sqlparser.Update:
 		plan = buildUpdatePlan(statement, schema)
 	case *sqlparser.Delete:
 		plan = buildDeletePlan(statement, schema)
-	case *sqlparser.Union, *sqlparser.Set, *sqlparser.DDL, *sqlparser.Other:
+	case *sqlparser.Union:
+		plan = buildUnionPlan(statement, schema)
+	case *sqlparser.Set, *sqlparser.DDL, *sqlparser.Other, *sqlparser.Savepoint, *sqlparser.SetTransaction,
+		*sqlparser.StartTransaction, *sqlparser.Explain, *sqlparser.Show, *sqlparser.Use, *sqlparser.Kill:
 		return noplan
 	default:
 		panic("unexpected")
 	}
 	plan.Original = query
+	plan.Hints = hints
+	if aliased && plan.ID != NoPlan {
+		plan.Aliased = true
+		switch plan.ID {
+		case SelectUnsharded, UpdateUnsharded, DeleteUnsharded, InsertUnsharded:
+			plan.Rewritten = generateQuery(statement)
+		}
+	}
 	return plan
 }
 
@@ -59,10 +59,25 @@ func (_ *multiIndex) Delete(_ VCursor, _ []interface{}, _ key.KeyspaceId) error
 
 func newMultiIndex(_ map[string]interface{}) (Vindex, error) { return &multiIndex{}, nil }
 
+// rangeIndex satisfies Ranged, Unique.
+type rangeIndex struct{}
+
+func (_ *rangeIndex) Cost() int { return 0 }
+func (_ *rangeIndex) Verify(_ VCursor, _ interface{}, _ key.KeyspaceId) (bool, error) {
+	return false, nil
+}
+func (_ *rangeIndex) Map(_ VCursor, _ []interface{}) ([]key.KeyspaceId, error) { return nil, nil }
+func (_ *rangeIndex) MapRange(_ VCursor, _, _ interface{}) (key.KeyRange, error) {
+	return key.KeyRange{}, nil
+}
+
+func newRangeIndex(_ map[string]interface{}) (Vindex, error) { return &rangeIndex{}, nil }
+
 func init() {
 	Register("hash", newHashIndex)
 	Register("lookup", newLookupIndex)
 	Register("multi", newMultiIndex)
+	Register("range", newRangeIndex)
 }
 
 func TestPlanName(t *testing.T) {
@@ -91,17 +106,45 @@ func TestPlan(t *testing.T) {
 	testFile(t, "select_cases.txt", schema)
 	testFile(t, "dml_cases.txt", schema)
 	testFile(t, "insert_cases.txt", schema)
+	testFile(t, "join_cases.txt", schema)
+	testFile(t, "union_cases.txt", schema)
+	testFile(t, "subquery_cases.txt", schema)
+	testFile(t, "hint_cases.txt", schema)
+	testFile(t, "alias_cases.txt", schema)
+	testFile(t, "sequence_cases.txt", schema)
+	testFile(t, "parent_cases.txt", schema)
+}
+
+// testColumns are the columns of the tables of
+// schema_test.json that are used by column_cases.txt.
+var testColumns = map[string][]Column{
+	"user": {
+		{Name: "id", Type: "bigint(20)"},
+		{Name: "name", Type: "varchar(64)"},
+	},
+	"user_extra": {
+		{Name: "user_id", Type: "bigint(20)"},
+		{Name: "extra", Type: "varchar(64)"},
+	},
+	"sales": {
+		{Name: "id", Type: "bigint(20)"},
+		{Name: "user_id", Type: "bigint(20)"},
+		{Name: "amount", Type: "int(11)"},
+	},
+}
+
+func TestPlanColumns(t *testing.T) {
+	schema, err := LoadSchemaJSON(locateFile("schema_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testFile(t, "column_cases.txt", schema.WithColumns(testColumns))
 }
 
 func testFile(t *testing.T, filename string, schema *Schema) {
 	for tcase := range iterateExecFile(filename) {
 		plan := BuildPlan(tcase.input, schema)
-		if plan.ID == NoPlan {
-			plan.Rewritten = ""
-			plan.ColVindex = nil
-			plan.Values = nil
-		}
-		bout, err := json.Marshal(plan)
+		bout, err := MarshalPlan(plan)
 		if err != nil {
 			panic(fmt.Sprintf("Error marshalling %v: %v", plan, err))
 		}
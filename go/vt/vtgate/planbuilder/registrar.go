@@ -65,6 +65,28 @@ type Reversible interface {
 	ReverseMap(cursor VCursor, ks key.KeyspaceId) (interface{}, error)
 }
 
+// A Ranged vindex is one that preserves the order of the ids
+// in their keyspace ids. This is optional. If present, VTGate
+// can send a query that selects a range of ids to only the
+// shards that cover it.
+type Ranged interface {
+	// MapRange returns the keyrange that contains the keyspace
+	// ids of the ids from start to end, inclusive. A nil start
+	// or end means that the range is unbounded on that side.
+	MapRange(cursor VCursor, start, end interface{}) (key.KeyRange, error)
+}
+
+// A CellAffine vindex is one whose rows are preferably
+// served from a specific cell, like the rows of a region
+// in a geo-partitioned keyspace. This is optional. If
+// present, VTGate sends the queries for a single id to
+// the tablets of its cell.
+type CellAffine interface {
+	// Cell returns the cell of id, or "" if
+	// id has no preferred cell.
+	Cell(cursor VCursor, id interface{}) (string, error)
+}
+
 // A Functional vindex is an index that can compute
 // the keyspace id from the id without a lookup. This
 // means that the creation of a functional vindex entry
@@ -100,6 +122,16 @@ type Lookup interface {
 	Delete(cursor VCursor, ids []interface{}, keyspace_id key.KeyspaceId) error
 }
 
+// A Consistent vindex is a Lookup whose entries must be
+// written in the same transaction as the rows that own them.
+// If a statement that writes them is not in a transaction,
+// VTGate executes it in a transaction of its own. Otherwise,
+// the entries are reverted if the statement fails.
+type Consistent interface {
+	Lookup
+	Consistent()
+}
+
 // A LookupGenerator vindex is a Lookup that can
 // generate new ids.
 type LookupGenerator interface {
@@ -107,6 +139,15 @@ type LookupGenerator interface {
 	Generate(cursor VCursor, keyspace_id key.KeyspaceId) (id int64, err error)
 }
 
+// A Typed vindex can only map the values of some column
+// types. It's used to validate a schema against the database.
+type Typed interface {
+	// AcceptsType returns true if the vindex can map the
+	// values of a column of the MySQL type columnType,
+	// like "bigint(20) unsigned".
+	AcceptsType(columnType string) bool
+}
+
 // A NewVindexFunc is a function that creates a Vindex based on the
 // properties specified in the input map. Every vindex must
 // register a NewVindexFunc under a unique vindexType.
@@ -117,7 +158,11 @@ var registry = make(map[string]NewVindexFunc)
 // Register registers a vindex under the specified vindexType.
 // A duplicate vindexType will generate a panic.
 // New vindexes will be created using these functions at the
-// time of schema loading.
+// time of schema loading. The Type of a vindex in the schema
+// selects the function, and its Params are passed to it as is.
+// Vindexes are usually registered by the init function of their
+// package. So, a binary can support a new type of vindex by just
+// importing the package that implements it.
 func Register(vindexType string, newVindexFunc NewVindexFunc) {
 	if _, ok := registry[vindexType]; ok {
 		panic(fmt.Sprintf("%s is already registered", vindexType))
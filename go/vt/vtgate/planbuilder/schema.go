@@ -8,14 +8,52 @@ import (
 	"encoding/json"
 	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/youtube/vitess/go/jscfg"
 )
 
 // Schema represents the denormalized version of SchemaFormal,
-// used for building routing plans.
+// used for building routing plans. The tables defined by more
+// than one keyspace are in Ambiguous instead of Tables: they're
+// only found in the keyspace the Schema is scoped to.
 type Schema struct {
-	Tables map[string]*Table
+	Tables    map[string]*Table
+	Ambiguous map[string][]*Table `json:",omitempty"`
+	keyspace  string
+}
+
+// InKeyspace returns schema scoped to keyspace: the unqualified
+// table names are resolved in keyspace first, and then in all the
+// keyspaces. It returns schema itself if keyspace is empty.
+func (schema *Schema) InKeyspace(keyspace string) *Schema {
+	if keyspace == "" || keyspace == schema.keyspace {
+		return schema
+	}
+	return &Schema{
+		Tables:    schema.Tables,
+		Ambiguous: schema.Ambiguous,
+		keyspace:  keyspace,
+	}
+}
+
+// KeyspaceTables returns the tables of keyspace by name,
+// including the ambiguous ones.
+func (schema *Schema) KeyspaceTables(keyspace string) map[string]*Table {
+	tables := make(map[string]*Table)
+	for tname, table := range schema.Tables {
+		if table.Keyspace.Name == keyspace {
+			tables[tname] = table
+		}
+	}
+	for tname, ambiguous := range schema.Ambiguous {
+		for _, table := range ambiguous {
+			if table.Keyspace.Name == keyspace {
+				tables[tname] = table
+			}
+		}
+	}
+	return tables
 }
 
 func (s *Schema) String() string {
@@ -26,97 +64,237 @@ func (s *Schema) String() string {
 	return string(b)
 }
 
-// Table represnts a table in Schema.
+// Table represnts a table in Schema. Ordered contains the
+// ColVindexes that can be used for routing, sorted by cost.
+// A View is routed with the vindexes of its base table, and
+// it cannot be written to. Columns are the columns of the table
+// in the order of the database, if they were learned from the
+// tablets. They are nil otherwise. Parent is the name of the table
+// whose primary vindex the table shares, if any: the joins between
+// them on the columns of that vindex stay within a shard.
 type Table struct {
-	Name        string
-	Keyspace    *Keyspace
-	ColVindexes []*ColVindex
-	Ordered     []*ColVindex
-	Owned       []*ColVindex
+	Name          string
+	Keyspace      *Keyspace
+	ColVindexes   []*ColVindex
+	Ordered       []*ColVindex
+	Owned         []*ColVindex
+	View          bool
+	IsSequence    bool
+	AutoIncrement *AutoIncrement
+	Columns       []Column
+	Parent        string
+}
+
+// AutoIncrement is the auto-increment column of a sharded
+// table. The inserts that don't supply its value get the next
+// value of Sequence, which is a sequence table of an unsharded
+// keyspace.
+type AutoIncrement struct {
+	Column   string
+	Sequence string
 }
 
 // Keyspace contains the keyspcae info for each Table.
+// The Name of a Template keyspace contains TenantPattern,
+// and its tables are routed with ForTenant.
 type Keyspace struct {
-	Name    string
-	Sharded bool
+	Name     string
+	Sharded  bool
+	Template bool
 }
 
 // Index contains the index info for each index of a table.
+// A WriteOnly vindex is maintained by inserts and deletes,
+// but is not used for routing.
 type ColVindex struct {
-	Col    string
-	Type   string
-	Name   string
-	Owned  bool
-	Vindex Vindex
+	Col       string
+	Type      string
+	Name      string
+	Owned     bool
+	WriteOnly bool
+	Vindex    Vindex
+}
+
+// KeyspaceSchema is the part of a Schema built from the
+// KeyspaceFormal of a single keyspace. The keyspaces are built
+// independently, and merged into a Schema with MergeKeyspaces,
+// so that the schema of a keyspace can be replaced without
+// building the others again.
+type KeyspaceSchema struct {
+	Keyspace *Keyspace
+	Tables   map[string]*Table
 }
 
 // BuildSchema builds a Schema from a SchemaFormal.
 func BuildSchema(source *SchemaFormal) (schema *Schema, err error) {
-	schema = &Schema{Tables: make(map[string]*Table)}
+	keyspaces := make([]*KeyspaceSchema, 0, len(source.Keyspaces))
 	for ksname, ks := range source.Keyspaces {
-		keyspace := &Keyspace{
-			Name:    ksname,
-			Sharded: ks.Sharded,
-		}
-		vindexes := make(map[string]Vindex)
-		for vname, vindexInfo := range ks.Vindexes {
-			vindex, err := createVindex(vindexInfo.Type, vindexInfo.Params)
-			if err != nil {
-				return nil, err
+		keyspace, err := BuildKeyspaceSchema(ksname, ks)
+		if err != nil {
+			return nil, err
+		}
+		keyspaces = append(keyspaces, keyspace)
+	}
+	return MergeKeyspaces(keyspaces)
+}
+
+// BuildKeyspaceSchema builds the KeyspaceSchema of keyspace ksname
+// from its KeyspaceFormal.
+func BuildKeyspaceSchema(ksname string, ks KeyspaceFormal) (*KeyspaceSchema, error) {
+	keyspace := &Keyspace{
+		Name:     ksname,
+		Sharded:  ks.Sharded,
+		Template: strings.Contains(ksname, TenantPattern),
+	}
+	vindexes := make(map[string]Vindex)
+	for vname, vindexInfo := range ks.Vindexes {
+		vindex, err := createVindex(vindexInfo.Type, vindexInfo.Params)
+		if err != nil {
+			return nil, err
+		}
+		switch vindex.(type) {
+		case Unique:
+		case NonUnique:
+		default:
+			return nil, fmt.Errorf("index %s is needs to be Unique or NonUnique", vname)
+		}
+		vindexes[vname] = vindex
+	}
+	tables := make(map[string]*Table, len(ks.Tables))
+	for tname, table := range ks.Tables {
+		t := &Table{
+			Name:     tname,
+			Keyspace: keyspace,
+		}
+		switch table.Type {
+		case "":
+		case TypeSequence:
+			if ks.Sharded {
+				return nil, fmt.Errorf("sequence table %s must be in an unsharded keyspace", tname)
 			}
-			switch vindex.(type) {
-			case Unique:
-			case NonUnique:
-			default:
-				return nil, fmt.Errorf("index %s is needs to be Unique or NonUnique", vname)
+			t.IsSequence = true
+		default:
+			return nil, fmt.Errorf("unknown type %s for table %s", table.Type, tname)
+		}
+		if table.AutoIncrement != nil {
+			if !ks.Sharded {
+				return nil, fmt.Errorf("auto-increment of table %s requires a sharded keyspace", tname)
+			}
+			if table.AutoIncrement.Column == "" || table.AutoIncrement.Sequence == "" {
+				return nil, fmt.Errorf("auto-increment of table %s needs a column and a sequence", tname)
+			}
+			t.AutoIncrement = &AutoIncrement{
+				Column:   table.AutoIncrement.Column,
+				Sequence: table.AutoIncrement.Sequence,
 			}
-			vindexes[vname] = vindex
 		}
-		for tname, table := range ks.Tables {
-			if _, ok := schema.Tables[tname]; ok {
-				return nil, fmt.Errorf("table %s has multiple definitions", tname)
+		for i, ind := range table.ColVindexes {
+			vindexInfo, ok := ks.Vindexes[ind.Name]
+			if !ok {
+				return nil, fmt.Errorf("index %s not found for table %s", ind.Name, tname)
 			}
-			t := &Table{
-				Name:     tname,
-				Keyspace: keyspace,
+			columnVindex := &ColVindex{
+				Col:       ind.Col,
+				Type:      vindexInfo.Type,
+				Name:      ind.Name,
+				Owned:     vindexInfo.Owner == tname,
+				WriteOnly: vindexInfo.WriteOnly,
+				Vindex:    vindexes[ind.Name],
 			}
-			for i, ind := range table.ColVindexes {
-				vindexInfo, ok := ks.Vindexes[ind.Name]
-				if !ok {
-					return nil, fmt.Errorf("index %s not found for table %s", ind.Name, tname)
+			if columnVindex.WriteOnly {
+				if i == 0 || !columnVindex.Owned {
+					return nil, fmt.Errorf("write-only index %s must be a non-primary index owned by table %s", ind.Name, tname)
 				}
-				columnVindex := &ColVindex{
-					Col:    ind.Col,
-					Type:   vindexInfo.Type,
-					Name:   ind.Name,
-					Owned:  vindexInfo.Owner == tname,
-					Vindex: vindexes[ind.Name],
+			}
+			if i == 0 {
+				// Perform Primary vindex check.
+				if _, ok := columnVindex.Vindex.(Unique); !ok {
+					return nil, fmt.Errorf("primary index %s is not Unique for table %s", ind.Name, tname)
 				}
-				if i == 0 {
-					// Perform Primary vindex check.
-					if _, ok := columnVindex.Vindex.(Unique); !ok {
-						return nil, fmt.Errorf("primary index %s is not Unique for table %s", ind.Name, tname)
-					}
-					if columnVindex.Owned {
-						if _, ok := columnVindex.Vindex.(Functional); !ok {
-							return nil, fmt.Errorf("primary owned index %s is not Functional for table %s", ind.Name, tname)
-						}
-					}
-				} else {
-					// Perform non-primary vindex check.
-					if columnVindex.Owned {
-						if _, ok := columnVindex.Vindex.(Lookup); !ok {
-							return nil, fmt.Errorf("non-primary owned index %s is not Lookup for table %s", ind.Name, tname)
-						}
+				if columnVindex.Owned {
+					if _, ok := columnVindex.Vindex.(Functional); !ok {
+						return nil, fmt.Errorf("primary owned index %s is not Functional for table %s", ind.Name, tname)
 					}
 				}
-				t.ColVindexes = append(t.ColVindexes, columnVindex)
+			} else {
+				// Perform non-primary vindex check.
 				if columnVindex.Owned {
-					t.Owned = append(t.Owned, columnVindex)
+					if _, ok := columnVindex.Vindex.(Lookup); !ok {
+						return nil, fmt.Errorf("non-primary owned index %s is not Lookup for table %s", ind.Name, tname)
+					}
 				}
 			}
-			t.Ordered = colVindexSorted(t.ColVindexes)
-			schema.Tables[tname] = t
+			t.ColVindexes = append(t.ColVindexes, columnVindex)
+			if columnVindex.Owned {
+				t.Owned = append(t.Owned, columnVindex)
+			}
+		}
+		t.Ordered = colVindexSorted(t.ColVindexes)
+		tables[tname] = t
+	}
+	for tname, table := range ks.Tables {
+		if table.Parent == "" {
+			continue
+		}
+		if !ks.Sharded {
+			return nil, fmt.Errorf("parent of table %s requires a sharded keyspace", tname)
+		}
+		if err := checkParent(tname, table.Parent, tables); err != nil {
+			return nil, err
+		}
+		tables[tname].Parent = table.Parent
+	}
+	for vname, base := range ks.Views {
+		if _, ok := ks.Tables[vname]; ok {
+			return nil, fmt.Errorf("view %s has the name of a table", vname)
+		}
+		t, ok := tables[base]
+		if !ok {
+			return nil, fmt.Errorf("base table %s not found for view %s", base, vname)
+		}
+		tables[vname] = &Table{
+			Name:        vname,
+			Keyspace:    keyspace,
+			ColVindexes: t.ColVindexes,
+			Ordered:     t.Ordered,
+			View:        true,
+		}
+	}
+	// The aliases are the same Table as their table, and
+	// resolveAliases replaces them with the table name.
+	for alias, tname := range ks.Aliases {
+		if _, ok := tables[alias]; ok {
+			return nil, fmt.Errorf("alias %s has the name of a table or view", alias)
+		}
+		t, ok := tables[tname]
+		if !ok {
+			return nil, fmt.Errorf("table %s not found for alias %s", tname, alias)
+		}
+		tables[alias] = t
+	}
+	return &KeyspaceSchema{Keyspace: keyspace, Tables: tables}, nil
+}
+
+// MergeKeyspaces builds the Schema of keyspaces. The tables that
+// are defined by more than one keyspace are ambiguous.
+func MergeKeyspaces(keyspaces []*KeyspaceSchema) (*Schema, error) {
+	schema := &Schema{Tables: make(map[string]*Table)}
+	for _, keyspace := range keyspaces {
+		for tname, table := range keyspace.Tables {
+			if tables, ok := schema.Ambiguous[tname]; ok {
+				schema.Ambiguous[tname] = append(tables, table)
+				continue
+			}
+			first, ok := schema.Tables[tname]
+			if !ok {
+				schema.Tables[tname] = table
+				continue
+			}
+			if schema.Ambiguous == nil {
+				schema.Ambiguous = make(map[string][]*Table)
+			}
+			schema.Ambiguous[tname] = []*Table{first, table}
+			delete(schema.Tables, tname)
 		}
 	}
 	return schema, nil
@@ -124,10 +302,25 @@ func BuildSchema(source *SchemaFormal) (schema *Schema, err error) {
 
 // FindTable returns a pointer to the Table if found.
 // Otherwise, it returns a reason, which is equivalent to an error.
+// The table of the keyspace schema is scoped to comes first.
 func (schema *Schema) FindTable(tablename string) (table *Table, reason string) {
 	if tablename == "" {
 		return nil, "complex table expression"
 	}
+	tables, ambiguous := schema.Ambiguous[tablename]
+	for _, table := range tables {
+		if table.Keyspace.Name == schema.keyspace {
+			return table, ""
+		}
+	}
+	if ambiguous {
+		names := make([]string, 0, len(tables))
+		for _, table := range tables {
+			names = append(names, table.Keyspace.Name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Sprintf("table %s is ambiguous: it's defined in keyspaces %s, use one of them first", tablename, strings.Join(names, ", "))
+	}
 	table = schema.Tables[tablename]
 	if table == nil {
 		return nil, fmt.Sprintf("table %s not found", tablename)
@@ -145,6 +338,9 @@ func (bc ByCost) Less(i, j int) bool { return bc[i].Vindex.Cost() < bc[j].Vindex
 
 func colVindexSorted(cvs []*ColVindex) (sorted []*ColVindex) {
 	for _, cv := range cvs {
+		if cv.WriteOnly {
+			continue
+		}
 		sorted = append(sorted, cv)
 	}
 	sort.Sort(ByCost(sorted))
@@ -158,25 +354,55 @@ type SchemaFormal struct {
 }
 
 // KeyspaceFormal is the keyspace info for each keyspace
-// as loaded from the source.
+// as loaded from the source. Views map the names of the
+// views of the databases to their base table, whose vindexes
+// route them. Aliases map alternate names to the tables or
+// views they stand for, so that tables can be renamed or
+// moved to another keyspace without changing the queries.
 type KeyspaceFormal struct {
 	Sharded  bool
 	Vindexes map[string]VindexFormal
 	Tables   map[string]TableFormal
+	Views    map[string]string
+	Aliases  map[string]string
 }
 
 // VindexFormal is the info for each index as loaded from
-// the source.
+// the source. A lookup vindex is made WriteOnly while it's
+// being backfilled: its entries are created and deleted
+// along with the rows of its owner, but queries are not
+// routed through it until it's complete.
 type VindexFormal struct {
-	Type   string
-	Params map[string]interface{}
-	Owner  string
+	Type      string
+	Params    map[string]interface{}
+	Owner     string
+	WriteOnly bool
 }
 
 // TableFormal is the info for each table as loaded from
-// the source.
+// the source. Type is empty for regular tables, or TypeSequence
+// for the tables that back the AutoIncrement of sharded tables.
+// Parent names the table of the same keyspace whose primary
+// vindex is also the primary vindex of this table, like a table
+// with a foreign key to it.
 type TableFormal struct {
-	ColVindexes []ColVindexFormal
+	Type          string
+	ColVindexes   []ColVindexFormal
+	AutoIncrement *AutoIncrementFormal
+	Parent        string
+}
+
+// TypeSequence is the Type of a sequence table. It has a single
+// row with id 0, whose next_id is the next value to be handed
+// out, and whose cache is the number of values that a vtgate
+// reserves at a time.
+const TypeSequence = "Sequence"
+
+// AutoIncrementFormal is the info for the auto-increment
+// column of a table as loaded from the source.
+type AutoIncrementFormal struct {
+	Column   string
+	Sequence string
 }
 
 // ColVindexFormal is the info for each indexed column
@@ -196,3 +422,28 @@ func LoadSchemaJSON(filename string) (schema *Schema, err error) {
 	}
 	return BuildSchema(&source)
 }
+
+// BuildKeyspaceSchemas builds a Schema from the JSON vschemas
+// of keyspaces, as stored in the topology. Each vschema is the
+// KeyspaceFormal of its keyspace.
+func BuildKeyspaceSchemas(vschemas map[string]string) (*Schema, error) {
+	keyspaces := make([]*KeyspaceSchema, 0, len(vschemas))
+	for keyspace, vschema := range vschemas {
+		ks, err := ParseKeyspaceSchema(keyspace, vschema)
+		if err != nil {
+			return nil, err
+		}
+		keyspaces = append(keyspaces, ks)
+	}
+	return MergeKeyspaces(keyspaces)
+}
+
+// ParseKeyspaceSchema builds the KeyspaceSchema of keyspace
+// from its JSON vschema.
+func ParseKeyspaceSchema(keyspace, vschema string) (*KeyspaceSchema, error) {
+	var ks KeyspaceFormal
+	if err := json.Unmarshal([]byte(vschema), &ks); err != nil {
+		return nil, fmt.Errorf("invalid vschema for keyspace %s: %v", keyspace, err)
+	}
+	return BuildKeyspaceSchema(keyspace, ks)
+}
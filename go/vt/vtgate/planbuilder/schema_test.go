@@ -6,6 +6,7 @@ package planbuilder
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/youtube/vitess/go/vt/key"
@@ -256,3 +257,408 @@ func TestShardedSchemaNotOwned(t *testing.T) {
 		t.Errorf("BuildSchema:s\n%v, want\n%v", got, want)
 	}
 }
+
+func TestShardedSchemaWriteOnly(t *testing.T) {
+	good := SchemaFormal{
+		Keyspaces: map[string]KeyspaceFormal{
+			"sharded": {
+				Sharded: true,
+				Vindexes: map[string]VindexFormal{
+					"stfu1": {
+						Type:  "stfu",
+						Owner: "t1",
+					},
+					"stln1": {
+						Type:      "stln",
+						Owner:     "t1",
+						WriteOnly: true,
+					},
+				},
+				Tables: map[string]TableFormal{
+					"t1": {
+						ColVindexes: []ColVindexFormal{
+							{
+								Col:  "c1",
+								Name: "stfu1",
+							}, {
+								Col:  "c2",
+								Name: "stln1",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	got, err := BuildSchema(&good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := got.Tables["t1"]
+	if len(table.Owned) != 2 || !table.Owned[1].WriteOnly {
+		t.Errorf("Owned: %v, want both vindexes", table.Owned)
+	}
+	if len(table.Ordered) != 1 || table.Ordered[0].Name != "stfu1" {
+		t.Errorf("Ordered: %v, want only stfu1", table.Ordered)
+	}
+
+	bad := good
+	bad.Keyspaces = map[string]KeyspaceFormal{
+		"sharded": {
+			Sharded: true,
+			Vindexes: map[string]VindexFormal{
+				"stfu1": {
+					Type:  "stfu",
+					Owner: "t1",
+				},
+				"stln1": {
+					Type:      "stln",
+					WriteOnly: true,
+				},
+			},
+			Tables: good.Keyspaces["sharded"].Tables,
+		},
+	}
+	_, err = BuildSchema(&bad)
+	want := "write-only index stln1 must be a non-primary index owned by table t1"
+	if err == nil || err.Error() != want {
+		t.Errorf("BuildSchema: %v, want %s", err, want)
+	}
+}
+
+func TestBuildKeyspaceSchemas(t *testing.T) {
+	got, err := BuildKeyspaceSchemas(map[string]string{
+		"unsharded": `{"Tables": {"t1": {}}}`,
+		"sharded":   `{"Sharded": true, "Vindexes": {"stfu1": {"Type": "stfu"}}, "Tables": {"t2": {"ColVindexes": [{"Col": "c1", "Name": "stfu1"}]}}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table, _ := got.FindTable("t1"); table == nil || table.Keyspace.Name != "unsharded" {
+		t.Errorf("FindTable(t1): %v, want a table of unsharded", table)
+	}
+	if table, _ := got.FindTable("t2"); table == nil || table.Keyspace.Name != "sharded" || len(table.Ordered) != 1 {
+		t.Errorf("FindTable(t2): %v, want a table of sharded with a vindex", table)
+	}
+
+	_, err = BuildKeyspaceSchemas(map[string]string{"ks": "{"})
+	if err == nil || !strings.HasPrefix(err.Error(), "invalid vschema for keyspace ks") {
+		t.Errorf("BuildKeyspaceSchemas: %v, want invalid vschema for keyspace ks", err)
+	}
+}
+
+func TestMergeKeyspaces(t *testing.T) {
+	unsharded, err := BuildKeyspaceSchema("unsharded", KeyspaceFormal{
+		Tables: map[string]TableFormal{"t1": {}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharded, err := BuildKeyspaceSchema("sharded", KeyspaceFormal{
+		Sharded:  true,
+		Vindexes: map[string]VindexFormal{"stfu1": {Type: "stfu"}},
+		Tables: map[string]TableFormal{
+			"t2": {ColVindexes: []ColVindexFormal{{Col: "c1", Name: "stfu1"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := MergeKeyspaces([]*KeyspaceSchema{unsharded, sharded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Tables["t1"] != unsharded.Tables["t1"] || got.Tables["t2"] != sharded.Tables["t2"] {
+		t.Errorf("MergeKeyspaces: %v, want the tables of both keyspaces", got)
+	}
+
+	duplicate, err := BuildKeyspaceSchema("other", KeyspaceFormal{
+		Tables: map[string]TableFormal{"t1": {}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = MergeKeyspaces([]*KeyspaceSchema{unsharded, sharded, duplicate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.Tables["t1"]; ok || len(got.Ambiguous["t1"]) != 2 {
+		t.Errorf("MergeKeyspaces: %v, want t1 to be ambiguous", got)
+	}
+	want := "table t1 is ambiguous: it's defined in keyspaces other, unsharded, use one of them first"
+	if _, reason := got.FindTable("t1"); reason != want {
+		t.Errorf("FindTable(t1): %s, want %s", reason, want)
+	}
+	if _, reason := got.InKeyspace("sharded").FindTable("t1"); reason != want {
+		t.Errorf("FindTable(t1) in sharded: %s, want %s", reason, want)
+	}
+	if table, _ := got.InKeyspace("other").FindTable("t1"); table != duplicate.Tables["t1"] {
+		t.Errorf("FindTable(t1) in other: %v, want the table of other", table)
+	}
+	if table, _ := got.InKeyspace("other").FindTable("t2"); table != sharded.Tables["t2"] {
+		t.Errorf("FindTable(t2) in other: %v, want the table of sharded", table)
+	}
+	if tables := got.KeyspaceTables("unsharded"); len(tables) != 1 || tables["t1"] != unsharded.Tables["t1"] {
+		t.Errorf("KeyspaceTables(unsharded): %v, want t1", tables)
+	}
+}
+
+func TestViewsAndAliases(t *testing.T) {
+	ks := KeyspaceFormal{
+		Sharded:  true,
+		Vindexes: map[string]VindexFormal{"stfu1": {Type: "stfu"}},
+		Tables: map[string]TableFormal{
+			"t1": {ColVindexes: []ColVindexFormal{{Col: "c1", Name: "stfu1"}}},
+		},
+		Views:   map[string]string{"v1": "t1"},
+		Aliases: map[string]string{"a1": "t1", "a2": "v1"},
+	}
+	got, err := BuildKeyspaceSchema("sharded", ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	view := got.Tables["v1"]
+	if view == nil || !view.View || view.Name != "v1" || len(view.Ordered) != 1 {
+		t.Errorf("view v1: %v, want a view with the vindexes of t1", view)
+	}
+	if got.Tables["a1"] != got.Tables["t1"] || got.Tables["a2"] != view {
+		t.Errorf("aliases: %v, want the tables they refer to", got.Tables)
+	}
+
+	testcases := []struct {
+		views   map[string]string
+		aliases map[string]string
+		want    string
+	}{{
+		views: map[string]string{"t1": "t1"},
+		want:  "view t1 has the name of a table",
+	}, {
+		views: map[string]string{"v1": "t2"},
+		want:  "base table t2 not found for view v1",
+	}, {
+		aliases: map[string]string{"t1": "t1"},
+		want:    "alias t1 has the name of a table or view",
+	}, {
+		aliases: map[string]string{"a1": "t2"},
+		want:    "table t2 not found for alias a1",
+	}}
+	for _, tcase := range testcases {
+		bad := ks
+		bad.Views = tcase.views
+		bad.Aliases = tcase.aliases
+		_, err := BuildKeyspaceSchema("sharded", bad)
+		if err == nil || err.Error() != tcase.want {
+			t.Errorf("BuildKeyspaceSchema: %v, want %s", err, tcase.want)
+		}
+	}
+}
+
+func TestSequences(t *testing.T) {
+	got, err := BuildSchema(&SchemaFormal{
+		Keyspaces: map[string]KeyspaceFormal{
+			"unsharded": {
+				Tables: map[string]TableFormal{
+					"seq": {Type: TypeSequence},
+				},
+			},
+			"sharded": {
+				Sharded:  true,
+				Vindexes: map[string]VindexFormal{"stfu1": {Type: "stfu"}},
+				Tables: map[string]TableFormal{
+					"t1": {
+						ColVindexes:   []ColVindexFormal{{Col: "c1", Name: "stfu1"}},
+						AutoIncrement: &AutoIncrementFormal{Column: "c2", Sequence: "seq"},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Tables["seq"].IsSequence {
+		t.Errorf("seq.IsSequence: false, want true")
+	}
+	want := &AutoIncrement{Column: "c2", Sequence: "seq"}
+	if !reflect.DeepEqual(got.Tables["t1"].AutoIncrement, want) {
+		t.Errorf("t1.AutoIncrement: %+v, want %+v", got.Tables["t1"].AutoIncrement, want)
+	}
+
+	testcases := []struct {
+		ks   KeyspaceFormal
+		want string
+	}{{
+		ks: KeyspaceFormal{
+			Sharded: true,
+			Tables:  map[string]TableFormal{"seq": {Type: TypeSequence}},
+		},
+		want: "sequence table seq must be in an unsharded keyspace",
+	}, {
+		ks: KeyspaceFormal{
+			Tables: map[string]TableFormal{"seq": {Type: "bad"}},
+		},
+		want: "unknown type bad for table seq",
+	}, {
+		ks: KeyspaceFormal{
+			Tables: map[string]TableFormal{
+				"t1": {AutoIncrement: &AutoIncrementFormal{Column: "c1", Sequence: "seq"}},
+			},
+		},
+		want: "auto-increment of table t1 requires a sharded keyspace",
+	}, {
+		ks: KeyspaceFormal{
+			Sharded:  true,
+			Vindexes: map[string]VindexFormal{"stfu1": {Type: "stfu"}},
+			Tables: map[string]TableFormal{
+				"t1": {
+					ColVindexes:   []ColVindexFormal{{Col: "c1", Name: "stfu1"}},
+					AutoIncrement: &AutoIncrementFormal{Column: "c1"},
+				},
+			},
+		},
+		want: "auto-increment of table t1 needs a column and a sequence",
+	}}
+	for _, tcase := range testcases {
+		_, err := BuildKeyspaceSchema("ks", tcase.ks)
+		if err == nil || err.Error() != tcase.want {
+			t.Errorf("BuildKeyspaceSchema: %v, want %s", err, tcase.want)
+		}
+	}
+}
+
+func TestParents(t *testing.T) {
+	ks := KeyspaceFormal{
+		Sharded: true,
+		Vindexes: map[string]VindexFormal{
+			"stfu1": {Type: "stfu"},
+			"stfu2": {Type: "stfu"},
+		},
+		Tables: map[string]TableFormal{
+			"t1": {ColVindexes: []ColVindexFormal{{Col: "c1", Name: "stfu1"}}},
+			"t2": {ColVindexes: []ColVindexFormal{{Col: "c2", Name: "stfu1"}}, Parent: "t1"},
+		},
+	}
+	got, err := BuildKeyspaceSchema("sharded", ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Tables["t2"].Parent != "t1" || got.Tables["t1"].Parent != "" {
+		t.Errorf("Parent: %q, %q, want t1 and none", got.Tables["t2"].Parent, got.Tables["t1"].Parent)
+	}
+
+	testcases := []struct {
+		sharded bool
+		table   TableFormal
+		want    string
+	}{{
+		sharded: true,
+		table:   TableFormal{ColVindexes: []ColVindexFormal{{Col: "c2", Name: "stfu1"}}, Parent: "t3"},
+		want:    "parent t3 not found for table t2",
+	}, {
+		sharded: true,
+		table:   TableFormal{ColVindexes: []ColVindexFormal{{Col: "c2", Name: "stfu1"}}, Parent: "t2"},
+		want:    "table t2 cannot be its own parent",
+	}, {
+		sharded: true,
+		table:   TableFormal{ColVindexes: []ColVindexFormal{{Col: "c2", Name: "stfu2"}}, Parent: "t1"},
+		want:    "table t2 must have the primary vindex of its parent t1",
+	}, {
+		sharded: false,
+		table:   TableFormal{Parent: "t1"},
+		want:    "parent of table t2 requires a sharded keyspace",
+	}}
+	for _, tcase := range testcases {
+		bad := KeyspaceFormal{
+			Sharded:  tcase.sharded,
+			Vindexes: ks.Vindexes,
+			Tables: map[string]TableFormal{
+				"t1": ks.Tables["t1"],
+				"t2": tcase.table,
+			},
+		}
+		if !tcase.sharded {
+			bad.Vindexes = nil
+			bad.Tables["t1"] = TableFormal{}
+		}
+		_, err := BuildKeyspaceSchema("ks", bad)
+		if err == nil || err.Error() != tcase.want {
+			t.Errorf("BuildKeyspaceSchema: %v, want %s", err, tcase.want)
+		}
+	}
+}
+
+func TestTenants(t *testing.T) {
+	schema, err := BuildSchema(&SchemaFormal{
+		Keyspaces: map[string]KeyspaceFormal{
+			"main": {
+				Tables: map[string]TableFormal{"t1": {}},
+			},
+			"customer_{tenant}": {
+				Tables: map[string]TableFormal{"t2": {}, "t3": {}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema.Tables["t1"].Keyspace.Template {
+		t.Errorf("main.Template: true, want false")
+	}
+	if !schema.Tables["t2"].Keyspace.Template {
+		t.Errorf("customer_{tenant}.Template: false, want true")
+	}
+
+	plan := BuildPlan("select * from t1", schema)
+	got, err := ForTenant(plan, "")
+	if err != nil || got != plan {
+		t.Errorf("ForTenant: %v, %v, want the same plan", got, err)
+	}
+
+	plan = BuildPlan("select * from t2 join t3", schema)
+	got, err = ForTenant(plan, "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Table.Keyspace.Name != "customer_42" || got.Table.Keyspace.Template {
+		t.Errorf("ForTenant: keyspace %+v, want customer_42", got.Table.Keyspace)
+	}
+	if plan.Table.Keyspace.Name != "customer_{tenant}" {
+		t.Errorf("ForTenant changed the cached plan: keyspace %s", plan.Table.Keyspace.Name)
+	}
+
+	_, err = ForTenant(plan, "")
+	want := "table t2 belongs to the tenant keyspaces customer_{tenant}, and no tenant is set"
+	if err == nil || err.Error() != want {
+		t.Errorf("ForTenant: %v, want %s", err, want)
+	}
+	_, err = ForTenant(plan, "a b")
+	want = `invalid tenant "a b"`
+	if err == nil || err.Error() != want {
+		t.Errorf("ForTenant: %v, want %s", err, want)
+	}
+}
+
+func TestWithColumns(t *testing.T) {
+	ks, err := BuildKeyspaceSchema("unsharded", KeyspaceFormal{
+		Tables:  map[string]TableFormal{"t1": {}, "t2": {}},
+		Aliases: map[string]string{"a1": "t1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := []Column{{Name: "c1", Type: "int(11)"}}
+	got := ks.WithColumns(map[string][]Column{"t1": columns})
+	t1 := got.Tables["t1"]
+	if !reflect.DeepEqual(t1.Columns, columns) || !t1.FindColumn("c1") || t1.FindColumn("c2") {
+		t.Errorf("t1.Columns: %v, want %v", t1.Columns, columns)
+	}
+	if got.Tables["a1"] != t1 {
+		t.Errorf("alias a1: %v, want the Table of t1", got.Tables["a1"])
+	}
+	if got.Tables["t2"] != ks.Tables["t2"] || got.Tables["t2"].HasColumns() {
+		t.Errorf("t2: %v, want the Table of t2 without columns", got.Tables["t2"])
+	}
+	if ks.Tables["t1"].HasColumns() {
+		t.Errorf("WithColumns changed the columns of the original table")
+	}
+}
@@ -4,9 +4,26 @@
 
 package planbuilder
 
-import "github.com/youtube/vitess/go/vt/sqlparser"
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
 
-func buildSelectPlan(sel *sqlparser.Select, schema *Schema) *Plan {
+	"github.com/youtube/vitess/go/vt/sqlparser"
+)
+
+// buildSelectPlan builds the plan of sel. If hints is not nil,
+// they can override the routing of sel.
+func buildSelectPlan(sel *sqlparser.Select, schema *Schema, hints *Hints) *Plan {
+	if len(sel.From) == 1 {
+		if join, ok := sel.From[0].(*sqlparser.JoinTableExpr); ok {
+			if hints.routes() {
+				return &Plan{ID: NoPlan, Reason: "routing hints are not supported for joins"}
+			}
+			return buildJoinPlan(sel, join, schema)
+		}
+	}
 	plan := &Plan{ID: NoPlan}
 	tablename, _ := analyzeFrom(sel.From)
 	plan.Table, plan.Reason = schema.FindTable(tablename)
@@ -17,13 +34,28 @@ func buildSelectPlan(sel *sqlparser.Select, schema *Schema) *Plan {
 		plan.ID = SelectUnsharded
 		return plan
 	}
+	if sel.Where != nil && hasSubquery(sel.Where.Expr) {
+		if hints.routes() {
+			return &Plan{ID: NoPlan, Reason: "routing hints are not supported for subqueries"}
+		}
+		return buildSubqueryPlan(sel, schema)
+	}
 
-	getWhereRouting(sel.Where, plan, false)
+	if hints.routes() {
+		getHintedRouting(sel.Where, plan, hints)
+		if plan.ID == NoPlan {
+			return plan
+		}
+	} else {
+		getWhereRouting(sel.Where, plan, false)
+	}
 	if plan.IsMulti() {
 		if hasPostProcessing(sel) {
-			plan.ID = NoPlan
-			plan.Reason = "too complex"
-			return plan
+			plan.Reason = buildMerge(sel, plan)
+			if plan.Reason != "" {
+				plan.ID = NoPlan
+				return plan
+			}
 		}
 	}
 	// The where clause might have changed.
@@ -114,3 +146,165 @@ func exprHasAggregates(node sqlparser.Expr) bool {
 func hasPostProcessing(sel *sqlparser.Select) bool {
 	return hasAggregates(sel.SelectExprs) || sel.Distinct != "" || sel.GroupBy != nil || sel.Having != nil || sel.OrderBy != nil || sel.Limit != nil
 }
+
+// buildMerge checks if the post-processing required by sel
+// can be performed by vtgate on the results of a multi-shard
+// query, and sets up plan to do so. It may rewrite the limit
+// clause of sel.
+func buildMerge(sel *sqlparser.Select, plan *Plan) (reason string) {
+	if hasAggregates(sel.SelectExprs) || sel.GroupBy != nil || sel.Having != nil {
+		if sel.Distinct != "" || sel.OrderBy != nil || sel.Limit != nil {
+			return "too complex"
+		}
+		plan.Aggregates, reason = buildAggregates(sel, plan.Table)
+		return reason
+	}
+	// Each shard returns distinct rows, but the same row
+	// can still come from more than one shard.
+	plan.Distinct = sel.Distinct != ""
+	plan.OrderBy, reason = buildOrderBy(sel)
+	if reason != "" {
+		return reason
+	}
+	plan.Limit, reason = buildLimit(sel)
+	return reason
+}
+
+// buildAggregates returns the merge operation for each column
+// if the rows returned by the shards have to be merged.
+func buildAggregates(sel *sqlparser.Select, table *Table) (aggregates []string, reason string) {
+	if sel.GroupBy != nil {
+		// If the rows are grouped by a unique vindex column,
+		// every group is confined to a single shard, and the
+		// results can be returned as is.
+		if !hasVindexGroupBy(sel.GroupBy, table) {
+			return nil, "too complex"
+		}
+		return nil, ""
+	}
+	if sel.Having != nil {
+		return nil, "too complex"
+	}
+	aggregates = make([]string, 0, len(sel.SelectExprs))
+	for _, expr := range sel.SelectExprs {
+		nonstar, ok := expr.(*sqlparser.NonStarExpr)
+		if !ok {
+			return nil, "too complex"
+		}
+		fexpr, ok := nonstar.Expr.(*sqlparser.FuncExpr)
+		if !ok || fexpr.Distinct {
+			return nil, "too complex"
+		}
+		name := strings.ToLower(string(fexpr.Name))
+		switch name {
+		case AggregateCount, AggregateSum, AggregateMin, AggregateMax:
+		default:
+			return nil, "too complex"
+		}
+		aggregates = append(aggregates, name)
+	}
+	return aggregates, ""
+}
+
+func hasVindexGroupBy(groupBy sqlparser.GroupBy, table *Table) bool {
+	for _, expr := range groupBy {
+		col, ok := expr.(*sqlparser.ColName)
+		if !ok {
+			continue
+		}
+		for _, colVindex := range table.ColVindexes {
+			if string(col.Name) == colVindex.Col && IsUnique(colVindex.Vindex) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// buildOrderBy maps the order by clause of sel to the columns
+// of the select list, which are used to merge-sort the rows
+// returned by the shards.
+func buildOrderBy(sel *sqlparser.Select) (orderBy []OrderByCol, reason string) {
+	for _, order := range sel.OrderBy {
+		col := findSelectCol(sel.SelectExprs, order.Expr)
+		if col == -1 {
+			return nil, fmt.Sprintf("order by expression %s must be in select list", sqlparser.String(order.Expr))
+		}
+		orderBy = append(orderBy, OrderByCol{
+			Col:  col,
+			Desc: order.Direction == sqlparser.AST_DESC,
+		})
+	}
+	return orderBy, ""
+}
+
+// findSelectCol returns the index of the column in selectExprs
+// that expr refers to, or -1 if there is none. expr can be a
+// column name, an alias or a 1-based column number.
+func findSelectCol(selectExprs sqlparser.SelectExprs, expr sqlparser.ValExpr) int {
+	switch expr := expr.(type) {
+	case sqlparser.NumVal:
+		num, err := strconv.Atoi(string(expr))
+		if err != nil || num < 1 || num > len(selectExprs) {
+			return -1
+		}
+		if _, ok := selectExprs[num-1].(*sqlparser.NonStarExpr); !ok {
+			return -1
+		}
+		return num - 1
+	case *sqlparser.ColName:
+		for i, selectExpr := range selectExprs {
+			nonstar, ok := selectExpr.(*sqlparser.NonStarExpr)
+			if !ok {
+				continue
+			}
+			if len(expr.Qualifier) == 0 && bytes.Equal(nonstar.As, expr.Name) {
+				return i
+			}
+			col, ok := nonstar.Expr.(*sqlparser.ColName)
+			if !ok || !bytes.Equal(col.Name, expr.Name) {
+				continue
+			}
+			if len(expr.Qualifier) != 0 && !bytes.Equal(col.Qualifier, expr.Qualifier) {
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// buildLimit returns the limit to be applied to the merged rows.
+// The limit clause of sel is rewritten to make every shard return
+// enough rows to cover the offset.
+func buildLimit(sel *sqlparser.Select) (limit *Limit, reason string) {
+	if sel.Limit == nil {
+		return nil, ""
+	}
+	limit = &Limit{}
+	var ok bool
+	if sel.Limit.Offset != nil {
+		if limit.Offset, ok = asCount(sel.Limit.Offset); !ok {
+			return nil, "too complex"
+		}
+	}
+	if limit.Rowcount, ok = asCount(sel.Limit.Rowcount); !ok {
+		return nil, "too complex"
+	}
+	sel.Limit = &sqlparser.Limit{
+		Rowcount: sqlparser.NumVal(strconv.FormatInt(limit.Offset+limit.Rowcount, 10)),
+	}
+	return limit, ""
+}
+
+func asCount(expr sqlparser.ValExpr) (int64, bool) {
+	num, ok := expr.(sqlparser.NumVal)
+	if !ok {
+		return 0, false
+	}
+	val, err := strconv.ParseInt(string(num), 10, 64)
+	if err != nil || val < 0 {
+		return 0, false
+	}
+	return val, true
+}
@@ -0,0 +1,266 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package planbuilder
+
+import (
+	"fmt"
+
+	"github.com/youtube/vitess/go/vt/sqlparser"
+)
+
+// Subquery is an uncorrelated subquery that is executed before
+// the outer query. Its result is passed to the outer query as
+// a bind var.
+type Subquery struct {
+	Plan *Plan
+	// VarName is the name of the bind var.
+	VarName string
+	// IsList is true if the bind var is a list of all the
+	// values returned. Otherwise, the subquery must return
+	// at most one value.
+	IsList bool
+}
+
+// buildSubqueryPlan builds a plan for a select on a sharded table
+// whose where clause has subqueries. Subqueries of the form
+// "expr op (subquery)" are replaced by bind vars. A correlated
+// "[not] exists (subquery)" is turned into a SelectSemiJoin or
+// SelectAntiJoin, where the subquery is executed for every row
+// of the outer query.
+func buildSubqueryPlan(sel *sqlparser.Select, schema *Schema) *Plan {
+	plan := &Plan{ID: NoPlan}
+	outer, reason := newJoinTable(sel.From[0], schema)
+	if reason != "" {
+		plan.Reason = reason
+		return plan
+	}
+	plan.Table = outer.table
+	sb := &subqueryBuilder{
+		outer:    outer,
+		joinVars: make(map[string]int),
+	}
+	var conditions []sqlparser.BoolExpr
+	for _, cond := range splitAnd(sel.Where.Expr, nil) {
+		if !hasSubquery(cond) {
+			conditions = append(conditions, cond)
+			continue
+		}
+		newcond, err := sb.addCondition(cond, schema)
+		if err != nil {
+			plan.Reason = err.Error()
+			return plan
+		}
+		if newcond != nil {
+			conditions = append(conditions, newcond)
+		}
+	}
+	sel.Where = sqlparser.NewWhere(sqlparser.AST_WHERE, joinAnd(conditions))
+
+	if sb.exists == nil {
+		plan = buildSelectPlan(sel, schema, nil)
+		if plan.ID != NoPlan {
+			plan.Subqueries = sb.subqueries
+		}
+		return plan
+	}
+
+	if hasPostProcessing(sel) {
+		plan.Reason = "too complex"
+		return plan
+	}
+	inner, err := sb.innerSelect()
+	if err != nil {
+		plan.Reason = err.Error()
+		return plan
+	}
+	sel.SelectExprs = append(sel.SelectExprs, sb.outerExprs...)
+	leftPlan := buildJoinSubplan(sel, schema)
+	if leftPlan.ID == NoPlan {
+		plan.Reason = leftPlan.Reason
+		return plan
+	}
+	leftPlan.Subqueries = sb.subqueries
+	rightPlan := buildJoinSubplan(inner, schema)
+	if rightPlan.ID == NoPlan {
+		plan.Reason = rightPlan.Reason
+		return plan
+	}
+	plan.ID = SelectSemiJoin
+	if sb.notExists {
+		plan.ID = SelectAntiJoin
+	}
+	plan.Table = outer.table
+	plan.Left = leftPlan
+	plan.Right = rightPlan
+	plan.JoinVars = sb.joinVars
+	return plan
+}
+
+// subqueryBuilder accumulates the subqueries of a select.
+type subqueryBuilder struct {
+	outer      *joinTable
+	subqueries []*Subquery
+
+	// exists is the subquery of the only correlated
+	// exists allowed.
+	exists    *sqlparser.Select
+	notExists bool
+
+	// outerExprs are the outer columns referenced by exists.
+	// They're appended to the outer select list.
+	outerExprs sqlparser.SelectExprs
+	joinVars   map[string]int
+}
+
+// addCondition handles a condition that contains subqueries.
+// It returns the condition to be used in its place, if any.
+func (sb *subqueryBuilder) addCondition(cond sqlparser.BoolExpr, schema *Schema) (sqlparser.BoolExpr, error) {
+	switch cond := cond.(type) {
+	case *sqlparser.ExistsExpr:
+		return nil, sb.setExists(cond, false)
+	case *sqlparser.NotExpr:
+		if exists, ok := cond.Expr.(*sqlparser.ExistsExpr); ok {
+			return nil, sb.setExists(exists, true)
+		}
+	case *sqlparser.ComparisonExpr:
+		subquery, ok := cond.Right.(*sqlparser.Subquery)
+		if !ok || hasSubquery(cond.Left) {
+			break
+		}
+		isList := false
+		switch cond.Operator {
+		case sqlparser.AST_IN:
+			isList = true
+		case sqlparser.AST_EQ, sqlparser.AST_LT, sqlparser.AST_GT, sqlparser.AST_LE,
+			sqlparser.AST_GE, sqlparser.AST_NE, sqlparser.AST_NSE:
+		default:
+			return nil, fmt.Errorf("unsupported subquery: %s", sqlparser.String(cond))
+		}
+		if inner, ok := subquery.Select.(*sqlparser.Select); ok && !isSingleColumn(inner.SelectExprs) {
+			return nil, fmt.Errorf("subquery must return a single column: %s", sqlparser.String(subquery))
+		}
+		if sb.isCorrelated(subquery.Select) {
+			return nil, fmt.Errorf("unsupported correlated subquery: %s", sqlparser.String(cond))
+		}
+		inner := buildStatementSubplan(subquery.Select, schema)
+		if inner.ID == NoPlan {
+			return nil, fmt.Errorf("%s", inner.Reason)
+		}
+		sq := &Subquery{
+			Plan:    inner,
+			VarName: fmt.Sprintf("_sq%d", len(sb.subqueries)+1),
+			IsList:  isList,
+		}
+		sb.subqueries = append(sb.subqueries, sq)
+		if isList {
+			cond.Right = sqlparser.ListArg("::" + sq.VarName)
+		} else {
+			cond.Right = sqlparser.ValArg(":" + sq.VarName)
+		}
+		return cond, nil
+	}
+	return nil, fmt.Errorf("has subquery")
+}
+
+func (sb *subqueryBuilder) setExists(exists *sqlparser.ExistsExpr, not bool) error {
+	if sb.exists != nil {
+		return fmt.Errorf("too complex")
+	}
+	sel, ok := exists.Subquery.Select.(*sqlparser.Select)
+	if !ok {
+		return fmt.Errorf("unsupported subquery: %s", sqlparser.String(exists))
+	}
+	sb.exists = sel
+	sb.notExists = not
+	return nil
+}
+
+// isCorrelated returns true if statement references the outer table.
+func (sb *subqueryBuilder) isCorrelated(statement sqlparser.SelectStatement) bool {
+	sel, ok := statement.(*sqlparser.Select)
+	if !ok || sel.Where == nil || sb.shadowsOuter(sel) {
+		return false
+	}
+	correlated := false
+	rewriteColNames(sel.Where.Expr, func(col *sqlparser.ColName) (sqlparser.ValExpr, error) {
+		if string(col.Qualifier) == sb.outer.alias {
+			correlated = true
+		}
+		return nil, nil
+	})
+	return correlated
+}
+
+// shadowsOuter returns true if the from clause of sel uses
+// the same name as the outer table.
+func (sb *subqueryBuilder) shadowsOuter(sel *sqlparser.Select) bool {
+	for _, expr := range sel.From {
+		if expr, ok := expr.(*sqlparser.AliasedTableExpr); ok {
+			name := sqlparser.GetTableName(expr.Expr)
+			if expr.As != nil {
+				name = string(expr.As)
+			}
+			if name == sb.outer.alias {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// innerSelect returns the exists subquery with its references
+// to the outer table replaced by bind vars. Only the existence
+// of a row matters, so at most one row is requested.
+func (sb *subqueryBuilder) innerSelect() (*sqlparser.Select, error) {
+	inner := sb.exists
+	if inner.Where != nil && !sb.shadowsOuter(inner) {
+		newExpr, err := rewriteColNames(inner.Where.Expr, func(col *sqlparser.ColName) (sqlparser.ValExpr, error) {
+			if string(col.Qualifier) != sb.outer.alias {
+				return nil, nil
+			}
+			return sqlparser.ValArg(":" + sb.joinVar(col)), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		inner.Where.Expr = newExpr.(sqlparser.BoolExpr)
+	}
+	if inner.Limit == nil {
+		inner.Limit = &sqlparser.Limit{Rowcount: sqlparser.NumVal("1")}
+	}
+	return inner, nil
+}
+
+// joinVar returns the name of the bind var that will carry
+// the value of col from the outer result. The column is added
+// to the outer columns if it's not already there.
+func (sb *subqueryBuilder) joinVar(col *sqlparser.ColName) string {
+	name := fmt.Sprintf("_%s_%s", col.Qualifier, col.Name)
+	if _, ok := sb.joinVars[name]; !ok {
+		sb.outerExprs = append(sb.outerExprs, &sqlparser.NonStarExpr{Expr: col})
+		sb.joinVars[name] = len(sb.outerExprs) - 1
+	}
+	return name
+}
+
+func isSingleColumn(selectExprs sqlparser.SelectExprs) bool {
+	if len(selectExprs) != 1 {
+		return false
+	}
+	_, ok := selectExprs[0].(*sqlparser.NonStarExpr)
+	return ok
+}
+
+// buildStatementSubplan builds a plan for a select statement
+// that's part of a bigger statement.
+func buildStatementSubplan(statement sqlparser.SelectStatement, schema *Schema) *Plan {
+	switch statement := statement.(type) {
+	case *sqlparser.Select:
+		return buildJoinSubplan(statement, schema)
+	case *sqlparser.Union:
+		return buildUnionSubplan(statement, schema)
+	}
+	panic("unexpected")
+}
@@ -0,0 +1,100 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package planbuilder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TenantPattern marks a keyspace of a SchemaFormal as a template:
+// its schema is shared by the keyspaces of many tenants, which are
+// named by replacing TenantPattern with the tenant identifier. For
+// instance, the template "customer_{tenant}" stands for the keyspaces
+// customer_1, customer_2, etc.
+const TenantPattern = "{tenant}"
+
+// TenantKeyspace returns the name of the keyspace of
+// tenant for the template keyspace pattern.
+func TenantKeyspace(pattern, tenant string) (string, error) {
+	if !isIdentifier(tenant) {
+		return "", fmt.Errorf("invalid tenant %q", tenant)
+	}
+	return strings.Replace(pattern, TenantPattern, tenant, -1), nil
+}
+
+// TenantTable returns table if it's not a table of a template
+// keyspace. Otherwise, it returns a copy of table that belongs to
+// the keyspace of tenant.
+func TenantTable(table *Table, tenant string) (*Table, error) {
+	if table == nil || !table.Keyspace.Template {
+		return table, nil
+	}
+	if tenant == "" {
+		return nil, fmt.Errorf("table %s belongs to the tenant keyspaces %s, and no tenant is set", table.Name, table.Keyspace.Name)
+	}
+	name, err := TenantKeyspace(table.Keyspace.Name, tenant)
+	if err != nil {
+		return nil, err
+	}
+	t := *table
+	t.Keyspace = &Keyspace{
+		Name:    name,
+		Sharded: table.Keyspace.Sharded,
+	}
+	return &t, nil
+}
+
+// ForTenant returns plan if none of its tables belong to a template
+// keyspace. Otherwise, it returns a copy of plan whose tables belong
+// to the keyspaces of tenant. The cached plans are shared by all the
+// tenants, and resolved for the tenant of each query.
+func ForTenant(plan *Plan, tenant string) (*Plan, error) {
+	if plan == nil || !plan.usesTemplate() {
+		return plan, nil
+	}
+	cp := *plan
+	var err error
+	if cp.Table, err = TenantTable(plan.Table, tenant); err != nil {
+		return nil, err
+	}
+	if cp.Left, err = ForTenant(plan.Left, tenant); err != nil {
+		return nil, err
+	}
+	if cp.Right, err = ForTenant(plan.Right, tenant); err != nil {
+		return nil, err
+	}
+	if plan.Subqueries != nil {
+		cp.Subqueries = make([]*Subquery, len(plan.Subqueries))
+		for i, sq := range plan.Subqueries {
+			sqcp := *sq
+			if sqcp.Plan, err = ForTenant(sq.Plan, tenant); err != nil {
+				return nil, err
+			}
+			cp.Subqueries[i] = &sqcp
+		}
+	}
+	return &cp, nil
+}
+
+// usesTemplate returns true if a table of
+// plan belongs to a template keyspace.
+func (pln *Plan) usesTemplate() bool {
+	if pln == nil {
+		return false
+	}
+	if pln.Table != nil && pln.Table.Keyspace.Template {
+		return true
+	}
+	if pln.Left.usesTemplate() || pln.Right.usesTemplate() {
+		return true
+	}
+	for _, sq := range pln.Subqueries {
+		if sq.Plan.usesTemplate() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package planbuilder
+
+import (
+	"fmt"
+
+	"github.com/youtube/vitess/go/vt/sqlparser"
+)
+
+// buildUnionPlan builds a plan that routes each side of the
+// union independently. The results are combined by vtgate.
+func buildUnionPlan(union *sqlparser.Union, schema *Schema) *Plan {
+	plan := &Plan{ID: NoPlan}
+	var id PlanID
+	switch union.Type {
+	case sqlparser.AST_UNION:
+		id = SelectUnion
+	case sqlparser.AST_UNION_ALL:
+		id = SelectUnionAll
+	default:
+		plan.Reason = fmt.Sprintf("unsupported union type: %s", union.Type)
+		return plan
+	}
+	leftPlan := buildUnionSubplan(union.Left, schema)
+	if leftPlan.ID == NoPlan {
+		plan.Reason = leftPlan.Reason
+		return plan
+	}
+	rightPlan := buildUnionSubplan(union.Right, schema)
+	if rightPlan.ID == NoPlan {
+		plan.Reason = rightPlan.Reason
+		return plan
+	}
+	plan.Table = leftPlan.Table
+	if leftPlan.ID == SelectUnsharded && rightPlan.ID == SelectUnsharded && leftPlan.Table.Keyspace.Name == rightPlan.Table.Keyspace.Name {
+		plan.ID = SelectUnsharded
+		return plan
+	}
+	plan.ID = id
+	plan.Left = leftPlan
+	plan.Right = rightPlan
+	return plan
+}
+
+func buildUnionSubplan(statement sqlparser.SelectStatement, schema *Schema) *Plan {
+	original := generateQuery(statement)
+	var plan *Plan
+	switch statement := statement.(type) {
+	case *sqlparser.Select:
+		// The order by and limit of the last select
+		// apply to the entire union.
+		if statement.OrderBy != nil || statement.Limit != nil {
+			return &Plan{ID: NoPlan, Reason: "order by or limit not allowed in union"}
+		}
+		plan = buildSelectPlan(statement, schema, nil)
+	case *sqlparser.Union:
+		plan = buildUnionPlan(statement, schema)
+	default:
+		panic("unexpected")
+	}
+	plan.Original = original
+	return plan
+}
@@ -0,0 +1,207 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package planbuilder
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DBColumns describes the tables of the database of a keyspace:
+// it maps the table names to their columns, which map to their
+// MySQL type, like "bigint(20) unsigned".
+type DBColumns map[string]map[string]string
+
+// ValidateSchema checks source against the databases of its
+// keyspaces, and returns the problems it found:
+//   - the schema must build, and its owners must be tables of
+//     their keyspace that use their vindex,
+//   - the tables must exist in the database of their keyspace,
+//   - the columns of the vindexes must exist, with a type the
+//     vindex accepts if it's Typed,
+//   - the Table of the params of a vindex, like the table of a
+//     lookup vindex, must be a table of the schema, or of current
+//     if it's not nil, and it must have the columns named by the
+//     Column, From and To params.
+//
+// A keyspace that's missing from databases is reported, and its
+// tables are not checked.
+func ValidateSchema(source *SchemaFormal, current *Schema, databases map[string]DBColumns) []string {
+	schema, err := BuildSchema(source)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var problems []string
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	for _, ksname := range sortedKeyspaces(source) {
+		ks := source.Keyspaces[ksname]
+		db, ok := databases[ksname]
+		if !ok {
+			report("no database schema for keyspace %s", ksname)
+		}
+		scoped := schema.InKeyspace(ksname)
+		for _, tname := range sortedTables(ks) {
+			table, _ := scoped.FindTable(tname)
+			if ai := table.AutoIncrement; ai != nil {
+				seq, _ := scoped.FindTable(ai.Sequence)
+				if seq == nil && current != nil {
+					seq, _ = current.FindTable(ai.Sequence)
+				}
+				if seq == nil {
+					report("sequence %s of table %s is not in the schema", ai.Sequence, tname)
+				} else if !seq.IsSequence {
+					report("table %s of the auto-increment of table %s is not a sequence", ai.Sequence, tname)
+				}
+			}
+			if db == nil {
+				continue
+			}
+			columns, ok := db[tname]
+			if !ok {
+				report("table %s not found in the database of keyspace %s", tname, ksname)
+				continue
+			}
+			for _, cv := range table.ColVindexes {
+				columnType, ok := columns[cv.Col]
+				if !ok {
+					report("column %s of vindex %s not found in table %s", cv.Col, cv.Name, tname)
+					continue
+				}
+				if typed, ok := cv.Vindex.(Typed); ok && !typed.AcceptsType(columnType) {
+					report("column %s of table %s has type %s, which vindex %s of type %s cannot map", cv.Col, tname, columnType, cv.Name, cv.Type)
+				}
+			}
+			if ai := table.AutoIncrement; ai != nil {
+				columnType, ok := columns[ai.Column]
+				if !ok {
+					report("auto-increment column %s not found in table %s", ai.Column, tname)
+				} else if !IsIntegralType(columnType) {
+					report("auto-increment column %s of table %s has type %s, which is not an integral type", ai.Column, tname, columnType)
+				}
+			}
+			if table.IsSequence {
+				for _, col := range []string{"id", "next_id", "cache"} {
+					if _, ok := columns[col]; !ok {
+						report("column %s of sequence %s not found in the database", col, tname)
+					}
+				}
+			}
+		}
+		for _, vname := range sortedVindexes(ks) {
+			vindexInfo := ks.Vindexes[vname]
+			if vindexInfo.Owner != "" && !usesVindex(ks, vindexInfo.Owner, vname) {
+				report("owner %s of vindex %s is not a table of keyspace %s that uses it", vindexInfo.Owner, vname, ksname)
+			}
+			lookup, _ := vindexInfo.Params["Table"].(string)
+			if lookup == "" {
+				continue
+			}
+			table, _ := schema.FindTable(lookup)
+			if table == nil && current != nil {
+				table, _ = current.FindTable(lookup)
+			}
+			if table == nil {
+				report("table %s of vindex %s is not in the schema", lookup, vname)
+				continue
+			}
+			db, ok := databases[table.Keyspace.Name]
+			if !ok {
+				if table.Keyspace.Name != ksname {
+					report("no database schema for keyspace %s of table %s of vindex %s", table.Keyspace.Name, lookup, vname)
+				}
+				continue
+			}
+			columns, ok := db[lookup]
+			if !ok {
+				report("table %s of vindex %s not found in the database of keyspace %s", lookup, vname, table.Keyspace.Name)
+				continue
+			}
+			for _, param := range []string{"Column", "From", "To"} {
+				col, _ := vindexInfo.Params[param].(string)
+				if col == "" {
+					continue
+				}
+				if _, ok := columns[col]; !ok {
+					report("column %s of vindex %s not found in table %s", col, vname, lookup)
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// usesVindex returns true if table tname of ks has a ColVindex of vname.
+func usesVindex(ks KeyspaceFormal, tname, vname string) bool {
+	table, ok := ks.Tables[tname]
+	if !ok {
+		return false
+	}
+	for _, cv := range table.ColVindexes {
+		if cv.Name == vname {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeyspaces(source *SchemaFormal) []string {
+	names := make([]string, 0, len(source.Keyspaces))
+	for name := range source.Keyspaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedTables(ks KeyspaceFormal) []string {
+	names := make([]string, 0, len(ks.Tables))
+	for name := range ks.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedVindexes(ks KeyspaceFormal) []string {
+	names := make([]string, 0, len(ks.Vindexes))
+	for name := range ks.Vindexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsIntegralType returns true if columnType is
+// a MySQL integer type, like "int(11) unsigned".
+func IsIntegralType(columnType string) bool {
+	switch baseType(columnType) {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint":
+		return true
+	}
+	return false
+}
+
+// IsTextType returns true if columnType is
+// a MySQL character type, like "varchar(64)".
+func IsTextType(columnType string) bool {
+	switch baseType(columnType) {
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext":
+		return true
+	}
+	return false
+}
+
+// baseType returns the lower case name of
+// columnType, without its length or attributes.
+func baseType(columnType string) string {
+	columnType = strings.ToLower(strings.TrimSpace(columnType))
+	if i := strings.IndexAny(columnType, "( "); i != -1 {
+		columnType = columnType[:i]
+	}
+	return columnType
+}
@@ -0,0 +1,200 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package planbuilder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stFT is a Functional, Unique and Typed vindex
+// that only maps integers.
+type stFT struct {
+	stFU
+}
+
+func (_ *stFT) AcceptsType(columnType string) bool { return IsIntegralType(columnType) }
+
+func NewSTFT(params map[string]interface{}) (Vindex, error) {
+	return &stFT{}, nil
+}
+
+func init() {
+	Register("stft", NewSTFT)
+}
+
+func validateSource() *SchemaFormal {
+	return &SchemaFormal{
+		Keyspaces: map[string]KeyspaceFormal{
+			"user": {
+				Sharded: true,
+				Vindexes: map[string]VindexFormal{
+					"user_index": {
+						Type:  "stft",
+						Owner: "user",
+					},
+					"name_user_map": {
+						Type:   "stln",
+						Owner:  "user",
+						Params: map[string]interface{}{"Table": "name_user", "From": "name", "To": "user_id"},
+					},
+				},
+				Tables: map[string]TableFormal{
+					"user": {
+						ColVindexes: []ColVindexFormal{
+							{Col: "id", Name: "user_index"},
+							{Col: "name", Name: "name_user_map"},
+						},
+					},
+				},
+			},
+			"lookup": {
+				Tables: map[string]TableFormal{
+					"name_user": {},
+				},
+			},
+		},
+	}
+}
+
+func validateDatabases() map[string]DBColumns {
+	return map[string]DBColumns{
+		"user": {
+			"user": {"id": "bigint(20) unsigned", "name": "varchar(64)"},
+		},
+		"lookup": {
+			"name_user": {"name": "varchar(64)", "user_id": "bigint(20) unsigned"},
+		},
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	problems := ValidateSchema(validateSource(), nil, validateDatabases())
+	if problems != nil {
+		t.Errorf("ValidateSchema: %v, want none", problems)
+	}
+}
+
+func TestValidateSchemaProblems(t *testing.T) {
+	source := validateSource()
+	user := source.Keyspaces["user"]
+	user.Vindexes["name_user_map"] = VindexFormal{
+		Type:   "stln",
+		Owner:  "user_extra",
+		Params: map[string]interface{}{"Table": "name_user", "From": "name", "To": "uid"},
+	}
+	user.Tables["user_extra"] = TableFormal{
+		ColVindexes: []ColVindexFormal{{Col: "user_id", Name: "user_index"}},
+	}
+	databases := validateDatabases()
+	databases["user"]["user"] = map[string]string{"id": "varchar(10)"}
+	problems := ValidateSchema(source, nil, databases)
+	want := []string{
+		"column id of table user has type varchar(10), which vindex user_index of type stft cannot map",
+		"column name of vindex name_user_map not found in table user",
+		"table user_extra not found in the database of keyspace user",
+		"owner user_extra of vindex name_user_map is not a table of keyspace user that uses it",
+		"column uid of vindex name_user_map not found in table name_user",
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("ValidateSchema:\n%s\nwant\n%s", strings.Join(problems, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestValidateSchemaCurrent(t *testing.T) {
+	source := validateSource()
+	delete(source.Keyspaces, "lookup")
+	databases := validateDatabases()
+	problems := ValidateSchema(source, nil, databases)
+	want := []string{"table name_user of vindex name_user_map is not in the schema"}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("ValidateSchema: %v, want %v", problems, want)
+	}
+
+	current, err := BuildSchema(validateSource())
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems = ValidateSchema(source, current, databases)
+	if problems != nil {
+		t.Errorf("ValidateSchema: %v, want none", problems)
+	}
+
+	delete(databases, "lookup")
+	problems = ValidateSchema(source, current, databases)
+	want = []string{"no database schema for keyspace lookup of table name_user of vindex name_user_map"}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("ValidateSchema: %v, want %v", problems, want)
+	}
+}
+
+func TestValidateSchemaBuildError(t *testing.T) {
+	source := validateSource()
+	source.Keyspaces["user"].Tables["user"].ColVindexes[0].Name = "noexist"
+	problems := ValidateSchema(source, nil, validateDatabases())
+	want := []string{"index noexist not found for table user"}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("ValidateSchema: %v, want %v", problems, want)
+	}
+}
+
+func TestValidateSchemaSequence(t *testing.T) {
+	source := validateSource()
+	user := source.Keyspaces["user"].Tables["user"]
+	user.AutoIncrement = &AutoIncrementFormal{Column: "id", Sequence: "user_seq"}
+	source.Keyspaces["user"].Tables["user"] = user
+	databases := validateDatabases()
+	problems := ValidateSchema(source, nil, databases)
+	want := []string{"sequence user_seq of table user is not in the schema"}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("ValidateSchema: %v, want %v", problems, want)
+	}
+
+	source.Keyspaces["lookup"].Tables["user_seq"] = TableFormal{Type: TypeSequence}
+	databases["lookup"]["user_seq"] = map[string]string{"id": "int(11)", "next_id": "bigint(20)", "cache": "bigint(20)"}
+	problems = ValidateSchema(source, nil, databases)
+	if problems != nil {
+		t.Errorf("ValidateSchema: %v, want none", problems)
+	}
+
+	user.AutoIncrement.Column = "name"
+	source.Keyspaces["lookup"].Tables["user_seq"] = TableFormal{}
+	delete(databases["lookup"]["user_seq"], "cache")
+	problems = ValidateSchema(source, nil, databases)
+	want = []string{
+		"table user_seq of the auto-increment of table user is not a sequence",
+		"auto-increment column name of table user has type varchar(64), which is not an integral type",
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("ValidateSchema:\n%s\nwant\n%s", strings.Join(problems, "\n"), strings.Join(want, "\n"))
+	}
+
+	source.Keyspaces["lookup"].Tables["user_seq"] = TableFormal{Type: TypeSequence}
+	user.AutoIncrement.Column = "id"
+	problems = ValidateSchema(source, nil, databases)
+	want = []string{"column cache of sequence user_seq not found in the database"}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("ValidateSchema: %v, want %v", problems, want)
+	}
+}
+
+func TestColumnTypes(t *testing.T) {
+	for _, typ := range []string{"int(11)", "BIGINT(20) UNSIGNED", "tinyint"} {
+		if !IsIntegralType(typ) {
+			t.Errorf("IsIntegralType(%q): false, want true", typ)
+		}
+	}
+	for _, typ := range []string{"varchar(64)", "TEXT", "char(1) binary"} {
+		if !IsTextType(typ) {
+			t.Errorf("IsTextType(%q): false, want true", typ)
+		}
+	}
+	for _, typ := range []string{"varbinary(64)", "decimal(10,2)", ""} {
+		if IsIntegralType(typ) || IsTextType(typ) {
+			t.Errorf("%q is integral or text, want neither", typ)
+		}
+	}
+}
@@ -38,12 +38,33 @@ func getWhereRouting(where *sqlparser.Where, plan *Plan, onlyUnique bool) {
 		plan.Values = values
 		return
 	}
+	var matches []*vindexMatch
 	for _, index := range plan.Table.Ordered {
 		if onlyUnique && !IsUnique(index.Vindex) {
 			continue
 		}
-		if planID, values := getMatch(where.Expr, index.Col); planID != SelectScatter {
-			plan.ID = planID
+		if planID, values, in := getMatch(where.Expr, index.Col); planID != SelectScatter {
+			matches = append(matches, &vindexMatch{index, planID, values, in})
+		}
+	}
+	if best := chooseMatch(matches); best != nil {
+		if best.in != nil {
+			best.in.Right = sqlparser.ListArg("::" + ListVarName)
+		}
+		plan.ID = best.planID
+		plan.ColVindex = best.index
+		plan.Values = best.values
+		if len(matches) > 1 {
+			plan.VindexChoice = vindexChoice(best, matches)
+		}
+		return
+	}
+	for _, index := range plan.Table.Ordered {
+		if _, ok := index.Vindex.(Ranged); !ok {
+			continue
+		}
+		if values := getRangeMatch(where.Expr, index.Col); values != nil {
+			plan.ID = SelectRange
 			plan.ColVindex = index
 			plan.Values = values
 			return
@@ -165,14 +186,77 @@ func getKeyrangeFromBool(node sqlparser.BoolExpr) (newnode sqlparser.BoolExpr, v
 	return node, nil, nil
 }
 
-func getMatch(node sqlparser.BoolExpr, col string) (planID PlanID, values interface{}) {
+// vindexMatch is a ColVindex that can route a where clause.
+// in is the IN condition that matched, if any. It gets
+// rewritten to use a list bind var only if the match is
+// chosen for the routing.
+type vindexMatch struct {
+	index  *ColVindex
+	planID PlanID
+	values interface{}
+	in     *sqlparser.ComparisonExpr
+}
+
+// cheaper returns true if m is a cheaper way to route the
+// query than other. A unique vindex is preferred because it
+// maps each value to a single shard. Then, the cheaper vindex
+// is preferred: computing a keyspace id is better than looking
+// it up. Finally, an equality is preferred over an IN clause.
+func (m *vindexMatch) cheaper(other *vindexMatch) bool {
+	if unique, otherUnique := IsUnique(m.index.Vindex), IsUnique(other.index.Vindex); unique != otherUnique {
+		return unique
+	}
+	if cost, otherCost := m.index.Vindex.Cost(), other.index.Vindex.Cost(); cost != otherCost {
+		return cost < otherCost
+	}
+	return m.planID == SelectEqual && other.planID == SelectIN
+}
+
+func (m *vindexMatch) String() string {
+	uniqueness := "non-unique"
+	if IsUnique(m.index.Vindex) {
+		uniqueness = "unique"
+	}
+	return fmt.Sprintf("%s on %s (%s, %s, cost %d)", m.index.Name, m.index.Col, m.planID, uniqueness, m.index.Vindex.Cost())
+}
+
+// chooseMatch returns the cheapest of matches, or nil
+// if there are none.
+func chooseMatch(matches []*vindexMatch) *vindexMatch {
+	var best *vindexMatch
+	for _, m := range matches {
+		if best == nil || m.cheaper(best) {
+			best = m
+		}
+	}
+	return best
+}
+
+// vindexChoice describes why best was chosen among matches.
+func vindexChoice(best *vindexMatch, matches []*vindexMatch) string {
+	choice := "chose " + best.String()
+	sep := " over "
+	for _, m := range matches {
+		if m == best {
+			continue
+		}
+		choice += sep + m.String()
+		sep = ", "
+	}
+	return choice
+}
+
+// getMatch returns the plan id and values for routing node
+// with the vindex on col. If the match is an IN condition, it
+// is returned as in, so it can be rewritten if used.
+func getMatch(node sqlparser.BoolExpr, col string) (planID PlanID, values interface{}, in *sqlparser.ComparisonExpr) {
 	switch node := node.(type) {
 	case *sqlparser.AndExpr:
-		if planID, values = getMatch(node.Left, col); planID != SelectScatter {
-			return planID, values
+		if planID, values, in = getMatch(node.Left, col); planID != SelectScatter {
+			return planID, values, in
 		}
-		if planID, values = getMatch(node.Right, col); planID != SelectScatter {
-			return planID, values
+		if planID, values, in = getMatch(node.Right, col); planID != SelectScatter {
+			return planID, values, in
 		}
 	case *sqlparser.ParenBoolExpr:
 		return getMatch(node.Expr, col)
@@ -180,32 +264,105 @@ func getMatch(node sqlparser.BoolExpr, col string) (planID PlanID, values interf
 		switch node.Operator {
 		case "=":
 			if !nameMatch(node.Left, col) {
-				return SelectScatter, nil
+				return SelectScatter, nil, nil
 			}
 			if !sqlparser.IsValue(node.Right) {
-				return SelectScatter, nil
+				return SelectScatter, nil, nil
 			}
 			val, err := asInterface(node.Right)
 			if err != nil {
-				return SelectScatter, nil
+				return SelectScatter, nil, nil
 			}
-			return SelectEqual, val
+			return SelectEqual, val, nil
 		case "in":
 			if !nameMatch(node.Left, col) {
-				return SelectScatter, nil
+				return SelectScatter, nil, nil
 			}
 			if !sqlparser.IsSimpleTuple(node.Right) {
-				return SelectScatter, nil
+				return SelectScatter, nil, nil
 			}
 			val, err := asInterface(node.Right)
 			if err != nil {
-				return SelectScatter, nil
+				return SelectScatter, nil, nil
+			}
+			return SelectIN, val, node
+		}
+	}
+	return SelectScatter, nil, nil
+}
+
+// getRangeMatch returns the lower and upper bounds of col
+// if node restricts it to a range, or nil otherwise. A nil
+// bound means that the range is unbounded on that side. The
+// bounds are inclusive, even if the comparison isn't, because
+// that still selects all the shards that can contain the rows.
+func getRangeMatch(node sqlparser.BoolExpr, col string) (values []interface{}) {
+	var bounds [2]sqlparser.ValExpr
+	findRangeBounds(node, col, &bounds)
+	if bounds[0] == nil && bounds[1] == nil {
+		return nil
+	}
+	values = make([]interface{}, 2)
+	for i, bound := range bounds {
+		if bound == nil {
+			continue
+		}
+		val, err := asInterface(bound)
+		if err != nil {
+			return nil
+		}
+		values[i] = val
+	}
+	return values
+}
+
+// findRangeBounds sets the lower and upper bounds of col
+// from the conditions of node. The first bound found for
+// each side is used.
+func findRangeBounds(node sqlparser.BoolExpr, col string, bounds *[2]sqlparser.ValExpr) {
+	setBound := func(i int, val sqlparser.ValExpr) {
+		if bounds[i] == nil && sqlparser.IsValue(val) {
+			bounds[i] = val
+		}
+	}
+	switch node := node.(type) {
+	case *sqlparser.AndExpr:
+		findRangeBounds(node.Left, col, bounds)
+		findRangeBounds(node.Right, col, bounds)
+	case *sqlparser.ParenBoolExpr:
+		findRangeBounds(node.Expr, col, bounds)
+	case *sqlparser.RangeCond:
+		if node.Operator != sqlparser.AST_BETWEEN || !nameMatch(node.Left, col) {
+			return
+		}
+		setBound(0, node.From)
+		setBound(1, node.To)
+	case *sqlparser.ComparisonExpr:
+		left, right, operator := node.Left, node.Right, node.Operator
+		if !nameMatch(left, col) {
+			// Turn "val < col" into "col > val".
+			left, right = right, left
+			switch operator {
+			case sqlparser.AST_LT:
+				operator = sqlparser.AST_GT
+			case sqlparser.AST_LE:
+				operator = sqlparser.AST_GE
+			case sqlparser.AST_GT:
+				operator = sqlparser.AST_LT
+			case sqlparser.AST_GE:
+				operator = sqlparser.AST_LE
 			}
-			node.Right = sqlparser.ListArg("::" + ListVarName)
-			return SelectIN, val
+		}
+		if !nameMatch(left, col) {
+			return
+		}
+		switch operator {
+		case sqlparser.AST_GT, sqlparser.AST_GE:
+			setBound(0, right)
+		case sqlparser.AST_LT, sqlparser.AST_LE:
+			setBound(1, right)
 		}
 	}
-	return SelectScatter, nil
 }
 
 func nameMatch(node sqlparser.ValExpr, col string) bool {
@@ -9,48 +9,373 @@ package vtgate
 import (
 	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/youtube/vitess/go/acl"
 	"github.com/youtube/vitess/go/cache"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/vt/sqlparser"
 	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
 )
 
+var (
+	planCacheSize    = flag.Int("plan_cache_size", 5000, "maximum number of plans in the vtgate plan cache, unless plan_cache_memory is set")
+	planCacheMemory  = flag.Int("plan_cache_memory", 0, "maximum estimated memory in bytes of the plans in the vtgate plan cache, 0 limits the number of plans instead")
+	normalizeQueries = flag.Bool("normalize_queries", false, "replace the literals of queries with bind vars before they're planned, so that the queries that only differ by their values share a plan")
+)
+
+// normalizedPrefix is the prefix of the names of the bind
+// vars that replace the literals of normalized queries.
+const normalizedPrefix = "_vtg"
+
+// planOverhead is the estimated memory of a plan,
+// on top of the memory of its queries.
+const planOverhead = 512
+
 var noPlan = &planbuilder.Plan{
 	ID:     planbuilder.NoPlan,
 	Reason: "planbuiler not initialized",
 }
 
+// planLatencyCutoffs are the upper bounds, in nanoseconds, of
+// the buckets of the latency histograms of the plans.
+var planLatencyCutoffs = []int64{1e6, 5e6, 10e6, 50e6, 100e6, 500e6, 1e9, 5e9}
+
+// cachedPlan is a plan of the cache, with the
+// stats of the queries that were executed with it.
+type cachedPlan struct {
+	plan *planbuilder.Plan
+	size int
+	// fingerprint is the fingerprint of the query of the plan.
+	fingerprint string
+
+	mu         sync.Mutex
+	queryCount int64
+	time       time.Duration
+	rowCount   int64
+	shardCount int64
+	errorCount int64
+	latency    *stats.Histogram
+}
+
+func newCachedPlan(plan *planbuilder.Plan) *cachedPlan {
+	return &cachedPlan{
+		plan:    plan,
+		size:    1,
+		latency: stats.NewHistogram("", planLatencyCutoffs),
+	}
+}
+
+// Size is part of the cache.Value interface.
+func (cp *cachedPlan) Size() int {
+	return cp.size
+}
+
+func (cp *cachedPlan) addStats(duration time.Duration, rowCount, shardCount int64, err error) {
+	cp.latency.Add(int64(duration))
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.queryCount++
+	cp.time += duration
+	cp.rowCount += rowCount
+	cp.shardCount += shardCount
+	if err != nil {
+		cp.errorCount++
+	}
+}
+
+func (cp *cachedPlan) stats() (queryCount int64, duration time.Duration, rowCount, shardCount, errorCount int64) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.queryCount, cp.time, cp.rowCount, cp.shardCount, cp.errorCount
+}
+
 type Planner struct {
-	schema *planbuilder.Schema
-	plans  *cache.LRUCache
+	plans *cache.LRUCache
+	// bySize is true if the capacity of plans is in
+	// estimated bytes instead of a number of plans.
+	bySize bool
+
+	// mu serializes the additions to plans, so that the
+	// evictions can be counted, and protects schema.
+	mu sync.Mutex
+	// schema is the schema the plans are built with. version
+	// is incremented when it changes, which clears plans.
+	schema        *planbuilder.Schema
+	version       int64
+	invalidations sync2.AtomicInt64
+
+	hits      sync2.AtomicInt64
+	misses    sync2.AtomicInt64
+	evictions sync2.AtomicInt64
+
+	// top keeps the heaviest query fingerprints, even
+	// those whose plans were evicted from the cache.
+	top *queryTopK
 }
 
-func NewPlanner(schema *planbuilder.Schema, cacheSize int) *Planner {
+// NewPlanner creates a Planner that caches up to cacheSize plans,
+// or cacheMemory bytes of plans if cacheMemory is not 0. If
+// statsName is not empty, the stats of the cache are exported
+// under that prefix, and the debug pages of the plans are served.
+func NewPlanner(schema *planbuilder.Schema, cacheSize, cacheMemory int, statsName string) *Planner {
 	plr := &Planner{
 		schema: schema,
 		plans:  cache.NewLRUCache(int64(cacheSize)),
+		top:    newQueryTopK(*queryStatsTopK),
+	}
+	if cacheMemory != 0 {
+		plr.plans.SetCapacity(int64(cacheMemory))
+		plr.bySize = true
+	}
+	if statsName != "" {
+		stats.Publish(statsName+"PlanCacheLength", stats.IntFunc(plr.plans.Length))
+		stats.Publish(statsName+"PlanCacheSize", stats.IntFunc(plr.plans.Size))
+		stats.Publish(statsName+"PlanCacheCapacity", stats.IntFunc(plr.plans.Capacity))
+		stats.Publish(statsName+"PlanCacheHits", stats.IntFunc(plr.hits.Get))
+		stats.Publish(statsName+"PlanCacheMisses", stats.IntFunc(plr.misses.Get))
+		stats.Publish(statsName+"PlanCacheEvictions", stats.IntFunc(plr.evictions.Get))
+		stats.Publish(statsName+"PlanCacheInvalidations", stats.IntFunc(plr.invalidations.Get))
+		stats.Publish(statsName+"SchemaVersion", stats.IntFunc(plr.SchemaVersion))
+		http.Handle("/debug/query_plans", plr)
+		http.Handle("/debug/query_stats", plr)
+		http.Handle("/debug/query_stats/top", plr)
+		http.Handle("/debug/plan_cache", plr)
+		http.Handle("/debug/schema", plr)
 	}
-	// TODO(sougou): Uncomment after making Planner testable.
-	//http.Handle("/debug/query_plans", plr)
-	//http.Handle("/debug/schema", plr)
 	return plr
 }
 
+// GetPlan returns the plan of sql, from the cache if it was already
+// built. The plans of the statements with the SKIP_CACHE hint are
+// not cached.
 func (plr *Planner) GetPlan(sql string) *planbuilder.Plan {
-	if plr.schema == nil {
+	return plr.GetPlanIn(sql, "")
+}
+
+// GetPlanIn is like GetPlan, but the unqualified tables of sql are
+// resolved in keyspace first, if it's not empty.
+func (plr *Planner) GetPlanIn(sql, keyspace string) *planbuilder.Plan {
+	schema, version := plr.schemaVersion()
+	key := planKey(schema, sql, keyspace)
+	if result, ok := plr.plans.Get(key); ok {
+		plr.hits.Add(1)
+		return result.(*cachedPlan).plan
+	}
+	if schema == nil {
 		return noPlan
 	}
-	if result, ok := plr.plans.Get(sql); ok {
-		return result.(*planbuilder.Plan)
+	plr.misses.Add(1)
+	plan := planbuilder.BuildPlan(sql, schema.InKeyspace(keyspace))
+	if plan.Hints != nil && plan.Hints.SkipCache {
+		return plan
+	}
+	cp := newCachedPlan(plan)
+	if plr.top != nil {
+		cp.fingerprint = queryFingerprint(sql)
+	}
+	if plr.bySize {
+		cp.size = planOverhead + len(key) + len(plan.Rewritten) + len(plan.Subquery)
+	}
+	plr.mu.Lock()
+	defer plr.mu.Unlock()
+	if version != plr.version {
+		// The schema changed while the plan was built.
+		return plan
+	}
+	length := plr.plans.Length()
+	if _, ok := plr.plans.Get(key); !ok {
+		length++
+	}
+	plr.plans.Set(key, cp)
+	if evicted := length - plr.plans.Length(); evicted > 0 {
+		plr.evictions.Add(evicted)
 	}
-	plan := planbuilder.BuildPlan(sql, plr.schema)
-	plr.plans.Set(sql, plan)
 	return plan
 }
 
+// planKey returns the key of the plan of sql in the cache, when its
+// tables are resolved in keyspace first. The plans only depend on
+// keyspace if some tables of schema are ambiguous.
+func planKey(schema *planbuilder.Schema, sql, keyspace string) string {
+	if keyspace == "" || schema == nil || len(schema.Ambiguous) == 0 {
+		return sql
+	}
+	// No query starts with a NUL byte.
+	return "\x00" + keyspace + "\x00" + sql
+}
+
+// Schema returns the schema the plans are built with.
+func (plr *Planner) Schema() *planbuilder.Schema {
+	schema, _ := plr.schemaVersion()
+	return schema
+}
+
+// SchemaVersion returns the number of times the schema was changed.
+func (plr *Planner) SchemaVersion() int64 {
+	_, version := plr.schemaVersion()
+	return version
+}
+
+func (plr *Planner) schemaVersion() (*planbuilder.Schema, int64) {
+	plr.mu.Lock()
+	defer plr.mu.Unlock()
+	return plr.schema, plr.version
+}
+
+// SetSchema replaces the schema the plans are built with. The
+// cached plans refer to the tables and vindexes of the previous
+// schema, so they're all invalidated: the queries are planned
+// again with the new schema.
+func (plr *Planner) SetSchema(schema *planbuilder.Schema) {
+	plr.mu.Lock()
+	defer plr.mu.Unlock()
+	plr.schema = schema
+	plr.version++
+	plr.invalidations.Add(plr.plans.Length())
+	plr.plans.Clear()
+}
+
+// Normalize replaces the literal values of sql with bind vars,
+// which are added to bindVars, and returns the new query. Queries
+// that only differ by their values are then planned once. The
+// values that the planner needs to see are left in place: those
+// of the limit, order by and group by clauses, and of keyrange
+// expressions. Only the strings and the decimal integers are
+// replaced. A query that can't be parsed is returned as is.
+func (plr *Planner) Normalize(sql string, bindVars map[string]interface{}) string {
+	stmt, err := sqlparser.Parse(sql)
+	if err != nil {
+		return sql
+	}
+	switch stmt.(type) {
+	case *sqlparser.Select, *sqlparser.Union, *sqlparser.Insert, *sqlparser.Update, *sqlparser.Delete:
+	default:
+		return sql
+	}
+	counter := 0
+	literals := 0
+	keep := 0
+	buf := sqlparser.NewTrackedBuffer(func(buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+		switch node := node.(type) {
+		case *sqlparser.Limit, sqlparser.OrderBy, sqlparser.GroupBy, *sqlparser.KeyrangeExpr:
+			keep++
+			node.Format(buf)
+			keep--
+			return
+		case sqlparser.StrVal, sqlparser.NumVal:
+			if keep != 0 {
+				break
+			}
+			val, ok := literalValue(node)
+			if !ok {
+				break
+			}
+			var name string
+			for {
+				counter++
+				name = fmt.Sprintf("%s%d", normalizedPrefix, counter)
+				if _, ok := bindVars[name]; !ok {
+					break
+				}
+			}
+			bindVars[name] = val
+			literals++
+			sqlparser.ValArg(":" + name).Format(buf)
+			return
+		}
+		node.Format(buf)
+	})
+	buf.Myprintf("%v", stmt)
+	if literals == 0 {
+		return sql
+	}
+	return buf.String()
+}
+
+// literalValue returns the bind var value of a literal, which
+// has the type the planner gives to the same literal in a query.
+func literalValue(node sqlparser.SQLNode) (interface{}, bool) {
+	switch node := node.(type) {
+	case sqlparser.StrVal:
+		return []byte(node), true
+	case sqlparser.NumVal:
+		for _, c := range node {
+			if c < '0' || c > '9' {
+				return nil, false
+			}
+		}
+		if val, err := strconv.ParseInt(string(node), 10, 64); err == nil {
+			return val, true
+		}
+		if val, err := strconv.ParseUint(string(node), 10, 64); err == nil {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+// AddStats records the execution of a query with the plan of sql
+// in keyspace, if it's still in the cache. shardCount is the number
+// of shards the query was sent to.
+func (plr *Planner) AddStats(sql, keyspace string, duration time.Duration, rowCount, shardCount int64, err error) {
+	result, ok := plr.plans.Get(planKey(plr.Schema(), sql, keyspace))
+	if ok {
+		result.(*cachedPlan).addStats(duration, rowCount, shardCount, err)
+	}
+	if plr.top == nil {
+		return
+	}
+	var fingerprint string
+	if ok {
+		fingerprint = result.(*cachedPlan).fingerprint
+	} else {
+		fingerprint = queryFingerprint(sql)
+	}
+	plr.top.add(fingerprint, duration, rowCount, err)
+}
+
+// SetCacheCapacity changes the capacity of the plan cache, which
+// is in plans, or in bytes if the cache is limited by memory.
+// The plans that don't fit anymore are evicted.
+func (plr *Planner) SetCacheCapacity(capacity int) error {
+	if capacity <= 0 {
+		return fmt.Errorf("plan cache capacity %v out of range", capacity)
+	}
+	plr.mu.Lock()
+	defer plr.mu.Unlock()
+	length := plr.plans.Length()
+	plr.plans.SetCapacity(int64(capacity))
+	if evicted := length - plr.plans.Length(); evicted > 0 {
+		plr.evictions.Add(evicted)
+	}
+	return nil
+}
+
+// perPlanStats are the stats of a plan served by /debug/query_stats.
+type perPlanStats struct {
+	Query      string
+	Plan       planbuilder.PlanID
+	Table      string
+	QueryCount int64
+	Time       time.Duration
+	RowCount   int64
+	ShardCount int64
+	ErrorCount int64
+	Latency    *stats.Histogram
+}
+
 func (plr *Planner) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	if request.URL.Path == "/debug/plan_cache" {
+		plr.servePlanCache(response, request)
+		return
+	}
 	if err := acl.CheckAccessHTTP(request, acl.DEBUGGING); err != nil {
 		acl.SendError(response, err)
 		return
@@ -61,18 +386,59 @@ func (plr *Planner) ServeHTTP(response http.ResponseWriter, request *http.Reques
 		response.Write([]byte(fmt.Sprintf("Length: %d\n", len(keys))))
 		for _, v := range keys {
 			response.Write([]byte(fmt.Sprintf("%#v\n", v)))
-			if plan, ok := plr.plans.Get(v); ok {
-				if b, err := json.MarshalIndent(plan, "", "  "); err != nil {
+			if result, ok := plr.plans.Get(v); ok {
+				cp := result.(*cachedPlan)
+				if b, err := json.MarshalIndent(cp.plan, "", "  "); err != nil {
 					response.Write([]byte(err.Error()))
 				} else {
 					response.Write(b)
 				}
+				queryCount, duration, rowCount, shardCount, errorCount := cp.stats()
+				response.Write([]byte(fmt.Sprintf("\nQueryCount: %d, Time: %v, RowCount: %d, ShardCount: %d, ErrorCount: %d, Latency: %v", queryCount, duration, rowCount, shardCount, errorCount, cp.latency)))
 				response.Write(([]byte)("\n\n"))
 			}
 		}
+	} else if request.URL.Path == "/debug/query_stats" {
+		keys := plr.plans.Keys()
+		response.Header().Set("Content-Type", "application/json; charset=utf-8")
+		pstats := make([]perPlanStats, 0, len(keys))
+		for _, v := range keys {
+			if result, ok := plr.plans.Get(v); ok {
+				cp := result.(*cachedPlan)
+				pps := perPlanStats{
+					Query:   v,
+					Plan:    cp.plan.ID,
+					Latency: cp.latency,
+				}
+				if cp.plan.Table != nil {
+					pps.Table = cp.plan.Table.Name
+				}
+				pps.QueryCount, pps.Time, pps.RowCount, pps.ShardCount, pps.ErrorCount = cp.stats()
+				pstats = append(pstats, pps)
+			}
+		}
+		if b, err := json.MarshalIndent(pstats, "", "  "); err != nil {
+			response.Write([]byte(err.Error()))
+		} else {
+			response.Write(b)
+		}
+	} else if request.URL.Path == "/debug/query_stats/top" {
+		if request.FormValue("reset") == "true" {
+			if err := acl.CheckAccessHTTP(request, acl.ADMIN); err != nil {
+				acl.SendError(response, err)
+				return
+			}
+			plr.top.reset()
+		}
+		response.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if b, err := json.MarshalIndent(plr.top.stats(), "", "  "); err != nil {
+			response.Write([]byte(err.Error()))
+		} else {
+			response.Write(b)
+		}
 	} else if request.URL.Path == "/debug/schema" {
 		response.Header().Set("Content-Type", "application/json; charset=utf-8")
-		b, err := json.MarshalIndent(plr.schema, "", " ")
+		b, err := json.MarshalIndent(plr.Schema(), "", " ")
 		if err != nil {
 			response.Write([]byte(err.Error()))
 			return
@@ -84,3 +450,33 @@ func (plr *Planner) ServeHTTP(response http.ResponseWriter, request *http.Reques
 		response.WriteHeader(http.StatusNotFound)
 	}
 }
+
+// servePlanCache serves /debug/plan_cache, which shows the
+// capacity of the plan cache and its stats. An admin can change
+// the capacity with the capacity parameter.
+func (plr *Planner) servePlanCache(response http.ResponseWriter, request *http.Request) {
+	if capacity := request.FormValue("capacity"); capacity != "" {
+		if err := acl.CheckAccessHTTP(request, acl.ADMIN); err != nil {
+			acl.SendError(response, err)
+			return
+		}
+		c, err := strconv.Atoi(capacity)
+		if err == nil {
+			err = plr.SetCacheCapacity(c)
+		}
+		if err != nil {
+			http.Error(response, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if err := acl.CheckAccessHTTP(request, acl.DEBUGGING); err != nil {
+		acl.SendError(response, err)
+		return
+	}
+	unit := "plans"
+	if plr.bySize {
+		unit = "bytes"
+	}
+	response.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(response, "{\"Length\": %d, \"Size\": %d, \"Capacity\": %d, \"Unit\": %q, \"Hits\": %d, \"Misses\": %d, \"Evictions\": %d}\n",
+		plr.plans.Length(), plr.plans.Size(), plr.plans.Capacity(), unit, plr.hits.Get(), plr.misses.Get(), plr.evictions.Get())
+}
@@ -0,0 +1,231 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestPlannerCache(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plr := NewPlanner(schema, 2, 0, "")
+	plr.GetPlan("select * from user where id = 1")
+	plr.GetPlan("select * from user where id = 1")
+	plr.GetPlan("select * from user where id = 2")
+	plr.GetPlan("select * from user where id = 3")
+	if hits, misses, evictions := plr.hits.Get(), plr.misses.Get(), plr.evictions.Get(); hits != 1 || misses != 3 || evictions != 1 {
+		t.Errorf("hits, misses, evictions: %d, %d, %d, want 1, 3, 1", hits, misses, evictions)
+	}
+	if err := plr.SetCacheCapacity(0); err == nil {
+		t.Errorf("SetCacheCapacity(0): nil, want error")
+	}
+	if err := plr.SetCacheCapacity(1); err != nil {
+		t.Fatal(err)
+	}
+	if length, evictions := plr.plans.Length(), plr.evictions.Get(); length != 1 || evictions != 2 {
+		t.Errorf("length, evictions: %d, %d, want 1, 2", length, evictions)
+	}
+
+	// A cache limited by memory holds fewer plans of long queries.
+	plr = NewPlanner(schema, 0, 2*planOverhead+100, "")
+	plr.GetPlan("select * from user where id = 1")
+	plr.GetPlan(fmt.Sprintf("select * from user where name = '%s'", strings.Repeat("a", 200)))
+	if length := plr.plans.Length(); length != 1 {
+		t.Errorf("length: %d, want 1", length)
+	}
+}
+
+func TestPlannerStats(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plr := NewPlanner(schema, 10, 0, "")
+	sql := "select * from user where id = 1"
+	plr.GetPlan(sql)
+	plr.AddStats(sql, "", 2*time.Millisecond, 3, 1, nil)
+	plr.AddStats(sql, "", 1*time.Millisecond, 0, 1, fmt.Errorf("err"))
+	plr.AddStats("select * from user where id = 2", "", 1*time.Millisecond, 1, 1, nil)
+
+	response := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/debug/query_stats", nil)
+	plr.ServeHTTP(response, request)
+	var pstats []struct {
+		Query      string
+		Plan       string
+		Table      string
+		QueryCount int64
+		Time       time.Duration
+		RowCount   int64
+		ShardCount int64
+		ErrorCount int64
+		Latency    map[string]int64
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &pstats); err != nil {
+		t.Fatalf("%v: %s", err, response.Body.String())
+	}
+	if len(pstats) != 1 {
+		t.Fatalf("query_stats: %+v, want 1 plan", pstats)
+	}
+	got := pstats[0]
+	if got.Query != sql || got.Plan != "SelectEqual" || got.Table != "user" {
+		t.Errorf("query_stats: %+v, want the SelectEqual plan of user", got)
+	}
+	if got.QueryCount != 2 || got.Time != 3*time.Millisecond || got.RowCount != 3 || got.ShardCount != 2 || got.ErrorCount != 1 {
+		t.Errorf("query_stats: %+v, want 2 queries in 3ms, 3 rows, 2 shards, 1 error", got)
+	}
+	if got.Latency["1000000"] != 1 || got.Latency["5000000"] != 2 {
+		t.Errorf("query_stats latency: %v, want 1 query within 1ms, 2 within 5ms", got.Latency)
+	}
+
+	response = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/debug/plan_cache?capacity=5", nil)
+	plr.ServeHTTP(response, request)
+	if plr.plans.Capacity() != 5 {
+		t.Errorf("capacity: %d, want 5", plr.plans.Capacity())
+	}
+	if body := response.Body.String(); !strings.Contains(body, `"Capacity": 5`) {
+		t.Errorf("plan_cache: %s, want a capacity of 5", body)
+	}
+	response = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/debug/plan_cache?capacity=x", nil)
+	plr.ServeHTTP(response, request)
+	if response.Code != 400 {
+		t.Errorf("plan_cache with an invalid capacity: %d, want 400", response.Code)
+	}
+}
+
+func TestPlannerNormalize(t *testing.T) {
+	plr := NewPlanner(nil, 10, 0, "")
+	testcases := []struct {
+		in       string
+		bindVars map[string]interface{}
+		out      string
+		want     map[string]interface{}
+	}{{
+		in:   "select * from user where id = 1 and name = 'foo'",
+		out:  "select * from user where id = :_vtg1 and name = :_vtg2",
+		want: map[string]interface{}{"_vtg1": int64(1), "_vtg2": []byte("foo")},
+	}, {
+		in:       "select * from user where id = 1 and name = :_vtg1",
+		bindVars: map[string]interface{}{"_vtg1": "foo"},
+		out:      "select * from user where id = :_vtg2 and name = :_vtg1",
+		want:     map[string]interface{}{"_vtg1": "foo", "_vtg2": int64(1)},
+	}, {
+		in:   "select a, count(*) from user where id in (1, 18446744073709551615) group by 1 order by 2 asc limit 10",
+		out:  "select a, count(*) from user where id in (:_vtg1, :_vtg2) group by 1 order by 2 asc limit 10",
+		want: map[string]interface{}{"_vtg1": int64(1), "_vtg2": uint64(18446744073709551615)},
+	}, {
+		in:   "insert into user(id, name) values (1.5, 0x10)",
+		out:  "insert into user(id, name) values (1.5, 0x10)",
+		want: map[string]interface{}{},
+	}, {
+		in:   "update user set a = 2 where id = :id",
+		out:  "update user set a = :_vtg1 where id = :id",
+		want: map[string]interface{}{"_vtg1": int64(2)},
+	}, {
+		in:   "set autocommit = 1",
+		out:  "set autocommit = 1",
+		want: map[string]interface{}{},
+	}}
+	for _, tcase := range testcases {
+		bindVars := tcase.bindVars
+		if bindVars == nil {
+			bindVars = make(map[string]interface{})
+		}
+		if out := plr.Normalize(tcase.in, bindVars); out != tcase.out {
+			t.Errorf("Normalize(%s): %s, want %s", tcase.in, out, tcase.out)
+		}
+		if !reflect.DeepEqual(bindVars, tcase.want) {
+			t.Errorf("Normalize(%s) bind vars: %v, want %v", tcase.in, bindVars, tcase.want)
+		}
+	}
+}
+
+func TestRouterNormalize(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	router.normalizeQueries = true
+	for _, sql := range []string{"select * from user where id = 1", "select * from user where id = 3"} {
+		if _, err := router.Execute(context.Background(), &proto.Query{Sql: sql, TabletType: topo.TYPE_MASTER}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantQuery := "select * from user where id = :_vtg1"
+	if len(sbc1.Queries) != 1 || sbc1.Queries[0] != wantQuery {
+		t.Errorf("sbc1.Queries: %v, want %s", sbc1.Queries, wantQuery)
+	}
+	if len(sbc2.Queries) != 1 || sbc2.Queries[0] != wantQuery {
+		t.Errorf("sbc2.Queries: %v, want %s", sbc2.Queries, wantQuery)
+	}
+	wantBind := map[string]interface{}{"_vtg1": int64(3)}
+	if !reflect.DeepEqual(sbc2.BindVars[0], wantBind) {
+		t.Errorf("sbc2.BindVars[0]: %v, want %v", sbc2.BindVars[0], wantBind)
+	}
+	if hits, misses := router.planner.hits.Get(), router.planner.misses.Get(); hits != 1 || misses != 1 {
+		t.Errorf("hits, misses: %d, %d, want 1, 1", hits, misses)
+	}
+}
+
+func TestPlannerSetSchema(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plr := NewPlanner(nil, 10, 0, "")
+	if plan := plr.GetPlan("select * from user where id = 1"); plan.ID != planbuilder.NoPlan {
+		t.Errorf("GetPlan without a schema: %v, want NoPlan", plan.ID)
+	}
+	plr.SetSchema(schema)
+	plan := plr.GetPlan("select * from user where id = 1")
+	if plan.ID != planbuilder.SelectEqual {
+		t.Errorf("GetPlan: %v, want SelectEqual", plan.ID)
+	}
+	if version := plr.SchemaVersion(); version != 1 {
+		t.Errorf("SchemaVersion: %d, want 1", version)
+	}
+
+	// The plans of the previous schema are not used anymore.
+	newSchema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plr.SetSchema(newSchema)
+	if length, invalidations := plr.plans.Length(), plr.invalidations.Get(); length != 0 || invalidations != 1 {
+		t.Errorf("length, invalidations: %d, %d, want 0, 1", length, invalidations)
+	}
+	newPlan := plr.GetPlan("select * from user where id = 1")
+	if newPlan == plan || newPlan.Table != newSchema.Tables["user"] {
+		t.Errorf("GetPlan after SetSchema returned a plan of the previous schema")
+	}
+	if version := plr.SchemaVersion(); version != 2 {
+		t.Errorf("SchemaVersion: %d, want 2", version)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/youtube/vitess/go/cache"
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+var preparedStatementsMax = flag.Int("prepared_statements_max", 10000, "maximum number of prepared statements, beyond which the least recently used ones are released")
+
+// preparedStatement is a query planned by PrepareQuery.
+type preparedStatement struct {
+	sql    string
+	params []proto.PreparedParam
+	plan   *planbuilder.Plan
+	// version is the version of the schema plan was built with.
+	version int64
+}
+
+// Size is part of the cache.Value interface.
+func (ps *preparedStatement) Size() int {
+	return 1
+}
+
+// preparedRegistry keeps track of the prepared statements. The
+// least recently used statements are released when there are
+// too many of them: executing them then fails, and the client
+// has to prepare them again.
+type preparedRegistry struct {
+	lastID     sync2.AtomicInt64
+	statements *cache.LRUCache
+}
+
+func newPreparedRegistry(capacity int) *preparedRegistry {
+	return &preparedRegistry{
+		statements: cache.NewLRUCache(int64(capacity)),
+	}
+}
+
+func (pr *preparedRegistry) get(id int64) *preparedStatement {
+	if ps, ok := pr.statements.Get(strconv.FormatInt(id, 10)); ok {
+		return ps.(*preparedStatement)
+	}
+	return nil
+}
+
+func (pr *preparedRegistry) set(id int64, ps *preparedStatement) {
+	pr.statements.Set(strconv.FormatInt(id, 10), ps)
+}
+
+// PrepareQuery plans sql, and returns the id of the prepared
+// statement, with the bind vars it must be executed with.
+// The queries that can't be planned can't be prepared.
+func (rtr *Router) PrepareQuery(sql string) (int64, []proto.PreparedParam, error) {
+	params, err := preparedParams(sql)
+	if err != nil {
+		return 0, nil, err
+	}
+	version := rtr.planner.SchemaVersion()
+	plan := rtr.planner.GetPlan(sql)
+	if plan.ID == planbuilder.NoPlan {
+		return 0, nil, fmt.Errorf("cannot prepare %q: %s", sql, plan.Reason)
+	}
+	id := rtr.prepared.lastID.Add(1)
+	rtr.prepared.set(id, &preparedStatement{
+		sql:     sql,
+		params:  params,
+		plan:    plan,
+		version: version,
+	})
+	return id, params, nil
+}
+
+// ExecutePrepared executes a statement prepared by PrepareQuery
+// with the plan it was prepared with. If the schema changed since,
+// the query is planned again.
+func (rtr *Router) ExecutePrepared(ctx context.Context, req *proto.ExecutePreparedRequest) (*mproto.QueryResult, error) {
+	ps := rtr.prepared.get(req.StatementId)
+	if ps == nil {
+		return nil, fmt.Errorf("prepared statement %d not found", req.StatementId)
+	}
+	if version := rtr.planner.SchemaVersion(); version != ps.version {
+		replanned := *ps
+		replanned.plan = rtr.planner.GetPlan(ps.sql)
+		replanned.version = version
+		rtr.prepared.set(req.StatementId, &replanned)
+		ps = &replanned
+	}
+	query := &proto.Query{
+		Sql:           ps.sql,
+		BindVariables: req.BindVariables,
+		TabletType:    req.TabletType,
+		Session:       req.Session,
+	}
+	ctx, cancel := withQueryTimeout(ctx, query.Session)
+	defer cancel()
+	ctx, warnings := withWarnings(ctx)
+	defer warnings.save(query)
+	ctx, logEntry := withQueryLog(ctx, "ExecutePrepared")
+	defer logEntry.send()
+	ctx, span := startSpan(ctx, "Router.ExecutePrepared")
+	defer span.Finish()
+	ctx, live := rtr.live.register(ctx, query)
+	defer rtr.live.unregister(live)
+	vcursor := newRequestContext(ctx, query, rtr)
+	vcursor.plan = ps.plan
+	vcursor.logEntry = logEntry
+	result, err := rtr.executeWithFallback(vcursor)
+	return result, live.killedErr(err)
+}
+
+// ClosePrepared releases a prepared statement.
+func (rtr *Router) ClosePrepared(id int64) {
+	rtr.prepared.statements.Delete(strconv.FormatInt(id, 10))
+}
+
+// preparedSql returns the query of a prepared
+// statement, or "" if it isn't prepared.
+func (rtr *Router) preparedSql(id int64) string {
+	if ps := rtr.prepared.get(id); ps != nil {
+		return ps.sql
+	}
+	return ""
+}
+
+// preparedParams returns the bind vars of sql, in the order
+// in which they first appear.
+func preparedParams(sql string) ([]proto.PreparedParam, error) {
+	stmt, err := sqlparser.Parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	var params []proto.PreparedParam
+	seen := make(map[string]bool)
+	buf := sqlparser.NewTrackedBuffer(func(buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+		var param proto.PreparedParam
+		switch node := node.(type) {
+		case sqlparser.ValArg:
+			param.Name = string(node[1:])
+		case sqlparser.ListArg:
+			param.Name = string(node[2:])
+			param.List = true
+		default:
+			node.Format(buf)
+			return
+		}
+		if !seen[param.Name] {
+			seen[param.Name] = true
+			params = append(params, param)
+		}
+		node.Format(buf)
+	})
+	buf.Myprintf("%v", stmt)
+	return params, nil
+}
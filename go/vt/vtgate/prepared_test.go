@@ -0,0 +1,101 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// This file uses the sandbox_test framework.
+
+func TestPreparedParams(t *testing.T) {
+	params, err := preparedParams("select * from user where id in ::ids and name = :name and a = :name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []proto.PreparedParam{{Name: "ids", List: true}, {Name: "name"}}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("preparedParams: %+v, want %+v", params, want)
+	}
+	if _, err := preparedParams("select from"); err == nil {
+		t.Errorf("preparedParams of an invalid query: nil, want error")
+	}
+}
+
+func TestPreparedStatement(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	sql := "select * from user where id = :id"
+	id, params, err := router.PrepareQuery(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []proto.PreparedParam{{Name: "id"}}; !reflect.DeepEqual(params, want) {
+		t.Errorf("PrepareQuery params: %+v, want %+v", params, want)
+	}
+	for _, val := range []int64{1, 3} {
+		_, err := router.ExecutePrepared(context.Background(), &proto.ExecutePreparedRequest{
+			StatementId:   id,
+			BindVariables: map[string]interface{}{"id": val},
+			TabletType:    topo.TYPE_MASTER,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sbc1.ExecCount != 1 || sbc2.ExecCount != 1 {
+		t.Errorf("ExecCount: %v, %v, want 1, 1", sbc1.ExecCount, sbc2.ExecCount)
+	}
+	// The query was planned once, and not looked up again.
+	if hits, misses := router.planner.hits.Get(), router.planner.misses.Get(); hits != 0 || misses != 1 {
+		t.Errorf("hits, misses: %d, %d, want 0, 1", hits, misses)
+	}
+
+	// The statement is planned again with a new schema.
+	plan := router.prepared.get(id).plan
+	router.SetSchema(schema)
+	if _, err := router.ExecutePrepared(context.Background(), &proto.ExecutePreparedRequest{
+		StatementId:   id,
+		BindVariables: map[string]interface{}{"id": int64(1)},
+		TabletType:    topo.TYPE_MASTER,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if router.prepared.get(id).plan == plan {
+		t.Errorf("plan was not rebuilt after SetSchema")
+	}
+
+	router.ClosePrepared(id)
+	_, err = router.ExecutePrepared(context.Background(), &proto.ExecutePreparedRequest{
+		StatementId: id,
+		TabletType:  topo.TYPE_MASTER,
+	})
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("ExecutePrepared of a closed statement: %v, want not found", err)
+	}
+
+	if _, _, err := router.PrepareQuery("select * from no_such_table"); err == nil || !strings.Contains(err.Error(), "cannot prepare") {
+		t.Errorf("PrepareQuery of an unknown table: %v, want cannot prepare", err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/youtube/vitess/go/bson"
 	"github.com/youtube/vitess/go/bytes2"
+	kproto "github.com/youtube/vitess/go/vt/key"
 )
 
 // MarshalBson bson-encodes Query.
@@ -36,6 +37,27 @@ func (query *Query) MarshalBson(buf *bytes2.ChunkedWriter, key string) {
 	} else {
 		(*query.Session).MarshalBson(buf, "Session")
 	}
+	bson.EncodeBool(buf, "ReplicaFallback", query.ReplicaFallback)
+	bson.EncodeString(buf, "Priority", query.Priority)
+	bson.EncodeString(buf, "Keyspace", query.Keyspace)
+	// []kproto.KeyspaceId
+	{
+		bson.EncodePrefix(buf, bson.Array, "KeyspaceIds")
+		lenWriter := bson.NewLenWriter(buf)
+		for _i, _v2 := range query.KeyspaceIds {
+			_v2.MarshalBson(buf, bson.Itoa(_i))
+		}
+		lenWriter.Close()
+	}
+	// []kproto.KeyRange
+	{
+		bson.EncodePrefix(buf, bson.Array, "KeyRanges")
+		lenWriter := bson.NewLenWriter(buf)
+		for _i, _v3 := range query.KeyRanges {
+			_v3.MarshalBson(buf, bson.Itoa(_i))
+		}
+		lenWriter.Close()
+	}
 
 	lenWriter.Close()
 }
@@ -79,6 +101,42 @@ func (query *Query) UnmarshalBson(buf *bytes.Buffer, kind byte) {
 				query.Session = new(Session)
 				(*query.Session).UnmarshalBson(buf, kind)
 			}
+		case "ReplicaFallback":
+			query.ReplicaFallback = bson.DecodeBool(buf, kind)
+		case "Priority":
+			query.Priority = bson.DecodeString(buf, kind)
+		case "Keyspace":
+			query.Keyspace = bson.DecodeString(buf, kind)
+		case "KeyspaceIds":
+			// []kproto.KeyspaceId
+			if kind != bson.Null {
+				if kind != bson.Array {
+					panic(bson.NewBsonError("unexpected kind %v for query.KeyspaceIds", kind))
+				}
+				bson.Next(buf, 4)
+				query.KeyspaceIds = make([]kproto.KeyspaceId, 0, 8)
+				for kind := bson.NextByte(buf); kind != bson.EOO; kind = bson.NextByte(buf) {
+					bson.SkipIndex(buf)
+					var _v2 kproto.KeyspaceId
+					_v2.UnmarshalBson(buf, kind)
+					query.KeyspaceIds = append(query.KeyspaceIds, _v2)
+				}
+			}
+		case "KeyRanges":
+			// []kproto.KeyRange
+			if kind != bson.Null {
+				if kind != bson.Array {
+					panic(bson.NewBsonError("unexpected kind %v for query.KeyRanges", kind))
+				}
+				bson.Next(buf, 4)
+				query.KeyRanges = make([]kproto.KeyRange, 0, 8)
+				for kind := bson.NextByte(buf); kind != bson.EOO; kind = bson.NextByte(buf) {
+					bson.SkipIndex(buf)
+					var _v3 kproto.KeyRange
+					_v3.UnmarshalBson(buf, kind)
+					query.KeyRanges = append(query.KeyRanges, _v3)
+				}
+			}
 		default:
 			bson.Skip(buf, kind)
 		}
@@ -33,6 +33,15 @@ func (queryResult *QueryResult) MarshalBson(buf *bytes2.ChunkedWriter, key strin
 		(*queryResult.Session).MarshalBson(buf, "Session")
 	}
 	bson.EncodeString(buf, "Error", queryResult.Error)
+	// []string
+	{
+		bson.EncodePrefix(buf, bson.Array, "ShardErrors")
+		lenWriter := bson.NewLenWriter(buf)
+		for _i, _v1 := range queryResult.ShardErrors {
+			bson.EncodeString(buf, bson.Itoa(_i), _v1)
+		}
+		lenWriter.Close()
+	}
 
 	lenWriter.Close()
 }
@@ -65,6 +74,21 @@ func (queryResult *QueryResult) UnmarshalBson(buf *bytes.Buffer, kind byte) {
 			}
 		case "Error":
 			queryResult.Error = bson.DecodeString(buf, kind)
+		case "ShardErrors":
+			// []string
+			if kind != bson.Null {
+				if kind != bson.Array {
+					panic(bson.NewBsonError("unexpected kind %v for queryResult.ShardErrors", kind))
+				}
+				bson.Next(buf, 4)
+				queryResult.ShardErrors = make([]string, 0, 8)
+				for kind := bson.NextByte(buf); kind != bson.EOO; kind = bson.NextByte(buf) {
+					bson.SkipIndex(buf)
+					var _v1 string
+					_v1 = bson.DecodeString(buf, kind)
+					queryResult.ShardErrors = append(queryResult.ShardErrors, _v1)
+				}
+			}
 		default:
 			bson.Skip(buf, kind)
 		}
@@ -34,6 +34,98 @@ func (session *Session) MarshalBson(buf *bytes2.ChunkedWriter, key string) {
 		}
 		lenWriter.Close()
 	}
+	bson.EncodeBool(buf, "AllowScatterDML", session.AllowScatterDML)
+	bson.EncodeInt64(buf, "CursorId", session.CursorId)
+	bson.EncodeBool(buf, "AllowPartialResults", session.AllowPartialResults)
+	bson.EncodeInt(buf, "StreamParallelism", session.StreamParallelism)
+	bson.EncodeBool(buf, "ReturnInsertValues", session.ReturnInsertValues)
+	bson.EncodeString(buf, "TransactionMode", session.TransactionMode)
+	// []string
+	{
+		bson.EncodePrefix(buf, bson.Array, "Savepoints")
+		lenWriter := bson.NewLenWriter(buf)
+		for _i, _v2 := range session.Savepoints {
+			bson.EncodeString(buf, bson.Itoa(_i), _v2)
+		}
+		lenWriter.Close()
+	}
+	bson.EncodeString(buf, "TransactionIsolation", session.TransactionIsolation)
+	bson.EncodeBool(buf, "TransactionReadOnly", session.TransactionReadOnly)
+	bson.EncodeInt64(buf, "TransactionTimeout", session.TransactionTimeout)
+	bson.EncodeBool(buf, "TransactionAborted", session.TransactionAborted)
+	bson.EncodeBool(buf, "Autocommit", session.Autocommit)
+	// []*ShardSession
+	{
+		bson.EncodePrefix(buf, bson.Array, "ReservedSessions")
+		lenWriter := bson.NewLenWriter(buf)
+		for _i, _v3 := range session.ReservedSessions {
+			// *ShardSession
+			if _v3 == nil {
+				bson.EncodePrefix(buf, bson.Null, bson.Itoa(_i))
+			} else {
+				(*_v3).MarshalBson(buf, bson.Itoa(_i))
+			}
+		}
+		lenWriter.Close()
+	}
+	bson.EncodeString(buf, "Tenant", session.Tenant)
+	bson.EncodeString(buf, "Cell", session.Cell)
+	bson.EncodeBool(buf, "ReadAfterWrite", session.ReadAfterWrite)
+	// []*ShardPosition
+	{
+		bson.EncodePrefix(buf, bson.Array, "WritePositions")
+		lenWriter := bson.NewLenWriter(buf)
+		for _i, _v4 := range session.WritePositions {
+			// *ShardPosition
+			if _v4 == nil {
+				bson.EncodePrefix(buf, bson.Null, bson.Itoa(_i))
+			} else {
+				(*_v4).MarshalBson(buf, bson.Itoa(_i))
+			}
+		}
+		lenWriter.Close()
+	}
+	bson.EncodeInt64(buf, "MaxStaleness", session.MaxStaleness)
+	// map[string]string
+	{
+		bson.EncodePrefix(buf, bson.Object, "SystemVariables")
+		lenWriter := bson.NewLenWriter(buf)
+		for _k, _v5 := range session.SystemVariables {
+			bson.EncodeString(buf, _k, _v5)
+		}
+		lenWriter.Close()
+	}
+	// map[string]interface{}
+	{
+		bson.EncodePrefix(buf, bson.Object, "UserVariables")
+		lenWriter := bson.NewLenWriter(buf)
+		for _k, _v6 := range session.UserVariables {
+			bson.EncodeInterface(buf, _k, _v6)
+		}
+		lenWriter.Close()
+	}
+	bson.EncodeString(buf, "DefaultKeyspace", session.DefaultKeyspace)
+	bson.EncodeInt64(buf, "QueryTimeout", session.QueryTimeout)
+	bson.EncodeInt64(buf, "MaxRows", session.MaxRows)
+	bson.EncodeUint64(buf, "LastInsertId", session.LastInsertId)
+	bson.EncodeUint64(buf, "FoundRows", session.FoundRows)
+	// []*Warning
+	{
+		bson.EncodePrefix(buf, bson.Array, "Warnings")
+		lenWriter := bson.NewLenWriter(buf)
+		for _i, _v7 := range session.Warnings {
+			// *Warning
+			if _v7 == nil {
+				bson.EncodePrefix(buf, bson.Null, bson.Itoa(_i))
+			} else {
+				(*_v7).MarshalBson(buf, bson.Itoa(_i))
+			}
+		}
+		lenWriter.Close()
+	}
+	bson.EncodeString(buf, "App", session.App)
+	bson.EncodeString(buf, "Workload", session.Workload)
+	bson.EncodeString(buf, "Team", session.Team)
 
 	lenWriter.Close()
 }
@@ -73,6 +165,154 @@ func (session *Session) UnmarshalBson(buf *bytes.Buffer, kind byte) {
 					session.ShardSessions = append(session.ShardSessions, _v1)
 				}
 			}
+		case "AllowScatterDML":
+			session.AllowScatterDML = bson.DecodeBool(buf, kind)
+		case "CursorId":
+			session.CursorId = bson.DecodeInt64(buf, kind)
+		case "AllowPartialResults":
+			session.AllowPartialResults = bson.DecodeBool(buf, kind)
+		case "StreamParallelism":
+			session.StreamParallelism = bson.DecodeInt(buf, kind)
+		case "ReturnInsertValues":
+			session.ReturnInsertValues = bson.DecodeBool(buf, kind)
+		case "TransactionMode":
+			session.TransactionMode = bson.DecodeString(buf, kind)
+		case "Savepoints":
+			// []string
+			if kind != bson.Null {
+				if kind != bson.Array {
+					panic(bson.NewBsonError("unexpected kind %v for session.Savepoints", kind))
+				}
+				bson.Next(buf, 4)
+				session.Savepoints = make([]string, 0, 8)
+				for kind := bson.NextByte(buf); kind != bson.EOO; kind = bson.NextByte(buf) {
+					bson.SkipIndex(buf)
+					var _v2 string
+					_v2 = bson.DecodeString(buf, kind)
+					session.Savepoints = append(session.Savepoints, _v2)
+				}
+			}
+		case "TransactionIsolation":
+			session.TransactionIsolation = bson.DecodeString(buf, kind)
+		case "TransactionReadOnly":
+			session.TransactionReadOnly = bson.DecodeBool(buf, kind)
+		case "TransactionTimeout":
+			session.TransactionTimeout = bson.DecodeInt64(buf, kind)
+		case "TransactionAborted":
+			session.TransactionAborted = bson.DecodeBool(buf, kind)
+		case "Autocommit":
+			session.Autocommit = bson.DecodeBool(buf, kind)
+		case "ReservedSessions":
+			// []*ShardSession
+			if kind != bson.Null {
+				if kind != bson.Array {
+					panic(bson.NewBsonError("unexpected kind %v for session.ReservedSessions", kind))
+				}
+				bson.Next(buf, 4)
+				session.ReservedSessions = make([]*ShardSession, 0, 8)
+				for kind := bson.NextByte(buf); kind != bson.EOO; kind = bson.NextByte(buf) {
+					bson.SkipIndex(buf)
+					var _v3 *ShardSession
+					// *ShardSession
+					if kind != bson.Null {
+						_v3 = new(ShardSession)
+						(*_v3).UnmarshalBson(buf, kind)
+					}
+					session.ReservedSessions = append(session.ReservedSessions, _v3)
+				}
+			}
+		case "Tenant":
+			session.Tenant = bson.DecodeString(buf, kind)
+		case "Cell":
+			session.Cell = bson.DecodeString(buf, kind)
+		case "ReadAfterWrite":
+			session.ReadAfterWrite = bson.DecodeBool(buf, kind)
+		case "WritePositions":
+			// []*ShardPosition
+			if kind != bson.Null {
+				if kind != bson.Array {
+					panic(bson.NewBsonError("unexpected kind %v for session.WritePositions", kind))
+				}
+				bson.Next(buf, 4)
+				session.WritePositions = make([]*ShardPosition, 0, 8)
+				for kind := bson.NextByte(buf); kind != bson.EOO; kind = bson.NextByte(buf) {
+					bson.SkipIndex(buf)
+					var _v4 *ShardPosition
+					// *ShardPosition
+					if kind != bson.Null {
+						_v4 = new(ShardPosition)
+						(*_v4).UnmarshalBson(buf, kind)
+					}
+					session.WritePositions = append(session.WritePositions, _v4)
+				}
+			}
+		case "MaxStaleness":
+			session.MaxStaleness = bson.DecodeInt64(buf, kind)
+		case "SystemVariables":
+			// map[string]string
+			if kind != bson.Null {
+				if kind != bson.Object {
+					panic(bson.NewBsonError("unexpected kind %v for session.SystemVariables", kind))
+				}
+				bson.Next(buf, 4)
+				session.SystemVariables = make(map[string]string)
+				for kind := bson.NextByte(buf); kind != bson.EOO; kind = bson.NextByte(buf) {
+					_k := bson.ReadCString(buf)
+					var _v5 string
+					_v5 = bson.DecodeString(buf, kind)
+					session.SystemVariables[_k] = _v5
+				}
+			}
+		case "UserVariables":
+			// map[string]interface{}
+			if kind != bson.Null {
+				if kind != bson.Object {
+					panic(bson.NewBsonError("unexpected kind %v for session.UserVariables", kind))
+				}
+				bson.Next(buf, 4)
+				session.UserVariables = make(map[string]interface{})
+				for kind := bson.NextByte(buf); kind != bson.EOO; kind = bson.NextByte(buf) {
+					_k := bson.ReadCString(buf)
+					var _v6 interface{}
+					_v6 = bson.DecodeInterface(buf, kind)
+					session.UserVariables[_k] = _v6
+				}
+			}
+		case "DefaultKeyspace":
+			session.DefaultKeyspace = bson.DecodeString(buf, kind)
+		case "QueryTimeout":
+			session.QueryTimeout = bson.DecodeInt64(buf, kind)
+		case "MaxRows":
+			session.MaxRows = bson.DecodeInt64(buf, kind)
+		case "LastInsertId":
+			session.LastInsertId = bson.DecodeUint64(buf, kind)
+		case "FoundRows":
+			session.FoundRows = bson.DecodeUint64(buf, kind)
+		case "Warnings":
+			// []*Warning
+			if kind != bson.Null {
+				if kind != bson.Array {
+					panic(bson.NewBsonError("unexpected kind %v for session.Warnings", kind))
+				}
+				bson.Next(buf, 4)
+				session.Warnings = make([]*Warning, 0, 8)
+				for kind := bson.NextByte(buf); kind != bson.EOO; kind = bson.NextByte(buf) {
+					bson.SkipIndex(buf)
+					var _v7 *Warning
+					// *Warning
+					if kind != bson.Null {
+						_v7 = new(Warning)
+						(*_v7).UnmarshalBson(buf, kind)
+					}
+					session.Warnings = append(session.Warnings, _v7)
+				}
+			}
+		case "App":
+			session.App = bson.DecodeString(buf, kind)
+		case "Workload":
+			session.Workload = bson.DecodeString(buf, kind)
+		case "Team":
+			session.Team = bson.DecodeString(buf, kind)
 		default:
 			bson.Skip(buf, kind)
 		}
@@ -19,10 +19,138 @@ import (
 type Session struct {
 	InTransaction bool
 	ShardSessions []*ShardSession
+	// AllowScatterDML allows updates and deletes
+	// to be sent to all shards of a keyspace.
+	AllowScatterDML bool
+	// CursorId identifies the cursor opened by BeginStream.
+	// It's 0 if there is no open cursor.
+	CursorId int64
+	// AllowPartialResults allows scatter selects to return
+	// the rows of the shards that responded if others fail.
+	AllowPartialResults bool
+	// StreamParallelism is the maximum number of shards a streaming
+	// query reads from at a time. It's 0 if the vtgate default applies.
+	StreamParallelism int
+	// ReturnInsertValues makes a sharded insert return the
+	// values of the vindex columns and the keyspace id of the
+	// inserted row, including the generated ones, as a row
+	// of the result.
+	ReturnInsertValues bool
+	// TransactionMode is the way the transaction
+	// commits if it spans multiple shards.
+	TransactionMode string
+	// Savepoints are the names of the savepoints of the
+	// transaction, in the order they were created. They're
+	// replayed on the shards that join the transaction later.
+	Savepoints []string
+	// TransactionIsolation is the isolation level of the
+	// transactions of the session, like "READ COMMITTED".
+	// The default of vttablet is used if it's empty.
+	TransactionIsolation string
+	// TransactionReadOnly makes the current transaction read only.
+	TransactionReadOnly bool
+	// TransactionTimeout is the time in nanoseconds after which
+	// vtgate rolls back a transaction of the session that's not
+	// committed or rolled back. The vtgate default applies if it's 0.
+	TransactionTimeout int64
+	// TransactionAborted is set if the transaction was rolled back
+	// by vtgate because it timed out. The statements of the session
+	// fail until it's committed or rolled back.
+	TransactionAborted bool
+	// Autocommit makes the single shard DMLs that are executed
+	// outside of a transaction commit in a transaction of their own,
+	// which is begun and committed in the same round-trip to vttablet.
+	Autocommit bool
+	// ReservedSessions are the connections reserved for the session
+	// on the shards it used session state on, like user variables,
+	// temporary tables or named locks. Their TransactionId is the id
+	// of the reserved connection.
+	ReservedSessions []*ShardSession
+	// Tenant identifies the tenant of the session. The tables of
+	// the template keyspaces of the V3 schema are routed to the
+	// keyspaces of the tenant.
+	Tenant string
+	// Cell is the cell of the client. The replica and rdonly
+	// reads of the V3 API prefer its tablets to those of the
+	// cell of vtgate.
+	Cell string
+	// ReadAfterWrite makes the replica and rdonly reads of the
+	// session see its own writes: a read of a shard the session
+	// committed to waits for the replica to reach the position of
+	// the commit, or goes to the master if it doesn't in time.
+	ReadAfterWrite bool
+	// WritePositions are the replication positions of the masters
+	// of the shards, after the last commit of the session on them.
+	// They're only recorded if ReadAfterWrite is set.
+	WritePositions []*ShardPosition
+	// MaxStaleness is the maximum replication lag, in nanoseconds,
+	// of the replica and rdonly tablets the reads of the V3 API are
+	// sent to. It's 0 if there's no bound.
+	MaxStaleness int64
+	// SystemVariables are the session variables set with SET, like
+	// sql_mode, by lower-case name. Their values are sql literals.
+	// They're set on the connection reserved for the session on
+	// each shard it uses.
+	SystemVariables map[string]string
+	// UserVariables are the user-defined variables set with SET,
+	// by name without the @. The V3 API binds their references in
+	// the queries of the session to their values.
+	UserVariables map[string]interface{}
+	// DefaultKeyspace is the keyspace set with USE. The V3 API
+	// resolves the unqualified table names in it first, so that
+	// the tables of other keyspaces with the same name are not
+	// ambiguous.
+	DefaultKeyspace string
+	// QueryTimeout is the time in nanoseconds each query of the
+	// session can run for, set with SET @@vitess_query_timeout.
+	// It's 0 if the queries only have the deadline of the caller.
+	QueryTimeout int64
+	// MaxRows is the maximum number of rows the shards can return
+	// for each query of the session, set with SET @@vitess_max_rows.
+	// It can only tighten the max_result_rows flag of vtgate. It's
+	// 0 if there's no limit.
+	MaxRows int64
+	// LastInsertId is the last id generated for an insert of the
+	// session, by the shards or by a vindex. The V3 API returns it
+	// for LAST_INSERT_ID().
+	LastInsertId uint64
+	// FoundRows is the number of rows returned by the last select
+	// of the session. The V3 API returns it for FOUND_ROWS().
+	FoundRows uint64
+	// Warnings are the non-fatal conditions of the last statement
+	// of the session, returned by SHOW WARNINGS.
+	Warnings []*Warning
+	// App, Workload and Team identify the traffic of the session.
+	// They're added to its queries as a comment, count in the
+	// stats by workload, and the queries of the WorkloadOLAP
+	// workload have the batch priority for admission control.
+	App      string
+	Workload string
+	Team     string
 }
 
+// Transaction modes of a Session.
+const (
+	// TxModeMulti commits the shards of a transaction one
+	// after the other. A failure can leave the transaction
+	// committed on some shards only.
+	TxModeMulti = ""
+	// TxModeSingle fails any statement that would make the
+	// transaction span more than one shard, including the
+	// transactions vtgate opens to maintain lookup vindexes.
+	TxModeSingle = "single"
+	// TxModeTwoPC commits the shards of a transaction
+	// atomically, with a two-phase commit.
+	TxModeTwoPC = "twopc"
+	// TxModeXA leaves the commit of the transactions that span
+	// multiple shards to an external transaction manager: they
+	// must be prepared with Prepare, and concluded with
+	// CommitPrepared or RollbackPrepared.
+	TxModeXA = "xa"
+)
+
 func (session *Session) String() string {
-	return fmt.Sprintf("InTransaction: %v, ShardSession: %+v", session.InTransaction, session.ShardSessions)
+	return fmt.Sprintf("InTransaction: %v, ShardSession: %+v, AllowScatterDML: %v, CursorId: %v, AllowPartialResults: %v, StreamParallelism: %v, ReturnInsertValues: %v, TransactionMode: %v, Savepoints: %v, TransactionIsolation: %v, TransactionReadOnly: %v, TransactionTimeout: %v, TransactionAborted: %v, Autocommit: %v, Tenant: %v, Cell: %v, ReadAfterWrite: %v, WritePositions: %+v, MaxStaleness: %v, SystemVariables: %v, UserVariables: %v, DefaultKeyspace: %v, QueryTimeout: %v, MaxRows: %v, LastInsertId: %v, FoundRows: %v, Warnings: %+v, App: %v, Workload: %v, Team: %v", session.InTransaction, session.ShardSessions, session.AllowScatterDML, session.CursorId, session.AllowPartialResults, session.StreamParallelism, session.ReturnInsertValues, session.TransactionMode, session.Savepoints, session.TransactionIsolation, session.TransactionReadOnly, session.TransactionTimeout, session.TransactionAborted, session.Autocommit, session.Tenant, session.Cell, session.ReadAfterWrite, session.WritePositions, session.MaxStaleness, session.SystemVariables, session.UserVariables, session.DefaultKeyspace, session.QueryTimeout, session.MaxRows, session.LastInsertId, session.FoundRows, session.Warnings, session.App, session.Workload, session.Team)
 }
 
 // ShardSession represents the session state for a shard.
@@ -37,14 +165,81 @@ func (shardSession *ShardSession) String() string {
 	return fmt.Sprintf("Keyspace: %v, Shard: %v, TabletType: %v, TransactionId: %v", shardSession.Keyspace, shardSession.Shard, shardSession.TabletType, shardSession.TransactionId)
 }
 
+// ShardPosition is the replication position of the master of a shard.
+type ShardPosition struct {
+	Keyspace string
+	Shard    string
+	Position string
+}
+
+func (shardPosition *ShardPosition) String() string {
+	return fmt.Sprintf("Keyspace: %v, Shard: %v, Position: %v", shardPosition.Keyspace, shardPosition.Shard, shardPosition.Position)
+}
+
+// Workloads of a Session.
+const (
+	// WorkloadOLTP is the workload of the interactive queries.
+	WorkloadOLTP = "oltp"
+	// WorkloadOLAP is the workload of the analytics queries.
+	WorkloadOLAP = "olap"
+)
+
+// Warning is a non-fatal condition of a statement, which may have
+// returned a degraded result.
+type Warning struct {
+	// Code identifies the condition, like WarningPartialResult.
+	Code    string
+	Message string
+}
+
+func (warning *Warning) String() string {
+	return fmt.Sprintf("Code: %v, Message: %v", warning.Code, warning.Message)
+}
+
+// Warning codes.
+const (
+	// WarningPartialResult means a shard failed, and the result
+	// only has the rows of the other shards.
+	WarningPartialResult = "PartialResult"
+	// WarningScatter means the statement was sent to all the
+	// shards of a keyspace, because it doesn't restrict the
+	// values of a vindex.
+	WarningScatter = "Scatter"
+	// WarningReplicaFallback means the read couldn't reach the
+	// master, and was served by a replica.
+	WarningReplicaFallback = "ReplicaFallback"
+)
+
 // Query represents a keyspace agnostic query request.
 type Query struct {
 	Sql           string
 	BindVariables map[string]interface{}
 	TabletType    topo.TabletType
 	Session       *Session
+	// ReplicaFallback allows a read on the master that fails
+	// because the master is unreachable, as during a reparent,
+	// to be retried on a replica that's not lagging.
+	ReplicaFallback bool
+	// Priority is the priority class of the query, one of the
+	// Priority constants. When the shards are at their in-flight
+	// limit, the queued queries of a higher priority go first.
+	Priority string
+	// Keyspace and KeyspaceIds or KeyRanges route the query like
+	// the V2 API if the V3 planner cannot handle it, and the
+	// fallback to V2 routing is enabled.
+	Keyspace    string
+	KeyspaceIds []kproto.KeyspaceId
+	KeyRanges   []kproto.KeyRange
 }
 
+const (
+	// PriorityOLTP is the priority of the interactive queries.
+	PriorityOLTP = ""
+	// PriorityBatch is the priority of the batch and analytics
+	// queries, which wait for the queued OLTP queries.
+	PriorityBatch = "batch"
+)
+
 // QueryShard represents a query request for the
 // specified list of shards.
 type QueryShard struct {
@@ -100,6 +295,9 @@ type QueryResult struct {
 	Result  *mproto.QueryResult
 	Session *Session
 	Error   string
+	// ShardErrors are the errors of the shards that failed
+	// when partial results were returned.
+	ShardErrors []string
 }
 
 // BatchQueryShard represents a batch query request
@@ -146,3 +344,135 @@ type SplitQueryPart struct {
 type SplitQueryResult struct {
 	Splits []SplitQueryPart
 }
+
+// MapKeyspaceIdRequest asks which shard of a keyspace a keyspace
+// id belongs to, and which vindex values it corresponds to.
+type MapKeyspaceIdRequest struct {
+	Keyspace   string
+	KeyspaceId kproto.KeyspaceId
+	TabletType topo.TabletType
+}
+
+// MapKeyspaceIdResult is the result of a MapKeyspaceIdRequest.
+// VindexValues contains the value of each vindex of the keyspace
+// that can be reverse mapped, by vindex name.
+type MapKeyspaceIdResult struct {
+	Shard        string
+	VindexValues map[string]interface{}
+}
+
+// DescribeKeyspacesRequest asks for the keyspaces served by
+// vtgate, or only for Keyspace if it's set.
+type DescribeKeyspacesRequest struct {
+	Keyspace string
+}
+
+// DescribeKeyspacesResult is the result of a DescribeKeyspacesRequest.
+type DescribeKeyspacesResult struct {
+	Keyspaces []KeyspaceDescription
+}
+
+// KeyspaceDescription describes a keyspace as seen by vtgate:
+// its sharding scheme, the keyspaces some of its tablet types are
+// served from, and its shards.
+type KeyspaceDescription struct {
+	Name               string
+	ShardingColumnName string
+	ShardingColumnType kproto.KeyspaceIdType
+	ServedFrom         map[topo.TabletType]string
+	TabletTypes        []topo.TabletType
+	Shards             []ShardDescription
+}
+
+// ShardDescription describes a shard of a keyspace. TabletTypes
+// are the tablet types whose queries are routed to the shard.
+type ShardDescription struct {
+	Name        string
+	KeyRange    kproto.KeyRange
+	TabletTypes []topo.TabletType
+}
+
+// ValidateVSchemaRequest checks the proposed VSchema of
+// Keyspace against its database, without applying it.
+type ValidateVSchemaRequest struct {
+	Keyspace string
+	VSchema  string
+}
+
+// ValidateVSchemaResult is the result of a ValidateVSchemaRequest.
+// Problems is empty if the VSchema can be applied.
+type ValidateVSchemaResult struct {
+	Problems []string
+	Error    string
+}
+
+// BulkInsertRequest inserts Rows into Table without going
+// through the SQL parser. Each row has one value per column
+// of Columns.
+type BulkInsertRequest struct {
+	Table      string
+	Columns    []string
+	Rows       [][]interface{}
+	TabletType topo.TabletType
+	Session    *Session
+}
+
+// PrepareRequest prepares the transaction of Session on all its
+// shards, for an external transaction manager. Dtid is the id of
+// the distributed transaction in the transaction manager.
+type PrepareRequest struct {
+	Session *Session
+	Dtid    string
+}
+
+// PrepareResult is the result of a PrepareRequest. Participants
+// are the prepared shards, which the transaction manager must pass
+// to CommitPrepared or RollbackPrepared.
+type PrepareResult struct {
+	Session      *Session
+	Participants []*ShardSession
+	Error        string
+}
+
+// ResolvePreparedRequest commits or rolls back the distributed
+// transaction Dtid on its Participants.
+type ResolvePreparedRequest struct {
+	Dtid         string
+	Participants []*ShardSession
+}
+
+// PrepareQueryRequest plans Sql once, for executing it
+// many times with ExecutePrepared.
+type PrepareQueryRequest struct {
+	Sql string
+}
+
+// PreparedParam describes a bind var of a prepared statement.
+// List is true if the bind var is a list, as in "in ::ids".
+type PreparedParam struct {
+	Name string
+	List bool
+}
+
+// PrepareQueryResult is the result of a PrepareQueryRequest.
+// StatementId identifies the prepared statement, and Params
+// are the bind vars it must be executed with.
+type PrepareQueryResult struct {
+	StatementId int64
+	Params      []PreparedParam
+	Error       string
+}
+
+// ExecutePreparedRequest executes the prepared statement
+// StatementId with BindVariables.
+type ExecutePreparedRequest struct {
+	StatementId   int64
+	BindVariables map[string]interface{}
+	TabletType    topo.TabletType
+	Session       *Session
+}
+
+// ClosePreparedRequest releases the prepared statement StatementId.
+type ClosePreparedRequest struct {
+	StatementId int64
+}
@@ -29,17 +29,113 @@ var commonSession = Session{
 		TabletType:    topo.TabletType("master"),
 		TransactionId: 2,
 	}},
+	AllowScatterDML:      true,
+	CursorId:             3,
+	AllowPartialResults:  true,
+	StreamParallelism:    4,
+	ReturnInsertValues:   true,
+	TransactionMode:      "twopc",
+	Savepoints:           []string{"sp1"},
+	TransactionIsolation: "READ COMMITTED",
+	TransactionReadOnly:  true,
+	TransactionTimeout:   5,
+	TransactionAborted:   true,
+	Autocommit:           true,
+	ReservedSessions: []*ShardSession{{
+		Keyspace:      "a",
+		Shard:         "0",
+		TabletType:    topo.TabletType("replica"),
+		TransactionId: 6,
+	}},
+	Tenant:         "t1",
+	Cell:           "aa",
+	ReadAfterWrite: true,
+	WritePositions: []*ShardPosition{{
+		Keyspace: "a",
+		Shard:    "0",
+		Position: "0-1-2",
+	}},
+	MaxStaleness:    7,
+	SystemVariables: map[string]string{"sql_mode": "'A'"},
+	UserVariables:   map[string]interface{}{"a": int64(8)},
+	DefaultKeyspace: "ks",
+	QueryTimeout:    9,
+	MaxRows:         10,
+	LastInsertId:    11,
+	FoundRows:       12,
+	Warnings:        []*Warning{{Code: "Scatter", Message: "m"}},
+	App:             "a",
+	Workload:        "olap",
+	Team:            "t",
 }
 
 type reflectSession struct {
-	InTransaction bool
-	ShardSessions []*ShardSession
+	InTransaction        bool
+	ShardSessions        []*ShardSession
+	AllowScatterDML      bool
+	CursorId             int64
+	AllowPartialResults  bool
+	StreamParallelism    int
+	ReturnInsertValues   bool
+	TransactionMode      string
+	Savepoints           []string
+	TransactionIsolation string
+	TransactionReadOnly  bool
+	TransactionTimeout   int64
+	TransactionAborted   bool
+	Autocommit           bool
+	ReservedSessions     []*ShardSession
+	Tenant               string
+	Cell                 string
+	ReadAfterWrite       bool
+	WritePositions       []*ShardPosition
+	MaxStaleness         int64
+	SystemVariables      map[string]string
+	UserVariables        map[string]interface{}
+	DefaultKeyspace      string
+	QueryTimeout         int64
+	MaxRows              int64
+	LastInsertId         uint64
+	FoundRows            uint64
+	Warnings             []*Warning
+	App                  string
+	Workload             string
+	Team                 string
 }
 
 type extraSession struct {
-	Extra         int
-	InTransaction bool
-	ShardSessions []*ShardSession
+	Extra                int
+	InTransaction        bool
+	ShardSessions        []*ShardSession
+	AllowScatterDML      bool
+	CursorId             int64
+	AllowPartialResults  bool
+	StreamParallelism    int
+	ReturnInsertValues   bool
+	TransactionMode      string
+	Savepoints           []string
+	TransactionIsolation string
+	TransactionReadOnly  bool
+	TransactionTimeout   int64
+	TransactionAborted   bool
+	Autocommit           bool
+	ReservedSessions     []*ShardSession
+	Tenant               string
+	Cell                 string
+	ReadAfterWrite       bool
+	WritePositions       []*ShardPosition
+	MaxStaleness         int64
+	SystemVariables      map[string]string
+	UserVariables        map[string]interface{}
+	DefaultKeyspace      string
+	QueryTimeout         int64
+	MaxRows              int64
+	LastInsertId         uint64
+	FoundRows            uint64
+	Warnings             []*Warning
+	App                  string
+	Workload             string
+	Team                 string
 }
 
 func TestSession(t *testing.T) {
@@ -56,6 +152,44 @@ func TestSession(t *testing.T) {
 			TabletType:    topo.TabletType("master"),
 			TransactionId: 2,
 		}},
+		AllowScatterDML:      true,
+		CursorId:             3,
+		AllowPartialResults:  true,
+		StreamParallelism:    4,
+		ReturnInsertValues:   true,
+		TransactionMode:      "twopc",
+		Savepoints:           []string{"sp1"},
+		TransactionIsolation: "READ COMMITTED",
+		TransactionReadOnly:  true,
+		TransactionTimeout:   5,
+		TransactionAborted:   true,
+		Autocommit:           true,
+		ReservedSessions: []*ShardSession{{
+			Keyspace:      "a",
+			Shard:         "0",
+			TabletType:    topo.TabletType("replica"),
+			TransactionId: 6,
+		}},
+		Tenant:         "t1",
+		Cell:           "aa",
+		ReadAfterWrite: true,
+		WritePositions: []*ShardPosition{{
+			Keyspace: "a",
+			Shard:    "0",
+			Position: "0-1-2",
+		}},
+		MaxStaleness:    7,
+		SystemVariables: map[string]string{"sql_mode": "'A'"},
+		UserVariables:   map[string]interface{}{"a": int64(8)},
+		DefaultKeyspace: "ks",
+		QueryTimeout:    9,
+		MaxRows:         10,
+		LastInsertId:    11,
+		FoundRows:       12,
+		Warnings:        []*Warning{{Code: "Scatter", Message: "m"}},
+		App:             "a",
+		Workload:        "olap",
+		Team:            "t",
 	})
 	if err != nil {
 		t.Error(err)
@@ -160,10 +294,91 @@ func TestQueryShard(t *testing.T) {
 	}
 }
 
+type reflectQuery struct {
+	Sql             string
+	BindVariables   map[string]interface{}
+	TabletType      topo.TabletType
+	Session         *Session
+	ReplicaFallback bool
+	Priority        string
+	Keyspace        string
+	KeyspaceIds     []kproto.KeyspaceId
+	KeyRanges       []kproto.KeyRange
+}
+
+type extraQuery struct {
+	Extra           int
+	Sql             string
+	BindVariables   map[string]interface{}
+	TabletType      topo.TabletType
+	Session         *Session
+	ReplicaFallback bool
+	Priority        string
+	Keyspace        string
+	KeyspaceIds     []kproto.KeyspaceId
+	KeyRanges       []kproto.KeyRange
+}
+
+func TestQuery(t *testing.T) {
+	reflected, err := bson.Marshal(&reflectQuery{
+		Sql:             "query",
+		BindVariables:   map[string]interface{}{"val": int64(1)},
+		TabletType:      topo.TabletType("replica"),
+		Session:         &commonSession,
+		ReplicaFallback: true,
+		Priority:        PriorityBatch,
+		Keyspace:        "keyspace",
+		KeyspaceIds:     []kproto.KeyspaceId{kproto.KeyspaceId("10"), kproto.KeyspaceId("18")},
+		KeyRanges:       []kproto.KeyRange{{Start: "10", End: "18"}},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	want := string(reflected)
+
+	custom := Query{
+		Sql:             "query",
+		BindVariables:   map[string]interface{}{"val": int64(1)},
+		TabletType:      topo.TabletType("replica"),
+		Session:         &commonSession,
+		ReplicaFallback: true,
+		Priority:        PriorityBatch,
+		Keyspace:        "keyspace",
+		KeyspaceIds:     []kproto.KeyspaceId{kproto.KeyspaceId("10"), kproto.KeyspaceId("18")},
+		KeyRanges:       []kproto.KeyRange{{Start: "10", End: "18"}},
+	}
+	encoded, err := bson.Marshal(&custom)
+	if err != nil {
+		t.Error(err)
+	}
+	got := string(encoded)
+	if want != got {
+		t.Errorf("want\n%+v, got\n%+v", want, got)
+	}
+
+	var unmarshalled Query
+	err = bson.Unmarshal(encoded, &unmarshalled)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(custom, unmarshalled) {
+		t.Errorf("want \n%+v, got \n%+v", custom, unmarshalled)
+	}
+
+	extra, err := bson.Marshal(&extraQuery{})
+	if err != nil {
+		t.Error(err)
+	}
+	err = bson.Unmarshal(extra, &unmarshalled)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 func TestQueryResult(t *testing.T) {
 	// We can't do the reflection test because bson
 	// doesn't do it correctly for embedded fields.
-	want := "|\x01\x00\x00" +
+	want := "\xc2\x04\x00\x00" +
 		"\x03Result\x00\x85\x00\x00\x00" +
 		"\x04Fields\x00*\x00\x00\x00" +
 		"\x030\x00\"\x00\x00\x00" +
@@ -176,7 +391,7 @@ func TestQueryResult(t *testing.T) {
 		"\x050\x00\x01\x00\x00\x00" +
 		"\x001\x051\x00\x02\x00\x00\x00\x00aa" +
 		"\x00\x00\x00" +
-		"\x03Session\x00\xd0\x00\x00\x00" +
+		"\x03Session\x00\xfb\x03\x00\x00" +
 		"\bInTransaction\x00\x01" +
 		"\x04ShardSessions\x00\xac\x00\x00\x00" +
 		"\x030\x00Q\x00\x00\x00" +
@@ -190,8 +405,65 @@ func TestQueryResult(t *testing.T) {
 		"\x05Shard\x00\x01\x00\x00\x00\x001" +
 		"\x05TabletType\x00\x06\x00\x00\x00\x00master" +
 		"\x12TransactionId\x00\x02\x00\x00\x00\x00\x00\x00\x00" +
-		"\x00\x00\x00" +
+		"\x00\x00" +
+		"\bAllowScatterDML\x00\x01" +
+		"\x12CursorId\x00\x03\x00\x00\x00\x00\x00\x00\x00" +
+		"\bAllowPartialResults\x00\x01" +
+		"\x12StreamParallelism\x00\x04\x00\x00\x00\x00\x00\x00\x00" +
+		"\bReturnInsertValues\x00\x01" +
+		"\x05TransactionMode\x00\x05\x00\x00\x00\x00twopc" +
+		"\x04Savepoints\x00\x10\x00\x00\x00" +
+		"\x050\x00\x03\x00\x00\x00\x00sp1" +
+		"\x00" +
+		"\x05TransactionIsolation\x00\x0e\x00\x00\x00\x00READ COMMITTED" +
+		"\bTransactionReadOnly\x00\x01" +
+		"\x12TransactionTimeout\x00\x05\x00\x00\x00\x00\x00\x00\x00" +
+		"\bTransactionAborted\x00\x01" +
+		"\bAutocommit\x00\x01" +
+		"\x04ReservedSessions\x00Y\x00\x00\x00" +
+		"\x030\x00Q\x00\x00\x00" +
+		"\x05Keyspace\x00\x01\x00\x00\x00\x00a" +
+		"\x05Shard\x00\x01\x00\x00\x00\x000" +
+		"\x05TabletType\x00\a\x00\x00\x00\x00replica" +
+		"\x12TransactionId\x00\x06\x00\x00\x00\x00\x00\x00\x00" +
+		"\x00" +
+		"\x00" +
+		"\x05Tenant\x00\x02\x00\x00\x00\x00t1" +
+		"\x05Cell\x00\x02\x00\x00\x00\x00aa" +
+		"\bReadAfterWrite\x00\x01" +
+		"\x04WritePositions\x00>\x00\x00\x00" +
+		"\x030\x006\x00\x00\x00" +
+		"\x05Keyspace\x00\x01\x00\x00\x00\x00a" +
+		"\x05Shard\x00\x01\x00\x00\x00\x000" +
+		"\x05Position\x00\x05\x00\x00\x00\x000-1-2" +
+		"\x00" +
+		"\x00" +
+		"\x12MaxStaleness\x00\a\x00\x00\x00\x00\x00\x00\x00" +
+		"\x03SystemVariables\x00\x17\x00\x00\x00" +
+		"\x05sql_mode\x00\x03\x00\x00\x00\x00'A'" +
+		"\x00" +
+		"\x03UserVariables\x00\x10\x00\x00\x00" +
+		"\x12a\x00\b\x00\x00\x00\x00\x00\x00\x00" +
+		"\x00" +
+		"\x05DefaultKeyspace\x00\x02\x00\x00\x00\x00ks" +
+		"\x12QueryTimeout\x00\t\x00\x00\x00\x00\x00\x00\x00" +
+		"\x12MaxRows\x00\n\x00\x00\x00\x00\x00\x00\x00" +
+		"?LastInsertId\x00\v\x00\x00\x00\x00\x00\x00\x00" +
+		"?FoundRows\x00\f\x00\x00\x00\x00\x00\x00\x00" +
+		"\x04Warnings\x00.\x00\x00\x00" +
+		"\x030\x00&\x00\x00\x00" +
+		"\x05Code\x00\a\x00\x00\x00\x00Scatter" +
+		"\x05Message\x00\x01\x00\x00\x00\x00m" +
+		"\x00" +
+		"\x00" +
+		"\x05App\x00\x01\x00\x00\x00\x00a" +
+		"\x05Workload\x00\x04\x00\x00\x00\x00olap" +
+		"\x05Team\x00\x01\x00\x00\x00\x00t" +
+		"\x00" +
 		"\x05Error\x00\x05\x00\x00\x00\x00error" +
+		"\x04ShardErrors\x00\x0e\x00\x00\x00" +
+		"\x050\x00\x01\x00\x00\x00\x00e" +
+		"\x00" +
 		"\x00"
 
 	custom := QueryResult{
@@ -203,8 +475,9 @@ func TestQueryResult(t *testing.T) {
 				{{sqltypes.String("1")}, {sqltypes.String("aa")}},
 			},
 		},
-		Session: &commonSession,
-		Error:   "error",
+		Session:     &commonSession,
+		Error:       "error",
+		ShardErrors: []string{"e"},
 	}
 	encoded, err := bson.Marshal(&custom)
 	if err != nil {
@@ -267,6 +540,44 @@ func TestBatchQueryShard(t *testing.T) {
 				TabletType:    topo.TabletType("master"),
 				TransactionId: 2,
 			}},
+			AllowScatterDML:      true,
+			CursorId:             3,
+			AllowPartialResults:  true,
+			StreamParallelism:    4,
+			ReturnInsertValues:   true,
+			TransactionMode:      "twopc",
+			Savepoints:           []string{"sp1"},
+			TransactionIsolation: "READ COMMITTED",
+			TransactionReadOnly:  true,
+			TransactionTimeout:   5,
+			TransactionAborted:   true,
+			Autocommit:           true,
+			ReservedSessions: []*ShardSession{{
+				Keyspace:      "a",
+				Shard:         "0",
+				TabletType:    topo.TabletType("replica"),
+				TransactionId: 6,
+			}},
+			Tenant:         "t1",
+			Cell:           "aa",
+			ReadAfterWrite: true,
+			WritePositions: []*ShardPosition{{
+				Keyspace: "a",
+				Shard:    "0",
+				Position: "0-1-2",
+			}},
+			MaxStaleness:    7,
+			SystemVariables: map[string]string{"sql_mode": "'A'"},
+			UserVariables:   map[string]interface{}{"a": int64(8)},
+			DefaultKeyspace: "ks",
+			QueryTimeout:    9,
+			MaxRows:         10,
+			LastInsertId:    11,
+			FoundRows:       12,
+			Warnings:        []*Warning{{Code: "Scatter", Message: "m"}},
+			App:             "a",
+			Workload:        "olap",
+			Team:            "t",
 		},
 	})
 	if err != nil {
@@ -580,6 +891,44 @@ func TestKeyspaceIdBatchQuery(t *testing.T) {
 				TabletType:    topo.TabletType("master"),
 				TransactionId: 2,
 			}},
+			AllowScatterDML:      true,
+			CursorId:             3,
+			AllowPartialResults:  true,
+			StreamParallelism:    4,
+			ReturnInsertValues:   true,
+			TransactionMode:      "twopc",
+			Savepoints:           []string{"sp1"},
+			TransactionIsolation: "READ COMMITTED",
+			TransactionReadOnly:  true,
+			TransactionTimeout:   5,
+			TransactionAborted:   true,
+			Autocommit:           true,
+			ReservedSessions: []*ShardSession{{
+				Keyspace:      "a",
+				Shard:         "0",
+				TabletType:    topo.TabletType("replica"),
+				TransactionId: 6,
+			}},
+			Tenant:         "t1",
+			Cell:           "aa",
+			ReadAfterWrite: true,
+			WritePositions: []*ShardPosition{{
+				Keyspace: "a",
+				Shard:    "0",
+				Position: "0-1-2",
+			}},
+			MaxStaleness:    7,
+			SystemVariables: map[string]string{"sql_mode": "'A'"},
+			UserVariables:   map[string]interface{}{"a": int64(8)},
+			DefaultKeyspace: "ks",
+			QueryTimeout:    9,
+			MaxRows:         10,
+			LastInsertId:    11,
+			FoundRows:       12,
+			Warnings:        []*Warning{{Code: "Scatter", Message: "m"}},
+			App:             "a",
+			Workload:        "olap",
+			Team:            "t",
 		},
 	})
 	if err != nil {
@@ -0,0 +1,139 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/youtube/vitess/go/acl"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// QueryRouting is how a query would be routed.
+type QueryRouting struct {
+	Sql string
+	// TabletType is the tablet type the query is routed
+	// with, after its TABLET_TYPE hint if it has one.
+	TabletType topo.TabletType
+	Plan       *planbuilder.Plan
+	Routes     []*RouteExplanation
+}
+
+// RouteQuery returns how query would be routed right now, without
+// executing it: its plan, and for each of its routes, the values of
+// the vindex, the keyspace ids they map to and the shards the query
+// would be sent to. Like for an EXPLAIN, the shards are resolved
+// with the current topology, and the lookup vindexes are queried.
+func (rtr *Router) RouteQuery(ctx context.Context, query *proto.Query) (*QueryRouting, error) {
+	routed := *query
+	routed.BindVariables = make(map[string]interface{}, len(query.BindVariables))
+	for k, v := range query.BindVariables {
+		routed.BindVariables[k] = v
+	}
+	plan, routes, err := rtr.explainQuery(newRequestContext(ctx, &routed, rtr))
+	if err != nil {
+		return nil, err
+	}
+	return &QueryRouting{
+		Sql:        query.Sql,
+		TabletType: routed.TabletType,
+		Plan:       plan,
+		Routes:     routes,
+	}, nil
+}
+
+// serveRoute serves /debug/route, which shows how the query of
+// the sql parameter would be routed. The bind_vars parameter has
+// its bind vars as a JSON object, tablet_type its tablet type,
+// master by default, keyspace the keyspace its unqualified tables
+// are resolved in first, and tenant the tenant it's executed for.
+func (rtr *Router) serveRoute(response http.ResponseWriter, request *http.Request) {
+	if err := acl.CheckAccessHTTP(request, acl.DEBUGGING); err != nil {
+		acl.SendError(response, err)
+		return
+	}
+	query := &proto.Query{
+		Sql:        request.FormValue("sql"),
+		TabletType: topo.TYPE_MASTER,
+		Session: &proto.Session{
+			DefaultKeyspace: request.FormValue("keyspace"),
+			Tenant:          request.FormValue("tenant"),
+		},
+	}
+	if query.Sql == "" {
+		http.Error(response, "missing sql parameter", http.StatusBadRequest)
+		return
+	}
+	if tabletType := request.FormValue("tablet_type"); tabletType != "" {
+		query.TabletType = topo.TabletType(tabletType)
+	}
+	var err error
+	if query.BindVariables, err = decodeBindVars(request.FormValue("bind_vars")); err != nil {
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+	routing, err := rtr.RouteQuery(context.Background(), query)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if b, err := json.MarshalIndent(routing, "", "  "); err != nil {
+		response.Write([]byte(err.Error()))
+	} else {
+		response.Write(b)
+	}
+}
+
+// decodeBindVars decodes bind vars from a JSON object. The integers
+// are decoded as int64, or uint64 if they're too large, so that they
+// can be mapped by the vindexes, and the arrays as list bind vars.
+func decodeBindVars(data string) (map[string]interface{}, error) {
+	bindVars := make(map[string]interface{})
+	if data == "" {
+		return bindVars, nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&bindVars); err != nil {
+		return nil, fmt.Errorf("invalid bind_vars: %v", err)
+	}
+	for k, v := range bindVars {
+		bindVars[k] = decodeBindVar(v)
+	}
+	return bindVars, nil
+}
+
+func decodeBindVar(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return u
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return string(v)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, val := range v {
+			list[i] = decodeBindVar(val)
+		}
+		return list
+	}
+	return v
+}
@@ -0,0 +1,154 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// This file uses the sandbox_test framework.
+
+func TestRouteQuery(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	routing, err := router.RouteQuery(context.Background(), &proto.Query{
+		Sql:           "select * from user where id in ::ids",
+		BindVariables: map[string]interface{}{"ids": []interface{}{1, 3, nil}},
+		TabletType:    topo.TYPE_REPLICA,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routing.Plan.ID != planbuilder.SelectIN || routing.TabletType != topo.TYPE_REPLICA {
+		t.Errorf("RouteQuery: %v plan for %v, want SelectIN for replica", routing.Plan.ID, routing.TabletType)
+	}
+	if len(routing.Routes) != 1 {
+		t.Fatalf("RouteQuery: %d routes, want 1", len(routing.Routes))
+	}
+	route := routing.Routes[0]
+	if route.Keyspace != "TestRouter" || route.Vindex != "user_index" || !route.Resolved {
+		t.Errorf("RouteQuery: %+v, want a resolved route of user_index in TestRouter", route)
+	}
+	if want := []string{"-20", "40-60"}; !reflect.DeepEqual(route.Shards, want) {
+		t.Errorf("RouteQuery shards: %v, want %v", route.Shards, want)
+	}
+	if len(route.VindexValues) != 2 {
+		t.Fatalf("RouteQuery vindex values: %+v, want 2", route.VindexValues)
+	}
+	for i, want := range []struct {
+		value interface{}
+		shard string
+	}{{1, "-20"}, {3, "40-60"}} {
+		got := route.VindexValues[i]
+		if got.Value != want.value || len(got.KeyspaceIds) != 1 || !reflect.DeepEqual(got.Shards, []string{want.shard}) {
+			t.Errorf("RouteQuery vindex value %d: %+v, want %v in %s", i, got, want.value, want.shard)
+		}
+	}
+
+	// A DML is routed with the keyspace id of its row.
+	routing, err = router.RouteQuery(context.Background(), &proto.Query{
+		Sql:           "update user set a = 2 where id = :id",
+		BindVariables: map[string]interface{}{"id": 3},
+		TabletType:    topo.TYPE_MASTER,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	route = routing.Routes[0]
+	if want := []string{"40-60"}; !reflect.DeepEqual(route.Shards, want) {
+		t.Errorf("RouteQuery shards: %v, want %v", route.Shards, want)
+	}
+	if len(route.VindexValues) != 1 || route.VindexValues[0].KeyspaceIds[0] == "" {
+		t.Errorf("RouteQuery vindex values: %+v, want the keyspace id of 3", route.VindexValues)
+	}
+
+	// The shards of an insert are only known when it's executed.
+	routing, err = router.RouteQuery(context.Background(), &proto.Query{
+		Sql:        "insert into user(id, name) values (1, 'a')",
+		TabletType: topo.TYPE_MASTER,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if route = routing.Routes[0]; route.Resolved || route.Shards != nil {
+		t.Errorf("RouteQuery: %+v, want an unresolved route", route)
+	}
+
+	if sbc1.ExecCount != 0 || sbc2.ExecCount != 0 {
+		t.Errorf("ExecCount: %v, %v, want 0, 0", sbc1.ExecCount, sbc2.ExecCount)
+	}
+
+	// The HTTP handler decodes the bind vars.
+	response := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/debug/route?"+url.Values{
+		"sql":       {"select * from user where id = :id"},
+		"bind_vars": {`{"id": 3}`},
+	}.Encode(), nil)
+	router.serveRoute(response, request)
+	var got struct {
+		TabletType topo.TabletType
+		Routes     []struct {
+			PlanID       string
+			Shards       []string
+			VindexValues []VindexRoute
+		}
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &got); err != nil {
+		t.Fatalf("%v: %s", err, response.Body.String())
+	}
+	if got.TabletType != topo.TYPE_MASTER || len(got.Routes) != 1 {
+		t.Fatalf("/debug/route: %+v, want a single route for master", got)
+	}
+	if r := got.Routes[0]; r.PlanID != "SelectEqual" || !reflect.DeepEqual(r.Shards, []string{"40-60"}) || len(r.VindexValues) != 1 {
+		t.Errorf("/debug/route: %+v, want the SelectEqual of 3 in 40-60", r)
+	}
+
+	response = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/debug/route?sql=select+1&bind_vars=x", nil)
+	router.serveRoute(response, request)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("/debug/route with invalid bind vars: %d, want 400", response.Code)
+	}
+}
+
+func TestDecodeBindVars(t *testing.T) {
+	got, err := decodeBindVars(`{"a": 1, "b": 18446744073709551615, "c": 1.5, "d": "x", "e": [1, "y"], "f": null}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"a": int64(1),
+		"b": uint64(18446744073709551615),
+		"c": 1.5,
+		"d": "x",
+		"e": []interface{}{int64(1), "y"},
+		"f": nil,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeBindVars: %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"container/heap"
+	"flag"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/sqlparser"
+)
+
+var queryStatsTopK = flag.Int("query_stats_top_k", 100, "number of query fingerprints kept by count, by time and by rows for /debug/query_stats/top, 0 disables them")
+
+// queryFingerprint returns the fingerprint of sql: its literals, bind
+// vars and lists of values are replaced with ?, its comments are
+// dropped, and only the first row of its values is kept, so that the
+// queries that have the same shape share a fingerprint. A query that
+// can't be parsed is its own fingerprint.
+func queryFingerprint(sql string) string {
+	stmt, err := sqlparser.Parse(sql)
+	if err != nil {
+		return sql
+	}
+	buf := sqlparser.NewTrackedBuffer(func(buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+		switch node := node.(type) {
+		case sqlparser.Comments:
+			return
+		case sqlparser.StrVal, sqlparser.NumVal, sqlparser.ValArg:
+			buf.Myprintf("?")
+			return
+		case sqlparser.ListArg:
+			buf.Myprintf("(?)")
+			return
+		case sqlparser.ValTuple:
+			if isValueList(node) {
+				buf.Myprintf("(?)")
+				return
+			}
+		case sqlparser.Values:
+			if len(node) > 1 {
+				buf.Myprintf("values %v", node[0])
+				return
+			}
+		}
+		node.Format(buf)
+	})
+	buf.Myprintf("%v", stmt)
+	return buf.String()
+}
+
+// isValueList returns true if tuple only holds values.
+func isValueList(tuple sqlparser.ValTuple) bool {
+	for _, expr := range tuple {
+		switch expr.(type) {
+		case sqlparser.StrVal, sqlparser.NumVal, sqlparser.ValArg, *sqlparser.NullVal:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// FingerprintStats are the stats of the queries of a fingerprint,
+// since it entered its top-K list. Overestimate is the weight it
+// inherited from the fingerprint it replaced in the list: the
+// weight it's ranked by is at most that much higher than its own.
+type FingerprintStats struct {
+	Fingerprint  string
+	Count        int64
+	Time         time.Duration
+	Rows         int64
+	Errors       int64
+	Overestimate int64
+}
+
+// topKEntry is a fingerprint of a topKList.
+type topKEntry struct {
+	FingerprintStats
+	weight int64
+	index  int
+}
+
+// topKList keeps the k heaviest fingerprints by weight with the
+// Space-Saving algorithm: a fingerprint that isn't in the full list
+// replaces the lightest one, and starts from its weight. The
+// fingerprints heavier than 1/k of the total weight are always in the
+// list, in a memory that doesn't depend on the number of fingerprints.
+type topKList struct {
+	k       int
+	weight  func(duration time.Duration, rowCount int64) int64
+	entries map[string]*topKEntry
+	// heap orders the entries from the lightest.
+	heap topKHeap
+}
+
+func newTopKList(k int, weight func(duration time.Duration, rowCount int64) int64) *topKList {
+	return &topKList{
+		k:       k,
+		weight:  weight,
+		entries: make(map[string]*topKEntry),
+	}
+}
+
+func (tkl *topKList) add(fingerprint string, duration time.Duration, rowCount int64, err error) {
+	weight := tkl.weight(duration, rowCount)
+	entry, ok := tkl.entries[fingerprint]
+	if !ok {
+		if weight == 0 && len(tkl.heap) == tkl.k {
+			// It would be the lightest entry.
+			return
+		}
+		entry = &topKEntry{FingerprintStats: FingerprintStats{Fingerprint: fingerprint}}
+		if len(tkl.heap) < tkl.k {
+			heap.Push(&tkl.heap, entry)
+		} else {
+			lightest := tkl.heap[0]
+			delete(tkl.entries, lightest.Fingerprint)
+			entry.weight = lightest.weight
+			entry.Overestimate = lightest.weight
+			entry.index = 0
+			tkl.heap[0] = entry
+		}
+		tkl.entries[fingerprint] = entry
+	}
+	entry.weight += weight
+	entry.Count++
+	entry.Time += duration
+	entry.Rows += rowCount
+	if err != nil {
+		entry.Errors++
+	}
+	heap.Fix(&tkl.heap, entry.index)
+}
+
+// list returns the stats of the fingerprints, the heaviest first.
+func (tkl *topKList) list() []FingerprintStats {
+	entries := make([]*topKEntry, len(tkl.heap))
+	copy(entries, tkl.heap)
+	sort.Sort(byTopKWeight(entries))
+	result := make([]FingerprintStats, len(entries))
+	for i, entry := range entries {
+		result[i] = entry.FingerprintStats
+	}
+	return result
+}
+
+// byTopKWeight sorts the entries from the heaviest. Unlike
+// topKHeap, it leaves the indexes of the entries alone.
+type byTopKWeight []*topKEntry
+
+func (b byTopKWeight) Len() int           { return len(b) }
+func (b byTopKWeight) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byTopKWeight) Less(i, j int) bool { return b[i].weight > b[j].weight }
+
+// topKHeap is the heap of the entries of a
+// topKList, which keeps their indexes up to date.
+type topKHeap []*topKEntry
+
+func (h topKHeap) Len() int           { return len(h) }
+func (h topKHeap) Less(i, j int) bool { return h[i].weight < h[j].weight }
+
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *topKHeap) Push(x interface{}) {
+	entry := x.(*topKEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *topKHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// queryTopK keeps the top-K fingerprints of the queries
+// by count, by total time and by rows returned.
+type queryTopK struct {
+	k int
+
+	mu      sync.Mutex
+	since   time.Time
+	byCount *topKList
+	byTime  *topKList
+	byRows  *topKList
+}
+
+// QueryTopKStats are the top-K fingerprints
+// of the queries executed since Since.
+type QueryTopKStats struct {
+	Since   time.Time
+	ByCount []FingerprintStats
+	ByTime  []FingerprintStats
+	ByRows  []FingerprintStats
+}
+
+// newQueryTopK creates a queryTopK. It returns
+// nil if k is 0, which disables the lists.
+func newQueryTopK(k int) *queryTopK {
+	if k <= 0 {
+		return nil
+	}
+	qtk := &queryTopK{k: k}
+	qtk.reset()
+	return qtk
+}
+
+// reset empties the lists. It does nothing if qtk is nil.
+func (qtk *queryTopK) reset() {
+	if qtk == nil {
+		return
+	}
+	qtk.mu.Lock()
+	defer qtk.mu.Unlock()
+	qtk.since = time.Now()
+	qtk.byCount = newTopKList(qtk.k, func(time.Duration, int64) int64 { return 1 })
+	qtk.byTime = newTopKList(qtk.k, func(duration time.Duration, _ int64) int64 { return int64(duration) })
+	qtk.byRows = newTopKList(qtk.k, func(_ time.Duration, rowCount int64) int64 { return rowCount })
+}
+
+// add records the execution of a query of fingerprint.
+// It does nothing if qtk is nil.
+func (qtk *queryTopK) add(fingerprint string, duration time.Duration, rowCount int64, err error) {
+	if qtk == nil {
+		return
+	}
+	qtk.mu.Lock()
+	defer qtk.mu.Unlock()
+	qtk.byCount.add(fingerprint, duration, rowCount, err)
+	qtk.byTime.add(fingerprint, duration, rowCount, err)
+	qtk.byRows.add(fingerprint, duration, rowCount, err)
+}
+
+// stats returns the lists, the heaviest fingerprints first.
+func (qtk *queryTopK) stats() QueryTopKStats {
+	if qtk == nil {
+		return QueryTopKStats{}
+	}
+	qtk.mu.Lock()
+	defer qtk.mu.Unlock()
+	return QueryTopKStats{
+		Since:   qtk.since,
+		ByCount: qtk.byCount.list(),
+		ByTime:  qtk.byTime.list(),
+		ByRows:  qtk.byRows.list(),
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+func TestQueryFingerprint(t *testing.T) {
+	for _, tcase := range []struct {
+		sql, want string
+	}{
+		{"select * from user where id = 1", "select * from user where id = ?"},
+		{"select /* uid:5 */ * from user where name = 'foo' and id in (1, 2, 3)", "select * from user where name = ? and id in (?)"},
+		{"select * from user where id in ::ids limit 10", "select * from user where id in (?) limit ?"},
+		{"insert into user(id, name) values (1, 'a'), (:id, 'b')", "insert into user(id, name) values (?)"},
+		{"update user set v = v + 1 where id = :id", "update user set v = v+? where id = ?"},
+		{"not sql", "not sql"},
+	} {
+		if got := queryFingerprint(tcase.sql); got != tcase.want {
+			t.Errorf("queryFingerprint(%q): %q, want %q", tcase.sql, got, tcase.want)
+		}
+	}
+}
+
+func TestTopKList(t *testing.T) {
+	tkl := newTopKList(2, func(time.Duration, int64) int64 { return 1 })
+	add := func(fingerprint string, n int) {
+		for i := 0; i < n; i++ {
+			tkl.add(fingerprint, time.Millisecond, 1, nil)
+		}
+	}
+	add("a", 5)
+	add("b", 2)
+	// c replaces b, the lightest, and inherits its 2 queries.
+	add("c", 1)
+	tkl.add("a", time.Millisecond, 1, fmt.Errorf("err"))
+	want := []FingerprintStats{
+		{Fingerprint: "a", Count: 6, Time: 6 * time.Millisecond, Rows: 6, Errors: 1},
+		{Fingerprint: "c", Count: 1, Time: time.Millisecond, Rows: 1, Overestimate: 2},
+	}
+	if got := tkl.list(); fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", want) {
+		t.Errorf("list: %+v, want %+v", got, want)
+	}
+	// b comes back in place of c, which now weighs 3.
+	add("b", 1)
+	if got := tkl.list(); len(got) != 2 || got[1].Fingerprint != "b" || got[1].Overestimate != 3 || got[1].Count != 1 {
+		t.Errorf("list: %+v, want b to replace c", got)
+	}
+}
+
+func TestPlannerTopK(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plr := NewPlanner(schema, 10, 0, "")
+	plr.GetPlan("select * from user where id = 1")
+	plr.AddStats("select * from user where id = 1", "", 1*time.Millisecond, 1, 1, nil)
+	// The plan of this one isn't cached: it's fingerprinted anyway.
+	plr.AddStats("select * from user where id = 2", "", 2*time.Millisecond, 0, 1, nil)
+	plr.AddStats("select * from music", "", 10*time.Millisecond, 5, 8, nil)
+
+	get := func(url string) QueryTopKStats {
+		response := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", url, nil)
+		plr.ServeHTTP(response, request)
+		var stats QueryTopKStats
+		if err := json.Unmarshal(response.Body.Bytes(), &stats); err != nil {
+			t.Fatalf("%v: %s", err, response.Body.String())
+		}
+		return stats
+	}
+	stats := get("/debug/query_stats/top")
+	if len(stats.ByCount) != 2 || stats.ByCount[0].Fingerprint != "select * from user where id = ?" || stats.ByCount[0].Count != 2 || stats.ByCount[0].Time != 3*time.Millisecond {
+		t.Errorf("ByCount: %+v, want the 2 queries of user first", stats.ByCount)
+	}
+	if len(stats.ByTime) != 2 || stats.ByTime[0].Fingerprint != "select * from music" {
+		t.Errorf("ByTime: %+v, want music first", stats.ByTime)
+	}
+	if len(stats.ByRows) != 2 || stats.ByRows[0].Fingerprint != "select * from music" || stats.ByRows[0].Rows != 5 {
+		t.Errorf("ByRows: %+v, want music first", stats.ByRows)
+	}
+
+	before := stats.Since
+	stats = get("/debug/query_stats/top?reset=true")
+	if len(stats.ByCount) != 0 || !stats.Since.After(before) {
+		t.Errorf("reset: %+v, want empty lists since after %v", stats, before)
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/bson"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/streamlog"
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// The query log records the statements routed by vtgate, with the
+// time they spent being planned, in vindex lookups and executing on
+// the shards. A sample of them is broadcast to the subscribers of
+// QueryLogger, which serve it on /debug/querylog and write it to
+// the query log file. The vindex lookups and the other queries
+// that are a part of a statement are not recorded on their own.
+
+var (
+	queryLogHandler         = flag.String("querylog_handler", "/debug/querylog", "URL handler for streaming the query log")
+	queryLogFile            = flag.String("querylog_file", "", "file the query log is written to, empty disables it")
+	queryLogFormat          = flag.String("querylog_format", "json", "format of the query log file: json, one entry per line, or bson")
+	queryLogMaxSize         = flag.Int64("querylog_max_size", 100*1024*1024, "size in bytes beyond which the query log file is rotated")
+	queryLogMaxFiles        = flag.Int("querylog_max_files", 5, "number of rotated query log files that are kept")
+	queryLogSampleRate      = flag.Float64("querylog_sample_rate", 1, "fraction of the successful statements that are recorded in the query log")
+	queryLogErrorSampleRate = flag.Float64("querylog_error_sample_rate", 1, "fraction of the failed statements that are recorded in the query log")
+
+	// QueryLogger broadcasts the entries of the query log.
+	QueryLogger = streamlog.New("VTGate", 50)
+
+	queryLogCounts = stats.NewCounters("VtgateQueryLog")
+)
+
+const queryLogKey contextKey = 4
+
+// queryLogEntry is an entry of the query log. ExecuteTime is the
+// time spent executing the statement, not counting the time of its
+// vindex lookups.
+type queryLogEntry struct {
+	Method      string
+	Sql         string
+	PlanType    string
+	Keyspace    string
+	ShardCount  int64
+	RowCount    int64
+	StartTime   time.Time
+	PlanTime    time.Duration
+	VindexTime  time.Duration
+	ExecuteTime time.Duration
+	TotalTime   time.Duration
+	Error       string
+
+	// vindexTime adds up the time of the vindex lookups,
+	// which may run concurrently.
+	vindexTime sync2.AtomicDuration
+	// shards are the calls to the shards of the statement and of
+	// its vindex lookups, recorded if the slow query log is on.
+	shards *shardLatencies
+}
+
+// withQueryLog returns a context that records the time of the
+// vindex lookups of the statement executed with it, and the entry
+// of the statement. The entry is nil if ctx already belongs to a
+// statement that the query is a part of.
+func withQueryLog(ctx context.Context, method string) (context.Context, *queryLogEntry) {
+	if _, ok := ctx.Value(queryLogKey).(*queryLogEntry); ok {
+		return ctx, nil
+	}
+	entry := &queryLogEntry{Method: method, StartTime: time.Now(), shards: new(shardLatencies)}
+	return context.WithValue(ctx, queryLogKey, entry), entry
+}
+
+// addVindexTime adds the time of a vindex lookup
+// to the entry of the statement of ctx, if any.
+func addVindexTime(ctx context.Context, duration time.Duration) {
+	if entry, ok := ctx.Value(queryLogKey).(*queryLogEntry); ok {
+		entry.vindexTime.Add(duration)
+	}
+}
+
+// record fills the entry with the execution of query with plan.
+// It does nothing if entry is nil.
+func (entry *queryLogEntry) record(query *proto.Query, plan *planbuilder.Plan, planTime, duration time.Duration, rowCount, shardCount int64, err error) {
+	if entry == nil {
+		return
+	}
+	entry.Sql = query.Sql
+	entry.PlanType = plan.ID.String()
+	entry.Keyspace = ""
+	if plan.Table != nil && plan.Table.Keyspace != nil {
+		entry.Keyspace = plan.Table.Keyspace.Name
+	}
+	entry.ShardCount = shardCount
+	entry.RowCount = rowCount
+	entry.PlanTime = planTime
+	entry.VindexTime = entry.vindexTime.Get()
+	entry.ExecuteTime = duration - entry.VindexTime
+	entry.Error = ""
+	if err != nil {
+		entry.Error = err.Error()
+	}
+}
+
+// send broadcasts the entry to the subscribers of QueryLogger if
+// it's sampled, and to those of SlowQueryLogger if it's slow. It does
+// nothing if entry is nil, or if no plan was recorded in it, like for
+// the statements answered by vtgate.
+func (entry *queryLogEntry) send() {
+	if entry == nil || entry.PlanType == "" {
+		return
+	}
+	entry.TotalTime = time.Now().Sub(entry.StartTime)
+	entry.sendSlow()
+	rate := *queryLogSampleRate
+	if entry.Error != "" {
+		rate = *queryLogErrorSampleRate
+	}
+	if rate < 1 && rand.Float64() >= rate {
+		queryLogCounts.Add("Skipped", 1)
+		return
+	}
+	queryLogCounts.Add("Sent", 1)
+	QueryLogger.Send(entry)
+}
+
+// formatQueryLog formats an entry of the query log for
+// /debug/querylog: as JSON if the format parameter is json,
+// and as a tab separated list of its fields otherwise.
+func formatQueryLog(params url.Values, message interface{}) string {
+	entry, ok := message.(*queryLogEntry)
+	if !ok {
+		return ""
+	}
+	if params.Get("format") == "json" {
+		b, err := json.Marshal(entry)
+		if err != nil {
+			return ""
+		}
+		return string(b) + "\n"
+	}
+	return fmt.Sprintf(
+		"%v\t%v\t%q\t%v\t%v\t%v\t%v\t%.6f\t%.6f\t%.6f\t%.6f\t%q\t\n",
+		entry.Method,
+		entry.StartTime.Format(time.StampMicro),
+		entry.Sql,
+		entry.PlanType,
+		entry.Keyspace,
+		entry.ShardCount,
+		entry.RowCount,
+		entry.TotalTime.Seconds(),
+		entry.PlanTime.Seconds(),
+		entry.VindexTime.Seconds(),
+		entry.ExecuteTime.Seconds(),
+		entry.Error,
+	)
+}
+
+// queryLogWriter writes the entries of a log to a file, which is
+// rotated once it would grow larger than maxSize: the file is renamed
+// to <path>.1, the previous <path>.1 to <path>.2, and so on, up to
+// maxFiles rotated files. Its rotations and writes are counted in
+// counts.
+type queryLogWriter struct {
+	path     string
+	format   string
+	maxSize  int64
+	maxFiles int
+	counts   *stats.Counters
+
+	// mu protects the fields below.
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func newQueryLogWriter(path, format string, maxSize int64, maxFiles int, counts *stats.Counters) (*queryLogWriter, error) {
+	if format != "json" && format != "bson" {
+		return nil, fmt.Errorf("invalid query log format %q, want json or bson", format)
+	}
+	qlw := &queryLogWriter{
+		path:     path,
+		format:   format,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		counts:   counts,
+	}
+	if err := qlw.open(); err != nil {
+		return nil, err
+	}
+	return qlw, nil
+}
+
+// open opens the file of the log, and appends to it if it exists.
+func (qlw *queryLogWriter) open() error {
+	file, err := os.OpenFile(qlw.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	qlw.file = file
+	qlw.size = fi.Size()
+	return nil
+}
+
+// encode returns the entry in the format of the log.
+func (qlw *queryLogWriter) encode(entry interface{}) ([]byte, error) {
+	if qlw.format == "bson" {
+		return bson.Marshal(entry)
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func (qlw *queryLogWriter) write(entry interface{}) error {
+	b, err := qlw.encode(entry)
+	if err != nil {
+		return err
+	}
+	qlw.mu.Lock()
+	defer qlw.mu.Unlock()
+	if qlw.file == nil {
+		return fmt.Errorf("query log %s is closed", qlw.path)
+	}
+	if qlw.size > 0 && qlw.size+int64(len(b)) > qlw.maxSize {
+		if err := qlw.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := qlw.file.Write(b)
+	qlw.size += int64(n)
+	return err
+}
+
+// rotate renames the file of the log, and opens a new one.
+// qlw.mu has to be locked.
+func (qlw *queryLogWriter) rotate() error {
+	if err := qlw.file.Close(); err != nil {
+		return err
+	}
+	qlw.file = nil
+	for i := qlw.maxFiles - 1; i > 0; i-- {
+		if err := os.Rename(qlw.rotatedPath(i), qlw.rotatedPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	var err error
+	if qlw.maxFiles > 0 {
+		err = os.Rename(qlw.path, qlw.rotatedPath(1))
+	} else {
+		err = os.Remove(qlw.path)
+	}
+	if err != nil {
+		return err
+	}
+	qlw.counts.Add("Rotations", 1)
+	return qlw.open()
+}
+
+// rotatedPath returns the path of the i-th rotated file.
+func (qlw *queryLogWriter) rotatedPath(i int) string {
+	return fmt.Sprintf("%s.%d", qlw.path, i)
+}
+
+// run writes the entries received on ch until it's closed.
+func (qlw *queryLogWriter) run(ch chan interface{}) {
+	for message := range ch {
+		if err := qlw.write(message); err != nil {
+			qlw.counts.Add("WriteErrors", 1)
+			log.Warningf("Cannot write to log %s: %v", qlw.path, err)
+			continue
+		}
+		qlw.counts.Add("Written", 1)
+	}
+}
+
+func (qlw *queryLogWriter) close() error {
+	qlw.mu.Lock()
+	defer qlw.mu.Unlock()
+	if qlw.file == nil {
+		return nil
+	}
+	err := qlw.file.Close()
+	qlw.file = nil
+	return err
+}
+
+// initQueryLog serves the query log on the handler set by the
+// querylog_handler flag, and writes it to the file set by the
+// querylog_file flag, if any.
+func initQueryLog() {
+	if *queryLogHandler != "" {
+		QueryLogger.ServeLogs(*queryLogHandler, formatQueryLog)
+	}
+	if *queryLogFile == "" {
+		return
+	}
+	qlw, err := newQueryLogWriter(*queryLogFile, *queryLogFormat, *queryLogMaxSize, *queryLogMaxFiles, queryLogCounts)
+	if err != nil {
+		log.Fatalf("Cannot open the query log: %v", err)
+	}
+	go qlw.run(QueryLogger.Subscribe("File"))
+}
@@ -0,0 +1,170 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/bson"
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestRouterQueryLog(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("-20", sbc)
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	ch := QueryLogger.Subscribe("Test")
+	defer QueryLogger.Unsubscribe(ch)
+	// receive returns the entry of sql, skipping
+	// the entries of the statements of other tests.
+	receive := func(sql string) *queryLogEntry {
+		for {
+			select {
+			case message := <-ch:
+				if entry := message.(*queryLogEntry); entry.Sql == sql {
+					return entry
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no query log entry for %s", sql)
+			}
+		}
+	}
+
+	// The id of user is generated by its lookup vindex, whose
+	// insert is a part of the statement: it has no entry.
+	sent := queryLogCounts.Counts()["Sent"]
+	sbclookup.setResults([]*mproto.QueryResult{{RowsAffected: 1, InsertId: 1}})
+	sbc.setResults([]*mproto.QueryResult{{RowsAffected: 1}})
+	q := &proto.Query{
+		Sql:        "insert into user(v, name) values (2, 'myname')",
+		TabletType: topo.TYPE_MASTER,
+	}
+	if _, err := router.Execute(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	entry := receive(q.Sql)
+	if got := queryLogCounts.Counts()["Sent"] - sent; got != 1 {
+		t.Errorf("VtgateQueryLog[Sent]: %d more, want 1", got)
+	}
+	if entry.Method != "Execute" || entry.PlanType != "InsertSharded" || entry.Keyspace != "TestRouter" || entry.ShardCount != 1 || entry.Error != "" {
+		t.Errorf("entry: %+v, want an Execute of InsertSharded on 1 shard of TestRouter", entry)
+	}
+	if entry.TotalTime < entry.PlanTime+entry.ExecuteTime {
+		t.Errorf("TotalTime: %v, want at least %v + %v", entry.TotalTime, entry.PlanTime, entry.ExecuteTime)
+	}
+
+	sbc.mustFailServer = 1
+	q.Sql = "select * from user where id = 1"
+	if _, err := router.Execute(context.Background(), q); err == nil {
+		t.Fatal("router.Execute: nil, want error")
+	}
+	if entry := receive(q.Sql); entry.PlanType != "SelectEqual" || !strings.Contains(entry.Error, "error: err") {
+		t.Errorf("entry: %+v, want a failed SelectEqual", entry)
+	}
+
+	// The statements are not sampled with a rate of 0.
+	defer func(rate float64) { *queryLogSampleRate = rate }(*queryLogSampleRate)
+	*queryLogSampleRate = 0
+	count := queryLogCounts.Counts()["Skipped"]
+	if _, err := router.Execute(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	if got := queryLogCounts.Counts()["Skipped"] - count; got != 1 {
+		t.Errorf("VtgateQueryLog[Skipped]: %d more, want 1", got)
+	}
+}
+
+func TestFormatQueryLog(t *testing.T) {
+	entry := &queryLogEntry{Method: "Execute", Sql: "select 1", PlanType: "SelectUnsharded"}
+	var decoded queryLogEntry
+	if err := json.Unmarshal([]byte(formatQueryLog(url.Values{"format": {"json"}}, entry)), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Sql != entry.Sql || decoded.PlanType != entry.PlanType {
+		t.Errorf("json: %+v, want %+v", decoded, entry)
+	}
+	if got := formatQueryLog(nil, entry); !strings.HasPrefix(got, "Execute\t") || !strings.Contains(got, "\t\"select 1\"\tSelectUnsharded\t") {
+		t.Errorf("text: %q", got)
+	}
+}
+
+func TestQueryLogWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "querylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := path.Join(dir, "querylog")
+
+	if _, err := newQueryLogWriter(logPath, "xml", 1024, 2, queryLogCounts); err == nil {
+		t.Errorf("newQueryLogWriter(xml): nil, want error")
+	}
+
+	entry := &queryLogEntry{Method: "Execute", Sql: "select 1", PlanType: "SelectUnsharded", ShardCount: 1}
+	b, err := bson.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each file holds two entries: the fifth entry
+	// rotates the log a second time.
+	qlw, err := newQueryLogWriter(logPath, "bson", int64(2*len(b)), 1, queryLogCounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qlw.close()
+	for i := 0; i < 5; i++ {
+		if err := qlw.write(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for file, want := range map[string]int{
+		logPath:            1,
+		qlw.rotatedPath(1): 2,
+		qlw.rotatedPath(2): 0,
+	} {
+		data, err := ioutil.ReadFile(file)
+		if want == 0 {
+			if !os.IsNotExist(err) {
+				t.Errorf("%s: %v, want it to not exist", file, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != want*len(b) {
+			t.Errorf("%s: %d bytes, want %d entries of %d bytes", file, len(data), want, len(b))
+		}
+		var decoded queryLogEntry
+		if err := bson.Unmarshal(data[:len(b)], &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Sql != entry.Sql || decoded.ShardCount != entry.ShardCount {
+			t.Errorf("%s: %+v, want %+v", file, decoded, entry)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"time"
+
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/logutil"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// The sessions that read their own writes record the replication
+// position of the master of each shard they commit to. A later
+// replica or rdonly read of the shard first waits for the replica
+// to reach that position, on the connection the read is then sent
+// on, and goes to the master if it doesn't in time.
+
+var (
+	positionQuery         = flag.String("read_after_write_position_query", "select @@global.gtid_binlog_pos", "query that returns the replication position of a master, for the sessions that read their own writes")
+	waitPositionQuery     = flag.String("read_after_write_wait_query", "select master_gtid_wait(:position, :timeout)", "query that waits for at most :timeout seconds for a replica to reach the replication position :position, and returns 0 if it did")
+	readAfterWriteTimeout = flag.Duration("read_after_write_timeout", 1*time.Second, "how long a read of a session that reads its own writes waits for the replica to catch up, before it's sent to the master; 0 sends it to the master without waiting")
+)
+
+// Results of the reads that wait for the writes of their session.
+const (
+	// ReadCaughtUp means the replica reached the position
+	// of the write, and was read from.
+	ReadCaughtUp = "CaughtUp"
+	// ReadFromMaster means the read was sent to the master.
+	ReadFromMaster = "Master"
+)
+
+var (
+	readAfterWriteReads = stats.NewMultiCounters("VtgateReadAfterWrite", []string{"Keyspace", "DbType", "Result"})
+	logPosition         = logutil.NewThrottledLogger("ReadAfterWrite", 5*time.Second)
+)
+
+// writePosition returns the replication position the session
+// wrote at on the shard. ok is false if the session doesn't read
+// its own writes, or hasn't written to the shard. The position is
+// empty if it couldn't be read after the write.
+func (session *SafeSession) writePosition(keyspace, shard string) (position string, ok bool) {
+	if session == nil || session.Session == nil {
+		return "", false
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if !session.ReadAfterWrite {
+		return "", false
+	}
+	for _, shardPosition := range session.WritePositions {
+		if shardPosition.Keyspace == keyspace && shardPosition.Shard == shard {
+			return shardPosition.Position, true
+		}
+	}
+	return "", false
+}
+
+// setWritePosition records the replication position the
+// session wrote at on the shard.
+func (session *SafeSession) setWritePosition(keyspace, shard, position string) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	for _, shardPosition := range session.WritePositions {
+		if shardPosition.Keyspace == keyspace && shardPosition.Shard == shard {
+			shardPosition.Position = position
+			return
+		}
+	}
+	session.WritePositions = append(session.WritePositions, &proto.ShardPosition{
+		Keyspace: keyspace,
+		Shard:    shard,
+		Position: position,
+	})
+}
+
+// readsAfterWrite returns true if the session reads its own writes.
+func (session *SafeSession) readsAfterWrite() bool {
+	if session == nil || session.Session == nil {
+		return false
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.ReadAfterWrite
+}
+
+// recordWritePositions records the replication positions of the
+// masters of shardSessions after they were committed, if the
+// session reads its own writes. If the position of a master can't
+// be read, the next reads of its shard go to the master.
+func (stc *ScatterConn) recordWritePositions(ctx context.Context, session *SafeSession, shardSessions []*proto.ShardSession) {
+	if !session.readsAfterWrite() {
+		return
+	}
+	for _, shardSession := range shardSessions {
+		if shardSession.TabletType != topo.TYPE_MASTER {
+			continue
+		}
+		sdc := stc.getConnection(ctx, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		qr, err := sdc.Execute(ctx, *positionQuery, nil, 0)
+		position := ""
+		if err == nil && len(qr.Rows) == 1 && len(qr.Rows[0]) == 1 {
+			position = qr.Rows[0][0].String()
+		} else {
+			logPosition.Warningf("cannot read the position of %v/%v, its next reads go to the master: %v", shardSession.Keyspace, shardSession.Shard, err)
+		}
+		session.setWritePosition(shardSession.Keyspace, shardSession.Shard, position)
+	}
+}
+
+// readAfterWriteType returns the tablet type a read of tabletType
+// is sent to on the shard: tabletType if the session didn't write
+// to the shard, or if the replica reached the position of the
+// write in time, and master otherwise.
+func (stc *ScatterConn) readAfterWriteType(ctx context.Context, session *SafeSession, keyspace, shard string, tabletType topo.TabletType) topo.TabletType {
+	if tabletType != topo.TYPE_REPLICA && tabletType != topo.TYPE_RDONLY {
+		return tabletType
+	}
+	position, ok := session.writePosition(keyspace, shard)
+	if !ok {
+		return tabletType
+	}
+	if position != "" && *readAfterWriteTimeout > 0 {
+		sdc := stc.getConnection(ctx, keyspace, shard, tabletType)
+		bindVars := map[string]interface{}{
+			"position": position,
+			"timeout":  readAfterWriteTimeout.Seconds(),
+		}
+		qr, err := sdc.Execute(ctx, *waitPositionQuery, bindVars, 0)
+		if err == nil && len(qr.Rows) == 1 && len(qr.Rows[0]) == 1 && qr.Rows[0][0].String() == "0" {
+			readAfterWriteReads.Add([]string{keyspace, string(tabletType), ReadCaughtUp}, 1)
+			return tabletType
+		}
+	}
+	readAfterWriteReads.Add([]string{keyspace, string(tabletType), ReadFromMaster}, 1)
+	return topo.TYPE_MASTER
+}
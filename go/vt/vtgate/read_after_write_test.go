@@ -0,0 +1,84 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func stringResult(value string) *mproto.QueryResult {
+	return &mproto.QueryResult{
+		Fields:       []mproto.Field{{Name: "value", Type: mproto.VT_VAR_STRING}},
+		RowsAffected: 1,
+		Rows:         [][]sqltypes.Value{{sqltypes.MakeString([]byte(value))}},
+	}
+}
+
+func TestReadAfterWrite(t *testing.T) {
+	keyspace := "TestReadAfterWrite"
+	s := createSandbox(keyspace)
+	sbc := &sandboxConn{}
+	s.MapTestConn("0", sbc)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+	ctx := context.Background()
+
+	session := NewSafeSession(&proto.Session{InTransaction: true, ReadAfterWrite: true})
+	if _, err := stc.Execute(ctx, "update a set b = 1", nil, keyspace, []string{"0"}, topo.TYPE_MASTER, session); err != nil {
+		t.Fatal(err)
+	}
+	sbc.setResults([]*mproto.QueryResult{stringResult("0-1-5")})
+	if err := stc.Commit(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+	wantPositions := []*proto.ShardPosition{{Keyspace: keyspace, Shard: "0", Position: "0-1-5"}}
+	if !reflect.DeepEqual(session.WritePositions, wantPositions) {
+		t.Errorf("WritePositions: %+v, want %+v", session.WritePositions, wantPositions)
+	}
+
+	for _, tcase := range []struct {
+		waitResult string
+		want       string
+	}{
+		{"0", ReadCaughtUp},
+		{"-1", ReadFromMaster},
+	} {
+		counts := readAfterWriteReads.Counts()
+		key := keyspace + ".replica." + tcase.want
+		sbc.Queries = nil
+		sbc.BindVars = nil
+		sbc.setResults([]*mproto.QueryResult{stringResult(tcase.waitResult)})
+		if _, err := stc.Execute(ctx, "select * from a", nil, keyspace, []string{"0"}, topo.TYPE_REPLICA, session); err != nil {
+			t.Fatal(err)
+		}
+		wantQueries := []string{*waitPositionQuery, "select * from a"}
+		if !reflect.DeepEqual(sbc.Queries, wantQueries) {
+			t.Errorf("queries: %v, want %v", sbc.Queries, wantQueries)
+		}
+		if sbc.BindVars[0]["position"] != "0-1-5" {
+			t.Errorf("wait bind variables: %v, want the position of the write", sbc.BindVars[0])
+		}
+		if got := readAfterWriteReads.Counts()[key] - counts[key]; got != 1 {
+			t.Errorf("%v: %v more, want 1", key, got)
+		}
+	}
+
+	// The sessions that don't read their own writes don't wait.
+	session.ReadAfterWrite = false
+	sbc.Queries = nil
+	if _, err := stc.Execute(ctx, "select * from a", nil, keyspace, []string{"0"}, topo.TYPE_REPLICA, session); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"select * from a"}; !reflect.DeepEqual(sbc.Queries, want) {
+		t.Errorf("queries: %v, want %v", sbc.Queries, want)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// A read on the master that fails because the master can't be
+// reached, as during a reparent, can be retried on a replica if
+// the query sets ReplicaFallback. The staleness of the rows is
+// bounded by only using the replicas whose replication lag is
+// not reported as high by their health check.
+
+// replicaFallbacks counts the reads retried on a replica.
+var replicaFallbacks = stats.NewInt("VtgateReplicaFallbacks")
+
+// laggingReplicaFilter is a SrvTopoServer that doesn't return
+// the endpoints whose replication lag is high.
+type laggingReplicaFilter struct {
+	SrvTopoServer
+}
+
+// GetEndPoints returns the healthy endpoints of the shard, and
+// fails if all of them are lagging. Unlike the endpoints of
+// ResilientSrvTopoServer, it never falls back to lagging ones.
+func (f laggingReplicaFilter) GetEndPoints(ctx context.Context, cell, keyspace, shard string, tabletType topo.TabletType) (*topo.EndPoints, error) {
+	endPoints, err := f.SrvTopoServer.GetEndPoints(ctx, cell, keyspace, shard, tabletType)
+	if err != nil {
+		return nil, err
+	}
+	healthy := make([]topo.EndPoint, 0, len(endPoints.Entries))
+	for _, ep := range endPoints.Entries {
+		if endPointIsHealthy(ep) {
+			healthy = append(healthy, ep)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil, fmt.Errorf("no %v tablet of %s/%s within the replication lag bound", tabletType, keyspace, shard)
+	}
+	return &topo.EndPoints{Entries: healthy}, nil
+}
+
+// replicaFallback returns the ScatterConn that reads from the
+// replicas of stc that are not lagging.
+func (stc *ScatterConn) replicaFallback() *ScatterConn {
+	stc.mu.Lock()
+	defer stc.mu.Unlock()
+	if stc.fallbackConn == nil {
+		stc.fallbackConn = stc.derive(laggingReplicaFilter{stc.toposerv}, stc.cell)
+	}
+	return stc.fallbackConn
+}
+
+// canFallback returns true if query failed with err because the
+// master was unreachable, and can be retried on a replica: it
+// must be a read outside of a transaction that allows it.
+func canFallback(query *proto.Query, err error) bool {
+	if !query.ReplicaFallback || query.TabletType != topo.TYPE_MASTER {
+		return false
+	}
+	if session := query.Session; session != nil && (session.InTransaction || len(session.ReservedSessions) != 0) {
+		return false
+	}
+	if !masterUnreachable(err) || reserveModeFor(query.Sql) == needReserved {
+		return false
+	}
+	stmt, perr := sqlparser.Parse(query.Sql)
+	if perr != nil {
+		return false
+	}
+	switch stmt := stmt.(type) {
+	case *sqlparser.Select:
+		return stmt.Lock == ""
+	case *sqlparser.Union:
+		return true
+	}
+	return false
+}
+
+// masterUnreachable returns true if err means that vtgate
+// couldn't reach the tablets, or that they weren't serving.
+func masterUnreachable(err error) bool {
+	connError, ok := err.(*ShardConnError)
+	return ok && !connError.InTransaction && shardUnavailable(err)
+}
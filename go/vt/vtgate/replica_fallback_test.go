@@ -0,0 +1,116 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/health"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// This file uses the sandbox_test framework.
+
+func TestCanFallback(t *testing.T) {
+	unreachable := &ShardConnError{Err: "error: conn"}
+	testcases := []struct {
+		query proto.Query
+		err   error
+		want  bool
+	}{
+		{proto.Query{Sql: "select * from t", TabletType: topo.TYPE_MASTER, ReplicaFallback: true}, unreachable, true},
+		{proto.Query{Sql: "select * from t union select * from u", TabletType: topo.TYPE_MASTER, ReplicaFallback: true}, unreachable, true},
+		{proto.Query{Sql: "select * from t", TabletType: topo.TYPE_MASTER}, unreachable, false},
+		{proto.Query{Sql: "select * from t", TabletType: topo.TYPE_REPLICA, ReplicaFallback: true}, unreachable, false},
+		{proto.Query{Sql: "select * from t for update", TabletType: topo.TYPE_MASTER, ReplicaFallback: true}, unreachable, false},
+		{proto.Query{Sql: "update t set a = 1", TabletType: topo.TYPE_MASTER, ReplicaFallback: true}, unreachable, false},
+		{proto.Query{Sql: "select * from t", TabletType: topo.TYPE_MASTER, ReplicaFallback: true, Session: &proto.Session{InTransaction: true}}, unreachable, false},
+		{proto.Query{Sql: "select * from t", TabletType: topo.TYPE_MASTER, ReplicaFallback: true}, &ShardConnError{Err: "error: err", ServerError: true}, false},
+		{proto.Query{Sql: "select * from t", TabletType: topo.TYPE_MASTER, ReplicaFallback: true}, &ShardConnError{Code: tabletconn.ERR_RETRY, Err: "retry: err", ServerError: true}, true},
+	}
+	for _, tcase := range testcases {
+		if got := canFallback(&tcase.query, tcase.err); got != tcase.want {
+			t.Errorf("canFallback(%s, %v): %v, want %v", tcase.query.Sql, tcase.err, got, tcase.want)
+		}
+	}
+}
+
+// fallbackSetup returns a Router that retries
+// each query once, and the tablet of keyspace.
+func fallbackSetup(t *testing.T, keyspace string) (*Router, *sandboxConn) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox(keyspace)
+	sbc := &sandboxConn{}
+	s.MapTestConn("0", sbc)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Millisecond, 1, 1*time.Second)
+	return NewRouter(serv, "aa", schema, "", scatterConn), sbc
+}
+
+func TestReplicaFallback(t *testing.T) {
+	router, sbc := fallbackSetup(t, TEST_UNSHARDED)
+	// The master fails both tries.
+	sbc.mustFailConn = 2
+	fallbacks := replicaFallbacks.Get()
+	q := proto.Query{
+		Sql:             "select * from music_user_map where id = 1",
+		TabletType:      topo.TYPE_MASTER,
+		ReplicaFallback: true,
+	}
+	qr, err := router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qr.Rows) != 1 {
+		t.Errorf("rows: %v, want 1 row", qr.Rows)
+	}
+	if sbc.ExecCount.Get() != 3 {
+		t.Errorf("ExecCount: %d, want 3", sbc.ExecCount.Get())
+	}
+	if got := replicaFallbacks.Get() - fallbacks; got != 1 {
+		t.Errorf("replicaFallbacks: %d, want 1", got)
+	}
+
+	// Without the option, the error is returned.
+	sbc.mustFailConn = 2
+	q.ReplicaFallback = false
+	if _, err := router.Execute(context.Background(), &q); err == nil {
+		t.Errorf("Execute without ReplicaFallback: nil, want error")
+	}
+
+	// Writes are not retried.
+	sbc.mustFailConn = 2
+	q.Sql = "update music_user_map set id = 2 where id = 1"
+	q.ReplicaFallback = true
+	if _, err := router.Execute(context.Background(), &q); err == nil {
+		t.Errorf("Execute of a write: nil, want error")
+	}
+}
+
+func TestReplicaFallbackLagging(t *testing.T) {
+	router, sbc := fallbackSetup(t, TEST_UNSHARDED)
+	sbc.endPoint.Health = map[string]string{health.ReplicationLag: health.ReplicationLagHigh}
+	sbc.mustFailConn = 2
+	q := proto.Query{
+		Sql:             "select * from music_user_map where id = 1",
+		TabletType:      topo.TYPE_MASTER,
+		ReplicaFallback: true,
+	}
+	// The only replica is lagging, so the read fails.
+	if _, err := router.Execute(context.Background(), &q); err == nil {
+		t.Errorf("Execute: nil, want error")
+	}
+	if sbc.ExecCount.Get() != 2 {
+		t.Errorf("ExecCount: %d, want 2", sbc.ExecCount.Get())
+	}
+}
@@ -5,16 +5,55 @@
 package vtgate
 
 import (
+	"fmt"
+	"time"
+
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
 	"github.com/youtube/vitess/go/vt/vtgate/proto"
 	"golang.org/x/net/context"
 )
 
+// nestedQueryKey marks the context of the queries that are executed
+// as a part of another statement, like its vindex lookups. They don't
+// change the results the session tracks for FOUND_ROWS() and
+// LAST_INSERT_ID().
+const nestedQueryKey contextKey = 7
+
+// withNestedQuery returns the context of a query
+// that's a part of the statement of ctx.
+func withNestedQuery(ctx context.Context) context.Context {
+	return context.WithValue(ctx, nestedQueryKey, true)
+}
+
+// isNestedQuery returns true if ctx is the context
+// of a query that's a part of another statement.
+func isNestedQuery(ctx context.Context) bool {
+	nested, _ := ctx.Value(nestedQueryKey).(bool)
+	return nested
+}
+
 type requestContext struct {
 	ctx    context.Context
 	query  *proto.Query
 	router *Router
+	// shardErrors collects the errors of the shards that failed
+	// if partial results are allowed, and is nil otherwise. It's
+	// shared with the contexts of the subplans.
+	shardErrors *[]error
+	// plan is the plan of a prepared statement, which is
+	// executed without planning the query again.
+	plan *planbuilder.Plan
+	// maxStaleness is the maximum replication lag of the
+	// replica and rdonly tablets of the query, if not 0.
+	maxStaleness time.Duration
+	// logEntry is the entry of the query in the query log,
+	// or nil if the query is a part of another statement.
+	logEntry *queryLogEntry
+	// routes records the routing decisions of the
+	// query if it's explained, and is nil otherwise.
+	routes *routeRecorder
 }
 
 func newRequestContext(ctx context.Context, query *proto.Query, router *Router) *requestContext {
@@ -25,12 +64,40 @@ func newRequestContext(ctx context.Context, query *proto.Query, router *Router)
 	}
 }
 
+// Execute executes a query for a vindex. The query can only use
+// a fraction of the time left before the deadline, as set by the
+// lookup_budget_fraction flag.
 func (vc *requestContext) Execute(boundQuery *tproto.BoundQuery) (*mproto.QueryResult, error) {
-	q := &proto.Query{
+	ctx, cancel := lookupContext(vc.ctx, vc.router.lookupBudgetFraction)
+	defer cancel()
+	ctx, span := startSpan(ctx, "Router.VindexLookup")
+	defer span.Finish()
+	startTime := time.Now()
+	result, err := vc.router.Execute(withNestedQuery(ctx), vc.newQuery(boundQuery))
+	addVindexTime(vc.ctx, time.Now().Sub(startTime))
+	if err != nil && vc.ctx.Err() == nil && budgetUsed(ctx) {
+		budgetExhausted.Add("Lookup", 1)
+		return nil, fmt.Errorf("deadline_exceeded: vindex lookup used up its share of the deadline: %v", err)
+	}
+	return result, err
+}
+
+// executeQuery executes a query that's part of the
+// execution of the query of vc, with the time that's left.
+func (vc *requestContext) executeQuery(boundQuery *tproto.BoundQuery) (*mproto.QueryResult, error) {
+	return vc.router.Execute(withNestedQuery(vc.ctx), vc.newQuery(boundQuery))
+}
+
+// newQuery returns the query that executes
+// boundQuery with the options of vc.
+func (vc *requestContext) newQuery(boundQuery *tproto.BoundQuery) *proto.Query {
+	return &proto.Query{
 		Sql:           boundQuery.Sql,
 		BindVariables: boundQuery.BindVariables,
 		TabletType:    vc.query.TabletType,
 		Session:       vc.query.Session,
+
+		ReplicaFallback: vc.query.ReplicaFallback,
+		Priority:        vc.query.Priority,
 	}
-	return vc.router.Execute(vc.ctx, q)
 }
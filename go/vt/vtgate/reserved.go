@@ -0,0 +1,198 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// reservedCounts counts the reserved connections vtgate
+// reserved, released, and found dropped by the tablets.
+var reservedCounts = stats.NewCounters("VtgateReservedConnections")
+
+// reserveMode tells multiGo how an action uses
+// the reserved connections of the session.
+type reserveMode int
+
+const (
+	// noReserved actions never execute on a reserved
+	// connection, like streaming queries.
+	noReserved reserveMode = iota
+	// useReserved actions execute on the reserved
+	// connection of a shard if the session has one.
+	useReserved
+	// needReserved actions change the session state,
+	// and reserve a connection if the session has none.
+	needReserved
+)
+
+// reserveModeFor returns the reserveMode of the queries. A query
+// needs a reserved connection if it changes the session state of the
+// connection: it assigns user variables, creates temporary tables,
+// takes named locks or uses prepared statements.
+func reserveModeFor(queries ...string) reserveMode {
+	for _, query := range queries {
+		if changesSessionState(query) {
+			return needReserved
+		}
+	}
+	return useReserved
+}
+
+// changesSessionState returns true if query changes the session
+// state of its connection. The statements the grammar supports are
+// checked on their AST, and the others on their tokens, so that the
+// comments and the strings are ignored either way.
+func changesSessionState(query string) bool {
+	// Most queries can't change the session state,
+	// and are not parsed again.
+	lower := strings.ToLower(query)
+	if !strings.Contains(lower, "@") && !strings.Contains(lower, "get_lock") && !strings.Contains(lower, "temporary") &&
+		!strings.Contains(lower, "prepare") && !strings.Contains(lower, "execute") && !strings.Contains(lower, "deallocate") {
+		return false
+	}
+	stmt, err := sqlparser.Parse(query)
+	if err != nil {
+		return tokensChangeSessionState(query)
+	}
+	switch stmt := stmt.(type) {
+	case *sqlparser.Set:
+		for _, expr := range stmt.Exprs {
+			if _, user, err := variableName(expr.Name); err == nil && user {
+				return true
+			}
+		}
+	case *sqlparser.DDL:
+		return stmt.Temporary
+	}
+	changes := false
+	buf := sqlparser.NewTrackedBuffer(func(buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+		if node, ok := node.(*sqlparser.FuncExpr); ok && strings.EqualFold(string(node.Name), "get_lock") {
+			changes = true
+		}
+		node.Format(buf)
+	})
+	buf.Myprintf("%v", stmt)
+	return changes
+}
+
+// tokensChangeSessionState is changesSessionState for the statements
+// the grammar doesn't support, like the prepared statements, and the
+// selects that assign user variables with := or INTO.
+func tokensChangeSessionState(query string) bool {
+	tokenizer := sqlparser.NewStringTokenizer(query)
+	var first, prevTyp int
+	var prev []byte
+	for {
+		typ, val := tokenizer.Scan()
+		switch {
+		case typ == 0:
+			return false
+		case typ == sqlparser.COMMENT:
+			continue
+		case first == 0 && typ == sqlparser.ID:
+			switch strings.ToLower(string(val)) {
+			case "prepare", "execute", "deallocate":
+				return true
+			}
+		case typ == '(' && prevTyp == sqlparser.ID && strings.EqualFold(string(prev), "get_lock"):
+			return true
+		case isUserVariable(typ, val) && (prevTyp == sqlparser.INTO || (first == sqlparser.SET && (prevTyp == sqlparser.SET || prevTyp == ','))):
+			return true
+		case typ == sqlparser.LEX_ERROR && string(val) == ":" && isUserVariable(prevTyp, prev):
+			// The tokenizer doesn't know :=.
+			return true
+		}
+		if first == 0 {
+			first = typ
+		}
+		prevTyp, prev = typ, val
+	}
+}
+
+// isUserVariable returns true if the token is a user variable.
+func isUserVariable(typ int, val []byte) bool {
+	return typ == sqlparser.ID && len(val) > 1 && val[0] == '@' && val[1] != '@'
+}
+
+// boundQueries returns the sql of queries.
+func boundQueries(queries []tproto.BoundQuery) []string {
+	sqls := make([]string, 0, len(queries))
+	for _, query := range queries {
+		sqls = append(sqls, query.Sql)
+	}
+	return sqls
+}
+
+// sqlValues returns the sql of each shard of sqls.
+func sqlValues(sqls map[string]string) []string {
+	values := make([]string, 0, len(sqls))
+	for _, sql := range sqls {
+		values = append(values, sql)
+	}
+	return values
+}
+
+// reservedID returns the id of the connection reserved for the
+// session on the shard, if the action uses it. It reserves one
+// if the action needs it, and sets the system variables of the
+// session on it.
+func (stc *ScatterConn) reservedID(context context.Context, sdc *ShardConn, keyspace, shard string, tabletType topo.TabletType, session *SafeSession, reserve reserveMode) (int64, error) {
+	if reserve == noReserved || session == nil || session.Session == nil {
+		return 0, nil
+	}
+	if reservedID := session.FindReserved(keyspace, shard, tabletType); reservedID != 0 || reserve != needReserved {
+		return reservedID, nil
+	}
+	reservedID, err := sdc.Reserve(context)
+	if err != nil {
+		return 0, err
+	}
+	if query := session.systemVariablesQuery(); query != "" {
+		if _, err := sdc.Execute(context, query, nil, reservedID); err != nil {
+			sdc.Release(context, reservedID)
+			return 0, fmt.Errorf("cannot set the session variables on %s/%s: %v", keyspace, shard, err)
+		}
+	}
+	reservedCounts.Add("Reserved", 1)
+	session.AppendReserved(&proto.ShardSession{
+		Keyspace:      keyspace,
+		TabletType:    tabletType,
+		Shard:         shard,
+		TransactionId: reservedID,
+	})
+	return reservedID, nil
+}
+
+// Release releases the connections reserved for the session.
+// Their session state is dropped.
+func (stc *ScatterConn) Release(context context.Context, session *SafeSession) error {
+	if session.InTransaction() {
+		return fmt.Errorf("cannot release reserved connections: in transaction")
+	}
+	session.mu.Lock()
+	reservedSessions := session.ReservedSessions
+	session.ReservedSessions = nil
+	session.mu.Unlock()
+	for _, shardSession := range reservedSessions {
+		sdc := stc.getConnection(context, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		if err := sdc.Release(context, shardSession.TransactionId); err != nil {
+			// The tablet drops the connection once it's idle anyway.
+			log.Warningf("Could not release reserved connection %d on %s/%s: %v", shardSession.TransactionId, shardSession.Keyspace, shardSession.Shard, err)
+			continue
+		}
+		reservedCounts.Add("Released", 1)
+	}
+	return nil
+}
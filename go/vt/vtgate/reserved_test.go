@@ -0,0 +1,136 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// This file uses the sandbox_test framework.
+
+func TestReserveModeFor(t *testing.T) {
+	testcases := []struct {
+		queries []string
+		want    reserveMode
+	}{
+		{[]string{"select 1 from t"}, useReserved},
+		{[]string{"set @a = 1"}, needReserved},
+		{[]string{"SET @a = 1"}, needReserved},
+		{[]string{"set @@session.autocommit = 1"}, useReserved},
+		{[]string{"select @a := id from t"}, needReserved},
+		{[]string{"select @a from t"}, useReserved},
+		{[]string{"select get_lock('a', 10)"}, needReserved},
+		{[]string{"create temporary table t(id int)"}, needReserved},
+		{[]string{"select 1 from t", "set @a = 1"}, needReserved},
+		{[]string{"/* comment */ set @a = 1, @@session.autocommit = 1"}, needReserved},
+		{[]string{"set @a = (select max(id) from t)"}, needReserved},
+		{[]string{"select @a:=id from t"}, needReserved},
+		{[]string{"select id from t into @a"}, needReserved},
+		{[]string{"select id into @a from t"}, needReserved},
+		{[]string{"select GET_LOCK ('a', 10) from dual"}, needReserved},
+		{[]string{"select Get_Lock('a', 10)"}, needReserved},
+		{[]string{"/* comment */ create temporary table t(id int)"}, needReserved},
+		{[]string{"prepare s from 'create temporary table t(id int)'"}, needReserved},
+		{[]string{"execute s"}, needReserved},
+		{[]string{"select 1 from t /* set @a = 1 */"}, useReserved},
+		{[]string{"select 1 from t /* @a := 1 */"}, useReserved},
+		{[]string{"select 'get_lock(' from t"}, useReserved},
+		{[]string{"select id from t where name = 'set @a = 1'"}, useReserved},
+		{[]string{"select release_lock('a') from dual"}, useReserved},
+		{[]string{"select @a = id from t"}, useReserved},
+		{[]string{"insert into t(id) values (@a)"}, useReserved},
+		{[]string{"select * from temporary_data"}, useReserved},
+		{[]string{"select id from t into outfile 'x'"}, useReserved},
+	}
+	for _, tcase := range testcases {
+		if got := reserveModeFor(tcase.queries...); got != tcase.want {
+			t.Errorf("reserveModeFor(%v): %v, want %v", tcase.queries, got, tcase.want)
+		}
+	}
+}
+
+func TestScatterConnReserved(t *testing.T) {
+	s := createSandbox("TestScatterConnReserved")
+	sbc := &sandboxConn{}
+	s.MapTestConn("0", sbc)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+	session := NewSafeSession(&proto.Session{})
+
+	// A query that doesn't change the session state
+	// doesn't reserve a connection.
+	if _, err := stc.Execute(context.Background(), "select 1", nil, "TestScatterConnReserved", []string{"0"}, "", session); err != nil {
+		t.Fatal(err)
+	}
+	if sbc.ReserveCount.Get() != 0 || len(session.ReservedSessions) != 0 {
+		t.Fatalf("ReserveCount: %d, ReservedSessions: %v, want none", sbc.ReserveCount.Get(), session.ReservedSessions)
+	}
+
+	if _, err := stc.Execute(context.Background(), "set @a = 1", nil, "TestScatterConnReserved", []string{"0"}, "", session); err != nil {
+		t.Fatal(err)
+	}
+	if sbc.ReserveCount.Get() != 1 {
+		t.Errorf("ReserveCount: %d, want 1", sbc.ReserveCount.Get())
+	}
+	reservedID := session.FindReserved("TestScatterConnReserved", "0", "")
+	if reservedID == 0 {
+		t.Fatalf("ReservedSessions: %v, want a reserved connection", session.ReservedSessions)
+	}
+
+	// The reserved connection is reused, and the
+	// transactions of the session begin on it.
+	if _, err := stc.Execute(context.Background(), "select @a", nil, "TestScatterConnReserved", []string{"0"}, "", session); err != nil {
+		t.Fatal(err)
+	}
+	if sbc.ReserveCount.Get() != 1 {
+		t.Errorf("ReserveCount: %d, want 1", sbc.ReserveCount.Get())
+	}
+	session.Session.InTransaction = true
+	if _, err := stc.Execute(context.Background(), "update t set a = @a", nil, "TestScatterConnReserved", []string{"0"}, "", session); err != nil {
+		t.Fatal(err)
+	}
+	if sbc.ReservedID != reservedID {
+		t.Errorf("Begin: reserved id %d, want %d", sbc.ReservedID, reservedID)
+	}
+	if err := stc.Release(context.Background(), session); err == nil {
+		t.Errorf("Release in transaction: nil, want error")
+	}
+	if err := stc.Commit(context.Background(), session); err != nil {
+		t.Fatal(err)
+	}
+	if len(session.ReservedSessions) != 1 {
+		t.Errorf("ReservedSessions after commit: %v, want 1", session.ReservedSessions)
+	}
+
+	if err := stc.Release(context.Background(), session); err != nil {
+		t.Fatal(err)
+	}
+	if sbc.ReleaseCount.Get() != 1 || len(session.ReservedSessions) != 0 {
+		t.Errorf("ReleaseCount: %d, ReservedSessions: %v, want 1, none", sbc.ReleaseCount.Get(), session.ReservedSessions)
+	}
+}
+
+func TestScatterConnReservedDropped(t *testing.T) {
+	s := createSandbox("TestScatterConnReservedDropped")
+	sbc := &sandboxConn{}
+	s.MapTestConn("0", sbc)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+	session := NewSafeSession(&proto.Session{})
+
+	if _, err := stc.Execute(context.Background(), "set @a = 1", nil, "TestScatterConnReservedDropped", []string{"0"}, "", session); err != nil {
+		t.Fatal(err)
+	}
+	// The tablet dropped the idle reserved connection.
+	sbc.mustFailNotTx = 1
+	if _, err := stc.Execute(context.Background(), "select @a", nil, "TestScatterConnReservedDropped", []string{"0"}, "", session); err == nil {
+		t.Fatalf("Execute: nil, want not_in_tx error")
+	}
+	if len(session.ReservedSessions) != 0 {
+		t.Errorf("ReservedSessions: %v, want none", session.ReservedSessions)
+	}
+}
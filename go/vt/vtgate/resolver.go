@@ -350,6 +350,26 @@ func (res *Resolver) Rollback(ctx context.Context, inSession *proto.Session) err
 	return res.scatterConn.Rollback(ctx, NewSafeSession(inSession))
 }
 
+// Prepare prepares a transaction for an external transaction manager.
+func (res *Resolver) Prepare(ctx context.Context, inSession *proto.Session, dtid string) ([]*proto.ShardSession, error) {
+	return res.scatterConn.Prepare(ctx, NewSafeSession(inSession), dtid)
+}
+
+// CommitPrepared commits a prepared transaction.
+func (res *Resolver) CommitPrepared(ctx context.Context, dtid string, participants []*proto.ShardSession) error {
+	return res.scatterConn.CommitPrepared(ctx, dtid, participants)
+}
+
+// RollbackPrepared rolls back a prepared transaction.
+func (res *Resolver) RollbackPrepared(ctx context.Context, dtid string, participants []*proto.ShardSession) error {
+	return res.scatterConn.RollbackPrepared(ctx, dtid, participants)
+}
+
+// Release releases the connections reserved for a session.
+func (res *Resolver) Release(ctx context.Context, inSession *proto.Session) error {
+	return res.scatterConn.Release(ctx, NewSafeSession(inSession))
+}
+
 // StrsEquals compares contents of two string slices.
 func StrsEquals(a, b []string) bool {
 	if len(a) != len(b) {
@@ -0,0 +1,64 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+)
+
+var (
+	maxResultRows  = flag.Int("max_result_rows", 0, "maximum number of rows the shards can return for a query, 0 means no limit")
+	maxResultBytes = flag.Int("max_result_bytes", 0, "maximum number of bytes the shards can return for a query, 0 means no limit")
+)
+
+// ResultLimitError is returned when the rows returned by
+// the shards for a query exceed -max_result_rows or
+// -max_result_bytes.
+type ResultLimitError struct {
+	// Unit is "rows" or "bytes".
+	Unit  string
+	Limit int
+}
+
+func (e *ResultLimitError) Error() string {
+	return fmt.Sprintf("query result exceeds the limit of %d %s", e.Limit, e.Unit)
+}
+
+// resultLimiter keeps count of the rows returned by the
+// shards for a query. It's safe for concurrent use.
+type resultLimiter struct {
+	maxRows, maxBytes int
+
+	mu    sync.Mutex
+	rows  int
+	bytes int
+	err   error
+}
+
+// add counts the rows of qr. Once a limit is exceeded,
+// it keeps returning a *ResultLimitError.
+func (rl *resultLimiter) add(qr *mproto.QueryResult) error {
+	if rl.maxRows == 0 && rl.maxBytes == 0 {
+		return nil
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.err != nil {
+		return rl.err
+	}
+	rl.rows += len(qr.Rows)
+	rl.bytes += rowsSize(qr.Rows)
+	switch {
+	case rl.maxRows != 0 && rl.rows > rl.maxRows:
+		rl.err = &ResultLimitError{Unit: "rows", Limit: rl.maxRows}
+	case rl.maxBytes != 0 && rl.bytes > rl.maxBytes:
+		rl.err = &ResultLimitError{Unit: "bytes", Limit: rl.maxBytes}
+	}
+	return rl.err
+}
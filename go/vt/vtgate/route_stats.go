@@ -0,0 +1,51 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"time"
+
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+// The route stats break down the queries routed by the plans by
+// keyspace, table, plan type and tablet type, so that the tables
+// that cause scatter traffic, and the slow routes, can be found.
+var (
+	routeLabels = []string{"Keyspace", "Table", "Plan", "DbType"}
+
+	routeTimings = stats.NewMultiTimings("VtgateRouteQueries", routeLabels)
+	routeRows    = stats.NewMultiCounters("VtgateRouteRows", routeLabels)
+	routeShards  = stats.NewMultiCounters("VtgateRouteShards", routeLabels)
+	routeErrors  = stats.NewMultiCounters("VtgateRouteErrors", routeLabels)
+)
+
+// planTable returns the name of the table of plan, or of the
+// leftmost table of a join. It's empty if plan has no table.
+func planTable(plan *planbuilder.Plan) string {
+	for ; plan != nil; plan = plan.Left {
+		if plan.Table != nil {
+			return plan.Table.Name
+		}
+	}
+	return ""
+}
+
+// recordRouteStats records the execution of query with plan in the
+// route stats. rowCount is the number of rows it returned, and
+// shardCount the number of shards it was sent to.
+func recordRouteStats(query *proto.Query, plan *planbuilder.Plan, duration time.Duration, rowCount, shardCount int64, err error) {
+	key := []string{planKeyspace(plan), planTable(plan), plan.ID.String(), string(query.TabletType)}
+	routeTimings.Add(key, duration)
+	routeRows.Add(key, rowCount)
+	routeShards.Add(key, shardCount)
+	if err != nil {
+		routeErrors.Add(key, 1)
+	}
+}
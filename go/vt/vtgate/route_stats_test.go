@@ -0,0 +1,67 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestRouterRouteStats(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	var conns []*sandboxConn
+	for _, shard := range []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"} {
+		sbc := &sandboxConn{}
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	type counts struct {
+		queries, rows, shards, errors int64
+	}
+	get := func(key string) counts {
+		return counts{
+			queries: routeTimings.Counts()[key],
+			rows:    routeRows.Counts()[key],
+			shards:  routeShards.Counts()[key],
+			errors:  routeErrors.Counts()[key],
+		}
+	}
+	scatterKey := "TestRouter.user.SelectScatter.replica"
+	equalKey := "TestRouter.user.SelectEqual.replica"
+	scatter, equal := get(scatterKey), get(equalKey)
+
+	// Each shard returns the single row result.
+	q := &proto.Query{Sql: "select * from user", TabletType: topo.TYPE_REPLICA}
+	if _, err := router.Execute(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	conns[0].mustFailServer = 1
+	q.Sql = "select * from user where id = 1"
+	if _, err := router.Execute(context.Background(), q); err == nil {
+		t.Fatal("router.Execute: nil, want error")
+	}
+
+	after := get(scatterKey)
+	if got, want := (counts{after.queries - scatter.queries, after.rows - scatter.rows, after.shards - scatter.shards, after.errors - scatter.errors}), (counts{1, 8, 8, 0}); got != want {
+		t.Errorf("%s: %+v more, want %+v", scatterKey, got, want)
+	}
+	after = get(equalKey)
+	if got, want := (counts{after.queries - equal.queries, after.rows - equal.rows, after.shards - equal.shards, after.errors - equal.errors}), (counts{1, 0, 1, 1}); got != want {
+		t.Errorf("%s: %+v more, want %+v", equalKey, got, want)
+	}
+}
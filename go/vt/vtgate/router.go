@@ -7,10 +7,21 @@ package vtgate
 // This is a V3 file. Do not intermix with V2.
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/trace"
+	"github.com/youtube/vitess/go/vt/concurrency"
 	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
 	"github.com/youtube/vitess/go/vt/vtgate/proto"
@@ -22,6 +33,14 @@ const (
 	dmlPostfix = " /* _routing keyspace_id:%v */"
 )
 
+var (
+	maxScatterDMLRows     = flag.Int("max_scatter_dml_rows", 1000, "maximum number of rows a scatter update or delete can affect, 0 means no limit")
+	insertSelectBatchSize = flag.Int("insert_select_batch_size", 100, "maximum number of rows of an insert ... select that are sent to the shards at a time")
+	bulkInsertBatchSize   = flag.Int("bulk_insert_batch_size", 500, "maximum number of rows of a bulk insert that are sent to the shards at a time")
+	dmlRoutingComment     = flag.Bool("dml_routing_comment", false, "append the keyspace id to DMLs as a comment instead of passing it to vttablet as a bind var, for vttablets that don't support the bind var")
+	maxDistinctBytes      = flag.Int("max_distinct_bytes", 16*1024*1024, "maximum memory used for removing the duplicate rows of a multi-shard distinct or union, 0 means no limit")
+)
+
 // Router is the layer to route queries to the correct shards
 // based on the values in the query.
 type Router struct {
@@ -29,25 +48,455 @@ type Router struct {
 	cell        string
 	planner     *Planner
 	scatterConn *ScatterConn
+
+	// maxScatterDMLRows is the number of affected rows beyond
+	// which a scatter update or delete is rolled back.
+	maxScatterDMLRows uint64
+	// maxDistinctBytes is the memory beyond which the removal
+	// of duplicate rows fails.
+	maxDistinctBytes int
+	// lookupBudgetFraction is the fraction of the time left before
+	// the deadline of a query that a vindex lookup can use.
+	lookupBudgetFraction float64
+	// normalizeQueries is true if the literals of the
+	// queries are replaced with bind vars before planning.
+	normalizeQueries bool
+	// v2Fallback is true if the queries that cannot be planned
+	// are routed with the keyspace ids or keyranges of the request.
+	v2Fallback bool
+	// prepared holds the statements prepared by PrepareQuery.
+	prepared *preparedRegistry
+	// slowPlans records the queries that are too slow
+	// or are sent to too many shards.
+	slowPlans *slowPlanLog
+	// keyspaces composes the schema from the schemas
+	// of the keyspaces.
+	keyspaces *keyspaceSchemas
+	// sequences holds the values reserved from the sequence
+	// tables for the auto-increment columns.
+	sequences *sequenceCache
+	// tabletTypeRules override the tablet types of the
+	// queries by keyspace.
+	tabletTypeRules *tabletTypeRules
+	// live holds the queries in flight.
+	live *liveQueryRegistry
 }
 
 // NewRouter creates a new Router.
 func NewRouter(serv SrvTopoServer, cell string, schema *planbuilder.Schema, statsName string, scatterConn *ScatterConn) *Router {
-	return &Router{
+	rtr := &Router{
 		serv:        serv,
 		cell:        cell,
-		planner:     NewPlanner(schema, 5000),
+		planner:     NewPlanner(schema, *planCacheSize, *planCacheMemory, statsName),
 		scatterConn: scatterConn,
+
+		maxScatterDMLRows: uint64(*maxScatterDMLRows),
+		maxDistinctBytes:  *maxDistinctBytes,
+
+		lookupBudgetFraction: *lookupBudgetFraction,
+		normalizeQueries:     *normalizeQueries,
+		v2Fallback:           *v2Fallback,
+		prepared:             newPreparedRegistry(*preparedStatementsMax),
+		slowPlans:            newSlowPlanLog(*slowPlanTime, *slowPlanShards, *slowPlansMax),
+		sequences:            newSequenceCache(),
+		tabletTypeRules:      newTabletTypeRules(),
+		live:                 newLiveQueryRegistry(),
+	}
+	rtr.keyspaces = newKeyspaceSchemas(rtr.planner)
+	if statsName != "" {
+		http.Handle("/debug/slow_plans", rtr.slowPlans)
+		http.Handle("/debug/queries", rtr.live)
+		http.HandleFunc("/debug/route", rtr.serveRoute)
 	}
+	return rtr
+}
+
+// SetSchema replaces the schema of the router. The queries that
+// are planned after it returns are routed with the new schema.
+// The tables discovered in the unsharded keyspaces that have no
+// schema are still routed.
+func (rtr *Router) SetSchema(schema *planbuilder.Schema) {
+	rtr.keyspaces.set(schema)
 }
 
 // Execute routes a non-streaming query.
 func (rtr *Router) Execute(ctx context.Context, query *proto.Query) (*mproto.QueryResult, error) {
-	if query.BindVariables == nil {
-		query.BindVariables = make(map[string]interface{})
+	ctx, err := withPriority(ctx, query.Priority)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, query.Session)
+	defer cancel()
+	ctx, warnings := withWarnings(ctx)
+	defer warnings.save(query)
+	ctx, logEntry := withQueryLog(ctx, "Execute")
+	defer logEntry.send()
+	ctx, span := startSpan(ctx, "Router.Execute")
+	defer span.Finish()
+	ctx, live := rtr.live.register(ctx, query)
+	defer rtr.live.unregister(live)
+	vcursor := newRequestContext(ctx, query, rtr)
+	vcursor.logEntry = logEntry
+	result, err := rtr.executeWithFallback(vcursor)
+	return result, live.killedErr(err)
+}
+
+// ExecutePartial is like Execute, but if the session allows partial
+// results and is not in a transaction, scatter selects return the
+// rows of the shards that responded as long as at least one of them
+// did. The errors of the other shards are returned separately.
+func (rtr *Router) ExecutePartial(ctx context.Context, query *proto.Query) (*mproto.QueryResult, []error, error) {
+	ctx, err := withPriority(ctx, query.Priority)
+	if err != nil {
+		return nil, nil, err
 	}
+	ctx, cancel := withQueryTimeout(ctx, query.Session)
+	defer cancel()
+	ctx, warnings := withWarnings(ctx)
+	defer warnings.save(query)
+	ctx, logEntry := withQueryLog(ctx, "ExecutePartial")
+	defer logEntry.send()
+	ctx, span := startSpan(ctx, "Router.ExecutePartial")
+	defer span.Finish()
+	ctx, live := rtr.live.register(ctx, query)
+	defer rtr.live.unregister(live)
 	vcursor := newRequestContext(ctx, query, rtr)
-	plan := rtr.planner.GetPlan(string(query.Sql))
+	vcursor.logEntry = logEntry
+	if query.Session != nil && query.Session.AllowPartialResults && !query.Session.InTransaction {
+		vcursor.shardErrors = new([]error)
+	}
+	result, err := rtr.executeWithFallback(vcursor)
+	if err != nil || vcursor.shardErrors == nil {
+		return result, nil, live.killedErr(err)
+	}
+	for _, shardErr := range *vcursor.shardErrors {
+		addWarning(ctx, proto.WarningPartialResult, "%v", shardErr)
+	}
+	return result, *vcursor.shardErrors, nil
+}
+
+// executeWithFallback executes the query of vcursor, and retries
+// it on a replica that's not lagging if it's a read that failed
+// because the master was unreachable and the query allows it.
+func (rtr *Router) executeWithFallback(vcursor *requestContext) (*mproto.QueryResult, error) {
+	result, err := rtr.execute(vcursor)
+	if err == nil || !canFallback(vcursor.query, err) {
+		return result, err
+	}
+	replicaFallbacks.Add(1)
+	query := *vcursor.query
+	query.TabletType = topo.TYPE_REPLICA
+	replicaRouter := *rtr
+	replicaRouter.scatterConn = rtr.scatterConn.replicaFallback()
+	fallback := newRequestContext(vcursor.ctx, &query, &replicaRouter)
+	fallback.plan = vcursor.plan
+	fallback.logEntry = vcursor.logEntry
+	if vcursor.shardErrors != nil {
+		*vcursor.shardErrors = nil
+		fallback.shardErrors = vcursor.shardErrors
+	}
+	result, err = replicaRouter.execute(fallback)
+	if err == nil {
+		addWarning(vcursor.ctx, proto.WarningReplicaFallback, "the master couldn't be reached, the read was served by a replica")
+	}
+	return result, err
+}
+
+func (rtr *Router) execute(vcursor *requestContext) (*mproto.QueryResult, error) {
+	if vcursor.query.BindVariables == nil {
+		vcursor.query.BindVariables = make(map[string]interface{})
+	}
+	planStart := time.Now()
+	plan := vcursor.plan
+	if plan == nil {
+		if stmt, ok := parseVTGateStatement(vcursor.query.Sql); ok {
+			return rtr.execVTGateStatement(vcursor, stmt)
+		}
+		_, span := startSpan(vcursor.ctx, "Router.Plan")
+		rtr.normalize(vcursor.query)
+		plan = rtr.planner.GetPlanIn(vcursor.query.Sql, sessionKeyspace(vcursor.query.Session))
+		span.Finish()
+	}
+	plan, err := planbuilder.ForTenant(plan, sessionTenant(vcursor.query.Session))
+	if err != nil {
+		return nil, err
+	}
+	if span, ok := trace.FromContext(vcursor.ctx); ok {
+		span.Annotate("plan", plan.ID.String())
+	}
+	startTime := time.Now()
+	planTime := startTime.Sub(planStart)
+	ctx, shardCount := withShardCount(vcursor.ctx)
+	vcursor.ctx = ctx
+	result, err := rtr.executePlan(vcursor, plan)
+	var rowCount int64
+	if result != nil {
+		rowCount = int64(len(result.Rows))
+		if err == nil && !isNestedQuery(vcursor.ctx) {
+			trackResult(vcursor.query.Session, plan, rowCount, result.InsertId)
+		}
+	}
+	duration := time.Now().Sub(startTime)
+	rtr.addStats(vcursor.query, plan, duration, rowCount, shardCount.Get(), err)
+	vcursor.logEntry.record(vcursor.query, plan, planTime, duration, rowCount, shardCount.Get(), err)
+	return result, err
+}
+
+// sessionTenant returns the tenant of session,
+// which resolves the template keyspaces.
+func sessionTenant(session *proto.Session) string {
+	if session == nil {
+		return ""
+	}
+	return session.Tenant
+}
+
+// sessionKeyspace returns the default keyspace of session,
+// which resolves the unqualified tables first.
+func sessionKeyspace(session *proto.Session) string {
+	if session == nil {
+		return ""
+	}
+	return session.DefaultKeyspace
+}
+
+// addStats records the execution of query with plan in the stats
+// of the plan and of its route, and in the slow plan log if needed.
+func (rtr *Router) addStats(query *proto.Query, plan *planbuilder.Plan, duration time.Duration, rowCount, shardCount int64, err error) {
+	rtr.planner.AddStats(query.Sql, sessionKeyspace(query.Session), duration, rowCount, shardCount, err)
+	recordRouteStats(query, plan, duration, rowCount, shardCount, err)
+	rtr.slowPlans.record(query.Sql, plan, duration, shardCount)
+}
+
+// normalize binds the user variables of the session in query,
+// and replaces the literals of query with bind vars if the router
+// normalizes queries.
+func (rtr *Router) normalize(query *proto.Query) {
+	bindUserVariables(query)
+	if rtr.normalizeQueries {
+		query.Sql = rtr.planner.Normalize(query.Sql, query.BindVariables)
+	}
+}
+
+func (rtr *Router) executePlan(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	if rtr.canFallbackV2(vcursor, plan) {
+		return rtr.execV2(vcursor)
+	}
+	if err := applyHints(vcursor, plan); err != nil {
+		return nil, err
+	}
+	rtr.applyTabletTypeRules(vcursor, plan)
+	warnScatter(vcursor, plan)
+	if err := rtr.execSubqueries(vcursor, plan); err != nil {
+		return nil, err
+	}
+	if err := checkBudget(vcursor.ctx, "Execute"); err != nil {
+		return nil, err
+	}
+	if plan.NeedsMerge() {
+		return rtr.execMerge(vcursor, plan)
+	}
+	return rtr.execPlan(vcursor, plan)
+}
+
+// applyHints applies the hints of plan that are not part of its
+// routing: the TABLET_TYPE hint changes the tablet type of the query.
+// Within a transaction, the statements have to be sent to the tablets
+// of the transaction. The MAX_STALENESS hint overrides the max
+// staleness of the session.
+func applyHints(vcursor *requestContext, plan *planbuilder.Plan) error {
+	if session := vcursor.query.Session; session != nil && session.MaxStaleness > 0 {
+		vcursor.maxStaleness = time.Duration(session.MaxStaleness)
+	}
+	if plan.Hints != nil && plan.Hints.MaxStaleness > 0 {
+		vcursor.maxStaleness = plan.Hints.MaxStaleness
+	}
+	if plan.Hints == nil || plan.Hints.TabletType == "" || plan.Hints.TabletType == vcursor.query.TabletType {
+		return nil
+	}
+	if vcursor.query.Session != nil && vcursor.query.Session.InTransaction {
+		return fmt.Errorf("TABLET_TYPE hint cannot be used in a transaction: %q", vcursor.query.Sql)
+	}
+	vcursor.query.TabletType = plan.Hints.TabletType
+	return nil
+}
+
+// parseVTGateStatement returns the statement of sql if it's one of
+// the statements that vtgate executes itself instead of sending them
+// to the shards. Only the queries that start with the keyword of
+// such a statement are parsed, and only the selects that may call a
+// session function.
+func parseVTGateStatement(sql string) (sqlparser.Statement, bool) {
+	switch sqlparser.FirstKeyword(sql) {
+	case sqlparser.SELECT:
+		lower := strings.ToLower(sql)
+		if !strings.Contains(lower, sqlparser.LastInsertID) && !strings.Contains(lower, sqlparser.FoundRows) {
+			return nil, false
+		}
+	case sqlparser.SAVEPOINT, sqlparser.ROLLBACK, sqlparser.RELEASE, sqlparser.SET, sqlparser.START,
+		sqlparser.EXPLAIN, sqlparser.DESCRIBE, sqlparser.SHOW, sqlparser.USE, sqlparser.KILL:
+	default:
+		return nil, false
+	}
+	stmt, err := sqlparser.Parse(sql)
+	if err != nil {
+		return nil, false
+	}
+	switch stmt := stmt.(type) {
+	case *sqlparser.Select:
+		_, ok := sqlparser.SessionFunctions(stmt)
+		return stmt, ok
+	case *sqlparser.Savepoint, *sqlparser.SetTransaction, *sqlparser.StartTransaction, *sqlparser.Explain,
+		*sqlparser.Show, *sqlparser.Set, *sqlparser.Use, *sqlparser.Kill:
+		return stmt, true
+	}
+	return nil, false
+}
+
+// execVTGateStatement executes a statement returned by
+// parseVTGateStatement.
+func (rtr *Router) execVTGateStatement(vcursor *requestContext, stmt sqlparser.Statement) (*mproto.QueryResult, error) {
+	switch stmt := stmt.(type) {
+	case *sqlparser.Select:
+		funcs, _ := sqlparser.SessionFunctions(stmt)
+		return rtr.execSessionSelect(vcursor, funcs)
+	case *sqlparser.Savepoint:
+		return rtr.execSavepoint(vcursor, stmt.Action, string(stmt.Name))
+	case *sqlparser.SetTransaction:
+		return rtr.execSetTransaction(vcursor, stmt.Isolation)
+	case *sqlparser.StartTransaction:
+		return rtr.execStartTransaction(vcursor, stmt.ReadOnly)
+	case *sqlparser.Explain:
+		return rtr.execExplain(vcursor, sqlparser.String(stmt.Statement))
+	case *sqlparser.Show:
+		switch stmt.Type {
+		case sqlparser.ShowWarnings:
+			return rtr.execShowWarnings(vcursor)
+		case sqlparser.ShowProcesslist:
+			return rtr.execShowProcesslist(vcursor, stmt.Full)
+		case sqlparser.ShowVitessTransactions:
+			return rtr.execShowTransactions(vcursor, string(stmt.Keyspace))
+		}
+		return rtr.execShow(vcursor, stmt.Type, string(stmt.Keyspace))
+	case *sqlparser.Set:
+		return rtr.execSet(vcursor, stmt)
+	case *sqlparser.Use:
+		return rtr.execUse(vcursor, string(stmt.DBName))
+	case *sqlparser.Kill:
+		return rtr.execKill(vcursor, stmt.ID)
+	}
+	return nil, fmt.Errorf("unexpected statement: %s", sqlparser.String(stmt))
+}
+
+// execSavepoint executes a SAVEPOINT, ROLLBACK TO SAVEPOINT or
+// RELEASE SAVEPOINT statement on all the shards of the transaction,
+// and records the savepoints of the transaction in the session,
+// so that they can be created on the shards that join it later.
+// Outside of a transaction, a savepoint is not created.
+func (rtr *Router) execSavepoint(vcursor *requestContext, kind, name string) (*mproto.QueryResult, error) {
+	session := vcursor.query.Session
+	inTransaction := session != nil && session.InTransaction
+	index := -1
+	if inTransaction {
+		for i, savepoint := range session.Savepoints {
+			if strings.EqualFold(savepoint, name) {
+				index = i
+			}
+		}
+	}
+	if kind != sqlparser.SavepointCreate && index == -1 {
+		return nil, fmt.Errorf("SAVEPOINT %s does not exist", name)
+	}
+	if !inTransaction {
+		return &mproto.QueryResult{}, nil
+	}
+	type target struct {
+		keyspace   string
+		tabletType topo.TabletType
+	}
+	var targets []target
+	shards := make(map[target][]string)
+	for _, shardSession := range session.ShardSessions {
+		t := target{shardSession.Keyspace, shardSession.TabletType}
+		if _, ok := shards[t]; !ok {
+			targets = append(targets, t)
+		}
+		shards[t] = append(shards[t], shardSession.Shard)
+	}
+	for _, t := range targets {
+		_, err := rtr.scatterConn.Execute(
+			vcursor.ctx,
+			vcursor.query.Sql,
+			vcursor.query.BindVariables,
+			t.keyspace,
+			shards[t],
+			t.tabletType,
+			NewSafeSession(session))
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch kind {
+	case sqlparser.SavepointCreate:
+		// A savepoint with the same name as an existing one
+		// replaces it.
+		if index != -1 {
+			session.Savepoints = append(session.Savepoints[:index], session.Savepoints[index+1:]...)
+		}
+		session.Savepoints = append(session.Savepoints, name)
+	case sqlparser.SavepointRollback:
+		session.Savepoints = session.Savepoints[:index+1]
+	case sqlparser.SavepointRelease:
+		session.Savepoints = session.Savepoints[:index]
+	}
+	return &mproto.QueryResult{}, nil
+}
+
+// execSetTransaction records the isolation level of the transactions
+// of the session. Unlike MySQL, it applies to all the following
+// transactions, and not only to the next one.
+func (rtr *Router) execSetTransaction(vcursor *requestContext, isolation string) (*mproto.QueryResult, error) {
+	if vcursor.query.Session == nil {
+		vcursor.query.Session = new(proto.Session)
+	}
+	if vcursor.query.Session.InTransaction {
+		return nil, errors.New("transaction characteristics can't be changed while a transaction is in progress")
+	}
+	vcursor.query.Session.TransactionIsolation = isolation
+	return &mproto.QueryResult{}, nil
+}
+
+// execUse makes keyspace the default keyspace of the session.
+// The unqualified tables of its next queries are resolved in
+// keyspace first.
+func (rtr *Router) execUse(vcursor *requestContext, keyspace string) (*mproto.QueryResult, error) {
+	schema := rtr.planner.Schema()
+	if schema == nil || len(schema.KeyspaceTables(keyspace)) == 0 {
+		return nil, fmt.Errorf("unknown keyspace: %s", keyspace)
+	}
+	if vcursor.query.Session == nil {
+		vcursor.query.Session = new(proto.Session)
+	}
+	vcursor.query.Session.DefaultKeyspace = keyspace
+	return &mproto.QueryResult{}, nil
+}
+
+// execStartTransaction begins a transaction, like VTGate.Begin.
+// The transaction is begun on each shard as it joins it, with
+// the access mode of readOnly.
+func (rtr *Router) execStartTransaction(vcursor *requestContext, readOnly bool) (*mproto.QueryResult, error) {
+	if vcursor.query.Session == nil {
+		vcursor.query.Session = new(proto.Session)
+	}
+	if vcursor.query.Session.InTransaction {
+		return nil, errors.New("cannot start a transaction while a transaction is in progress")
+	}
+	vcursor.query.Session.InTransaction = true
+	vcursor.query.Session.TransactionReadOnly = readOnly
+	return &mproto.QueryResult{}, nil
+}
+
+func (rtr *Router) execPlan(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
 	switch plan.ID {
 	case planbuilder.SelectUnsharded, planbuilder.UpdateUnsharded,
 		planbuilder.DeleteUnsharded, planbuilder.InsertUnsharded:
@@ -58,20 +507,239 @@ func (rtr *Router) Execute(ctx context.Context, query *proto.Query) (*mproto.Que
 		return rtr.execSelectIN(vcursor, plan)
 	case planbuilder.SelectKeyrange:
 		return rtr.execSelectKeyrange(vcursor, plan)
+	case planbuilder.SelectRange:
+		return rtr.execSelectRange(vcursor, plan)
 	case planbuilder.SelectScatter:
 		return rtr.execSelectScatter(vcursor, plan)
+	case planbuilder.SelectJoin:
+		return rtr.execSelectJoin(vcursor, plan)
+	case planbuilder.SelectUnion, planbuilder.SelectUnionAll:
+		return rtr.execSelectUnion(vcursor, plan)
+	case planbuilder.SelectSemiJoin, planbuilder.SelectAntiJoin:
+		return rtr.execSemiJoin(vcursor, plan)
 	case planbuilder.UpdateEqual:
 		return rtr.execUpdateEqual(vcursor, plan)
+	case planbuilder.UpdateMigrate:
+		return rtr.execUpdateMigrate(vcursor, plan)
 	case planbuilder.DeleteEqual:
 		return rtr.execDeleteEqual(vcursor, plan)
+	case planbuilder.UpdateIn, planbuilder.DeleteIn:
+		return rtr.execDMLIn(vcursor, plan)
+	case planbuilder.UpdateScatter, planbuilder.DeleteScatter:
+		return rtr.execDMLScatter(vcursor, plan)
 	case planbuilder.InsertSharded:
 		return rtr.execInsertSharded(vcursor, plan)
+	case planbuilder.InsertSelect:
+		return rtr.execInsertSelect(vcursor, plan)
 	default:
 		return nil, fmt.Errorf("plan %+v unimplemented", plan)
 	}
 }
 
+// StreamExecute executes a streaming query.
+func (rtr *Router) StreamExecute(ctx context.Context, query *proto.Query, sendReply func(*mproto.QueryResult) error) error {
+	if query.BindVariables == nil {
+		query.BindVariables = make(map[string]interface{})
+	}
+	ctx, err := withPriority(ctx, query.Priority)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withQueryTimeout(ctx, query.Session)
+	defer cancel()
+	ctx, warnings := withWarnings(ctx)
+	defer warnings.save(query)
+	ctx, logEntry := withQueryLog(ctx, "StreamExecute")
+	defer logEntry.send()
+	ctx, span := startSpan(ctx, "Router.StreamExecute")
+	defer span.Finish()
+	ctx, live := rtr.live.register(ctx, query)
+	defer rtr.live.unregister(live)
+	planStart := time.Now()
+	_, planSpan := startSpan(ctx, "Router.Plan")
+	rtr.normalize(query)
+	ctx, shardCount := withShardCount(ctx)
+	vcursor := newRequestContext(ctx, query, rtr)
+	plan, err := planbuilder.ForTenant(rtr.planner.GetPlanIn(query.Sql, sessionKeyspace(query.Session)), sessionTenant(query.Session))
+	planSpan.Finish()
+	if err != nil {
+		return err
+	}
+	span.Annotate("plan", plan.ID.String())
+	startTime := time.Now()
+	planTime := startTime.Sub(planStart)
+	var rowCount int64
+	err = rtr.streamExecute(vcursor, plan, func(qr *mproto.QueryResult) error {
+		rowCount += int64(len(qr.Rows))
+		return sendReply(qr)
+	})
+	if err == nil {
+		trackResult(query.Session, plan, rowCount, 0)
+	}
+	duration := time.Now().Sub(startTime)
+	rtr.addStats(query, plan, duration, rowCount, shardCount.Get(), err)
+	logEntry.record(query, plan, planTime, duration, rowCount, shardCount.Get(), err)
+	return live.killedErr(err)
+}
+
+func (rtr *Router) streamExecute(vcursor *requestContext, plan *planbuilder.Plan, sendReply func(*mproto.QueryResult) error) error {
+	if rtr.canFallbackV2(vcursor, plan) {
+		return rtr.streamExecV2(vcursor, sendReply)
+	}
+	if err := applyHints(vcursor, plan); err != nil {
+		return err
+	}
+	rtr.applyTabletTypeRules(vcursor, plan)
+	warnScatter(vcursor, plan)
+	if err := rtr.execSubqueries(vcursor, plan); err != nil {
+		return err
+	}
+
+	if plan.NeedsMerge() {
+		if plan.Aggregates == nil {
+			return rtr.streamMerge(vcursor, plan, sendReply)
+		}
+		// The rows have to be aggregated before they can be sent.
+		result, err := rtr.execMerge(vcursor, plan)
+		if err != nil {
+			return err
+		}
+		return sendReply(result)
+	}
+
+	var params *scatterParams
+	var err error
+	switch plan.ID {
+	case planbuilder.SelectUnsharded:
+		params, err = rtr.paramsUnsharded(vcursor, plan)
+	case planbuilder.SelectEqual:
+		params, err = rtr.paramsSelectEqual(vcursor, plan)
+	case planbuilder.SelectIN:
+		params, err = rtr.paramsSelectIN(vcursor, plan)
+	case planbuilder.SelectKeyrange:
+		params, err = rtr.paramsSelectKeyrange(vcursor, plan)
+	case planbuilder.SelectRange:
+		params, err = rtr.paramsSelectRange(vcursor, plan)
+	case planbuilder.SelectScatter:
+		params, err = rtr.paramsSelectScatter(vcursor, plan)
+	default:
+		return fmt.Errorf("query %q cannot be used for streaming", vcursor.query.Sql)
+	}
+	if err != nil {
+		return err
+	}
+	stc, err := rtr.scatterConnFor(vcursor, params)
+	if err != nil {
+		return err
+	}
+	return stc.StreamExecuteMulti(
+		vcursor.ctx,
+		params.query,
+		params.ks,
+		params.shardVars,
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		sendReply)
+}
+
+// scatterParams stores the parameters for a query
+// that will be sent to ScatterConn.
+type scatterParams struct {
+	query     string
+	ks        string
+	shardVars map[string]map[string]interface{}
+	// cell is the preferred cell of the rows, if any.
+	cell string
+}
+
+// newScatterParams creates a scatterParams that sends the
+// same bind vars to every shard.
+func newScatterParams(query, ks string, bv map[string]interface{}, shards []string) *scatterParams {
+	shardVars := make(map[string]map[string]interface{}, len(shards))
+	for _, shard := range shards {
+		shardVars[shard] = bv
+	}
+	return &scatterParams{
+		query:     query,
+		ks:        ks,
+		shardVars: shardVars,
+	}
+}
+
+// scatterConnFor returns the ScatterConn that sends the query of
+// params to the preferred cell of its rows. Transactions always
+// stay in the cell of vtgate, where they're committed. The replica
+// and rdonly reads without a preferred cell go to the cell of the
+// session, and fall back to the remote read cells for the shards
+// that have no healthy tablet in it. If they have a max staleness,
+// they only go to the tablets within it.
+func (rtr *Router) scatterConnFor(vcursor *requestContext, params *scatterParams) (*ScatterConn, error) {
+	session := vcursor.query.Session
+	if session != nil && session.InTransaction {
+		return rtr.scatterConn, nil
+	}
+	tabletType := vcursor.query.TabletType
+	if tabletType != topo.TYPE_REPLICA && tabletType != topo.TYPE_RDONLY {
+		return rtr.scatterConn.inCell(params.cell), nil
+	}
+	cell := params.cell
+	if cell == "" && session != nil {
+		cell = session.Cell
+	}
+	stc := rtr.scatterConn.inCell(cell).withRemoteReads()
+	if vcursor.maxStaleness == 0 {
+		return stc, nil
+	}
+	return rtr.boundStaleness(vcursor, params, stc)
+}
+
+// execScatterSelect sends a select to the shards of params. If
+// vcursor allows partial results, the failures of some of the
+// shards are recorded in vcursor instead of failing the query.
+func (rtr *Router) execScatterSelect(vcursor *requestContext, params *scatterParams) (*mproto.QueryResult, error) {
+	stc, err := rtr.scatterConnFor(vcursor, params)
+	if err != nil {
+		return nil, err
+	}
+	if vcursor.shardErrors == nil {
+		return stc.ExecuteMulti(
+			vcursor.ctx,
+			params.query,
+			params.ks,
+			params.shardVars,
+			vcursor.query.TabletType,
+			NewSafeSession(vcursor.query.Session))
+	}
+	result, shardErrors, err := stc.ExecuteMultiPartial(
+		vcursor.ctx,
+		params.query,
+		params.ks,
+		params.shardVars,
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session))
+	*vcursor.shardErrors = append(*vcursor.shardErrors, shardErrors...)
+	return result, err
+}
+
 func (rtr *Router) execUnsharded(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	params, err := rtr.paramsUnsharded(vcursor, plan)
+	if err != nil {
+		return nil, err
+	}
+	stc, err := rtr.scatterConnFor(vcursor, params)
+	if err != nil {
+		return nil, err
+	}
+	return stc.ExecuteMulti(
+		vcursor.ctx,
+		params.query,
+		params.ks,
+		params.shardVars,
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session))
+}
+
+func (rtr *Router) paramsUnsharded(vcursor *requestContext, plan *planbuilder.Plan) (*scatterParams, error) {
 	ks, allShards, err := getKeyspaceShards(vcursor.ctx, rtr.serv, rtr.cell, plan.Table.Keyspace.Name, vcursor.query.TabletType)
 	if err != nil {
 		return nil, err
@@ -79,218 +747,1164 @@ func (rtr *Router) execUnsharded(vcursor *requestContext, plan *planbuilder.Plan
 	if len(allShards) != 1 {
 		return nil, fmt.Errorf("unsharded keyspace %s has multiple shards: %+v", ks, allShards)
 	}
-	shards := []string{allShards[0].ShardName()}
-	return rtr.scatterConn.Execute(
+	sql := vcursor.query.Sql
+	if plan.Aliased {
+		sql = plan.Rewritten
+	}
+	return newScatterParams(sql, ks, vcursor.query.BindVariables, []string{allShards[0].ShardName()}), nil
+}
+
+func (rtr *Router) execSelectEqual(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	params, err := rtr.paramsSelectEqual(vcursor, plan)
+	if err != nil {
+		return nil, err
+	}
+	stc, err := rtr.scatterConnFor(vcursor, params)
+	if err != nil {
+		return nil, err
+	}
+	return stc.ExecuteMulti(
 		vcursor.ctx,
-		vcursor.query.Sql,
-		vcursor.query.BindVariables,
-		ks,
-		shards,
+		params.query,
+		params.ks,
+		params.shardVars,
 		vcursor.query.TabletType,
 		NewSafeSession(vcursor.query.Session))
 }
 
-func (rtr *Router) execSelectEqual(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+func (rtr *Router) paramsSelectEqual(vcursor *requestContext, plan *planbuilder.Plan) (*scatterParams, error) {
 	keys, err := rtr.resolveKeys([]interface{}{plan.Values}, vcursor.query.BindVariables)
 	if err != nil {
 		return nil, err
 	}
+	if keys[0] == nil {
+		// A NULL value can't match any row.
+		return newScatterParams(plan.Rewritten, plan.Table.Keyspace.Name, vcursor.query.BindVariables, nil), nil
+	}
 	ks, routing, err := rtr.resolveShards(vcursor, keys, plan)
-	return rtr.scatterConn.Execute(
-		vcursor.ctx,
-		plan.Rewritten,
-		vcursor.query.BindVariables,
-		ks,
-		routing.Shards(),
-		vcursor.query.TabletType,
-		NewSafeSession(vcursor.query.Session))
+	if err != nil {
+		return nil, err
+	}
+	params := newScatterParams(plan.Rewritten, ks, vcursor.query.BindVariables, routing.Shards())
+	if affine, ok := plan.ColVindex.Vindex.(planbuilder.CellAffine); ok {
+		if params.cell, err = affine.Cell(vcursor, keys[0]); err != nil {
+			return nil, err
+		}
+	}
+	return params, nil
 }
 
 func (rtr *Router) execSelectIN(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
-	keys, err := rtr.resolveKeys(plan.Values.([]interface{}), vcursor.query.BindVariables)
+	params, err := rtr.paramsSelectIN(vcursor, plan)
+	if err != nil {
+		return nil, err
+	}
+	return rtr.execScatterSelect(vcursor, params)
+}
+
+func (rtr *Router) paramsSelectIN(vcursor *requestContext, plan *planbuilder.Plan) (*scatterParams, error) {
+	keys, err := rtr.resolveList(plan.Values, vcursor.query.BindVariables)
 	if err != nil {
 		return nil, err
 	}
 	ks, routing, err := rtr.resolveShards(vcursor, keys, plan)
-	shardVars := make(map[string]map[string]interface{})
-	for shard, vals := range routing {
-		bv := make(map[string]interface{}, len(vcursor.query.BindVariables)+1)
-		for k, v := range vcursor.query.BindVariables {
-			bv[k] = v
+	if err != nil {
+		return nil, err
+	}
+	return &scatterParams{
+		query:     plan.Rewritten,
+		ks:        ks,
+		shardVars: routing.ShardVars(vcursor.query.BindVariables),
+	}, nil
+}
+
+func (rtr *Router) execSelectKeyrange(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	params, err := rtr.paramsSelectKeyrange(vcursor, plan)
+	if err != nil {
+		return nil, err
+	}
+	return rtr.execScatterSelect(vcursor, params)
+}
+
+// paramsSelectKeyrange sends the query to all the shards
+// that overlap with the keyrange.
+func (rtr *Router) paramsSelectKeyrange(vcursor *requestContext, plan *planbuilder.Plan) (*scatterParams, error) {
+	keys, err := rtr.resolveKeys(plan.Values.([]interface{}), vcursor.query.BindVariables)
+	if err != nil {
+		return nil, err
+	}
+	kr, err := getKeyRange(keys)
+	if err != nil {
+		return nil, err
+	}
+	vcursor.recordKeyRange(kr)
+	ks, shards, err := mapKeyRangesToShards(vcursor.ctx, rtr.serv, rtr.cell, plan.Table.Keyspace.Name, vcursor.query.TabletType, []key.KeyRange{kr})
+	if err != nil {
+		return nil, err
+	}
+	return newScatterParams(plan.Rewritten, ks, vcursor.query.BindVariables, shards), nil
+}
+
+func getKeyRange(keys []interface{}) (key.KeyRange, error) {
+	var ksids []key.KeyspaceId
+	for _, k := range keys {
+		switch k := k.(type) {
+		case string:
+			ksids = append(ksids, key.KeyspaceId(k))
+		default:
+			return key.KeyRange{}, fmt.Errorf("expecting strings for keyrange: %+v", keys)
 		}
-		bv[planbuilder.ListVarName] = vals
-		shardVars[shard] = bv
 	}
-	return rtr.scatterConn.ExecuteMulti(
-		vcursor.ctx,
-		plan.Rewritten,
-		ks,
-		shardVars,
-		vcursor.query.TabletType,
+	return key.KeyRange{
+		Start: ksids[0],
+		End:   ksids[1],
+	}, nil
+}
+
+func (rtr *Router) execSelectRange(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	params, err := rtr.paramsSelectRange(vcursor, plan)
+	if err != nil {
+		return nil, err
+	}
+	return rtr.execScatterSelect(vcursor, params)
+}
+
+// paramsSelectRange sends the query to all the shards that
+// overlap with the keyrange of the range of vindex values.
+func (rtr *Router) paramsSelectRange(vcursor *requestContext, plan *planbuilder.Plan) (*scatterParams, error) {
+	keys, err := rtr.resolveKeys(plan.Values.([]interface{}), vcursor.query.BindVariables)
+	if err != nil {
+		return nil, err
+	}
+	kr, err := plan.ColVindex.Vindex.(planbuilder.Ranged).MapRange(vcursor, keys[0], keys[1])
+	if err != nil {
+		return nil, err
+	}
+	vcursor.recordKeyRange(kr)
+	if kr.End != "" && kr.Start >= kr.End {
+		// The range is empty.
+		return newScatterParams(plan.Rewritten, plan.Table.Keyspace.Name, vcursor.query.BindVariables, nil), nil
+	}
+	ks, shards, err := mapKeyRangesToShards(vcursor.ctx, rtr.serv, rtr.cell, plan.Table.Keyspace.Name, vcursor.query.TabletType, []key.KeyRange{kr})
+	if err != nil {
+		return nil, err
+	}
+	return newScatterParams(plan.Rewritten, ks, vcursor.query.BindVariables, shards), nil
+}
+
+func (rtr *Router) execSelectScatter(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	params, err := rtr.paramsSelectScatter(vcursor, plan)
+	if err != nil {
+		return nil, err
+	}
+	return rtr.execScatterSelect(vcursor, params)
+}
+
+func (rtr *Router) paramsSelectScatter(vcursor *requestContext, plan *planbuilder.Plan) (*scatterParams, error) {
+	ks, allShards, err := getKeyspaceShards(vcursor.ctx, rtr.serv, rtr.cell, plan.Table.Keyspace.Name, vcursor.query.TabletType)
+	if err != nil {
+		return nil, err
+	}
+	var shards []string
+	for _, shard := range allShards {
+		shards = append(shards, shard.ShardName())
+	}
+	return newScatterParams(plan.Rewritten, ks, vcursor.query.BindVariables, shards), nil
+}
+
+// execMerge executes a plan whose rows have to be
+// post-processed by vtgate.
+func (rtr *Router) execMerge(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	if plan.Aggregates != nil {
+		result, err := rtr.execPlan(vcursor, plan)
+		if err != nil {
+			return nil, err
+		}
+		return aggregateResult(result, plan.Aggregates)
+	}
+	params, err := rtr.paramsMerge(vcursor, plan)
+	if err != nil {
+		return nil, err
+	}
+	stc, err := rtr.scatterConnFor(vcursor, params)
+	if err != nil {
+		return nil, err
+	}
+	var results []*mproto.QueryResult
+	if vcursor.shardErrors == nil {
+		results, err = stc.ExecuteMultiPerShard(
+			vcursor.ctx,
+			params.query,
+			params.ks,
+			params.shardVars,
+			vcursor.query.TabletType,
+			NewSafeSession(vcursor.query.Session))
+	} else {
+		var shardErrors []error
+		results, shardErrors, err = stc.ExecuteMultiPerShardPartial(
+			vcursor.ctx,
+			params.query,
+			params.ks,
+			params.shardVars,
+			vcursor.query.TabletType,
+			NewSafeSession(vcursor.query.Session))
+		*vcursor.shardErrors = append(*vcursor.shardErrors, shardErrors...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return mergeSort(results, plan.OrderBy, plan.Limit, rtr.newDistinctFilter(plan))
+}
+
+// streamMerge is the streaming version of execMerge. It can't be
+// used for aggregates.
+func (rtr *Router) streamMerge(vcursor *requestContext, plan *planbuilder.Plan, sendReply func(*mproto.QueryResult) error) error {
+	params, err := rtr.paramsMerge(vcursor, plan)
+	if err != nil {
+		return err
+	}
+	stc, err := rtr.scatterConnFor(vcursor, params)
+	if err != nil {
+		return err
+	}
+	return stc.StreamExecuteMultiPerShard(
+		vcursor.ctx,
+		params.query,
+		params.ks,
+		params.shardVars,
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		func(streams []<-chan *mproto.QueryResult) error {
+			return streamMergeSort(streams, plan.OrderBy, plan.Limit, rtr.newDistinctFilter(plan), sendReply)
+		})
+}
+
+func (rtr *Router) paramsMerge(vcursor *requestContext, plan *planbuilder.Plan) (*scatterParams, error) {
+	switch plan.ID {
+	case planbuilder.SelectEqual:
+		return rtr.paramsSelectEqual(vcursor, plan)
+	case planbuilder.SelectIN:
+		return rtr.paramsSelectIN(vcursor, plan)
+	case planbuilder.SelectKeyrange:
+		return rtr.paramsSelectKeyrange(vcursor, plan)
+	case planbuilder.SelectRange:
+		return rtr.paramsSelectRange(vcursor, plan)
+	case planbuilder.SelectScatter:
+		return rtr.paramsSelectScatter(vcursor, plan)
+	}
+	return nil, fmt.Errorf("plan %v cannot be merged", plan.ID)
+}
+
+// newDistinctFilter returns the filter for removing the
+// duplicate rows of plan, if any.
+func (rtr *Router) newDistinctFilter(plan *planbuilder.Plan) *distinctFilter {
+	if !plan.Distinct {
+		return nil
+	}
+	return newDistinctFilter(rtr.maxDistinctBytes)
+}
+
+// execSelectJoin performs a nested-loop join. The left query is
+// executed first. For every row it returns, the right query is
+// executed with the join vars set from that row. The output row
+// is assembled from both rows as specified by plan.Cols.
+// If the left side returns no rows, the right query is not executed
+// and the result will not contain any fields.
+func (rtr *Router) execSelectJoin(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	lresult, err := rtr.execSubplan(vcursor, plan.Left, vcursor.query.BindVariables)
+	if err != nil {
+		return nil, err
+	}
+	result := &mproto.QueryResult{}
+	for _, lrow := range lresult.Rows {
+		bv := make(map[string]interface{}, len(vcursor.query.BindVariables)+len(plan.JoinVars))
+		for k, v := range vcursor.query.BindVariables {
+			bv[k] = v
+		}
+		for k, col := range plan.JoinVars {
+			bv[k], err = mproto.Convert(lresult.Fields[col].Type, lrow[col])
+			if err != nil {
+				return nil, err
+			}
+		}
+		rresult, err := rtr.execSubplan(vcursor, plan.Right, bv)
+		if err != nil {
+			return nil, err
+		}
+		if result.Fields == nil {
+			result.Fields = joinFields(lresult.Fields, rresult.Fields, plan.Cols)
+		}
+		for _, rrow := range rresult.Rows {
+			result.Rows = append(result.Rows, joinRow(lrow, rrow, plan.Cols))
+		}
+	}
+	result.RowsAffected = uint64(len(result.Rows))
+	return result, nil
+}
+
+// execSubplan executes a plan that was built as part of
+// a bigger plan, like one side of a join.
+func (rtr *Router) execSubplan(vcursor *requestContext, plan *planbuilder.Plan, bindVars map[string]interface{}) (*mproto.QueryResult, error) {
+	query := &proto.Query{
+		Sql:           plan.Original,
+		BindVariables: bindVars,
+		TabletType:    vcursor.query.TabletType,
+		Session:       vcursor.query.Session,
+	}
+	subcursor := newRequestContext(vcursor.ctx, query, rtr)
+	subcursor.shardErrors = vcursor.shardErrors
+	if err := rtr.execSubqueries(subcursor, plan); err != nil {
+		return nil, err
+	}
+	if plan.NeedsMerge() {
+		return rtr.execMerge(subcursor, plan)
+	}
+	return rtr.execPlan(subcursor, plan)
+}
+
+// execSubqueries executes the uncorrelated subqueries of plan.
+// Their results are added to the bind vars of vcursor.
+func (rtr *Router) execSubqueries(vcursor *requestContext, plan *planbuilder.Plan) error {
+	if plan.Subqueries == nil {
+		return nil
+	}
+	// The bind vars may be shared with the caller. So, we
+	// work on a copy.
+	bv := make(map[string]interface{}, len(vcursor.query.BindVariables)+len(plan.Subqueries))
+	for k, v := range vcursor.query.BindVariables {
+		bv[k] = v
+	}
+	for _, sq := range plan.Subqueries {
+		result, err := rtr.execSubplan(vcursor, sq.Plan, vcursor.query.BindVariables)
+		if err != nil {
+			return err
+		}
+		var values []interface{}
+		for _, row := range result.Rows {
+			val, err := mproto.Convert(result.Fields[0].Type, row[0])
+			if err != nil {
+				return err
+			}
+			values = append(values, val)
+		}
+		if sq.IsList {
+			bv[sq.VarName] = values
+			continue
+		}
+		switch len(values) {
+		case 0:
+			bv[sq.VarName] = nil
+		case 1:
+			bv[sq.VarName] = values[0]
+		default:
+			return fmt.Errorf("subquery %q returned more than one row", sq.Plan.Original)
+		}
+	}
+	vcursor.query.BindVariables = bv
+	return nil
+}
+
+// execSemiJoin executes the outer query of a correlated exists,
+// and then the subquery for every row it returns. The row is
+// kept if the subquery returns a row, or if it doesn't for an
+// anti-join. The trailing columns that supplied the join vars
+// are removed from the result.
+func (rtr *Router) execSemiJoin(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	lresult, err := rtr.execSubplan(vcursor, plan.Left, vcursor.query.BindVariables)
+	if err != nil {
+		return nil, err
+	}
+	result := &mproto.QueryResult{}
+	if len(lresult.Fields) < len(plan.JoinVars) {
+		return result, nil
+	}
+	base := len(lresult.Fields) - len(plan.JoinVars)
+	result.Fields = lresult.Fields[:base]
+	for _, lrow := range lresult.Rows {
+		bv := make(map[string]interface{}, len(vcursor.query.BindVariables)+len(plan.JoinVars))
+		for k, v := range vcursor.query.BindVariables {
+			bv[k] = v
+		}
+		for k, col := range plan.JoinVars {
+			bv[k], err = mproto.Convert(lresult.Fields[base+col].Type, lrow[base+col])
+			if err != nil {
+				return nil, err
+			}
+		}
+		rresult, err := rtr.execSubplan(vcursor, plan.Right, bv)
+		if err != nil {
+			return nil, err
+		}
+		if (len(rresult.Rows) != 0) == (plan.ID == planbuilder.SelectSemiJoin) {
+			result.Rows = append(result.Rows, lrow[:base])
+		}
+	}
+	result.RowsAffected = uint64(len(result.Rows))
+	return result, nil
+}
+
+func joinFields(lfields, rfields []mproto.Field, cols []int) []mproto.Field {
+	fields := make([]mproto.Field, len(cols))
+	for i, col := range cols {
+		if col < 0 {
+			fields[i] = lfields[-col-1]
+		} else {
+			fields[i] = rfields[col-1]
+		}
+	}
+	return fields
+}
+
+func joinRow(lrow, rrow []sqltypes.Value, cols []int) []sqltypes.Value {
+	row := make([]sqltypes.Value, len(cols))
+	for i, col := range cols {
+		if col < 0 {
+			row[i] = lrow[-col-1]
+		} else {
+			row[i] = rrow[col-1]
+		}
+	}
+	return row
+}
+
+func (rtr *Router) execSelectUnion(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	lresult, err := rtr.execSubplan(vcursor, plan.Left, vcursor.query.BindVariables)
+	if err != nil {
+		return nil, err
+	}
+	rresult, err := rtr.execSubplan(vcursor, plan.Right, vcursor.query.BindVariables)
+	if err != nil {
+		return nil, err
+	}
+	if lresult.Fields != nil && rresult.Fields != nil && len(lresult.Fields) != len(rresult.Fields) {
+		return nil, fmt.Errorf("union: column count mismatch: %d vs %d", len(lresult.Fields), len(rresult.Fields))
+	}
+	result := &mproto.QueryResult{Fields: lresult.Fields}
+	if result.Fields == nil {
+		result.Fields = rresult.Fields
+	}
+	if plan.ID == planbuilder.SelectUnionAll {
+		result.Rows = make([][]sqltypes.Value, 0, len(lresult.Rows)+len(rresult.Rows))
+		result.Rows = append(result.Rows, lresult.Rows...)
+		result.Rows = append(result.Rows, rresult.Rows...)
+	} else {
+		distinct := newDistinctFilter(rtr.maxDistinctBytes)
+		for _, rows := range [][][]sqltypes.Value{lresult.Rows, rresult.Rows} {
+			for _, row := range rows {
+				isNew, err := distinct.isNew(row)
+				if err != nil {
+					return nil, err
+				}
+				if isNew {
+					result.Rows = append(result.Rows, row)
+				}
+			}
+		}
+	}
+	result.RowsAffected = uint64(len(result.Rows))
+	return result, nil
+}
+
+func (rtr *Router) execUpdateEqual(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	if plan.Subquery != "" && needsTransaction(vcursor, plan) {
+		return rtr.execInTransaction(vcursor, func() (*mproto.QueryResult, error) {
+			return rtr.execUpdateEqual(vcursor, plan)
+		})
+	}
+	keys, err := rtr.resolveKeys([]interface{}{plan.Values}, vcursor.query.BindVariables)
+	if err != nil {
+		return nil, err
+	}
+	ks, shard, ksid, err := rtr.resolveSingleShard(vcursor, keys[0], plan)
+	if err != nil {
+		return nil, err
+	}
+	if ksid == key.MinKey {
+		return &mproto.QueryResult{}, nil
+	}
+	var deleted, created []vindexEntries
+	if plan.Subquery != "" {
+		deleted, created, err = rtr.updateVindexEntries(vcursor, plan, ks, shard, ksid)
+		if err != nil {
+			return nil, rtr.revertUpdated(vcursor, deleted, created, ksid, err)
+		}
+	}
+	vcursor.query.BindVariables[ksidName] = string(ksid)
+	rewritten := routeDML(plan.Rewritten, vcursor.query.BindVariables, ksid)
+	result, err := rtr.execShardDML(vcursor, rewritten, ks, shard)
+	if err != nil {
+		return nil, rtr.revertUpdated(vcursor, deleted, created, ksid, err)
+	}
+	return result, nil
+}
+
+// execUpdateMigrate executes an update that changes the primary
+// vindex column of a row, which moves the row to another shard.
+// The row is deleted, and inserted again with the new values. The
+// delete and the insert go through the router, which maintains the
+// owned vindex entries. They're executed in the transaction of the
+// session, or in a transaction of their own.
+func (rtr *Router) execUpdateMigrate(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	if vcursor.query.Session == nil || !vcursor.query.Session.InTransaction {
+		return rtr.execInTransaction(vcursor, func() (*mproto.QueryResult, error) {
+			return rtr.execUpdateMigrate(vcursor, plan)
+		})
+	}
+	assignments := make(map[string]interface{}, len(plan.Assignments))
+	for col, val := range plan.Assignments {
+		keys, err := rtr.resolveKeys([]interface{}{val}, vcursor.query.BindVariables)
+		if err != nil {
+			return nil, err
+		}
+		assignments[col] = keys[0]
+	}
+	result, err := vcursor.executeQuery(&tproto.BoundQuery{
+		Sql:           plan.Subquery,
+		BindVariables: vcursor.query.BindVariables,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Rows) == 0 {
+		return &mproto.QueryResult{}, nil
+	}
+	_, err = vcursor.executeQuery(&tproto.BoundQuery{
+		Sql:           plan.Rewritten,
+		BindVariables: vcursor.query.BindVariables,
+	})
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]string, len(result.Fields))
+	for i, field := range result.Fields {
+		cols[i] = field.Name
+	}
+	insert := fmt.Sprintf("insert into %s(%s) values(:%s)", plan.Table.Name, strings.Join(cols, ", "), strings.Join(cols, ", :"))
+	for _, row := range result.Rows {
+		bv := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			if val, ok := assignments[col]; ok {
+				bv[col] = val
+				continue
+			}
+			if bv[col], err = mproto.Convert(result.Fields[i].Type, row[i]); err != nil {
+				return nil, err
+			}
+		}
+		_, err = vcursor.executeQuery(&tproto.BoundQuery{
+			Sql:           insert,
+			BindVariables: bv,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &mproto.QueryResult{RowsAffected: uint64(len(result.Rows))}, nil
+}
+
+func (rtr *Router) execDeleteEqual(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	if needsTransaction(vcursor, plan) {
+		return rtr.execInTransaction(vcursor, func() (*mproto.QueryResult, error) {
+			return rtr.execDeleteEqual(vcursor, plan)
+		})
+	}
+	keys, err := rtr.resolveKeys([]interface{}{plan.Values}, vcursor.query.BindVariables)
+	if err != nil {
+		return nil, err
+	}
+	ks, shard, ksid, err := rtr.resolveSingleShard(vcursor, keys[0], plan)
+	if err != nil {
+		return nil, err
+	}
+	if ksid == key.MinKey {
+		return &mproto.QueryResult{}, nil
+	}
+	var deleted []vindexEntries
+	if plan.Subquery != "" {
+		deleted, err = rtr.deleteVindexEntries(vcursor, plan, vcursor.query.BindVariables, ks, shard, ksid)
+		if err != nil {
+			return nil, rtr.revertDeleted(vcursor, deleted, ksid, err)
+		}
+	}
+	vcursor.query.BindVariables[ksidName] = string(ksid)
+	rewritten := routeDML(plan.Rewritten, vcursor.query.BindVariables, ksid)
+	result, err := rtr.execShardDML(vcursor, rewritten, ks, shard)
+	if err != nil {
+		return nil, rtr.revertDeleted(vcursor, deleted, ksid, err)
+	}
+	return result, nil
+}
+
+// execDMLIn executes an UpdateIn or DeleteIn. The values of the
+// IN clause are grouped by shard, and each shard receives the
+// statement with only its own values. The shards are updated in
+// the transaction of the session, or in a transaction of their own.
+// The owned vindex entries of the deleted rows are deleted one
+// value at a time, because each value has its own keyspace id.
+func (rtr *Router) execDMLIn(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	if vcursor.query.Session == nil || !vcursor.query.Session.InTransaction {
+		return rtr.execInTransaction(vcursor, func() (*mproto.QueryResult, error) {
+			return rtr.execDMLIn(vcursor, plan)
+		})
+	}
+	keys, err := rtr.resolveList(plan.Values, vcursor.query.BindVariables)
+	if err != nil {
+		return nil, err
+	}
+	var ks string
+	routing := make(routingMap)
+	for _, k := range keys {
+		newKeyspace, shard, ksid, err := rtr.resolveSingleShard(vcursor, k, plan)
+		if err != nil {
+			return nil, err
+		}
+		if ksid == key.MinKey {
+			continue
+		}
+		ks = newKeyspace
+		if plan.Subquery != "" {
+			bv := make(map[string]interface{}, len(vcursor.query.BindVariables)+1)
+			for name, val := range vcursor.query.BindVariables {
+				bv[name] = val
+			}
+			bv[planbuilder.ListVarName] = []interface{}{k}
+			deleted, err := rtr.deleteVindexEntries(vcursor, plan, bv, ks, shard, ksid)
+			if err != nil {
+				return nil, rtr.revertDeleted(vcursor, deleted, ksid, err)
+			}
+		}
+		routing.Add(shard, k)
+	}
+	if len(routing) == 0 {
+		return &mproto.QueryResult{}, nil
+	}
+	return rtr.scatterConn.ExecuteMulti(
+		vcursor.ctx,
+		plan.Rewritten,
+		ks,
+		routing.ShardVars(vcursor.query.BindVariables),
+		vcursor.query.TabletType,
 		NewSafeSession(vcursor.query.Session))
 }
 
-func (rtr *Router) execSelectKeyrange(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
-	keys, err := rtr.resolveKeys(plan.Values.([]interface{}), vcursor.query.BindVariables)
-	if err != nil {
-		return nil, err
-	}
-	kr, err := getKeyRange(keys)
+// execDMLScatter sends an update or delete to all shards. It's only
+// allowed if the session has opted in. If the session is not in a
+// transaction, the statement is executed in its own transaction, so
+// that it's applied to all shards or none. The transaction is rolled
+// back if too many rows are affected.
+func (rtr *Router) execDMLScatter(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	session := vcursor.query.Session
+	if session == nil || !session.AllowScatterDML {
+		return nil, fmt.Errorf("query %q needs to be sent to all shards, which is not allowed for this session", vcursor.query.Sql)
+	}
+	ks, allShards, err := getKeyspaceShards(vcursor.ctx, rtr.serv, rtr.cell, plan.Table.Keyspace.Name, vcursor.query.TabletType)
+	if err != nil {
+		return nil, err
+	}
+	var shards []string
+	for _, shard := range allShards {
+		shards = append(shards, shard.ShardName())
+	}
+	autocommit := !session.InTransaction
+	if autocommit {
+		session.InTransaction = true
+	}
+	safeSession := NewSafeSession(session)
+	result, err := rtr.scatterConn.Execute(
+		vcursor.ctx,
+		plan.Rewritten,
+		vcursor.query.BindVariables,
+		ks,
+		shards,
+		vcursor.query.TabletType,
+		safeSession)
+	if err == nil && rtr.maxScatterDMLRows != 0 && result.RowsAffected > rtr.maxScatterDMLRows {
+		err = fmt.Errorf("query %q affected %d rows, which exceeds the limit of %d: transaction rolled back", vcursor.query.Sql, result.RowsAffected, rtr.maxScatterDMLRows)
+	}
+	if err != nil {
+		rtr.scatterConn.Rollback(vcursor.ctx, safeSession)
+		return nil, err
+	}
+	if autocommit {
+		if err := rtr.scatterConn.Commit(vcursor.ctx, safeSession); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (rtr *Router) execInsertSharded(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	if needsTransaction(vcursor, plan) {
+		return rtr.execInTransaction(vcursor, func() (*mproto.QueryResult, error) {
+			return rtr.execInsertSharded(vcursor, plan)
+		})
+	}
+	ksid, generated, created, err := rtr.handleVindexes(vcursor, plan, vcursor.query.BindVariables)
+	if err != nil {
+		return nil, err
+	}
+	ks, shard, err := rtr.getRouting(vcursor.ctx, plan.Table.Keyspace.Name, vcursor.query.TabletType, ksid)
+	if err != nil {
+		return nil, rtr.revertCreated(vcursor, created, ksid, err)
+	}
+	vcursor.query.BindVariables[ksidName] = string(ksid)
+	rewritten := routeDML(plan.Rewritten, vcursor.query.BindVariables, ksid)
+	result, err := rtr.execShardDML(vcursor, rewritten, ks, shard)
+	if err != nil {
+		return nil, rtr.revertCreated(vcursor, created, ksid, err)
+	}
+	if generated != 0 {
+		if result.InsertId != 0 {
+			return nil, fmt.Errorf("vindex and db generated a value each for insert")
+		}
+		result.InsertId = uint64(generated)
+	}
+	if vcursor.query.Session != nil && vcursor.query.Session.ReturnInsertValues {
+		fields, row, err := insertValues(plan, vcursor.query.BindVariables, ksid)
+		if err != nil {
+			return nil, err
+		}
+		result.Fields = fields
+		result.Rows = [][]sqltypes.Value{row}
+	}
+	return result, nil
+}
+
+// insertValues returns the row that the ReturnInsertValues option
+// adds to the result of a sharded insert: the values of the vindex
+// columns, including the generated ones, followed by the keyspace id.
+func insertValues(plan *planbuilder.Plan, bv map[string]interface{}, ksid key.KeyspaceId) (fields []mproto.Field, row []sqltypes.Value, err error) {
+	fields = make([]mproto.Field, 0, len(plan.Table.ColVindexes)+1)
+	row = make([]sqltypes.Value, 0, len(plan.Table.ColVindexes)+1)
+	for _, colVindex := range plan.Table.ColVindexes {
+		val, err := sqltypes.BuildValue(bv["_"+colVindex.Col])
+		if err != nil {
+			return nil, nil, err
+		}
+		field := mproto.Field{Name: colVindex.Col, Type: mproto.VT_VAR_STRING}
+		switch {
+		case val.IsNull():
+			field.Type = mproto.VT_NULL
+		case val.IsNumeric():
+			field.Type = mproto.VT_LONGLONG
+		case val.IsFractional():
+			field.Type = mproto.VT_DOUBLE
+		}
+		fields = append(fields, field)
+		row = append(row, val)
+	}
+	fields = append(fields, mproto.Field{Name: ksidName, Type: mproto.VT_VAR_STRING})
+	row = append(row, sqltypes.MakeString([]byte(ksid)))
+	return fields, row, nil
+}
+
+// handleVindexes maps the row inserted by an InsertSharded plan
+// to its keyspace id, and creates the entries of its owned vindexes.
+// The value of the auto-increment column is generated first, since
+// it can also be the value of a vindex.
+// The vindex values are read from bv, and their final values are
+// stored back into bv. The created entries are returned, so they
+// can be reverted if the insert fails.
+func (rtr *Router) handleVindexes(vcursor *requestContext, plan *planbuilder.Plan, bv map[string]interface{}) (ksid key.KeyspaceId, generated int64, created []vindexEntries, err error) {
+	if plan.Generate != nil {
+		generated, err = rtr.handleGenerate(vcursor, plan.Generate, bv)
+		if err != nil {
+			return "", 0, nil, err
+		}
+	}
+	keys, err := rtr.resolveKeys(plan.Values.([]interface{}), bv)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	ksid, primarygen, err := rtr.handlePrimary(vcursor, keys[0], plan.Table.ColVindexes[0], bv)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if primarygen != 0 {
+		if generated != 0 {
+			return "", 0, nil, fmt.Errorf("insert generated more than one value")
+		}
+		generated = primarygen
+	}
+	for i := 1; i < len(keys); i++ {
+		colVindex := plan.Table.ColVindexes[i]
+		newgen, err := rtr.handleNonPrimary(vcursor, keys[i], colVindex, bv, ksid)
+		if err != nil {
+			return "", 0, nil, rtr.revertCreated(vcursor, created, ksid, err)
+		}
+		if colVindex.Owned {
+			created = append(created, vindexEntries{
+				colVindex: colVindex,
+				ids:       []interface{}{bv["_"+colVindex.Col]},
+			})
+		}
+		if newgen != 0 {
+			if generated != 0 {
+				return "", 0, nil, rtr.revertCreated(vcursor, created, ksid, fmt.Errorf("insert generated more than one value"))
+			}
+			generated = newgen
+		}
+	}
+	return ksid, generated, created, nil
+}
+
+// execInsertSelect executes the select of an InsertSelect through
+// the normal routing path, and inserts the resulting rows in
+// batches of insert_select_batch_size.
+func (rtr *Router) execInsertSelect(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	if needsTransaction(vcursor, plan.Right) {
+		return rtr.execInTransaction(vcursor, func() (*mproto.QueryResult, error) {
+			return rtr.execInsertSelect(vcursor, plan)
+		})
+	}
+	selected, err := vcursor.executeQuery(&tproto.BoundQuery{
+		Sql:           plan.Subquery,
+		BindVariables: vcursor.query.BindVariables,
+	})
+	if err != nil {
+		return nil, err
+	}
+	rows := make([][]interface{}, len(selected.Rows))
+	for i, row := range selected.Rows {
+		if len(row) != len(selected.Fields) {
+			return nil, fmt.Errorf("row has %d values, want %d", len(row), len(selected.Fields))
+		}
+		rows[i] = make([]interface{}, len(row))
+		for j, field := range selected.Fields {
+			if rows[i][j], err = mproto.Convert(field.Type, row[j]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rtr.insertRows(vcursor, plan.Right, rows, *insertSelectBatchSize)
+}
+
+// insertRows inserts rows with a single row insert plan built by
+// the planbuilder for an InsertSelect or a bulk insert. The value
+// of the i-th column of a row is supplied as the bind var _i. The
+// rows are inserted in batches of batchSize.
+func (rtr *Router) insertRows(vcursor *requestContext, plan *planbuilder.Plan, rows [][]interface{}, batchSize int) (*mproto.QueryResult, error) {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	result := &mproto.QueryResult{}
+	for start := 0; start < len(rows); start += batchSize {
+		end := start + batchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		inserted, err := rtr.insertBatch(vcursor, plan, rows[start:end])
+		if err != nil {
+			return nil, err
+		}
+		result.RowsAffected += inserted.RowsAffected
+		if result.InsertId == 0 {
+			result.InsertId = inserted.InsertId
+		}
+		if inserted.Fields != nil {
+			result.Fields = inserted.Fields
+		}
+		result.Rows = append(result.Rows, inserted.Rows...)
+	}
+	return result, nil
+}
+
+// insertBatch inserts a batch of rows for insertRows. The rows are
+// grouped by shard, and each shard receives its inserts in a single
+// ExecuteBatch. Every row of a sharded table is a separate query,
+// which carries the keyspace id of the row in its comment.
+func (rtr *Router) insertBatch(vcursor *requestContext, plan *planbuilder.Plan, rows [][]interface{}) (*mproto.QueryResult, error) {
+	if plan.ID == planbuilder.InsertUnsharded {
+		return rtr.insertBatchUnsharded(vcursor, plan, rows)
+	}
+	type shardBatch struct {
+		keyspace string
+		shard    string
+		queries  []tproto.BoundQuery
+		ksids    []key.KeyspaceId
+		created  [][]vindexEntries
+	}
+	var batches []*shardBatch
+	byShard := make(map[string]*shardBatch)
+	result := &mproto.QueryResult{}
+	for _, row := range rows {
+		bv := make(map[string]interface{}, len(row)+len(plan.Table.ColVindexes)+1)
+		for i, val := range row {
+			bv[fmt.Sprintf("_%d", i)] = val
+		}
+		ksid, generated, created, err := rtr.handleVindexes(vcursor, plan, bv)
+		if err != nil {
+			return nil, err
+		}
+		if generated != 0 && result.InsertId == 0 {
+			result.InsertId = uint64(generated)
+		}
+		ks, shard, err := rtr.getRouting(vcursor.ctx, plan.Table.Keyspace.Name, vcursor.query.TabletType, ksid)
+		if err != nil {
+			return nil, rtr.revertCreated(vcursor, created, ksid, err)
+		}
+		bv[ksidName] = string(ksid)
+		if vcursor.query.Session != nil && vcursor.query.Session.ReturnInsertValues {
+			valueFields, valueRow, err := insertValues(plan, bv, ksid)
+			if err != nil {
+				return nil, rtr.revertCreated(vcursor, created, ksid, err)
+			}
+			result.Fields = valueFields
+			result.Rows = append(result.Rows, valueRow)
+		}
+		batch := byShard[shard]
+		if batch == nil {
+			batch = &shardBatch{keyspace: ks, shard: shard}
+			byShard[shard] = batch
+			batches = append(batches, batch)
+		}
+		batch.queries = append(batch.queries, tproto.BoundQuery{
+			Sql:           routeDML(plan.Rewritten, bv, ksid),
+			BindVariables: bv,
+		})
+		batch.ksids = append(batch.ksids, ksid)
+		batch.created = append(batch.created, created)
+	}
+	for _, batch := range batches {
+		qrs, err := rtr.scatterConn.ExecuteBatch(
+			vcursor.ctx,
+			batch.queries,
+			batch.keyspace,
+			[]string{batch.shard},
+			vcursor.query.TabletType,
+			NewSafeSession(vcursor.query.Session))
+		if err != nil {
+			for i, created := range batch.created {
+				err = rtr.revertCreated(vcursor, created, batch.ksids[i], err)
+			}
+			return nil, err
+		}
+		addBatchResults(result, qrs)
+	}
+	return result, nil
+}
+
+// insertBatchUnsharded is insertBatch for an unsharded table.
+func (rtr *Router) insertBatchUnsharded(vcursor *requestContext, plan *planbuilder.Plan, rows [][]interface{}) (*mproto.QueryResult, error) {
+	ks, allShards, err := getKeyspaceShards(vcursor.ctx, rtr.serv, rtr.cell, plan.Table.Keyspace.Name, vcursor.query.TabletType)
 	if err != nil {
 		return nil, err
 	}
-	ks, shards, err := mapExactShards(vcursor.ctx, rtr.serv, rtr.cell, plan.Table.Keyspace.Name, vcursor.query.TabletType, kr)
-	if err != nil {
-		return nil, err
+	if len(allShards) != 1 {
+		return nil, fmt.Errorf("unsharded keyspace %s has multiple shards: %+v", ks, allShards)
 	}
-	if len(shards) != 1 {
-		return nil, fmt.Errorf("keyrange must match exactly one shard: %+v", keys)
+	queries := make([]tproto.BoundQuery, 0, len(rows))
+	for _, row := range rows {
+		bv := make(map[string]interface{}, len(row))
+		for i, val := range row {
+			bv[fmt.Sprintf("_%d", i)] = val
+		}
+		queries = append(queries, tproto.BoundQuery{
+			Sql:           plan.Rewritten,
+			BindVariables: bv,
+		})
 	}
-	return rtr.scatterConn.Execute(
+	qrs, err := rtr.scatterConn.ExecuteBatch(
 		vcursor.ctx,
-		plan.Rewritten,
-		vcursor.query.BindVariables,
+		queries,
 		ks,
-		shards,
+		[]string{allShards[0].ShardName()},
 		vcursor.query.TabletType,
 		NewSafeSession(vcursor.query.Session))
+	if err != nil {
+		return nil, err
+	}
+	result := &mproto.QueryResult{}
+	addBatchResults(result, qrs)
+	return result, nil
 }
 
-func getKeyRange(keys []interface{}) (key.KeyRange, error) {
-	var ksids []key.KeyspaceId
-	for _, k := range keys {
-		switch k := k.(type) {
-		case string:
-			ksids = append(ksids, key.KeyspaceId(k))
-		default:
-			return key.KeyRange{}, fmt.Errorf("expecting strings for keyrange: %+v", keys)
+// addBatchResults adds the rows affected by a batch of
+// inserts to result, along with the first insert id.
+func addBatchResults(result *mproto.QueryResult, qrs *tproto.QueryResultList) {
+	for _, qr := range qrs.List {
+		result.RowsAffected += qr.RowsAffected
+		if result.InsertId == 0 {
+			result.InsertId = qr.InsertId
 		}
 	}
-	return key.KeyRange{
-		Start: ksids[0],
-		End:   ksids[1],
-	}, nil
 }
 
-func (rtr *Router) execSelectScatter(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
-	ks, allShards, err := getKeyspaceShards(vcursor.ctx, rtr.serv, rtr.cell, plan.Table.Keyspace.Name, vcursor.query.TabletType)
-	if err != nil {
-		return nil, err
+// BulkInsert inserts rows into a table without parsing any SQL.
+// The routing of each row is resolved with the table's vindexes,
+// like for an insert statement, and the rows are sent to their
+// shards in batches of bulk_insert_batch_size. The rows are
+// inserted in the transaction of the session, if any.
+func (rtr *Router) BulkInsert(ctx context.Context, req *proto.BulkInsertRequest) (*mproto.QueryResult, error) {
+	schema := rtr.planner.Schema()
+	if schema == nil {
+		return nil, fmt.Errorf("no vtgate schema")
 	}
-	var shards []string
-	for _, shard := range allShards {
-		shards = append(shards, shard.ShardName())
+	plan := planbuilder.BuildBulkInsertPlan(req.Table, req.Columns, schema)
+	if plan.ID == planbuilder.NoPlan {
+		return nil, fmt.Errorf("cannot bulk insert into %s: %s", req.Table, plan.Reason)
 	}
-	return rtr.scatterConn.Execute(
-		vcursor.ctx,
-		plan.Rewritten,
-		vcursor.query.BindVariables,
-		ks,
-		shards,
-		vcursor.query.TabletType,
-		NewSafeSession(vcursor.query.Session))
+	for i, row := range req.Rows {
+		if len(row) != len(req.Columns) {
+			return nil, fmt.Errorf("row %d has %d values, want %d", i, len(row), len(req.Columns))
+		}
+	}
+	ctx, cancel := withQueryTimeout(ctx, req.Session)
+	defer cancel()
+	vcursor := newRequestContext(ctx, &proto.Query{
+		BindVariables: make(map[string]interface{}),
+		TabletType:    req.TabletType,
+		Session:       req.Session,
+	}, rtr)
+	if needsTransaction(vcursor, plan) {
+		return rtr.execInTransaction(vcursor, func() (*mproto.QueryResult, error) {
+			return rtr.insertRows(vcursor, plan, req.Rows, *bulkInsertBatchSize)
+		})
+	}
+	return rtr.insertRows(vcursor, plan, req.Rows, *bulkInsertBatchSize)
 }
 
-func (rtr *Router) execUpdateEqual(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
-	keys, err := rtr.resolveKeys([]interface{}{plan.Values}, vcursor.query.BindVariables)
-	if err != nil {
-		return nil, err
+// ExecuteBatch routes a batch of queries. Each query is planned
+// like for Execute, and the queries are grouped by the shards they
+// target. Every shard receives its queries in a single round trip,
+// in the order of the batch. The result of a query that targets
+// multiple shards combines the results of those shards. Only the
+// queries that can be sent as is to their shards can be batched:
+// the ones that need a merge, a join, or vindex maintenance can't.
+func (rtr *Router) ExecuteBatch(ctx context.Context, queries []tproto.BoundQuery, tabletType topo.TabletType, session *proto.Session) (*tproto.QueryResultList, error) {
+	type shardBatch struct {
+		keyspace string
+		shard    string
+		queries  []tproto.BoundQuery
+		// indexes are the positions of queries in the batch.
+		indexes []int
 	}
-	ks, shard, ksid, err := rtr.resolveSingleShard(vcursor, keys[0], plan)
-	if err != nil {
-		return nil, err
+	ctx, cancel := withQueryTimeout(ctx, session)
+	defer cancel()
+	var batches []*shardBatch
+	byShard := make(map[string]*shardBatch)
+	for i, boundQuery := range queries {
+		query := &proto.Query{
+			Sql:           boundQuery.Sql,
+			BindVariables: boundQuery.BindVariables,
+			TabletType:    tabletType,
+			Session:       session,
+		}
+		if query.BindVariables == nil {
+			query.BindVariables = make(map[string]interface{})
+		}
+		vcursor := newRequestContext(ctx, query, rtr)
+		params, err := rtr.paramsBatch(vcursor)
+		if err != nil {
+			return nil, err
+		}
+		for shard, bv := range params.shardVars {
+			name := params.ks + "/" + shard
+			batch := byShard[name]
+			if batch == nil {
+				batch = &shardBatch{keyspace: params.ks, shard: shard}
+				byShard[name] = batch
+				batches = append(batches, batch)
+			}
+			batch.queries = append(batch.queries, tproto.BoundQuery{
+				Sql:           params.query,
+				BindVariables: bv,
+			})
+			batch.indexes = append(batch.indexes, i)
+		}
 	}
-	if ksid == key.MinKey {
-		return &mproto.QueryResult{}, nil
+
+	qrs := &tproto.QueryResultList{List: make([]mproto.QueryResult, len(queries))}
+	safeSession := NewSafeSession(session)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	allErrors := new(concurrency.AllErrorRecorder)
+	for _, batch := range batches {
+		wg.Add(1)
+		go func(batch *shardBatch) {
+			defer wg.Done()
+			shardqrs, err := rtr.scatterConn.ExecuteBatch(
+				ctx,
+				batch.queries,
+				batch.keyspace,
+				[]string{batch.shard},
+				tabletType,
+				safeSession)
+			if err != nil {
+				allErrors.RecordError(err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for i, index := range batch.indexes {
+				appendResult(&qrs.List[index], &shardqrs.List[i])
+			}
+		}(batch)
 	}
-	vcursor.query.BindVariables[ksidName] = string(ksid)
-	rewritten := plan.Rewritten + fmt.Sprintf(dmlPostfix, ksid)
-	return rtr.scatterConn.Execute(
-		vcursor.ctx,
-		rewritten,
-		vcursor.query.BindVariables,
-		ks,
-		[]string{shard},
-		vcursor.query.TabletType,
-		NewSafeSession(vcursor.query.Session))
+	wg.Wait()
+	if allErrors.HasErrors() {
+		return nil, allErrors.AggrError(rtr.scatterConn.aggregateErrors)
+	}
+	return qrs, nil
 }
 
-func (rtr *Router) execDeleteEqual(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
-	keys, err := rtr.resolveKeys([]interface{}{plan.Values}, vcursor.query.BindVariables)
+// paramsBatch returns the scatterParams of the query
+// of vcursor, for ExecuteBatch.
+func (rtr *Router) paramsBatch(vcursor *requestContext) (*scatterParams, error) {
+	rtr.normalize(vcursor.query)
+	plan, err := planbuilder.ForTenant(rtr.planner.GetPlanIn(vcursor.query.Sql, sessionKeyspace(vcursor.query.Session)), sessionTenant(vcursor.query.Session))
 	if err != nil {
 		return nil, err
 	}
-	ks, shard, ksid, err := rtr.resolveSingleShard(vcursor, keys[0], plan)
-	if err != nil {
+	if plan.Hints != nil && plan.Hints.TabletType != "" && plan.Hints.TabletType != vcursor.query.TabletType {
+		return nil, fmt.Errorf("query %q cannot be used in a batch: TABLET_TYPE hint", vcursor.query.Sql)
+	}
+	if err := rtr.execSubqueries(vcursor, plan); err != nil {
 		return nil, err
 	}
-	if ksid == key.MinKey {
-		return &mproto.QueryResult{}, nil
+	if plan.NeedsMerge() {
+		return nil, fmt.Errorf("query %q cannot be used in a batch", vcursor.query.Sql)
 	}
-	if plan.Subquery != "" {
-		err = rtr.deleteVindexEntries(vcursor, plan, ks, shard, ksid)
-		if err != nil {
-			return nil, err
+	switch plan.ID {
+	case planbuilder.SelectUnsharded, planbuilder.UpdateUnsharded,
+		planbuilder.DeleteUnsharded, planbuilder.InsertUnsharded:
+		return rtr.paramsUnsharded(vcursor, plan)
+	case planbuilder.SelectEqual:
+		return rtr.paramsSelectEqual(vcursor, plan)
+	case planbuilder.SelectIN:
+		return rtr.paramsSelectIN(vcursor, plan)
+	case planbuilder.SelectKeyrange:
+		return rtr.paramsSelectKeyrange(vcursor, plan)
+	case planbuilder.SelectRange:
+		return rtr.paramsSelectRange(vcursor, plan)
+	case planbuilder.SelectScatter:
+		return rtr.paramsSelectScatter(vcursor, plan)
+	case planbuilder.UpdateEqual, planbuilder.DeleteEqual:
+		if plan.Subquery == "" {
+			return rtr.paramsDMLEqual(vcursor, plan)
 		}
 	}
-	vcursor.query.BindVariables[ksidName] = string(ksid)
-	rewritten := plan.Rewritten + fmt.Sprintf(dmlPostfix, ksid)
-	return rtr.scatterConn.Execute(
-		vcursor.ctx,
-		rewritten,
-		vcursor.query.BindVariables,
-		ks,
-		[]string{shard},
-		vcursor.query.TabletType,
-		NewSafeSession(vcursor.query.Session))
+	return nil, fmt.Errorf("query %q cannot be used in a batch", vcursor.query.Sql)
 }
 
-func (rtr *Router) execInsertSharded(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
-	input := plan.Values.([]interface{})
-	keys, err := rtr.resolveKeys(input, vcursor.query.BindVariables)
-	if err != nil {
-		return nil, err
-	}
-	ksid, generated, err := rtr.handlePrimary(vcursor, keys[0], plan.Table.ColVindexes[0], vcursor.query.BindVariables)
+// paramsDMLEqual returns the scatterParams of an UpdateEqual or
+// a DeleteEqual that doesn't change any owned vindex entries.
+// A statement that matches no keyspace id is sent to no shards.
+func (rtr *Router) paramsDMLEqual(vcursor *requestContext, plan *planbuilder.Plan) (*scatterParams, error) {
+	keys, err := rtr.resolveKeys([]interface{}{plan.Values}, vcursor.query.BindVariables)
 	if err != nil {
 		return nil, err
 	}
-	ks, shard, err := rtr.getRouting(vcursor.ctx, plan.Table.Keyspace.Name, vcursor.query.TabletType, ksid)
+	ks, shard, ksid, err := rtr.resolveSingleShard(vcursor, keys[0], plan)
 	if err != nil {
 		return nil, err
 	}
-	for i := 1; i < len(keys); i++ {
-		newgen, err := rtr.handleNonPrimary(vcursor, keys[i], plan.Table.ColVindexes[i], vcursor.query.BindVariables, ksid)
-		if err != nil {
-			return nil, err
-		}
-		if newgen != 0 {
-			if generated != 0 {
-				return nil, fmt.Errorf("insert generated more than one value")
-			}
-			generated = newgen
-		}
+	if ksid == key.MinKey {
+		return newScatterParams(plan.Rewritten, ks, nil, nil), nil
 	}
 	vcursor.query.BindVariables[ksidName] = string(ksid)
-	rewritten := plan.Rewritten + fmt.Sprintf(dmlPostfix, ksid)
-	result, err := rtr.scatterConn.Execute(
-		vcursor.ctx,
-		rewritten,
-		vcursor.query.BindVariables,
-		ks,
-		[]string{shard},
-		vcursor.query.TabletType,
-		NewSafeSession(vcursor.query.Session))
-	if err != nil {
-		return nil, err
-	}
-	if generated != 0 {
-		if result.InsertId != 0 {
-			return nil, fmt.Errorf("vindex and db generated a value each for insert")
-		}
-		result.InsertId = uint64(generated)
-	}
-	return result, nil
+	rewritten := routeDML(plan.Rewritten, vcursor.query.BindVariables, ksid)
+	return newScatterParams(rewritten, ks, vcursor.query.BindVariables, []string{shard}), nil
 }
 
 func (rtr *Router) resolveKeys(vals []interface{}, bindVars map[string]interface{}) (keys []interface{}, err error) {
@@ -312,6 +1926,31 @@ func (rtr *Router) resolveKeys(vals []interface{}, bindVars map[string]interface
 	return keys, nil
 }
 
+// resolveList returns the keys of an IN clause. vals is either
+// a list of values, or the name of a list bind var.
+func (rtr *Router) resolveList(vals interface{}, bindVars map[string]interface{}) (keys []interface{}, err error) {
+	name, ok := vals.(string)
+	if !ok {
+		return rtr.resolveKeys(vals.([]interface{}), bindVars)
+	}
+	list, ok := bindVars[name[2:]].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("could not find list bind var %s", name)
+	}
+	keys = make([]interface{}, 0, len(list))
+	for _, val := range list {
+		switch val := val.(type) {
+		case nil:
+			// A NULL value can't match any row.
+		case []byte:
+			keys = append(keys, string(val))
+		default:
+			keys = append(keys, val)
+		}
+	}
+	return keys, nil
+}
+
 func (rtr *Router) resolveShards(vcursor *requestContext, vindexKeys []interface{}, plan *planbuilder.Plan) (newKeyspace string, routing routingMap, err error) {
 	newKeyspace, allShards, err := getKeyspaceShards(vcursor.ctx, rtr.serv, rtr.cell, plan.Table.Keyspace.Name, vcursor.query.TabletType)
 	if err != nil {
@@ -320,11 +1959,14 @@ func (rtr *Router) resolveShards(vcursor *requestContext, vindexKeys []interface
 	routing = make(routingMap)
 	switch mapper := plan.ColVindex.Vindex.(type) {
 	case planbuilder.Unique:
-		ksids, err := mapper.Map(vcursor, vindexKeys)
+		cursor, op := vcursor.startVindexOp(plan.ColVindex, "Map", len(vindexKeys))
+		ksids, err := mapper.Map(cursor, vindexKeys)
+		op.finish(err)
 		if err != nil {
 			return "", nil, err
 		}
 		for i, ksid := range ksids {
+			vcursor.recordVindexRoute(vindexKeys[i], []key.KeyspaceId{ksid}, allShards)
 			if ksid == key.MinKey {
 				continue
 			}
@@ -335,11 +1977,14 @@ func (rtr *Router) resolveShards(vcursor *requestContext, vindexKeys []interface
 			routing.Add(shard, vindexKeys[i])
 		}
 	case planbuilder.NonUnique:
-		ksidss, err := mapper.Map(vcursor, vindexKeys)
+		cursor, op := vcursor.startVindexOp(plan.ColVindex, "Map", len(vindexKeys))
+		ksidss, err := mapper.Map(cursor, vindexKeys)
+		op.finish(err)
 		if err != nil {
 			return "", nil, err
 		}
 		for i, ksids := range ksidss {
+			vcursor.recordVindexRoute(vindexKeys[i], ksids, allShards)
 			for _, ksid := range ksids {
 				if ksid == key.MinKey {
 					continue
@@ -366,7 +2011,9 @@ func (rtr *Router) resolveSingleShard(vcursor *requestContext, vindexKey interfa
 	if !ok {
 		panic("unexpected")
 	}
-	ksids, err := mapper.Map(vcursor, []interface{}{vindexKey})
+	cursor, op := vcursor.startVindexOp(plan.ColVindex, "Map", 1)
+	ksids, err := mapper.Map(cursor, []interface{}{vindexKey})
+	op.finish(err)
 	if err != nil {
 		return "", "", "", err
 	}
@@ -374,6 +2021,7 @@ func (rtr *Router) resolveSingleShard(vcursor *requestContext, vindexKey interfa
 		panic("unexpected")
 	}
 	ksid = ksids[0]
+	vcursor.recordVindexRoute(vindexKey, ksids, allShards)
 	if ksid == key.MinKey {
 		return "", "", ksid, nil
 	}
@@ -384,30 +2032,35 @@ func (rtr *Router) resolveSingleShard(vcursor *requestContext, vindexKey interfa
 	return newKeyspace, shard, ksid, nil
 }
 
-func (rtr *Router) deleteVindexEntries(vcursor *requestContext, plan *planbuilder.Plan, ks, shard string, ksid key.KeyspaceId) error {
+// deleteVindexEntries deletes the entries of the owned vindexes
+// for the rows of a delete. The rows are selected by the plan's
+// Subquery with bindVars. It returns the entries it deleted,
+// even if it fails.
+func (rtr *Router) deleteVindexEntries(vcursor *requestContext, plan *planbuilder.Plan, bindVars map[string]interface{}, ks, shard string, ksid key.KeyspaceId) ([]vindexEntries, error) {
 	result, err := rtr.scatterConn.Execute(
 		vcursor.ctx,
 		plan.Subquery,
-		vcursor.query.BindVariables,
+		bindVars,
 		ks,
 		[]string{shard},
 		vcursor.query.TabletType,
 		NewSafeSession(vcursor.query.Session))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(result.Rows) == 0 {
-		return nil
+		return nil, nil
 	}
 	if len(result.Rows[0]) != len(plan.Table.Owned) {
 		panic("unexpected")
 	}
+	var deleted []vindexEntries
 	for i, colVindex := range plan.Table.Owned {
 		keys := make(map[interface{}]bool)
 		for _, row := range result.Rows {
 			k, err := mproto.Convert(result.Fields[i].Type, row[i])
 			if err != nil {
-				return err
+				return deleted, err
 			}
 			switch k := k.(type) {
 			case []byte:
@@ -420,20 +2073,238 @@ func (rtr *Router) deleteVindexEntries(vcursor *requestContext, plan *planbuilde
 		for k := range keys {
 			ids = append(ids, k)
 		}
+		cursor, op := vcursor.startVindexOp(colVindex, "Delete", len(ids))
 		switch vindex := colVindex.Vindex.(type) {
 		case planbuilder.Functional:
-			if err = vindex.Delete(vcursor, ids, ksid); err != nil {
-				return err
-			}
+			err = vindex.Delete(cursor, ids, ksid)
 		case planbuilder.Lookup:
-			if err = vindex.Delete(vcursor, ids, ksid); err != nil {
-				return err
-			}
+			err = vindex.Delete(cursor, ids, ksid)
 		default:
 			panic("unexpceted")
 		}
+		op.finish(err)
+		if err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, vindexEntries{colVindex: colVindex, ids: ids})
 	}
-	return nil
+	return deleted, nil
+}
+
+// vindexEntries are the ids of an owned vindex
+// that a statement created or deleted.
+type vindexEntries struct {
+	colVindex *planbuilder.ColVindex
+	ids       []interface{}
+}
+
+// routeDML passes the keyspace id of a DML to vttablet through
+// bindVars, and returns the sql to send. If dml_routing_comment
+// is set, the keyspace id is appended to the sql as a comment instead.
+func routeDML(sql string, bindVars map[string]interface{}, ksid key.KeyspaceId) string {
+	if *dmlRoutingComment {
+		return sql + fmt.Sprintf(dmlPostfix, ksid)
+	}
+	bindVars[tproto.RoutingKeyspaceId] = string(ksid)
+	return sql
+}
+
+// execShardDML executes a DML on a single shard. If the session
+// is in the autocommit mode and not in a transaction, the DML is
+// sent between the begin and the commit of its own transaction in
+// a single batch, which takes one round-trip to vttablet instead
+// of three.
+func (rtr *Router) execShardDML(vcursor *requestContext, sql, ks, shard string) (*mproto.QueryResult, error) {
+	session := vcursor.query.Session
+	if session == nil || !session.Autocommit || session.InTransaction {
+		return rtr.scatterConn.Execute(
+			vcursor.ctx,
+			sql,
+			vcursor.query.BindVariables,
+			ks,
+			[]string{shard},
+			vcursor.query.TabletType,
+			NewSafeSession(session))
+	}
+	queries := []tproto.BoundQuery{
+		{Sql: "begin"},
+		{Sql: sql, BindVariables: vcursor.query.BindVariables},
+		{Sql: "commit"},
+	}
+	// The position of the write is read in the same round-trip.
+	if session.ReadAfterWrite && vcursor.query.TabletType == topo.TYPE_MASTER {
+		queries = append(queries, tproto.BoundQuery{Sql: *positionQuery})
+	}
+	qrs, err := rtr.scatterConn.ExecuteBatch(
+		vcursor.ctx,
+		queries,
+		ks,
+		[]string{shard},
+		vcursor.query.TabletType,
+		NewSafeSession(nil))
+	if err != nil {
+		return nil, err
+	}
+	if len(queries) == 4 {
+		position := ""
+		if rows := qrs.List[3].Rows; len(rows) == 1 && len(rows[0]) == 1 {
+			position = rows[0][0].String()
+		}
+		NewSafeSession(session).setWritePosition(ks, shard, position)
+	}
+	return &qrs.List[1], nil
+}
+
+// needsTransaction returns true if plan writes to a Consistent
+// vindex, and the session is not in a transaction.
+func needsTransaction(vcursor *requestContext, plan *planbuilder.Plan) bool {
+	if vcursor.query.Session != nil && vcursor.query.Session.InTransaction {
+		return false
+	}
+	for _, colVindex := range plan.Table.Owned {
+		if _, ok := colVindex.Vindex.(planbuilder.Consistent); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// execInTransaction calls exec in a transaction of its own,
+// which is committed only if exec succeeds.
+func (rtr *Router) execInTransaction(vcursor *requestContext, exec func() (*mproto.QueryResult, error)) (*mproto.QueryResult, error) {
+	if vcursor.query.Session == nil {
+		vcursor.query.Session = new(proto.Session)
+	}
+	vcursor.query.Session.InTransaction = true
+	safeSession := NewSafeSession(vcursor.query.Session)
+	result, err := exec()
+	if err != nil {
+		rtr.scatterConn.Rollback(vcursor.ctx, safeSession)
+		return nil, err
+	}
+	if err := rtr.scatterConn.Commit(vcursor.ctx, safeSession); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// updateVindexEntries replaces the entries of the owned vindexes
+// whose columns are changed by an UpdateEqual. The old values are
+// selected by the plan's Subquery, and the new values are the plan's
+// Assignments. It returns the entries it deleted and created, even
+// if it fails.
+func (rtr *Router) updateVindexEntries(vcursor *requestContext, plan *planbuilder.Plan, ks, shard string, ksid key.KeyspaceId) (deleted, created []vindexEntries, err error) {
+	result, err := rtr.scatterConn.Execute(
+		vcursor.ctx,
+		plan.Subquery,
+		vcursor.query.BindVariables,
+		ks,
+		[]string{shard},
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(result.Rows) == 0 {
+		return nil, nil, nil
+	}
+	i := 0
+	for _, colVindex := range plan.Table.ColVindexes[1:] {
+		val, ok := plan.Assignments[colVindex.Col]
+		if !ok {
+			continue
+		}
+		keys, err := rtr.resolveKeys([]interface{}{val}, vcursor.query.BindVariables)
+		if err != nil {
+			return deleted, created, err
+		}
+		oldKeys := make(map[interface{}]bool)
+		for _, row := range result.Rows {
+			k, err := mproto.Convert(result.Fields[i].Type, row[i])
+			if err != nil {
+				return deleted, created, err
+			}
+			switch k := k.(type) {
+			case nil:
+				// NULL values have no vindex entries.
+			case []byte:
+				oldKeys[string(k)] = true
+			default:
+				oldKeys[k] = true
+			}
+		}
+		i++
+		vindex := colVindex.Vindex.(planbuilder.Lookup)
+		if len(oldKeys) != 0 {
+			var ids []interface{}
+			for k := range oldKeys {
+				ids = append(ids, k)
+			}
+			cursor, op := vcursor.startVindexOp(colVindex, "Delete", len(ids))
+			err := vindex.Delete(cursor, ids, ksid)
+			op.finish(err)
+			if err != nil {
+				return deleted, created, err
+			}
+			deleted = append(deleted, vindexEntries{colVindex: colVindex, ids: ids})
+		}
+		if keys[0] == nil {
+			continue
+		}
+		cursor, op := vcursor.startVindexOp(colVindex, "Create", 1)
+		err = vindex.Create(cursor, keys[0], ksid)
+		op.finish(err)
+		if err != nil {
+			return deleted, created, err
+		}
+		created = append(created, vindexEntries{colVindex: colVindex, ids: keys})
+	}
+	return deleted, created, nil
+}
+
+// revertUpdated reverts the changes of updateVindexEntries.
+func (rtr *Router) revertUpdated(vcursor *requestContext, deleted, created []vindexEntries, ksid key.KeyspaceId, err error) error {
+	err = rtr.revertCreated(vcursor, created, ksid, err)
+	return rtr.revertDeleted(vcursor, deleted, ksid, err)
+}
+
+// revertCreated deletes the Consistent vindex entries created
+// by a statement that failed with err. It returns err, along
+// with the reason why the entries could not be deleted, if any.
+func (rtr *Router) revertCreated(vcursor *requestContext, created []vindexEntries, ksid key.KeyspaceId, err error) error {
+	for _, entries := range created {
+		vindex, ok := entries.colVindex.Vindex.(planbuilder.Consistent)
+		if !ok {
+			continue
+		}
+		cursor, op := vcursor.startVindexOp(entries.colVindex, "Delete", len(entries.ids))
+		revertErr := vindex.Delete(cursor, entries.ids, ksid)
+		op.finish(revertErr)
+		if revertErr != nil {
+			return fmt.Errorf("%v; could not revert the entries of vindex %s: %v", err, entries.colVindex.Name, revertErr)
+		}
+	}
+	return err
+}
+
+// revertDeleted is like revertCreated,
+// but the entries are recreated.
+func (rtr *Router) revertDeleted(vcursor *requestContext, deleted []vindexEntries, ksid key.KeyspaceId, err error) error {
+	for _, entries := range deleted {
+		vindex, ok := entries.colVindex.Vindex.(planbuilder.Consistent)
+		if !ok {
+			continue
+		}
+		for _, id := range entries.ids {
+			cursor, op := vcursor.startVindexOp(entries.colVindex, "Create", 1)
+			revertErr := vindex.Create(cursor, id, ksid)
+			op.finish(revertErr)
+			if revertErr != nil {
+				return fmt.Errorf("%v; could not revert the entries of vindex %s: %v", err, entries.colVindex.Name, revertErr)
+			}
+		}
+	}
+	return err
 }
 
 func (rtr *Router) handlePrimary(vcursor *requestContext, vindexKey interface{}, colVindex *planbuilder.ColVindex, bv map[string]interface{}) (ksid key.KeyspaceId, generated int64, err error) {
@@ -443,13 +2314,17 @@ func (rtr *Router) handlePrimary(vcursor *requestContext, vindexKey interface{},
 			if !ok {
 				return "", 0, fmt.Errorf("value must be supplied for column %s", colVindex.Col)
 			}
-			generated, err = generator.Generate(vcursor)
+			cursor, op := vcursor.startVindexOp(colVindex, "Generate", 1)
+			generated, err = generator.Generate(cursor)
+			op.finish(err)
 			vindexKey = generated
 			if err != nil {
 				return "", 0, err
 			}
 		} else {
-			err = colVindex.Vindex.(planbuilder.Functional).Create(vcursor, vindexKey)
+			cursor, op := vcursor.startVindexOp(colVindex, "Create", 1)
+			err = colVindex.Vindex.(planbuilder.Functional).Create(cursor, vindexKey)
+			op.finish(err)
 			if err != nil {
 				return "", 0, err
 			}
@@ -459,7 +2334,9 @@ func (rtr *Router) handlePrimary(vcursor *requestContext, vindexKey interface{},
 		return "", 0, fmt.Errorf("value must be supplied for column %s", colVindex.Col)
 	}
 	mapper := colVindex.Vindex.(planbuilder.Unique)
-	ksids, err := mapper.Map(vcursor, []interface{}{vindexKey})
+	cursor, op := vcursor.startVindexOp(colVindex, "Map", 1)
+	ksids, err := mapper.Map(cursor, []interface{}{vindexKey})
+	op.finish(err)
 	if err != nil {
 		return "", 0, err
 	}
@@ -478,13 +2355,17 @@ func (rtr *Router) handleNonPrimary(vcursor *requestContext, vindexKey interface
 			if !ok {
 				return 0, fmt.Errorf("value must be supplied for column %s", colVindex.Col)
 			}
-			generated, err = generator.Generate(vcursor, ksid)
+			cursor, op := vcursor.startVindexOp(colVindex, "Generate", 1)
+			generated, err = generator.Generate(cursor, ksid)
+			op.finish(err)
 			vindexKey = generated
 			if err != nil {
 				return 0, err
 			}
 		} else {
-			err = colVindex.Vindex.(planbuilder.Lookup).Create(vcursor, vindexKey, ksid)
+			cursor, op := vcursor.startVindexOp(colVindex, "Create", 1)
+			err = colVindex.Vindex.(planbuilder.Lookup).Create(cursor, vindexKey, ksid)
+			op.finish(err)
 			if err != nil {
 				return 0, err
 			}
@@ -503,7 +2384,9 @@ func (rtr *Router) handleNonPrimary(vcursor *requestContext, vindexKey interface
 				return 0, fmt.Errorf("could not compute value for column %v", colVindex.Col)
 			}
 		} else {
-			ok, err := colVindex.Vindex.Verify(vcursor, vindexKey, ksid)
+			cursor, op := vcursor.startVindexOp(colVindex, "Verify", 1)
+			ok, err := colVindex.Vindex.Verify(cursor, vindexKey, ksid)
+			op.finish(err)
 			if err != nil {
 				return 0, err
 			}
@@ -516,6 +2399,39 @@ func (rtr *Router) handleNonPrimary(vcursor *requestContext, vindexKey interface
 	return generated, nil
 }
 
+// MapKeyspaceId returns the shard of keyspace that ksid belongs to,
+// and the values that the Reversible vindexes of the keyspace map
+// ksid to, by vindex name.
+func (rtr *Router) MapKeyspaceId(ctx context.Context, keyspace string, ksid key.KeyspaceId, tabletType topo.TabletType) (shard string, values map[string]interface{}, err error) {
+	_, shard, err = rtr.getRouting(ctx, keyspace, tabletType, ksid)
+	if err != nil {
+		return "", nil, err
+	}
+	values = make(map[string]interface{})
+	schema := rtr.planner.Schema()
+	if schema == nil {
+		return shard, values, nil
+	}
+	vcursor := newRequestContext(ctx, &proto.Query{TabletType: tabletType}, rtr)
+	for _, table := range schema.KeyspaceTables(keyspace) {
+		for _, colVindex := range table.ColVindexes {
+			if _, ok := values[colVindex.Name]; ok {
+				continue
+			}
+			reversible, ok := colVindex.Vindex.(planbuilder.Reversible)
+			if !ok {
+				continue
+			}
+			value, err := reversible.ReverseMap(vcursor, ksid)
+			if err != nil {
+				return "", nil, fmt.Errorf("vindex %s: %v", colVindex.Name, err)
+			}
+			values[colVindex.Name] = value
+		}
+	}
+	return shard, values, nil
+}
+
 func (rtr *Router) getRouting(ctx context.Context, keyspace string, tabletType topo.TabletType, ksid key.KeyspaceId) (newKeyspace, shard string, err error) {
 	newKeyspace, allShards, err := getKeyspaceShards(ctx, rtr.serv, rtr.cell, keyspace, tabletType)
 	if err != nil {
@@ -5,14 +5,17 @@
 package vtgate
 
 import (
+	"fmt"
 	"path"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/sqltypes"
 	"github.com/youtube/vitess/go/testfiles"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
 	"github.com/youtube/vitess/go/vt/vtgate/proto"
@@ -105,6 +108,17 @@ func TestUnsharded(t *testing.T) {
 	if sbc.Queries[0] != wantQuery {
 		t.Errorf("sbc.Queries[0]: %q, want %q\n", sbc.Queries[0], wantQuery)
 	}
+
+	q.Sql = "select * from music_map where id = 1"
+	sbc.Queries = nil
+	_, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQuery = "select * from music_user_map as music_map where id = 1"
+	if sbc.Queries[0] != wantQuery {
+		t.Errorf("sbc.Queries[0]: %q, want %q\n", sbc.Queries[0], wantQuery)
+	}
 }
 
 func TestSelectEqual(t *testing.T) {
@@ -297,6 +311,8 @@ func TestSelectKeyrange(t *testing.T) {
 	sbc2 := &sandboxConn{}
 	s.MapTestConn("-20", sbc1)
 	s.MapTestConn("40-60", sbc2)
+	sbc3 := &sandboxConn{}
+	s.MapTestConn("20-40", sbc3)
 	serv := new(sandboxTopo)
 	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
 	router := NewRouter(serv, "aa", schema, "", scatterConn)
@@ -336,6 +352,164 @@ func TestSelectKeyrange(t *testing.T) {
 	if sbc2.Queries[0] != wantQuery {
 		t.Errorf("sbc2.Queries[0]: %q, want %q\n", sbc2.Queries[0], wantQuery)
 	}
+
+	// A keyrange that spans multiple shards.
+	q.Sql = "select * from user where keyrange('\x10', '\x50')"
+	result, err := router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sbc1.ExecCount != 2 {
+		t.Errorf("sbc1.ExecCount: %v, want 2\n", sbc1.ExecCount)
+	}
+	if sbc2.ExecCount != 2 {
+		t.Errorf("sbc2.ExecCount: %v, want 2\n", sbc2.ExecCount)
+	}
+	if sbc3.ExecCount != 1 {
+		t.Errorf("sbc3.ExecCount: %v, want 1\n", sbc3.ExecCount)
+	}
+	// -20, 20-40 and 40-60 each return a row.
+	if result.RowsAffected != 3 {
+		t.Errorf("result.RowsAffected: %v, want 3", result.RowsAffected)
+	}
+}
+
+func TestMapKeyspaceId(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	createSandbox("TestRouter")
+	createSandbox(TEST_UNSHARDED)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	shard, values, err := router.MapKeyspaceId(context.Background(), "TestRouter", "\x16k@\xb4J\xbaK\xd6", topo.TYPE_MASTER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shard != "-20" {
+		t.Errorf("shard: %s, want -20", shard)
+	}
+	wantValues := map[string]interface{}{"user_index": int64(1)}
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("values: %v, want %v", values, wantValues)
+	}
+
+	// The unsharded keyspace has no Reversible vindexes.
+	shard, values, err = router.MapKeyspaceId(context.Background(), TEST_UNSHARDED, "\x16k@\xb4J\xbaK\xd6", topo.TYPE_MASTER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shard != "0" || len(values) != 0 {
+		t.Errorf("MapKeyspaceId: %s, %v, want 0, map[]", shard, values)
+	}
+}
+
+func TestSelectRange(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	var conns []*sandboxConn
+	for _, shard := range shards {
+		sbc := &sandboxConn{}
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	// The event ids from 0 to 255 are spread
+	// across the shards, 32 ids per shard.
+	testcases := []struct {
+		sql      string
+		bindVars map[string]interface{}
+		want     []int
+	}{{
+		sql:  "select * from event where time between 40 and 70",
+		want: []int{0, 1, 1, 0, 0, 0, 0, 0},
+	}, {
+		sql:      "select * from event where time >= :start and time < :end",
+		bindVars: map[string]interface{}{"start": 30, "end": 100},
+		want:     []int{1, 1, 1, 1, 0, 0, 0, 0},
+	}, {
+		sql:  "select * from event where time > 200",
+		want: []int{0, 0, 0, 0, 0, 0, 1, 1},
+	}, {
+		sql:  "select * from event where time between 70 and 40",
+		want: []int{0, 0, 0, 0, 0, 0, 0, 0},
+	}}
+	for _, tcase := range testcases {
+		for _, conn := range conns {
+			conn.ExecCount.Set(0)
+		}
+		q := proto.Query{
+			Sql:           tcase.sql,
+			BindVariables: tcase.bindVars,
+			TabletType:    topo.TYPE_MASTER,
+		}
+		if _, err := router.Execute(context.Background(), &q); err != nil {
+			t.Errorf("%s: %v", tcase.sql, err)
+			continue
+		}
+		var got []int
+		for _, conn := range conns {
+			got = append(got, int(conn.ExecCount.Get()))
+		}
+		if !reflect.DeepEqual(got, tcase.want) {
+			t.Errorf("%s: ExecCount: %v, want %v", tcase.sql, got, tcase.want)
+		}
+	}
+}
+
+func TestSelectEqualCellAffinity(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("80-a0", sbc)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	q := proto.Query{
+		Sql:        "select * from customer where country = 'DE'",
+		TabletType: topo.TYPE_REPLICA,
+	}
+	_, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sbc.ExecCount != 1 {
+		t.Errorf("sbc.ExecCount: %v, want 1\n", sbc.ExecCount)
+	}
+	s.sandmu.Lock()
+	cell := s.EndPointCell
+	s.sandmu.Unlock()
+	if cell != "eu_west" {
+		t.Errorf("EndPointCell: %s, want eu_west", cell)
+	}
+
+	// Transactions stay in the cell of vtgate.
+	q.TabletType = topo.TYPE_MASTER
+	q.Session = &proto.Session{InTransaction: true}
+	_, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.sandmu.Lock()
+	cell = s.EndPointCell
+	s.sandmu.Unlock()
+	if cell != "aa" {
+		t.Errorf("EndPointCell: %s, want aa", cell)
+	}
 }
 
 func TestSelectScatter(t *testing.T) {
@@ -374,224 +548,1999 @@ func TestSelectScatter(t *testing.T) {
 	}
 }
 
-func TestUpdateEqual(t *testing.T) {
+func TestSelectScatterDistinct(t *testing.T) {
 	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
 	if err != nil {
 		t.Fatal(err)
 	}
 	s := createSandbox("TestRouter")
-	sbc1 := &sandboxConn{}
-	sbc2 := &sandboxConn{}
-	s.MapTestConn("-20", sbc1)
-	s.MapTestConn("40-60", sbc2)
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	var conns []*sandboxConn
+	for _, shard := range shards {
+		sbc := &sandboxConn{}
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
 	serv := new(sandboxTopo)
 	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
 	router := NewRouter(serv, "aa", schema, "", scatterConn)
 	q := proto.Query{
-		Sql:        "update user set a=2 where id = 1",
+		Sql:        "select distinct id, value from user",
 		TabletType: topo.TYPE_MASTER,
 	}
-	_, err = router.Execute(context.Background(), &q)
+	result, err := router.Execute(context.Background(), &q)
 	if err != nil {
-		t.Error(err)
-	}
-	wantBind := map[string]interface{}{
-		"keyspace_id": "\x16k@\xb4J\xbaK\xd6",
+		t.Fatal(err)
 	}
-	if !reflect.DeepEqual(sbc1.BindVars[0], wantBind) {
-		t.Errorf("sbc1.BindVars[0] = %#v, want %#v", sbc1.BindVars[0], wantBind)
+	// Every shard returns the same row.
+	wantResult := &mproto.QueryResult{
+		Fields:       singleRowResult.Fields,
+		RowsAffected: 1,
+		Rows:         singleRowResult.Rows,
 	}
-	wantQuery := "update user set a = 2 where id = 1 /* _routing keyspace_id:166b40b44aba4bd6 */"
-	if sbc1.Queries[0] != wantQuery {
-		t.Errorf("sbc1.Queries[0]: %q, want %q\n", sbc1.Queries[0], wantQuery)
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
 	}
-	if sbc2.ExecCount != 0 {
-		t.Errorf("sbc2.ExecCount: %v, want 0\n", sbc2.ExecCount)
+	wantQuery := "select distinct id, value from user"
+	for _, conn := range conns {
+		if conn.Queries[0] != wantQuery {
+			t.Errorf("conn.Queries[0]: %q, want %q\n", conn.Queries[0], wantQuery)
+		}
 	}
-	q.Sql = "update user set a=2 where id = 3"
+
+	router.maxDistinctBytes = 4
 	_, err = router.Execute(context.Background(), &q)
-	if err != nil {
-		t.Error(err)
-	}
-	if sbc1.ExecCount != 1 {
-		t.Errorf("sbc1.ExecCount: %v, want 1\n", sbc1.ExecCount)
-	}
-	wantBind = map[string]interface{}{
-		"keyspace_id": "N\xb1\x90ɢ\xfa\x16\x9c",
-	}
-	if !reflect.DeepEqual(sbc2.BindVars[0], wantBind) {
-		t.Errorf("sbc2.BindVars[0] = %#v, want %#v", sbc2.BindVars[0], wantBind)
-	}
-	wantQuery = "update user set a = 2 where id = 3 /* _routing keyspace_id:4eb190c9a2fa169c */"
-	if sbc2.Queries[0] != wantQuery {
-		t.Errorf("sbc2.Queries[0]: %q, want %q\n", sbc2.Queries[0], wantQuery)
+	want := "distinct: rows exceed the memory limit of 4 bytes"
+	if err == nil || err.Error() != want {
+		t.Errorf("router.Execute: %v, want %s", err, want)
 	}
 }
 
-func TestDeleteEqual(t *testing.T) {
+func TestSelectScatterPartial(t *testing.T) {
 	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
 	if err != nil {
 		t.Fatal(err)
 	}
 	s := createSandbox("TestRouter")
-	sbc := &sandboxConn{}
-	s.MapTestConn("-20", sbc)
-
-	l := createSandbox("TestUnsharded")
-	sbclookup := &sandboxConn{}
-	l.MapTestConn("0", sbclookup)
-
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	var conns []*sandboxConn
+	for _, shard := range shards {
+		sbc := &sandboxConn{}
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
 	serv := new(sandboxTopo)
 	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
 	router := NewRouter(serv, "aa", schema, "", scatterConn)
 
-	sbc.setResults([]*mproto.QueryResult{&mproto.QueryResult{
-		Fields: []mproto.Field{
-			{"id", 3},
-			{"name", 253},
-		},
-		RowsAffected: 1,
-		InsertId:     0,
-		Rows: [][]sqltypes.Value{{
-			{sqltypes.Numeric("1")},
-			{sqltypes.String("myname")},
-		}},
-	}})
+	// Partial results are not allowed by default.
+	conns[0].mustFailServer = 1
 	q := proto.Query{
-		Sql:        "delete from user where id = 1",
+		Sql:        "select * from user",
 		TabletType: topo.TYPE_MASTER,
+		Session:    &proto.Session{},
 	}
-	_, err = router.Execute(context.Background(), &q)
+	_, shardErrors, err := router.ExecutePartial(context.Background(), &q)
+	if err == nil || shardErrors != nil {
+		t.Errorf("ExecutePartial: %v, %v, want error", shardErrors, err)
+	}
+
+	conns[0].mustFailServer = 1
+	q.Session.AllowPartialResults = true
+	result, shardErrors, err := router.ExecutePartial(context.Background(), &q)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-	wantBinds := []map[string]interface{}{{}, {
-		"keyspace_id": "\x16k@\xb4J\xbaK\xd6",
-	}}
-	if !reflect.DeepEqual(sbc.BindVars, wantBinds) {
-		t.Errorf("sbc.BindVars = %#v, want %#v", sbc.BindVars, wantBinds)
+	if len(result.Rows) != 7 {
+		t.Errorf("len(result.Rows): %d, want 7", len(result.Rows))
 	}
-	wantQueries := []string{
-		"select id, name from user where id = 1 for update",
-		"delete from user where id = 1 /* _routing keyspace_id:166b40b44aba4bd6 */",
+	if len(shardErrors) != 1 || !strings.Contains(shardErrors[0].Error(), "error: err") {
+		t.Errorf("shardErrors: %v, want one error", shardErrors)
 	}
-	if !reflect.DeepEqual(sbc.Queries, wantQueries) {
-		t.Errorf("sbc.Queries: %q, want %q\n", sbc.Queries, wantQueries)
+
+	// Merged results are partial too.
+	conns[0].mustFailServer = 1
+	q.Sql = "select id from user order by id"
+	result, shardErrors, err = router.ExecutePartial(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rows) != 7 || len(shardErrors) != 1 {
+		t.Errorf("ExecutePartial: %d rows, %v, want 7 rows and one error", len(result.Rows), shardErrors)
 	}
 
-	wantBinds = []map[string]interface{}{{
-		"id": []interface{}{int64(1)},
-	}, {
-		"user_id": int64(1),
-		"name":    []interface{}{"myname"},
-	}}
-	if !reflect.DeepEqual(sbclookup.BindVars, wantBinds) {
-		t.Errorf("sbclookup.BindVars = \n%#v, want \n%#v", sbclookup.BindVars, wantBinds)
+	// The query fails if all the shards fail.
+	for _, conn := range conns {
+		conn.mustFailServer = 1
 	}
-	wantQueries = []string{
-		"delete from user_idx where id in ::id",
-		"delete from name_user_map where name in ::name and user_id = :user_id",
+	q.Sql = "select * from user"
+	_, _, err = router.ExecutePartial(context.Background(), &q)
+	if err == nil {
+		t.Errorf("ExecutePartial: nil, want error")
 	}
-	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
-		t.Errorf("sbclookup.Queries: %q, want %q\n", sbclookup.Queries, wantQueries)
+
+	// Partial results are not allowed in transactions.
+	conns[0].mustFailServer = 1
+	q.Session.InTransaction = true
+	_, _, err = router.ExecutePartial(context.Background(), &q)
+	if err == nil {
+		t.Errorf("ExecutePartial: nil, want error")
 	}
 }
 
-func TestInsertSharded(t *testing.T) {
+func TestSelectScatterAggregates(t *testing.T) {
 	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
 	if err != nil {
 		t.Fatal(err)
 	}
 	s := createSandbox("TestRouter")
-	sbc1 := &sandboxConn{}
-	sbc2 := &sandboxConn{}
-	s.MapTestConn("-20", sbc1)
-	s.MapTestConn("40-60", sbc2)
-
-	l := createSandbox("TestUnsharded")
-	sbclookup := &sandboxConn{}
-	l.MapTestConn("0", sbclookup)
-
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	var conns []*sandboxConn
+	for i, shard := range shards {
+		sbc := &sandboxConn{}
+		sbc.setResults([]*mproto.QueryResult{aggregateRowResult(i)})
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
 	serv := new(sandboxTopo)
 	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
 	router := NewRouter(serv, "aa", schema, "", scatterConn)
-
 	q := proto.Query{
-		Sql:        "insert into user(id, v, name) values (1, 2, 'myname')",
+		Sql:        "select count(*), sum(a), min(b), max(b) from user",
 		TabletType: topo.TYPE_MASTER,
 	}
-	_, err = router.Execute(context.Background(), &q)
+	result, err := router.Execute(context.Background(), &q)
 	if err != nil {
-		t.Error(err)
-	}
-	wantBind := map[string]interface{}{
-		"id": int64(1),
+		t.Fatal(err)
 	}
-	if !reflect.DeepEqual(sbclookup.BindVars[0], wantBind) {
-		t.Errorf("sbclookup.BindVars[0] = %#v, want %#v", sbclookup.BindVars[0], wantBind)
+	wantResult := &mproto.QueryResult{
+		Fields:       aggregateRowResult(0).Fields,
+		RowsAffected: 1,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("28")},
+			{sqltypes.Fractional("16.0")},
+			{sqltypes.String("b0")},
+			{sqltypes.String("b7")},
+		}},
 	}
-	wantQuery := "insert into user_idx(id) values(:id)"
-	if sbclookup.Queries[0] != wantQuery {
-		t.Errorf("sbclookup.Queries[0]: %q, want %q\n", sbclookup.Queries[0], wantQuery)
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
 	}
-	wantBind = map[string]interface{}{
-		"keyspace_id": "\x16k@\xb4J\xbaK\xd6",
-		"_id":         int64(1),
-		"_name":       "myname",
+
+	for i, conn := range conns {
+		conn.setResults([]*mproto.QueryResult{aggregateRowResult(i)})
 	}
-	if !reflect.DeepEqual(sbc1.BindVars[0], wantBind) {
-		t.Errorf("sbc1.BindVars[0] = %#v, want %#v", sbc1.BindVars[0], wantBind)
+	result, err = routerStream(router, &q)
+	if err != nil {
+		t.Fatal(err)
 	}
-	wantQuery = "insert into user(id, v, name) values (:_id, 2, :_name) /* _routing keyspace_id:166b40b44aba4bd6 */"
-	if sbc1.Queries[0] != wantQuery {
-		t.Errorf("sbc1.Queries[0]: %q, want %q\n", sbc1.Queries[0], wantQuery)
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("routerStream: %+v, want %+v", result, wantResult)
 	}
-	if sbc2.ExecCount != 0 {
-		t.Errorf("sbc2.ExecCount: %v, want 0\n", sbc2.ExecCount)
+}
+
+// aggregateRowResult returns the partial aggregates for
+// "select count(*), sum(a), min(b), max(b)" from shard i.
+func aggregateRowResult(i int) *mproto.QueryResult {
+	return &mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"count(*)", mproto.VT_LONGLONG},
+			{"sum(a)", mproto.VT_NEWDECIMAL},
+			{"min(b)", mproto.VT_VAR_STRING},
+			{"max(b)", mproto.VT_VAR_STRING},
+		},
+		RowsAffected: 1,
+		Rows: [][]sqltypes.Value{{
+			sqltypes.MakeNumeric([]byte(fmt.Sprintf("%d", i))),
+			sqltypes.MakeFractional([]byte(fmt.Sprintf("%d.5", i/2))),
+			sqltypes.MakeString([]byte(fmt.Sprintf("b%d", i))),
+			sqltypes.MakeString([]byte(fmt.Sprintf("b%d", i))),
+		}},
+	}
+}
+
+func TestSelectScatterOrderBy(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	var conns []*sandboxConn
+	for i, shard := range shards {
+		sbc := &sandboxConn{}
+		sbc.setResults([]*mproto.QueryResult{orderedRowResult(i)})
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	q := proto.Query{
+		Sql:        "select id, a from user order by a desc limit 2, 3",
+		TabletType: topo.TYPE_MASTER,
+	}
+	result, err := router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantQuery := "select id, a from user order by a desc limit 5"
+	for _, conn := range conns {
+		if conn.Queries[0] != wantQuery {
+			t.Errorf("conn.Queries[0]: %q, want %q\n", conn.Queries[0], wantQuery)
+		}
+	}
+	// Shard i returns the values i+8 and i, in descending order.
+	wantResult := &mproto.QueryResult{
+		Fields:       orderedRowResult(0).Fields,
+		RowsAffected: 3,
+		Rows: [][]sqltypes.Value{{
+			sqltypes.MakeNumeric([]byte("13")),
+			sqltypes.MakeNumeric([]byte("13")),
+		}, {
+			sqltypes.MakeNumeric([]byte("12")),
+			sqltypes.MakeNumeric([]byte("12")),
+		}, {
+			sqltypes.MakeNumeric([]byte("11")),
+			sqltypes.MakeNumeric([]byte("11")),
+		}},
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+
+	for i, conn := range conns {
+		conn.setResults([]*mproto.QueryResult{orderedRowResult(i)})
+	}
+	result, err = routerStream(router, &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("routerStream: %+v, want %+v", result, wantResult)
+	}
+
+	// A failed reply must stop the merge.
+	for i, conn := range conns {
+		conn.setResults([]*mproto.QueryResult{orderedRowResult(i)})
+	}
+	err = router.StreamExecute(context.Background(), &q, func(qr *mproto.QueryResult) error {
+		return fmt.Errorf("reply failed")
+	})
+	want := "reply failed"
+	if err == nil || err.Error() != want {
+		t.Errorf("router.StreamExecute: %v, want %s", err, want)
+	}
+}
+
+// orderedRowResult returns the rows of shard i for
+// "select id, a from user order by a desc".
+func orderedRowResult(i int) *mproto.QueryResult {
+	return &mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"id", mproto.VT_LONGLONG},
+			{"a", mproto.VT_LONGLONG},
+		},
+		RowsAffected: 2,
+		Rows: [][]sqltypes.Value{{
+			sqltypes.MakeNumeric([]byte(fmt.Sprintf("%d", i+8))),
+			sqltypes.MakeNumeric([]byte(fmt.Sprintf("%d", i+8))),
+		}, {
+			sqltypes.MakeNumeric([]byte(fmt.Sprintf("%d", i))),
+			sqltypes.MakeNumeric([]byte(fmt.Sprintf("%d", i))),
+		}},
+	}
+}
+
+func TestSelectINLimit(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	sbc1.setResults([]*mproto.QueryResult{orderedRowResult(0)})
+	sbc2.setResults([]*mproto.QueryResult{orderedRowResult(1)})
+	q := proto.Query{
+		Sql:        "select id, a from user where id in (1, 3) limit 1, 2",
+		TabletType: topo.TYPE_MASTER,
+	}
+	result, err := router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each shard returns 2 rows. Only 2 of the 4 rows must be returned.
+	if result.RowsAffected != 2 || len(result.Rows) != 2 {
+		t.Errorf("result: %+v, want 2 rows", result)
+	}
+	wantQueries := []string{"select id, a from user where id in ::_vals limit 3"}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %q, want %q\n", sbc1.Queries, wantQueries)
+	}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %q, want %q\n", sbc2.Queries, wantQueries)
+	}
+
+	q.Sql = "select id, a from user where id in (1, 3) limit 4, 2"
+	result, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RowsAffected != 0 || len(result.Rows) != 0 {
+		t.Errorf("result: %+v, want no rows", result)
+	}
+}
+
+func TestSelectJoin(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	sbc1.setResults([]*mproto.QueryResult{&mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"id", 3},
+		},
+		RowsAffected: 2,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("1")},
+		}, {
+			{sqltypes.Numeric("3")},
+		}},
+	}})
+	q := proto.Query{
+		Sql:        "select u.id, e.extra from user as u join user_extra as e on u.id = e.user_id where u.id = 1",
+		TabletType: topo.TYPE_MASTER,
+	}
+	result, err := router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantResult := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"id", 3},
+			{"id", 3},
+		},
+		RowsAffected: 2,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("1")},
+			{sqltypes.Numeric("1")},
+		}, {
+			{sqltypes.Numeric("3")},
+			{sqltypes.Numeric("1")},
+		}},
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+	wantQueries := []string{
+		"select u.id from user as u where u.id = 1",
+		"select e.extra from user_extra as e where e.user_id = :_u_id",
+	}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %q, want %q\n", sbc1.Queries, wantQueries)
+	}
+	wantBinds := []map[string]interface{}{{}, {
+		"_u_id": int64(1),
+	}}
+	if !reflect.DeepEqual(sbc1.BindVars, wantBinds) {
+		t.Errorf("sbc1.BindVars = %#v, want %#v", sbc1.BindVars, wantBinds)
+	}
+	wantQueries = []string{
+		"select e.extra from user_extra as e where e.user_id = :_u_id",
+	}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %q, want %q\n", sbc2.Queries, wantQueries)
+	}
+	wantBinds = []map[string]interface{}{{
+		"_u_id": int64(3),
+	}}
+	if !reflect.DeepEqual(sbc2.BindVars, wantBinds) {
+		t.Errorf("sbc2.BindVars = %#v, want %#v", sbc2.BindVars, wantBinds)
+	}
+}
+
+func TestSelectUnion(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	q := proto.Query{
+		Sql:        "select id from user where id = 1 union all select id from user where id = 3",
+		TabletType: topo.TYPE_MASTER,
+	}
+	result, err := router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantResult := &mproto.QueryResult{
+		Fields:       singleRowResult.Fields,
+		RowsAffected: 2,
+		Rows:         append(singleRowResult.Rows, singleRowResult.Rows...),
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+	wantQueries := []string{"select id from user where id = 1"}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %q, want %q\n", sbc1.Queries, wantQueries)
+	}
+	wantQueries = []string{"select id from user where id = 3"}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %q, want %q\n", sbc2.Queries, wantQueries)
+	}
+
+	q.Sql = "select id from user where id = 1 union select id from user where id = 3"
+	result, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantResult = &mproto.QueryResult{
+		Fields:       singleRowResult.Fields,
+		RowsAffected: 1,
+		Rows:         singleRowResult.Rows,
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+}
+
+func TestSelectSubquery(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	subqueryResult := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"user_id", 3},
+		},
+		RowsAffected: 2,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("1")},
+		}, {
+			{sqltypes.Numeric("3")},
+		}},
+	}
+	sbc1.setResults([]*mproto.QueryResult{subqueryResult})
+	q := proto.Query{
+		Sql:        "select id from user where id in (select user_id from user_extra where user_id = 1)",
+		TabletType: topo.TYPE_MASTER,
+	}
+	result, err := router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantResult := &mproto.QueryResult{
+		Fields:       singleRowResult.Fields,
+		RowsAffected: 2,
+		Rows:         append(singleRowResult.Rows, singleRowResult.Rows...),
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+	wantQueries := []string{
+		"select user_id from user_extra where user_id = 1",
+		"select id from user where id in ::_vals",
+	}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %q, want %q\n", sbc1.Queries, wantQueries)
+	}
+	wantBinds := []map[string]interface{}{{}, {
+		"_sq1":  []interface{}{int64(1), int64(3)},
+		"_vals": []interface{}{int64(1)},
+	}}
+	if !reflect.DeepEqual(sbc1.BindVars, wantBinds) {
+		t.Errorf("sbc1.BindVars = %#v, want %#v", sbc1.BindVars, wantBinds)
+	}
+	wantBinds = []map[string]interface{}{{
+		"_sq1":  []interface{}{int64(1), int64(3)},
+		"_vals": []interface{}{int64(3)},
+	}}
+	if !reflect.DeepEqual(sbc2.BindVars, wantBinds) {
+		t.Errorf("sbc2.BindVars = %#v, want %#v", sbc2.BindVars, wantBinds)
+	}
+
+	// A subquery that returns no rows doesn't match anything.
+	sbc1.Queries = nil
+	sbc1.setResults([]*mproto.QueryResult{&mproto.QueryResult{Fields: subqueryResult.Fields}})
+	q.Sql = "select id from user where id = (select user_id from user_extra where user_id = 1)"
+	result, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rows) != 0 {
+		t.Errorf("result: %+v, want no rows", result)
+	}
+	wantQueries = []string{"select user_id from user_extra where user_id = 1"}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %q, want %q\n", sbc1.Queries, wantQueries)
+	}
+
+	sbc1.setResults([]*mproto.QueryResult{subqueryResult})
+	_, err = router.Execute(context.Background(), &q)
+	want := `subquery "select user_id from user_extra where user_id = 1" returned more than one row`
+	if err == nil || err.Error() != want {
+		t.Errorf("router.Execute: %v, want %s", err, want)
+	}
+}
+
+func TestSelectSemiJoin(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	outerResult := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"id", 3},
+			{"id", 3},
+		},
+		RowsAffected: 2,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("1")},
+			{sqltypes.Numeric("1")},
+		}, {
+			{sqltypes.Numeric("3")},
+			{sqltypes.Numeric("3")},
+		}},
+	}
+	sbc1.setResults([]*mproto.QueryResult{outerResult})
+	sbc2.setResults([]*mproto.QueryResult{&mproto.QueryResult{}})
+	q := proto.Query{
+		Sql:        "select id from user where id = 1 and exists (select 1 from user_extra where user_extra.user_id = user.id)",
+		TabletType: topo.TYPE_MASTER,
+	}
+	result, err := router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantResult := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"id", 3},
+		},
+		RowsAffected: 1,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("1")},
+		}},
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+	wantQueries := []string{
+		"select id, user.id from user where id = 1",
+		"select 1 from user_extra where user_extra.user_id = :_user_id limit 1",
+	}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %q, want %q\n", sbc1.Queries, wantQueries)
+	}
+	wantBinds := []map[string]interface{}{{
+		"_user_id": int64(3),
+	}}
+	if !reflect.DeepEqual(sbc2.BindVars, wantBinds) {
+		t.Errorf("sbc2.BindVars = %#v, want %#v", sbc2.BindVars, wantBinds)
+	}
+
+	sbc1.setResults([]*mproto.QueryResult{outerResult})
+	sbc2.setResults([]*mproto.QueryResult{&mproto.QueryResult{}})
+	q.Sql = "select id from user where id = 1 and not exists (select 1 from user_extra where user_extra.user_id = user.id)"
+	result, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantResult.Rows = [][]sqltypes.Value{{
+		{sqltypes.Numeric("3")},
+	}}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+}
+
+func TestUpdateEqual(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	q := proto.Query{
+		Sql:        "update user set a=2 where id = 1",
+		TabletType: topo.TYPE_MASTER,
+	}
+	_, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Error(err)
+	}
+	wantBind := map[string]interface{}{
+		"keyspace_id":        "\x16k@\xb4J\xbaK\xd6",
+		"_routingKeyspaceId": "\x16k@\xb4J\xbaK\xd6",
+	}
+	if !reflect.DeepEqual(sbc1.BindVars[0], wantBind) {
+		t.Errorf("sbc1.BindVars[0] = %#v, want %#v", sbc1.BindVars[0], wantBind)
+	}
+	wantQuery := "update user set a = 2 where id = 1"
+	if sbc1.Queries[0] != wantQuery {
+		t.Errorf("sbc1.Queries[0]: %q, want %q\n", sbc1.Queries[0], wantQuery)
+	}
+	if sbc2.ExecCount != 0 {
+		t.Errorf("sbc2.ExecCount: %v, want 0\n", sbc2.ExecCount)
+	}
+	q.Sql = "update user set a=2 where id = 3"
+	_, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Error(err)
+	}
+	if sbc1.ExecCount != 1 {
+		t.Errorf("sbc1.ExecCount: %v, want 1\n", sbc1.ExecCount)
+	}
+	wantBind = map[string]interface{}{
+		"keyspace_id":        "N\xb1\x90ɢ\xfa\x16\x9c",
+		"_routingKeyspaceId": "N\xb1\x90ɢ\xfa\x16\x9c",
+	}
+	if !reflect.DeepEqual(sbc2.BindVars[0], wantBind) {
+		t.Errorf("sbc2.BindVars[0] = %#v, want %#v", sbc2.BindVars[0], wantBind)
+	}
+	wantQuery = "update user set a = 2 where id = 3"
+	if sbc2.Queries[0] != wantQuery {
+		t.Errorf("sbc2.Queries[0]: %q, want %q\n", sbc2.Queries[0], wantQuery)
+	}
+}
+
+func TestSingleShardTransaction(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	q := proto.Query{
+		Sql:        "update user set a=2 where id = 1",
+		TabletType: topo.TYPE_MASTER,
+		Session: &proto.Session{
+			InTransaction:   true,
+			TransactionMode: proto.TxModeSingle,
+		},
+	}
+	_, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Error(err)
+	}
+	q.Sql = "update user set a=2 where id = 3"
+	_, err = router.Execute(context.Background(), &q)
+	want := "transaction_mode is single, but the transaction would span multiple shards: TestRouter/-20, TestRouter/40-60"
+	if err == nil || err.Error() != want {
+		t.Errorf("router.Execute: %v, want %s", err, want)
+	}
+	if sbc2.ExecCount != 0 {
+		t.Errorf("sbc2.ExecCount: %v, want 0\n", sbc2.ExecCount)
+	}
+}
+
+func TestSavepoint(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	session := &proto.Session{InTransaction: true}
+	for _, sql := range []string{
+		"update user set a=2 where id = 1",
+		"savepoint a",
+		"savepoint b",
+		"update user set a=2 where id = 3",
+		"rollback to savepoint A",
+		"release savepoint a",
+	} {
+		q := proto.Query{
+			Sql:        sql,
+			TabletType: topo.TYPE_MASTER,
+			Session:    session,
+		}
+		if _, err := router.Execute(context.Background(), &q); err != nil {
+			t.Fatalf("router.Execute(%s): %v", sql, err)
+		}
+	}
+	wantQueries := []string{
+		"update user set a = 2 where id = 1",
+		"savepoint a",
+		"savepoint b",
+		"rollback to savepoint A",
+		"release savepoint a",
+	}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %q, want %q", sbc1.Queries, wantQueries)
+	}
+	// sbc2 joined the transaction after the savepoints were created.
+	wantQueries = []string{
+		"savepoint `a`",
+		"savepoint `b`",
+		"update user set a = 2 where id = 3",
+		"rollback to savepoint A",
+		"release savepoint a",
+	}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %q, want %q", sbc2.Queries, wantQueries)
+	}
+	if len(session.Savepoints) != 0 {
+		t.Errorf("session.Savepoints: %v, want none", session.Savepoints)
+	}
+
+	q := proto.Query{
+		Sql:        "rollback to b",
+		TabletType: topo.TYPE_MASTER,
+		Session:    session,
+	}
+	_, err = router.Execute(context.Background(), &q)
+	want := "SAVEPOINT b does not exist"
+	if err == nil || err.Error() != want {
+		t.Errorf("router.Execute: %v, want %s", err, want)
+	}
+
+	// Outside of a transaction, savepoints are not created.
+	sbc1.Queries = nil
+	q = proto.Query{
+		Sql:        "savepoint a",
+		TabletType: topo.TYPE_MASTER,
+		Session:    &proto.Session{},
+	}
+	if _, err := router.Execute(context.Background(), &q); err != nil {
+		t.Error(err)
+	}
+	if sbc1.Queries != nil || q.Session.Savepoints != nil {
+		t.Errorf("savepoint outside of a transaction: queries %q, savepoints %v, want none", sbc1.Queries, q.Session.Savepoints)
+	}
+}
+
+func TestTransactionOptions(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	q := proto.Query{TabletType: topo.TYPE_MASTER}
+	for _, sql := range []string{
+		"set transaction isolation level read committed",
+		"start transaction read only",
+		"select * from user where id = 1",
+	} {
+		q.Sql = sql
+		if _, err := router.Execute(context.Background(), &q); err != nil {
+			t.Fatalf("router.Execute(%s): %v", sql, err)
+		}
+	}
+	if sbc1.BeginCount.Get() != 1 || sbc1.Isolation != "READ COMMITTED" || !sbc1.ReadOnly {
+		t.Errorf("Begin: %d, %q, %v, want 1, READ COMMITTED, true", sbc1.BeginCount.Get(), sbc1.Isolation, sbc1.ReadOnly)
+	}
+
+	q.Sql = "set transaction isolation level serializable"
+	_, err = router.Execute(context.Background(), &q)
+	want := "transaction characteristics can't be changed while a transaction is in progress"
+	if err == nil || err.Error() != want {
+		t.Errorf("router.Execute: %v, want %s", err, want)
+	}
+	q.Sql = "start transaction"
+	_, err = router.Execute(context.Background(), &q)
+	want = "cannot start a transaction while a transaction is in progress"
+	if err == nil || err.Error() != want {
+		t.Errorf("router.Execute: %v, want %s", err, want)
+	}
+
+	// The isolation level applies to the next transactions,
+	// but the access mode doesn't.
+	if err := scatterConn.Commit(context.Background(), NewSafeSession(q.Session)); err != nil {
+		t.Fatal(err)
+	}
+	q.Session.InTransaction = true
+	q.Sql = "select * from user where id = 1"
+	if _, err := router.Execute(context.Background(), &q); err != nil {
+		t.Fatal(err)
+	}
+	if sbc1.BeginCount.Get() != 2 || sbc1.Isolation != "READ COMMITTED" || sbc1.ReadOnly {
+		t.Errorf("Begin: %d, %q, %v, want 2, READ COMMITTED, false", sbc1.BeginCount.Get(), sbc1.Isolation, sbc1.ReadOnly)
+	}
+}
+
+func TestAutocommit(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	q := proto.Query{
+		Sql:        "update user set a=2 where id = 1",
+		TabletType: topo.TYPE_MASTER,
+		Session:    &proto.Session{Autocommit: true},
+	}
+	if _, err := router.Execute(context.Background(), &q); err != nil {
+		t.Fatal(err)
+	}
+	wantQueries := []string{
+		"begin",
+		"update user set a = 2 where id = 1",
+		"commit",
+	}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %q, want %q", sbc1.Queries, wantQueries)
+	}
+	if sbc1.ExecCount.Get() != 1 || sbc1.BeginCount.Get() != 0 || sbc1.CommitCount.Get() != 0 {
+		t.Errorf("exec, begin, commit: %d, %d, %d, want 1, 0, 0", sbc1.ExecCount.Get(), sbc1.BeginCount.Get(), sbc1.CommitCount.Get())
+	}
+	if q.Session.InTransaction || q.Session.ShardSessions != nil {
+		t.Errorf("session: %v, want not in a transaction", q.Session)
+	}
+
+	// In a transaction, autocommit doesn't apply.
+	sbc1.Queries = nil
+	q.Session.InTransaction = true
+	if _, err := router.Execute(context.Background(), &q); err != nil {
+		t.Fatal(err)
+	}
+	wantQueries = []string{"update user set a = 2 where id = 1"}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %q, want %q", sbc1.Queries, wantQueries)
+	}
+	if sbc1.BeginCount.Get() != 1 {
+		t.Errorf("sbc1.BeginCount: %d, want 1", sbc1.BeginCount.Get())
+	}
+}
+
+func TestDMLRoutingComment(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	*dmlRoutingComment = true
+	defer func() { *dmlRoutingComment = false }()
+	q := proto.Query{
+		Sql:        "update user set a=2 where id = 1",
+		TabletType: topo.TYPE_MASTER,
+	}
+	_, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Error(err)
+	}
+	wantBind := map[string]interface{}{
+		"keyspace_id": "\x16k@\xb4J\xbaK\xd6",
+	}
+	if !reflect.DeepEqual(sbc1.BindVars[0], wantBind) {
+		t.Errorf("sbc1.BindVars[0] = %#v, want %#v", sbc1.BindVars[0], wantBind)
+	}
+	wantQuery := "update user set a = 2 where id = 1 /* _routing keyspace_id:166b40b44aba4bd6 */"
+	if sbc1.Queries[0] != wantQuery {
+		t.Errorf("sbc1.Queries[0]: %q, want %q\n", sbc1.Queries[0], wantQuery)
+	}
+}
+
+func TestUpdateEqualChangedVindex(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("-20", sbc)
+
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	sbc.setResults([]*mproto.QueryResult{&mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"name", 253},
+		},
+		RowsAffected: 1,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.String("myname")},
+		}},
+	}})
+	q := proto.Query{
+		Sql:        "update user set name = 'newname' where id = 1",
+		TabletType: topo.TYPE_MASTER,
+	}
+	_, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []string{
+		"select name from user where id = 1 for update",
+		"update user set name = 'newname' where id = 1",
+	}
+	if !reflect.DeepEqual(sbc.Queries, wantQueries) {
+		t.Errorf("sbc.Queries: %q, want %q\n", sbc.Queries, wantQueries)
+	}
+	wantBinds := []map[string]interface{}{{
+		"user_id": int64(1),
+		"name":    []interface{}{"myname"},
+	}, {
+		"user_id": int64(1),
+		"name":    "newname",
+	}}
+	if !reflect.DeepEqual(sbclookup.BindVars, wantBinds) {
+		t.Errorf("sbclookup.BindVars = \n%#v, want \n%#v", sbclookup.BindVars, wantBinds)
+	}
+	wantQueries = []string{
+		"delete from name_user_map where name in ::name and user_id = :user_id",
+		"insert into name_user_map(name, user_id) values(:name, :user_id)",
+	}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %q, want %q\n", sbclookup.Queries, wantQueries)
+	}
+
+	// No rows, no vindex changes.
+	sbc.setResults([]*mproto.QueryResult{&mproto.QueryResult{}})
+	sbclookup.Queries = nil
+	_, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Error(err)
+	}
+	if sbclookup.Queries != nil {
+		t.Errorf("sbclookup.Queries: %q, want nil", sbclookup.Queries)
+	}
+}
+
+func TestUpdateMigrate(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	row := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"id", 3},
+			{"name", 253},
+		},
+		RowsAffected: 1,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("1")},
+			{sqltypes.String("myname")},
+		}},
+	}
+	sbc1.setResults([]*mproto.QueryResult{row, row})
+	q := proto.Query{
+		Sql:        "update user set id = 3 where id = 1",
+		TabletType: topo.TYPE_MASTER,
+	}
+	result, err := router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RowsAffected != 1 {
+		t.Errorf("RowsAffected: %d, want 1", result.RowsAffected)
+	}
+	wantQueries := []string{
+		"select * from user where id = 1 for update",
+		"select id, name from user where id = 1 for update",
+		"delete from user where id = 1",
+	}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %q, want %q\n", sbc1.Queries, wantQueries)
+	}
+	wantBind := map[string]interface{}{
+		"id":                 int64(3),
+		"name":               []byte("myname"),
+		"_id":                int64(3),
+		"_name":              []byte("myname"),
+		"keyspace_id":        "N\xb1\x90ɢ\xfa\x16\x9c",
+		"_routingKeyspaceId": "N\xb1\x90ɢ\xfa\x16\x9c",
+	}
+	if !reflect.DeepEqual(sbc2.BindVars, []map[string]interface{}{wantBind}) {
+		t.Errorf("sbc2.BindVars = %#v, want %#v", sbc2.BindVars, wantBind)
+	}
+	wantQueries = []string{
+		"insert into user(id, name) values (:_id, :_name)",
+	}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %q, want %q\n", sbc2.Queries, wantQueries)
+	}
+	wantQueries = []string{
+		"delete from user_idx where id in ::id",
+		"delete from name_user_map where name in ::name and user_id = :user_id",
+		"insert into user_idx(id) values(:id)",
+		"insert into name_user_map(name, user_id) values(:name, :user_id)",
+	}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %q, want %q\n", sbclookup.Queries, wantQueries)
+	}
+	if sbc1.CommitCount != 1 || sbc2.CommitCount != 1 || sbclookup.CommitCount != 1 {
+		t.Errorf("CommitCount: %v, %v, %v, want 1, 1, 1", sbc1.CommitCount, sbc2.CommitCount, sbclookup.CommitCount)
+	}
+
+	q.Sql = "update user set id = 3 where id in (1, 2)"
+	_, err = router.Execute(context.Background(), &q)
+	want := "primary index is changing in a multi-shard update"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("router.Execute: %v, want %s", err, want)
+	}
+}
+
+func TestDeleteEqual(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("-20", sbc)
+
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	sbc.setResults([]*mproto.QueryResult{&mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"id", 3},
+			{"name", 253},
+		},
+		RowsAffected: 1,
+		InsertId:     0,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("1")},
+			{sqltypes.String("myname")},
+		}},
+	}})
+	q := proto.Query{
+		Sql:        "delete from user where id = 1",
+		TabletType: topo.TYPE_MASTER,
+	}
+	_, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Error(err)
+	}
+	wantBinds := []map[string]interface{}{{}, {
+		"keyspace_id":        "\x16k@\xb4J\xbaK\xd6",
+		"_routingKeyspaceId": "\x16k@\xb4J\xbaK\xd6",
+	}}
+	if !reflect.DeepEqual(sbc.BindVars, wantBinds) {
+		t.Errorf("sbc.BindVars = %#v, want %#v", sbc.BindVars, wantBinds)
+	}
+	wantQueries := []string{
+		"select id, name from user where id = 1 for update",
+		"delete from user where id = 1",
+	}
+	if !reflect.DeepEqual(sbc.Queries, wantQueries) {
+		t.Errorf("sbc.Queries: %q, want %q\n", sbc.Queries, wantQueries)
+	}
+
+	wantBinds = []map[string]interface{}{{
+		"id": []interface{}{int64(1)},
+	}, {
+		"user_id": int64(1),
+		"name":    []interface{}{"myname"},
+	}}
+	if !reflect.DeepEqual(sbclookup.BindVars, wantBinds) {
+		t.Errorf("sbclookup.BindVars = \n%#v, want \n%#v", sbclookup.BindVars, wantBinds)
+	}
+	wantQueries = []string{
+		"delete from user_idx where id in ::id",
+		"delete from name_user_map where name in ::name and user_id = :user_id",
+	}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %q, want %q\n", sbclookup.Queries, wantQueries)
+	}
+}
+
+func TestDMLIn(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	q := proto.Query{
+		Sql:        "update user set a = 2 where id in (1, 3)",
+		TabletType: topo.TYPE_MASTER,
+	}
+	_, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []string{"update user set a = 2 where id in ::_vals"}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %q, want %q\n", sbc1.Queries, wantQueries)
+	}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %q, want %q\n", sbc2.Queries, wantQueries)
+	}
+	wantBinds := []map[string]interface{}{{
+		"_vals": []interface{}{int64(1)},
+	}}
+	if !reflect.DeepEqual(sbc1.BindVars, wantBinds) {
+		t.Errorf("sbc1.BindVars = %#v, want %#v", sbc1.BindVars, wantBinds)
+	}
+	wantBinds = []map[string]interface{}{{
+		"_vals": []interface{}{int64(3)},
+	}}
+	if !reflect.DeepEqual(sbc2.BindVars, wantBinds) {
+		t.Errorf("sbc2.BindVars = %#v, want %#v", sbc2.BindVars, wantBinds)
+	}
+	if sbc1.CommitCount != 1 || sbc2.CommitCount != 1 {
+		t.Errorf("CommitCount: %v, %v, want 1, 1", sbc1.CommitCount, sbc2.CommitCount)
+	}
+
+	sbc1.Queries = nil
+	sbc1.BindVars = nil
+	sbc2.Queries = nil
+	sbc1.setResults([]*mproto.QueryResult{&mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"id", 3},
+			{"name", 253},
+		},
+		RowsAffected: 1,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("1")},
+			{sqltypes.String("myname")},
+		}},
+	}})
+	sbc2.setResults([]*mproto.QueryResult{&mproto.QueryResult{}})
+	q.Sql = "delete from user where id in (1, 3)"
+	_, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries = []string{
+		"select id, name from user where id in ::_vals for update",
+		"delete from user where id in ::_vals",
+	}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %q, want %q\n", sbc1.Queries, wantQueries)
+	}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %q, want %q\n", sbc2.Queries, wantQueries)
+	}
+	wantQueries = []string{
+		"delete from user_idx where id in ::id",
+		"delete from name_user_map where name in ::name and user_id = :user_id",
+	}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %q, want %q\n", sbclookup.Queries, wantQueries)
+	}
+	if sbc1.CommitCount != 2 || sbc2.CommitCount != 2 || sbclookup.CommitCount != 1 {
+		t.Errorf("CommitCount: %v, %v, %v, want 2, 2, 1", sbc1.CommitCount, sbc2.CommitCount, sbclookup.CommitCount)
+	}
+}
+
+func TestDMLScatter(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	var conns []*sandboxConn
+	for _, shard := range shards {
+		sbc := &sandboxConn{}
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	q := proto.Query{
+		Sql:        "update user set a = 2",
+		TabletType: topo.TYPE_MASTER,
+	}
+	_, err = router.Execute(context.Background(), &q)
+	want := `query "update user set a = 2" needs to be sent to all shards, which is not allowed for this session`
+	if err == nil || err.Error() != want {
+		t.Errorf("router.Execute: %v, want %s", err, want)
+	}
+
+	q.Session = &proto.Session{AllowScatterDML: true}
+	result, err := router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RowsAffected != 8 {
+		t.Errorf("result.RowsAffected: %d, want 8", result.RowsAffected)
+	}
+	if q.Session.InTransaction || q.Session.ShardSessions != nil {
+		t.Errorf("q.Session: %+v, want no transaction", q.Session)
+	}
+	for _, conn := range conns {
+		if conn.Queries[0] != q.Sql {
+			t.Errorf("conn.Queries[0]: %q, want %q\n", conn.Queries[0], q.Sql)
+		}
+		if conn.BeginCount.Get() != 1 || conn.CommitCount.Get() != 1 {
+			t.Errorf("begin, commit: %d, %d, want 1, 1", conn.BeginCount.Get(), conn.CommitCount.Get())
+		}
+	}
+
+	router.maxScatterDMLRows = 5
+	q.Sql = "delete from user_extra"
+	_, err = router.Execute(context.Background(), &q)
+	want = `query "delete from user_extra" affected 8 rows, which exceeds the limit of 5: transaction rolled back`
+	if err == nil || err.Error() != want {
+		t.Errorf("router.Execute: %v, want %s", err, want)
+	}
+	for _, conn := range conns {
+		if conn.RollbackCount.Get() != 1 || conn.CommitCount.Get() != 1 {
+			t.Errorf("commit, rollback: %d, %d, want 1, 1", conn.CommitCount.Get(), conn.RollbackCount.Get())
+		}
+	}
+}
+
+func TestInsertSharded(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	q := proto.Query{
+		Sql:        "insert into user(id, v, name) values (1, 2, 'myname')",
+		TabletType: topo.TYPE_MASTER,
+	}
+	_, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Error(err)
+	}
+	wantBind := map[string]interface{}{
+		"id": int64(1),
+	}
+	if !reflect.DeepEqual(sbclookup.BindVars[0], wantBind) {
+		t.Errorf("sbclookup.BindVars[0] = %#v, want %#v", sbclookup.BindVars[0], wantBind)
+	}
+	wantQuery := "insert into user_idx(id) values(:id)"
+	if sbclookup.Queries[0] != wantQuery {
+		t.Errorf("sbclookup.Queries[0]: %q, want %q\n", sbclookup.Queries[0], wantQuery)
+	}
+	wantBind = map[string]interface{}{
+		"keyspace_id":        "\x16k@\xb4J\xbaK\xd6",
+		"_routingKeyspaceId": "\x16k@\xb4J\xbaK\xd6",
+		"_id":                int64(1),
+		"_name":              "myname",
+	}
+	if !reflect.DeepEqual(sbc1.BindVars[0], wantBind) {
+		t.Errorf("sbc1.BindVars[0] = %#v, want %#v", sbc1.BindVars[0], wantBind)
+	}
+	wantQuery = "insert into user(id, v, name) values (:_id, 2, :_name)"
+	if sbc1.Queries[0] != wantQuery {
+		t.Errorf("sbc1.Queries[0]: %q, want %q\n", sbc1.Queries[0], wantQuery)
+	}
+	if sbc2.ExecCount != 0 {
+		t.Errorf("sbc2.ExecCount: %v, want 0\n", sbc2.ExecCount)
+	}
+
+	q.Sql = "insert into user(id, v, name) values (3, 2, 'myname2')"
+	sbclookup.BindVars = nil
+	sbclookup.Queries = nil
+	_, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Error(err)
+	}
+	if sbc1.ExecCount != 1 {
+		t.Errorf("sbc1.ExecCount: %v, want 1\n", sbc1.ExecCount)
+	}
+	wantBind = map[string]interface{}{
+		"id": int64(3),
+	}
+	if !reflect.DeepEqual(sbclookup.BindVars[0], wantBind) {
+		t.Errorf("sbclookup.BindVars[0] = %#v, want %#v", sbclookup.BindVars[0], wantBind)
+	}
+	wantQuery = "insert into user_idx(id) values(:id)"
+	if sbclookup.Queries[0] != wantQuery {
+		t.Errorf("sbclookup.Queries[0]: %q, want %q\n", sbclookup.Queries[0], wantQuery)
+	}
+	wantBind = map[string]interface{}{
+		"keyspace_id":        "N\xb1\x90ɢ\xfa\x16\x9c",
+		"_routingKeyspaceId": "N\xb1\x90ɢ\xfa\x16\x9c",
+		"_id":                int64(3),
+		"_name":              "myname2",
+	}
+	if !reflect.DeepEqual(sbc2.BindVars[0], wantBind) {
+		t.Errorf("sbc2.BindVars[0] = %#v, want %#v", sbc2.BindVars[0], wantBind)
+	}
+	wantQuery = "insert into user(id, v, name) values (:_id, 2, :_name)"
+	if sbc2.Queries[0] != wantQuery {
+		t.Errorf("sbc2.Queries[0]: %q, want %q\n", sbc2.Queries[0], wantQuery)
+	}
+}
+
+func TestInsertGenerator(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("80-a0", sbc)
+
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	q := proto.Query{
+		Sql:        "insert into user(v, name) values (2, 'myname')",
+		TabletType: topo.TYPE_MASTER,
+	}
+	_, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Error(err)
+	}
+	wantBind := map[string]interface{}{
+		"id": nil,
+	}
+	if !reflect.DeepEqual(sbclookup.BindVars[0], wantBind) {
+		t.Errorf("sbclookup.BindVars[0] = %#v, want %#v", sbclookup.BindVars[0], wantBind)
+	}
+	wantQuery := "insert into user_idx(id) values(:id)"
+	if sbclookup.Queries[0] != wantQuery {
+		t.Errorf("sbclookup.Queries[0]: %q, want %q\n", sbclookup.Queries[0], wantQuery)
+	}
+	wantBind = map[string]interface{}{
+		"keyspace_id":        "\x8c\xa6M\xe9\xc1\xb1#\xa7",
+		"_routingKeyspaceId": "\x8c\xa6M\xe9\xc1\xb1#\xa7",
+		"_id":                int64(0),
+		"_name":              "myname",
+	}
+	if !reflect.DeepEqual(sbc.BindVars[0], wantBind) {
+		t.Errorf("sbc.BindVars[0] = %#v, want %#v", sbc.BindVars[0], wantBind)
+	}
+	wantQuery = "insert into user(v, name, id) values (2, :_name, :_id)"
+	if sbc.Queries[0] != wantQuery {
+		t.Errorf("sbc.Queries[0]: %q, want %q\n", sbc.Queries[0], wantQuery)
+	}
+}
+
+func TestInsertReturnValues(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("-20", sbc)
+
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	sbclookup.setResults([]*mproto.QueryResult{&mproto.QueryResult{RowsAffected: 1, InsertId: 1}})
+	sbc.setResults([]*mproto.QueryResult{&mproto.QueryResult{RowsAffected: 1}})
+	q := proto.Query{
+		Sql:        "insert into user(v, name) values (2, 'myname')",
+		TabletType: topo.TYPE_MASTER,
+		Session:    &proto.Session{ReturnInsertValues: true},
+	}
+	result, err := router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantResult := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"id", mproto.VT_LONGLONG},
+			{"name", mproto.VT_VAR_STRING},
+			{"keyspace_id", mproto.VT_VAR_STRING},
+		},
+		RowsAffected: 1,
+		InsertId:     1,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("1")},
+			{sqltypes.String("myname")},
+			{sqltypes.String("\x16k@\xb4J\xbaK\xd6")},
+		}},
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+
+	sbc.setResults([]*mproto.QueryResult{&mproto.QueryResult{RowsAffected: 1}})
+	q.Sql = "insert into user(id, name) values (1, 'myname')"
+	q.Session = nil
+	result, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Fields != nil || result.Rows != nil {
+		t.Errorf("result: %+v, want no rows", result)
+	}
+}
+
+func TestInsertLookupOwned(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("-20", sbc)
+
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	q := proto.Query{
+		Sql:        "insert into music(user_id, id) values (2, 3)",
+		TabletType: topo.TYPE_MASTER,
+	}
+	_, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Error(err)
+	}
+	wantBind := map[string]interface{}{
+		"music_id": int64(3),
+		"user_id":  int64(2),
+	}
+	if !reflect.DeepEqual(sbclookup.BindVars[0], wantBind) {
+		t.Errorf("sbclookup.BindVars[0] = %#v, want %#v", sbclookup.BindVars[0], wantBind)
+	}
+	wantQuery := "insert into music_user_map(music_id, user_id) values(:music_id, :user_id)"
+	if sbclookup.Queries[0] != wantQuery {
+		t.Errorf("sbclookup.Queries[0]: %q, want %q\n", sbclookup.Queries[0], wantQuery)
+	}
+	wantBind = map[string]interface{}{
+		"keyspace_id":        "\x06\xe7\xea\"Βp\x8f",
+		"_routingKeyspaceId": "\x06\xe7\xea\"Βp\x8f",
+		"_user_id":           int64(2),
+		"_id":                int64(3),
+	}
+	if !reflect.DeepEqual(sbc.BindVars[0], wantBind) {
+		t.Errorf("sbc.BindVars[0] = %#v, want %#v", sbc.BindVars[0], wantBind)
+	}
+	wantQuery = "insert into music(user_id, id) values (:_user_id, :_id)"
+	if sbc.Queries[0] != wantQuery {
+		t.Errorf("sbc.Queries[0]: %q, want %q\n", sbc.Queries[0], wantQuery)
+	}
+}
+
+func TestInsertSelect(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	sbc1.setResults([]*mproto.QueryResult{&mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"id", 3},
+			{"name", 253},
+		},
+		RowsAffected: 3,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("1")},
+			{sqltypes.String("a")},
+		}, {
+			{sqltypes.Numeric("3")},
+			{sqltypes.String("b")},
+		}, {
+			{sqltypes.Numeric("1")},
+			{sqltypes.String("c")},
+		}},
+	}})
+	q := proto.Query{
+		Sql:        "insert into user(id, name) select user_id, name from user_extra where user_id = 1",
+		TabletType: topo.TYPE_MASTER,
+	}
+	result, err := router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RowsAffected != 3 {
+		t.Errorf("RowsAffected: %d, want 3", result.RowsAffected)
+	}
+	wantQueries := []string{
+		"select user_id, name from user_extra where user_id = 1",
+		"insert into user(id, name) values (:_id, :_name)",
+		"insert into user(id, name) values (:_id, :_name)",
+	}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %q, want %q\n", sbc1.Queries, wantQueries)
+	}
+	wantBinds := []map[string]interface{}{{}, {
+		"_0":                 int64(1),
+		"_1":                 []byte("a"),
+		"_id":                int64(1),
+		"_name":              []byte("a"),
+		"keyspace_id":        "\x16k@\xb4J\xbaK\xd6",
+		"_routingKeyspaceId": "\x16k@\xb4J\xbaK\xd6",
+	}, {
+		"_0":                 int64(1),
+		"_1":                 []byte("c"),
+		"_id":                int64(1),
+		"_name":              []byte("c"),
+		"keyspace_id":        "\x16k@\xb4J\xbaK\xd6",
+		"_routingKeyspaceId": "\x16k@\xb4J\xbaK\xd6",
+	}}
+	if !reflect.DeepEqual(sbc1.BindVars, wantBinds) {
+		t.Errorf("sbc1.BindVars = %#v, want %#v", sbc1.BindVars, wantBinds)
+	}
+	wantQueries = []string{
+		"insert into user(id, name) values (:_id, :_name)",
+	}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %q, want %q\n", sbc2.Queries, wantQueries)
+	}
+	if sbc1.ExecCount != 2 || sbc2.ExecCount != 1 {
+		t.Errorf("ExecCount: %v, %v, want 2, 1", sbc1.ExecCount, sbc2.ExecCount)
+	}
+	if len(sbclookup.Queries) != 6 {
+		t.Errorf("sbclookup.Queries: %q, want 6 inserts", sbclookup.Queries)
+	}
+
+	q.Sql = "insert into user(id, name) select user_id from user_extra"
+	_, err = router.Execute(context.Background(), &q)
+	want := "column list doesn't match values"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("router.Execute: %v, want %s", err, want)
+	}
+}
+
+func TestInsertSelectBatchSize(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("-20", sbc)
+
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	sbc.setResults([]*mproto.QueryResult{&mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"id", 3},
+			{"name", 253},
+		},
+		RowsAffected: 3,
+		Rows: [][]sqltypes.Value{
+			{{sqltypes.Numeric("1")}, {sqltypes.String("a")}},
+			{{sqltypes.Numeric("1")}, {sqltypes.String("b")}},
+			{{sqltypes.Numeric("1")}, {sqltypes.String("c")}},
+		},
+	}})
+	defer func(saved int) { *insertSelectBatchSize = saved }(*insertSelectBatchSize)
+	*insertSelectBatchSize = 2
+	q := proto.Query{
+		Sql:        "insert into user(id, name) select user_id, name from user_extra where user_id = 1",
+		TabletType: topo.TYPE_MASTER,
+	}
+	result, err := router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RowsAffected != 3 {
+		t.Errorf("RowsAffected: %d, want 3", result.RowsAffected)
+	}
+	// One select, and two batches of inserts.
+	if sbc.ExecCount != 3 {
+		t.Errorf("sbc.ExecCount: %v, want 3", sbc.ExecCount)
+	}
+	if len(sbc.Queries) != 4 {
+		t.Errorf("sbc.Queries: %q, want 4 queries", sbc.Queries)
+	}
+}
+
+func TestBulkInsert(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	req := &proto.BulkInsertRequest{
+		Table:      "user",
+		Columns:    []string{"id", "name"},
+		Rows:       [][]interface{}{{1, "a"}, {3, "b"}, {1, "c"}},
+		TabletType: topo.TYPE_MASTER,
+	}
+	result, err := router.BulkInsert(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RowsAffected != 3 {
+		t.Errorf("RowsAffected: %d, want 3", result.RowsAffected)
+	}
+	wantQueries := []string{
+		"insert into user(id, name) values (:_id, :_name)",
+		"insert into user(id, name) values (:_id, :_name)",
+	}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %q, want %q\n", sbc1.Queries, wantQueries)
+	}
+	wantQueries = []string{
+		"insert into user(id, name) values (:_id, :_name)",
+	}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %q, want %q\n", sbc2.Queries, wantQueries)
+	}
+	if sbc1.ExecCount != 1 || sbc2.ExecCount != 1 {
+		t.Errorf("ExecCount: %v, %v, want 1, 1", sbc1.ExecCount, sbc2.ExecCount)
 	}
 
-	q.Sql = "insert into user(id, v, name) values (3, 2, 'myname2')"
-	sbclookup.BindVars = nil
 	sbclookup.Queries = nil
+	sbclookup.BindVars = nil
+	req = &proto.BulkInsertRequest{
+		Table:      "music_user_map",
+		Columns:    []string{"music_id", "user_id"},
+		Rows:       [][]interface{}{{1, 2}, {3, 4}},
+		TabletType: topo.TYPE_MASTER,
+	}
+	_, err = router.BulkInsert(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantQueries = []string{
+		"insert into music_user_map(music_id, user_id) values (:_0, :_1)",
+		"insert into music_user_map(music_id, user_id) values (:_0, :_1)",
+	}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %q, want %q\n", sbclookup.Queries, wantQueries)
+	}
+	wantBinds := []map[string]interface{}{{
+		"_0": 1,
+		"_1": 2,
+	}, {
+		"_0": 3,
+		"_1": 4,
+	}}
+	if !reflect.DeepEqual(sbclookup.BindVars, wantBinds) {
+		t.Errorf("sbclookup.BindVars: %+v, want %+v\n", sbclookup.BindVars, wantBinds)
+	}
+
+	testcases := []struct {
+		req  *proto.BulkInsertRequest
+		want string
+	}{{
+		req:  &proto.BulkInsertRequest{Table: "nouser", Columns: []string{"id"}},
+		want: `cannot bulk insert into nouser: table nouser not found`,
+	}, {
+		req:  &proto.BulkInsertRequest{Table: "user", Columns: []string{"id", "name)"}},
+		want: `cannot bulk insert into user: invalid column name "name)"`,
+	}, {
+		req:  &proto.BulkInsertRequest{Table: "user"},
+		want: `cannot bulk insert into user: no column list`,
+	}, {
+		req:  &proto.BulkInsertRequest{Table: "user", Columns: []string{"id"}, Rows: [][]interface{}{{1, 2}}},
+		want: `row 0 has 2 values, want 1`,
+	}}
+	for _, tcase := range testcases {
+		_, err := router.BulkInsert(context.Background(), tcase.req)
+		if err == nil || err.Error() != tcase.want {
+			t.Errorf("BulkInsert(%+v): %v, want %s", tcase.req, err, tcase.want)
+		}
+	}
+}
+
+func TestInsertLookupOwnedGenerator(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("-20", sbc)
+
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	q := proto.Query{
+		Sql:        "insert into music(user_id) values (2)",
+		TabletType: topo.TYPE_MASTER,
+	}
 	_, err = router.Execute(context.Background(), &q)
 	if err != nil {
 		t.Error(err)
 	}
-	if sbc1.ExecCount != 1 {
-		t.Errorf("sbc1.ExecCount: %v, want 1\n", sbc1.ExecCount)
+	wantBind := map[string]interface{}{
+		"music_id": nil,
+		"user_id":  int64(2),
+	}
+	if !reflect.DeepEqual(sbclookup.BindVars[0], wantBind) {
+		t.Errorf("sbclookup.BindVars[0] = %#v, want %#v", sbclookup.BindVars[0], wantBind)
+	}
+	wantQuery := "insert into music_user_map(music_id, user_id) values(:music_id, :user_id)"
+	if sbclookup.Queries[0] != wantQuery {
+		t.Errorf("sbclookup.Queries[0]: %q, want %q\n", sbclookup.Queries[0], wantQuery)
+	}
+	wantBind = map[string]interface{}{
+		"keyspace_id":        "\x06\xe7\xea\"Βp\x8f",
+		"_routingKeyspaceId": "\x06\xe7\xea\"Βp\x8f",
+		"_user_id":           int64(2),
+		"_id":                int64(0),
+	}
+	if !reflect.DeepEqual(sbc.BindVars[0], wantBind) {
+		t.Errorf("sbc.BindVars[0] = %#v, want %#v", sbc.BindVars[0], wantBind)
+	}
+	wantQuery = "insert into music(user_id, id) values (:_user_id, :_id)"
+	if sbc.Queries[0] != wantQuery {
+		t.Errorf("sbc.Queries[0]: %q, want %q\n", sbc.Queries[0], wantQuery)
+	}
+}
+
+func TestInsertConsistentLookup(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("-20", sbc)
+
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	// Outside a transaction, the insert gets one of its own.
+	q := proto.Query{
+		Sql:        "insert into orders(user_id, id) values (1, 5)",
+		TabletType: topo.TYPE_MASTER,
+	}
+	_, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []string{"insert into order_user_map(order_id, user_id) values(:order_id, :user_id)"}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %q, want %q\n", sbclookup.Queries, wantQueries)
+	}
+	for _, conn := range []*sandboxConn{sbc, sbclookup} {
+		if conn.BeginCount != 1 || conn.CommitCount != 1 {
+			t.Errorf("BeginCount, CommitCount: %v, %v, want 1, 1", conn.BeginCount, conn.CommitCount)
+		}
+	}
+	if q.Session.InTransaction {
+		t.Errorf("Session.InTransaction: true, want false")
+	}
+
+	// If the insert fails, the transaction is rolled back.
+	sbc.mustFailServer = 1
+	sbclookup.Queries = nil
+	_, err = router.Execute(context.Background(), &q)
+	if err == nil {
+		t.Errorf("Execute: nil, want error")
+	}
+	if sbclookup.RollbackCount != 1 || sbclookup.CommitCount != 1 {
+		t.Errorf("RollbackCount, CommitCount: %v, %v, want 1, 1", sbclookup.RollbackCount, sbclookup.CommitCount)
+	}
+
+	// In a transaction, the lookup entry is deleted if the insert fails.
+	sbc.mustFailServer = 1
+	sbclookup.Queries = nil
+	q.Session = &proto.Session{InTransaction: true}
+	_, err = router.Execute(context.Background(), &q)
+	if err == nil {
+		t.Errorf("Execute: nil, want error")
+	}
+	wantQueries = []string{
+		"insert into order_user_map(order_id, user_id) values(:order_id, :user_id)",
+		"delete from order_user_map where order_id in ::order_id and user_id = :user_id",
+	}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %q, want %q\n", sbclookup.Queries, wantQueries)
+	}
+	if !q.Session.InTransaction || sbclookup.RollbackCount != 1 {
+		t.Errorf("the transaction of the session was ended")
+	}
+}
+
+func TestDeleteConsistentLookup(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("-20", sbc)
+
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	ownedResult := &mproto.QueryResult{
+		Fields:       []mproto.Field{{"id", 3}},
+		RowsAffected: 1,
+		Rows:         [][]sqltypes.Value{{{sqltypes.Numeric("5")}}},
+	}
+	// In a transaction, the lookup entry is recreated if the delete fails.
+	sbc.setResults([]*mproto.QueryResult{ownedResult})
+	sbc.onConnUse = func(conn *sandboxConn) {
+		if len(conn.Queries) != 0 && strings.HasPrefix(conn.Queries[len(conn.Queries)-1], "delete") {
+			conn.mustFailServer = 1
+		}
 	}
-	wantBind = map[string]interface{}{
-		"id": int64(3),
+	q := proto.Query{
+		Sql:        "delete from orders where user_id = 1",
+		TabletType: topo.TYPE_MASTER,
+		Session:    &proto.Session{InTransaction: true},
 	}
-	if !reflect.DeepEqual(sbclookup.BindVars[0], wantBind) {
-		t.Errorf("sbclookup.BindVars[0] = %#v, want %#v", sbclookup.BindVars[0], wantBind)
+	_, err = router.Execute(context.Background(), &q)
+	if err == nil {
+		t.Errorf("Execute: nil, want error")
 	}
-	wantQuery = "insert into user_idx(id) values(:id)"
-	if sbclookup.Queries[0] != wantQuery {
-		t.Errorf("sbclookup.Queries[0]: %q, want %q\n", sbclookup.Queries[0], wantQuery)
+	wantQueries := []string{
+		"delete from order_user_map where order_id in ::order_id and user_id = :user_id",
+		"insert into order_user_map(order_id, user_id) values(:order_id, :user_id)",
 	}
-	wantBind = map[string]interface{}{
-		"keyspace_id": "N\xb1\x90ɢ\xfa\x16\x9c",
-		"_id":         int64(3),
-		"_name":       "myname2",
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %q, want %q\n", sbclookup.Queries, wantQueries)
 	}
-	if !reflect.DeepEqual(sbc2.BindVars[0], wantBind) {
-		t.Errorf("sbc2.BindVars[0] = %#v, want %#v", sbc2.BindVars[0], wantBind)
+	wantBind := map[string]interface{}{
+		"order_id": int64(5),
+		"user_id":  int64(1),
 	}
-	wantQuery = "insert into user(id, v, name) values (:_id, 2, :_name) /* _routing keyspace_id:4eb190c9a2fa169c */"
-	if sbc2.Queries[0] != wantQuery {
-		t.Errorf("sbc2.Queries[0]: %q, want %q\n", sbc2.Queries[0], wantQuery)
+	if !reflect.DeepEqual(sbclookup.BindVars[1], wantBind) {
+		t.Errorf("sbclookup.BindVars[1] = %#v, want %#v", sbclookup.BindVars[1], wantBind)
 	}
 }
 
-func TestInsertGenerator(t *testing.T) {
+func TestInsertLookupUnowned(t *testing.T) {
 	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
 	if err != nil {
 		t.Fatal(err)
 	}
 	s := createSandbox("TestRouter")
 	sbc := &sandboxConn{}
-	s.MapTestConn("80-a0", sbc)
+	s.MapTestConn("-20", sbc)
 
 	l := createSandbox("TestUnsharded")
 	sbclookup := &sandboxConn{}
@@ -602,7 +2551,7 @@ func TestInsertGenerator(t *testing.T) {
 	router := NewRouter(serv, "aa", schema, "", scatterConn)
 
 	q := proto.Query{
-		Sql:        "insert into user(v, name) values (2, 'myname')",
+		Sql:        "insert into music_extra(user_id, music_id) values (2, 3)",
 		TabletType: topo.TYPE_MASTER,
 	}
 	_, err = router.Execute(context.Background(), &q)
@@ -610,30 +2559,32 @@ func TestInsertGenerator(t *testing.T) {
 		t.Error(err)
 	}
 	wantBind := map[string]interface{}{
-		"id": nil,
+		"music_id": int64(3),
+		"user_id":  int64(2),
 	}
 	if !reflect.DeepEqual(sbclookup.BindVars[0], wantBind) {
 		t.Errorf("sbclookup.BindVars[0] = %#v, want %#v", sbclookup.BindVars[0], wantBind)
 	}
-	wantQuery := "insert into user_idx(id) values(:id)"
+	wantQuery := "select music_id from music_user_map where music_id = :music_id and user_id = :user_id"
 	if sbclookup.Queries[0] != wantQuery {
 		t.Errorf("sbclookup.Queries[0]: %q, want %q\n", sbclookup.Queries[0], wantQuery)
 	}
 	wantBind = map[string]interface{}{
-		"keyspace_id": "\x8c\xa6M\xe9\xc1\xb1#\xa7",
-		"_id":         int64(0),
-		"_name":       "myname",
+		"keyspace_id":        "\x06\xe7\xea\"Βp\x8f",
+		"_routingKeyspaceId": "\x06\xe7\xea\"Βp\x8f",
+		"_user_id":           int64(2),
+		"_music_id":          int64(3),
 	}
 	if !reflect.DeepEqual(sbc.BindVars[0], wantBind) {
 		t.Errorf("sbc.BindVars[0] = %#v, want %#v", sbc.BindVars[0], wantBind)
 	}
-	wantQuery = "insert into user(v, name, id) values (2, :_name, :_id) /* _routing keyspace_id:8ca64de9c1b123a7 */"
+	wantQuery = "insert into music_extra(user_id, music_id) values (:_user_id, :_music_id)"
 	if sbc.Queries[0] != wantQuery {
 		t.Errorf("sbc.Queries[0]: %q, want %q\n", sbc.Queries[0], wantQuery)
 	}
 }
 
-func TestInsertLookupOwned(t *testing.T) {
+func TestInsertLookupUnownedUnsupplied(t *testing.T) {
 	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
 	if err != nil {
 		t.Fatal(err)
@@ -651,7 +2602,7 @@ func TestInsertLookupOwned(t *testing.T) {
 	router := NewRouter(serv, "aa", schema, "", scatterConn)
 
 	q := proto.Query{
-		Sql:        "insert into music(user_id, id) values (2, 3)",
+		Sql:        "insert into music_extra_reversed(music_id) values (3)",
 		TabletType: topo.TYPE_MASTER,
 	}
 	_, err = router.Execute(context.Background(), &q)
@@ -660,37 +2611,39 @@ func TestInsertLookupOwned(t *testing.T) {
 	}
 	wantBind := map[string]interface{}{
 		"music_id": int64(3),
-		"user_id":  int64(2),
 	}
 	if !reflect.DeepEqual(sbclookup.BindVars[0], wantBind) {
 		t.Errorf("sbclookup.BindVars[0] = %#v, want %#v", sbclookup.BindVars[0], wantBind)
 	}
-	wantQuery := "insert into music_user_map(music_id, user_id) values(:music_id, :user_id)"
+	wantQuery := "select user_id from music_user_map where music_id = :music_id"
 	if sbclookup.Queries[0] != wantQuery {
 		t.Errorf("sbclookup.Queries[0]: %q, want %q\n", sbclookup.Queries[0], wantQuery)
 	}
 	wantBind = map[string]interface{}{
-		"keyspace_id": "\x06\xe7\xea\"Βp\x8f",
-		"_user_id":    int64(2),
-		"_id":         int64(3),
+		"keyspace_id":        "\x16k@\xb4J\xbaK\xd6",
+		"_routingKeyspaceId": "\x16k@\xb4J\xbaK\xd6",
+		"_user_id":           int64(1),
+		"_music_id":          int64(3),
 	}
 	if !reflect.DeepEqual(sbc.BindVars[0], wantBind) {
 		t.Errorf("sbc.BindVars[0] = %#v, want %#v", sbc.BindVars[0], wantBind)
 	}
-	wantQuery = "insert into music(user_id, id) values (:_user_id, :_id) /* _routing keyspace_id:06e7ea22ce92708f */"
+	wantQuery = "insert into music_extra_reversed(music_id, user_id) values (:_music_id, :_user_id)"
 	if sbc.Queries[0] != wantQuery {
 		t.Errorf("sbc.Queries[0]: %q, want %q\n", sbc.Queries[0], wantQuery)
 	}
 }
 
-func TestInsertLookupOwnedGenerator(t *testing.T) {
+func TestStreamSelectEqual(t *testing.T) {
 	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
 	if err != nil {
 		t.Fatal(err)
 	}
 	s := createSandbox("TestRouter")
-	sbc := &sandboxConn{}
-	s.MapTestConn("-20", sbc)
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
 
 	l := createSandbox("TestUnsharded")
 	sbclookup := &sandboxConn{}
@@ -699,137 +2652,228 @@ func TestInsertLookupOwnedGenerator(t *testing.T) {
 	serv := new(sandboxTopo)
 	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
 	router := NewRouter(serv, "aa", schema, "", scatterConn)
-
 	q := proto.Query{
-		Sql:        "insert into music(user_id) values (2)",
+		Sql:        "select * from user where id = 1",
 		TabletType: topo.TYPE_MASTER,
 	}
-	_, err = router.Execute(context.Background(), &q)
+	result, err := routerStream(router, &q)
 	if err != nil {
 		t.Error(err)
 	}
-	wantBind := map[string]interface{}{
-		"music_id": nil,
-		"user_id":  int64(2),
+	if !reflect.DeepEqual(result, singleRowResult) {
+		t.Errorf("result: %+v, want %+v", result, singleRowResult)
 	}
-	if !reflect.DeepEqual(sbclookup.BindVars[0], wantBind) {
-		t.Errorf("sbclookup.BindVars[0] = %#v, want %#v", sbclookup.BindVars[0], wantBind)
+	wantQuery := "select * from user where id = 1"
+	if sbc1.Queries[0] != wantQuery {
+		t.Errorf("sbc1.Queries[0]: %q, want %q\n", sbc1.Queries[0], wantQuery)
 	}
-	wantQuery := "insert into music_user_map(music_id, user_id) values(:music_id, :user_id)"
-	if sbclookup.Queries[0] != wantQuery {
-		t.Errorf("sbclookup.Queries[0]: %q, want %q\n", sbclookup.Queries[0], wantQuery)
+	if sbc2.ExecCount != 0 {
+		t.Errorf("sbc2.ExecCount: %v, want 0\n", sbc2.ExecCount)
 	}
-	wantBind = map[string]interface{}{
-		"keyspace_id": "\x06\xe7\xea\"Βp\x8f",
-		"_user_id":    int64(2),
-		"_id":         int64(0),
+
+	q.Sql = "select * from user where name = 'foo'"
+	sbc1.Queries = nil
+	_, err = routerStream(router, &q)
+	if err != nil {
+		t.Error(err)
 	}
-	if !reflect.DeepEqual(sbc.BindVars[0], wantBind) {
-		t.Errorf("sbc.BindVars[0] = %#v, want %#v", sbc.BindVars[0], wantBind)
+	wantQuery = "select * from user where name = 'foo'"
+	if sbc1.Queries[0] != wantQuery {
+		t.Errorf("sbc1.Queries[0]: %q, want %q\n", sbc1.Queries[0], wantQuery)
 	}
-	wantQuery = "insert into music(user_id, id) values (:_user_id, :_id) /* _routing keyspace_id:06e7ea22ce92708f */"
-	if sbc.Queries[0] != wantQuery {
-		t.Errorf("sbc.Queries[0]: %q, want %q\n", sbc.Queries[0], wantQuery)
+	wantQueries := []string{
+		"select user_id from name_user_map where name = :name",
+	}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %q, want %q\n", sbclookup.Queries, wantQueries)
 	}
 }
 
-func TestInsertLookupUnowned(t *testing.T) {
+func TestStreamSelectIN(t *testing.T) {
 	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
 	if err != nil {
 		t.Fatal(err)
 	}
 	s := createSandbox("TestRouter")
-	sbc := &sandboxConn{}
-	s.MapTestConn("-20", sbc)
-
-	l := createSandbox("TestUnsharded")
-	sbclookup := &sandboxConn{}
-	l.MapTestConn("0", sbclookup)
-
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
 	serv := new(sandboxTopo)
 	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
 	router := NewRouter(serv, "aa", schema, "", scatterConn)
-
 	q := proto.Query{
-		Sql:        "insert into music_extra(user_id, music_id) values (2, 3)",
+		Sql:        "select * from user where id in (1, 3)",
 		TabletType: topo.TYPE_MASTER,
 	}
-	_, err = router.Execute(context.Background(), &q)
+	result, err := routerStream(router, &q)
 	if err != nil {
 		t.Error(err)
 	}
+	if len(result.Rows) != 2 {
+		t.Errorf("len(result.Rows): %d, want 2", len(result.Rows))
+	}
+	wantQuery := "select * from user where id in ::_vals"
 	wantBind := map[string]interface{}{
-		"music_id": int64(3),
-		"user_id":  int64(2),
+		"_vals": []interface{}{int64(1)},
 	}
-	if !reflect.DeepEqual(sbclookup.BindVars[0], wantBind) {
-		t.Errorf("sbclookup.BindVars[0] = %#v, want %#v", sbclookup.BindVars[0], wantBind)
+	if !reflect.DeepEqual(sbc1.BindVars[0], wantBind) {
+		t.Errorf("sbc1.BindVars[0] = %#v, want %#v", sbc1.BindVars[0], wantBind)
 	}
-	wantQuery := "select music_id from music_user_map where music_id = :music_id and user_id = :user_id"
-	if sbclookup.Queries[0] != wantQuery {
-		t.Errorf("sbclookup.Queries[0]: %q, want %q\n", sbclookup.Queries[0], wantQuery)
+	if sbc1.Queries[0] != wantQuery {
+		t.Errorf("sbc1.Queries[0]: %q, want %q\n", sbc1.Queries[0], wantQuery)
 	}
 	wantBind = map[string]interface{}{
-		"keyspace_id": "\x06\xe7\xea\"Βp\x8f",
-		"_user_id":    int64(2),
-		"_music_id":   int64(3),
+		"_vals": []interface{}{int64(3)},
 	}
-	if !reflect.DeepEqual(sbc.BindVars[0], wantBind) {
-		t.Errorf("sbc.BindVars[0] = %#v, want %#v", sbc.BindVars[0], wantBind)
+	if !reflect.DeepEqual(sbc2.BindVars[0], wantBind) {
+		t.Errorf("sbc2.BindVars[0] = %#v, want %#v", sbc2.BindVars[0], wantBind)
 	}
-	wantQuery = "insert into music_extra(user_id, music_id) values (:_user_id, :_music_id) /* _routing keyspace_id:06e7ea22ce92708f */"
-	if sbc.Queries[0] != wantQuery {
-		t.Errorf("sbc.Queries[0]: %q, want %q\n", sbc.Queries[0], wantQuery)
+	if sbc2.Queries[0] != wantQuery {
+		t.Errorf("sbc2.Queries[0]: %q, want %q\n", sbc2.Queries[0], wantQuery)
 	}
 }
 
-func TestInsertLookupUnownedUnsupplied(t *testing.T) {
+func TestStreamSelectScatter(t *testing.T) {
 	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
 	if err != nil {
 		t.Fatal(err)
 	}
 	s := createSandbox("TestRouter")
-	sbc := &sandboxConn{}
-	s.MapTestConn("-20", sbc)
-
-	l := createSandbox("TestUnsharded")
-	sbclookup := &sandboxConn{}
-	l.MapTestConn("0", sbclookup)
-
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	var conns []*sandboxConn
+	for _, shard := range shards {
+		sbc := &sandboxConn{}
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
 	serv := new(sandboxTopo)
 	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
 	router := NewRouter(serv, "aa", schema, "", scatterConn)
-
 	q := proto.Query{
-		Sql:        "insert into music_extra_reversed(music_id) values (3)",
+		Sql:        "select * from user",
 		TabletType: topo.TYPE_MASTER,
 	}
-	_, err = router.Execute(context.Background(), &q)
+	result, err := routerStream(router, &q)
 	if err != nil {
 		t.Error(err)
 	}
-	wantBind := map[string]interface{}{
-		"music_id": int64(3),
+	if len(result.Rows) != len(shards) {
+		t.Errorf("len(result.Rows): %d, want %d", len(result.Rows), len(shards))
 	}
-	if !reflect.DeepEqual(sbclookup.BindVars[0], wantBind) {
-		t.Errorf("sbclookup.BindVars[0] = %#v, want %#v", sbclookup.BindVars[0], wantBind)
+	wantQuery := "select * from user"
+	for _, conn := range conns {
+		if conn.Queries[0] != wantQuery {
+			t.Errorf("conn.Queries[0]: %q, want %q\n", conn.Queries[0], wantQuery)
+		}
 	}
-	wantQuery := "select user_id from music_user_map where music_id = :music_id"
-	if sbclookup.Queries[0] != wantQuery {
-		t.Errorf("sbclookup.Queries[0]: %q, want %q\n", sbclookup.Queries[0], wantQuery)
+}
+
+func TestStreamUnsupported(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
 	}
-	wantBind = map[string]interface{}{
-		"keyspace_id": "\x16k@\xb4J\xbaK\xd6",
-		"_user_id":    int64(1),
-		"_music_id":   int64(3),
+	createSandbox("TestRouter")
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	q := proto.Query{
+		Sql:        "update user set a=2 where id = 1",
+		TabletType: topo.TYPE_MASTER,
 	}
-	if !reflect.DeepEqual(sbc.BindVars[0], wantBind) {
-		t.Errorf("sbc.BindVars[0] = %#v, want %#v", sbc.BindVars[0], wantBind)
+	_, err = routerStream(router, &q)
+	want := `query "update user set a=2 where id = 1" cannot be used for streaming`
+	if err == nil || err.Error() != want {
+		t.Errorf("routerStream: %v, want %s", err, want)
 	}
-	wantQuery = "insert into music_extra_reversed(music_id, user_id) values (:_music_id, :_user_id) /* _routing keyspace_id:166b40b44aba4bd6 */"
-	if sbc.Queries[0] != wantQuery {
-		t.Errorf("sbc.Queries[0]: %q, want %q\n", sbc.Queries[0], wantQuery)
+}
+
+func TestExecuteBatch(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+	l := createSandbox(TEST_UNSHARDED)
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	qrs, err := router.ExecuteBatch(context.Background(), []tproto.BoundQuery{
+		{Sql: "update user set a=2 where id = 1"},
+		{Sql: "update user set a=2 where id = 3"},
+		{Sql: "select * from music_user_map where id = 1"},
+		{Sql: "select * from user where id = 1"},
+		{Sql: "select * from user where id in (1, 3)"},
+	}, topo.TYPE_MASTER, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One round trip per shard.
+	if sbc1.ExecCount != 1 || sbc2.ExecCount != 1 || sbclookup.ExecCount != 1 {
+		t.Errorf("ExecCount: %v, %v, %v, want 1, 1, 1", sbc1.ExecCount, sbc2.ExecCount, sbclookup.ExecCount)
+	}
+	wantQueries := []string{
+		"update user set a = 2 where id = 1",
+		"select * from user where id = 1",
+		"select * from user where id in ::_vals",
+	}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %v, want %v", sbc1.Queries, wantQueries)
+	}
+	wantQueries = []string{
+		"update user set a = 2 where id = 3",
+		"select * from user where id in ::_vals",
+	}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %v, want %v", sbc2.Queries, wantQueries)
 	}
+	if len(qrs.List) != 5 {
+		t.Fatalf("len(qrs.List): %d, want 5", len(qrs.List))
+	}
+	if len(qrs.List[3].Rows) != 1 || len(qrs.List[4].Rows) != 2 {
+		t.Errorf("rows: %v, %v, want 1 and 2 rows", qrs.List[3].Rows, qrs.List[4].Rows)
+	}
+
+	_, err = router.ExecuteBatch(context.Background(), []tproto.BoundQuery{
+		{Sql: "select * from user where id = 1"},
+		{Sql: "delete from user where id = 1"},
+	}, topo.TYPE_MASTER, nil)
+	want := `query "delete from user where id = 1" cannot be used in a batch`
+	if err == nil || err.Error() != want {
+		t.Errorf("ExecuteBatch: %v, want %s", err, want)
+	}
+	if sbc1.ExecCount != 1 {
+		t.Errorf("sbc1.ExecCount: %v, want 1", sbc1.ExecCount)
+	}
+}
+
+func routerStream(router *Router, q *proto.Query) (qr *mproto.QueryResult, err error) {
+	results := make(chan *mproto.QueryResult, 10)
+	err = router.StreamExecute(context.Background(), q, func(qr *mproto.QueryResult) error {
+		results <- qr
+		return nil
+	})
+	close(results)
+	if err != nil {
+		return nil, err
+	}
+	first := true
+	for r := range results {
+		if first {
+			qr = &mproto.QueryResult{Fields: r.Fields}
+			first = false
+		}
+		qr.Rows = append(qr.Rows, r.Rows...)
+		qr.RowsAffected += r.RowsAffected
+	}
+	return qr, nil
 }
 
 func locateFile(name string) string {
@@ -838,3 +2882,115 @@ func locateFile(name string) string {
 	}
 	return testfiles.Locate("vtgate/" + name)
 }
+
+func TestRouterHints(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	// The KEYRANGE hint overrides the routing by the vindex.
+	sql := "select /*vt+ KEYRANGE=40-60, SKIP_CACHE=true */ * from user where id = 1"
+	if _, err := router.Execute(context.Background(), &proto.Query{Sql: sql, TabletType: topo.TYPE_MASTER}); err != nil {
+		t.Fatal(err)
+	}
+	if sbc1.ExecCount != 0 || sbc2.ExecCount != 1 {
+		t.Errorf("ExecCount: %v, %v, want 0, 1", sbc1.ExecCount, sbc2.ExecCount)
+	}
+	if length := router.planner.plans.Length(); length != 0 {
+		t.Errorf("plans.Length: %d, want 0", length)
+	}
+
+	sql = "select /*vt+ TABLET_TYPE=replica */ * from user where id = 1"
+	_, err = router.Execute(context.Background(), &proto.Query{
+		Sql:        sql,
+		TabletType: topo.TYPE_MASTER,
+		Session:    &proto.Session{InTransaction: true},
+	})
+	if err == nil || !strings.Contains(err.Error(), "TABLET_TYPE hint cannot be used in a transaction") {
+		t.Errorf("Execute in a transaction: %v, want TABLET_TYPE hint error", err)
+	}
+	query := &proto.Query{Sql: sql, TabletType: topo.TYPE_MASTER}
+	if _, err := router.Execute(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+	if query.TabletType != topo.TYPE_REPLICA {
+		t.Errorf("TabletType: %v, want replica", query.TabletType)
+	}
+	if sbc1.ExecCount != 1 {
+		t.Errorf("sbc1.ExecCount: %v, want 1", sbc1.ExecCount)
+	}
+}
+
+func TestRouterTenant(t *testing.T) {
+	schema, err := planbuilder.BuildSchema(&planbuilder.SchemaFormal{
+		Keyspaces: map[string]planbuilder.KeyspaceFormal{
+			"TestTenant_{tenant}": {
+				Tables: map[string]planbuilder.TableFormal{"orders": {}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1 := createSandbox("TestTenant_a")
+	defer deleteSandbox("TestTenant_a")
+	// The keyspaces of the tenants are unsharded.
+	s1.ShardSpec = "-"
+	sbc1 := &sandboxConn{}
+	s1.MapTestConn("0", sbc1)
+	s2 := createSandbox("TestTenant_b")
+	defer deleteSandbox("TestTenant_b")
+	s2.ShardSpec = "-"
+	sbc2 := &sandboxConn{}
+	s2.MapTestConn("0", sbc2)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	for _, tenant := range []string{"a", "b", "b"} {
+		q := proto.Query{
+			Sql:        "select * from orders",
+			TabletType: topo.TYPE_MASTER,
+			Session:    &proto.Session{Tenant: tenant},
+		}
+		if _, err := router.Execute(context.Background(), &q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sbc1.ExecCount != 1 || sbc2.ExecCount != 2 {
+		t.Errorf("ExecCount: %v, %v, want 1, 2", sbc1.ExecCount, sbc2.ExecCount)
+	}
+	// The plan is shared by the tenants.
+	if length := router.planner.plans.Length(); length != 1 {
+		t.Errorf("plans.Length: %d, want 1", length)
+	}
+
+	_, err = router.Execute(context.Background(), &proto.Query{
+		Sql:        "select * from orders",
+		TabletType: topo.TYPE_MASTER,
+	})
+	want := "table orders belongs to the tenant keyspaces TestTenant_{tenant}, and no tenant is set"
+	if err == nil || err.Error() != want {
+		t.Errorf("Execute without a tenant: %v, want %s", err, want)
+	}
+	_, err = routerStream(router, &proto.Query{
+		Sql:        "select * from orders",
+		TabletType: topo.TYPE_MASTER,
+		Session:    &proto.Session{Tenant: "a"},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if sbc1.ExecCount != 2 {
+		t.Errorf("sbc1.ExecCount: %v, want 2", sbc1.ExecCount)
+	}
+}
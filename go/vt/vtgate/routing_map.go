@@ -4,6 +4,8 @@
 
 package vtgate
 
+import "github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+
 type routingMap map[string][]interface{}
 
 func (rtm routingMap) Add(shard string, id interface{}) {
@@ -24,3 +26,18 @@ func (rtm routingMap) Shards() []string {
 	}
 	return shards
 }
+
+// ShardVars returns the bind vars for each shard. Each shard
+// gets a copy of bv along with its list of ids in ListVarName.
+func (rtm routingMap) ShardVars(bv map[string]interface{}) map[string]map[string]interface{} {
+	shardVars := make(map[string]map[string]interface{}, len(rtm))
+	for shard, vals := range rtm {
+		newbv := make(map[string]interface{}, len(bv)+1)
+		for k, v := range bv {
+			newbv[k] = v
+		}
+		newbv[planbuilder.ListVarName] = vals
+		shardVars[shard] = newbv
+	}
+	return shardVars
+}
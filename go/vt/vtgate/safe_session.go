@@ -5,6 +5,9 @@
 package vtgate
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/youtube/vitess/go/vt/topo"
@@ -43,15 +46,138 @@ func (session *SafeSession) Find(keyspace, shard string, tabletType topo.TabletT
 	return 0
 }
 
-func (session *SafeSession) Append(shardSession *proto.ShardSession) {
+// Append records the transaction of a shard that joins the
+// transaction of the session. The shards of different keyspaces
+// may join it concurrently: if the shard already joined it, the
+// existing transaction is kept and its id is returned, so that
+// the caller can roll back its own. Otherwise, it returns 0.
+func (session *SafeSession) Append(shardSession *proto.ShardSession) int64 {
 	session.mu.Lock()
 	defer session.mu.Unlock()
+	for _, existing := range session.ShardSessions {
+		if shardSession.Keyspace == existing.Keyspace && shardSession.TabletType == existing.TabletType && shardSession.Shard == existing.Shard {
+			return existing.TransactionId
+		}
+	}
 	session.ShardSessions = append(session.ShardSessions, shardSession)
+	return 0
+}
+
+// shardSessions returns the shards that joined the transaction of
+// the session, sorted by keyspace, shard and tablet type. If keyspace
+// is not empty, only the shards of keyspace are returned.
+func (session *SafeSession) shardSessions(keyspace string) []*proto.ShardSession {
+	if session == nil || session.Session == nil {
+		return nil
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	var shardSessions []*proto.ShardSession
+	for _, shardSession := range session.ShardSessions {
+		if keyspace == "" || shardSession.Keyspace == keyspace {
+			shardSessions = append(shardSessions, shardSession)
+		}
+	}
+	sort.Sort(byShard(shardSessions))
+	return shardSessions
+}
+
+// FindReserved returns the id of the connection reserved
+// for the session on the shard, or 0 if there's none.
+func (session *SafeSession) FindReserved(keyspace, shard string, tabletType topo.TabletType) int64 {
+	if session == nil || session.Session == nil {
+		return 0
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	for _, shardSession := range session.ReservedSessions {
+		if keyspace == shardSession.Keyspace && tabletType == shardSession.TabletType && shard == shardSession.Shard {
+			return shardSession.TransactionId
+		}
+	}
+	return 0
+}
+
+// AppendReserved records a connection reserved for the session.
+func (session *SafeSession) AppendReserved(shardSession *proto.ShardSession) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.ReservedSessions = append(session.ReservedSessions, shardSession)
 }
 
+// DropReserved forgets the connection reserved
+// for the session on the shard.
+func (session *SafeSession) DropReserved(keyspace, shard string, tabletType topo.TabletType) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	for i, shardSession := range session.ReservedSessions {
+		if keyspace == shardSession.Keyspace && tabletType == shardSession.TabletType && shard == shardSession.Shard {
+			session.ReservedSessions = append(session.ReservedSessions[:i], session.ReservedSessions[i+1:]...)
+			return
+		}
+	}
+}
+
+// Reset concludes the transaction of the session. The
+// reserved connections are kept, since they outlive it.
 func (session *SafeSession) Reset() {
 	session.mu.Lock()
 	defer session.mu.Unlock()
 	session.Session.InTransaction = false
 	session.ShardSessions = nil
+	session.Savepoints = nil
+	session.TransactionReadOnly = false
+	session.TransactionAborted = false
+}
+
+// txOptions returns the isolation level and the access
+// mode the transaction begins with on each shard.
+func (session *SafeSession) txOptions() (isolation string, readOnly bool) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.TransactionIsolation, session.TransactionReadOnly
+}
+
+// savepoints returns the names of the savepoints of the transaction.
+func (session *SafeSession) savepoints() []string {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return append([]string(nil), session.Savepoints...)
+}
+
+// checkSingleShard returns an error if the session is in the single
+// transaction mode, and executing on shards of keyspace would make
+// its transaction span more than one shard.
+func (session *SafeSession) checkSingleShard(keyspace string, shards []string, tabletType topo.TabletType) error {
+	if session == nil || session.Session == nil {
+		return nil
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if !session.Session.InTransaction || session.TransactionMode != proto.TxModeSingle {
+		return nil
+	}
+	var names []string
+	for _, shardSession := range session.ShardSessions {
+		names = append(names, fmt.Sprintf("%s/%s", shardSession.Keyspace, shardSession.Shard))
+	}
+	var added []string
+	for shard := range unique(shards) {
+		found := false
+		for _, shardSession := range session.ShardSessions {
+			if inShardSession(shardSession, keyspace, shard, tabletType) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			added = append(added, fmt.Sprintf("%s/%s", keyspace, shard))
+		}
+	}
+	sort.Strings(added)
+	names = append(names, added...)
+	if len(names) > 1 {
+		return fmt.Errorf("transaction_mode is single, but the transaction would span multiple shards: %s", strings.Join(names, ", "))
+	}
+	return nil
 }
@@ -48,6 +48,14 @@ func createSandbox(keyspace string) *sandbox {
 	return s
 }
 
+// deleteSandbox removes the sandbox of keyspace, so that
+// the tests that list the keyspaces don't see it.
+func deleteSandbox(keyspace string) {
+	sandboxMu.Lock()
+	defer sandboxMu.Unlock()
+	delete(sandboxMap, keyspace)
+}
+
 func getSandbox(keyspace string) *sandbox {
 	sandboxMu.Lock()
 	defer sandboxMu.Unlock()
@@ -77,6 +85,9 @@ type sandbox struct {
 	// EndPointMustFail specifies how often GetEndPoints must fail before succeeding
 	EndPointMustFail int
 
+	// EndPointCell is the cell of the last GetEndPoints call
+	EndPointCell string
+
 	// DialerCoun tracks how often sandboxDialer was called
 	DialCounter int
 
@@ -103,6 +114,7 @@ func (s *sandbox) Reset() {
 	s.SrvKeyspaceMustFail = 0
 	s.EndPointCounter = 0
 	s.EndPointMustFail = 0
+	s.EndPointCell = ""
 	s.DialCounter = 0
 	s.DialMustFail = 0
 	s.KeyspaceServedFrom = ""
@@ -268,10 +280,15 @@ func (sct *sandboxTopo) GetSrvKeyspace(context context.Context, cell, keyspace s
 
 func (sct *sandboxTopo) GetEndPoints(context context.Context, cell, keyspace, shard string, tabletType topo.TabletType) (*topo.EndPoints, error) {
 	sand := getSandbox(keyspace)
+	sand.sandmu.Lock()
 	sand.EndPointCounter++
+	sand.EndPointCell = cell
+	sand.sandmu.Unlock()
 	if sct.callbackGetEndPoints != nil {
 		sct.callbackGetEndPoints(sct)
 	}
+	sand.sandmu.Lock()
+	defer sand.sandmu.Unlock()
 	if sand.EndPointMustFail > 0 {
 		sand.EndPointMustFail--
 		return nil, fmt.Errorf("topo error")
@@ -322,11 +339,38 @@ type sandboxConn struct {
 	CommitCount   sync2.AtomicInt64
 	RollbackCount sync2.AtomicInt64
 	CloseCount    sync2.AtomicInt64
+	ReserveCount  sync2.AtomicInt64
+	ReleaseCount  sync2.AtomicInt64
+
+	// Two-phase commit counts.
+	PrepareCount             sync2.AtomicInt64
+	CommitPreparedCount      sync2.AtomicInt64
+	RollbackPreparedCount    sync2.AtomicInt64
+	CreateTransactionCount   sync2.AtomicInt64
+	StartCommitCount         sync2.AtomicInt64
+	SetRollbackCount         sync2.AtomicInt64
+	ConcludeTransactionCount sync2.AtomicInt64
+
+	// UnresolvedTxs is returned by UnresolvedTransactions.
+	UnresolvedTxs []tproto.DistributedTx
+
+	// Waits is returned by LockWaits.
+	Waits []tproto.LockWait
+
+	// mu protects the fields the calls change, from the concurrent
+	// calls and from the calls withRetry abandoned: the mustFail
+	// counters, and the fields below.
+	mu sync.Mutex
 
 	// BindVars & Queries store the requests received.
 	BindVars []map[string]interface{}
 	Queries  []string
 
+	// Isolation, ReadOnly & ReservedID store the options of the last Begin.
+	Isolation  string
+	ReadOnly   bool
+	ReservedID int64
+
 	// results specifies the results to be returned.
 	// They're consumed as results are returned. If there are
 	// no results left, singleRowResult is returned.
@@ -340,6 +384,8 @@ func (sbc *sandboxConn) getError() error {
 	if sbc.onConnUse != nil {
 		sbc.onConnUse(sbc)
 	}
+	sbc.mu.Lock()
+	defer sbc.mu.Unlock()
 	if sbc.mustFailRetry > 0 {
 		sbc.mustFailRetry--
 		return &tabletconn.ServerError{Code: tabletconn.ERR_RETRY, Err: "retry: err"}
@@ -368,6 +414,8 @@ func (sbc *sandboxConn) getError() error {
 }
 
 func (sbc *sandboxConn) setResults(r []*mproto.QueryResult) {
+	sbc.mu.Lock()
+	defer sbc.mu.Unlock()
 	sbc.results = r
 }
 
@@ -377,8 +425,10 @@ func (sbc *sandboxConn) Execute(context context.Context, query string, bindVars
 	for k, v := range bindVars {
 		bv[k] = v
 	}
+	sbc.mu.Lock()
 	sbc.BindVars = append(sbc.BindVars, bv)
 	sbc.Queries = append(sbc.Queries, query)
+	sbc.mu.Unlock()
 	if sbc.mustDelay != 0 {
 		time.Sleep(sbc.mustDelay)
 	}
@@ -390,6 +440,16 @@ func (sbc *sandboxConn) Execute(context context.Context, query string, bindVars
 
 func (sbc *sandboxConn) ExecuteBatch(context context.Context, queries []tproto.BoundQuery, transactionID int64) (*tproto.QueryResultList, error) {
 	sbc.ExecCount.Add(1)
+	for _, query := range queries {
+		bv := make(map[string]interface{})
+		for k, v := range query.BindVariables {
+			bv[k] = v
+		}
+		sbc.mu.Lock()
+		sbc.BindVars = append(sbc.BindVars, bv)
+		sbc.Queries = append(sbc.Queries, query.Sql)
+		sbc.mu.Unlock()
+	}
 	if sbc.mustDelay != 0 {
 		time.Sleep(sbc.mustDelay)
 	}
@@ -410,8 +470,10 @@ func (sbc *sandboxConn) StreamExecute(context context.Context, query string, bin
 	for k, v := range bindVars {
 		bv[k] = v
 	}
+	sbc.mu.Lock()
 	sbc.BindVars = append(sbc.BindVars, bv)
 	sbc.Queries = append(sbc.Queries, query)
+	sbc.mu.Unlock()
 	if sbc.mustDelay != 0 {
 		time.Sleep(sbc.mustDelay)
 	}
@@ -422,9 +484,14 @@ func (sbc *sandboxConn) StreamExecute(context context.Context, query string, bin
 	return ch, func() error { return err }, err
 }
 
-func (sbc *sandboxConn) Begin(context context.Context) (int64, error) {
+func (sbc *sandboxConn) Begin(context context.Context, isolation string, readOnly bool, reservedID int64) (int64, error) {
 	sbc.ExecCount.Add(1)
 	sbc.BeginCount.Add(1)
+	sbc.mu.Lock()
+	sbc.Isolation = isolation
+	sbc.ReadOnly = readOnly
+	sbc.ReservedID = reservedID
+	sbc.mu.Unlock()
 	if sbc.mustDelay != 0 {
 		time.Sleep(sbc.mustDelay)
 	}
@@ -453,6 +520,82 @@ func (sbc *sandboxConn) Rollback(context context.Context, transactionID int64) e
 	return sbc.getError()
 }
 
+func (sbc *sandboxConn) Reserve(context context.Context) (int64, error) {
+	sbc.ExecCount.Add(1)
+	sbc.ReserveCount.Add(1)
+	if sbc.mustDelay != 0 {
+		time.Sleep(sbc.mustDelay)
+	}
+	err := sbc.getError()
+	if err != nil {
+		return 0, err
+	}
+	return sbc.TransactionId.Add(1), nil
+}
+
+func (sbc *sandboxConn) Release(context context.Context, reservedID int64) error {
+	sbc.ExecCount.Add(1)
+	sbc.ReleaseCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) Prepare(context context.Context, transactionID int64, dtid string) error {
+	sbc.ExecCount.Add(1)
+	sbc.PrepareCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) CommitPrepared(context context.Context, dtid string) error {
+	sbc.ExecCount.Add(1)
+	sbc.CommitPreparedCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) RollbackPrepared(context context.Context, dtid string, originalID int64) error {
+	sbc.ExecCount.Add(1)
+	sbc.RollbackPreparedCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) CreateTransaction(context context.Context, dtid string, participants []tproto.Participant) error {
+	sbc.ExecCount.Add(1)
+	sbc.CreateTransactionCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) StartCommit(context context.Context, transactionID int64, dtid string) error {
+	sbc.ExecCount.Add(1)
+	sbc.StartCommitCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) SetRollback(context context.Context, dtid string, transactionID int64) error {
+	sbc.ExecCount.Add(1)
+	sbc.SetRollbackCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) ConcludeTransaction(context context.Context, dtid string) error {
+	sbc.ExecCount.Add(1)
+	sbc.ConcludeTransactionCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) UnresolvedTransactions(context context.Context, abandonAge time.Duration) ([]tproto.DistributedTx, error) {
+	sbc.ExecCount.Add(1)
+	if err := sbc.getError(); err != nil {
+		return nil, err
+	}
+	return sbc.UnresolvedTxs, nil
+}
+
+func (sbc *sandboxConn) LockWaits(context context.Context) ([]tproto.LockWait, error) {
+	if err := sbc.getError(); err != nil {
+		return nil, err
+	}
+	return sbc.Waits, nil
+}
+
 var sandboxSQRowCount = int64(10)
 
 // Fake SplitQuery creates splits from the original query by appending the
@@ -486,6 +629,8 @@ func (sbc *sandboxConn) setEndPoint(ep topo.EndPoint) {
 }
 
 func (sbc *sandboxConn) getNextResult() *mproto.QueryResult {
+	sbc.mu.Lock()
+	defer sbc.mu.Unlock()
 	if len(sbc.results) != 0 {
 		r := sbc.results[0]
 		sbc.results = sbc.results[1:]
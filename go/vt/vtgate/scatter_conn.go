@@ -5,11 +5,14 @@
 package vtgate
 
 import (
+	"flag"
 	"fmt"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
 
+	log "github.com/golang/glog"
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/sync2"
@@ -22,7 +25,11 @@ import (
 	"golang.org/x/net/context"
 )
 
-var idGen sync2.AtomicInt64
+var (
+	idGen sync2.AtomicInt64
+
+	streamParallelism = flag.Int("stream_parallelism", 0, "maximum number of shards a streaming query reads from at a time, 0 means no limit")
+)
 
 // ScatterConn is used for executing queries across
 // multiple ShardConn connections.
@@ -36,6 +43,56 @@ type ScatterConn struct {
 
 	mu         sync.Mutex
 	shardConns map[string]*ShardConn
+	// cellConns are the ScatterConns of the other cells,
+	// created by inCell.
+	cellConns map[string]*ScatterConn
+	// fallbackConn is the ScatterConn that only uses the
+	// replicas that are not lagging, created by replicaFallback.
+	fallbackConn *ScatterConn
+	// remoteReadConn is the ScatterConn that reads from the
+	// remoteReadCells when a shard has no healthy tablet in
+	// cell, created by withRemoteReads.
+	remoteReadConn *ScatterConn
+	// stalenessConns are the ScatterConns that only use the
+	// tablets within a replication lag, created by withMaxStaleness.
+	stalenessConns map[time.Duration]*ScatterConn
+	// remoteReadCells are the cells, in order of preference,
+	// the replica and rdonly reads can fall back to.
+	remoteReadCells []string
+
+	// maxResultRows and maxResultBytes limit the
+	// rows the shards can return for a query.
+	maxResultRows  int
+	maxResultBytes int
+	// spillThreshold is the size of the shard results of a
+	// non-streaming query beyond which they're spilled to disk,
+	// up to spillMaxBytes. 0 disables spilling.
+	spillThreshold int
+	spillMaxBytes  int
+	// streamParallelism is the default maximum number
+	// of shards a streaming query reads from at a time.
+	streamParallelism int
+	// hedgeDelay is the time after which the reads that can be
+	// hedged are also sent to a second tablet. 0 disables it.
+	hedgeDelay time.Duration
+	// admission limits the queries in flight on each keyspace.
+	// It's shared with the ScatterConns of the other cells.
+	admission *admission
+
+	// txReaper rolls back the transactions that time out.
+	// It's shared with the ScatterConns of the other cells.
+	txReaper *txReaper
+	// deadlocks breaks the deadlocks between transactions.
+	// It's shared with the ScatterConns of the other cells.
+	deadlocks *deadlockDetector
+	// breakers fail the queries to the shards that keep failing.
+	// They're shared with the ScatterConns of the other cells,
+	// and have a breaker per cell.
+	breakers *circuitBreakers
+	// health tracks the success rate and the latency of the
+	// queries to each shard. It's shared with the ScatterConns
+	// of the other cells.
+	health *shardHealthTracker
 }
 
 // shardActionFunc defines the contract for a shard action. Every such function
@@ -48,7 +105,11 @@ type shardActionFunc func(conn *ShardConn, transactionId int64, sResults chan<-
 // NewScatterConn creates a new ScatterConn. All input parameters are passed through
 // for creating the appropriate ShardConn.
 func NewScatterConn(serv SrvTopoServer, statsName, cell string, retryDelay time.Duration, retryCount int, timeout time.Duration) *ScatterConn {
-	return &ScatterConn{
+	admission, err := newAdmission(*keyspaceInFlightLimit, *inFlightLimits, *inFlightQueueTimeout)
+	if err != nil {
+		log.Fatalf("invalid in_flight_limits: %v", err)
+	}
+	stc := &ScatterConn{
 		toposerv:   serv,
 		cell:       cell,
 		retryDelay: retryDelay,
@@ -56,7 +117,27 @@ func NewScatterConn(serv SrvTopoServer, statsName, cell string, retryDelay time.
 		timeout:    timeout,
 		timings:    stats.NewMultiTimings(statsName, []string{"Operation", "Keyspace", "ShardName", "DbType"}),
 		shardConns: make(map[string]*ShardConn),
+		cellConns:  make(map[string]*ScatterConn),
+
+		maxResultRows:  *maxResultRows,
+		maxResultBytes: *maxResultBytes,
+		spillThreshold: *resultSpillThreshold,
+		spillMaxBytes:  *resultSpillMaxBytes,
+
+		streamParallelism: *streamParallelism,
+		hedgeDelay:        *hedgeDelay,
+		remoteReadCells:   remoteReadCells,
+		admission:         admission,
+		breakers:          newCircuitBreakers(*circuitBreakerErrorRate, *circuitBreakerMinRequests, *circuitBreakerWindow, *circuitBreakerOpenTime, *circuitBreakerSlowTime),
+		health:            newShardHealthTracker(*sloWindow, *sloSuccessRate, *sloP99Latency, *sloMinRequests),
+	}
+	stc.txReaper = newTxReaper(stc)
+	stc.deadlocks = newDeadlockDetector(stc)
+	if statsName != "" {
+		stc.health.publish("VtgateShardHealth")
+		http.Handle("/debug/shard_health", stc.health)
 	}
+	return stc
 }
 
 // InitializeConnections pre-initializes all ShardConn which create underlying connections.
@@ -119,6 +200,11 @@ func (stc *ScatterConn) Execute(
 	tabletType topo.TabletType,
 	session *SafeSession,
 ) (*mproto.QueryResult, error) {
+	hedge := stc.canHedge(query, shards, tabletType, session)
+	context = withWorkload(context, session)
+	context, span := startSpan(context, "ScatterConn.Execute")
+	span.Annotate("keyspace", keyspace)
+	defer span.Finish()
 	results, allErrors := stc.multiGo(
 		context,
 		"Execute",
@@ -126,8 +212,10 @@ func (stc *ScatterConn) Execute(
 		shards,
 		tabletType,
 		session,
+		[]string{query},
+		reserveModeFor(query),
 		func(sdc *ShardConn, transactionId int64, sResults chan<- interface{}) error {
-			innerqr, err := sdc.Execute(context, query, bindVars, transactionId)
+			innerqr, err := stc.execute(context, sdc, query, bindVars, transactionId, hedge)
 			if err != nil {
 				return err
 			}
@@ -135,15 +223,28 @@ func (stc *ScatterConn) Execute(
 			return nil
 		})
 
-	qr := new(mproto.QueryResult)
+	limiter := stc.newResultLimiter(session)
+	buffer := stc.newSpillBuffer()
+	defer buffer.close()
+	var limitErr error
 	for innerqr := range results {
 		innerqr := innerqr.(*mproto.QueryResult)
-		appendResult(qr, innerqr)
+		// We still need to finish pumping
+		if limitErr != nil {
+			continue
+		}
+		if limitErr = limiter.add(innerqr); limitErr != nil {
+			continue
+		}
+		limitErr = buffer.add(innerqr)
 	}
 	if allErrors.HasErrors() {
 		return nil, allErrors.AggrError(stc.aggregateErrors)
 	}
-	return qr, nil
+	if limitErr != nil {
+		return nil, limitErr
+	}
+	return buffer.result()
 }
 
 // ExecuteMulti is like Execute,
@@ -157,6 +258,134 @@ func (stc *ScatterConn) ExecuteMulti(
 	tabletType topo.TabletType,
 	session *SafeSession,
 ) (*mproto.QueryResult, error) {
+	qr, _, err := stc.executeMulti(context, query, keyspace, shardVars, tabletType, session, false)
+	return qr, err
+}
+
+// ExecuteMultiPartial is like ExecuteMulti, but if only some of the
+// shards fail, the results of the others are returned along with
+// the errors of the failed shards. It fails if all the shards fail.
+func (stc *ScatterConn) ExecuteMultiPartial(
+	context context.Context,
+	query string,
+	keyspace string,
+	shardVars map[string]map[string]interface{},
+	tabletType topo.TabletType,
+	session *SafeSession,
+) (*mproto.QueryResult, []error, error) {
+	return stc.executeMulti(context, query, keyspace, shardVars, tabletType, session, true)
+}
+
+func (stc *ScatterConn) executeMulti(
+	context context.Context,
+	query string,
+	keyspace string,
+	shardVars map[string]map[string]interface{},
+	tabletType topo.TabletType,
+	session *SafeSession,
+	partial bool,
+) (*mproto.QueryResult, []error, error) {
+	shards := getShards(shardVars)
+	hedge := stc.canHedge(query, shards, tabletType, session)
+	context = withWorkload(context, session)
+	context, span := startSpan(context, "ScatterConn.ExecuteMulti")
+	span.Annotate("keyspace", keyspace)
+	defer span.Finish()
+	results, allErrors := stc.multiGo(
+		context,
+		"Execute",
+		keyspace,
+		shards,
+		tabletType,
+		session,
+		[]string{query},
+		reserveModeFor(query),
+		func(sdc *ShardConn, transactionId int64, sResults chan<- interface{}) error {
+			innerqr, err := stc.execute(context, sdc, query, shardVars[sdc.shard], transactionId, hedge)
+			if err != nil {
+				return err
+			}
+			sResults <- innerqr
+			return nil
+		})
+
+	limiter := stc.newResultLimiter(session)
+	buffer := stc.newSpillBuffer()
+	defer buffer.close()
+	var limitErr error
+	succeeded := 0
+	for innerqr := range results {
+		innerqr := innerqr.(*mproto.QueryResult)
+		succeeded++
+		// We still need to finish pumping
+		if limitErr != nil {
+			continue
+		}
+		if limitErr = limiter.add(innerqr); limitErr != nil {
+			continue
+		}
+		limitErr = buffer.add(innerqr)
+	}
+	if allErrors.HasErrors() {
+		if !partial || succeeded == 0 {
+			return nil, nil, allErrors.AggrError(stc.aggregateErrors)
+		}
+		if limitErr != nil {
+			return nil, nil, limitErr
+		}
+		qr, err := buffer.result()
+		if err != nil {
+			return nil, nil, err
+		}
+		return qr, allErrors.Errors, nil
+	}
+	if limitErr != nil {
+		return nil, nil, limitErr
+	}
+	qr, err := buffer.result()
+	return qr, nil, err
+}
+
+// ExecuteMultiPerShard is like ExecuteMulti, but the results
+// of each shard are returned separately.
+func (stc *ScatterConn) ExecuteMultiPerShard(
+	context context.Context,
+	query string,
+	keyspace string,
+	shardVars map[string]map[string]interface{},
+	tabletType topo.TabletType,
+	session *SafeSession,
+) ([]*mproto.QueryResult, error) {
+	qrs, _, err := stc.executeMultiPerShard(context, query, keyspace, shardVars, tabletType, session, false)
+	return qrs, err
+}
+
+// ExecuteMultiPerShardPartial is like ExecuteMultiPerShard, but
+// failures are handled like in ExecuteMultiPartial.
+func (stc *ScatterConn) ExecuteMultiPerShardPartial(
+	context context.Context,
+	query string,
+	keyspace string,
+	shardVars map[string]map[string]interface{},
+	tabletType topo.TabletType,
+	session *SafeSession,
+) ([]*mproto.QueryResult, []error, error) {
+	return stc.executeMultiPerShard(context, query, keyspace, shardVars, tabletType, session, true)
+}
+
+func (stc *ScatterConn) executeMultiPerShard(
+	context context.Context,
+	query string,
+	keyspace string,
+	shardVars map[string]map[string]interface{},
+	tabletType topo.TabletType,
+	session *SafeSession,
+	partial bool,
+) ([]*mproto.QueryResult, []error, error) {
+	context = withWorkload(context, session)
+	context, span := startSpan(context, "ScatterConn.ExecuteMultiPerShard")
+	span.Annotate("keyspace", keyspace)
+	defer span.Finish()
 	results, allErrors := stc.multiGo(
 		context,
 		"Execute",
@@ -164,6 +393,8 @@ func (stc *ScatterConn) ExecuteMulti(
 		getShards(shardVars),
 		tabletType,
 		session,
+		[]string{query},
+		reserveModeFor(query),
 		func(sdc *ShardConn, transactionId int64, sResults chan<- interface{}) error {
 			innerqr, err := sdc.Execute(context, query, shardVars[sdc.shard], transactionId)
 			if err != nil {
@@ -173,15 +404,32 @@ func (stc *ScatterConn) ExecuteMulti(
 			return nil
 		})
 
-	qr := new(mproto.QueryResult)
+	limiter := stc.newResultLimiter(session)
+	var limitErr error
+	var qrs []*mproto.QueryResult
+	succeeded := 0
 	for innerqr := range results {
 		innerqr := innerqr.(*mproto.QueryResult)
-		appendResult(qr, innerqr)
+		succeeded++
+		// We still need to finish pumping
+		if limitErr = limiter.add(innerqr); limitErr != nil {
+			continue
+		}
+		qrs = append(qrs, innerqr)
 	}
 	if allErrors.HasErrors() {
-		return nil, allErrors.AggrError(stc.aggregateErrors)
+		if !partial || succeeded == 0 {
+			return nil, nil, allErrors.AggrError(stc.aggregateErrors)
+		}
+		if limitErr != nil {
+			return nil, nil, limitErr
+		}
+		return qrs, allErrors.Errors, nil
 	}
-	return qr, nil
+	if limitErr != nil {
+		return nil, nil, limitErr
+	}
+	return qrs, nil, nil
 }
 
 func (stc *ScatterConn) ExecuteEntityIds(
@@ -193,6 +441,10 @@ func (stc *ScatterConn) ExecuteEntityIds(
 	tabletType topo.TabletType,
 	session *SafeSession,
 ) (*mproto.QueryResult, error) {
+	context = withWorkload(context, session)
+	context, span := startSpan(context, "ScatterConn.ExecuteEntityIds")
+	span.Annotate("keyspace", keyspace)
+	defer span.Finish()
 	results, allErrors := stc.multiGo(
 		context,
 		"ExecuteEntityIds",
@@ -200,6 +452,8 @@ func (stc *ScatterConn) ExecuteEntityIds(
 		shards,
 		tabletType,
 		session,
+		sqlValues(sqls),
+		reserveModeFor(sqlValues(sqls)...),
 		func(sdc *ShardConn, transactionId int64, sResults chan<- interface{}) error {
 			shard := sdc.shard
 			sql := sqls[shard]
@@ -212,15 +466,28 @@ func (stc *ScatterConn) ExecuteEntityIds(
 			return nil
 		})
 
-	qr := new(mproto.QueryResult)
+	limiter := stc.newResultLimiter(session)
+	buffer := stc.newSpillBuffer()
+	defer buffer.close()
+	var limitErr error
 	for innerqr := range results {
 		innerqr := innerqr.(*mproto.QueryResult)
-		appendResult(qr, innerqr)
+		// We still need to finish pumping
+		if limitErr != nil {
+			continue
+		}
+		if limitErr = limiter.add(innerqr); limitErr != nil {
+			continue
+		}
+		limitErr = buffer.add(innerqr)
 	}
 	if allErrors.HasErrors() {
 		return nil, allErrors.AggrError(stc.aggregateErrors)
 	}
-	return qr, nil
+	if limitErr != nil {
+		return nil, limitErr
+	}
+	return buffer.result()
 }
 
 // ExecuteBatch executes a batch of non-streaming queries on the specified shards.
@@ -232,6 +499,10 @@ func (stc *ScatterConn) ExecuteBatch(
 	tabletType topo.TabletType,
 	session *SafeSession,
 ) (qrs *tproto.QueryResultList, err error) {
+	context = withWorkload(context, session)
+	context, span := startSpan(context, "ScatterConn.ExecuteBatch")
+	span.Annotate("keyspace", keyspace)
+	defer span.Finish()
 	results, allErrors := stc.multiGo(
 		context,
 		"ExecuteBatch",
@@ -239,6 +510,8 @@ func (stc *ScatterConn) ExecuteBatch(
 		shards,
 		tabletType,
 		session,
+		boundQueries(queries),
+		reserveModeFor(boundQueries(queries)...),
 		func(sdc *ShardConn, transactionId int64, sResults chan<- interface{}) error {
 			innerqrs, err := sdc.ExecuteBatch(context, queries, transactionId)
 			if err != nil {
@@ -263,6 +536,7 @@ func (stc *ScatterConn) ExecuteBatch(
 }
 
 // StreamExecute executes a streaming query on vttablet. The retry rules are the same.
+// The shards are read from at most streamLimit(session) at a time.
 func (stc *ScatterConn) StreamExecute(
 	context context.Context,
 	query string,
@@ -273,13 +547,20 @@ func (stc *ScatterConn) StreamExecute(
 	session *SafeSession,
 	sendReply func(reply *mproto.QueryResult) error,
 ) error {
-	results, allErrors := stc.multiGo(
+	context = withWorkload(context, session)
+	context, span := startSpan(context, "ScatterConn.StreamExecute")
+	span.Annotate("keyspace", keyspace)
+	defer span.Finish()
+	results, allErrors := stc.multiGoLimit(
 		context,
 		"StreamExecute",
 		keyspace,
 		shards,
 		tabletType,
 		session,
+		[]string{query},
+		noReserved,
+		stc.streamLimit(session),
 		func(sdc *ShardConn, transactionId int64, sResults chan<- interface{}) error {
 			sr, errFunc := sdc.StreamExecute(context, query, bindVars, transactionId)
 			if sr != nil {
@@ -289,17 +570,25 @@ func (stc *ScatterConn) StreamExecute(
 			}
 			return errFunc()
 		})
-	var replyErr error
+	limiter := stc.newResultLimiter(session)
+	var replyErr, limitErr error
 	for innerqr := range results {
 		// We still need to finish pumping
-		if replyErr != nil {
+		if replyErr != nil || limitErr != nil {
 			continue
 		}
-		replyErr = sendReply(innerqr.(*mproto.QueryResult))
+		innerqr := innerqr.(*mproto.QueryResult)
+		if limitErr = limiter.add(innerqr); limitErr != nil {
+			continue
+		}
+		replyErr = sendReply(innerqr)
 	}
 	if replyErr != nil {
 		allErrors.RecordError(replyErr)
 	}
+	if limitErr != nil && !allErrors.HasErrors() {
+		return limitErr
+	}
 	return allErrors.AggrError(stc.aggregateErrors)
 }
 
@@ -315,13 +604,20 @@ func (stc *ScatterConn) StreamExecuteMulti(
 	session *SafeSession,
 	sendReply func(reply *mproto.QueryResult) error,
 ) error {
-	results, allErrors := stc.multiGo(
+	context = withWorkload(context, session)
+	context, span := startSpan(context, "ScatterConn.StreamExecuteMulti")
+	span.Annotate("keyspace", keyspace)
+	defer span.Finish()
+	results, allErrors := stc.multiGoLimit(
 		context,
 		"StreamExecute",
 		keyspace,
 		getShards(shardVars),
 		tabletType,
 		session,
+		[]string{query},
+		noReserved,
+		stc.streamLimit(session),
 		func(sdc *ShardConn, transactionId int64, sResults chan<- interface{}) error {
 			sr, errFunc := sdc.StreamExecute(context, query, shardVars[sdc.shard], transactionId)
 			if sr != nil {
@@ -331,43 +627,249 @@ func (stc *ScatterConn) StreamExecuteMulti(
 			}
 			return errFunc()
 		})
-	var replyErr error
+	limiter := stc.newResultLimiter(session)
+	var replyErr, limitErr error
 	for innerqr := range results {
 		// We still need to finish pumping
-		if replyErr != nil {
+		if replyErr != nil || limitErr != nil {
+			continue
+		}
+		innerqr := innerqr.(*mproto.QueryResult)
+		if limitErr = limiter.add(innerqr); limitErr != nil {
 			continue
 		}
-		replyErr = sendReply(innerqr.(*mproto.QueryResult))
+		replyErr = sendReply(innerqr)
 	}
 	if replyErr != nil {
 		allErrors.RecordError(replyErr)
 	}
+	if limitErr != nil && !allErrors.HasErrors() {
+		return limitErr
+	}
+	return allErrors.AggrError(stc.aggregateErrors)
+}
+
+// StreamExecuteMultiPerShard is like StreamExecuteMulti, but
+// the results of each shard are delivered through a stream of
+// their own, which merge is expected to consume. A shard stream
+// holds at most one pending result. So, the shards are not read
+// faster than merge consumes their results. The streams are
+// drained after merge returns. All the shards are read from at
+// the same time, because merge may need a row from each of them.
+func (stc *ScatterConn) StreamExecuteMultiPerShard(
+	context context.Context,
+	query string,
+	keyspace string,
+	shardVars map[string]map[string]interface{},
+	tabletType topo.TabletType,
+	session *SafeSession,
+	merge func(streams []<-chan *mproto.QueryResult) error,
+) error {
+	shards := getShards(shardVars)
+	streams := make(map[string]chan *mproto.QueryResult, len(shards))
+	mergeStreams := make([]<-chan *mproto.QueryResult, 0, len(shards))
+	for shard := range unique(shards) {
+		stream := make(chan *mproto.QueryResult, 1)
+		streams[shard] = stream
+		mergeStreams = append(mergeStreams, stream)
+	}
+	limiter := stc.newResultLimiter(session)
+	context = withWorkload(context, session)
+	context, span := startSpan(context, "ScatterConn.StreamExecuteMultiPerShard")
+	span.Annotate("keyspace", keyspace)
+	defer span.Finish()
+	results, allErrors := stc.multiGo(
+		context,
+		"StreamExecute",
+		keyspace,
+		shards,
+		tabletType,
+		session,
+		[]string{query},
+		noReserved,
+		func(sdc *ShardConn, transactionId int64, sResults chan<- interface{}) error {
+			sr, errFunc := sdc.StreamExecute(context, query, shardVars[sdc.shard], transactionId)
+			if sr != nil {
+				for qr := range sr {
+					// We still need to finish pumping
+					if limiter.add(qr) != nil {
+						continue
+					}
+					streams[sdc.shard] <- qr
+				}
+			}
+			return errFunc()
+		})
+	// A stream is closed only after all the shards are done,
+	// because its shard may not get to execute.
+	go func() {
+		for range results {
+		}
+		for _, stream := range streams {
+			close(stream)
+		}
+	}()
+	mergeErr := merge(mergeStreams)
+	// We still need to finish pumping.
+	for _, stream := range mergeStreams {
+		for range stream {
+		}
+	}
+	if mergeErr != nil {
+		allErrors.RecordError(mergeErr)
+	}
+	if limiter.err != nil && !allErrors.HasErrors() {
+		return limiter.err
+	}
 	return allErrors.AggrError(stc.aggregateErrors)
 }
 
 // Commit commits the current transaction. There are no retries on this operation.
+// If the session is in the twopc transaction mode, a transaction that spans
+// multiple shards is committed atomically. In the xa transaction mode, such
+// a transaction can't be committed: it must be prepared.
 func (stc *ScatterConn) Commit(context context.Context, session *SafeSession) (err error) {
+	context, span := startSpan(context, "ScatterConn.Commit")
+	defer span.Finish()
 	if !session.InTransaction() {
 		return fmt.Errorf("cannot commit: not in transaction")
 	}
+	if err := stc.txReaper.checkAborted(session); err != nil {
+		session.Reset()
+		return err
+	}
+	if isXA(session) {
+		return fmt.Errorf("cannot commit: transaction_mode is xa, and the transaction spans multiple shards: it must be prepared")
+	}
+	stc.txReaper.untrack(session)
+	stc.deadlocks.forget(session)
+	if isTwoPC(session) {
+		shardSessions := session.ShardSessions
+		err = stc.commit2PC(context, session)
+		if err == nil {
+			stc.recordWritePositions(context, session, shardSessions)
+		}
+		session.Reset()
+		return err
+	}
+	// The shards are committed in a deterministic order, keyspace
+	// by keyspace, rather than in the order they joined the transaction.
+	shardSessions := session.shardSessions("")
+	results := make([]ShardCommitResult, 0, len(shardSessions))
 	committing := true
-	for _, shardSession := range session.ShardSessions {
+	for _, shardSession := range shardSessions {
 		sdc := stc.getConnection(context, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		result := ShardCommitResult{
+			Keyspace:   shardSession.Keyspace,
+			Shard:      shardSession.Shard,
+			TabletType: shardSession.TabletType,
+			Result:     CommitSucceeded,
+		}
 		if !committing {
 			sdc.Rollback(context, shardSession.TransactionId)
-			continue
-		}
-		if err = sdc.Commit(context, shardSession.TransactionId); err != nil {
+			result.Result = CommitRolledBack
+		} else if err = sdc.Commit(context, shardSession.TransactionId); err != nil {
 			committing = false
+			result.Result = commitFailure(err)
+			result.Err = err
+		}
+		results = append(results, result)
+	}
+	committed := make([]*proto.ShardSession, 0, len(results))
+	for i, result := range results {
+		if result.Result == CommitSucceeded {
+			committed = append(committed, shardSessions[i])
 		}
 	}
+	stc.recordWritePositions(context, session, committed)
 	session.Reset()
-	return err
+	if committing || len(results) == 1 {
+		return err
+	}
+	return &CommitError{Shards: results}
+}
+
+// Results of the commit of a shard.
+const (
+	// CommitSucceeded means the shard was committed.
+	CommitSucceeded = "committed"
+	// CommitFailed means the shard failed to commit,
+	// and its transaction was not committed.
+	CommitFailed = "failed"
+	// CommitUnknown means vtgate failed to communicate with
+	// the shard, which may or may not have committed.
+	CommitUnknown = "unknown"
+	// CommitRolledBack means the shard was rolled back
+	// because a shard before it failed to commit.
+	CommitRolledBack = "rolled back"
+)
+
+// ShardCommitResult is the result of the commit of a shard.
+// Err is set if the commit failed.
+type ShardCommitResult struct {
+	Keyspace   string
+	Shard      string
+	TabletType topo.TabletType
+	Result     string
+	Err        error
+}
+
+// CommitError is returned if a transaction that spans multiple
+// shards fails to commit. The shards are committed one at a time,
+// and the ones after the shard that failed are rolled back. Shards
+// lists the result of each shard, in the order they were committed.
+type CommitError struct {
+	Shards []ShardCommitResult
+}
+
+func (e *CommitError) Error() string {
+	results := make([]string, 0, len(e.Shards))
+	for _, shard := range e.Shards {
+		result := fmt.Sprintf("%s/%s: %s", shard.Keyspace, shard.Shard, shard.Result)
+		if shard.Err != nil {
+			result = fmt.Sprintf("%s (%v)", result, shard.Err)
+		}
+		results = append(results, result)
+	}
+	return fmt.Sprintf("commit failed: %s", strings.Join(results, ", "))
+}
+
+// commitFailure returns the result of a shard that failed
+// to commit with err.
+func commitFailure(err error) string {
+	if connError, ok := err.(*ShardConnError); ok && connError.ServerError {
+		return CommitFailed
+	}
+	return CommitUnknown
+}
+
+// byShard sorts shard sessions by keyspace, shard and tablet type.
+type byShard []*proto.ShardSession
+
+func (bs byShard) Len() int      { return len(bs) }
+func (bs byShard) Swap(i, j int) { bs[i], bs[j] = bs[j], bs[i] }
+func (bs byShard) Less(i, j int) bool {
+	if bs[i].Keyspace != bs[j].Keyspace {
+		return bs[i].Keyspace < bs[j].Keyspace
+	}
+	if bs[i].Shard != bs[j].Shard {
+		return bs[i].Shard < bs[j].Shard
+	}
+	return bs[i].TabletType < bs[j].TabletType
 }
 
 // Rollback rolls back the current transaction. There are no retries on this operation.
 func (stc *ScatterConn) Rollback(context context.Context, session *SafeSession) (err error) {
-	for _, shardSession := range session.ShardSessions {
+	context, span := startSpan(context, "ScatterConn.Rollback")
+	defer span.Finish()
+	if err := stc.txReaper.checkAborted(session); err != nil {
+		// The transaction was already rolled back.
+		session.Reset()
+		return nil
+	}
+	stc.txReaper.untrack(session)
+	stc.deadlocks.forget(session)
+	for _, shardSession := range session.shardSessions("") {
 		sdc := stc.getConnection(context, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
 		sdc.Rollback(context, shardSession.TransactionId)
 	}
@@ -412,7 +914,7 @@ func (stc *ScatterConn) SplitQuery(ctx context.Context, query tproto.BoundQuery,
 	for shard := range keyRangeByShard {
 		shards = append(shards, shard)
 	}
-	allSplits, allErrors := stc.multiGo(ctx, "SplitQuery", keyspace, shards, topo.TYPE_RDONLY, NewSafeSession(&proto.Session{}), actionFunc)
+	allSplits, allErrors := stc.multiGo(ctx, "SplitQuery", keyspace, shards, topo.TYPE_RDONLY, NewSafeSession(&proto.Session{}), []string{query.Sql}, noReserved, actionFunc)
 	splits := []proto.SplitQueryPart{}
 	for s := range allSplits {
 		splits = append(splits, s.([]proto.SplitQueryPart)...)
@@ -424,7 +926,8 @@ func (stc *ScatterConn) SplitQuery(ctx context.Context, query tproto.BoundQuery,
 	return splits, nil
 }
 
-// Close closes the underlying ShardConn connections.
+// Close closes the underlying ShardConn connections,
+// including those of the other cells.
 func (stc *ScatterConn) Close() error {
 	stc.mu.Lock()
 	defer stc.mu.Unlock()
@@ -432,13 +935,89 @@ func (stc *ScatterConn) Close() error {
 		v.Close()
 	}
 	stc.shardConns = make(map[string]*ShardConn)
+	for _, v := range stc.cellConns {
+		v.Close()
+	}
+	stc.cellConns = make(map[string]*ScatterConn)
+	if stc.fallbackConn != nil {
+		stc.fallbackConn.Close()
+		stc.fallbackConn = nil
+	}
+	if stc.remoteReadConn != nil {
+		stc.remoteReadConn.Close()
+		stc.remoteReadConn = nil
+	}
+	for _, v := range stc.stalenessConns {
+		v.Close()
+	}
+	stc.stalenessConns = nil
 	return nil
 }
 
+// inCell returns a ScatterConn that sends the queries to the
+// tablets of cell. It shares the settings and stats of stc.
+// An empty cell is the cell of stc.
+func (stc *ScatterConn) inCell(cell string) *ScatterConn {
+	if cell == "" || cell == stc.cell {
+		return stc
+	}
+	stc.mu.Lock()
+	defer stc.mu.Unlock()
+	if cellConn, ok := stc.cellConns[cell]; ok {
+		return cellConn
+	}
+	cellConn := stc.derive(stc.toposerv, cell)
+	stc.cellConns[cell] = cellConn
+	return cellConn
+}
+
+// derive returns a new ScatterConn with the settings of stc
+// that gets its endpoints from toposerv, in cell.
+func (stc *ScatterConn) derive(toposerv SrvTopoServer, cell string) *ScatterConn {
+	return &ScatterConn{
+		toposerv:   toposerv,
+		cell:       cell,
+		retryDelay: stc.retryDelay,
+		retryCount: stc.retryCount,
+		timeout:    stc.timeout,
+		timings:    stc.timings,
+		shardConns: make(map[string]*ShardConn),
+		cellConns:  make(map[string]*ScatterConn),
+
+		maxResultRows:  stc.maxResultRows,
+		maxResultBytes: stc.maxResultBytes,
+		spillThreshold: stc.spillThreshold,
+		spillMaxBytes:  stc.spillMaxBytes,
+
+		streamParallelism: stc.streamParallelism,
+		hedgeDelay:        stc.hedgeDelay,
+		remoteReadCells:   stc.remoteReadCells,
+		admission:         stc.admission,
+
+		txReaper:  stc.txReaper,
+		deadlocks: stc.deadlocks,
+		breakers:  stc.breakers,
+		health:    stc.health,
+	}
+}
+
 func (stc *ScatterConn) aggregateErrors(errors []error) error {
 	if len(errors) == 0 {
 		return nil
 	}
+	for _, e := range errors {
+		// The transaction was rolled back, so the
+		// other errors don't matter.
+		if _, ok := e.(*DeadlockError); ok {
+			return e
+		}
+	}
+	for _, e := range errors {
+		// The query was not sent to any shard.
+		if _, ok := e.(*ResourceExhaustedError); ok {
+			return e
+		}
+	}
 	allRetryableError := true
 	for _, e := range errors {
 		connError, ok := e.(*ShardConnError)
@@ -453,13 +1032,23 @@ func (stc *ScatterConn) aggregateErrors(errors []error) error {
 	} else {
 		code = tabletconn.ERR_NORMAL
 	}
+	// The aggregated error is a communication
+	// failure only if all the errors are.
+	serverError := false
+	for _, e := range errors {
+		if connError, ok := e.(*ShardConnError); !ok || connError.ServerError {
+			serverError = true
+			break
+		}
+	}
 	errs := make([]string, 0, len(errors))
 	for _, e := range errors {
 		errs = append(errs, e.Error())
 	}
 	return &ShardConnError{
-		Code: code,
-		Err:  fmt.Sprintf("%v", strings.Join(errs, "\n")),
+		Code:        code,
+		Err:         fmt.Sprintf("%v", strings.Join(errs, "\n")),
+		ServerError: serverError,
 	}
 }
 
@@ -469,7 +1058,10 @@ func (stc *ScatterConn) aggregateErrors(errors []error) error {
 // and updates the Session with the transaction id. If the session already
 // contains a transaction id for the shard, it reuses it.
 // If there are any unrecoverable errors during a transaction, multiGo
-// rolls back the transaction for all shards.
+// rolls back the transaction for all shards. Outside a transaction,
+// the action executes on the reserved connection of the session
+// as reserve specifies. queries are the queries the action executes,
+// which count against the in-flight limits of their keyspace and tables.
 // The action function must match the shardActionFunc signature.
 func (stc *ScatterConn) multiGo(
 	context context.Context,
@@ -478,26 +1070,114 @@ func (stc *ScatterConn) multiGo(
 	shards []string,
 	tabletType topo.TabletType,
 	session *SafeSession,
+	queries []string,
+	reserve reserveMode,
+	action shardActionFunc,
+) (rResults <-chan interface{}, allErrors *concurrency.AllErrorRecorder) {
+	return stc.multiGoLimit(context, name, keyspace, shards, tabletType, session, queries, reserve, 0, action)
+}
+
+// multiGoLimit is like multiGo, but the action is performed on
+// at most parallelism shards at a time. 0 means no limit.
+func (stc *ScatterConn) multiGoLimit(
+	context context.Context,
+	name string,
+	keyspace string,
+	shards []string,
+	tabletType topo.TabletType,
+	session *SafeSession,
+	queries []string,
+	reserve reserveMode,
+	parallelism int,
 	action shardActionFunc,
 ) (rResults <-chan interface{}, allErrors *concurrency.AllErrorRecorder) {
 	allErrors = new(concurrency.AllErrorRecorder)
 	results := make(chan interface{}, len(shards))
+	if err := stc.txReaper.checkAborted(session); err != nil {
+		allErrors.RecordError(err)
+		close(results)
+		return results, allErrors
+	}
+	if err := session.checkSingleShard(keyspace, shards, tabletType); err != nil {
+		allErrors.RecordError(err)
+		close(results)
+		return results, allErrors
+	}
+	if _, err := sessionWorkload(session); err != nil {
+		allErrors.RecordError(err)
+		close(results)
+		return results, allErrors
+	}
+	release, err := stc.admission.acquire(context, keyspace, queries)
+	if err != nil {
+		allErrors.RecordError(err)
+		close(results)
+		return results, allErrors
+	}
+	if reserve == useReserved && session.systemVariablesQuery() != "" {
+		// The queries of the session see its system variables.
+		reserve = needReserved
+	}
+	var sem chan struct{}
+	if parallelism > 0 {
+		sem = make(chan struct{}, parallelism)
+	}
+	wait := stc.deadlocks.begin(session)
 	var wg sync.WaitGroup
-	for shard := range unique(shards) {
+	uniqueShards := unique(shards)
+	addShardCount(context, len(uniqueShards))
+	addLiveShards(context, keyspace, uniqueShards)
+	addAuditShards(context, keyspace, uniqueShards)
+	for shard := range uniqueShards {
 		wg.Add(1)
 		go func(shard string) {
 			defer wg.Done()
+			if sem != nil {
+				sem <- struct{}{}
+				defer func() { <-sem }()
+			}
+			tabletType := transactionAffinityType(session, keyspace, shard, tabletType)
+			tabletType = stc.readAfterWriteType(context, session, keyspace, shard, tabletType)
 			startTime := time.Now()
 			defer stc.timings.Record([]string{name, keyspace, shard, string(tabletType)}, startTime)
+			// finish records the call to the shard,
+			// which failed with err, if not nil.
+			finish := func(err error) {
+				latency := time.Now().Sub(startTime)
+				stc.health.record(keyspace, shard, tabletType, latency, err)
+				addShardLatency(context, keyspace, shard, tabletType, latency, err)
+			}
 
+			key := shardKey(keyspace, shard, tabletType)
+			if err := wait.enter(key); err != nil {
+				allErrors.RecordError(err)
+				return
+			}
+			defer wait.leave(key)
+			done, err := stc.breakers.allow(stc.cell + "." + key)
+			if err != nil {
+				finish(err)
+				allErrors.RecordError(err)
+				return
+			}
 			sdc := stc.getConnection(context, keyspace, shard, tabletType)
-			transactionId, err := stc.updateSession(context, sdc, keyspace, shard, tabletType, session)
+			transactionId, err := stc.updateSession(context, sdc, keyspace, shard, tabletType, session, reserve)
 			if err != nil {
+				done(err)
+				finish(err)
 				allErrors.RecordError(err)
 				return
 			}
 			err = action(sdc, transactionId, results)
+			done(err)
+			finish(err)
 			if err != nil {
+				if transactionId != 0 && !session.InTransaction() && strings.Contains(err.Error(), "not_in_tx") {
+					// The tablet dropped the reserved connection,
+					// along with its session state.
+					session.DropReserved(keyspace, shard, tabletType)
+					reservedCounts.Add("Dropped", 1)
+				}
 				allErrors.RecordError(err)
 				return
 			}
@@ -505,9 +1185,14 @@ func (stc *ScatterConn) multiGo(
 	}
 	go func() {
 		wg.Wait()
+		release()
 		// If we want to rollback, we have to do it before closing results
 		// so that the session is updated to be not InTransaction.
-		if allErrors.HasErrors() {
+		if err := stc.deadlocks.end(wait); err != nil {
+			// The transaction was picked to break a deadlock.
+			allErrors.RecordError(err)
+			stc.Rollback(context, session)
+		} else if allErrors.HasErrors() {
 			if session.InTransaction() {
 				errstr := allErrors.Error().Error()
 				// We cannot recover from these errors
@@ -521,6 +1206,15 @@ func (stc *ScatterConn) multiGo(
 	return results, allErrors
 }
 
+// execute executes query on sdc. It's hedged if hedge is true,
+// unless it executes on a reserved connection.
+func (stc *ScatterConn) execute(context context.Context, sdc *ShardConn, query string, bindVars map[string]interface{}, transactionId int64, hedge bool) (*mproto.QueryResult, error) {
+	if hedge && transactionId == 0 {
+		return sdc.ExecuteHedged(context, query, bindVars, stc.hedgeDelay)
+	}
+	return sdc.Execute(context, query, bindVars, transactionId)
+}
+
 func (stc *ScatterConn) getConnection(context context.Context, keyspace, shard string, tabletType topo.TabletType) *ShardConn {
 	stc.mu.Lock()
 	defer stc.mu.Unlock()
@@ -540,31 +1234,69 @@ func (stc *ScatterConn) updateSession(
 	keyspace, shard string,
 	tabletType topo.TabletType,
 	session *SafeSession,
+	reserve reserveMode,
 ) (transactionId int64, err error) {
 	if !session.InTransaction() {
-		return 0, nil
+		return stc.reservedID(context, sdc, keyspace, shard, tabletType, session, reserve)
 	}
-	// No need to protect ourselves from the race condition between
-	// Find and Append. The higher level functions ensure that no
-	// duplicate (keyspace, shard, tabletType) tuples can execute
-	// this at the same time.
+	// The higher level functions ensure that no duplicate (keyspace,
+	// shard, tabletType) tuples of a statement execute this at the
+	// same time, but the statements on other keyspaces may. Append
+	// resolves the race if they begin on the same shard.
 	transactionId = session.Find(keyspace, shard, tabletType)
 	if transactionId != 0 {
 		return transactionId, nil
 	}
-	transactionId, err = sdc.Begin(context)
+	// The transaction begins on the reserved connection of the
+	// shard, so that it sees the session state.
+	reservedID, err := stc.reservedID(context, sdc, keyspace, shard, tabletType, session, reserve)
+	if err != nil {
+		return 0, err
+	}
+	isolation, readOnly := session.txOptions()
+	transactionId, err = sdc.Begin(context, isolation, readOnly, reservedID)
 	if err != nil {
 		return 0, err
 	}
-	session.Append(&proto.ShardSession{
+	if existing := session.Append(&proto.ShardSession{
 		Keyspace:      keyspace,
 		TabletType:    tabletType,
 		Shard:         shard,
 		TransactionId: transactionId,
-	})
+	}); existing != 0 {
+		sdc.Rollback(context, transactionId)
+		return existing, nil
+	}
+	stc.txReaper.track(session)
+	// The shard joins the transaction after its savepoints
+	// were created. Create them, so that they can be rolled
+	// back to on this shard too.
+	for _, name := range session.savepoints() {
+		if _, err := sdc.Execute(context, fmt.Sprintf("savepoint `%s`", name), nil, transactionId); err != nil {
+			return 0, err
+		}
+	}
 	return transactionId, nil
 }
 
+// streamLimit returns the maximum number of shards a streaming
+// query of session can read from at a time.
+func (stc *ScatterConn) streamLimit(session *SafeSession) int {
+	if session != nil && session.Session != nil && session.StreamParallelism > 0 {
+		return session.StreamParallelism
+	}
+	return stc.streamParallelism
+}
+
+// newResultLimiter returns the limiter of the rows
+// the shards return for a query of session.
+func (stc *ScatterConn) newResultLimiter(session *SafeSession) *resultLimiter {
+	return &resultLimiter{
+		maxRows:  stc.maxRows(session),
+		maxBytes: stc.maxResultBytes,
+	}
+}
+
 func getShards(shardVars map[string]map[string]interface{}) []string {
 	shards := make([]string, 0, len(shardVars))
 	for k := range shardVars {
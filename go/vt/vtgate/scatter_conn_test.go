@@ -7,6 +7,7 @@ package vtgate
 import (
 	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -208,6 +209,106 @@ func TestScatterConnStreamExecuteSendError(t *testing.T) {
 	}
 }
 
+func TestScatterConnResultLimit(t *testing.T) {
+	s := createSandbox("TestScatterConnResultLimit")
+	s.MapTestConn("0", &sandboxConn{})
+	s.MapTestConn("1", &sandboxConn{})
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+	shards := []string{"0", "1"}
+	execute := func() error {
+		_, err := stc.Execute(context.Background(), "query", nil, "TestScatterConnResultLimit", shards, "", nil)
+		return err
+	}
+	stream := func() error {
+		return stc.StreamExecute(context.Background(), "query", nil, "TestScatterConnResultLimit", shards, "", nil, func(*mproto.QueryResult) error {
+			return nil
+		})
+	}
+
+	// Each shard returns one row of 4 bytes.
+	testcases := []struct {
+		maxRows, maxBytes int
+		want              error
+	}{{
+		maxRows: 2,
+	}, {
+		maxRows: 1,
+		want:    &ResultLimitError{Unit: "rows", Limit: 1},
+	}, {
+		maxBytes: 8,
+	}, {
+		maxBytes: 7,
+		want:     &ResultLimitError{Unit: "bytes", Limit: 7},
+	}}
+	for _, tcase := range testcases {
+		stc.maxResultRows, stc.maxResultBytes = tcase.maxRows, tcase.maxBytes
+		for _, f := range []func() error{execute, stream} {
+			if err := f(); !reflect.DeepEqual(err, tcase.want) {
+				t.Errorf("limits %d rows, %d bytes: %v, want %v", tcase.maxRows, tcase.maxBytes, err, tcase.want)
+			}
+		}
+	}
+}
+
+func TestScatterConnStreamParallelism(t *testing.T) {
+	s := createSandbox("TestScatterConnStreamParallelism")
+	shards := []string{"0", "1", "2", "3"}
+	for _, shard := range shards {
+		s.MapTestConn(shard, &sandboxConn{})
+	}
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+	stc.streamParallelism = 2
+	if got := stc.streamLimit(nil); got != 2 {
+		t.Errorf("streamLimit(nil): %d, want 2", got)
+	}
+	session := NewSafeSession(&proto.Session{StreamParallelism: 1})
+	if got := stc.streamLimit(session); got != 1 {
+		t.Errorf("streamLimit: %d, want 1", got)
+	}
+
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	results, allErrors := stc.multiGoLimit(context.Background(), "StreamExecute", "TestScatterConnStreamParallelism", shards, "", nil, nil, noReserved, 2, func(sdc *ShardConn, transactionId int64, sResults chan<- interface{}) error {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		sResults <- sdc.shard
+		return nil
+	})
+	count := 0
+	for range results {
+		count++
+	}
+	if allErrors.HasErrors() {
+		t.Error(allErrors.Error())
+	}
+	if count != 4 {
+		t.Errorf("got %d results, want 4", count)
+	}
+	if maxActive != 2 {
+		t.Errorf("maxActive: %d, want 2", maxActive)
+	}
+
+	qr := new(mproto.QueryResult)
+	err := stc.StreamExecute(context.Background(), "query", nil, "TestScatterConnStreamParallelism", shards, "", session, func(r *mproto.QueryResult) error {
+		appendResult(qr, r)
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if len(qr.Rows) != 4 {
+		t.Errorf("got %d rows, want 4", len(qr.Rows))
+	}
+}
+
 func TestScatterConnCommitSuccess(t *testing.T) {
 	s := createSandbox("TestScatterConnCommitSuccess")
 	sbc0 := &sandboxConn{}
@@ -266,6 +367,97 @@ func TestScatterConnCommitSuccess(t *testing.T) {
 	}
 }
 
+func TestScatterConnCommitError(t *testing.T) {
+	s := createSandbox("TestScatterConnCommitError")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("1", sbc1)
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("2", sbc2)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+
+	// The shards are committed in order, whatever
+	// the order in which they joined the transaction.
+	session := NewSafeSession(&proto.Session{InTransaction: true})
+	for _, shard := range []string{"2", "0", "1"} {
+		stc.Execute(context.Background(), "query1", nil, "TestScatterConnCommitError", []string{shard}, "", session)
+	}
+	sbc1.mustFailServer = 1
+	err := stc.Commit(context.Background(), session)
+	commitErr, ok := err.(*CommitError)
+	if !ok {
+		t.Fatalf("Commit: %v, want *CommitError", err)
+	}
+	var got []string
+	for _, shard := range commitErr.Shards {
+		got = append(got, shard.Shard+": "+shard.Result)
+	}
+	want := []string{"0: committed", "1: failed", "2: rolled back"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CommitError.Shards: %v, want %v", got, want)
+	}
+	wantErr := "commit failed: TestScatterConnCommitError/0: committed, TestScatterConnCommitError/1: failed (shard, host: TestScatterConnCommitError.1., {Uid:0 Host:1 NamedPortMap:map[vt:1] Health:map[]}, error: err), TestScatterConnCommitError/2: rolled back"
+	if err.Error() != wantErr {
+		t.Errorf("Commit: %v, want %s", err, wantErr)
+	}
+	if sbc0.CommitCount.Get() != 1 || sbc1.CommitCount.Get() != 1 || sbc2.RollbackCount.Get() != 1 {
+		t.Errorf("commits: %d, %d, rollbacks: %d, want 1, 1, 1", sbc0.CommitCount.Get(), sbc1.CommitCount.Get(), sbc2.RollbackCount.Get())
+	}
+
+	// A shard that can't be reached may or may not have committed.
+	session = NewSafeSession(&proto.Session{InTransaction: true})
+	stc.Execute(context.Background(), "query1", nil, "TestScatterConnCommitError", []string{"0", "1"}, "", session)
+	sbc0.mustFailConn = 1
+	err = stc.Commit(context.Background(), session)
+	commitErr, ok = err.(*CommitError)
+	if !ok {
+		t.Fatalf("Commit: %v, want *CommitError", err)
+	}
+	if result := commitErr.Shards[0].Result; result != CommitUnknown {
+		t.Errorf("result: %s, want %s", result, CommitUnknown)
+	}
+}
+
+func TestScatterConnSingleShard(t *testing.T) {
+	s := createSandbox("TestScatterConnSingleShard")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+
+	session := NewSafeSession(&proto.Session{InTransaction: true, TransactionMode: proto.TxModeSingle})
+	_, err := stc.Execute(context.Background(), "query1", nil, "TestScatterConnSingleShard", []string{"0", "1"}, "", session)
+	want := "transaction_mode is single, but the transaction would span multiple shards: TestScatterConnSingleShard/0, TestScatterConnSingleShard/1"
+	if err == nil || err.Error() != want {
+		t.Errorf("Execute: %v, want %s", err, want)
+	}
+	if execCount := sbc0.ExecCount.Get() + sbc1.ExecCount.Get(); execCount != 0 {
+		t.Errorf("ExecCount: %d, want 0", execCount)
+	}
+
+	if _, err := stc.Execute(context.Background(), "query1", nil, "TestScatterConnSingleShard", []string{"0"}, "", session); err != nil {
+		t.Error(err)
+	}
+	if _, err := stc.Execute(context.Background(), "query1", nil, "TestScatterConnSingleShard", []string{"0"}, "", session); err != nil {
+		t.Error(err)
+	}
+	_, err = stc.Execute(context.Background(), "query1", nil, "TestScatterConnSingleShard", []string{"1"}, "", session)
+	if err == nil || err.Error() != want {
+		t.Errorf("Execute: %v, want %s", err, want)
+	}
+	if sbc1.ExecCount.Get() != 0 {
+		t.Errorf("sbc1.ExecCount: %d, want 0", sbc1.ExecCount.Get())
+	}
+
+	// Outside a transaction, multiple shards are allowed.
+	session = NewSafeSession(&proto.Session{TransactionMode: proto.TxModeSingle})
+	if _, err := stc.Execute(context.Background(), "query1", nil, "TestScatterConnSingleShard", []string{"0", "1"}, "", session); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestScatterConnRollback(t *testing.T) {
 	s := createSandbox("TestScatterConnRollback")
 	sbc0 := &sandboxConn{}
@@ -0,0 +1,138 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/timer"
+	"github.com/youtube/vitess/go/vt/concurrency"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"golang.org/x/net/context"
+)
+
+// trackedColumnsQuery returns the columns of the tables of the
+// database of a shard, in the order of their tables.
+const trackedColumnsQuery = columnsQuery + " order by table_name, ordinal_position"
+
+// SchemaTracker learns the columns of the tables of the keyspaces
+// of a router from their tablets, so that the planner can expand
+// the * expressions of joins, insert without a column list, and
+// report the unknown columns. The columns are read from the first
+// master shard of each keyspace, and read again every interval to
+// pick up the schema changes.
+type SchemaTracker struct {
+	router *Router
+	ticks  *timer.Timer
+
+	mu sync.Mutex
+	// columns are the columns that were last
+	// learned for each keyspace.
+	columns map[string]map[string][]planbuilder.Column
+}
+
+// NewSchemaTracker creates a SchemaTracker that learns the
+// columns of the tables every interval.
+func NewSchemaTracker(interval time.Duration, router *Router) *SchemaTracker {
+	return &SchemaTracker{
+		router:  router,
+		ticks:   timer.NewTimer(interval),
+		columns: make(map[string]map[string][]planbuilder.Column),
+	}
+}
+
+// Open starts learning the columns in the background.
+func (st *SchemaTracker) Open() {
+	st.ticks.Start(func() {
+		if err := st.Check(); err != nil {
+			log.Errorf("Could not learn the columns of the tables: %v", err)
+		}
+	})
+}
+
+// Close stops learning the columns.
+func (st *SchemaTracker) Close() {
+	st.ticks.Stop()
+}
+
+// Check learns the columns of the tables of the keyspaces, and
+// replaces the schema of the router if they changed. A keyspace
+// whose columns can't be read keeps the ones that were learned
+// before. The errors of those keyspaces are returned together.
+func (st *SchemaTracker) Check() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ctx := context.Background()
+	rec := concurrency.AllErrorRecorder{}
+	changed := make(map[string]map[string][]planbuilder.Column)
+	found := make(map[string]bool)
+	for _, keyspace := range st.router.keyspaces.names() {
+		found[keyspace] = true
+		columns, err := st.readColumns(ctx, keyspace)
+		if err != nil {
+			rec.RecordError(fmt.Errorf("keyspace %s: %v", keyspace, err))
+			continue
+		}
+		if previous, ok := st.columns[keyspace]; ok && reflect.DeepEqual(previous, columns) {
+			continue
+		}
+		st.columns[keyspace] = columns
+		changed[keyspace] = columns
+	}
+	for keyspace := range st.columns {
+		if !found[keyspace] {
+			delete(st.columns, keyspace)
+			changed[keyspace] = nil
+		}
+	}
+	version := st.router.planner.SchemaVersion()
+	st.router.keyspaces.updateColumns(changed, &rec)
+	if newVersion := st.router.planner.SchemaVersion(); newVersion != version {
+		log.Infof("Learned the columns of the tables, version %d", newVersion)
+	}
+	return rec.Error()
+}
+
+// readColumns returns the columns of the tables of keyspace,
+// as read from its first master shard.
+func (st *SchemaTracker) readColumns(ctx context.Context, keyspace string) (map[string][]planbuilder.Column, error) {
+	ks, allShards, err := getKeyspaceShards(ctx, st.router.serv, st.router.cell, keyspace, topo.TYPE_MASTER)
+	if err != nil {
+		return nil, err
+	}
+	if len(allShards) == 0 {
+		return nil, fmt.Errorf("no shards found for keyspace %s", keyspace)
+	}
+	qr, err := st.router.scatterConn.Execute(
+		ctx,
+		trackedColumnsQuery,
+		nil,
+		ks,
+		[]string{allShards[0].ShardName()},
+		topo.TYPE_MASTER,
+		NewSafeSession(nil))
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string][]planbuilder.Column)
+	for _, row := range qr.Rows {
+		if len(row) < 3 {
+			return nil, fmt.Errorf("unexpected row in the columns of keyspace %s: %v", keyspace, row)
+		}
+		table := row[0].String()
+		columns[table] = append(columns[table], planbuilder.Column{
+			Name: row[1].String(),
+			Type: row[2].String(),
+		})
+	}
+	return columns, nil
+}
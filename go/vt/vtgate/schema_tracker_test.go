@@ -0,0 +1,119 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// trackedColumnsResult builds the result of trackedColumnsQuery
+// from triplets of table, column and type.
+func trackedColumnsResult(columns ...string) *mproto.QueryResult {
+	qr := &mproto.QueryResult{
+		Fields: []mproto.Field{{"table_name", 253}, {"column_name", 253}, {"column_type", 253}},
+	}
+	for i := 0; i+2 < len(columns); i += 3 {
+		qr.Rows = append(qr.Rows, []sqltypes.Value{
+			{sqltypes.String(columns[i])},
+			{sqltypes.String(columns[i+1])},
+			{sqltypes.String(columns[i+2])},
+		})
+	}
+	qr.RowsAffected = uint64(len(qr.Rows))
+	return qr
+}
+
+func TestSchemaTracker(t *testing.T) {
+	createSandbox("TestRouter")
+	s := createSandbox(TEST_UNSHARDED)
+	sbc := &sandboxConn{}
+	s.MapTestConn("0", sbc)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	schema, err := planbuilder.BuildSchema(&planbuilder.SchemaFormal{
+		Keyspaces: map[string]planbuilder.KeyspaceFormal{
+			TEST_UNSHARDED: {
+				Tables:  map[string]planbuilder.TableFormal{"t1": {}, "t2": {}},
+				Aliases: map[string]string{"a1": "t1"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	st := NewSchemaTracker(1*time.Hour, router)
+
+	sbc.setResults([]*mproto.QueryResult{trackedColumnsResult(
+		"t1", "id", "bigint(20)",
+		"t1", "name", "varchar(64)",
+	)})
+	if err := st.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if sbc.Queries[0] != trackedColumnsQuery {
+		t.Errorf("queries: %v, want %s", sbc.Queries, trackedColumnsQuery)
+	}
+	if version := router.planner.SchemaVersion(); version != 1 {
+		t.Errorf("SchemaVersion: %d, want 1", version)
+	}
+	want := []planbuilder.Column{{Name: "id", Type: "bigint(20)"}, {Name: "name", Type: "varchar(64)"}}
+	t1, _ := router.planner.Schema().FindTable("t1")
+	if t1 == nil || !reflect.DeepEqual(t1.Columns, want) {
+		t.Errorf("FindTable(t1): %v, want columns %v", t1, want)
+	}
+	if a1, _ := router.planner.Schema().FindTable("a1"); a1 != t1 {
+		t.Errorf("FindTable(a1): %v, want the table t1", a1)
+	}
+	if t2, _ := router.planner.Schema().FindTable("t2"); t2 == nil || t2.HasColumns() {
+		t.Errorf("FindTable(t2): %v, want a table without columns", t2)
+	}
+
+	// The columns didn't change.
+	sbc.setResults([]*mproto.QueryResult{trackedColumnsResult(
+		"t1", "id", "bigint(20)",
+		"t1", "name", "varchar(64)",
+	)})
+	if err := st.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if version := router.planner.SchemaVersion(); version != 1 {
+		t.Errorf("SchemaVersion: %d, want 1", version)
+	}
+
+	// A keyspace whose columns can't be read keeps its columns.
+	sbc.mustFailServer = 1
+	if err := st.Check(); err == nil {
+		t.Errorf("Check with a failing tablet: nil, want error")
+	}
+	if t1, _ := router.planner.Schema().FindTable("t1"); !t1.HasColumns() {
+		t.Errorf("the columns of t1 were lost after a failed check")
+	}
+
+	// The columns are kept when the schema is replaced.
+	router.SetSchema(schema)
+	if t1, _ := router.planner.Schema().FindTable("t1"); !t1.HasColumns() {
+		t.Errorf("the columns of t1 were lost after SetSchema")
+	}
+
+	// A new column is picked up.
+	sbc.setResults([]*mproto.QueryResult{trackedColumnsResult(
+		"t1", "id", "bigint(20)",
+		"t1", "name", "varchar(64)",
+		"t1", "email", "varchar(64)",
+	)})
+	if err := st.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if t1, _ := router.planner.Schema().FindTable("t1"); !t1.FindColumn("email") {
+		t.Errorf("FindTable(t1): %v, want column email", t1)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/jscfg"
+	"github.com/youtube/vitess/go/timer"
+	"github.com/youtube/vitess/go/vt/concurrency"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// SchemaWatcher reloads the schema of a Router from its file
+// when the file is modified. A file that can't be read is ignored,
+// and the Router keeps the previous schema. Otherwise, only the
+// keyspaces that changed are built again: a keyspace that can't
+// be built keeps its previous schema, while the changes to the
+// other keyspaces are applied.
+type SchemaWatcher struct {
+	filename string
+	router   *Router
+	ticks    *timer.Timer
+
+	mu      sync.Mutex
+	modTime time.Time
+	// keyspaces are the keyspaces that were last read.
+	keyspaces map[string]planbuilder.KeyspaceFormal
+	// loaded is true once the schema was loaded.
+	loaded bool
+}
+
+// NewSchemaWatcher creates a SchemaWatcher that checks filename
+// every interval. The schema of router must have been loaded
+// from filename.
+func NewSchemaWatcher(filename string, interval time.Duration, router *Router) *SchemaWatcher {
+	sw := &SchemaWatcher{
+		filename: filename,
+		router:   router,
+		ticks:    timer.NewTimer(interval),
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return sw
+	}
+	var source planbuilder.SchemaFormal
+	if err := jscfg.ReadJson(filename, &source); err != nil {
+		return sw
+	}
+	sw.modTime = fi.ModTime()
+	sw.keyspaces = source.Keyspaces
+	sw.loaded = true
+	return sw
+}
+
+// Open starts checking the file in the background.
+func (sw *SchemaWatcher) Open() {
+	sw.ticks.Start(func() {
+		if err := sw.Check(); err != nil {
+			log.Errorf("Could not reload the schema from %s: %v", sw.filename, err)
+		}
+	})
+}
+
+// Close stops checking the file.
+func (sw *SchemaWatcher) Close() {
+	sw.ticks.Stop()
+}
+
+// Check reloads the schema if the file was modified since
+// it was last loaded. The errors of the keyspaces that were
+// rejected are returned together.
+func (sw *SchemaWatcher) Check() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	fi, err := os.Stat(sw.filename)
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(sw.modTime) {
+		return nil
+	}
+	// The file is not loaded again until it's modified,
+	// even if it's invalid.
+	sw.modTime = fi.ModTime()
+	var source planbuilder.SchemaFormal
+	if err := jscfg.ReadJson(sw.filename, &source); err != nil {
+		return err
+	}
+	rec := concurrency.AllErrorRecorder{}
+	changed := make(map[string]*planbuilder.KeyspaceSchema)
+	for name, ks := range source.Keyspaces {
+		if previous, ok := sw.keyspaces[name]; ok && reflect.DeepEqual(previous, ks) {
+			continue
+		}
+		keyspace, err := planbuilder.BuildKeyspaceSchema(name, ks)
+		if err != nil {
+			rec.RecordError(fmt.Errorf("keyspace %s: %v", name, err))
+			continue
+		}
+		changed[name] = keyspace
+	}
+	for name := range sw.keyspaces {
+		if _, ok := source.Keyspaces[name]; !ok {
+			changed[name] = nil
+		}
+	}
+	sw.keyspaces = source.Keyspaces
+	version := sw.router.planner.SchemaVersion()
+	sw.router.keyspaces.update(changed, false, !sw.loaded, &rec)
+	sw.loaded = true
+	if newVersion := sw.router.planner.SchemaVersion(); newVersion != version {
+		log.Infof("Reloaded the schema from %s, version %d", sw.filename, newVersion)
+	}
+	return rec.Error()
+}
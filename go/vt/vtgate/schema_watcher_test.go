@@ -0,0 +1,130 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+func TestSchemaWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "schema_watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data, err := ioutil.ReadFile(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := path.Join(dir, "schema.json")
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := planbuilder.LoadSchemaJSON(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := NewRouter(new(sandboxTopo), "aa", schema, "", nil)
+	sw := NewSchemaWatcher(filename, 1*time.Hour, router)
+
+	// The file was not modified.
+	if err := sw.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if version := router.planner.SchemaVersion(); version != 0 {
+		t.Errorf("SchemaVersion: %d, want 0", version)
+	}
+
+	// An invalid schema is ignored.
+	modTime := time.Now().Add(1 * time.Minute)
+	if err := ioutil.WriteFile(filename, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(filename, modTime, modTime)
+	if err := sw.Check(); err == nil {
+		t.Errorf("Check with an invalid schema: nil, want error")
+	}
+	if router.planner.Schema() != schema {
+		t.Errorf("Schema was replaced by an invalid schema")
+	}
+
+	// Only the keyspaces that changed are built again.
+	var source planbuilder.SchemaFormal
+	if err := json.Unmarshal(data, &source); err != nil {
+		t.Fatal(err)
+	}
+	source.Keyspaces[TEST_UNSHARDED].Tables["main1"] = planbuilder.TableFormal{}
+	modTime = modTime.Add(1 * time.Minute)
+	writeSchemaFile(t, filename, &source, modTime)
+	if err := sw.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if router.planner.Schema() == schema {
+		t.Errorf("Schema was not reloaded")
+	}
+	if version := router.planner.SchemaVersion(); version != 1 {
+		t.Errorf("SchemaVersion: %d, want 1", version)
+	}
+	if table, _ := router.planner.Schema().FindTable("main1"); table == nil {
+		t.Errorf("main1 not found in the reloaded schema")
+	}
+
+	// A keyspace that can't be built keeps its previous schema,
+	// and the other keyspaces are still reloaded.
+	source.Keyspaces["TestRouter"].Vindexes["user_index"] = planbuilder.VindexFormal{Type: "no_such_type"}
+	source.Keyspaces[TEST_UNSHARDED].Tables["main2"] = planbuilder.TableFormal{}
+	modTime = modTime.Add(1 * time.Minute)
+	writeSchemaFile(t, filename, &source, modTime)
+	if err := sw.Check(); err == nil || !strings.Contains(err.Error(), "keyspace TestRouter") {
+		t.Errorf("Check with an invalid keyspace: %v, want keyspace TestRouter error", err)
+	}
+	if version := router.planner.SchemaVersion(); version != 2 {
+		t.Errorf("SchemaVersion: %d, want 2", version)
+	}
+	if table, _ := router.planner.Schema().FindTable("main2"); table == nil {
+		t.Errorf("main2 not found in the reloaded schema")
+	}
+	if table, _ := router.planner.Schema().FindTable("user"); table == nil || table.ColVindexes[0].Type != "hash" {
+		t.Errorf("FindTable(user): %v, want the previous table", table)
+	}
+
+	// A table defined by two keyspaces is ambiguous.
+	source.Keyspaces["TestRouter"] = planbuilder.KeyspaceFormal{
+		Tables: map[string]planbuilder.TableFormal{"main1": {}},
+	}
+	modTime = modTime.Add(1 * time.Minute)
+	writeSchemaFile(t, filename, &source, modTime)
+	if err := sw.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if version := router.planner.SchemaVersion(); version != 3 {
+		t.Errorf("SchemaVersion: %d, want 3", version)
+	}
+	if table, reason := router.planner.Schema().FindTable("main1"); table != nil || !strings.Contains(reason, "ambiguous") {
+		t.Errorf("FindTable(main1): %v, %s, want an ambiguous table", table, reason)
+	}
+	if table, _ := router.planner.Schema().InKeyspace(TEST_UNSHARDED).FindTable("main1"); table == nil || table.Keyspace.Name != TEST_UNSHARDED {
+		t.Errorf("FindTable(main1) in %s: %v, want a table of %s", TEST_UNSHARDED, table, TEST_UNSHARDED)
+	}
+}
+
+func writeSchemaFile(t *testing.T, filename string, source *planbuilder.SchemaFormal, modTime time.Time) {
+	data, err := json.Marshal(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(filename, modTime, modTime)
+}
@@ -0,0 +1,152 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// The queries that reserve a block of values of a sequence table.
+// They are executed in a transaction of their own, so that the
+// row is locked only while the block is reserved.
+const (
+	sequenceSelect = "select next_id, cache from %s where id = 0 for update"
+	sequenceUpdate = "update %s set next_id = next_id + cache where id = 0"
+)
+
+// sequenceCache holds the values that a vtgate reserved from
+// the sequence tables, but did not hand out yet.
+type sequenceCache struct {
+	mu     sync.Mutex
+	blocks map[string]*sequenceBlock
+}
+
+// sequenceBlock is the range [next, end) of reserved values of
+// a sequence. mu is held while a new block is reserved, so that
+// concurrent inserts wait for it instead of reserving their own.
+type sequenceBlock struct {
+	mu        sync.Mutex
+	next, end int64
+}
+
+func newSequenceCache() *sequenceCache {
+	return &sequenceCache{blocks: make(map[string]*sequenceBlock)}
+}
+
+// block returns the block of the sequence table of keyspace.
+func (sc *sequenceCache) block(keyspace, table string) *sequenceBlock {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	name := keyspace + "." + table
+	block, ok := sc.blocks[name]
+	if !ok {
+		block = &sequenceBlock{}
+		sc.blocks[name] = block
+	}
+	return block
+}
+
+// handleGenerate resolves the value of the auto-increment column
+// of an InsertSharded, and stores it in bv as SeqVarName. If the
+// insert supplies no value, the next value of the sequence is used,
+// and returned as generated.
+func (rtr *Router) handleGenerate(vcursor *requestContext, gen *planbuilder.Generate, bv map[string]interface{}) (generated int64, err error) {
+	keys, err := rtr.resolveKeys([]interface{}{gen.Value}, bv)
+	if err != nil {
+		return 0, err
+	}
+	val := keys[0]
+	if val == nil {
+		generated, err = rtr.nextSequenceValue(vcursor, gen.Sequence)
+		if err != nil {
+			return 0, err
+		}
+		val = generated
+	}
+	bv[planbuilder.SeqVarName] = val
+	return generated, nil
+}
+
+// nextSequenceValue returns the next value of the sequence table
+// name. The values are taken from the block reserved by this vtgate,
+// and a new block is reserved when it runs out.
+func (rtr *Router) nextSequenceValue(vcursor *requestContext, name string) (int64, error) {
+	table, reason := rtr.planner.Schema().FindTable(name)
+	if reason != "" {
+		return 0, fmt.Errorf("sequence %s: %s", name, reason)
+	}
+	if !table.IsSequence {
+		return 0, fmt.Errorf("table %s is not a sequence", name)
+	}
+	table, err := planbuilder.TenantTable(table, sessionTenant(vcursor.query.Session))
+	if err != nil {
+		return 0, err
+	}
+	block := rtr.sequences.block(table.Keyspace.Name, table.Name)
+	block.mu.Lock()
+	defer block.mu.Unlock()
+	if block.next >= block.end {
+		next, cache, err := rtr.reserveSequence(vcursor, table)
+		if err != nil {
+			return 0, err
+		}
+		block.next, block.end = next, next+cache
+	}
+	val := block.next
+	block.next++
+	return val, nil
+}
+
+// reserveSequence reserves the next block of values of the
+// sequence table on the master of its keyspace. It returns the
+// first value of the block, and the number of values in it.
+func (rtr *Router) reserveSequence(vcursor *requestContext, table *planbuilder.Table) (next, cache int64, err error) {
+	ks, allShards, err := getKeyspaceShards(vcursor.ctx, rtr.serv, rtr.cell, table.Keyspace.Name, topo.TYPE_MASTER)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(allShards) != 1 {
+		return 0, 0, fmt.Errorf("unsharded keyspace %s has multiple shards: %+v", ks, allShards)
+	}
+	qrs, err := rtr.scatterConn.ExecuteBatch(
+		vcursor.ctx,
+		[]tproto.BoundQuery{
+			{Sql: "begin"},
+			{Sql: fmt.Sprintf(sequenceSelect, table.Name)},
+			{Sql: fmt.Sprintf(sequenceUpdate, table.Name)},
+			{Sql: "commit"},
+		},
+		ks,
+		[]string{allShards[0].ShardName()},
+		topo.TYPE_MASTER,
+		NewSafeSession(nil))
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(qrs.List) != 4 {
+		return 0, 0, fmt.Errorf("sequence %s: unexpected number of results: %d", table.Name, len(qrs.List))
+	}
+	result := qrs.List[1]
+	if len(result.Rows) != 1 || len(result.Rows[0]) != 2 {
+		return 0, 0, fmt.Errorf("sequence %s must have a single row with next_id and cache", table.Name)
+	}
+	next, err = strconv.ParseInt(result.Rows[0][0].String(), 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("sequence %s has an invalid next_id: %v", table.Name, err)
+	}
+	cache, err = strconv.ParseInt(result.Rows[0][1].String(), 10, 64)
+	if err != nil || cache < 1 {
+		return 0, 0, fmt.Errorf("sequence %s has an invalid cache: %v", table.Name, result.Rows[0][1])
+	}
+	return next, cache, nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// sequenceResults returns the results of the batch
+// that reserves a block of a sequence.
+func sequenceResults(next, cache string) []*mproto.QueryResult {
+	return []*mproto.QueryResult{
+		{},
+		{
+			Fields: []mproto.Field{
+				{"next_id", mproto.VT_LONGLONG},
+				{"cache", mproto.VT_LONGLONG},
+			},
+			RowsAffected: 1,
+			Rows: [][]sqltypes.Value{{
+				sqltypes.MakeNumeric([]byte(next)),
+				sqltypes.MakeNumeric([]byte(cache)),
+			}},
+		},
+		{RowsAffected: 1},
+		{},
+	}
+}
+
+func TestInsertSequence(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	sbclookup.setResults(sequenceResults("10", "2"))
+	q := proto.Query{
+		Sql:        "insert into sales(user_id, amount) values (1, 2)",
+		TabletType: topo.TYPE_MASTER,
+	}
+	result, err := router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantQueries := []string{
+		"begin",
+		"select next_id, cache from user_seq where id = 0 for update",
+		"update user_seq set next_id = next_id + cache where id = 0",
+		"commit",
+	}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %q, want %q", sbclookup.Queries, wantQueries)
+	}
+	wantQuery := "insert into sales(user_id, amount, id) values (:_user_id, 2, :__seq)"
+	if sbc1.Queries[0] != wantQuery {
+		t.Errorf("sbc1.Queries[0]: %q, want %q", sbc1.Queries[0], wantQuery)
+	}
+	if got := sbc1.BindVars[0]["__seq"]; got != int64(10) {
+		t.Errorf("__seq: %#v, want 10", got)
+	}
+	if result.InsertId != 10 {
+		t.Errorf("InsertId: %d, want 10", result.InsertId)
+	}
+
+	// The second value comes from the cached block.
+	sbclookup.Queries = nil
+	result, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sbclookup.Queries != nil {
+		t.Errorf("sbclookup.Queries: %q, want none", sbclookup.Queries)
+	}
+	if got := sbc1.BindVars[1]["__seq"]; got != int64(11) {
+		t.Errorf("__seq: %#v, want 11", got)
+	}
+	if result.InsertId != 11 {
+		t.Errorf("InsertId: %d, want 11", result.InsertId)
+	}
+
+	// The block is used up, and another one is reserved.
+	sbclookup.setResults(sequenceResults("20", "2"))
+	result, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %q, want %q", sbclookup.Queries, wantQueries)
+	}
+	if result.InsertId != 20 {
+		t.Errorf("InsertId: %d, want 20", result.InsertId)
+	}
+
+	// A supplied value is used as is.
+	sbclookup.Queries = nil
+	q.Sql = "insert into sales(id, user_id, amount) values (5, 1, 2)"
+	result, err = router.Execute(context.Background(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sbclookup.Queries != nil {
+		t.Errorf("sbclookup.Queries: %q, want none", sbclookup.Queries)
+	}
+	if got := sbc1.BindVars[3]["__seq"]; got != int64(5) {
+		t.Errorf("__seq: %#v, want 5", got)
+	}
+	if result.InsertId != 0 {
+		t.Errorf("InsertId: %d, want 0", result.InsertId)
+	}
+	if sbc2.ExecCount != 0 {
+		t.Errorf("sbc2.ExecCount: %v, want 0", sbc2.ExecCount)
+	}
+}
+
+func TestInsertSequenceFail(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("-20", sbc)
+
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	sbclookup.setResults([]*mproto.QueryResult{{}, {}, {}, {}})
+	q := proto.Query{
+		Sql:        "insert into sales(user_id, amount) values (1, 2)",
+		TabletType: topo.TYPE_MASTER,
+	}
+	_, err = router.Execute(context.Background(), &q)
+	want := "sequence user_seq must have a single row with next_id and cache"
+	if err == nil || err.Error() != want {
+		t.Errorf("router.Execute: %v, want %s", err, want)
+	}
+
+	sbclookup.setResults(sequenceResults("10", "0"))
+	_, err = router.Execute(context.Background(), &q)
+	want = "sequence user_seq has an invalid cache: 0"
+	if err == nil || err.Error() != want {
+		t.Errorf("router.Execute: %v, want %s", err, want)
+	}
+	if sbc.ExecCount != 0 {
+		t.Errorf("sbc.ExecCount: %v, want 0", sbc.ExecCount)
+	}
+}
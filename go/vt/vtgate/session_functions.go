@@ -0,0 +1,65 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"strconv"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+// The shards of a session only know about the statements they
+// executed, so LAST_INSERT_ID() and FOUND_ROWS() are answered by
+// vtgate from the session, which records the last id generated for
+// an insert, by the shards or by a vindex, and the number of rows
+// of the last select. Only the statements of the session are
+// tracked, not the vindex lookups and subqueries they execute.
+
+// trackResult records the result of a query of session with plan:
+// the number of rows of a select, and the id generated by an
+// insert, if any.
+func trackResult(session *proto.Session, plan *planbuilder.Plan, rowCount int64, insertID uint64) {
+	if session == nil {
+		return
+	}
+	if plan.IsSelect() {
+		session.FoundRows = uint64(rowCount)
+	}
+	if insertID != 0 {
+		session.LastInsertId = insertID
+	}
+}
+
+// execSessionSelect returns the values of the session functions
+// funcs in a single row. Like in MySQL, the select itself counts as
+// a select of one row for the next FOUND_ROWS().
+func (rtr *Router) execSessionSelect(vcursor *requestContext, funcs []sqlparser.SessionFunction) (*mproto.QueryResult, error) {
+	session := vcursor.query.Session
+	if session == nil {
+		session = new(proto.Session)
+		vcursor.query.Session = session
+	}
+	result := &mproto.QueryResult{
+		Fields:       make([]mproto.Field, len(funcs)),
+		Rows:         [][]sqltypes.Value{make([]sqltypes.Value, len(funcs))},
+		RowsAffected: 1,
+	}
+	for i, f := range funcs {
+		value := session.LastInsertId
+		if f.Name == sqlparser.FoundRows {
+			value = session.FoundRows
+		}
+		result.Fields[i] = mproto.Field{Name: f.Column, Type: mproto.VT_LONGLONG}
+		result.Rows[0][i] = sqltypes.MakeNumeric([]byte(strconv.FormatUint(value, 10)))
+	}
+	session.FoundRows = 1
+	return result, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestRouterSessionFunctions(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("-20", sbc)
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	ctx := context.Background()
+
+	sessionSelect := func(q *proto.Query) [][]sqltypes.Value {
+		count := sbc.ExecCount.Get() + sbclookup.ExecCount.Get()
+		q.Sql = "select last_insert_id(), found_rows() as n from dual"
+		result, err := router.Execute(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sbc.ExecCount.Get() + sbclookup.ExecCount.Get(); got != count {
+			t.Errorf("ExecCount: %d more, want the select to stay in vtgate", got-count)
+		}
+		wantFields := []mproto.Field{
+			{"last_insert_id()", mproto.VT_LONGLONG},
+			{"n", mproto.VT_LONGLONG},
+		}
+		if !reflect.DeepEqual(result.Fields, wantFields) {
+			t.Errorf("Fields: %v, want %v", result.Fields, wantFields)
+		}
+		return result.Rows
+	}
+	row := func(lastInsertID, foundRows string) [][]sqltypes.Value {
+		return [][]sqltypes.Value{{
+			sqltypes.MakeNumeric([]byte(lastInsertID)),
+			sqltypes.MakeNumeric([]byte(foundRows)),
+		}}
+	}
+
+	// The id of user is generated by its lookup vindex.
+	sbclookup.setResults([]*mproto.QueryResult{{RowsAffected: 1, InsertId: 1}})
+	sbc.setResults([]*mproto.QueryResult{{RowsAffected: 1}})
+	q := &proto.Query{
+		Sql:        "insert into user(v, name) values (2, 'myname')",
+		TabletType: topo.TYPE_MASTER,
+		Session:    &proto.Session{},
+	}
+	if _, err := router.Execute(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sessionSelect(q), row("1", "0"); !reflect.DeepEqual(got, want) {
+		t.Errorf("after insert: %v, want %v", got, want)
+	}
+
+	// The session select counts as a select of one row.
+	if got, want := sessionSelect(q), row("1", "1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("after session select: %v, want %v", got, want)
+	}
+
+	sbc.setResults([]*mproto.QueryResult{{
+		Fields:       singleRowResult.Fields,
+		RowsAffected: 2,
+		Rows:         [][]sqltypes.Value{singleRowResult.Rows[0], singleRowResult.Rows[0]},
+	}})
+	q.Sql = "select * from user where id = 1"
+	if _, err := router.Execute(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sessionSelect(q), row("1", "2"); !reflect.DeepEqual(got, want) {
+		t.Errorf("after select: %v, want %v", got, want)
+	}
+
+	// The vindex lookups of a statement don't change the results
+	// of the session: the update looks up the keyspace id of its
+	// music id with a select of one row.
+	sbc.setResults([]*mproto.QueryResult{{
+		Fields:       singleRowResult.Fields,
+		RowsAffected: 2,
+		Rows:         [][]sqltypes.Value{singleRowResult.Rows[0], singleRowResult.Rows[0]},
+	}})
+	q.Sql = "select * from user where id = 1"
+	if _, err := router.Execute(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+	count := sbclookup.ExecCount.Get()
+	q.Sql = "update music set a = 1 where id = 1"
+	if _, err := router.Execute(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+	if got := sbclookup.ExecCount.Get(); got != count+1 {
+		t.Errorf("lookup ExecCount: %d more, want 1", got-count)
+	}
+	if got, want := sessionSelect(q), row("1", "2"); !reflect.DeepEqual(got, want) {
+		t.Errorf("after update: %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// A session can limit its own queries with the variables of vtgate,
+// which are set like the system variables but never reach the
+// shards: SET @@vitess_query_timeout = N limits each query to N
+// milliseconds, and SET @@vitess_max_rows = N limits the rows the
+// shards can return for each query. 0 removes a limit.
+
+// limitVariables are the variables that limit the queries of a
+// session, with the function that records their value in it.
+var limitVariables = map[string]func(session *proto.Session, value int64){
+	"vitess_query_timeout": func(session *proto.Session, value int64) {
+		session.QueryTimeout = int64(time.Duration(value) * time.Millisecond)
+	},
+	"vitess_max_rows": func(session *proto.Session, value int64) {
+		session.MaxRows = value
+	},
+}
+
+// limitValue returns the value expr assigns to the limit
+// variable name, which must be a non-negative integer.
+func limitValue(name string, expr sqlparser.ValExpr) (int64, error) {
+	if value, ok := literalValue(expr); ok {
+		if value, ok := value.(int64); ok {
+			return value, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid value for session variable %s: %s", name, sqlparser.String(expr))
+}
+
+// withQueryTimeout returns the context of a query of session, which
+// expires after the query timeout of the session if it has one. The
+// returned function must be called once the query is done.
+func withQueryTimeout(ctx context.Context, session *proto.Session) (context.Context, context.CancelFunc) {
+	if session == nil || session.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(session.QueryTimeout))
+}
+
+// maxRows returns the maximum number of rows the shards can return
+// for a query of session: the max rows of the session if it's lower
+// than the limit of stc.
+func (stc *ScatterConn) maxRows(session *SafeSession) int {
+	if session == nil || session.Session == nil || session.MaxRows <= 0 {
+		return stc.maxResultRows
+	}
+	if stc.maxResultRows != 0 && int64(stc.maxResultRows) < session.MaxRows {
+		return stc.maxResultRows
+	}
+	return int(session.MaxRows)
+}
@@ -0,0 +1,123 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestRouterSessionLimits(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("-20", sbc)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Millisecond, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	ctx := context.Background()
+
+	q := &proto.Query{
+		Sql:        "set @@vitess_query_timeout = 50, @@session.vitess_max_rows = 1",
+		TabletType: topo.TYPE_MASTER,
+	}
+	if _, err := router.Execute(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+	if sbc.ExecCount.Get() != 0 {
+		t.Errorf("ExecCount: %d, want the SET to stay in vtgate", sbc.ExecCount.Get())
+	}
+	if got, want := time.Duration(q.Session.QueryTimeout), 50*time.Millisecond; got != want {
+		t.Errorf("QueryTimeout: %v, want %v", got, want)
+	}
+	if q.Session.MaxRows != 1 {
+		t.Errorf("MaxRows: %d, want 1", q.Session.MaxRows)
+	}
+	if len(q.Session.SystemVariables) != 0 || len(q.Session.ReservedSessions) != 0 {
+		t.Errorf("session: %v, want no system variables", q.Session)
+	}
+
+	q.Sql = "select * from user where id = 1"
+	if _, err := router.Execute(ctx, q); err != nil {
+		t.Errorf("query of 1 row: %v, want nil", err)
+	}
+	twoRows := &mproto.QueryResult{
+		Fields:       singleRowResult.Fields,
+		RowsAffected: 2,
+		Rows:         [][]sqltypes.Value{singleRowResult.Rows[0], singleRowResult.Rows[0]},
+	}
+	sbc.setResults([]*mproto.QueryResult{twoRows})
+	want := &ResultLimitError{Unit: "rows", Limit: 1}
+	if _, err := router.Execute(ctx, q); !reflect.DeepEqual(err, want) {
+		t.Errorf("query of 2 rows: %v, want %v", err, want)
+	}
+
+	// 0 removes the limit.
+	q.Sql = "set @@vitess_max_rows = 0"
+	if _, err := router.Execute(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+	q.Sql = "select * from user where id = 1"
+	sbc.setResults([]*mproto.QueryResult{twoRows})
+	if _, err := router.Execute(ctx, q); err != nil {
+		t.Errorf("query of 2 rows without limit: %v, want nil", err)
+	}
+
+	q.Sql = "set @@vitess_max_rows = 'a'"
+	wantErr := "invalid value for session variable vitess_max_rows: 'a'"
+	if _, err := router.Execute(ctx, q); err == nil || err.Error() != wantErr {
+		t.Errorf("%s: %v, want %s", q.Sql, err, wantErr)
+	}
+}
+
+func TestWithQueryTimeout(t *testing.T) {
+	ctx, cancel := withQueryTimeout(context.Background(), &proto.Session{})
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Errorf("deadline without query timeout, want none")
+	}
+
+	ctx, cancel = withQueryTimeout(context.Background(), &proto.Session{QueryTimeout: int64(time.Minute)})
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	if !ok || deadline.After(time.Now().Add(time.Minute)) {
+		t.Errorf("deadline: %v, want within a minute", deadline)
+	}
+}
+
+func TestScatterConnMaxRows(t *testing.T) {
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+	for _, tcase := range []struct {
+		flag    int
+		session int64
+		want    int
+	}{
+		{0, 0, 0},
+		{10, 0, 10},
+		{0, 5, 5},
+		{10, 5, 5},
+		{10, 20, 10},
+	} {
+		stc.maxResultRows = tcase.flag
+		session := NewSafeSession(&proto.Session{MaxRows: tcase.session})
+		if got := stc.maxRows(session); got != tcase.want {
+			t.Errorf("maxRows(flag %d, session %d): %d, want %d", tcase.flag, tcase.session, got, tcase.want)
+		}
+	}
+	if got := stc.maxRows(nil); got != 10 {
+		t.Errorf("maxRows(nil): %d, want 10", got)
+	}
+}
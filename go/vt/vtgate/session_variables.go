@@ -0,0 +1,258 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+// The SET statements of the V3 API change the variables of the
+// session instead of being sent to the shards. The system variables
+// are set on the connection reserved for the session on each shard:
+// once a session has some, its queries execute on its reserved
+// connections, except the streaming ones. The user variables stay in
+// vtgate, and the queries of the session read them as bind vars.
+
+// variableKind is the kind of literal a system variable accepts.
+type variableKind int
+
+const (
+	stringVariable variableKind = iota
+	intVariable
+)
+
+// systemVariables are the system variables a session can set.
+var systemVariables = map[string]variableKind{
+	"sql_mode":     stringVariable,
+	"time_zone":    stringVariable,
+	"wait_timeout": intVariable,
+}
+
+// unsafeVariables are the system variables that vtgate and vttablet
+// rely on, with the reason they cannot be set.
+var unsafeVariables = map[string]string{
+	"autocommit":   "use the Autocommit option of the session instead",
+	"sql_log_bin":  "the writes would not be replicated",
+	"tx_isolation": "use SET TRANSACTION ISOLATION LEVEL instead",
+	"tx_read_only": "use START TRANSACTION READ ONLY instead",
+}
+
+// userVariablePrefix is the prefix of the names of the bind
+// vars that replace the user variables in the queries.
+const userVariablePrefix = "_vtu_"
+
+// setVariables counts the variables set by the sessions, by name.
+// The user variables are counted as "user".
+var setVariables = stats.NewCounters("VtgateSetVariables")
+
+// variableName returns the lower-case name of the variable col
+// assigns, without its @ or @@. user is true for a user variable.
+func variableName(col *sqlparser.ColName) (name string, user bool, err error) {
+	name = strings.ToLower(string(col.Name))
+	switch strings.ToLower(string(col.Qualifier)) {
+	case "":
+	case "@@session", "@@local":
+		return name, false, nil
+	case "@@global":
+		return "", false, fmt.Errorf("global variable %s cannot be set through vtgate", name)
+	default:
+		return "", false, fmt.Errorf("unsupported variable: %s", sqlparser.String(col))
+	}
+	if strings.HasPrefix(name, "@@") {
+		return name[2:], false, nil
+	}
+	if strings.HasPrefix(name, "@") {
+		return name[1:], true, nil
+	}
+	return name, false, nil
+}
+
+// systemValue returns the sql literal expr assigns to the system
+// variable name, or an error if name cannot be set to it.
+func systemValue(name string, expr sqlparser.ValExpr) (string, error) {
+	if reason, ok := unsafeVariables[name]; ok {
+		return "", fmt.Errorf("session variable %s cannot be set: %s", name, reason)
+	}
+	kind, ok := systemVariables[name]
+	if !ok {
+		return "", fmt.Errorf("unsupported session variable: %s", name)
+	}
+	switch expr := expr.(type) {
+	case sqlparser.StrVal:
+		if kind == stringVariable {
+			return sqlparser.String(expr), nil
+		}
+	case sqlparser.NumVal:
+		if _, ok := literalValue(expr); ok && kind == intVariable {
+			return sqlparser.String(expr), nil
+		}
+	}
+	return "", fmt.Errorf("invalid value for session variable %s: %s", name, sqlparser.String(expr))
+}
+
+// userValue returns the bind var value expr assigns to the user
+// variable name. It can be a literal, null or a bind var.
+func userValue(name string, expr sqlparser.ValExpr, bindVars map[string]interface{}) (interface{}, error) {
+	switch expr := expr.(type) {
+	case *sqlparser.NullVal:
+		return nil, nil
+	case sqlparser.ValArg:
+		value, ok := bindVars[string(expr[1:])]
+		if !ok {
+			return nil, fmt.Errorf("missing bind var %s", expr[1:])
+		}
+		return value, nil
+	}
+	if value, ok := literalValue(expr); ok {
+		return value, nil
+	}
+	return nil, fmt.Errorf("user variable @%s can only be set to a constant: %s", name, sqlparser.String(expr))
+}
+
+// execSet records the variables set assigns in the session. The
+// system variables are also set on the reserved connections of
+// the session, and the session is only changed if that succeeded.
+// The limit variables of vtgate are only recorded in the session.
+// Nothing is changed if one of the variables cannot be set.
+func (rtr *Router) execSet(vcursor *requestContext, set *sqlparser.Set) (*mproto.QueryResult, error) {
+	system := make(map[string]string)
+	user := make(map[string]interface{})
+	limits := make(map[string]int64)
+	for _, expr := range set.Exprs {
+		name, isUser, err := variableName(expr.Name)
+		if err != nil {
+			return nil, err
+		}
+		if isUser {
+			value, err := userValue(name, expr.Expr, vcursor.query.BindVariables)
+			if err != nil {
+				return nil, err
+			}
+			user[name] = value
+			continue
+		}
+		if _, ok := limitVariables[name]; ok {
+			value, err := limitValue(name, expr.Expr)
+			if err != nil {
+				return nil, err
+			}
+			limits[name] = value
+			continue
+		}
+		value, err := systemValue(name, expr.Expr)
+		if err != nil {
+			return nil, err
+		}
+		system[name] = value
+	}
+	if vcursor.query.Session == nil {
+		vcursor.query.Session = new(proto.Session)
+	}
+	session := vcursor.query.Session
+	if len(system) != 0 {
+		if session.InTransaction {
+			return nil, errors.New("session variables can't be changed while a transaction is in progress")
+		}
+		query := setVariablesQuery(system)
+		for _, shardSession := range session.ReservedSessions {
+			sdc := rtr.scatterConn.getConnection(vcursor.ctx, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+			if _, err := sdc.Execute(vcursor.ctx, query, nil, shardSession.TransactionId); err != nil {
+				return nil, fmt.Errorf("cannot set the session variables on %s/%s: %v", shardSession.Keyspace, shardSession.Shard, err)
+			}
+		}
+		if session.SystemVariables == nil {
+			session.SystemVariables = make(map[string]string)
+		}
+		for name, value := range system {
+			session.SystemVariables[name] = value
+			setVariables.Add(name, 1)
+		}
+	}
+	if len(user) != 0 && session.UserVariables == nil {
+		session.UserVariables = make(map[string]interface{})
+	}
+	for name, value := range user {
+		session.UserVariables[name] = value
+		setVariables.Add("user", 1)
+	}
+	for name, value := range limits {
+		limitVariables[name](session, value)
+		setVariables.Add(name, 1)
+	}
+	return &mproto.QueryResult{}, nil
+}
+
+// setVariablesQuery returns the SET statement that assigns the
+// system variables, in the order of their names.
+func setVariablesQuery(variables map[string]string) string {
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assignments := make([]string, len(names))
+	for i, name := range names {
+		assignments[i] = fmt.Sprintf("@@session.%s = %s", name, variables[name])
+	}
+	return "set " + strings.Join(assignments, ", ")
+}
+
+// systemVariablesQuery returns the SET statement that assigns the
+// system variables of the session, or "" if it has none.
+func (session *SafeSession) systemVariablesQuery() string {
+	if session == nil || session.Session == nil {
+		return ""
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if len(session.SystemVariables) == 0 {
+		return ""
+	}
+	return setVariablesQuery(session.SystemVariables)
+}
+
+// bindUserVariables replaces the references to the user variables
+// of the session in query with bind vars of their values. The
+// references to the variables the session didn't set are kept.
+func bindUserVariables(query *proto.Query) {
+	if query.Session == nil || len(query.Session.UserVariables) == 0 || !strings.Contains(query.Sql, "@") {
+		return
+	}
+	stmt, err := sqlparser.Parse(query.Sql)
+	if err != nil {
+		return
+	}
+	switch stmt.(type) {
+	case *sqlparser.Select, *sqlparser.Union, *sqlparser.Insert, *sqlparser.Update, *sqlparser.Delete:
+	default:
+		return
+	}
+	bound := 0
+	buf := sqlparser.NewTrackedBuffer(func(buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+		if col, ok := node.(*sqlparser.ColName); ok && col.Qualifier == nil {
+			if name, isUser, err := variableName(col); err == nil && isUser {
+				if value, ok := query.Session.UserVariables[name]; ok {
+					query.BindVariables[userVariablePrefix+name] = value
+					bound++
+					sqlparser.ValArg(":" + userVariablePrefix + name).Format(buf)
+					return
+				}
+			}
+		}
+		node.Format(buf)
+	})
+	buf.Myprintf("%v", stmt)
+	if bound != 0 {
+		query.Sql = buf.String()
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestRouterSetVariables(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("-20", sbc)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Millisecond, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	ctx := context.Background()
+
+	q := &proto.Query{
+		Sql:           "set sql_mode = 'STRICT_ALL_TABLES', @@session.WAIT_TIMEOUT = 10, @a = 1, @b = :b",
+		BindVariables: map[string]interface{}{"b": "x"},
+		TabletType:    topo.TYPE_MASTER,
+	}
+	if _, err := router.Execute(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+	if sbc.ExecCount.Get() != 0 {
+		t.Errorf("ExecCount: %d, want the SET to stay in vtgate", sbc.ExecCount.Get())
+	}
+	wantSystem := map[string]string{"sql_mode": "'STRICT_ALL_TABLES'", "wait_timeout": "10"}
+	if !reflect.DeepEqual(q.Session.SystemVariables, wantSystem) {
+		t.Errorf("SystemVariables: %v, want %v", q.Session.SystemVariables, wantSystem)
+	}
+	wantUser := map[string]interface{}{"a": int64(1), "b": "x"}
+	if !reflect.DeepEqual(q.Session.UserVariables, wantUser) {
+		t.Errorf("UserVariables: %v, want %v", q.Session.UserVariables, wantUser)
+	}
+
+	// The first query of the shard reserves a connection and
+	// sets the system variables on it. The user variables are
+	// bound, and route the query.
+	q.Sql = "select * from user where id = @a"
+	q.BindVariables = nil
+	if _, err := router.Execute(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+	wantQueries := []string{
+		"set @@session.sql_mode = 'STRICT_ALL_TABLES', @@session.wait_timeout = 10",
+		"select * from user where id = :_vtu_a",
+	}
+	if !reflect.DeepEqual(sbc.Queries, wantQueries) {
+		t.Errorf("queries: %v, want %v", sbc.Queries, wantQueries)
+	}
+	if got := sbc.BindVars[1][userVariablePrefix+"a"]; got != int64(1) {
+		t.Errorf("bind var of @a: %v, want 1", got)
+	}
+	if sbc.ReserveCount.Get() != 1 || len(q.Session.ReservedSessions) != 1 {
+		t.Fatalf("ReserveCount: %d, ReservedSessions: %v, want 1", sbc.ReserveCount.Get(), q.Session.ReservedSessions)
+	}
+
+	// A SET is applied to the reserved connections right away.
+	sbc.Queries = nil
+	q.Sql = "set time_zone = '+00:00'"
+	if _, err := router.Execute(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+	wantQueries = []string{"set @@session.time_zone = '+00:00'"}
+	if !reflect.DeepEqual(sbc.Queries, wantQueries) {
+		t.Errorf("queries: %v, want %v", sbc.Queries, wantQueries)
+	}
+	if q.Session.SystemVariables["time_zone"] != "'+00:00'" {
+		t.Errorf("SystemVariables: %v, want the time_zone", q.Session.SystemVariables)
+	}
+	if sbc.ReserveCount.Get() != 1 {
+		t.Errorf("ReserveCount: %d, want the reserved connection to be reused", sbc.ReserveCount.Get())
+	}
+
+	// The variables the session didn't set are left to the shards.
+	sbc.Queries = nil
+	q.Sql = "select @c from user where id = 1"
+	if _, err := router.Execute(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+	if want := "select @c from user where id = 1"; len(sbc.Queries) != 1 || sbc.Queries[0] != want {
+		t.Errorf("queries: %v, want %v", sbc.Queries, want)
+	}
+}
+
+func TestRouterSetVariablesErrors(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	createSandbox("TestRouter")
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Millisecond, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	for _, tcase := range []struct {
+		sql     string
+		session proto.Session
+		want    string
+	}{{
+		sql:  "set foo = 1",
+		want: "unsupported session variable: foo",
+	}, {
+		sql:  "set @@global.wait_timeout = 10",
+		want: "global variable wait_timeout cannot be set through vtgate",
+	}, {
+		sql:  "set sql_log_bin = 0",
+		want: "session variable sql_log_bin cannot be set: the writes would not be replicated",
+	}, {
+		sql:  "set wait_timeout = 'a'",
+		want: "invalid value for session variable wait_timeout: 'a'",
+	}, {
+		sql:  "set @a = 1, time_zone = 1",
+		want: "invalid value for session variable time_zone: 1",
+	}, {
+		sql:  "set @a = @b + 1",
+		want: "user variable @a can only be set to a constant: @b+1",
+	}, {
+		sql:  "set @a = :a",
+		want: "missing bind var a",
+	}, {
+		sql:     "set time_zone = '+00:00'",
+		session: proto.Session{InTransaction: true},
+		want:    "session variables can't be changed while a transaction is in progress",
+	}} {
+		session := tcase.session
+		q := &proto.Query{
+			Sql:        tcase.sql,
+			TabletType: topo.TYPE_MASTER,
+			Session:    &session,
+		}
+		_, err := router.Execute(context.Background(), q)
+		if err == nil || err.Error() != tcase.want {
+			t.Errorf("%s: %v, want %s", tcase.sql, err, tcase.want)
+		}
+		if len(session.SystemVariables) != 0 || len(session.UserVariables) != 0 {
+			t.Errorf("%s: session %v, want no variables", tcase.sql, &session)
+		}
+	}
+}
@@ -32,6 +32,14 @@ type ShardConn struct {
 	// conn needs a mutex because it can change during the lifetime of ShardConn.
 	mu   sync.Mutex
 	conn tabletconn.TabletConn
+	// hedgeConn is the connection to the second tablet
+	// hedged reads are sent to. See ExecuteHedged.
+	hedgeConn tabletconn.TabletConn
+	// standbyConn is a warmed up connection to another tablet,
+	// which replaces conn when its tablet is marked down.
+	// It's only kept if warmStandby is true.
+	standbyConn tabletconn.TabletConn
+	warmStandby bool
 }
 
 // NewShardConn creates a new ShardConn. It creates a Balancer using
@@ -54,6 +62,8 @@ func NewShardConn(ctx context.Context, serv SrvTopoServer, cell, keyspace, shard
 		retryCount: retryCount,
 		timeout:    timeout,
 		balancer:   blc,
+
+		warmStandby: *warmStandbyConn,
 	}
 }
 
@@ -62,6 +72,9 @@ type ShardConnError struct {
 	ShardIdentifier string
 	InTransaction   bool
 	Err             string
+	// ServerError is true if the error was returned by vttablet,
+	// and false if vtgate failed to communicate with it.
+	ServerError bool
 }
 
 func (e *ShardConnError) Error() string {
@@ -75,82 +88,207 @@ func (e *ShardConnError) Error() string {
 // It is not necessary to call this function before serving queries,
 // but it would reduce connection overhead when serving the first query.
 func (sdc *ShardConn) Dial(ctx context.Context) error {
-	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
-		return nil
+	_, err := sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
+		return nil, nil
 	}, 0, false)
+	return err
 }
 
 // Execute executes a non-streaming query on vttablet. If there are connection errors,
 // it retries retryCount times before failing. It does not retry if the connection is in
 // the middle of a transaction.
 func (sdc *ShardConn) Execute(ctx context.Context, query string, bindVars map[string]interface{}, transactionID int64) (qr *mproto.QueryResult, err error) {
-	err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
-		var innerErr error
-		qr, innerErr = conn.Execute(ctx, query, bindVars, transactionID)
-		return innerErr
+	ctx, span := sdc.startSpan(ctx, "ShardConn.Execute")
+	defer span.Finish()
+	query = addWorkloadComment(ctx, query)
+	result, err := sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
+		return conn.Execute(ctx, query, bindVars, transactionID)
 	}, transactionID, false)
+	qr, _ = result.(*mproto.QueryResult)
 	return qr, err
 }
 
 // ExecuteBatch executes a group of queries. The retry rules are the same as Execute.
 func (sdc *ShardConn) ExecuteBatch(ctx context.Context, queries []tproto.BoundQuery, transactionID int64) (qrs *tproto.QueryResultList, err error) {
-	err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
-		var innerErr error
-		qrs, innerErr = conn.ExecuteBatch(ctx, queries, transactionID)
-		return innerErr
+	ctx, span := sdc.startSpan(ctx, "ShardConn.ExecuteBatch")
+	defer span.Finish()
+	if _, ok := ctx.Value(workloadKey).(*workloadTags); ok {
+		tagged := make([]tproto.BoundQuery, len(queries))
+		for i, query := range queries {
+			tagged[i] = tproto.BoundQuery{Sql: addWorkloadComment(ctx, query.Sql), BindVariables: query.BindVariables}
+		}
+		queries = tagged
+	}
+	result, err := sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
+		return conn.ExecuteBatch(ctx, queries, transactionID)
 	}, transactionID, false)
+	qrs, _ = result.(*tproto.QueryResultList)
 	return qrs, err
 }
 
 // StreamExecute executes a streaming query on vttablet. The retry rules are the same as Execute.
 func (sdc *ShardConn) StreamExecute(ctx context.Context, query string, bindVars map[string]interface{}, transactionID int64) (<-chan *mproto.QueryResult, tabletconn.ErrFunc) {
+	// The span of a streaming query lasts until its stream is done.
+	ctx, span := sdc.startSpan(ctx, "ShardConn.StreamExecute")
+	query = addWorkloadComment(ctx, query)
 	var usedConn tabletconn.TabletConn
 	var erFunc tabletconn.ErrFunc
 	var results <-chan *mproto.QueryResult
-	err := sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+	_, err := sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
 		var err error
 		results, erFunc, err = conn.StreamExecute(ctx, query, bindVars, transactionID)
 		usedConn = conn
-		return err
+		return nil, err
 	}, transactionID, true)
 	if err != nil {
+		span.Finish()
 		return results, func() error { return err }
 	}
 	inTransaction := (transactionID != 0)
-	return results, func() error { return sdc.WrapError(erFunc(), usedConn.EndPoint(), inTransaction) }
+	return results, func() error {
+		defer span.Finish()
+		return sdc.WrapError(erFunc(), usedConn.EndPoint(), inTransaction)
+	}
 }
 
-// Begin begins a transaction. The retry rules are the same as Execute.
-func (sdc *ShardConn) Begin(ctx context.Context) (transactionID int64, err error) {
-	err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
-		var innerErr error
-		transactionID, innerErr = conn.Begin(ctx)
-		return innerErr
-	}, 0, false)
+// Begin begins a transaction, on the reserved connection reservedID
+// if it's not 0. The retry rules are the same as Execute.
+func (sdc *ShardConn) Begin(ctx context.Context, isolation string, readOnly bool, reservedID int64) (transactionID int64, err error) {
+	ctx, span := sdc.startSpan(ctx, "ShardConn.Begin")
+	defer span.Finish()
+	result, err := sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
+		return conn.Begin(ctx, isolation, readOnly, reservedID)
+	}, reservedID, false)
+	transactionID, _ = result.(int64)
 	return transactionID, err
 }
 
+// Reserve reserves a connection for the session. The retry rules are the same as Execute.
+func (sdc *ShardConn) Reserve(ctx context.Context) (reservedID int64, err error) {
+	result, err := sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
+		return conn.Reserve(ctx)
+	}, 0, false)
+	reservedID, _ = result.(int64)
+	return reservedID, err
+}
+
+// Release releases a reserved connection. It's not retried.
+func (sdc *ShardConn) Release(ctx context.Context, reservedID int64) (err error) {
+	_, err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
+		return nil, conn.Release(ctx, reservedID)
+	}, reservedID, false)
+	return err
+}
+
 // Commit commits the current transaction. The retry rules are the same as Execute.
 func (sdc *ShardConn) Commit(ctx context.Context, transactionID int64) (err error) {
-	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
-		return conn.Commit(ctx, transactionID)
+	ctx, span := sdc.startSpan(ctx, "ShardConn.Commit")
+	defer span.Finish()
+	_, err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
+		return nil, conn.Commit(ctx, transactionID)
 	}, transactionID, false)
+	return err
 }
 
 // Rollback rolls back the current transaction. The retry rules are the same as Execute.
 func (sdc *ShardConn) Rollback(ctx context.Context, transactionID int64) (err error) {
-	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
-		return conn.Rollback(ctx, transactionID)
+	ctx, span := sdc.startSpan(ctx, "ShardConn.Rollback")
+	defer span.Finish()
+	_, err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
+		return nil, conn.Rollback(ctx, transactionID)
+	}, transactionID, false)
+	return err
+}
+
+// Prepare prepares the transaction for a two-phase commit. It's not retried.
+func (sdc *ShardConn) Prepare(ctx context.Context, transactionID int64, dtid string) (err error) {
+	_, err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
+		return nil, conn.Prepare(ctx, transactionID, dtid)
+	}, transactionID, false)
+	return err
+}
+
+// CommitPrepared commits a prepared transaction. Committing it
+// again is a no-op, so it's retried like a call outside a transaction.
+func (sdc *ShardConn) CommitPrepared(ctx context.Context, dtid string) (err error) {
+	_, err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
+		return nil, conn.CommitPrepared(ctx, dtid)
+	}, 0, false)
+	return err
+}
+
+// RollbackPrepared rolls back a prepared transaction. The retry
+// rules are the same as CommitPrepared.
+func (sdc *ShardConn) RollbackPrepared(ctx context.Context, dtid string, originalID int64) (err error) {
+	_, err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
+		return nil, conn.RollbackPrepared(ctx, dtid, originalID)
+	}, 0, false)
+	return err
+}
+
+// CreateTransaction records a distributed transaction. The retry
+// rules are the same as Execute.
+func (sdc *ShardConn) CreateTransaction(ctx context.Context, dtid string, participants []tproto.Participant) (err error) {
+	_, err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
+		return nil, conn.CreateTransaction(ctx, dtid, participants)
+	}, 0, false)
+	return err
+}
+
+// StartCommit commits the transaction, and with it the
+// distributed transaction. It's not retried.
+func (sdc *ShardConn) StartCommit(ctx context.Context, transactionID int64, dtid string) (err error) {
+	_, err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
+		return nil, conn.StartCommit(ctx, transactionID, dtid)
 	}, transactionID, false)
+	return err
+}
+
+// SetRollback rolls back the transaction, and with it the
+// distributed transaction. It's not retried.
+func (sdc *ShardConn) SetRollback(ctx context.Context, dtid string, transactionID int64) (err error) {
+	_, err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
+		return nil, conn.SetRollback(ctx, dtid, transactionID)
+	}, transactionID, false)
+	return err
+}
+
+// ConcludeTransaction forgets a distributed transaction.
+// The retry rules are the same as CommitPrepared.
+func (sdc *ShardConn) ConcludeTransaction(ctx context.Context, dtid string) (err error) {
+	_, err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
+		return nil, conn.ConcludeTransaction(ctx, dtid)
+	}, 0, false)
+	return err
+}
+
+// UnresolvedTransactions returns the distributed transactions
+// older than abandonAge. The retry rules are the same as Execute.
+func (sdc *ShardConn) UnresolvedTransactions(ctx context.Context, abandonAge time.Duration) (txs []tproto.DistributedTx, err error) {
+	result, err := sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
+		return conn.UnresolvedTransactions(ctx, abandonAge)
+	}, 0, false)
+	txs, _ = result.([]tproto.DistributedTx)
+	return txs, err
+}
+
+// LockWaits returns the transactions of the tablet that wait for a
+// row lock held by another of its transactions. The retry rules are
+// the same as Execute.
+func (sdc *ShardConn) LockWaits(ctx context.Context) (waits []tproto.LockWait, err error) {
+	result, err := sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
+		return conn.LockWaits(ctx)
+	}, 0, false)
+	waits, _ = result.([]tproto.LockWait)
+	return waits, err
 }
 
 func (sdc *ShardConn) SplitQuery(ctx context.Context, query tproto.BoundQuery, splitCount int) (queries []tproto.QuerySplit, err error) {
-	err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
-		var innerErr error
-		queries, innerErr = conn.SplitQuery(ctx, query, splitCount)
-		return innerErr
+	result, err := sdc.withRetry(ctx, func(conn tabletconn.TabletConn) (interface{}, error) {
+		return conn.SplitQuery(ctx, query, splitCount)
 	}, 0, false)
-	return
+	queries, _ = result.([]tproto.QuerySplit)
+	return queries, err
 }
 
 // Close closes the underlying TabletConn. ShardConn can be
@@ -158,6 +296,14 @@ func (sdc *ShardConn) SplitQuery(ctx context.Context, query tproto.BoundQuery, s
 func (sdc *ShardConn) Close() {
 	sdc.mu.Lock()
 	defer sdc.mu.Unlock()
+	if sdc.hedgeConn != nil {
+		sdc.hedgeConn.Close()
+		sdc.hedgeConn = nil
+	}
+	if sdc.standbyConn != nil {
+		sdc.standbyConn.Close()
+		sdc.standbyConn = nil
+	}
 	if sdc.conn == nil {
 		return
 	}
@@ -170,9 +316,13 @@ func (sdc *ShardConn) Close() {
 // the middle of a transaction. While returning the error check if it maybe a result of
 // a resharding event, and set the re-resolve bit and let the upper layers
 // re-resolve and retry.
-func (sdc *ShardConn) withRetry(ctx context.Context, action func(conn tabletconn.TabletConn) error, transactionID int64, isStreaming bool) error {
+// The action returns its result rather than setting the variables of
+// the caller: if the call times out, or ctx is done, withRetry returns
+// before the action does, and its result is dropped.
+func (sdc *ShardConn) withRetry(ctx context.Context, action func(conn tabletconn.TabletConn) (interface{}, error), transactionID int64, isStreaming bool) (interface{}, error) {
 	var conn tabletconn.TabletConn
 	var endPoint topo.EndPoint
+	var result interface{}
 	var err error
 	var retry bool
 	inTransaction := (transactionID != 0)
@@ -183,33 +333,58 @@ func (sdc *ShardConn) withRetry(ctx context.Context, action func(conn tabletconn
 			if retry {
 				continue
 			}
-			return sdc.WrapError(err, endPoint, inTransaction)
+			return nil, sdc.WrapError(err, endPoint, inTransaction)
 		}
 		// no timeout for streaming query
 		if isStreaming {
-			err = action(conn)
+			result, err = action(conn)
 		} else {
-			tmr := time.NewTimer(sdc.timeout)
-			done := make(chan int)
-			var errAction error
-			go func() {
-				errAction = action(conn)
-				close(done)
-			}()
+			tmr := time.NewTimer(sdc.callTimeout(ctx))
+			// done is buffered, so that the action
+			// doesn't block once it's abandoned.
+			done := make(chan actionResult, 1)
+			go func(conn tabletconn.TabletConn) {
+				result, err := action(conn)
+				done <- actionResult{result: result, err: err}
+			}(conn)
 			select {
 			case <-tmr.C:
-				err = tabletconn.OperationalError("vttablet: call timeout")
-			case <-done:
-				err = errAction
+				result, err = nil, tabletconn.OperationalError("vttablet: call timeout")
+			case <-ctx.Done():
+				// The query was killed, or is past its deadline.
+				// The tablet is not at fault: don't retry.
+				tmr.Stop()
+				return nil, sdc.WrapError(tabletconn.OperationalError(fmt.Sprintf("vttablet: %v", ctx.Err())), endPoint, inTransaction)
+			case r := <-done:
+				result, err = r.result, r.err
 			}
 			tmr.Stop()
 		}
+		sdc.balancer.Record(endPoint.Uid, err)
 		if sdc.canRetry(err, transactionID, conn) {
 			continue
 		}
-		return sdc.WrapError(err, endPoint, inTransaction)
+		return result, sdc.WrapError(err, endPoint, inTransaction)
 	}
-	return sdc.WrapError(err, endPoint, inTransaction)
+	return nil, sdc.WrapError(err, endPoint, inTransaction)
+}
+
+// actionResult is what the action of withRetry returns.
+type actionResult struct {
+	result interface{}
+	err    error
+}
+
+// callTimeout returns the timeout of a call to vttablet, which
+// can't go beyond the deadline of ctx.
+func (sdc *ShardConn) callTimeout(ctx context.Context) time.Duration {
+	timeout := sdc.timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := deadline.Sub(time.Now()); remaining < timeout {
+			timeout = remaining
+		}
+	}
+	return timeout
 }
 
 // getConn reuses an existing connection if possible. Otherwise
@@ -231,6 +406,16 @@ func (sdc *ShardConn) getConn(ctx context.Context) (conn tabletconn.TabletConn,
 	if sdc.conn != nil {
 		return sdc.conn, sdc.conn.EndPoint(), nil, false
 	}
+	if sdc.standbyConn != nil {
+		standby := sdc.standbyConn
+		sdc.standbyConn = nil
+		if sdc.balancer.Available(standby.EndPoint().Uid) {
+			sdc.conn = standby
+			go sdc.warmUp()
+			return sdc.conn, sdc.conn.EndPoint(), nil, false
+		}
+		go standby.Close()
+	}
 
 	endPoint, err = sdc.balancer.Get()
 	if err != nil {
@@ -242,9 +427,46 @@ func (sdc *ShardConn) getConn(ctx context.Context) (conn tabletconn.TabletConn,
 		return nil, endPoint, err, true
 	}
 	sdc.conn = conn
+	go sdc.warmUp()
 	return sdc.conn, endPoint, nil, false
 }
 
+// warmUp dials the standby connection, if the ShardConn keeps one,
+// to a tablet other than the one of the current connection.
+func (sdc *ShardConn) warmUp() {
+	if !sdc.warmStandby {
+		return
+	}
+	sdc.mu.Lock()
+	if sdc.conn == nil || sdc.standbyConn != nil {
+		sdc.mu.Unlock()
+		return
+	}
+	uid := sdc.conn.EndPoint().Uid
+	sdc.mu.Unlock()
+
+	endPoint, ok := sdc.balancer.GetAlternate(uid)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sdc.timeout)
+	defer cancel()
+	conn, err := tabletconn.GetDialer()(ctx, endPoint, sdc.keyspace, sdc.shard, sdc.timeout)
+	if err != nil {
+		sdc.balancer.MarkDown(endPoint.Uid, err.Error())
+		return
+	}
+
+	sdc.mu.Lock()
+	defer sdc.mu.Unlock()
+	if sdc.conn == nil || sdc.standbyConn != nil || sdc.conn.EndPoint().Uid == endPoint.Uid {
+		// The connection changed while dialing.
+		go conn.Close()
+		return
+	}
+	sdc.standbyConn = conn
+}
+
 // canRetry determines whether a query can be retried or not.
 // OperationalErrors like retry/fatal cause a reconnect and retry if query is not in a txn.
 // TxPoolFull causes a retry and all other errors are non-retry.
@@ -306,6 +528,7 @@ func (sdc *ShardConn) WrapError(in error, endPoint topo.EndPoint, inTransaction
 		ShardIdentifier: shardIdentifier,
 		InTransaction:   inTransaction,
 		Err:             in.Error(),
+		ServerError:     ok,
 	}
 	return shardConnErr
 }
@@ -59,7 +59,7 @@ func TestShardConnExecuteStream(t *testing.T) {
 func TestShardConnBegin(t *testing.T) {
 	testShardConnGeneric(t, "TestShardConnBegin", func() error {
 		sdc := NewShardConn(context.Background(), new(sandboxTopo), "aa", "TestShardConnBegin", "0", "", 1*time.Millisecond, 3, 1*time.Millisecond)
-		_, err := sdc.Begin(context.Background())
+		_, err := sdc.Begin(context.Background(), "", false, 0)
 		return err
 	})
 }
@@ -246,7 +246,7 @@ func TestShardConnBeginOther(t *testing.T) {
 	s.MapTestConn("0", sbc)
 	sdc := NewShardConn(context.Background(), new(sandboxTopo), "aa", "TestShardConnBeginOther", "0", "", 10*time.Millisecond, 3, 1*time.Millisecond)
 	startTime := time.Now()
-	_, err := sdc.Begin(context.Background())
+	_, err := sdc.Begin(context.Background(), "", false, 0)
 	// If transaction pool is full, Begin should wait and retry.
 	if time.Now().Sub(startTime) < (10 * time.Millisecond) {
 		t.Errorf("want >10ms, got %v", time.Now().Sub(startTime))
@@ -0,0 +1,299 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/youtube/vitess/go/acl"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+var (
+	sloWindow      = flag.Duration("slo_window", 5*time.Minute, "time window over which the success rate and the p99 latency of each shard are computed")
+	sloSuccessRate = flag.Float64("slo_success_rate", 0, "fraction of the queries to a shard that must succeed within the window, 0 disables this objective")
+	sloP99Latency  = flag.Duration("slo_p99_latency", 0, "latency that 99% of the queries to a shard must not exceed within the window, 0 disables this objective")
+	sloMinRequests = flag.Int("slo_min_requests", 100, "minimum number of queries to a shard within the window before it can breach its objectives")
+)
+
+// shardHealthBuckets is the number of buckets the window is
+// split into. The window rolls forward a bucket at a time.
+const shardHealthBuckets = 10
+
+// shardLatencyCutoffs are the upper bounds of the buckets of the
+// latency histograms. The p99 latency is rounded up to one of them.
+var shardLatencyCutoffs = [...]time.Duration{
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// shardHealthTracker keeps the rolling success rate and latency
+// histogram of the queries to each shard and tablet type, as sent
+// by the ScatterConn. Only the failures that mean that the shard
+// couldn't serve the query count against its success rate: the
+// application errors, like duplicate keys, don't.
+type shardHealthTracker struct {
+	window      time.Duration
+	successRate float64
+	p99Latency  time.Duration
+	minRequests int64
+
+	mu     sync.Mutex
+	shards map[shardHealthKey]*shardHealth
+}
+
+type shardHealthKey struct {
+	keyspace   string
+	shard      string
+	tabletType topo.TabletType
+}
+
+type shardHealth struct {
+	buckets [shardHealthBuckets]shardHealthBucket
+}
+
+// shardHealthBucket holds the queries of
+// the bucket of the window numbered epoch.
+type shardHealthBucket struct {
+	epoch    int64
+	requests int64
+	failures int64
+	// latencies counts the queries by bucket of shardLatencyCutoffs,
+	// and the queries slower than the last cutoff in the last element.
+	latencies [len(shardLatencyCutoffs) + 1]int64
+	// maxLatency is the latency of the slowest query.
+	maxLatency time.Duration
+}
+
+// ShardHealth is the health of a shard and tablet
+// type over the window that ends at Time.
+type ShardHealth struct {
+	Keyspace   string
+	Shard      string
+	TabletType topo.TabletType
+	Time       time.Time
+	Requests   int64
+	Failures   int64
+	// SuccessRate is the fraction of the Requests that succeeded.
+	SuccessRate float64
+	// P99Latency is rounded up to the bucket of the latency
+	// histogram it falls into. It's the latency of the slowest
+	// query if it's beyond the last bucket.
+	P99Latency time.Duration
+	// Breaching lists the objectives the shard doesn't meet.
+	Breaching []string
+}
+
+func newShardHealthTracker(window time.Duration, successRate float64, p99Latency time.Duration, minRequests int) *shardHealthTracker {
+	return &shardHealthTracker{
+		window:      window,
+		successRate: successRate,
+		p99Latency:  p99Latency,
+		minRequests: int64(minRequests),
+		shards:      make(map[shardHealthKey]*shardHealth),
+	}
+}
+
+// epoch returns the number of the bucket of the window that t is in.
+func (sht *shardHealthTracker) epoch(t time.Time) int64 {
+	bucketLength := int64(sht.window) / shardHealthBuckets
+	if bucketLength <= 0 {
+		bucketLength = 1
+	}
+	return t.UnixNano() / bucketLength
+}
+
+// record records a query to the shard of keyspace
+// and tabletType, which took latency and failed with err.
+func (sht *shardHealthTracker) record(keyspace, shard string, tabletType topo.TabletType, latency time.Duration, err error) {
+	sht.recordAt(time.Now(), keyspace, shard, tabletType, latency, err)
+}
+
+func (sht *shardHealthTracker) recordAt(now time.Time, keyspace, shard string, tabletType topo.TabletType, latency time.Duration, err error) {
+	key := shardHealthKey{keyspace: keyspace, shard: shard, tabletType: tabletType}
+	epoch := sht.epoch(now)
+	sht.mu.Lock()
+	defer sht.mu.Unlock()
+	sh, ok := sht.shards[key]
+	if !ok {
+		sh = new(shardHealth)
+		sht.shards[key] = sh
+	}
+	bucket := &sh.buckets[epoch%shardHealthBuckets]
+	if bucket.epoch != epoch {
+		*bucket = shardHealthBucket{epoch: epoch}
+	}
+	bucket.requests++
+	if shardUnavailable(err) {
+		bucket.failures++
+	}
+	i := sort.Search(len(shardLatencyCutoffs), func(i int) bool { return latency <= shardLatencyCutoffs[i] })
+	bucket.latencies[i]++
+	if latency > bucket.maxLatency {
+		bucket.maxLatency = latency
+	}
+}
+
+// health returns the health of the shards that were
+// sent queries within the window, sorted by shard.
+func (sht *shardHealthTracker) health() []ShardHealth {
+	return sht.healthAt(time.Now())
+}
+
+func (sht *shardHealthTracker) healthAt(now time.Time) []ShardHealth {
+	epoch := sht.epoch(now)
+	sht.mu.Lock()
+	defer sht.mu.Unlock()
+	var result []ShardHealth
+	for key, sh := range sht.shards {
+		var total shardHealthBucket
+		for _, bucket := range sh.buckets {
+			if bucket.epoch <= epoch-shardHealthBuckets || bucket.epoch > epoch {
+				continue
+			}
+			total.requests += bucket.requests
+			total.failures += bucket.failures
+			for i, count := range bucket.latencies {
+				total.latencies[i] += count
+			}
+			if bucket.maxLatency > total.maxLatency {
+				total.maxLatency = bucket.maxLatency
+			}
+		}
+		if total.requests == 0 {
+			delete(sht.shards, key)
+			continue
+		}
+		result = append(result, sht.summarize(now, key, &total))
+	}
+	sort.Sort(byShardHealth(result))
+	return result
+}
+
+// summarize returns the health of key from the total of its buckets.
+func (sht *shardHealthTracker) summarize(now time.Time, key shardHealthKey, total *shardHealthBucket) ShardHealth {
+	health := ShardHealth{
+		Keyspace:    key.keyspace,
+		Shard:       key.shard,
+		TabletType:  key.tabletType,
+		Time:        now,
+		Requests:    total.requests,
+		Failures:    total.failures,
+		SuccessRate: float64(total.requests-total.failures) / float64(total.requests),
+		P99Latency:  total.maxLatency,
+	}
+	// The p99 query is the one that has
+	// 1% of the queries slower than itself.
+	rank := total.requests - total.requests/100
+	var count int64
+	for i, cutoff := range shardLatencyCutoffs {
+		count += total.latencies[i]
+		if count >= rank {
+			health.P99Latency = cutoff
+			break
+		}
+	}
+	if total.requests < sht.minRequests {
+		return health
+	}
+	if sht.successRate > 0 && health.SuccessRate < sht.successRate {
+		health.Breaching = append(health.Breaching, "success_rate")
+	}
+	if sht.p99Latency > 0 && health.P99Latency > sht.p99Latency {
+		health.Breaching = append(health.Breaching, "p99_latency")
+	}
+	return health
+}
+
+type byShardHealth []ShardHealth
+
+func (b byShardHealth) Len() int      { return len(b) }
+func (b byShardHealth) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byShardHealth) Less(i, j int) bool {
+	if b[i].Keyspace != b[j].Keyspace {
+		return b[i].Keyspace < b[j].Keyspace
+	}
+	if b[i].Shard != b[j].Shard {
+		return b[i].Shard < b[j].Shard
+	}
+	return b[i].TabletType < b[j].TabletType
+}
+
+// publish exports the health of the shards, by keyspace,
+// shard and tablet type, with names that start with prefix.
+func (sht *shardHealthTracker) publish(prefix string) {
+	labels := []string{"Keyspace", "ShardName", "DbType"}
+	publish := func(name string, value func(health *ShardHealth) int64) {
+		stats.NewMultiCountersFunc(prefix+name, labels, func() map[string]int64 {
+			result := make(map[string]int64)
+			for _, health := range sht.health() {
+				result[health.Keyspace+"."+health.Shard+"."+string(health.TabletType)] = value(&health)
+			}
+			return result
+		})
+	}
+	publish("Requests", func(health *ShardHealth) int64 { return health.Requests })
+	publish("Failures", func(health *ShardHealth) int64 { return health.Failures })
+	publish("P99LatencyMs", func(health *ShardHealth) int64 { return int64(health.P99Latency / time.Millisecond) })
+	publish("Breaching", func(health *ShardHealth) int64 {
+		if len(health.Breaching) != 0 {
+			return 1
+		}
+		return 0
+	})
+}
+
+// ServeHTTP serves the health of the shards as JSON, usually on
+// /debug/shard_health. The keyspace parameter, if set, only keeps
+// the shards of the keyspace, and breaching=true only keeps the
+// shards that breach their objectives.
+func (sht *shardHealthTracker) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	if err := acl.CheckAccessHTTP(request, acl.DEBUGGING); err != nil {
+		acl.SendError(response, err)
+		return
+	}
+	keyspace := request.FormValue("keyspace")
+	breaching := strings.ToLower(request.FormValue("breaching")) == "true"
+	result := make([]ShardHealth, 0)
+	for _, health := range sht.health() {
+		if keyspace != "" && health.Keyspace != keyspace {
+			continue
+		}
+		if breaching && len(health.Breaching) == 0 {
+			continue
+		}
+		result = append(result, health)
+	}
+	response.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if b, err := json.MarshalIndent(result, "", "  "); err != nil {
+		response.Write([]byte(err.Error()))
+	} else {
+		response.Write(b)
+	}
+}
+
+// ShardHealth returns the health of the shards that vtgate
+// sent queries to within the window, sorted by shard.
+func (stc *ScatterConn) ShardHealth() []ShardHealth {
+	return stc.health.health()
+}
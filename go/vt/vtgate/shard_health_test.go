@@ -0,0 +1,93 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+)
+
+func TestShardHealthTracker(t *testing.T) {
+	sht := newShardHealthTracker(10*time.Second, 0.9, 50*time.Millisecond, 10)
+	now := time.Unix(1000, 0)
+	unavailable := &ShardConnError{Code: tabletconn.ERR_RETRY, ServerError: true, Err: "retry: err"}
+	application := &ShardConnError{Code: tabletconn.ERR_NORMAL, ServerError: true, Err: "error: duplicate key"}
+
+	// -80 is slow, and 2 of its queries can't be served.
+	for i := 0; i < 20; i++ {
+		var err error
+		switch i {
+		case 0, 1:
+			err = unavailable
+		case 2:
+			err = application
+		}
+		sht.recordAt(now, "ks", "-80", topo.TYPE_MASTER, 90*time.Millisecond, err)
+		sht.recordAt(now, "ks", "80-", topo.TYPE_MASTER, 3*time.Millisecond, err)
+	}
+	// 80- is also queried too little on replica to breach.
+	sht.recordAt(now, "ks", "80-", topo.TYPE_REPLICA, time.Second, unavailable)
+
+	summary := func(health []ShardHealth) map[string][]string {
+		result := make(map[string][]string)
+		for _, h := range health {
+			result[h.Shard+"."+string(h.TabletType)] = h.Breaching
+		}
+		return result
+	}
+	health := sht.healthAt(now.Add(time.Second))
+	want := map[string][]string{
+		"-80.master":  {"p99_latency"},
+		"80-.master":  nil,
+		"80-.replica": nil,
+	}
+	if got := summary(health); !reflect.DeepEqual(got, want) {
+		t.Errorf("breaching: %v, want %v", got, want)
+	}
+	if h := health[0]; h.Shard != "-80" || h.Requests != 20 || h.Failures != 2 || h.SuccessRate != 0.9 || h.P99Latency != 100*time.Millisecond {
+		t.Errorf("health of -80: %+v, want 2 failures out of 20 and a p99 of 100ms", h)
+	}
+	if h := health[2]; h.TabletType != topo.TYPE_REPLICA || h.P99Latency != time.Second || h.SuccessRate != 0 {
+		t.Errorf("health of 80-.replica: %+v, want a p99 of 1s and no success", h)
+	}
+
+	// 80- fails a third query, and falls below its success rate.
+	sht.recordAt(now.Add(5*time.Second), "ks", "80-", topo.TYPE_MASTER, 3*time.Millisecond, unavailable)
+	if got := summary(sht.healthAt(now.Add(5 * time.Second)))["80-.master"]; !reflect.DeepEqual(got, []string{"success_rate"}) {
+		t.Errorf("breaching of 80-.master: %v, want success_rate", got)
+	}
+
+	// The first queries are out of the window.
+	health = sht.healthAt(now.Add(11 * time.Second))
+	if len(health) != 1 || health[0].Shard != "80-" || health[0].Requests != 1 || health[0].Breaching != nil {
+		t.Errorf("health: %+v, want the single query to 80-", health)
+	}
+}
+
+func TestScatterConnShardHealth(t *testing.T) {
+	s := createSandbox("TestScatterConnShardHealth")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{mustFailConn: 10}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+	if _, err := stc.Execute(context.Background(), "query", nil, "TestScatterConnShardHealth", []string{"0", "1"}, "", nil); err == nil {
+		t.Fatal("Execute: nil, want error")
+	}
+	health := stc.ShardHealth()
+	if len(health) != 2 {
+		t.Fatalf("health: %+v, want 2 shards", health)
+	}
+	for i, want := range []int64{0, 1} {
+		if health[i].Requests != 1 || health[i].Failures != want {
+			t.Errorf("health of %s: %+v, want %d failures out of 1", health[i].Shard, health[i], want)
+		}
+	}
+}
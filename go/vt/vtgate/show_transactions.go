@@ -0,0 +1,40 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"strconv"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+)
+
+// transactionsFields are the columns of SHOW VITESS_TRANSACTIONS.
+var transactionsFields = []mproto.Field{
+	{Name: "Keyspace", Type: mproto.VT_VAR_STRING},
+	{Name: "Shard", Type: mproto.VT_VAR_STRING},
+	{Name: "TabletType", Type: mproto.VT_VAR_STRING},
+	{Name: "TransactionId", Type: mproto.VT_LONGLONG},
+}
+
+// execShowTransactions returns the result of SHOW VITESS_TRANSACTIONS:
+// one row per shard that joined the transaction of the session, in the
+// order they're committed in. If keyspace is not empty, only the shards
+// of keyspace are returned. There are no rows outside of a transaction.
+func (rtr *Router) execShowTransactions(vcursor *requestContext, keyspace string) (*mproto.QueryResult, error) {
+	result := &mproto.QueryResult{Fields: transactionsFields}
+	for _, shardSession := range NewSafeSession(vcursor.query.Session).shardSessions(keyspace) {
+		result.Rows = append(result.Rows, []sqltypes.Value{
+			sqltypes.MakeString([]byte(shardSession.Keyspace)),
+			sqltypes.MakeString([]byte(shardSession.Shard)),
+			sqltypes.MakeString([]byte(shardSession.TabletType)),
+			sqltypes.MakeNumeric([]byte(strconv.FormatInt(shardSession.TransactionId, 10))),
+		})
+	}
+	result.RowsAffected = uint64(len(result.Rows))
+	return result, nil
+}
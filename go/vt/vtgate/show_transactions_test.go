@@ -0,0 +1,114 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestRouterShowTransactions(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("-20", sbc)
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	ctx := context.Background()
+
+	q := &proto.Query{
+		TabletType: topo.TYPE_MASTER,
+		Session:    &proto.Session{InTransaction: true},
+	}
+	for _, sql := range []string{
+		"select * from music_user_map where music_id = 1",
+		"select * from user where id = 1",
+	} {
+		q.Sql = sql
+		if _, err := router.Execute(ctx, q); err != nil {
+			t.Fatalf("router.Execute(%s): %v", sql, err)
+		}
+	}
+	showTransactions := func(sql string) [][]sqltypes.Value {
+		q.Sql = sql
+		result, err := router.Execute(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.Rows
+	}
+	row := func(keyspace, shard, transactionID string) []sqltypes.Value {
+		return []sqltypes.Value{
+			sqltypes.MakeString([]byte(keyspace)),
+			sqltypes.MakeString([]byte(shard)),
+			sqltypes.MakeString([]byte(topo.TYPE_MASTER)),
+			sqltypes.MakeNumeric([]byte(transactionID)),
+		}
+	}
+
+	// The shards are listed in the order they're committed in.
+	got := showTransactions("show vitess_transactions")
+	want := [][]sqltypes.Value{row("TestRouter", "-20", "1"), row("TestUnsharded", "0", "1")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("show vitess_transactions: %v, want %v", got, want)
+	}
+	got = showTransactions("show vitess_transactions from TestUnsharded")
+	want = want[1:]
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("show vitess_transactions from TestUnsharded: %v, want %v", got, want)
+	}
+
+	if err := scatterConn.Commit(ctx, NewSafeSession(q.Session)); err != nil {
+		t.Fatal(err)
+	}
+	if sbc.CommitCount.Get() != 1 || sbclookup.CommitCount.Get() != 1 {
+		t.Errorf("CommitCount: %d, %d, want 1, 1", sbc.CommitCount.Get(), sbclookup.CommitCount.Get())
+	}
+	if got := showTransactions("show vitess_transactions"); len(got) != 0 {
+		t.Errorf("show vitess_transactions after commit: %v, want none", got)
+	}
+}
+
+func TestSafeSessionAppend(t *testing.T) {
+	session := NewSafeSession(&proto.Session{InTransaction: true})
+	var wg sync.WaitGroup
+	existing := make([]int64, 2)
+	for i := range existing {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			existing[i] = session.Append(&proto.ShardSession{
+				Keyspace:      "ks",
+				Shard:         "0",
+				TabletType:    topo.TYPE_MASTER,
+				TransactionId: int64(i + 1),
+			})
+		}(i)
+	}
+	wg.Wait()
+	if len(session.ShardSessions) != 1 {
+		t.Fatalf("ShardSessions: %v, want 1", session.ShardSessions)
+	}
+	// The transaction that lost the race is given the one that won it.
+	kept := session.ShardSessions[0].TransactionId
+	if existing[kept%2] != kept || existing[kept-1] != 0 {
+		t.Errorf("Append: %v, want 0 for transaction %d, and %d for the other", existing, kept, kept)
+	}
+}
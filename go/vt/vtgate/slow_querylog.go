@@ -0,0 +1,200 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/streamlog"
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+)
+
+// The slow query log records the statements that take longer than
+// slow_query_time, with the latency of each of the calls to the
+// shards they made, vindex lookups included, and the slowest of
+// them. A statement that's slow because of its fan-out has many
+// calls of similar latencies, and one that's slow because of a bad
+// tablet has a single call that takes most of its time. The slow
+// statements are recorded whether the query log samples them or not.
+
+var (
+	slowQueryTime        = flag.Duration("slow_query_time", 0, "statements that take longer than this are recorded in the slow query log, 0 disables it")
+	slowQueryLogHandler  = flag.String("slow_querylog_handler", "/debug/slow_querylog", "URL handler for streaming the slow query log")
+	slowQueryLogFile     = flag.String("slow_querylog_file", "", "file the slow query log is written to, empty disables it")
+	slowQueryLogFormat   = flag.String("slow_querylog_format", "json", "format of the slow query log file: json, one entry per line, or bson")
+	slowQueryLogMaxSize  = flag.Int64("slow_querylog_max_size", 100*1024*1024, "size in bytes beyond which the slow query log file is rotated")
+	slowQueryLogMaxFiles = flag.Int("slow_querylog_max_files", 5, "number of rotated slow query log files that are kept")
+
+	// SlowQueryLogger broadcasts the entries of the slow query log.
+	SlowQueryLogger = streamlog.New("VTGateSlowQuery", 50)
+
+	slowQueryLogCounts = stats.NewCounters("VtgateSlowQueryLog")
+)
+
+// ShardLatency is a call to a shard made by a statement.
+type ShardLatency struct {
+	Keyspace   string
+	Shard      string
+	TabletType topo.TabletType
+	Latency    time.Duration
+	Error      string
+}
+
+// shardLatencies are the calls to the shards made by a statement.
+type shardLatencies struct {
+	mu   sync.Mutex
+	list []ShardLatency
+}
+
+// slowQueryEntry is an entry of the slow query log. Shards are the
+// calls to the shards, the slowest first.
+type slowQueryEntry struct {
+	Method       string
+	Sql          string
+	PlanType     string
+	Keyspace     string
+	ShardCount   int64
+	RowCount     int64
+	StartTime    time.Time
+	PlanTime     time.Duration
+	VindexTime   time.Duration
+	ExecuteTime  time.Duration
+	TotalTime    time.Duration
+	Error        string
+	SlowestShard string
+	SlowestTime  time.Duration
+	Shards       []ShardLatency
+}
+
+// addShardLatency records a call to the shard of keyspace and
+// tabletType, which took latency and failed with err, in the query
+// log entry of the statement of ctx, if any, when the slow query
+// log is on.
+func addShardLatency(ctx context.Context, keyspace, shard string, tabletType topo.TabletType, latency time.Duration, err error) {
+	if *slowQueryTime == 0 {
+		return
+	}
+	entry, ok := ctx.Value(queryLogKey).(*queryLogEntry)
+	if !ok {
+		return
+	}
+	sl := ShardLatency{
+		Keyspace:   keyspace,
+		Shard:      shard,
+		TabletType: tabletType,
+		Latency:    latency,
+	}
+	if err != nil {
+		sl.Error = err.Error()
+	}
+	entry.shards.mu.Lock()
+	entry.shards.list = append(entry.shards.list, sl)
+	entry.shards.mu.Unlock()
+}
+
+// sendSlow broadcasts the entry to the subscribers
+// of SlowQueryLogger if it took longer than slowQueryTime.
+func (entry *queryLogEntry) sendSlow() {
+	if *slowQueryTime == 0 || entry.TotalTime < *slowQueryTime {
+		return
+	}
+	slow := &slowQueryEntry{
+		Method:      entry.Method,
+		Sql:         entry.Sql,
+		PlanType:    entry.PlanType,
+		Keyspace:    entry.Keyspace,
+		ShardCount:  entry.ShardCount,
+		RowCount:    entry.RowCount,
+		StartTime:   entry.StartTime,
+		PlanTime:    entry.PlanTime,
+		VindexTime:  entry.VindexTime,
+		ExecuteTime: entry.ExecuteTime,
+		TotalTime:   entry.TotalTime,
+		Error:       entry.Error,
+	}
+	entry.shards.mu.Lock()
+	slow.Shards = make([]ShardLatency, len(entry.shards.list))
+	copy(slow.Shards, entry.shards.list)
+	entry.shards.mu.Unlock()
+	sort.Sort(byLatency(slow.Shards))
+	if len(slow.Shards) != 0 {
+		slowest := slow.Shards[0]
+		slow.SlowestShard = slowest.Keyspace + "/" + slowest.Shard
+		slow.SlowestTime = slowest.Latency
+	}
+	slowQueryLogCounts.Add("Sent", 1)
+	SlowQueryLogger.Send(slow)
+}
+
+type byLatency []ShardLatency
+
+func (b byLatency) Len() int           { return len(b) }
+func (b byLatency) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byLatency) Less(i, j int) bool { return b[i].Latency > b[j].Latency }
+
+// formatSlowQueryLog formats an entry of the slow query log for
+// /debug/slow_querylog: as JSON if the format parameter is json,
+// and as a tab separated list of its fields otherwise, where the
+// calls to the shards are listed as keyspace/shard:seconds.
+func formatSlowQueryLog(params url.Values, message interface{}) string {
+	entry, ok := message.(*slowQueryEntry)
+	if !ok {
+		return ""
+	}
+	if params.Get("format") == "json" {
+		b, err := json.Marshal(entry)
+		if err != nil {
+			return ""
+		}
+		return string(b) + "\n"
+	}
+	shards := make([]string, len(entry.Shards))
+	for i, sl := range entry.Shards {
+		shards[i] = fmt.Sprintf("%s/%s:%.6f", sl.Keyspace, sl.Shard, sl.Latency.Seconds())
+	}
+	return fmt.Sprintf(
+		"%v\t%v\t%q\t%v\t%v\t%v\t%.6f\t%v\t%.6f\t%v\t%q\t\n",
+		entry.Method,
+		entry.StartTime.Format(time.StampMicro),
+		entry.Sql,
+		entry.PlanType,
+		entry.Keyspace,
+		entry.ShardCount,
+		entry.TotalTime.Seconds(),
+		entry.SlowestShard,
+		entry.SlowestTime.Seconds(),
+		strings.Join(shards, ","),
+		entry.Error,
+	)
+}
+
+// initSlowQueryLog serves the slow query log on the handler set by
+// the slow_querylog_handler flag, and writes it to the file set by
+// the slow_querylog_file flag, if any.
+func initSlowQueryLog() {
+	if *slowQueryLogHandler != "" {
+		SlowQueryLogger.ServeLogs(*slowQueryLogHandler, formatSlowQueryLog)
+	}
+	if *slowQueryLogFile == "" {
+		return
+	}
+	qlw, err := newQueryLogWriter(*slowQueryLogFile, *slowQueryLogFormat, *slowQueryLogMaxSize, *slowQueryLogMaxFiles, slowQueryLogCounts)
+	if err != nil {
+		log.Fatalf("Cannot open the slow query log: %v", err)
+	}
+	go qlw.run(SlowQueryLogger.Subscribe("File"))
+}
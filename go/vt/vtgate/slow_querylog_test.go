@@ -0,0 +1,76 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestRouterSlowQueryLog(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	var conns []*sandboxConn
+	for _, shard := range []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"} {
+		sbc := &sandboxConn{}
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	// The calls to the shards time out after a second.
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Millisecond, 3, 1*time.Second)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	defer func(slowTime time.Duration) { *slowQueryTime = slowTime }(*slowQueryTime)
+	*slowQueryTime = 50 * time.Millisecond
+	ch := SlowQueryLogger.Subscribe("Test")
+	defer SlowQueryLogger.Unsubscribe(ch)
+
+	// The statement is fast enough.
+	sent := slowQueryLogCounts.Counts()["Sent"]
+	q := &proto.Query{Sql: "select * from user where id = 1", TabletType: topo.TYPE_REPLICA}
+	if _, err := router.Execute(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	if got := slowQueryLogCounts.Counts()["Sent"] - sent; got != 0 {
+		t.Errorf("VtgateSlowQueryLog[Sent]: %d more, want 0", got)
+	}
+
+	// A single shard of the scatter is slow.
+	conns[2].mustDelay = 100 * time.Millisecond
+	q.Sql = "select * from user"
+	if _, err := router.Execute(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	var entry *slowQueryEntry
+	for entry == nil {
+		select {
+		case message := <-ch:
+			if e := message.(*slowQueryEntry); e.Sql == q.Sql {
+				entry = e
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no slow query log entry for %s", q.Sql)
+		}
+	}
+	if entry.PlanType != "SelectScatter" || len(entry.Shards) != 8 || entry.SlowestShard != "TestRouter/40-60" || entry.SlowestTime < conns[2].mustDelay {
+		t.Errorf("entry: %+v, want 8 shards and 40-60 the slowest", entry)
+	}
+	if entry.Shards[1].Latency >= conns[2].mustDelay {
+		t.Errorf("shards: %+v, want 40-60 to be the only slow one", entry.Shards)
+	}
+	if got := formatSlowQueryLog(nil, entry); !strings.Contains(got, "\tTestRouter/40-60\t") || !strings.Contains(got, "TestRouter/-20:") {
+		t.Errorf("text: %q", got)
+	}
+}
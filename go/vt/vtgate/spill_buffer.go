@@ -0,0 +1,134 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/youtube/vitess/go/bson"
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/stats"
+)
+
+var (
+	resultSpillThreshold = flag.Int("result_spill_threshold", 0, "bytes of the shard results of a non-streaming query that vtgate keeps in memory before it spills the other shard results to a temporary file, 0 disables spilling")
+	resultSpillMaxBytes  = flag.Int("result_spill_max_bytes", 1<<30, "maximum number of bytes of shard results a query can spill to disk, 0 means no limit")
+	resultSpillDir       = flag.String("result_spill_dir", "", "directory of the files shard results are spilled to, the default temporary directory if empty")
+)
+
+// spillStats counts the queries that spilled shard results, the
+// bytes they spilled, and the queries that exceeded the spill limit.
+var spillStats = stats.NewCounters("VtgateResultSpill")
+
+// spillBuffer collects the shard results of a non-streaming query.
+// Once it holds threshold bytes of rows, it writes the results
+// of the next shards to a temporary file instead of keeping
+// them on the heap, until they're merged by result.
+type spillBuffer struct {
+	threshold int
+	maxSpill  int
+	dir       string
+
+	qr       *mproto.QueryResult
+	memBytes int
+
+	file    *os.File
+	writer  *bufio.Writer
+	spilled int
+}
+
+func (stc *ScatterConn) newSpillBuffer() *spillBuffer {
+	return &spillBuffer{
+		threshold: stc.spillThreshold,
+		maxSpill:  stc.spillMaxBytes,
+		dir:       *resultSpillDir,
+		qr:        new(mproto.QueryResult),
+	}
+}
+
+// add adds the result of a shard.
+func (sb *spillBuffer) add(innerqr *mproto.QueryResult) error {
+	size := rowsSize(innerqr.Rows)
+	if sb.threshold == 0 || (sb.file == nil && sb.memBytes+size <= sb.threshold) {
+		sb.memBytes += size
+		appendResult(sb.qr, innerqr)
+		return nil
+	}
+	if sb.file == nil {
+		file, err := ioutil.TempFile(sb.dir, "vtgate-spill-")
+		if err != nil {
+			return fmt.Errorf("cannot spill shard results: %v", err)
+		}
+		sb.file = file
+		sb.writer = bufio.NewWriter(file)
+		spillStats.Add("Queries", 1)
+	}
+	data, err := bson.Marshal(innerqr)
+	if err != nil {
+		return fmt.Errorf("cannot spill shard results: %v", err)
+	}
+	sb.spilled += len(data)
+	spillStats.Add("Bytes", int64(len(data)))
+	if sb.maxSpill != 0 && sb.spilled > sb.maxSpill {
+		spillStats.Add("LimitExceeded", 1)
+		return &ResultLimitError{Unit: "spilled bytes", Limit: sb.maxSpill}
+	}
+	if _, err := sb.writer.Write(data); err != nil {
+		return fmt.Errorf("cannot spill shard results: %v", err)
+	}
+	return nil
+}
+
+// result returns the merged results of the shards.
+func (sb *spillBuffer) result() (*mproto.QueryResult, error) {
+	if sb.file == nil {
+		return sb.qr, nil
+	}
+	if err := sb.writer.Flush(); err != nil {
+		return nil, fmt.Errorf("cannot read spilled shard results: %v", err)
+	}
+	if _, err := sb.file.Seek(0, 0); err != nil {
+		return nil, fmt.Errorf("cannot read spilled shard results: %v", err)
+	}
+	reader := bufio.NewReader(sb.file)
+	for {
+		innerqr := new(mproto.QueryResult)
+		err := bson.UnmarshalFromStream(reader, innerqr)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read spilled shard results: %v", err)
+		}
+		appendResult(sb.qr, innerqr)
+	}
+	return sb.qr, nil
+}
+
+// close removes the file of the spilled results, if any.
+func (sb *spillBuffer) close() {
+	if sb.file == nil {
+		return
+	}
+	sb.file.Close()
+	os.Remove(sb.file.Name())
+}
+
+// rowsSize returns the number of bytes of the values of rows.
+func rowsSize(rows [][]sqltypes.Value) int {
+	size := 0
+	for _, row := range rows {
+		for _, val := range row {
+			size += len(val.Raw())
+		}
+	}
+	return size
+}
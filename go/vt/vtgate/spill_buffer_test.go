@@ -0,0 +1,83 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"golang.org/x/net/context"
+)
+
+// This file uses the sandbox_test framework.
+
+func spillResult(val string) *mproto.QueryResult {
+	return &mproto.QueryResult{
+		RowsAffected: 1,
+		Rows:         [][]sqltypes.Value{{sqltypes.MakeString([]byte(val))}},
+	}
+}
+
+func TestSpillBuffer(t *testing.T) {
+	sb := &spillBuffer{threshold: 4, qr: new(mproto.QueryResult)}
+	defer sb.close()
+	for _, val := range []string{"abc", "defg", "hi"} {
+		if err := sb.add(spillResult(val)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sb.file == nil {
+		t.Fatalf("spillBuffer did not spill")
+	}
+	name := sb.file.Name()
+	qr, err := sb.result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qr.Rows) != 3 || qr.RowsAffected != 3 {
+		t.Fatalf("result: %+v, want 3 rows", qr)
+	}
+	for i, want := range []string{"abc", "defg", "hi"} {
+		if got := qr.Rows[i][0].String(); got != want {
+			t.Errorf("row %d: %s, want %s", i, got, want)
+		}
+	}
+	sb.close()
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("spill file %s was not removed: %v", name, err)
+	}
+}
+
+func TestSpillBufferLimit(t *testing.T) {
+	sb := &spillBuffer{threshold: 1, maxSpill: 10, qr: new(mproto.QueryResult)}
+	defer sb.close()
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		err = sb.add(spillResult("abcdefghijklmnop"))
+	}
+	if err == nil || !strings.Contains(err.Error(), "spilled bytes") {
+		t.Errorf("add over the limit: %v, want a spilled bytes error", err)
+	}
+}
+
+func TestScatterConnSpill(t *testing.T) {
+	s := createSandbox("TestScatterConnSpill")
+	s.MapTestConn("0", &sandboxConn{})
+	s.MapTestConn("1", &sandboxConn{})
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 0, 1*time.Second)
+	stc.spillThreshold = 1
+	shardVars := map[string]map[string]interface{}{"0": nil, "1": nil}
+	qr, err := stc.ExecuteMulti(context.Background(), "select * from t", "TestScatterConnSpill", shardVars, "", NewSafeSession(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qr.Rows) != 2 {
+		t.Errorf("rows: %v, want 2 rows", qr.Rows)
+	}
+}
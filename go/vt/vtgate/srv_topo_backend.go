@@ -0,0 +1,66 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"fmt"
+
+	log "github.com/golang/glog"
+
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+var srvTopoBackend = flag.String("srv_topo_backend", "", "the backend to read the serving graph from, empty to read it from the topology server")
+
+// SrvTopoBackend is the part of topo.Server that ResilientSrvTopoServer
+// reads the serving graph from. Any topo.Server is a SrvTopoBackend.
+// A backend that also implements topo.SrvKeyspaceWatcher can be used
+// to watch the SrvKeyspaces instead of polling them.
+type SrvTopoBackend interface {
+	GetSrvKeyspaceNames(cell string) ([]string, error)
+
+	GetSrvKeyspace(cell, keyspace string) (*topo.SrvKeyspace, error)
+
+	GetSrvShard(cell, keyspace, shard string) (*topo.SrvShard, error)
+
+	GetEndPoints(cell, keyspace, shard string, tabletType topo.TabletType) (*topo.EndPoints, error)
+}
+
+// SrvTopoBackendFactory creates a SrvTopoBackend. ts is the topology
+// server of the process, which the backend may use for the records
+// it doesn't serve itself.
+type SrvTopoBackendFactory func(ts topo.Server) (SrvTopoBackend, error)
+
+// Registry for SrvTopoBackend implementations.
+var srvTopoBackends = make(map[string]SrvTopoBackendFactory)
+
+// RegisterSrvTopoBackend adds an implementation for a SrvTopoBackend.
+// If an implementation with that name already exists, panics.
+// Call this in the 'init' function in your module.
+func RegisterSrvTopoBackend(name string, factory SrvTopoBackendFactory) {
+	if srvTopoBackends[name] != nil {
+		panic(fmt.Errorf("Duplicate SrvTopoBackend registration for %v", name))
+	}
+	srvTopoBackends[name] = factory
+}
+
+// NewSrvTopoBackend returns the SrvTopoBackend named by the
+// 'srv_topo_backend' flag, or ts itself if the flag is empty.
+func NewSrvTopoBackend(ts topo.Server) (SrvTopoBackend, error) {
+	return newSrvTopoBackend(*srvTopoBackend, ts)
+}
+
+func newSrvTopoBackend(name string, ts topo.Server) (SrvTopoBackend, error) {
+	if name == "" {
+		return ts, nil
+	}
+	factory := srvTopoBackends[name]
+	if factory == nil {
+		return nil, fmt.Errorf("no SrvTopoBackend named %v", name)
+	}
+	log.Infof("Using SrvTopoBackend: %v", name)
+	return factory(ts)
+}
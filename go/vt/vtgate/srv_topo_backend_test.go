@@ -0,0 +1,66 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+)
+
+// fakeSrvTopoBackend serves the SrvKeyspaces of the topology
+// server as if they were all sharded by user_id.
+type fakeSrvTopoBackend struct {
+	topo.Server
+}
+
+func (fb *fakeSrvTopoBackend) GetSrvKeyspace(cell, keyspace string) (*topo.SrvKeyspace, error) {
+	ks, err := fb.Server.GetSrvKeyspace(cell, keyspace)
+	if err != nil {
+		return nil, err
+	}
+	ks.ShardingColumnName = "user_id"
+	return ks, nil
+}
+
+func init() {
+	RegisterSrvTopoBackend("fake", func(ts topo.Server) (SrvTopoBackend, error) {
+		return &fakeSrvTopoBackend{ts}, nil
+	})
+}
+
+func TestSrvTopoBackend(t *testing.T) {
+	ft := &fakeTopo{keyspace: "test_ks"}
+
+	backend, err := newSrvTopoBackend("", ft)
+	if err != nil || backend != SrvTopoBackend(ft) {
+		t.Errorf("default SrvTopoBackend: %v, %v, want the topology server", backend, err)
+	}
+
+	if _, err := newSrvTopoBackend("unknown", ft); err == nil {
+		t.Errorf("unknown SrvTopoBackend didn't return an error")
+	}
+
+	backend, err = newSrvTopoBackend("fake", ft)
+	if err != nil {
+		t.Fatalf("newSrvTopoBackend(fake) failed: %v", err)
+	}
+	rsts := NewResilientSrvTopoServer(backend, testCounterPrefix("TestSrvTopoBackend"))
+	ks, err := rsts.GetSrvKeyspace(context.Background(), "", "test_ks")
+	if err != nil {
+		t.Fatalf("GetSrvKeyspace got unexpected error: %v", err)
+	}
+	if ks.ShardingColumnName != "user_id" {
+		t.Errorf("GetSrvKeyspace: %+v, want the value of the fake backend", ks)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("duplicate RegisterSrvTopoBackend didn't panic")
+		}
+	}()
+	RegisterSrvTopoBackend("fake", nil)
+}
@@ -0,0 +1,133 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/youtube/vitess/go/acl"
+	"github.com/youtube/vitess/go/stats"
+)
+
+// SrvKeyspaceHealth describes how current the cached SrvKeyspace
+// of a keyspace is, so it can be seen when vtgate is routing with
+// stale topology data.
+type SrvKeyspaceHealth struct {
+	Cell     string
+	Keyspace string
+	// Age is the time since the SrvKeyspace was last read
+	// successfully. It's 0 if it never was.
+	Age         time.Duration
+	LastSuccess time.Time
+	ErrorCount  int64
+	LastError   string
+	Watched     bool
+	Stale       bool
+	// FallbackCell is the cell the SrvKeyspace was read from,
+	// if it couldn't be read from Cell.
+	FallbackCell string
+}
+
+// SrvKeyspaceHealthList is used for sorting
+type SrvKeyspaceHealthList []*SrvKeyspaceHealth
+
+// Len is part of sort.Interface
+func (skhl SrvKeyspaceHealthList) Len() int {
+	return len(skhl)
+}
+
+// Less is part of sort.Interface
+func (skhl SrvKeyspaceHealthList) Less(i, j int) bool {
+	return skhl[i].Cell+"."+skhl[i].Keyspace <
+		skhl[j].Cell+"."+skhl[j].Keyspace
+}
+
+// Swap is part of sort.Interface
+func (skhl SrvKeyspaceHealthList) Swap(i, j int) {
+	skhl[i], skhl[j] = skhl[j], skhl[i]
+}
+
+// SrvKeyspaceHealth returns the health of all the cached
+// SrvKeyspaces, sorted by cell and keyspace.
+func (server *ResilientSrvTopoServer) SrvKeyspaceHealth() SrvKeyspaceHealthList {
+	now := time.Now()
+	var result SrvKeyspaceHealthList
+	server.mutex.Lock()
+	for _, entry := range server.srvKeyspaceCache {
+		entry.mutex.Lock()
+		health := &SrvKeyspaceHealth{
+			Cell:         entry.cell,
+			Keyspace:     entry.keyspace,
+			LastSuccess:  entry.lastSuccessTime,
+			ErrorCount:   entry.errorCount,
+			Watched:      entry.watching,
+			Stale:        entry.stale,
+			FallbackCell: entry.fallbackCell,
+		}
+		if !entry.lastSuccessTime.IsZero() {
+			health.Age = now.Sub(entry.lastSuccessTime)
+		}
+		if entry.lastError != nil {
+			health.LastError = entry.lastError.Error()
+		}
+		entry.mutex.Unlock()
+		result = append(result, health)
+	}
+	server.mutex.Unlock()
+
+	// do the sorting without the mutex
+	sort.Sort(result)
+	return result
+}
+
+// publishSrvKeyspaceHealth exports the age, in seconds, and the
+// error count of the cached SrvKeyspaces, and the unix time of
+// their last successful read, by cell and keyspace.
+func (server *ResilientSrvTopoServer) publishSrvKeyspaceHealth(counterPrefix string) {
+	labels := []string{"Cell", "Keyspace"}
+	stats.NewMultiCountersFunc(counterPrefix+"SrvKeyspaceAgeSeconds", labels, func() map[string]int64 {
+		result := make(map[string]int64)
+		for _, health := range server.SrvKeyspaceHealth() {
+			result[health.Cell+"."+health.Keyspace] = int64(health.Age / time.Second)
+		}
+		return result
+	})
+	stats.NewMultiCountersFunc(counterPrefix+"SrvKeyspaceLastSuccess", labels, func() map[string]int64 {
+		result := make(map[string]int64)
+		for _, health := range server.SrvKeyspaceHealth() {
+			if !health.LastSuccess.IsZero() {
+				result[health.Cell+"."+health.Keyspace] = health.LastSuccess.Unix()
+			}
+		}
+		return result
+	})
+	stats.NewMultiCountersFunc(counterPrefix+"SrvKeyspaceErrors", labels, func() map[string]int64 {
+		result := make(map[string]int64)
+		for _, health := range server.SrvKeyspaceHealth() {
+			result[health.Cell+"."+health.Keyspace] = health.ErrorCount
+		}
+		return result
+	})
+}
+
+// HealthHandler returns the handler that serves the health of
+// the cached SrvKeyspaces as JSON, usually on /debug/topology.
+func (server *ResilientSrvTopoServer) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if err := acl.CheckAccessHTTP(request, acl.DEBUGGING); err != nil {
+			acl.SendError(response, err)
+			return
+		}
+		response.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if b, err := json.MarshalIndent(server.SrvKeyspaceHealth(), "", "  "); err != nil {
+			response.Write([]byte(err.Error()))
+		} else {
+			response.Write(b)
+		}
+	})
+}
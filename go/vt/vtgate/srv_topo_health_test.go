@@ -0,0 +1,57 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestSrvKeyspaceHealth(t *testing.T) {
+	ft := &fakeTopo{keyspace: "test_ks"}
+	rsts := NewResilientSrvTopoServer(ft, testCounterPrefix("TestSrvKeyspaceHealth"))
+	rsts.cacheTTL = 0
+
+	if _, err := rsts.GetSrvKeyspace(context.Background(), "cell1", "test_ks"); err != nil {
+		t.Fatalf("GetSrvKeyspace got unexpected error: %v", err)
+	}
+	if _, err := rsts.GetSrvKeyspace(context.Background(), "cell1", "unknown_ks"); err == nil {
+		t.Fatalf("GetSrvKeyspace of an unknown keyspace didn't return an error")
+	}
+
+	// The topology server fails, the cached value is served.
+	ft.keyspace = "another_ks"
+	for i := 0; i < 2; i++ {
+		if _, err := rsts.GetSrvKeyspace(context.Background(), "cell1", "test_ks"); err != nil {
+			t.Fatalf("GetSrvKeyspace got unexpected error: %v", err)
+		}
+	}
+
+	health := rsts.SrvKeyspaceHealth()
+	if len(health) != 2 {
+		t.Fatalf("SrvKeyspaceHealth: %+v, want 2 keyspaces", health)
+	}
+	if got := health[0]; got.Keyspace != "test_ks" || got.ErrorCount != 2 || !got.Stale || got.LastSuccess.IsZero() || got.LastError != "" {
+		t.Errorf("health of test_ks: %+v, want a stale value with 2 errors", got)
+	}
+	if got := health[1]; got.Keyspace != "unknown_ks" || got.ErrorCount != 1 || !got.LastSuccess.IsZero() || got.Age != 0 || got.LastError == "" {
+		t.Errorf("health of unknown_ks: %+v, want 1 error and no value", got)
+	}
+
+	response := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/debug/topology", nil)
+	rsts.HealthHandler().ServeHTTP(response, request)
+	var served []SrvKeyspaceHealth
+	if err := json.Unmarshal(response.Body.Bytes(), &served); err != nil {
+		t.Fatalf("%v: %s", err, response.Body.String())
+	}
+	if len(served) != 2 || served[0].Keyspace != "test_ks" || served[0].ErrorCount != 2 {
+		t.Errorf("/debug/topology: %s, want the health of test_ks and unknown_ks", response.Body.String())
+	}
+}
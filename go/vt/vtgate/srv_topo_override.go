@@ -0,0 +1,158 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/logutil"
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+)
+
+// The override file is an emergency measure: when the topology is
+// down but the tablets of some keyspaces are known, it replaces what
+// the topology says about them. It's a json map of KeyspaceOverride
+// by keyspace name, for instance:
+//
+//   {
+//     "user": {
+//       "SrvKeyspace": {
+//         "Partitions": {"master": {"Shards": [{"Name": "0"}]}},
+//         "TabletTypes": ["master"]
+//       },
+//       "EndPoints": {
+//         "0": {"master": {"entries": [{"uid": 1, "host": "db1", "named_port_map": {"_vtocc": 15001}}]}}
+//       }
+//     }
+//   }
+//
+// The override applies to all the cells.
+
+var srvTopoOverrideFile = flag.String("srv_topo_override_file", "", "emergency json file overriding the serving graph of some keyspaces, for when the topology is down but their tablets are known")
+
+var (
+	srvTopoOverrideKeyspaces = stats.NewInt("VtgateSrvTopoOverrideKeyspaces")
+	srvTopoOverrideReads     = stats.NewMultiCounters("VtgateSrvTopoOverrideReads", []string{"Keyspace", "Operation"})
+)
+
+// KeyspaceOverride is the serving graph of a keyspace in the
+// override file. EndPoints are the endpoints of each shard,
+// by tablet type.
+type KeyspaceOverride struct {
+	SrvKeyspace *topo.SrvKeyspace
+	EndPoints   map[string]map[topo.TabletType]*topo.EndPoints
+}
+
+// OverrideSrvTopoServer is a SrvTopoServer that serves some keyspaces
+// from an override file, and the others from the underlying
+// SrvTopoServer.
+type OverrideSrvTopoServer struct {
+	SrvTopoServer
+	keyspaces map[string]*KeyspaceOverride
+	logger    *logutil.ThrottledLogger
+}
+
+// NewOverrideSrvTopoServer returns the SrvTopoServer that overrides
+// serv with the file of the 'srv_topo_override_file' flag, or serv
+// itself if the flag is empty.
+func NewOverrideSrvTopoServer(serv SrvTopoServer) (SrvTopoServer, error) {
+	if *srvTopoOverrideFile == "" {
+		return serv, nil
+	}
+	return LoadSrvTopoOverride(serv, *srvTopoOverrideFile)
+}
+
+// LoadSrvTopoOverride reads the override file filename, and returns
+// an OverrideSrvTopoServer that serves its keyspaces instead of serv.
+func LoadSrvTopoOverride(serv SrvTopoServer, filename string) (*OverrideSrvTopoServer, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the override file %v: %v", filename, err)
+	}
+	keyspaces := make(map[string]*KeyspaceOverride)
+	if err := json.Unmarshal(data, &keyspaces); err != nil {
+		return nil, fmt.Errorf("cannot parse the override file %v: %v", filename, err)
+	}
+	for name, ko := range keyspaces {
+		if ko == nil || ko.SrvKeyspace == nil {
+			return nil, fmt.Errorf("keyspace %v of the override file %v has no SrvKeyspace", name, filename)
+		}
+		for _, partition := range ko.SrvKeyspace.Partitions {
+			for _, shard := range partition.Shards {
+				if len(ko.EndPoints[shard.ShardName()]) == 0 {
+					return nil, fmt.Errorf("shard %v/%v of the override file %v has no endpoints", name, shard.ShardName(), filename)
+				}
+			}
+		}
+		log.Errorf("EMERGENCY OVERRIDE: the serving graph of keyspace %v is read from %v instead of the topology", name, filename)
+	}
+	srvTopoOverrideKeyspaces.Set(int64(len(keyspaces)))
+	return &OverrideSrvTopoServer{
+		SrvTopoServer: serv,
+		keyspaces:     keyspaces,
+		logger:        logutil.NewThrottledLogger("SrvTopoOverride", 1*time.Minute),
+	}, nil
+}
+
+// GetSrvKeyspaceNames returns the keyspaces of the underlying
+// SrvTopoServer and those of the override file. If the underlying
+// SrvTopoServer fails, only the latter are returned.
+func (ovs *OverrideSrvTopoServer) GetSrvKeyspaceNames(ctx context.Context, cell string) ([]string, error) {
+	names, err := ovs.SrvTopoServer.GetSrvKeyspaceNames(ctx, cell)
+	if err != nil {
+		ovs.logger.Warningf("cannot read the keyspaces of cell %v, only returning those of the override file: %v", cell, err)
+		names = nil
+	}
+	for name := range ovs.keyspaces {
+		found := false
+		for _, n := range names {
+			if n == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetSrvKeyspace returns the SrvKeyspace of the override file
+// for the overridden keyspaces.
+func (ovs *OverrideSrvTopoServer) GetSrvKeyspace(ctx context.Context, cell, keyspace string) (*topo.SrvKeyspace, error) {
+	ko, ok := ovs.keyspaces[keyspace]
+	if !ok {
+		return ovs.SrvTopoServer.GetSrvKeyspace(ctx, cell, keyspace)
+	}
+	srvTopoOverrideReads.Add([]string{keyspace, "SrvKeyspace"}, 1)
+	ovs.logger.Warningf("serving keyspace %v from the override file", keyspace)
+	return ko.SrvKeyspace, nil
+}
+
+// GetEndPoints returns the endpoints of the override file
+// for the shards of the overridden keyspaces.
+func (ovs *OverrideSrvTopoServer) GetEndPoints(ctx context.Context, cell, keyspace, shard string, tabletType topo.TabletType) (*topo.EndPoints, error) {
+	ko, ok := ovs.keyspaces[keyspace]
+	if !ok {
+		return ovs.SrvTopoServer.GetEndPoints(ctx, cell, keyspace, shard, tabletType)
+	}
+	srvTopoOverrideReads.Add([]string{keyspace, "EndPoints"}, 1)
+	ovs.logger.Warningf("serving keyspace %v from the override file", keyspace)
+	endPoints, ok := ko.EndPoints[shard][tabletType]
+	if !ok {
+		return nil, fmt.Errorf("no %v endpoints for %v/%v in the override file", tabletType, keyspace, shard)
+	}
+	return endPoints, nil
+}
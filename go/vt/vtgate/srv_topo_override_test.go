@@ -0,0 +1,106 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+)
+
+// downTopo is a SrvTopoServer whose topology is down.
+type downTopo struct {
+	sandboxTopo
+}
+
+func (dt *downTopo) GetSrvKeyspaceNames(ctx context.Context, cell string) ([]string, error) {
+	return nil, fmt.Errorf("topology is down")
+}
+
+func writeOverrideFile(t *testing.T, data string) string {
+	f, err := ioutil.TempFile("", "srv_topo_override")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestSrvTopoOverride(t *testing.T) {
+	filename := writeOverrideFile(t, `{
+  "TestSrvTopoOverride": {
+    "SrvKeyspace": {
+      "Partitions": {"master": {"Shards": [{"Name": "0"}]}},
+      "TabletTypes": ["master"]
+    },
+    "EndPoints": {
+      "0": {"master": {"entries": [{"uid": 1, "host": "db1", "named_port_map": {"_vtocc": 15001}}]}}
+    }
+  }
+}`)
+	defer os.Remove(filename)
+	createSandbox("TestOther")
+	ovs, err := LoadSrvTopoOverride(&downTopo{}, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srvTopoOverrideKeyspaces.Get() != 1 {
+		t.Errorf("VtgateSrvTopoOverrideKeyspaces: %v, want 1", srvTopoOverrideKeyspaces.Get())
+	}
+
+	names, err := ovs.GetSrvKeyspaceNames(context.Background(), "aa")
+	if err != nil || !reflect.DeepEqual(names, []string{"TestSrvTopoOverride"}) {
+		t.Errorf("GetSrvKeyspaceNames: %v, %v, want the keyspace of the override file", names, err)
+	}
+	ks, err := ovs.GetSrvKeyspace(context.Background(), "aa", "TestSrvTopoOverride")
+	if err != nil || ks.Partitions[topo.TYPE_MASTER].Shards[0].Name != "0" {
+		t.Errorf("GetSrvKeyspace: %+v, %v, want the keyspace of the override file", ks, err)
+	}
+	reads := srvTopoOverrideReads.Counts()["TestSrvTopoOverride.EndPoints"]
+	endPoints, err := ovs.GetEndPoints(context.Background(), "aa", "TestSrvTopoOverride", "0", topo.TYPE_MASTER)
+	if err != nil || len(endPoints.Entries) != 1 || endPoints.Entries[0].Host != "db1" {
+		t.Errorf("GetEndPoints: %+v, %v, want the endpoints of the override file", endPoints, err)
+	}
+	if _, err := ovs.GetEndPoints(context.Background(), "aa", "TestSrvTopoOverride", "0", topo.TYPE_REPLICA); err == nil {
+		t.Errorf("GetEndPoints(replica) didn't fail")
+	}
+	if got := srvTopoOverrideReads.Counts()["TestSrvTopoOverride.EndPoints"] - reads; got != 2 {
+		t.Errorf("VtgateSrvTopoOverrideReads: %v more, want 2", got)
+	}
+
+	// The other keyspaces are served by the topology.
+	if _, err := ovs.GetSrvKeyspace(context.Background(), "aa", "TestOther"); err != nil {
+		t.Errorf("GetSrvKeyspace(TestOther): %v", err)
+	}
+	endPoints, err = ovs.GetEndPoints(context.Background(), "aa", "TestOther", "0", topo.TYPE_MASTER)
+	if err != nil || len(endPoints.Entries) != 0 {
+		t.Errorf("GetEndPoints(TestOther): %+v, %v, want the endpoints of the sandbox", endPoints, err)
+	}
+}
+
+func TestSrvTopoOverrideErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"ks": {}}`,
+		`{"ks": {"SrvKeyspace": {"Partitions": {"master": {"Shards": [{"Name": "0"}]}}}}}`,
+		`not json`,
+	} {
+		filename := writeOverrideFile(t, data)
+		if _, err := LoadSrvTopoOverride(&downTopo{}, filename); err == nil {
+			t.Errorf("LoadSrvTopoOverride(%v) didn't fail", data)
+		}
+		os.Remove(filename)
+	}
+	if _, err := LoadSrvTopoOverride(&downTopo{}, "/nonexistent"); err == nil {
+		t.Errorf("LoadSrvTopoOverride(/nonexistent) didn't fail")
+	}
+}
@@ -15,6 +15,7 @@ import (
 
 	log "github.com/golang/glog"
 
+	"github.com/youtube/vitess/go/flagutil"
 	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/vt/health"
 	"github.com/youtube/vitess/go/vt/topo"
@@ -24,14 +25,24 @@ import (
 var (
 	srvTopoCacheTTL    = flag.Duration("srv_topo_cache_ttl", 1*time.Second, "how long to use cached entries for topology")
 	enableRemoteMaster = flag.Bool("enable_remote_master", false, "enable remote master access")
+	srvTopoWatch       = flag.Bool("srv_topo_watch", false, "watch the SrvKeyspace records instead of polling them, if the topology server supports it")
+
+	srvTopoFallbackCells flagutil.StringListValue
 )
 
+func init() {
+	flag.Var(&srvTopoFallbackCells, "srv_topo_fallback_cells", "comma-separated list of cells to read the SrvKeyspace records from, in order, when they can't be read from the local cell")
+}
+
 const (
 	queryCategory       = "query"
 	cachedCategory      = "cached"
 	errorCategory       = "error"
 	remoteQueryCategory = "remote-query"
 	remoteErrorCategory = "remote-error"
+	watchCategory       = "watch"
+	watchErrorCategory  = "watch-error"
+	fallbackCategory    = "fallback"
 )
 
 // SrvTopoServer is a subset of topo.Server that only contains the serving
@@ -45,15 +56,26 @@ type SrvTopoServer interface {
 }
 
 // ResilientSrvTopoServer is an implementation of SrvTopoServer based
-// on a topo.Server that uses a cache for two purposes:
-// - limit the QPS to the underlying topo.Server
+// on a SrvTopoBackend that uses a cache for two purposes:
+// - limit the QPS to the underlying SrvTopoBackend
 // - return the last known value of the data if there is an error
+// If watchSrvKeyspace is set and the SrvTopoBackend is a
+// topo.SrvKeyspaceWatcher, the SrvKeyspace entries are kept current
+// by watches instead of being read again when they expire.
+// A SrvKeyspace that can't be read from its cell, and that is
+// not cached, is read from the fallbackCells instead.
 type ResilientSrvTopoServer struct {
-	topoServer         topo.Server
+	topoServer         SrvTopoBackend
 	cacheTTL           time.Duration
 	enableRemoteMaster bool
+	watchSrvKeyspace   bool
+	fallbackCells      []string
 	counts             *stats.Counters
 
+	// srvKeyspaceFallbacks counts the SrvKeyspaces that were
+	// served from a fallback cell, which may be stale.
+	srvKeyspaceFallbacks *stats.MultiCounters
+
 	// mutex protects the cache map itself, not the individual
 	// values in the cache.
 	mutex                 sync.Mutex
@@ -121,6 +143,23 @@ type srvKeyspaceEntry struct {
 	value            *topo.SrvKeyspace
 	lastError        error
 	lastErrorContext context.Context
+
+	// watching is true while a watch keeps value current.
+	watching bool
+	// watchTime is when the last watch was started. A watch
+	// that fails is started again after cacheTTL.
+	watchTime time.Time
+	// stale is true if value is served after the topology
+	// server failed to return a newer one.
+	stale bool
+	// fallbackCell is the cell value was read from, if
+	// it couldn't be read from cell.
+	fallbackCell string
+	// lastSuccessTime is when value was last read from the
+	// topology server, or received from a watch.
+	lastSuccessTime time.Time
+	// errorCount counts the reads and watches that failed.
+	errorCount int64
 }
 
 type endPointsEntry struct {
@@ -179,20 +218,27 @@ func filterUnhealthyServers(endPoints *topo.EndPoints) *topo.EndPoints {
 }
 
 // NewResilientSrvTopoServer creates a new ResilientSrvTopoServer
-// based on the provided SrvTopoServer.
-func NewResilientSrvTopoServer(base topo.Server, counterPrefix string) *ResilientSrvTopoServer {
-	return &ResilientSrvTopoServer{
+// based on the provided SrvTopoBackend, which is usually the
+// topo.Server itself.
+func NewResilientSrvTopoServer(base SrvTopoBackend, counterPrefix string) *ResilientSrvTopoServer {
+	server := &ResilientSrvTopoServer{
 		topoServer:         base,
 		cacheTTL:           *srvTopoCacheTTL,
 		enableRemoteMaster: *enableRemoteMaster,
+		watchSrvKeyspace:   *srvTopoWatch,
+		fallbackCells:      srvTopoFallbackCells,
 		counts:             stats.NewCounters(counterPrefix + "Counts"),
 
+		srvKeyspaceFallbacks: stats.NewMultiCounters(counterPrefix+"SrvKeyspaceFallbackCount", []string{"Cell", "Keyspace", "FallbackCell"}),
+
 		srvKeyspaceNamesCache: make(map[string]*srvKeyspaceNamesEntry),
 		srvKeyspaceCache:      make(map[string]*srvKeyspaceEntry),
 		endPointsCache:        make(map[string]*endPointsEntry),
 
 		endPointCounters: newEndPointCounters(counterPrefix),
 	}
+	server.publishSrvKeyspaceHealth(counterPrefix)
+	return server
 }
 
 // GetSrvKeyspaceNames returns all keyspace names for the given cell.
@@ -266,6 +312,17 @@ func (server *ResilientSrvTopoServer) GetSrvKeyspace(context context.Context, ce
 	entry.mutex.Lock()
 	defer entry.mutex.Unlock()
 
+	// If the entry is watched, it's always current. Otherwise,
+	// try to start a watch every cacheTTL.
+	if watcher, ok := server.topoServer.(topo.SrvKeyspaceWatcher); ok && server.watchSrvKeyspace {
+		if !entry.watching && time.Now().Sub(entry.watchTime) >= server.cacheTTL {
+			server.startSrvKeyspaceWatch(context, watcher, entry)
+		}
+		if entry.watching {
+			return entry.value, entry.lastError
+		}
+	}
+
 	// If the entry is fresh enough, return it
 	if time.Now().Sub(entry.insertionTime) < server.cacheTTL {
 		return entry.value, entry.lastError
@@ -273,13 +330,23 @@ func (server *ResilientSrvTopoServer) GetSrvKeyspace(context context.Context, ce
 
 	// not in cache or too old, get the real value
 	result, err := server.topoServer.GetSrvKeyspace(cell, keyspace)
+	// The fallback cells are used if there is no cached value,
+	// or if it came from them too.
+	fallbackCell := ""
+	if err != nil && err != topo.ErrNoNode && (entry.insertionTime.IsZero() || entry.fallbackCell != "") {
+		if result, fallbackCell = server.getFallbackSrvKeyspace(context, cell, keyspace, err); fallbackCell != "" {
+			err = nil
+		}
+	}
 	if err != nil {
+		entry.errorCount++
 		if entry.insertionTime.IsZero() {
 			server.counts.Add(errorCategory, 1)
 			log.Errorf("GetSrvKeyspace(%v, %v, %v) failed: %v (no cached value, caching and returning error)", context, cell, keyspace, err)
 		} else {
 			server.counts.Add(cachedCategory, 1)
 			log.Warningf("GetSrvKeyspace(%v, %v, %v) failed: %v (returning cached value: %v %v)", context, cell, keyspace, err, entry.value, entry.lastError)
+			entry.stale = true
 			return entry.value, entry.lastError
 		}
 	}
@@ -289,9 +356,103 @@ func (server *ResilientSrvTopoServer) GetSrvKeyspace(context context.Context, ce
 	entry.value = result
 	entry.lastError = err
 	entry.lastErrorContext = context
+	entry.stale = fallbackCell != ""
+	entry.fallbackCell = fallbackCell
+	if err == nil {
+		entry.lastSuccessTime = entry.insertionTime
+	}
 	return result, err
 }
 
+// getFallbackSrvKeyspace reads the SrvKeyspace of keyspace from
+// the first fallback cell that returns it, after it couldn't be
+// read from cell because of cellErr. It returns the cell it was
+// read from, or "" if none did.
+func (server *ResilientSrvTopoServer) getFallbackSrvKeyspace(context context.Context, cell, keyspace string, cellErr error) (*topo.SrvKeyspace, string) {
+	for _, fallbackCell := range server.fallbackCells {
+		if fallbackCell == cell {
+			continue
+		}
+		result, err := server.topoServer.GetSrvKeyspace(fallbackCell, keyspace)
+		if err != nil {
+			log.Warningf("GetSrvKeyspace(%v, %v, %v) failed in fallback cell %v: %v", context, cell, keyspace, fallbackCell, err)
+			continue
+		}
+		server.counts.Add(fallbackCategory, 1)
+		server.srvKeyspaceFallbacks.Add([]string{cell, keyspace, fallbackCell}, 1)
+		log.Warningf("GetSrvKeyspace(%v, %v, %v) failed: %v (returning the possibly stale value of fallback cell %v)", context, cell, keyspace, cellErr, fallbackCell)
+		return result, fallbackCell
+	}
+	return nil, ""
+}
+
+// startSrvKeyspaceWatch starts watching the SrvKeyspace of entry,
+// and waits for its current value. If the watch can't be started,
+// the entry is left as is, and it's polled instead. It must be
+// called with the entry mutex held.
+func (server *ResilientSrvTopoServer) startSrvKeyspaceWatch(ctx context.Context, watcher topo.SrvKeyspaceWatcher, entry *srvKeyspaceEntry) {
+	entry.watchTime = time.Now()
+	notifications, stopWatching, err := watcher.WatchSrvKeyspace(entry.cell, entry.keyspace)
+	if err != nil {
+		server.counts.Add(watchErrorCategory, 1)
+		log.Warningf("WatchSrvKeyspace(%v, %v) failed: %v", entry.cell, entry.keyspace, err)
+		return
+	}
+	var value *topo.SrvKeyspace
+	var ok bool
+	select {
+	case value, ok = <-notifications:
+	case <-ctx.Done():
+		close(stopWatching)
+		return
+	}
+	if !ok {
+		server.counts.Add(watchErrorCategory, 1)
+		log.Warningf("WatchSrvKeyspace(%v, %v) failed before returning a value", entry.cell, entry.keyspace)
+		return
+	}
+	entry.watching = true
+	entry.lastErrorContext = ctx
+	entry.setWatchedValue(value)
+	go server.watchSrvKeyspaceEntry(entry, notifications)
+}
+
+// watchSrvKeyspaceEntry updates entry with the values of
+// notifications, until the watch fails. The last value is
+// then served as stale, until the watch can be started again
+// or the entry is polled.
+func (server *ResilientSrvTopoServer) watchSrvKeyspaceEntry(entry *srvKeyspaceEntry, notifications <-chan *topo.SrvKeyspace) {
+	for value := range notifications {
+		server.counts.Add(watchCategory, 1)
+		entry.mutex.Lock()
+		entry.setWatchedValue(value)
+		entry.mutex.Unlock()
+	}
+	server.counts.Add(watchErrorCategory, 1)
+	log.Warningf("Watch of SrvKeyspace(%v, %v) stopped, serving the last value until it's restarted", entry.cell, entry.keyspace)
+	entry.mutex.Lock()
+	entry.watching = false
+	entry.stale = true
+	entry.errorCount++
+	entry.mutex.Unlock()
+}
+
+// setWatchedValue stores a value received from a watch.
+// A nil value means that the SrvKeyspace doesn't exist, which
+// is reported with the context of the client that started the
+// watch. It must be called with the entry mutex held.
+func (entry *srvKeyspaceEntry) setWatchedValue(value *topo.SrvKeyspace) {
+	entry.insertionTime = time.Now()
+	entry.value = value
+	entry.lastError = nil
+	if value == nil {
+		entry.lastError = topo.ErrNoNode
+	}
+	entry.stale = false
+	entry.fallbackCell = ""
+	entry.lastSuccessTime = entry.insertionTime
+}
+
 // GetEndPoints return all endpoints for the given cell, keyspace, shard, and tablet type.
 func (server *ResilientSrvTopoServer) GetEndPoints(context context.Context, cell, keyspace, shard string, tabletType topo.TabletType) (result *topo.EndPoints, err error) {
 	shard = strings.ToLower(shard)
@@ -431,6 +592,9 @@ type SrvKeyspaceCacheStatus struct {
 	Value            *topo.SrvKeyspace
 	LastError        error
 	LastErrorContext context.Context
+	Watched          bool
+	Stale            bool
+	FallbackCell     string
 }
 
 // StatusAsHTML returns an HTML version of our status.
@@ -440,7 +604,16 @@ func (st *SrvKeyspaceCacheStatus) StatusAsHTML() template.HTML {
 		return template.HTML("No Data")
 	}
 
-	result := "<b>Partitions:</b><br>"
+	result := ""
+	if st.FallbackCell != "" {
+		result += "<b>Stale:</b>&nbsp;read from fallback cell " + st.FallbackCell + "<br>"
+	} else if st.Stale {
+		result += "<b>Stale:</b>&nbsp;the topology server could not be reached<br>"
+	}
+	if st.Watched {
+		result += "<b>Watched</b><br>"
+	}
+	result += "<b>Partitions:</b><br>"
 	for tabletType, keyspacePartition := range st.Value.Partitions {
 		result += "&nbsp;<b>" + string(tabletType) + "</b>"
 		for _, shard := range keyspacePartition.Shards {
@@ -574,6 +747,9 @@ func (server *ResilientSrvTopoServer) CacheStatus() *ResilientSrvTopoServerCache
 			Value:            entry.value,
 			LastError:        entry.lastError,
 			LastErrorContext: entry.lastErrorContext,
+			Watched:          entry.watching,
+			Stale:            entry.stale,
+			FallbackCell:     entry.fallbackCell,
 		})
 		entry.mutex.Unlock()
 	}
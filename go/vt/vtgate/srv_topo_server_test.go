@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/youtube/vitess/go/sync2"
 	"github.com/youtube/vitess/go/vt/health"
 	"github.com/youtube/vitess/go/vt/topo"
 	"golang.org/x/net/context"
@@ -218,6 +220,8 @@ func (ft *fakeTopo) UpdateKeyspace(ki *topo.KeyspaceInfo, existingVersion int64)
 func (ft *fakeTopo) GetKeyspace(keyspace string) (*topo.KeyspaceInfo, error)     { return nil, nil }
 func (ft *fakeTopo) GetKeyspaces() ([]string, error)                             { return nil, nil }
 func (ft *fakeTopo) DeleteKeyspaceShards(keyspace string) error                  { return nil }
+func (ft *fakeTopo) SaveVSchema(keyspace, vschema string) error                   { return nil }
+func (ft *fakeTopo) GetVSchema(keyspace string) (string, error)                   { return "", topo.ErrNoNode }
 func (ft *fakeTopo) CreateShard(keyspace, shard string, value *topo.Shard) error { return nil }
 func (ft *fakeTopo) UpdateShard(si *topo.ShardInfo, existingVersion int64) (int64, error) {
 	return 0, nil
@@ -418,3 +422,204 @@ func TestCachedErrors(t *testing.T) {
 		t.Fatalf("GetSrvKeyspace was not called again: %v times", ft.callCount)
 	}
 }
+
+// fakeTopoWatcher is a fakeTopo that can watch the SrvKeyspaces.
+// Every watch gets the values of notifications.
+type fakeTopoWatcher struct {
+	fakeTopo
+	notifications chan *topo.SrvKeyspace
+	watchErr      error
+	watchCount    int
+}
+
+func (ft *fakeTopoWatcher) WatchSrvKeyspace(cell, keyspace string) (<-chan *topo.SrvKeyspace, chan<- struct{}, error) {
+	ft.watchCount++
+	if ft.watchErr != nil {
+		return nil, nil, ft.watchErr
+	}
+	return ft.notifications, make(chan struct{}), nil
+}
+
+// counterPrefixRuns numbers the counter prefixes returned by
+// testCounterPrefix.
+var counterPrefixRuns sync2.AtomicInt64
+
+// testCounterPrefix returns a counter prefix for the
+// ResilientSrvTopoServer of the test name. The prefix is new for
+// every call, so that the test can be run more than once.
+func testCounterPrefix(name string) string {
+	return fmt.Sprintf("%s%d", name, counterPrefixRuns.Add(1))
+}
+
+// waitForSrvKeyspace waits until the cached SrvKeyspace
+// has the sharding column name want.
+func waitForSrvKeyspace(t *testing.T, rsts *ResilientSrvTopoServer, want string) {
+	for i := 0; i < 100; i++ {
+		ks, err := rsts.GetSrvKeyspace(context.Background(), "", "watched_ks")
+		if err == nil && ks.ShardingColumnName == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for SrvKeyspace %v", want)
+}
+
+func TestWatchSrvKeyspace(t *testing.T) {
+	// The keyspace can't be polled, only watched.
+	ft := &fakeTopoWatcher{
+		fakeTopo:      fakeTopo{keyspace: "test_ks"},
+		notifications: make(chan *topo.SrvKeyspace, 10),
+	}
+	rsts := NewResilientSrvTopoServer(ft, testCounterPrefix("TestWatchSrvKeyspace"))
+	rsts.watchSrvKeyspace = true
+
+	ft.notifications <- &topo.SrvKeyspace{ShardingColumnName: "c1"}
+	ks, err := rsts.GetSrvKeyspace(context.Background(), "", "watched_ks")
+	if err != nil || ks.ShardingColumnName != "c1" {
+		t.Fatalf("GetSrvKeyspace: %v, %v, want c1", ks, err)
+	}
+	if ft.watchCount != 1 || ft.callCount != 0 {
+		t.Errorf("watchCount, callCount: %v, %v, want 1, 0", ft.watchCount, ft.callCount)
+	}
+
+	// The changes are picked up without polling, even if the entry
+	// doesn't expire.
+	rsts.cacheTTL = time.Hour
+	ft.notifications <- &topo.SrvKeyspace{ShardingColumnName: "c2"}
+	waitForSrvKeyspace(t, rsts, "c2")
+	if ft.watchCount != 1 || ft.callCount != 0 {
+		t.Errorf("watchCount, callCount: %v, %v, want 1, 0", ft.watchCount, ft.callCount)
+	}
+
+	// The watch fails, and so does the topology server: the last
+	// value is served as stale.
+	close(ft.notifications)
+	for i := 0; i < 100 && rsts.CacheStatus().SrvKeyspaces[0].Watched; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	rsts.cacheTTL = 0
+	ft.watchErr = fmt.Errorf("topo outage")
+	ks, err = rsts.GetSrvKeyspace(context.Background(), "", "watched_ks")
+	if err != nil || ks.ShardingColumnName != "c2" {
+		t.Fatalf("GetSrvKeyspace: %v, %v, want stale c2", ks, err)
+	}
+	if ft.watchCount != 2 || ft.callCount != 1 {
+		t.Errorf("watchCount, callCount: %v, %v, want 2, 1", ft.watchCount, ft.callCount)
+	}
+	if status := rsts.CacheStatus().SrvKeyspaces[0]; status.Watched || !status.Stale {
+		t.Errorf("CacheStatus: %+v, want stale", status)
+	}
+
+	// The watch is started again.
+	ft.watchErr = nil
+	ft.notifications = make(chan *topo.SrvKeyspace, 10)
+	ft.notifications <- &topo.SrvKeyspace{ShardingColumnName: "c3"}
+	ks, err = rsts.GetSrvKeyspace(context.Background(), "", "watched_ks")
+	if err != nil || ks.ShardingColumnName != "c3" {
+		t.Fatalf("GetSrvKeyspace: %v, %v, want c3", ks, err)
+	}
+	if status := rsts.CacheStatus().SrvKeyspaces[0]; !status.Watched || status.Stale {
+		t.Errorf("CacheStatus: %+v, want watched", status)
+	}
+
+	// A deleted SrvKeyspace is reported as such.
+	ft.notifications <- nil
+	for i := 0; i < 100; i++ {
+		if _, err = rsts.GetSrvKeyspace(context.Background(), "", "watched_ks"); err != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != topo.ErrNoNode {
+		t.Errorf("GetSrvKeyspace: %v, want %v", err, topo.ErrNoNode)
+	}
+}
+
+// fakeTopoCells is a fakeTopo whose SrvKeyspaces
+// depend on the cell, and whose cells can be down.
+type fakeTopoCells struct {
+	fakeTopo
+	columns map[string]string
+	down    map[string]bool
+	calls   []string
+}
+
+func (ft *fakeTopoCells) GetSrvKeyspace(cell, keyspace string) (*topo.SrvKeyspace, error) {
+	ft.calls = append(ft.calls, cell)
+	if ft.down[cell] {
+		return nil, fmt.Errorf("cell %v is down", cell)
+	}
+	column, ok := ft.columns[cell]
+	if !ok {
+		return nil, topo.ErrNoNode
+	}
+	return &topo.SrvKeyspace{ShardingColumnName: column}, nil
+}
+
+func TestSrvKeyspaceFallbackCells(t *testing.T) {
+	ft := &fakeTopoCells{
+		columns: map[string]string{"cell1": "c1", "cell2": "c2", "cell3": "c3"},
+		down:    map[string]bool{"cell1": true, "cell2": true},
+	}
+	rsts := NewResilientSrvTopoServer(ft, testCounterPrefix("TestSrvKeyspaceFallbackCells"))
+	rsts.fallbackCells = []string{"cell2", "cell3"}
+	rsts.cacheTTL = 0
+
+	// The first fallback cell that works is used.
+	ks, err := rsts.GetSrvKeyspace(context.Background(), "cell1", "test_ks")
+	if err != nil || ks.ShardingColumnName != "c3" {
+		t.Fatalf("GetSrvKeyspace: %v, %v, want c3", ks, err)
+	}
+	if want := []string{"cell1", "cell2", "cell3"}; !reflect.DeepEqual(ft.calls, want) {
+		t.Errorf("calls: %v, want %v", ft.calls, want)
+	}
+	if status := rsts.CacheStatus().SrvKeyspaces[0]; status.FallbackCell != "cell3" || !status.Stale {
+		t.Errorf("CacheStatus: %+v, want stale from cell3", status)
+	}
+	if got := rsts.srvKeyspaceFallbacks.Counts()["cell1.test_ks.cell3"]; got != 1 {
+		t.Errorf("srvKeyspaceFallbacks: %v, want 1", got)
+	}
+
+	// The value of a fallback cell is read again from them.
+	ft.calls = nil
+	ft.down["cell2"] = false
+	ks, err = rsts.GetSrvKeyspace(context.Background(), "cell1", "test_ks")
+	if err != nil || ks.ShardingColumnName != "c2" {
+		t.Fatalf("GetSrvKeyspace: %v, %v, want c2", ks, err)
+	}
+	if want := []string{"cell1", "cell2"}; !reflect.DeepEqual(ft.calls, want) {
+		t.Errorf("calls: %v, want %v", ft.calls, want)
+	}
+
+	// Once the cell is back, its own value is used.
+	ft.down["cell1"] = false
+	ks, err = rsts.GetSrvKeyspace(context.Background(), "cell1", "test_ks")
+	if err != nil || ks.ShardingColumnName != "c1" {
+		t.Fatalf("GetSrvKeyspace: %v, %v, want c1", ks, err)
+	}
+	if status := rsts.CacheStatus().SrvKeyspaces[0]; status.FallbackCell != "" || status.Stale {
+		t.Errorf("CacheStatus: %+v, want fresh", status)
+	}
+
+	// A cached value of the cell is preferred to the fallback cells.
+	ft.calls = nil
+	ft.down["cell1"] = true
+	ks, err = rsts.GetSrvKeyspace(context.Background(), "cell1", "test_ks")
+	if err != nil || ks.ShardingColumnName != "c1" {
+		t.Fatalf("GetSrvKeyspace: %v, %v, want cached c1", ks, err)
+	}
+	if want := []string{"cell1"}; !reflect.DeepEqual(ft.calls, want) {
+		t.Errorf("calls: %v, want %v", ft.calls, want)
+	}
+
+	// A keyspace that doesn't exist in the cell is not looked up
+	// in the fallback cells.
+	ft.calls = nil
+	delete(ft.columns, "cell3")
+	if _, err := rsts.GetSrvKeyspace(context.Background(), "cell3", "test_ks"); err != topo.ErrNoNode {
+		t.Errorf("GetSrvKeyspace: %v, want %v", err, topo.ErrNoNode)
+	}
+	if want := []string{"cell3"}; !reflect.DeepEqual(ft.calls, want) {
+		t.Errorf("calls: %v, want %v", ft.calls, want)
+	}
+}
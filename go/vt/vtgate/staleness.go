@@ -0,0 +1,136 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/health"
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+)
+
+// The replica and rdonly reads can bound the replication lag of the
+// tablets they're sent to, with the MAX_STALENESS hint or the
+// MaxStaleness of their session. The lag of a tablet is the upper
+// bound it reports in its health. A tablet whose lag is high is
+// never within a bound, and a tablet that doesn't report its lag is
+// assumed to be caught up.
+
+var staleReadsToMaster = flag.Bool("stale_reads_to_master", false, "send the reads with a max staleness to the master when a shard has no replica within it, instead of failing them")
+
+// Results of the reads that have no tablet within their staleness.
+const (
+	// StaleReadRejected means the read failed.
+	StaleReadRejected = "Rejected"
+	// StaleReadToMaster means the read was sent to the master.
+	StaleReadToMaster = "Master"
+)
+
+var staleReads = stats.NewMultiCounters("VtgateStaleReads", []string{"Keyspace", "DbType", "Result"})
+
+// StalenessError is returned by the reads with a max staleness
+// when a shard has no tablet of their type within it.
+type StalenessError struct {
+	Keyspace     string
+	Shard        string
+	TabletType   topo.TabletType
+	MaxStaleness time.Duration
+}
+
+func (e *StalenessError) Error() string {
+	return fmt.Sprintf("no %v tablet of %s/%s has a replication lag under %v", e.TabletType, e.Keyspace, e.Shard, e.MaxStaleness)
+}
+
+// endPointLag returns the replication lag that ep reports. ok is
+// false if ep reports a high lag, or a lag that can't be parsed.
+func endPointLag(ep topo.EndPoint) (lag time.Duration, ok bool) {
+	if !endPointIsHealthy(ep) {
+		return 0, false
+	}
+	value, reported := ep.Health[health.ReplicationLagSeconds]
+	if !reported {
+		return 0, true
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// stalenessFilter is a SrvTopoServer that only returns the endpoints
+// whose replication lag is within maxStaleness.
+type stalenessFilter struct {
+	SrvTopoServer
+	maxStaleness time.Duration
+}
+
+// GetEndPoints returns the endpoints of the shard within the
+// staleness, which may be none.
+func (f stalenessFilter) GetEndPoints(ctx context.Context, cell, keyspace, shard string, tabletType topo.TabletType) (*topo.EndPoints, error) {
+	endPoints, err := f.SrvTopoServer.GetEndPoints(ctx, cell, keyspace, shard, tabletType)
+	if err != nil {
+		return nil, err
+	}
+	fresh := make([]topo.EndPoint, 0, len(endPoints.Entries))
+	for _, ep := range endPoints.Entries {
+		if lag, ok := endPointLag(ep); ok && lag <= f.maxStaleness {
+			fresh = append(fresh, ep)
+		}
+	}
+	return &topo.EndPoints{Entries: fresh}, nil
+}
+
+// withMaxStaleness returns the ScatterConn that only sends the
+// queries to the tablets of stc whose replication lag is within
+// maxStaleness.
+func (stc *ScatterConn) withMaxStaleness(maxStaleness time.Duration) *ScatterConn {
+	stc.mu.Lock()
+	defer stc.mu.Unlock()
+	if stc.stalenessConns == nil {
+		stc.stalenessConns = make(map[time.Duration]*ScatterConn)
+	}
+	conn, ok := stc.stalenessConns[maxStaleness]
+	if !ok {
+		conn = stc.derive(stalenessFilter{stc.toposerv, maxStaleness}, stc.cell)
+		stc.stalenessConns[maxStaleness] = conn
+	}
+	return conn
+}
+
+// boundStaleness returns the ScatterConn that sends the read of
+// params to the tablets of stc within the max staleness of vcursor.
+// If a shard has none, the read fails with a StalenessError, or is
+// sent to the master if the stale_reads_to_master flag is set.
+func (rtr *Router) boundStaleness(vcursor *requestContext, params *scatterParams, stc *ScatterConn) (*ScatterConn, error) {
+	tabletType := vcursor.query.TabletType
+	filter := stalenessFilter{stc.toposerv, vcursor.maxStaleness}
+	for shard := range params.shardVars {
+		endPoints, err := filter.GetEndPoints(vcursor.ctx, stc.cell, params.ks, shard, tabletType)
+		if err == nil && len(endPoints.Entries) != 0 {
+			continue
+		}
+		if !*staleReadsToMaster {
+			staleReads.Add([]string{params.ks, string(tabletType), StaleReadRejected}, 1)
+			return nil, &StalenessError{
+				Keyspace:     params.ks,
+				Shard:        shard,
+				TabletType:   tabletType,
+				MaxStaleness: vcursor.maxStaleness,
+			}
+		}
+		staleReads.Add([]string{params.ks, string(tabletType), StaleReadToMaster}, 1)
+		vcursor.query.TabletType = topo.TYPE_MASTER
+		return rtr.scatterConn.inCell(params.cell), nil
+	}
+	return stc.withMaxStaleness(vcursor.maxStaleness), nil
+}
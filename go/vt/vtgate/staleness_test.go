@@ -0,0 +1,109 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/health"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// lagTopo is a SrvTopoServer whose replica endpoints
+// report a replication lag.
+type lagTopo struct {
+	sandboxTopo
+	lag string
+}
+
+func (lt *lagTopo) GetEndPoints(ctx context.Context, cell, keyspace, shard string, tabletType topo.TabletType) (*topo.EndPoints, error) {
+	endPoints, err := lt.sandboxTopo.GetEndPoints(ctx, cell, keyspace, shard, tabletType)
+	if err != nil || tabletType == topo.TYPE_MASTER {
+		return endPoints, err
+	}
+	lagging := &topo.EndPoints{}
+	for _, ep := range endPoints.Entries {
+		ep.Health = map[string]string{health.ReplicationLagSeconds: lt.lag}
+		lagging.Entries = append(lagging.Entries, ep)
+	}
+	return lagging, nil
+}
+
+func TestEndPointLag(t *testing.T) {
+	for _, tcase := range []struct {
+		health map[string]string
+		lag    time.Duration
+		ok     bool
+	}{
+		{nil, 0, true},
+		{map[string]string{health.ReplicationLagSeconds: "4"}, 4 * time.Second, true},
+		{map[string]string{health.ReplicationLag: health.ReplicationLagHigh}, 0, false},
+		{map[string]string{health.ReplicationLagSeconds: "x"}, 0, false},
+	} {
+		lag, ok := endPointLag(topo.EndPoint{Health: tcase.health})
+		if lag != tcase.lag || ok != tcase.ok {
+			t.Errorf("endPointLag(%v): %v, %v, want %v, %v", tcase.health, lag, ok, tcase.lag, tcase.ok)
+		}
+	}
+}
+
+func TestRouterMaxStaleness(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	s.MapTestConn("-20", &sandboxConn{})
+	serv := &lagTopo{lag: "8"}
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Millisecond, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	session := &proto.Session{MaxStaleness: int64(5 * time.Second)}
+
+	query := &proto.Query{
+		Sql:        "select * from user where id = 1",
+		TabletType: topo.TYPE_REPLICA,
+		Session:    session,
+	}
+	_, err = router.Execute(context.Background(), query)
+	if _, ok := err.(*StalenessError); !ok {
+		t.Errorf("Execute: %v, want a StalenessError", err)
+	}
+
+	// The hint overrides the staleness of the session.
+	query = &proto.Query{
+		Sql:        "select /*vt+ MAX_STALENESS=10s */ * from user where id = 1",
+		TabletType: topo.TYPE_REPLICA,
+		Session:    session,
+	}
+	if _, err := router.Execute(context.Background(), query); err != nil {
+		t.Errorf("Execute with MAX_STALENESS=10s: %v", err)
+	}
+	if query.TabletType != topo.TYPE_REPLICA {
+		t.Errorf("TabletType: %v, want replica", query.TabletType)
+	}
+
+	*staleReadsToMaster = true
+	defer func() { *staleReadsToMaster = false }()
+	counts := staleReads.Counts()
+	query = &proto.Query{
+		Sql:        "select * from user where id = 1",
+		TabletType: topo.TYPE_REPLICA,
+		Session:    session,
+	}
+	if _, err := router.Execute(context.Background(), query); err != nil {
+		t.Errorf("Execute with stale_reads_to_master: %v", err)
+	}
+	if query.TabletType != topo.TYPE_MASTER {
+		t.Errorf("TabletType: %v, want master", query.TabletType)
+	}
+	key := "TestRouter.replica." + StaleReadToMaster
+	if got := staleReads.Counts()[key] - counts[key]; got != 1 {
+		t.Errorf("%v: %v more, want 1", key, got)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+var (
+	tabletSelectionPolicy = flag.String("tablet_selection_policy", "random", "how vtgate picks the tablet of a shard it connects to: random, or health to prefer the tablets that are not lagging, then those with the fewest errors and queries")
+	tabletHealthWindow    = flag.Duration("tablet_health_window", 10*time.Second, "time window over which vtgate computes the error rate and qps of each tablet")
+	warmStandbyConn       = flag.Bool("warm_standby_conn", false, "keep a warmed up connection to a second tablet of each shard, to fail over to without dialing")
+)
+
+// TabletHealth is the health of a tablet as seen by
+// vtgate, which a SelectionPolicy picks tablets by.
+type TabletHealth struct {
+	EndPoint topo.EndPoint
+	// Lagging is true if the health check of the
+	// tablet reports a high replication lag.
+	Lagging bool
+	// ErrorRate is the fraction of the recent queries
+	// to the tablet that failed to reach it.
+	ErrorRate float64
+	// QPS is the recent rate of queries vtgate sent to the tablet.
+	QPS float64
+}
+
+// SelectionPolicy picks the tablet of a shard that a
+// Balancer returns among the ones that are not marked down.
+type SelectionPolicy interface {
+	// Select returns the index of the tablet to use
+	// in candidates, which are in a random order.
+	Select(candidates []TabletHealth) int
+}
+
+// RandomPolicy picks a random tablet.
+type RandomPolicy struct{}
+
+// Select is part of the SelectionPolicy interface.
+func (RandomPolicy) Select(candidates []TabletHealth) int {
+	return 0
+}
+
+// HealthPolicy picks the tablet with the lowest replication lag,
+// then the lowest error rate, then the lowest qps. All the tablets
+// of a Balancer are in the cell of its ShardConn, which is the cell
+// of vtgate unless the query prefers another one.
+type HealthPolicy struct{}
+
+// Select is part of the SelectionPolicy interface.
+func (HealthPolicy) Select(candidates []TabletHealth) int {
+	best := 0
+	for i := 1; i < len(candidates); i++ {
+		if healthier(&candidates[i], &candidates[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// healthier returns true if th1 is a better pick than th2.
+func healthier(th1, th2 *TabletHealth) bool {
+	if th1.Lagging != th2.Lagging {
+		return !th1.Lagging
+	}
+	if th1.ErrorRate != th2.ErrorRate {
+		return th1.ErrorRate < th2.ErrorRate
+	}
+	return th1.QPS < th2.QPS
+}
+
+var selectionPolicies = map[string]SelectionPolicy{
+	"random": RandomPolicy{},
+	"health": HealthPolicy{},
+}
+
+// RegisterSelectionPolicy registers a SelectionPolicy under name,
+// for the tablet_selection_policy flag.
+func RegisterSelectionPolicy(name string, policy SelectionPolicy) {
+	if _, ok := selectionPolicies[name]; ok {
+		log.Fatalf("Selection policy %s already exists", name)
+	}
+	selectionPolicies[name] = policy
+}
+
+// GetSelectionPolicy returns the policy to use, described by the command line flag.
+func GetSelectionPolicy() SelectionPolicy {
+	policy, ok := selectionPolicies[*tabletSelectionPolicy]
+	if !ok {
+		log.Fatalf("No selection policy registered for %s", *tabletSelectionPolicy)
+	}
+	return policy
+}
+
+// tabletStats counts the queries sent to a tablet and their
+// failures, over the current and the previous window.
+type tabletStats struct {
+	windowStart  time.Time
+	requests     int64
+	errors       int64
+	prevRequests int64
+	prevErrors   int64
+}
+
+// roll starts a new window if the current one is over.
+func (ts *tabletStats) roll(now time.Time, window time.Duration) {
+	elapsed := now.Sub(ts.windowStart)
+	if elapsed < window {
+		return
+	}
+	if elapsed < 2*window {
+		ts.prevRequests, ts.prevErrors = ts.requests, ts.errors
+	} else {
+		ts.prevRequests, ts.prevErrors = 0, 0
+	}
+	ts.windowStart = now
+	ts.requests, ts.errors = 0, 0
+}
+
+// record records a query that failed if failed is true.
+func (ts *tabletStats) record(now time.Time, window time.Duration, failed bool) {
+	ts.roll(now, window)
+	ts.requests++
+	if failed {
+		ts.errors++
+	}
+}
+
+// health returns the health of the tablet of endPoint.
+func (ts *tabletStats) health(now time.Time, window time.Duration, endPoint topo.EndPoint) TabletHealth {
+	ts.roll(now, window)
+	th := TabletHealth{
+		EndPoint: endPoint,
+		Lagging:  !endPointIsHealthy(endPoint),
+	}
+	requests := ts.requests + ts.prevRequests
+	if requests != 0 {
+		th.ErrorRate = float64(ts.errors+ts.prevErrors) / float64(requests)
+	}
+	if elapsed := window + now.Sub(ts.windowStart); elapsed > 0 {
+		th.QPS = float64(requests) / elapsed.Seconds()
+	}
+	return th
+}
+
+// tabletFailed returns true if err means that
+// the query couldn't be served by the tablet.
+func tabletFailed(err error) bool {
+	if err == nil {
+		return false
+	}
+	if serverError, ok := err.(*tabletconn.ServerError); ok {
+		return serverError.Code == tabletconn.ERR_RETRY || serverError.Code == tabletconn.ERR_FATAL
+	}
+	return true
+}
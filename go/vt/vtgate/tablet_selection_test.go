@@ -0,0 +1,156 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/health"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+)
+
+// This file uses the sandbox_test framework.
+
+func TestHealthPolicy(t *testing.T) {
+	candidates := []TabletHealth{
+		{EndPoint: topo.EndPoint{Uid: 0}, Lagging: true},
+		{EndPoint: topo.EndPoint{Uid: 1}, ErrorRate: 0.5},
+		{EndPoint: topo.EndPoint{Uid: 2}, QPS: 100},
+		{EndPoint: topo.EndPoint{Uid: 3}, QPS: 10},
+	}
+	if got := (HealthPolicy{}).Select(candidates); got != 3 {
+		t.Errorf("Select: %d, want 3", got)
+	}
+	if got := (RandomPolicy{}).Select(candidates); got != 0 {
+		t.Errorf("RandomPolicy.Select: %d, want 0", got)
+	}
+}
+
+func TestTabletStats(t *testing.T) {
+	var ts tabletStats
+	now := time.Now()
+	ts.record(now, time.Second, true)
+	ts.record(now, time.Second, false)
+	th := ts.health(now, time.Second, topo.EndPoint{})
+	if th.ErrorRate != 0.5 || th.Lagging {
+		t.Errorf("health: %+v, want an error rate of 0.5", th)
+	}
+	// The previous window still counts.
+	th = ts.health(now.Add(1500*time.Millisecond), time.Second, topo.EndPoint{})
+	if th.ErrorRate != 0.5 {
+		t.Errorf("health in the next window: %+v, want an error rate of 0.5", th)
+	}
+	th = ts.health(now.Add(5*time.Second), time.Second, topo.EndPoint{})
+	if th.ErrorRate != 0 || th.QPS != 0 {
+		t.Errorf("health after two windows: %+v, want no errors and no qps", th)
+	}
+	lagging := topo.EndPoint{Health: map[string]string{health.ReplicationLag: health.ReplicationLagHigh}}
+	if th := ts.health(now, time.Second, lagging); !th.Lagging {
+		t.Errorf("health of a lagging tablet: %+v, want Lagging", th)
+	}
+}
+
+func TestTabletFailed(t *testing.T) {
+	testcases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{tabletconn.OperationalError("conn"), true},
+		{&tabletconn.ServerError{Code: tabletconn.ERR_RETRY}, true},
+		{&tabletconn.ServerError{Code: tabletconn.ERR_NORMAL}, false},
+	}
+	for _, tcase := range testcases {
+		if got := tabletFailed(tcase.err); got != tcase.want {
+			t.Errorf("tabletFailed(%v): %v, want %v", tcase.err, got, tcase.want)
+		}
+	}
+}
+
+func TestBalancerHealthPolicy(t *testing.T) {
+	getEndPoints := func() (*topo.EndPoints, error) {
+		return &topo.EndPoints{
+			Entries: []topo.EndPoint{
+				{Uid: 0, Health: map[string]string{health.ReplicationLag: health.ReplicationLagHigh}},
+				{Uid: 1},
+				{Uid: 2},
+			},
+		}, nil
+	}
+	blc := NewBalancer(getEndPoints, RETRY_DELAY)
+	blc.policy = HealthPolicy{}
+	if _, err := blc.Get(); err != nil {
+		t.Fatal(err)
+	}
+	blc.Record(1, tabletconn.OperationalError("conn"))
+	blc.Record(2, nil)
+	for i := 0; i < 10; i++ {
+		endPoint, err := blc.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if endPoint.Uid != 2 {
+			t.Fatalf("Get: %d, want 2", endPoint.Uid)
+		}
+	}
+	blc.MarkDown(2, "down")
+	if endPoint, _ := blc.Get(); endPoint.Uid != 1 {
+		t.Errorf("Get with 2 marked down: %d, want 1", endPoint.Uid)
+	}
+	if blc.Available(2) {
+		t.Errorf("Available(2): true, want false")
+	}
+	if len(blc.Health()) != 3 {
+		t.Errorf("Health: %v, want 3 tablets", blc.Health())
+	}
+}
+
+func TestShardConnWarmStandby(t *testing.T) {
+	s := createSandbox("TestShardConnWarmStandby")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("0", sbc1)
+	sdc := NewShardConn(context.Background(), new(sandboxTopo), "aa", "TestShardConnWarmStandby", "0", "", 1*time.Millisecond, 3, 1*time.Second)
+	sdc.warmStandby = true
+	if _, err := sdc.Execute(context.Background(), "select 1", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		sdc.mu.Lock()
+		warm := sdc.standbyConn != nil
+		sdc.mu.Unlock()
+		if warm {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("the standby connection was not dialed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The tablet in use fails, and the query
+	// fails over to the standby connection.
+	first, second := sbc0, sbc1
+	if sdc.conn.EndPoint().Uid == 1 {
+		first, second = sbc1, sbc0
+	}
+	first.mustFailConn = 1
+	if _, err := sdc.Execute(context.Background(), "select 1", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if second.ExecCount.Get() != 1 {
+		t.Errorf("ExecCount: %d, want 1", second.ExecCount.Get())
+	}
+	// The dialer may still be running in warmUp.
+	s.sandmu.Lock()
+	defer s.sandmu.Unlock()
+	if s.DialCounter != 2 {
+		t.Errorf("DialCounter: %d, want 2", s.DialCounter)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/timer"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+var tabletTypeOverrides = stats.NewMultiCounters("VtgateTabletTypeOverrides", []string{"Keyspace", "DbType", "TargetDbType"})
+
+// tabletTypeRules maps, by keyspace, the tablet types requested by
+// the queries to the tablet types they are routed to instead.
+type tabletTypeRules struct {
+	mu    sync.Mutex
+	rules map[string]map[topo.TabletType]topo.TabletType
+}
+
+func newTabletTypeRules() *tabletTypeRules {
+	return &tabletTypeRules{}
+}
+
+// set replaces all the rules.
+func (ttr *tabletTypeRules) set(rules map[string]map[topo.TabletType]topo.TabletType) {
+	ttr.mu.Lock()
+	defer ttr.mu.Unlock()
+	ttr.rules = rules
+}
+
+// override returns the tablet type the queries of tabletType
+// to keyspace are routed to.
+func (ttr *tabletTypeRules) override(keyspace string, tabletType topo.TabletType) topo.TabletType {
+	ttr.mu.Lock()
+	defer ttr.mu.Unlock()
+	if target, ok := ttr.rules[keyspace][tabletType]; ok {
+		return target
+	}
+	return tabletType
+}
+
+// planKeyspace returns the keyspace of the first table of plan,
+// or "" if it has none.
+func planKeyspace(plan *planbuilder.Plan) string {
+	for ; plan != nil; plan = plan.Left {
+		if plan.Table != nil {
+			return plan.Table.Keyspace.Name
+		}
+	}
+	return ""
+}
+
+// applyTabletTypeRules changes the tablet type of the query of
+// vcursor if the keyspace of plan has a rule for it. The queries
+// of a transaction, and the master queries, are not changed.
+func (rtr *Router) applyTabletTypeRules(vcursor *requestContext, plan *planbuilder.Plan) {
+	if vcursor.query.TabletType == topo.TYPE_MASTER {
+		return
+	}
+	if vcursor.query.Session != nil && vcursor.query.Session.InTransaction {
+		return
+	}
+	keyspace := planKeyspace(plan)
+	if keyspace == "" {
+		return
+	}
+	target := rtr.tabletTypeRules.override(keyspace, vcursor.query.TabletType)
+	if target == vcursor.query.TabletType || target == topo.TYPE_MASTER {
+		return
+	}
+	tabletTypeOverrides.Add([]string{keyspace, string(vcursor.query.TabletType), string(target)}, 1)
+	vcursor.query.TabletType = target
+}
+
+// TabletTypeRuleSource returns the tablet type rules of the
+// keyspaces. It's satisfied by topo.Server.
+type TabletTypeRuleSource interface {
+	GetKeyspaces() ([]string, error)
+	GetKeyspace(keyspace string) (*topo.KeyspaceInfo, error)
+}
+
+// TabletTypeRuleWatcher reloads the tablet type rules of a Router
+// from the keyspaces in the topology.
+type TabletTypeRuleWatcher struct {
+	source TabletTypeRuleSource
+	router *Router
+	ticks  *timer.Timer
+}
+
+// NewTabletTypeRuleWatcher creates a TabletTypeRuleWatcher that
+// checks the keyspaces of source every interval.
+func NewTabletTypeRuleWatcher(source TabletTypeRuleSource, interval time.Duration, router *Router) *TabletTypeRuleWatcher {
+	return &TabletTypeRuleWatcher{
+		source: source,
+		router: router,
+		ticks:  timer.NewTimer(interval),
+	}
+}
+
+// Open starts checking the keyspaces in the background.
+func (tw *TabletTypeRuleWatcher) Open() {
+	tw.ticks.Start(func() {
+		if err := tw.Check(); err != nil {
+			log.Errorf("Could not reload the tablet type rules from the topology: %v", err)
+		}
+	})
+}
+
+// Close stops checking the keyspaces.
+func (tw *TabletTypeRuleWatcher) Close() {
+	tw.ticks.Stop()
+}
+
+// Check reads the tablet type rules of all the keyspaces, and
+// replaces those of the router. If any keyspace can't be read,
+// the router keeps its previous rules.
+func (tw *TabletTypeRuleWatcher) Check() error {
+	keyspaces, err := tw.source.GetKeyspaces()
+	if err != nil {
+		return err
+	}
+	rules := make(map[string]map[topo.TabletType]topo.TabletType)
+	for _, keyspace := range keyspaces {
+		ki, err := tw.source.GetKeyspace(keyspace)
+		if err == topo.ErrNoNode {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(ki.TabletTypeRules) != 0 {
+			rules[keyspace] = ki.TabletTypeRules
+		}
+	}
+	tw.router.tabletTypeRules.set(rules)
+	return nil
+}
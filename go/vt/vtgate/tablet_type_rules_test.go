@@ -0,0 +1,101 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// fakeTabletTypeRuleSource is a TabletTypeRuleSource with
+// the keyspaces of a map.
+type fakeTabletTypeRuleSource struct {
+	keyspaces map[string]*topo.Keyspace
+}
+
+func (fs *fakeTabletTypeRuleSource) GetKeyspaces() ([]string, error) {
+	var keyspaces []string
+	for keyspace := range fs.keyspaces {
+		keyspaces = append(keyspaces, keyspace)
+	}
+	return keyspaces, nil
+}
+
+func (fs *fakeTabletTypeRuleSource) GetKeyspace(keyspace string) (*topo.KeyspaceInfo, error) {
+	value, ok := fs.keyspaces[keyspace]
+	if !ok {
+		return nil, topo.ErrNoNode
+	}
+	return topo.NewKeyspaceInfo(keyspace, value, 0), nil
+}
+
+func TestTabletTypeRules(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	s.MapTestConn("-20", &sandboxConn{})
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	source := &fakeTabletTypeRuleSource{
+		keyspaces: map[string]*topo.Keyspace{
+			"TestRouter": &topo.Keyspace{
+				TabletTypeRules: map[topo.TabletType]topo.TabletType{
+					topo.TYPE_REPLICA: topo.TYPE_RDONLY,
+				},
+			},
+			"other": &topo.Keyspace{},
+		},
+	}
+	tw := NewTabletTypeRuleWatcher(source, 1*time.Hour, router)
+	if err := tw.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	sql := "select * from user where id = 1"
+	for _, tcase := range []struct {
+		tabletType    topo.TabletType
+		inTransaction bool
+		want          topo.TabletType
+	}{
+		{topo.TYPE_REPLICA, false, topo.TYPE_RDONLY},
+		{topo.TYPE_RDONLY, false, topo.TYPE_RDONLY},
+		{topo.TYPE_MASTER, false, topo.TYPE_MASTER},
+		{topo.TYPE_REPLICA, true, topo.TYPE_REPLICA},
+	} {
+		query := &proto.Query{
+			Sql:        sql,
+			TabletType: tcase.tabletType,
+			Session:    &proto.Session{InTransaction: tcase.inTransaction},
+		}
+		if _, err := router.Execute(context.Background(), query); err != nil {
+			t.Fatal(err)
+		}
+		if query.TabletType != tcase.want {
+			t.Errorf("TabletType of %v (in transaction: %v): %v, want %v", tcase.tabletType, tcase.inTransaction, query.TabletType, tcase.want)
+		}
+	}
+
+	// The rule is removed.
+	source.keyspaces["TestRouter"] = &topo.Keyspace{}
+	if err := tw.Check(); err != nil {
+		t.Fatal(err)
+	}
+	query := &proto.Query{Sql: sql, TabletType: topo.TYPE_REPLICA}
+	if _, err := router.Execute(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+	if query.TabletType != topo.TYPE_REPLICA {
+		t.Errorf("TabletType: %v, want replica", query.TabletType)
+	}
+}
@@ -6,13 +6,20 @@ package vtgate
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
+	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/vt/key"
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/vtgate/proto"
 	"golang.org/x/net/context"
 )
 
+// overlappingShards counts the queries routed to one side of
+// the overlapping shards of a keyspace that's being resharded.
+var overlappingShards = stats.NewMultiCounters("VtgateOverlappingShards", []string{"Keyspace", "DbType"})
+
 func mapKeyspaceIdsToShards(ctx context.Context, topoServ SrvTopoServer, cell, keyspace string, tabletType topo.TabletType, keyspaceIds []key.KeyspaceId) (string, []string, error) {
 	keyspace, allShards, err := getKeyspaceShards(ctx, topoServ, cell, keyspace, tabletType)
 	if err != nil {
@@ -33,6 +40,12 @@ func mapKeyspaceIdsToShards(ctx context.Context, topoServ SrvTopoServer, cell, k
 	return keyspace, res, nil
 }
 
+// getKeyspaceShards returns the shards of keyspace for tabletType.
+// If the keyspace is served from another keyspace for tabletType,
+// as it is during a vertical split, the shards of that keyspace are
+// returned along with its name. The redirections are followed until
+// a keyspace serves tabletType itself, so that tables can be moved
+// again before their previous keyspace is cleaned up.
 func getKeyspaceShards(ctx context.Context, topoServ SrvTopoServer, cell, keyspace string, tabletType topo.TabletType) (string, []topo.SrvShard, error) {
 	srvKeyspace, err := topoServ.GetSrvKeyspace(ctx, cell, keyspace)
 	if err != nil {
@@ -40,7 +53,18 @@ func getKeyspaceShards(ctx context.Context, topoServ SrvTopoServer, cell, keyspa
 	}
 
 	// check if the keyspace has been redirected for this tabletType.
-	if servedFrom, ok := srvKeyspace.ServedFrom[tabletType]; ok {
+	redirects := []string{keyspace}
+	for {
+		servedFrom, ok := srvKeyspace.ServedFrom[tabletType]
+		if !ok || servedFrom == keyspace {
+			break
+		}
+		for _, redirect := range redirects {
+			if redirect == servedFrom {
+				return "", nil, fmt.Errorf("keyspace %v is served from itself for tabletType %v: %v -> %v", redirects[0], tabletType, strings.Join(redirects, " -> "), servedFrom)
+			}
+		}
+		redirects = append(redirects, servedFrom)
 		keyspace = servedFrom
 		srvKeyspace, err = topoServ.GetSrvKeyspace(ctx, cell, keyspace)
 		if err != nil {
@@ -52,7 +76,47 @@ func getKeyspaceShards(ctx context.Context, topoServ SrvTopoServer, cell, keyspa
 	if !ok {
 		return "", nil, fmt.Errorf("No partition found for tabletType %v in keyspace %v", tabletType, keyspace)
 	}
-	return keyspace, partition.Shards, nil
+	shards, err := servingShards(keyspace, partition.Shards, tabletType)
+	if err != nil {
+		return "", nil, err
+	}
+	return keyspace, shards, nil
+}
+
+// servingShards returns the shards of a partition that serve
+// tabletType. While a keyspace is resharded, its partitions can
+// list both the source and the destination shards of a key range,
+// which overlap. Each query is then routed to the side whose
+// ServedTypes contain tabletType. A shard without ServedTypes
+// serves all the tablet types.
+func servingShards(keyspace string, shards []topo.SrvShard, tabletType topo.TabletType) ([]topo.SrvShard, error) {
+	if !shardsOverlap(shards) {
+		return shards, nil
+	}
+	serving := make([]topo.SrvShard, 0, len(shards))
+	for _, shard := range shards {
+		if len(shard.ServedTypes) == 0 || topo.IsTypeInList(tabletType, shard.ServedTypes) {
+			serving = append(serving, shard)
+		}
+	}
+	sort.Sort(topo.SrvShardArray(serving))
+	if shardsOverlap(serving) {
+		return nil, fmt.Errorf("keyspace %v has overlapping shards serving tabletType %v: %+v", keyspace, tabletType, serving)
+	}
+	overlappingShards.Add([]string{keyspace, string(tabletType)}, 1)
+	return serving, nil
+}
+
+// shardsOverlap returns true if shards overlap, or if
+// they're not sorted by key range.
+func shardsOverlap(shards []topo.SrvShard) bool {
+	for i := 1; i < len(shards); i++ {
+		previous := shards[i-1].KeyRange
+		if previous.End == key.MaxKey || shards[i].KeyRange.Start < previous.End {
+			return true
+		}
+	}
+	return false
 }
 
 func getShardForKeyspaceId(allShards []topo.SrvShard, keyspaceId key.KeyspaceId) (string, error) {
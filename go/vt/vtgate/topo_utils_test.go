@@ -100,3 +100,103 @@ func TestMapExactShards(t *testing.T) {
 		}
 	}
 }
+
+func TestGetKeyspaceShardsServedFrom(t *testing.T) {
+	ts := new(sandboxTopo)
+	createSandbox("TestServedFromA").KeyspaceServedFrom = "TestServedFromB"
+	createSandbox("TestServedFromB").KeyspaceServedFrom = "TestServedFromC"
+	createSandbox("TestServedFromC")
+	defer func() {
+		for _, keyspace := range []string{"TestServedFromA", "TestServedFromB", "TestServedFromC"} {
+			deleteSandbox(keyspace)
+		}
+	}()
+
+	// The redirections are followed for the tablet types they cover.
+	keyspace, shards, err := getKeyspaceShards(context.Background(), ts, "", "TestServedFromA", topo.TYPE_MASTER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyspace != "TestServedFromC" || len(shards) != 8 {
+		t.Errorf("getKeyspaceShards: %v, %v shards, want TestServedFromC, 8 shards", keyspace, len(shards))
+	}
+	keyspace, _, err = getKeyspaceShards(context.Background(), ts, "", "TestServedFromA", topo.TYPE_REPLICA)
+	if err != nil || keyspace != "TestServedFromA" {
+		t.Errorf("getKeyspaceShards: %v, %v, want TestServedFromA", keyspace, err)
+	}
+
+	// A loop is reported.
+	getSandbox("TestServedFromC").KeyspaceServedFrom = "TestServedFromA"
+	_, _, err = getKeyspaceShards(context.Background(), ts, "", "TestServedFromA", topo.TYPE_MASTER)
+	want := "keyspace TestServedFromA is served from itself for tabletType master: TestServedFromA -> TestServedFromB -> TestServedFromC -> TestServedFromA"
+	if err == nil || err.Error() != want {
+		t.Errorf("getKeyspaceShards: %v, want %s", err, want)
+	}
+}
+
+// srvKeyspaceTopo is a SrvTopoServer that returns the
+// same SrvKeyspace for all the keyspaces.
+type srvKeyspaceTopo struct {
+	sandboxTopo
+	srvKeyspace *topo.SrvKeyspace
+}
+
+func (st *srvKeyspaceTopo) GetSrvKeyspace(ctx context.Context, cell, keyspace string) (*topo.SrvKeyspace, error) {
+	return st.srvKeyspace, nil
+}
+
+func TestGetKeyspaceShardsOverlapping(t *testing.T) {
+	// 80- is being split into 80-c0 and c0-, which
+	// only serve rdonly so far.
+	newShard := func(name string, servedTypes ...topo.TabletType) topo.SrvShard {
+		krs, err := key.ParseShardingSpec(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return topo.SrvShard{Name: name, KeyRange: krs[0], ServedTypes: servedTypes}
+	}
+	shards := []topo.SrvShard{
+		newShard("-80"),
+		newShard("80-", topo.TYPE_MASTER, topo.TYPE_REPLICA),
+		newShard("80-c0", topo.TYPE_RDONLY),
+		newShard("c0-", topo.TYPE_RDONLY),
+	}
+	ts := &srvKeyspaceTopo{srvKeyspace: &topo.SrvKeyspace{
+		Partitions: map[topo.TabletType]*topo.KeyspacePartition{
+			topo.TYPE_MASTER: &topo.KeyspacePartition{Shards: shards},
+			topo.TYPE_RDONLY: &topo.KeyspacePartition{Shards: shards},
+		},
+	}}
+	for _, tcase := range []struct {
+		tabletType topo.TabletType
+		want       []string
+	}{
+		{topo.TYPE_MASTER, []string{"-80", "80-"}},
+		{topo.TYPE_RDONLY, []string{"-80", "80-c0", "c0-"}},
+	} {
+		_, allShards, err := getKeyspaceShards(context.Background(), ts, "", "TestOverlapping", tcase.tabletType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, shard := range allShards {
+			got = append(got, shard.ShardName())
+		}
+		if !reflect.DeepEqual(got, tcase.want) {
+			t.Errorf("getKeyspaceShards(%v): %v, want %v", tcase.tabletType, got, tcase.want)
+		}
+	}
+
+	// Both sides serve master.
+	shards[2].ServedTypes = append(shards[2].ServedTypes, topo.TYPE_MASTER)
+	if _, _, err := getKeyspaceShards(context.Background(), ts, "", "TestOverlapping", topo.TYPE_MASTER); err == nil {
+		t.Errorf("getKeyspaceShards of overlapping master shards didn't fail")
+	}
+
+	// The shards that don't overlap are returned as is.
+	disjoint := []topo.SrvShard{newShard("-80", topo.TYPE_MASTER), newShard("80-", topo.TYPE_MASTER)}
+	got, err := servingShards("TestOverlapping", disjoint, topo.TYPE_RDONLY)
+	if err != nil || !reflect.DeepEqual(got, disjoint) {
+		t.Errorf("servingShards: %v, %v, want %v", got, err, disjoint)
+	}
+}
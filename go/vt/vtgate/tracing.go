@@ -0,0 +1,46 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"github.com/youtube/vitess/go/trace"
+	"golang.org/x/net/context"
+)
+
+// A query is traced from the RPC that vtgate serves down to the
+// calls to the tablets. The RPC span is the parent of the spans of
+// the Router, which plans the query and looks up its vindexes, and
+// of the ScatterConn, which has a child span for the call to each
+// shard. The spans are exported by the tracing plugin registered
+// with trace.RegisterSpanFactory, if any.
+
+// startServerSpan starts the span of the RPC labeled label,
+// as a child of the span of ctx, if any. It returns a context
+// that carries it to the spans of the work done to serve it.
+func startServerSpan(ctx context.Context, label string) (context.Context, trace.Span) {
+	span := trace.NewSpanFromContext(ctx)
+	span.StartServer(label)
+	return trace.NewContext(ctx, span), span
+}
+
+// startSpan starts the span of the local work labeled label, as
+// a child of the span of ctx, and returns a context that carries it.
+func startSpan(ctx context.Context, label string) (context.Context, trace.Span) {
+	span := trace.NewSpanFromContext(ctx)
+	span.StartLocal(label)
+	return trace.NewContext(ctx, span), span
+}
+
+// startSpan starts the span of a call labeled label to the tablets
+// of the shard of sdc, as a child of the span of ctx, and returns
+// a context that carries it.
+func (sdc *ShardConn) startSpan(ctx context.Context, label string) (context.Context, trace.Span) {
+	span := trace.NewSpanFromContext(ctx)
+	span.StartClient(label)
+	span.Annotate("keyspace", sdc.keyspace)
+	span.Annotate("shard", sdc.shard)
+	span.Annotate("tablet_type", string(sdc.tabletType))
+	return trace.NewContext(ctx, span), span
+}
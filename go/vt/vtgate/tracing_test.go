@@ -0,0 +1,182 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/trace"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// testSpan is a span recorded by testSpanFactory.
+type testSpan struct {
+	factory     *testSpanFactory
+	parent      *testSpan
+	label       string
+	annotations map[string]interface{}
+	finished    bool
+}
+
+func (span *testSpan) start(label string) {
+	span.factory.mu.Lock()
+	defer span.factory.mu.Unlock()
+	span.label = label
+	span.factory.spans = append(span.factory.spans, span)
+}
+
+func (span *testSpan) StartLocal(label string)  { span.start(label) }
+func (span *testSpan) StartClient(label string) { span.start(label) }
+func (span *testSpan) StartServer(label string) { span.start(label) }
+
+func (span *testSpan) Finish() {
+	span.factory.mu.Lock()
+	defer span.factory.mu.Unlock()
+	span.finished = true
+}
+
+func (span *testSpan) Annotate(key string, value interface{}) {
+	span.factory.mu.Lock()
+	defer span.factory.mu.Unlock()
+	span.annotations[key] = value
+}
+
+// path returns the labels of the span and its ancestors,
+// from the root span down to the span.
+func (span *testSpan) path() string {
+	if span.parent == nil {
+		return span.label
+	}
+	return span.parent.path() + "/" + span.label
+}
+
+type testSpanKey struct{}
+
+// testSpanFactory records the spans it creates.
+type testSpanFactory struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (sf *testSpanFactory) New(parent trace.Span) trace.Span {
+	span := &testSpan{factory: sf, annotations: make(map[string]interface{})}
+	if parent != nil {
+		span.parent = parent.(*testSpan)
+	}
+	return span
+}
+
+func (sf *testSpanFactory) FromContext(ctx context.Context) (trace.Span, bool) {
+	span, ok := ctx.Value(testSpanKey{}).(*testSpan)
+	return span, ok
+}
+
+func (sf *testSpanFactory) NewContext(parent context.Context, span trace.Span) context.Context {
+	return context.WithValue(parent, testSpanKey{}, span)
+}
+
+// find returns the spans of path.
+func (sf *testSpanFactory) find(path string) []*testSpan {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	var spans []*testSpan
+	for _, span := range sf.spans {
+		if span.path() == path {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// noopSpanFactory creates spans that do nothing,
+// like the default factory of the trace package.
+type noopSpanFactory struct{}
+
+func (noopSpanFactory) New(parent trace.Span) trace.Span                                { return noopSpan{} }
+func (noopSpanFactory) FromContext(ctx context.Context) (trace.Span, bool)              { return nil, false }
+func (noopSpanFactory) NewContext(ctx context.Context, span trace.Span) context.Context { return ctx }
+
+type noopSpan struct{}
+
+func (noopSpan) StartLocal(string)            {}
+func (noopSpan) StartClient(string)           {}
+func (noopSpan) StartServer(string)           {}
+func (noopSpan) Finish()                      {}
+func (noopSpan) Annotate(string, interface{}) {}
+
+func TestRouterTracing(t *testing.T) {
+	factory := new(testSpanFactory)
+	trace.RegisterSpanFactory(factory)
+	defer trace.RegisterSpanFactory(noopSpanFactory{})
+
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("-20", sbc)
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	// The id of user is generated by its lookup vindex.
+	sbclookup.setResults([]*mproto.QueryResult{{RowsAffected: 1, InsertId: 1}})
+	sbc.setResults([]*mproto.QueryResult{{RowsAffected: 1}})
+	ctx, span := startServerSpan(context.Background(), "VTGate.Execute")
+	_, err = router.Execute(ctx, &proto.Query{
+		Sql:        "insert into user(v, name) values (2, 'myname')",
+		TabletType: topo.TYPE_MASTER,
+	})
+	span.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The id of user is generated by user_index, and name_user_map
+	// creates its entry: each of them inserts into its table in
+	// TestUnsharded.
+	for _, tcase := range []struct {
+		path     string
+		count    int
+		keyspace string
+		shard    string
+	}{
+		{"VTGate.Execute/Router.Execute/Router.Plan", 1, "", ""},
+		{"VTGate.Execute/Router.Execute/Vindex.Generate/Router.VindexLookup/Router.Execute/ScatterConn.ExecuteMulti/ShardConn.Execute", 1, "TestUnsharded", "0"},
+		{"VTGate.Execute/Router.Execute/Vindex.Create/Router.VindexLookup/Router.Execute/ScatterConn.ExecuteMulti/ShardConn.Execute", 1, "TestUnsharded", "0"},
+		{"VTGate.Execute/Router.Execute/Vindex.Map", 1, "", ""},
+		{"VTGate.Execute/Router.Execute/ScatterConn.Execute/ShardConn.Execute", 1, "TestRouter", "-20"},
+	} {
+		spans := factory.find(tcase.path)
+		if len(spans) != tcase.count {
+			t.Errorf("spans of %s: %d, want %d", tcase.path, len(spans), tcase.count)
+			continue
+		}
+		for _, span := range spans {
+			if !span.finished {
+				t.Errorf("span %s is not finished", tcase.path)
+			}
+			if tcase.shard != "" && (span.annotations["keyspace"] != tcase.keyspace || span.annotations["shard"] != tcase.shard || span.annotations["tablet_type"] != string(topo.TYPE_MASTER)) {
+				t.Errorf("annotations of %s: %v, want %s/%s", tcase.path, span.annotations, tcase.keyspace, tcase.shard)
+			}
+		}
+	}
+	if spans := factory.find("VTGate.Execute/Router.Execute"); len(spans) != 1 || spans[0].annotations["plan"] != "InsertSharded" {
+		t.Errorf("Router.Execute spans: %d, want 1 annotated with the InsertSharded plan", len(spans))
+	}
+	if spans := factory.find("VTGate.Execute/Router.Execute/Vindex.Create"); len(spans) != 1 || spans[0].annotations["vindex"] != "name_user_map" || spans[0].annotations["batch_size"] != 1 {
+		t.Errorf("Vindex.Create spans: %d, want 1 annotated with name_user_map and a batch of 1", len(spans))
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/timer"
+	"github.com/youtube/vitess/go/vt/concurrency"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+var (
+	twopcResolveInterval = flag.Duration("twopc_resolve_interval", 0, "how often vtgate resolves abandoned distributed transactions, 0 disables it. If the twopc transaction mode is used, at least one vtgate must resolve them")
+	twopcAbandonAge      = flag.Duration("twopc_abandon_age", 1*time.Minute, "time after which a distributed transaction that's not concluded is considered abandoned")
+)
+
+// commit2PC commits the transaction of session atomically across
+// its shards. The first shard coordinates the commit: it records
+// the distributed transaction before the other shards are prepared,
+// and its own commit is the decision to commit. If vtgate fails
+// after that, the TxResolver of a vtgate completes the commit.
+func (stc *ScatterConn) commit2PC(ctx context.Context, session *SafeSession) error {
+	mmShard := session.ShardSessions[0]
+	mm := stc.getConnection(ctx, mmShard.Keyspace, mmShard.Shard, mmShard.TabletType)
+	dtid := fmt.Sprintf("%s:%s:%d", mmShard.Keyspace, mmShard.Shard, mmShard.TransactionId)
+	participants := make([]tproto.Participant, 0, len(session.ShardSessions)-1)
+	for _, shardSession := range session.ShardSessions[1:] {
+		participants = append(participants, tproto.Participant{
+			Keyspace: shardSession.Keyspace,
+			Shard:    shardSession.Shard,
+		})
+	}
+	if err := mm.CreateTransaction(ctx, dtid, participants); err != nil {
+		for _, shardSession := range session.ShardSessions {
+			sdc := stc.getConnection(ctx, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+			sdc.Rollback(ctx, shardSession.TransactionId)
+		}
+		return err
+	}
+	for _, shardSession := range session.ShardSessions[1:] {
+		sdc := stc.getConnection(ctx, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		if err := sdc.Prepare(ctx, shardSession.TransactionId, dtid); err != nil {
+			stc.rollback2PC(ctx, session, dtid)
+			return err
+		}
+	}
+	if err := mm.StartCommit(ctx, mmShard.TransactionId, dtid); err != nil {
+		stc.rollback2PC(ctx, session, dtid)
+		return err
+	}
+	// The transaction is committed. Every participant is asked
+	// to commit, and if one fails, the transaction is left for
+	// the TxResolver to retry.
+	failed := false
+	for _, shardSession := range session.ShardSessions[1:] {
+		sdc := stc.getConnection(ctx, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		if err := sdc.CommitPrepared(ctx, dtid); err != nil {
+			log.Warningf("Transaction %s is committed, but %s/%s failed to commit, it will be resolved later: %v", dtid, shardSession.Keyspace, shardSession.Shard, err)
+			failed = true
+		}
+	}
+	if failed {
+		return nil
+	}
+	if err := mm.ConcludeTransaction(ctx, dtid); err != nil {
+		log.Warningf("Transaction %s is committed, but could not be concluded: %v", dtid, err)
+	}
+	return nil
+}
+
+// rollback2PC rolls back a distributed transaction that was not
+// committed. If the decision to roll back can't be recorded,
+// the transaction may have been committed, and is left for the
+// TxResolver to resolve.
+func (stc *ScatterConn) rollback2PC(ctx context.Context, session *SafeSession, dtid string) {
+	mmShard := session.ShardSessions[0]
+	mm := stc.getConnection(ctx, mmShard.Keyspace, mmShard.Shard, mmShard.TabletType)
+	if err := mm.SetRollback(ctx, dtid, mmShard.TransactionId); err != nil {
+		log.Warningf("Could not roll back transaction %s, it will be resolved later: %v", dtid, err)
+		return
+	}
+	failed := false
+	for _, shardSession := range session.ShardSessions[1:] {
+		sdc := stc.getConnection(ctx, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		if err := sdc.RollbackPrepared(ctx, dtid, shardSession.TransactionId); err != nil {
+			log.Warningf("Transaction %s is rolled back, but %s/%s failed to roll back, it will be resolved later: %v", dtid, shardSession.Keyspace, shardSession.Shard, err)
+			failed = true
+		}
+	}
+	if failed {
+		return
+	}
+	if err := mm.ConcludeTransaction(ctx, dtid); err != nil {
+		log.Warningf("Transaction %s is rolled back, but could not be concluded: %v", dtid, err)
+	}
+}
+
+// TxResolver periodically resolves the distributed transactions
+// that were abandoned before they were concluded, for instance
+// because the vtgate that committed them failed. Transactions
+// that weren't decided are rolled back.
+type TxResolver struct {
+	scatterConn *ScatterConn
+	abandonAge  time.Duration
+	ticks       *timer.Timer
+}
+
+// NewTxResolver creates a TxResolver that looks for abandoned
+// transactions every interval.
+func NewTxResolver(scatterConn *ScatterConn, interval, abandonAge time.Duration) *TxResolver {
+	return &TxResolver{
+		scatterConn: scatterConn,
+		abandonAge:  abandonAge,
+		ticks:       timer.NewTimer(interval),
+	}
+}
+
+// Open starts resolving transactions in the background.
+func (txr *TxResolver) Open() {
+	txr.ticks.Start(func() {
+		if err := txr.ResolveAll(context.Background()); err != nil {
+			log.Errorf("Could not resolve distributed transactions: %v", err)
+		}
+	})
+}
+
+// Close stops resolving transactions.
+func (txr *TxResolver) Close() {
+	txr.ticks.Stop()
+}
+
+// ResolveAll resolves the abandoned transactions coordinated
+// by the master shards of all the keyspaces of the cell.
+func (txr *TxResolver) ResolveAll(ctx context.Context) error {
+	stc := txr.scatterConn
+	ksNames, err := stc.toposerv.GetSrvKeyspaceNames(ctx, stc.cell)
+	if err != nil {
+		return err
+	}
+	var errRecorder concurrency.AllErrorRecorder
+	for _, ksName := range ksNames {
+		keyspace, shards, err := getKeyspaceShards(ctx, stc.toposerv, stc.cell, ksName, topo.TYPE_MASTER)
+		if err != nil {
+			errRecorder.RecordError(err)
+			continue
+		}
+		for _, shard := range shards {
+			if err := txr.resolveShard(ctx, keyspace, shard.ShardName()); err != nil {
+				errRecorder.RecordError(err)
+			}
+		}
+	}
+	return errRecorder.Error()
+}
+
+// resolveShard resolves the abandoned transactions coordinated by shard.
+func (txr *TxResolver) resolveShard(ctx context.Context, keyspace, shard string) error {
+	mm := txr.scatterConn.getConnection(ctx, keyspace, shard, topo.TYPE_MASTER)
+	txs, err := mm.UnresolvedTransactions(ctx, txr.abandonAge)
+	if err != nil {
+		return err
+	}
+	for _, dt := range txs {
+		if err := txr.resolve(ctx, mm, dt); err != nil {
+			return fmt.Errorf("transaction %s: %v", dt.Dtid, err)
+		}
+	}
+	return nil
+}
+
+// resolve completes dt according to its state, and concludes it.
+func (txr *TxResolver) resolve(ctx context.Context, mm *ShardConn, dt tproto.DistributedTx) error {
+	stc := txr.scatterConn
+	switch dt.State {
+	case tproto.DT_PREPARE:
+		// The commit was never decided.
+		if err := mm.SetRollback(ctx, dt.Dtid, 0); err != nil {
+			return err
+		}
+		fallthrough
+	case tproto.DT_ROLLBACK:
+		for _, p := range dt.Participants {
+			if err := stc.getConnection(ctx, p.Keyspace, p.Shard, topo.TYPE_MASTER).RollbackPrepared(ctx, dt.Dtid, 0); err != nil {
+				return err
+			}
+		}
+	case tproto.DT_COMMIT:
+		for _, p := range dt.Participants {
+			if err := stc.getConnection(ctx, p.Keyspace, p.Shard, topo.TYPE_MASTER).CommitPrepared(ctx, dt.Dtid); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown state %s", dt.State)
+	}
+	log.Infof("Resolved abandoned transaction %s in state %s", dt.Dtid, dt.State)
+	return mm.ConcludeTransaction(ctx, dt.Dtid)
+}
+
+// isTwoPC returns true if session needs a two-phase commit.
+func isTwoPC(session *SafeSession) bool {
+	return session.TransactionMode == proto.TxModeTwoPC && len(session.ShardSessions) > 1
+}
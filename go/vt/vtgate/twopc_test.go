@@ -0,0 +1,223 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/sync2"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// This file uses the sandbox_test framework.
+
+// twoPCSetup returns a ScatterConn and a twopc session
+// that is in a transaction on shards 0 and 1 of keyspace.
+func twoPCSetup(t *testing.T, keyspace string) (*ScatterConn, *SafeSession, *sandboxConn, *sandboxConn) {
+	s := createSandbox(keyspace)
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+	session := NewSafeSession(&proto.Session{InTransaction: true, TransactionMode: proto.TxModeTwoPC})
+	if _, err := stc.Execute(context.Background(), "query1", nil, keyspace, []string{"0"}, "", session); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stc.Execute(context.Background(), "query1", nil, keyspace, []string{"1"}, "", session); err != nil {
+		t.Fatal(err)
+	}
+	return stc, session, sbc0, sbc1
+}
+
+func checkCounts(t *testing.T, name string, counts map[string]*sync2.AtomicInt64, want map[string]int64) {
+	for k, v := range counts {
+		if v.Get() != want[k] {
+			t.Errorf("%s.%s: %d, want %d", name, k, v.Get(), want[k])
+		}
+	}
+}
+
+func twoPCCounts(sbc *sandboxConn) map[string]*sync2.AtomicInt64 {
+	return map[string]*sync2.AtomicInt64{
+		"Commit":              &sbc.CommitCount,
+		"Rollback":            &sbc.RollbackCount,
+		"Prepare":             &sbc.PrepareCount,
+		"CommitPrepared":      &sbc.CommitPreparedCount,
+		"RollbackPrepared":    &sbc.RollbackPreparedCount,
+		"CreateTransaction":   &sbc.CreateTransactionCount,
+		"StartCommit":         &sbc.StartCommitCount,
+		"SetRollback":         &sbc.SetRollbackCount,
+		"ConcludeTransaction": &sbc.ConcludeTransactionCount,
+	}
+}
+
+func TestCommit2PC(t *testing.T) {
+	stc, session, sbc0, sbc1 := twoPCSetup(t, "TestCommit2PC")
+	if err := stc.Commit(context.Background(), session); err != nil {
+		t.Error(err)
+	}
+	if session.InTransaction() {
+		t.Errorf("session is still in a transaction")
+	}
+	checkCounts(t, "sbc0", twoPCCounts(sbc0), map[string]int64{
+		"CreateTransaction":   1,
+		"StartCommit":         1,
+		"ConcludeTransaction": 1,
+	})
+	checkCounts(t, "sbc1", twoPCCounts(sbc1), map[string]int64{
+		"Prepare":        1,
+		"CommitPrepared": 1,
+	})
+}
+
+func TestCommit2PCSingleShard(t *testing.T) {
+	s := createSandbox("TestCommit2PCSingleShard")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+	session := NewSafeSession(&proto.Session{InTransaction: true, TransactionMode: proto.TxModeTwoPC})
+	stc.Execute(context.Background(), "query1", nil, "TestCommit2PCSingleShard", []string{"0"}, "", session)
+	if err := stc.Commit(context.Background(), session); err != nil {
+		t.Error(err)
+	}
+	checkCounts(t, "sbc0", twoPCCounts(sbc0), map[string]int64{
+		"Commit": 1,
+	})
+}
+
+func TestCommit2PCCreateTransactionFail(t *testing.T) {
+	stc, session, sbc0, sbc1 := twoPCSetup(t, "TestCommit2PCCreateTransactionFail")
+	sbc0.mustFailServer = 1
+	if err := stc.Commit(context.Background(), session); err == nil {
+		t.Errorf("Commit: nil, want error")
+	}
+	checkCounts(t, "sbc0", twoPCCounts(sbc0), map[string]int64{
+		"CreateTransaction": 1,
+		"Rollback":          1,
+	})
+	checkCounts(t, "sbc1", twoPCCounts(sbc1), map[string]int64{
+		"Rollback": 1,
+	})
+}
+
+func TestCommit2PCPrepareFail(t *testing.T) {
+	stc, session, sbc0, sbc1 := twoPCSetup(t, "TestCommit2PCPrepareFail")
+	sbc1.mustFailServer = 1
+	if err := stc.Commit(context.Background(), session); err == nil {
+		t.Errorf("Commit: nil, want error")
+	}
+	checkCounts(t, "sbc0", twoPCCounts(sbc0), map[string]int64{
+		"CreateTransaction":   1,
+		"SetRollback":         1,
+		"ConcludeTransaction": 1,
+	})
+	checkCounts(t, "sbc1", twoPCCounts(sbc1), map[string]int64{
+		"Prepare":          1,
+		"RollbackPrepared": 1,
+	})
+}
+
+func TestCommit2PCStartCommitFail(t *testing.T) {
+	stc, session, sbc0, sbc1 := twoPCSetup(t, "TestCommit2PCStartCommitFail")
+	sbc0.onConnUse = func(sbc *sandboxConn) {
+		if sbc.StartCommitCount.Get() == 1 && sbc.SetRollbackCount.Get() == 0 {
+			sbc.mustFailServer = 1
+		}
+	}
+	if err := stc.Commit(context.Background(), session); err == nil {
+		t.Errorf("Commit: nil, want error")
+	}
+	checkCounts(t, "sbc0", twoPCCounts(sbc0), map[string]int64{
+		"CreateTransaction":   1,
+		"StartCommit":         1,
+		"SetRollback":         1,
+		"ConcludeTransaction": 1,
+	})
+	checkCounts(t, "sbc1", twoPCCounts(sbc1), map[string]int64{
+		"Prepare":          1,
+		"RollbackPrepared": 1,
+	})
+}
+
+func TestCommit2PCCommitPreparedFail(t *testing.T) {
+	stc, session, sbc0, sbc1 := twoPCSetup(t, "TestCommit2PCCommitPreparedFail")
+	sbc2 := &sandboxConn{}
+	getSandbox("TestCommit2PCCommitPreparedFail").MapTestConn("2", sbc2)
+	if _, err := stc.Execute(context.Background(), "query1", nil, "TestCommit2PCCommitPreparedFail", []string{"2"}, "", session); err != nil {
+		t.Fatal(err)
+	}
+	sbc1.onConnUse = func(sbc *sandboxConn) {
+		if sbc.CommitPreparedCount.Get() == 1 {
+			sbc.mustFailServer = 1
+		}
+	}
+	// The transaction is committed, and will be concluded later.
+	// The participants after the one that failed still commit.
+	if err := stc.Commit(context.Background(), session); err != nil {
+		t.Error(err)
+	}
+	checkCounts(t, "sbc0", twoPCCounts(sbc0), map[string]int64{
+		"CreateTransaction": 1,
+		"StartCommit":       1,
+	})
+	checkCounts(t, "sbc1", twoPCCounts(sbc1), map[string]int64{
+		"Prepare":        1,
+		"CommitPrepared": 1,
+	})
+	checkCounts(t, "sbc2", twoPCCounts(sbc2), map[string]int64{
+		"Prepare":        1,
+		"CommitPrepared": 1,
+	})
+}
+
+func TestTxResolver(t *testing.T) {
+	s := createSandbox("TestTxResolver")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("1", sbc1)
+	participants := []tproto.Participant{{Keyspace: "TestTxResolver", Shard: "1"}}
+	sbc0.UnresolvedTxs = []tproto.DistributedTx{{
+		Dtid:         "TestTxResolver:0:1",
+		State:        tproto.DT_PREPARE,
+		Participants: participants,
+	}, {
+		Dtid:         "TestTxResolver:0:2",
+		State:        tproto.DT_ROLLBACK,
+		Participants: participants,
+	}, {
+		Dtid:         "TestTxResolver:0:3",
+		State:        tproto.DT_COMMIT,
+		Participants: participants,
+	}}
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+	txr := NewTxResolver(stc, time.Minute, time.Minute)
+	if err := txr.resolveShard(context.Background(), "TestTxResolver", "0"); err != nil {
+		t.Error(err)
+	}
+	checkCounts(t, "sbc0", twoPCCounts(sbc0), map[string]int64{
+		"SetRollback":         1,
+		"ConcludeTransaction": 3,
+	})
+	checkCounts(t, "sbc1", twoPCCounts(sbc1), map[string]int64{
+		"RollbackPrepared": 2,
+		"CommitPrepared":   1,
+	})
+
+	sbc0.UnresolvedTxs = []tproto.DistributedTx{{
+		Dtid:         "TestTxResolver:0:4",
+		State:        "UNKNOWN",
+		Participants: participants,
+	}}
+	err := txr.resolveShard(context.Background(), "TestTxResolver", "0")
+	want := "transaction TestTxResolver:0:4: unknown state UNKNOWN"
+	if err == nil || err.Error() != want {
+		t.Errorf("resolveShard: %v, want %s", err, want)
+	}
+}
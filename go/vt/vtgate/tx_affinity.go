@@ -0,0 +1,59 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+// The replica and rdonly reads of a session in a transaction go to
+// the master of the shards the transaction already runs on, in the
+// transaction, so that they see its uncommitted writes. The shards
+// the transaction doesn't run on are read from tablets of their type.
+
+// affinityReads counts the replica and rdonly reads that were sent to
+// the transaction of their session, by keyspace and tablet type.
+var affinityReads = stats.NewMultiCounters("VtgateTxAffinityReads", []string{"Keyspace", "DbType"})
+
+// inShardSession returns true if a query of tabletType on the shard
+// executes in the transaction of shardSession: shardSession is on the
+// shard, and has the same tablet type, or is on the master while the
+// query is a replica or rdonly read.
+func inShardSession(shardSession *proto.ShardSession, keyspace, shard string, tabletType topo.TabletType) bool {
+	if shardSession.Keyspace != keyspace || shardSession.Shard != shard {
+		return false
+	}
+	if shardSession.TabletType == tabletType {
+		return true
+	}
+	return shardSession.TabletType == topo.TYPE_MASTER && (tabletType == topo.TYPE_REPLICA || tabletType == topo.TYPE_RDONLY)
+}
+
+// transactionAffinityType returns the tablet type a query of tabletType
+// is sent to on the shard: master if it's a replica or rdonly read,
+// and the transaction of the session already runs on the master of
+// the shard, and tabletType otherwise.
+func transactionAffinityType(session *SafeSession, keyspace, shard string, tabletType topo.TabletType) topo.TabletType {
+	if tabletType != topo.TYPE_REPLICA && tabletType != topo.TYPE_RDONLY {
+		return tabletType
+	}
+	if session == nil || session.Session == nil {
+		return tabletType
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if !session.Session.InTransaction {
+		return tabletType
+	}
+	for _, shardSession := range session.ShardSessions {
+		if shardSession.TabletType == topo.TYPE_MASTER && inShardSession(shardSession, keyspace, shard, tabletType) {
+			affinityReads.Add([]string{keyspace, string(tabletType)}, 1)
+			return topo.TYPE_MASTER
+		}
+	}
+	return tabletType
+}
@@ -0,0 +1,59 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestTransactionAffinity(t *testing.T) {
+	keyspace := "TestTransactionAffinity"
+	s := createSandbox(keyspace)
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+	ctx := context.Background()
+
+	session := NewSafeSession(&proto.Session{InTransaction: true, TransactionMode: proto.TxModeSingle})
+	if _, err := stc.Execute(ctx, "update a set b = 1", nil, keyspace, []string{"0"}, topo.TYPE_MASTER, session); err != nil {
+		t.Fatal(err)
+	}
+	key := keyspace + ".replica"
+	counts := affinityReads.Counts()
+
+	// The read of the shard of the transaction executes in it,
+	// even in the single transaction mode.
+	if _, err := stc.Execute(ctx, "select * from a", nil, keyspace, []string{"0"}, topo.TYPE_REPLICA, session); err != nil {
+		t.Fatal(err)
+	}
+	if len(session.ShardSessions) != 1 || sbc0.BeginCount.Get() != 1 {
+		t.Errorf("ShardSessions: %v, BeginCount: %d, want the read in the master transaction", session.ShardSessions, sbc0.BeginCount.Get())
+	}
+	if got := affinityReads.Counts()[key] - counts[key]; got != 1 {
+		t.Errorf("%v: %v more, want 1", key, got)
+	}
+
+	// The other shards are read from their replicas.
+	session.TransactionMode = ""
+	if _, err := stc.Execute(ctx, "select * from a", nil, keyspace, []string{"0", "1"}, topo.TYPE_REPLICA, session); err != nil {
+		t.Fatal(err)
+	}
+	if got := session.Find(keyspace, "1", topo.TYPE_REPLICA); got == 0 {
+		t.Errorf("ShardSessions: %v, want a replica session on shard 1", session.ShardSessions)
+	}
+	if got := session.Find(keyspace, "0", topo.TYPE_REPLICA); got != 0 {
+		t.Errorf("ShardSessions: %v, want no replica session on shard 0", session.ShardSessions)
+	}
+	if got := affinityReads.Counts()[key] - counts[key]; got != 2 {
+		t.Errorf("%v: %v more, want 2", key, got)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+var transactionTimeout = flag.Duration("transaction_timeout", 0, "time after which vtgate rolls back a transaction that's not committed or rolled back, 0 means no timeout")
+
+// abortedRetention is how long vtgate remembers the transactions
+// it rolled back, to report it to the sessions that come back.
+const abortedRetention = 1 * time.Hour
+
+var errTransactionTimedOut = errors.New("transaction was rolled back because it exceeded the transaction timeout")
+
+// txReaper rolls back the transactions that are not committed or
+// rolled back before their timeout, for instance because the client
+// went away, so that they don't hold their locks forever. Sessions
+// don't have an identity in vtgate, so a transaction is identified
+// by its first shard session. Only the transactions that went
+// through this vtgate are rolled back.
+type txReaper struct {
+	scatterConn *ScatterConn
+
+	mu  sync.Mutex
+	txs map[string]*trackedTx
+	// aborted are the transactions that were rolled back,
+	// until their session comes back or they're forgotten.
+	aborted map[string]bool
+}
+
+// trackedTx is a transaction that's rolled back when timer fires.
+type trackedTx struct {
+	shardSessions []*proto.ShardSession
+	timer         *time.Timer
+}
+
+func newTxReaper(scatterConn *ScatterConn) *txReaper {
+	return &txReaper{
+		scatterConn: scatterConn,
+		txs:         make(map[string]*trackedTx),
+		aborted:     make(map[string]bool),
+	}
+}
+
+// track records the shard sessions of the transaction of session,
+// and starts its timeout if it's not tracked yet.
+func (txr *txReaper) track(session *SafeSession) {
+	timeout := session.transactionTimeout()
+	if timeout <= 0 {
+		return
+	}
+	key, shardSessions := session.txKey()
+	if key == "" {
+		return
+	}
+	txr.mu.Lock()
+	defer txr.mu.Unlock()
+	if tx, ok := txr.txs[key]; ok {
+		tx.shardSessions = shardSessions
+		return
+	}
+	txr.txs[key] = &trackedTx{
+		shardSessions: shardSessions,
+		timer: time.AfterFunc(timeout, func() {
+			txr.reap(key)
+		}),
+	}
+}
+
+// untrack stops the timeout of the transaction of session.
+func (txr *txReaper) untrack(session *SafeSession) {
+	key, _ := session.txKey()
+	if key == "" {
+		return
+	}
+	txr.mu.Lock()
+	defer txr.mu.Unlock()
+	if tx, ok := txr.txs[key]; ok {
+		tx.timer.Stop()
+		delete(txr.txs, key)
+	}
+}
+
+// reap rolls back the transaction identified by key.
+func (txr *txReaper) reap(key string) {
+	txr.mu.Lock()
+	tx, ok := txr.txs[key]
+	if !ok {
+		txr.mu.Unlock()
+		return
+	}
+	delete(txr.txs, key)
+	txr.aborted[key] = true
+	txr.mu.Unlock()
+	time.AfterFunc(abortedRetention, func() {
+		txr.mu.Lock()
+		defer txr.mu.Unlock()
+		delete(txr.aborted, key)
+	})
+
+	log.Warningf("Rolling back transaction %s, it exceeded the transaction timeout", key)
+	ctx := context.Background()
+	for _, shardSession := range tx.shardSessions {
+		sdc := txr.scatterConn.getConnection(ctx, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		if err := sdc.Rollback(ctx, shardSession.TransactionId); err != nil {
+			log.Warningf("Could not roll back transaction %s on %s/%s: %v", key, shardSession.Keyspace, shardSession.Shard, err)
+		}
+	}
+}
+
+// checkAborted returns an error if the transaction of session
+// was rolled back because it timed out. The session is marked
+// aborted, and its shard sessions are dropped.
+func (txr *txReaper) checkAborted(session *SafeSession) error {
+	if session == nil || session.Session == nil {
+		return nil
+	}
+	key, _ := session.txKey()
+	if key != "" {
+		txr.mu.Lock()
+		aborted := txr.aborted[key]
+		delete(txr.aborted, key)
+		txr.mu.Unlock()
+		if aborted {
+			session.abort()
+		}
+	}
+	if session.isAborted() {
+		return errTransactionTimedOut
+	}
+	return nil
+}
+
+// txKey returns the key that identifies the transaction of session,
+// and a copy of its shard sessions. The key is empty if the session
+// is not in a transaction on any shard.
+func (session *SafeSession) txKey() (string, []*proto.ShardSession) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if !session.Session.InTransaction || len(session.ShardSessions) == 0 {
+		return "", nil
+	}
+	first := session.ShardSessions[0]
+	key := fmt.Sprintf("%s:%s:%s:%d", first.Keyspace, first.Shard, first.TabletType, first.TransactionId)
+	return key, append([]*proto.ShardSession(nil), session.ShardSessions...)
+}
+
+// transactionTimeout returns the timeout of the transaction of session.
+func (session *SafeSession) transactionTimeout() time.Duration {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.TransactionTimeout != 0 {
+		return time.Duration(session.TransactionTimeout)
+	}
+	return *transactionTimeout
+}
+
+// abort marks the transaction of session aborted. Its shard
+// sessions are dropped, since they were rolled back.
+func (session *SafeSession) abort() {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.TransactionAborted = true
+	session.ShardSessions = nil
+}
+
+func (session *SafeSession) isAborted() bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.TransactionAborted
+}
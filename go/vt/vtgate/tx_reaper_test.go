@@ -0,0 +1,87 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// This file uses the sandbox_test framework.
+
+func TestTxReaper(t *testing.T) {
+	s := createSandbox("TestTxReaper")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+	session := NewSafeSession(&proto.Session{InTransaction: true, TransactionTimeout: int64(10 * time.Millisecond)})
+	if _, err := stc.Execute(context.Background(), "query1", nil, "TestTxReaper", []string{"0", "1"}, "", session); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; sbc0.RollbackCount.Get() == 0 || sbc1.RollbackCount.Get() == 0; i++ {
+		if i == 100 {
+			t.Fatalf("transaction was not rolled back: %d, %d", sbc0.RollbackCount.Get(), sbc1.RollbackCount.Get())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, err := stc.Execute(context.Background(), "query1", nil, "TestTxReaper", []string{"0"}, "", session)
+	if err == nil || err.Error() != errTransactionTimedOut.Error() {
+		t.Errorf("Execute: %v, want %v", err, errTransactionTimedOut)
+	}
+	if !session.TransactionAborted || session.ShardSessions != nil {
+		t.Errorf("session: %v, want aborted with no shard sessions", session.Session)
+	}
+	if err := stc.Rollback(context.Background(), session); err != nil {
+		t.Error(err)
+	}
+	if session.TransactionAborted || session.InTransaction() {
+		t.Errorf("session: %v, want reset", session.Session)
+	}
+	if sbc0.RollbackCount.Get() != 1 {
+		t.Errorf("sbc0.RollbackCount: %d, want 1", sbc0.RollbackCount.Get())
+	}
+}
+
+func TestTxReaperCommit(t *testing.T) {
+	s := createSandbox("TestTxReaperCommit")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+	session := NewSafeSession(&proto.Session{InTransaction: true, TransactionTimeout: int64(10 * time.Millisecond)})
+	if _, err := stc.Execute(context.Background(), "query1", nil, "TestTxReaperCommit", []string{"0"}, "", session); err != nil {
+		t.Fatal(err)
+	}
+	if err := stc.Commit(context.Background(), session); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if sbc0.RollbackCount.Get() != 0 {
+		t.Errorf("sbc0.RollbackCount: %d, want 0", sbc0.RollbackCount.Get())
+	}
+
+	// A transaction that times out can't be committed.
+	session = NewSafeSession(&proto.Session{InTransaction: true, TransactionTimeout: int64(time.Millisecond)})
+	if _, err := stc.Execute(context.Background(), "query1", nil, "TestTxReaperCommit", []string{"0"}, "", session); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; sbc0.RollbackCount.Get() == 0; i++ {
+		if i == 100 {
+			t.Fatalf("transaction was not rolled back")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := stc.Commit(context.Background(), session); err != errTransactionTimedOut {
+		t.Errorf("Commit: %v, want %v", err, errTransactionTimedOut)
+	}
+	if session.InTransaction() || sbc0.CommitCount.Get() != 1 {
+		t.Errorf("session: %v, commits: %d, want reset and 1 commit", session.Session, sbc0.CommitCount.Get())
+	}
+}
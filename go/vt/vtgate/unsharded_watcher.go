@@ -0,0 +1,171 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/timer"
+	"github.com/youtube/vitess/go/vt/concurrency"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"golang.org/x/net/context"
+)
+
+// tablesQuery returns the tables of the database of a shard.
+const tablesQuery = "select table_name from information_schema.tables where table_schema = database()"
+
+// UnshardedWatcher discovers the tables of the unsharded keyspaces
+// that have no schema of their own, so that they don't need a
+// vschema: all their tables are routed to their only shard. The
+// tables are read from the master of the keyspace, and read again
+// every interval to pick up the new ones. The tables that are
+// defined by the schema of another keyspace are left out.
+type UnshardedWatcher struct {
+	router *Router
+	ticks  *timer.Timer
+
+	mu sync.Mutex
+	// tables are the sorted tables that were last
+	// discovered in each keyspace.
+	tables map[string][]string
+}
+
+// NewUnshardedWatcher creates an UnshardedWatcher that discovers
+// the tables of the unsharded keyspaces every interval.
+func NewUnshardedWatcher(interval time.Duration, router *Router) *UnshardedWatcher {
+	return &UnshardedWatcher{
+		router: router,
+		ticks:  timer.NewTimer(interval),
+		tables: make(map[string][]string),
+	}
+}
+
+// Open starts discovering the tables in the background.
+func (uw *UnshardedWatcher) Open() {
+	uw.ticks.Start(func() {
+		if err := uw.Check(); err != nil {
+			log.Errorf("Could not discover the tables of the unsharded keyspaces: %v", err)
+		}
+	})
+}
+
+// Close stops discovering the tables.
+func (uw *UnshardedWatcher) Close() {
+	uw.ticks.Stop()
+}
+
+// Check discovers the tables of the unsharded keyspaces, and
+// replaces the schema of the router if they changed. A keyspace
+// whose tables can't be read keeps the ones that were discovered
+// before. The errors of those keyspaces are returned together.
+func (uw *UnshardedWatcher) Check() error {
+	uw.mu.Lock()
+	defer uw.mu.Unlock()
+	ctx := context.Background()
+	keyspaces, err := uw.router.serv.GetSrvKeyspaceNames(ctx, uw.router.cell)
+	if err != nil {
+		return err
+	}
+	rec := concurrency.AllErrorRecorder{}
+	changed := make(map[string]*planbuilder.KeyspaceSchema)
+	found := make(map[string]bool)
+	for _, keyspace := range keyspaces {
+		if uw.router.keyspaces.isExplicit(keyspace) {
+			continue
+		}
+		shard, err := uw.unshardedShard(ctx, keyspace)
+		if err != nil {
+			rec.RecordError(fmt.Errorf("keyspace %s: %v", keyspace, err))
+			if _, ok := uw.tables[keyspace]; ok {
+				found[keyspace] = true
+			}
+			continue
+		}
+		if shard == "" {
+			continue
+		}
+		found[keyspace] = true
+		tables, err := uw.readTables(ctx, keyspace, shard)
+		if err != nil {
+			rec.RecordError(fmt.Errorf("keyspace %s: %v", keyspace, err))
+			continue
+		}
+		if previous, ok := uw.tables[keyspace]; ok && reflect.DeepEqual(previous, tables) {
+			continue
+		}
+		formal := planbuilder.KeyspaceFormal{Tables: make(map[string]planbuilder.TableFormal, len(tables))}
+		for _, table := range tables {
+			formal.Tables[table] = planbuilder.TableFormal{}
+		}
+		ks, err := planbuilder.BuildKeyspaceSchema(keyspace, formal)
+		if err != nil {
+			rec.RecordError(fmt.Errorf("keyspace %s: %v", keyspace, err))
+			continue
+		}
+		uw.tables[keyspace] = tables
+		changed[keyspace] = ks
+	}
+	for keyspace := range uw.tables {
+		if !found[keyspace] {
+			delete(uw.tables, keyspace)
+			changed[keyspace] = nil
+		}
+	}
+	version := uw.router.planner.SchemaVersion()
+	uw.router.keyspaces.update(changed, true, false, &rec)
+	if newVersion := uw.router.planner.SchemaVersion(); newVersion != version {
+		log.Infof("Discovered the tables of the unsharded keyspaces, version %d", newVersion)
+	}
+	return rec.Error()
+}
+
+// unshardedShard returns the only shard of keyspace if it's
+// unsharded and served by itself, or "" otherwise.
+func (uw *UnshardedWatcher) unshardedShard(ctx context.Context, keyspace string) (string, error) {
+	srvKeyspace, err := uw.router.serv.GetSrvKeyspace(ctx, uw.router.cell, keyspace)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := srvKeyspace.ServedFrom[topo.TYPE_MASTER]; ok {
+		return "", nil
+	}
+	partition, ok := srvKeyspace.Partitions[topo.TYPE_MASTER]
+	if !ok || len(partition.Shards) != 1 || partition.Shards[0].KeyRange.IsPartial() {
+		return "", nil
+	}
+	return partition.Shards[0].ShardName(), nil
+}
+
+// readTables returns the sorted tables of the database of shard.
+func (uw *UnshardedWatcher) readTables(ctx context.Context, keyspace, shard string) ([]string, error) {
+	qr, err := uw.router.scatterConn.Execute(
+		ctx,
+		tablesQuery,
+		nil,
+		keyspace,
+		[]string{shard},
+		topo.TYPE_MASTER,
+		NewSafeSession(nil))
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]string, 0, len(qr.Rows))
+	for _, row := range qr.Rows {
+		if len(row) < 1 {
+			return nil, fmt.Errorf("unexpected row in the tables of keyspace %s: %v", keyspace, row)
+		}
+		tables = append(tables, row[0].String())
+	}
+	sort.Strings(tables)
+	return tables, nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// tablesResult builds the result of tablesQuery.
+func tablesResult(tables ...string) *mproto.QueryResult {
+	qr := &mproto.QueryResult{
+		Fields: []mproto.Field{{"table_name", 253}},
+	}
+	for _, table := range tables {
+		qr.Rows = append(qr.Rows, []sqltypes.Value{{sqltypes.String(table)}})
+	}
+	qr.RowsAffected = uint64(len(qr.Rows))
+	return qr
+}
+
+func TestUnshardedWatcher(t *testing.T) {
+	createSandbox("TestRouter")
+	s := createSandbox(TEST_UNSHARDED)
+	sbc := &sandboxConn{}
+	s.MapTestConn("0", sbc)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", nil, "", scatterConn)
+	uw := NewUnshardedWatcher(1*time.Hour, router)
+
+	sbc.setResults([]*mproto.QueryResult{tablesResult("t2", "t1")})
+	if err := uw.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if sbc.Queries[0] != tablesQuery {
+		t.Errorf("queries: %v, want %s", sbc.Queries, tablesQuery)
+	}
+	if version := router.planner.SchemaVersion(); version != 1 {
+		t.Errorf("SchemaVersion: %d, want 1", version)
+	}
+	table, _ := router.planner.Schema().FindTable("t1")
+	if table == nil || table.Keyspace.Name != TEST_UNSHARDED || table.Keyspace.Sharded {
+		t.Errorf("FindTable(t1): %v, want an unsharded table of %s", table, TEST_UNSHARDED)
+	}
+	if plan := router.planner.GetPlan("select * from t2"); plan.ID != planbuilder.SelectUnsharded {
+		t.Errorf("plan: %v, want SelectUnsharded", plan.ID)
+	}
+
+	// The tables didn't change.
+	sbc.setResults([]*mproto.QueryResult{tablesResult("t1", "t2")})
+	if err := uw.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if version := router.planner.SchemaVersion(); version != 1 {
+		t.Errorf("SchemaVersion: %d, want 1", version)
+	}
+
+	// A keyspace whose tables can't be read keeps its tables.
+	sbc.mustFailServer = 1
+	if err := uw.Check(); err == nil {
+		t.Errorf("Check with a failing tablet: nil, want error")
+	}
+	if table, _ := router.planner.Schema().FindTable("t1"); table == nil {
+		t.Errorf("t1 not found after a failed discovery")
+	}
+
+	// The tables defined by an explicit schema are left out.
+	explicit, err := planbuilder.BuildSchema(&planbuilder.SchemaFormal{
+		Keyspaces: map[string]planbuilder.KeyspaceFormal{
+			"TestRouter": {Tables: map[string]planbuilder.TableFormal{"t2": {}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.SetSchema(explicit)
+	if table, _ := router.planner.Schema().FindTable("t1"); table == nil || table.Keyspace.Name != TEST_UNSHARDED {
+		t.Errorf("FindTable(t1): %v, want a table of %s", table, TEST_UNSHARDED)
+	}
+	if table, _ := router.planner.Schema().FindTable("t2"); table == nil || table.Keyspace.Name != "TestRouter" {
+		t.Errorf("FindTable(t2): %v, want a table of TestRouter", table)
+	}
+
+	// An explicit schema of the keyspace hides its discovered tables.
+	explicit, err = planbuilder.BuildSchema(&planbuilder.SchemaFormal{
+		Keyspaces: map[string]planbuilder.KeyspaceFormal{
+			TEST_UNSHARDED: {Tables: map[string]planbuilder.TableFormal{"t3": {}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.SetSchema(explicit)
+	if table, _ := router.planner.Schema().FindTable("t1"); table != nil {
+		t.Errorf("FindTable(t1): %v, want nil", table)
+	}
+	queries := len(sbc.Queries)
+	if err := uw.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if len(sbc.Queries) != queries {
+		t.Errorf("the tables of a keyspace with a schema were discovered: %v", sbc.Queries[queries:])
+	}
+	if table, _ := router.planner.Schema().FindTable("t3"); table == nil {
+		t.Errorf("t3 not found in the explicit schema")
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"flag"
+	"fmt"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+var (
+	v2Fallback = flag.Bool("v3_fallback_to_v2", false, "execute the queries that the V3 planner cannot handle on the shards of the keyspace ids or keyranges of the request, like the V2 API, instead of failing them")
+
+	v2Fallbacks = stats.NewCounters("VtgateV2Fallbacks")
+)
+
+// canFallbackV2 returns true if the query of vcursor, which
+// couldn't be planned, can be routed like a V2 query instead.
+func (rtr *Router) canFallbackV2(vcursor *requestContext, plan *planbuilder.Plan) bool {
+	query := vcursor.query
+	return rtr.v2Fallback && plan.ID == planbuilder.NoPlan &&
+		query.Keyspace != "" && (len(query.KeyspaceIds) != 0 || len(query.KeyRanges) != 0)
+}
+
+// paramsV2 returns the shards of the keyspace ids or keyranges
+// of the query of vcursor, like ExecuteKeyspaceIds and
+// ExecuteKeyRanges. The query is sent as is.
+func (rtr *Router) paramsV2(vcursor *requestContext) (*scatterParams, error) {
+	query := vcursor.query
+	if len(query.KeyspaceIds) != 0 && len(query.KeyRanges) != 0 {
+		return nil, fmt.Errorf("cannot route %q with both keyspace ids and keyranges", query.Sql)
+	}
+	if isDml(query.Sql) && len(query.KeyspaceIds) > 1 {
+		return nil, fmt.Errorf("DML should not span multiple keyspace_ids")
+	}
+	var ks string
+	var shards []string
+	var err error
+	if len(query.KeyspaceIds) != 0 {
+		ks, shards, err = mapKeyspaceIdsToShards(vcursor.ctx, rtr.serv, rtr.cell, query.Keyspace, query.TabletType, query.KeyspaceIds)
+	} else {
+		ks, shards, err = mapKeyRangesToShards(vcursor.ctx, rtr.serv, rtr.cell, query.Keyspace, query.TabletType, query.KeyRanges)
+	}
+	if err != nil {
+		return nil, err
+	}
+	v2Fallbacks.Add(ks, 1)
+	return newScatterParams(query.Sql, ks, query.BindVariables, shards), nil
+}
+
+func (rtr *Router) execV2(vcursor *requestContext) (*mproto.QueryResult, error) {
+	params, err := rtr.paramsV2(vcursor)
+	if err != nil {
+		return nil, err
+	}
+	return rtr.execScatterSelect(vcursor, params)
+}
+
+func (rtr *Router) streamExecV2(vcursor *requestContext, sendReply func(*mproto.QueryResult) error) error {
+	params, err := rtr.paramsV2(vcursor)
+	if err != nil {
+		return err
+	}
+	return rtr.scatterConn.StreamExecuteMulti(
+		vcursor.ctx,
+		params.query,
+		params.ks,
+		params.shardVars,
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		sendReply)
+}
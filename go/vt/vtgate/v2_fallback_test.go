@@ -0,0 +1,92 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// This file uses the sandbox_test framework.
+
+func TestRouterV2Fallback(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("-20", sbc1)
+	s.MapTestConn("40-60", sbc2)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	sql := "select * from legacy_table"
+	query := func() *proto.Query {
+		return &proto.Query{
+			Sql:         sql,
+			TabletType:  topo.TYPE_MASTER,
+			Keyspace:    "TestRouter",
+			KeyspaceIds: []key.KeyspaceId{"\x10"},
+		}
+	}
+	if _, err := router.Execute(context.Background(), query()); err == nil || !strings.Contains(err.Error(), "table legacy_table not found") {
+		t.Errorf("Execute without the fallback: %v, want table legacy_table not found", err)
+	}
+
+	router.v2Fallback = true
+	if _, err := router.Execute(context.Background(), query()); err != nil {
+		t.Fatal(err)
+	}
+	if sbc1.ExecCount != 1 || sbc1.Queries[0] != sql {
+		t.Errorf("sbc1.Queries: %v, want %s", sbc1.Queries, sql)
+	}
+
+	// A query that the V3 planner handles is not affected.
+	q := query()
+	q.Sql = "select * from user where id = 3"
+	if _, err := router.Execute(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	if sbc1.ExecCount != 1 || sbc2.ExecCount != 1 {
+		t.Errorf("ExecCount: %v, %v, want 1, 1", sbc1.ExecCount, sbc2.ExecCount)
+	}
+
+	q = query()
+	q.KeyspaceIds = nil
+	q.KeyRanges = []key.KeyRange{{Start: "\x40", End: "\x60"}}
+	err = router.StreamExecute(context.Background(), q, func(*mproto.QueryResult) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sbc2.ExecCount != 2 {
+		t.Errorf("sbc2.ExecCount: %v, want 2", sbc2.ExecCount)
+	}
+
+	q = query()
+	q.KeyRanges = []key.KeyRange{{Start: "\x40", End: "\x60"}}
+	if _, err := router.Execute(context.Background(), q); err == nil || !strings.Contains(err.Error(), "both keyspace ids and keyranges") {
+		t.Errorf("Execute with keyspace ids and keyranges: %v, want error", err)
+	}
+
+	// Without a keyspace, the query still fails.
+	q = query()
+	q.Keyspace = ""
+	if _, err := router.Execute(context.Background(), q); err == nil {
+		t.Errorf("Execute without a keyspace: nil, want error")
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"golang.org/x/net/context"
+)
+
+// columnsQuery returns the columns of the tables of the
+// database of a shard, with their MySQL type.
+const columnsQuery = "select table_name, column_name, column_type from information_schema.columns where table_schema = database()"
+
+// ValidateVSchema checks the proposed vschema of keyspace against
+// the database of the keyspace, and returns the problems it would
+// cause if it was applied. The tables of the current schema are
+// used to resolve the lookup tables of the other keyspaces. The
+// database schema is read from the first master shard of each
+// keyspace involved.
+func (rtr *Router) ValidateVSchema(ctx context.Context, keyspace, vschema string) ([]string, error) {
+	var ks planbuilder.KeyspaceFormal
+	if err := json.Unmarshal([]byte(vschema), &ks); err != nil {
+		return nil, fmt.Errorf("invalid vschema for keyspace %s: %v", keyspace, err)
+	}
+	source := &planbuilder.SchemaFormal{
+		Keyspaces: map[string]planbuilder.KeyspaceFormal{keyspace: ks},
+	}
+	current := rtr.planner.Schema()
+	keyspaces := map[string]bool{keyspace: true}
+	if current != nil {
+		for _, vindexInfo := range ks.Vindexes {
+			lookup, _ := vindexInfo.Params["Table"].(string)
+			if _, ok := ks.Tables[lookup]; ok || lookup == "" {
+				continue
+			}
+			if table, _ := current.FindTable(lookup); table != nil {
+				keyspaces[table.Keyspace.Name] = true
+			}
+		}
+	}
+	databases := make(map[string]planbuilder.DBColumns, len(keyspaces))
+	for name := range keyspaces {
+		columns, err := rtr.getDBColumns(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		databases[name] = columns
+	}
+	return planbuilder.ValidateSchema(source, current, databases), nil
+}
+
+// getDBColumns reads the columns of the tables of keyspace
+// from its first master shard.
+func (rtr *Router) getDBColumns(ctx context.Context, keyspace string) (planbuilder.DBColumns, error) {
+	newKeyspace, allShards, err := getKeyspaceShards(ctx, rtr.serv, rtr.cell, keyspace, topo.TYPE_MASTER)
+	if err != nil {
+		return nil, err
+	}
+	if len(allShards) == 0 {
+		return nil, fmt.Errorf("no shards found for keyspace %s", keyspace)
+	}
+	qr, err := rtr.scatterConn.Execute(
+		ctx,
+		columnsQuery,
+		nil,
+		newKeyspace,
+		[]string{allShards[0].ShardName()},
+		topo.TYPE_MASTER,
+		NewSafeSession(nil))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the database schema of keyspace %s: %v", keyspace, err)
+	}
+	columns := make(planbuilder.DBColumns)
+	for _, row := range qr.Rows {
+		if len(row) < 3 {
+			return nil, fmt.Errorf("unexpected row in the database schema of keyspace %s: %v", keyspace, row)
+		}
+		table := row[0].String()
+		if columns[table] == nil {
+			columns[table] = make(map[string]string)
+		}
+		columns[table][row[1].String()] = row[2].String()
+	}
+	return columns, nil
+}
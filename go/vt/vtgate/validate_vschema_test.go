@@ -0,0 +1,109 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"golang.org/x/net/context"
+)
+
+// columnsResult builds the result of columnsQuery
+// from rows of table name, column name and column type.
+func columnsResult(rows ...[3]string) *mproto.QueryResult {
+	qr := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"table_name", 253},
+			{"column_name", 253},
+			{"column_type", 253},
+		},
+	}
+	for _, row := range rows {
+		qr.Rows = append(qr.Rows, []sqltypes.Value{
+			{sqltypes.String(row[0])},
+			{sqltypes.String(row[1])},
+			{sqltypes.String(row[2])},
+		})
+	}
+	qr.RowsAffected = uint64(len(qr.Rows))
+	return qr
+}
+
+func TestValidateVSchema(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("-20", sbc)
+	u := createSandbox(TEST_UNSHARDED)
+	sbclookup := &sandboxConn{}
+	u.MapTestConn("0", sbclookup)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	vschema := `{
+  "Sharded": true,
+  "Vindexes": {
+    "user_index": {"Type": "hash", "Owner": "user", "Params": {"Table": "user_idx", "Column": "id"}},
+    "name_user_map": {"Type": "lookup_hash_multi", "Owner": "user", "Params": {"Table": "name_user_map", "From": "name", "To": "user_id"}}
+  },
+  "Tables": {
+    "user": {"ColVindexes": [{"Col": "id", "Name": "user_index"}, {"Col": "name", "Name": "name_user_map"}]}
+  }
+}`
+	sbc.setResults([]*mproto.QueryResult{columnsResult(
+		[3]string{"user", "id", "bigint(20)"},
+		[3]string{"user", "name", "varchar(64)"},
+	)})
+	sbclookup.setResults([]*mproto.QueryResult{columnsResult(
+		[3]string{"user_idx", "id", "bigint(20)"},
+		[3]string{"name_user_map", "name", "varchar(64)"},
+		[3]string{"name_user_map", "user_id", "bigint(20)"},
+	)})
+	problems, err := router.ValidateVSchema(context.Background(), "TestRouter", vschema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems != nil {
+		t.Errorf("ValidateVSchema: %v, want none", problems)
+	}
+	if sbc.Queries[0] != columnsQuery || sbclookup.Queries[0] != columnsQuery {
+		t.Errorf("queries: %v, %v, want %s", sbc.Queries, sbclookup.Queries, columnsQuery)
+	}
+
+	sbc.setResults([]*mproto.QueryResult{columnsResult(
+		[3]string{"user", "id", "varchar(10)"},
+	)})
+	sbclookup.setResults([]*mproto.QueryResult{columnsResult(
+		[3]string{"user_idx", "id", "bigint(20)"},
+		[3]string{"name_user_map", "name", "varchar(64)"},
+	)})
+	problems, err = router.ValidateVSchema(context.Background(), "TestRouter", vschema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"column id of table user has type varchar(10), which vindex user_index of type hash cannot map",
+		"column name of vindex name_user_map not found in table user",
+		"column user_id of vindex name_user_map not found in table name_user_map",
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("ValidateVSchema: %v, want %v", problems, want)
+	}
+
+	_, err = router.ValidateVSchema(context.Background(), "TestRouter", "{")
+	want0 := "invalid vschema for keyspace TestRouter: unexpected end of JSON input"
+	if err == nil || err.Error() != want0 {
+		t.Errorf("ValidateVSchema: %v, want %s", err, want0)
+	}
+}
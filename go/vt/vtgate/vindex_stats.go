@@ -0,0 +1,62 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"time"
+
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/trace"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// The operations of the vindexes are timed and traced by vindex
+// name, because those of the lookup vindexes issue queries of their
+// own, which would otherwise only show up as a part of the latency
+// of the statements that caused them. VtgateVindexIds adds up the
+// ids the operations were called with: divided by their count, it
+// gives their average batch size.
+var (
+	vindexLabels = []string{"Vindex", "Operation"}
+
+	vindexTimings = stats.NewMultiTimings("VtgateVindexOperations", vindexLabels)
+	vindexIds     = stats.NewMultiCounters("VtgateVindexIds", vindexLabels)
+	vindexErrors  = stats.NewMultiCounters("VtgateVindexErrors", vindexLabels)
+)
+
+// vindexOp is an operation of a vindex in flight.
+type vindexOp struct {
+	key       []string
+	span      trace.Span
+	startTime time.Time
+}
+
+// startVindexOp starts the operation of colVindex on batchSize ids.
+// It returns the cursor to call the operation with, whose lookup
+// queries are traced as children of the span of the operation.
+func (vc *requestContext) startVindexOp(colVindex *planbuilder.ColVindex, operation string, batchSize int) (*requestContext, *vindexOp) {
+	op := &vindexOp{
+		key:       []string{colVindex.Name, operation},
+		startTime: time.Now(),
+	}
+	cursor := *vc
+	cursor.ctx, op.span = startSpan(vc.ctx, "Vindex."+operation)
+	op.span.Annotate("vindex", colVindex.Name)
+	op.span.Annotate("batch_size", batchSize)
+	vindexIds.Add(op.key, int64(batchSize))
+	return &cursor, op
+}
+
+// finish records the operation, which failed if err is not nil.
+func (op *vindexOp) finish(err error) {
+	vindexTimings.Add(op.key, time.Now().Sub(op.startTime))
+	if err != nil {
+		vindexErrors.Add(op.key, 1)
+		op.span.Annotate("error", err.Error())
+	}
+	op.span.Finish()
+}
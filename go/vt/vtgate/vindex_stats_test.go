@@ -0,0 +1,83 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestRouterVindexStats(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	for _, shard := range []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"} {
+		s.MapTestConn(shard, &sandboxConn{})
+	}
+	l := createSandbox("TestUnsharded")
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	type counts struct {
+		operations, ids, errors int64
+	}
+	get := func(key string) counts {
+		return counts{
+			operations: vindexTimings.Counts()[key],
+			ids:        vindexIds.Counts()[key],
+			errors:     vindexErrors.Counts()[key],
+		}
+	}
+	keys := []string{
+		"user_index.Generate",
+		"user_index.Map",
+		"name_user_map.Create",
+	}
+	before := make(map[string]counts)
+	for _, key := range keys {
+		before[key] = get(key)
+	}
+
+	sbclookup.setResults([]*mproto.QueryResult{{RowsAffected: 1, InsertId: 1}})
+	q := &proto.Query{
+		Sql:        "insert into user(v, name) values (2, 'myname')",
+		TabletType: topo.TYPE_MASTER,
+	}
+	if _, err := router.Execute(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	q.Sql = "select * from user where id in (1, 2)"
+	if _, err := router.Execute(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	// The id can't be generated if the lookup fails.
+	sbclookup.mustFailServer = 1
+	q.Sql = "insert into user(v, name) values (2, 'myname')"
+	if _, err := router.Execute(context.Background(), q); err == nil {
+		t.Fatal("router.Execute: nil, want error")
+	}
+
+	for key, want := range map[string]counts{
+		"user_index.Generate":  {2, 2, 1},
+		"user_index.Map":       {2, 3, 0},
+		"name_user_map.Create": {1, 1, 0},
+	} {
+		after := get(key)
+		if got := (counts{after.operations - before[key].operations, after.ids - before[key].ids, after.errors - before[key].errors}); got != want {
+			t.Errorf("%s: %+v more, want %+v", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+var (
+	_ planbuilder.NonUnique  = (*ConsistentLookupHash)(nil)
+	_ planbuilder.Consistent = (*ConsistentLookupHash)(nil)
+
+	_ planbuilder.Unique     = (*ConsistentLookupHashUnique)(nil)
+	_ planbuilder.Consistent = (*ConsistentLookupHashUnique)(nil)
+)
+
+// ConsistentLookupHash is a LookupHashMulti whose entries
+// are written in the same transaction as their rows.
+type ConsistentLookupHash struct {
+	LookupHashMulti
+}
+
+func NewConsistentLookupHash(m map[string]interface{}) (planbuilder.Vindex, error) {
+	clh := &ConsistentLookupHash{}
+	if err := clh.init(m); err != nil {
+		return nil, err
+	}
+	return clh, nil
+}
+
+func (vind *ConsistentLookupHash) Consistent() {}
+
+// ConsistentLookupHashUnique is a LookupHashUnique whose
+// entries are written in the same transaction as their rows.
+type ConsistentLookupHashUnique struct {
+	LookupHashUnique
+}
+
+func NewConsistentLookupHashUnique(m map[string]interface{}) (planbuilder.Vindex, error) {
+	clhu := &ConsistentLookupHashUnique{}
+	if err := clhu.init(m); err != nil {
+		return nil, err
+	}
+	return clhu, nil
+}
+
+func (vind *ConsistentLookupHashUnique) Consistent() {}
+
+func init() {
+	planbuilder.Register("consistent_lookup_hash", NewConsistentLookupHash)
+	planbuilder.Register("consistent_lookup_hash_unique", NewConsistentLookupHashUnique)
+}
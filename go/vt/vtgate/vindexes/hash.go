@@ -45,6 +45,10 @@ func (vind *HashVindex) Cost() int {
 	return 1
 }
 
+func (vind *HashVindex) AcceptsType(columnType string) bool {
+	return planbuilder.IsIntegralType(columnType)
+}
+
 func (vind *HashVindex) Map(_ planbuilder.VCursor, ids []interface{}) ([]key.KeyspaceId, error) {
 	out := make([]key.KeyspaceId, 0, len(ids))
 	for _, id := range ids {
@@ -5,6 +5,7 @@
 package vindexes
 
 import (
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
@@ -108,6 +109,9 @@ type vcursor struct {
 func (vc *vcursor) Execute(query *tproto.BoundQuery) (*mproto.QueryResult, error) {
 	vc.query = query
 	switch {
+	case strings.HasPrefix(query.Sql, "select") && strings.Contains(query.Sql, " in ::"):
+		// Each id maps to 1.
+		return listResult(query, 1), nil
 	case strings.HasPrefix(query.Sql, "select"):
 		return &mproto.QueryResult{
 			Fields: []mproto.Field{{
@@ -128,6 +132,30 @@ func (vc *vcursor) Execute(query *tproto.BoundQuery) (*mproto.QueryResult, error
 	panic("unexpected")
 }
 
+// listResult returns the result of a select that looks up a
+// list of ids. Each id is mapped to all the values in nums.
+func listResult(query *tproto.BoundQuery, nums ...int) *mproto.QueryResult {
+	result := &mproto.QueryResult{
+		Fields: []mproto.Field{{
+			Type: mproto.VT_LONG,
+		}, {
+			Type: mproto.VT_LONG,
+		}},
+	}
+	for _, ids := range query.BindVariables {
+		for _, id := range ids.([]interface{}) {
+			for _, num := range nums {
+				result.Rows = append(result.Rows, []sqltypes.Value{
+					sqltypes.MakeNumeric([]byte(fmt.Sprint(id))),
+					sqltypes.MakeNumeric([]byte(fmt.Sprint(num))),
+				})
+			}
+		}
+	}
+	result.RowsAffected = uint64(len(result.Rows))
+	return result
+}
+
 func TestHashCreate(t *testing.T) {
 	vc := &vcursor{}
 	err := hash.Create(vc, 1)
@@ -0,0 +1,109 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/youtube/vitess/go/cache"
+	"github.com/youtube/vitess/go/stats"
+)
+
+// lookupCacheCounts counts the hits and misses
+// of the lookup vindex caches by table.
+var lookupCacheCounts = stats.NewMultiCounters("VindexLookupCacheCounts", []string{"Table", "Result"})
+
+// lookupCache caches the values that a lookup vindex maps its ids to.
+// Entries are invalidated when this vtgate creates or deletes them,
+// and expire after the ttl so that the changes made by other vtgates
+// are eventually seen. Ids that aren't found are not cached.
+type lookupCache struct {
+	table string
+	ttl   time.Duration
+	lru   *cache.LRUCache
+}
+
+type lookupCacheEntry struct {
+	values  []int64
+	expires time.Time
+}
+
+func (lce *lookupCacheEntry) Size() int {
+	return 1
+}
+
+// newLookupCache creates a lookupCache from the CacheSize and
+// CacheTTL params. CacheSize is the number of ids to cache, and
+// CacheTTL is a duration like "30s". It returns nil if CacheSize
+// is not set. A CacheTTL of 0 means that the entries don't expire.
+func newLookupCache(table string, m map[string]interface{}) (*lookupCache, error) {
+	var size int64
+	switch v := m["CacheSize"].(type) {
+	case nil:
+		return nil, nil
+	case float64:
+		size = int64(v)
+	case string:
+		var err error
+		if size, err = strconv.ParseInt(v, 0, 64); err != nil {
+			return nil, fmt.Errorf("lookup vindex %s: invalid CacheSize: %v", table, err)
+		}
+	default:
+		return nil, fmt.Errorf("lookup vindex %s: invalid CacheSize: %v", table, v)
+	}
+	if size <= 0 {
+		return nil, nil
+	}
+	var ttl time.Duration
+	switch v := m["CacheTTL"].(type) {
+	case nil:
+	case string:
+		var err error
+		if ttl, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("lookup vindex %s: invalid CacheTTL: %v", table, err)
+		}
+	default:
+		return nil, fmt.Errorf("lookup vindex %s: invalid CacheTTL: %v", table, v)
+	}
+	return &lookupCache{
+		table: table,
+		ttl:   ttl,
+		lru:   cache.NewLRUCache(size),
+	}, nil
+}
+
+// Get returns the cached values of id.
+func (lc *lookupCache) Get(id interface{}) ([]int64, bool) {
+	if v, ok := lc.lru.Get(lookupKey(id)); ok {
+		entry := v.(*lookupCacheEntry)
+		if lc.ttl == 0 || time.Now().Before(entry.expires) {
+			lookupCacheCounts.Add([]string{lc.table, "Hit"}, 1)
+			return entry.values, true
+		}
+		lc.lru.Delete(lookupKey(id))
+	}
+	lookupCacheCounts.Add([]string{lc.table, "Miss"}, 1)
+	return nil, false
+}
+
+// Set caches the values of id. Empty values are not cached.
+func (lc *lookupCache) Set(id interface{}, values []int64) {
+	if len(values) == 0 {
+		return
+	}
+	lc.lru.Set(lookupKey(id), &lookupCacheEntry{
+		values:  values,
+		expires: time.Now().Add(lc.ttl),
+	})
+}
+
+// Invalidate removes the cached values of the ids.
+func (lc *lookupCache) Invalidate(ids ...interface{}) {
+	for _, id := range ids {
+		lc.lru.Delete(lookupKey(id))
+	}
+}
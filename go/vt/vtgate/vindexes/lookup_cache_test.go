@@ -0,0 +1,131 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/vt/key"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+)
+
+// vcursorcount is a vcursor that counts the queries it executes.
+type vcursorcount struct {
+	vcursor
+	count int
+}
+
+func (vc *vcursorcount) Execute(query *tproto.BoundQuery) (*mproto.QueryResult, error) {
+	vc.count++
+	return vc.vcursor.Execute(query)
+}
+
+func TestLookupCache(t *testing.T) {
+	// The counters are global, reset them in case the test is repeated.
+	lookupCacheCounts.Set([]string{"cached", "Hit"}, 0)
+	lookupCacheCounts.Set([]string{"cached", "Miss"}, 0)
+	v, err := NewLookupHashUnique(map[string]interface{}{
+		"Table":     "cached",
+		"From":      "fromc",
+		"To":        "toc",
+		"CacheSize": float64(10),
+		"CacheTTL":  "1h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vind := v.(*LookupHashUnique)
+	vc := &vcursorcount{}
+	want := []key.KeyspaceId{"\x16k@\xb4J\xbaK\xd6"}
+	for i := 0; i < 2; i++ {
+		got, err := vind.Map(vc, []interface{}{1})
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Map(): %#v, want %+v", got, want)
+		}
+	}
+	if vc.count != 1 {
+		t.Errorf("count: %d, want 1", vc.count)
+	}
+
+	// Only the ids that are not cached are looked up.
+	_, err = vind.Map(vc, []interface{}{1, 2})
+	if err != nil {
+		t.Error(err)
+	}
+	if vc.count != 2 {
+		t.Errorf("count: %d, want 2", vc.count)
+	}
+	wantBindVars := map[string]interface{}{"fromc": 2}
+	if !reflect.DeepEqual(vc.query.BindVariables, wantBindVars) {
+		t.Errorf("vc.query.BindVariables: %v, want %v", vc.query.BindVariables, wantBindVars)
+	}
+
+	// Create and Delete invalidate the entries.
+	if err := vind.Create(vc, 1, "\x16k@\xb4J\xbaK\xd6"); err != nil {
+		t.Error(err)
+	}
+	if err := vind.Delete(vc, []interface{}{2}, "\x16k@\xb4J\xbaK\xd6"); err != nil {
+		t.Error(err)
+	}
+	vc.count = 0
+	_, err = vind.Map(vc, []interface{}{1, 2})
+	if err != nil {
+		t.Error(err)
+	}
+	if vc.count != 1 {
+		t.Errorf("count: %d, want 1", vc.count)
+	}
+
+	counts := lookupCacheCounts.Counts()
+	if counts["cached.Hit"] != 2 || counts["cached.Miss"] != 4 {
+		t.Errorf("lookupCacheCounts: %v, want 2 hits and 4 misses", counts)
+	}
+}
+
+func TestLookupCacheExpiry(t *testing.T) {
+	lc, err := newLookupCache("expiry", map[string]interface{}{"CacheSize": "10", "CacheTTL": "1ns"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc.Set(1, []int64{1})
+	time.Sleep(time.Millisecond)
+	if _, ok := lc.Get(1); ok {
+		t.Errorf("Get(1) found an expired entry")
+	}
+
+	// Ids that are not found are not cached.
+	lc, err = newLookupCache("expiry", map[string]interface{}{"CacheSize": "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc.Set(1, nil)
+	if _, ok := lc.Get(1); ok {
+		t.Errorf("Get(1) found an empty entry")
+	}
+}
+
+func TestLookupCacheParams(t *testing.T) {
+	lc, err := newLookupCache("t", map[string]interface{}{})
+	if lc != nil || err != nil {
+		t.Errorf("newLookupCache: %v, %v, want nil, nil", lc, err)
+	}
+	_, err = NewLookupHashMulti(map[string]interface{}{"Table": "t", "CacheSize": "a"})
+	want := `lookup vindex t: invalid CacheSize: strconv.ParseInt: parsing "a": invalid syntax`
+	if err == nil || err.Error() != want {
+		t.Errorf("NewLookupHashMulti: %v, want %s", err, want)
+	}
+	_, err = NewLookupHashMulti(map[string]interface{}{"Table": "t", "CacheSize": float64(1), "CacheTTL": "a"})
+	want = "lookup vindex t: invalid CacheTTL: "
+	if err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("NewLookupHashMulti: %v, want %s", err, want)
+	}
+}
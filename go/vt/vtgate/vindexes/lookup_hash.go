@@ -7,17 +7,19 @@ package vindexes
 import (
 	"fmt"
 
+	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/vt/key"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
 	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
 )
 
 type lookupHash struct {
-	Table, From, To       string
-	sel, verify, ins, del string
+	Table, From, To                string
+	sel, selList, verify, ins, del string
+	cache                          *lookupCache
 }
 
-func (vind *lookupHash) init(m map[string]interface{}) {
+func (vind *lookupHash) init(m map[string]interface{}) error {
 	get := func(name string) string {
 		v, _ := m[name].(string)
 		return v
@@ -30,9 +32,13 @@ func (vind *lookupHash) init(m map[string]interface{}) {
 	vind.From = from
 	vind.To = to
 	vind.sel = fmt.Sprintf("select %s from %s where %s = :%s", to, t, from, from)
+	vind.selList = fmt.Sprintf("select %s, %s from %s where %s in ::%s", from, to, t, from, from)
 	vind.verify = fmt.Sprintf("select %s from %s where %s = :%s and %s = :%s", from, t, from, from, to, to)
 	vind.ins = fmt.Sprintf("insert into %s(%s, %s) values(:%s, :%s)", t, from, to, from, to)
 	vind.del = fmt.Sprintf("delete from %s where %s in ::%s and %s = :%s", t, from, from, to, to)
+	var err error
+	vind.cache, err = newLookupCache(t, m)
+	return err
 }
 
 func (vind *lookupHash) Verify(vcursor planbuilder.VCursor, id interface{}, ksid key.KeyspaceId) (bool, error) {
@@ -64,6 +70,9 @@ func (vind *lookupHash) Create(vcursor planbuilder.VCursor, id interface{}, ksid
 	if _, err := vcursor.Execute(bq); err != nil {
 		return err
 	}
+	if vind.cache != nil {
+		vind.cache.Invalidate(id)
+	}
 	return nil
 }
 
@@ -78,5 +87,123 @@ func (vind *lookupHash) Delete(vcursor planbuilder.VCursor, ids []interface{}, k
 	if _, err := vcursor.Execute(bq); err != nil {
 		return err
 	}
+	if vind.cache != nil {
+		vind.cache.Invalidate(ids...)
+	}
 	return nil
 }
+
+// lookup returns the values of each id, in the order of ids. The
+// ids that are not cached are looked up with a single query.
+func (vind *lookupHash) lookup(vcursor planbuilder.VCursor, ids []interface{}) ([][]int64, error) {
+	out := make([][]int64, len(ids))
+	var misses []int
+	var missIds []interface{}
+	for i, id := range ids {
+		if vind.cache != nil {
+			if values, ok := vind.cache.Get(id); ok {
+				out[i] = values
+				continue
+			}
+		}
+		misses = append(misses, i)
+		missIds = append(missIds, id)
+	}
+	var values [][]int64
+	var err error
+	switch len(missIds) {
+	case 0:
+		return out, nil
+	case 1:
+		values, err = vind.mapOne(vcursor, missIds[0])
+	default:
+		values, err = vind.mapList(vcursor, missIds)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range misses {
+		out[i] = values[j]
+		if vind.cache != nil {
+			vind.cache.Set(ids[i], values[j])
+		}
+	}
+	return out, nil
+}
+
+// mapOne looks up the values of a single id.
+func (vind *lookupHash) mapOne(vcursor planbuilder.VCursor, id interface{}) ([][]int64, error) {
+	bq := &tproto.BoundQuery{
+		Sql: vind.sel,
+		BindVariables: map[string]interface{}{
+			vind.From: id,
+		},
+	}
+	result, err := vcursor.Execute(bq)
+	if err != nil {
+		return nil, err
+	}
+	var nums []int64
+	for _, row := range result.Rows {
+		inum, err := mproto.Convert(result.Fields[0].Type, row[0])
+		if err != nil {
+			return nil, err
+		}
+		num, err := getNumber(inum)
+		if err != nil {
+			return nil, err
+		}
+		nums = append(nums, num)
+	}
+	return [][]int64{nums}, nil
+}
+
+// mapList looks up the values of all the ids with a single query.
+// It returns the values of each id, in the order of ids.
+func (vind *lookupHash) mapList(vcursor planbuilder.VCursor, ids []interface{}) ([][]int64, error) {
+	bq := &tproto.BoundQuery{
+		Sql: vind.selList,
+		BindVariables: map[string]interface{}{
+			vind.From: ids,
+		},
+	}
+	result, err := vcursor.Execute(bq)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string][]int64)
+	for _, row := range result.Rows {
+		from, err := mproto.Convert(result.Fields[0].Type, row[0])
+		if err != nil {
+			return nil, err
+		}
+		inum, err := mproto.Convert(result.Fields[1].Type, row[1])
+		if err != nil {
+			return nil, err
+		}
+		num, err := getNumber(inum)
+		if err != nil {
+			return nil, err
+		}
+		k := lookupKey(from)
+		values[k] = append(values[k], num)
+	}
+	out := make([][]int64, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, values[lookupKey(id)])
+	}
+	return out, nil
+}
+
+// lookupKey converts an id to the string that it's matched
+// by in the lookup table, the way MySQL compares a number to a
+// string. This lets the ids match the values returned in the result.
+func lookupKey(id interface{}) string {
+	switch id := id.(type) {
+	case []byte:
+		return string(id)
+	case string:
+		return id
+	}
+	return fmt.Sprintf("%v", id)
+}
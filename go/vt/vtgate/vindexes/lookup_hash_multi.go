@@ -5,9 +5,7 @@
 package vindexes
 
 import (
-	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/vt/key"
-	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
 	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
 )
 
@@ -22,7 +20,9 @@ type LookupHashMulti struct {
 
 func NewLookupHashMulti(m map[string]interface{}) (planbuilder.Vindex, error) {
 	lhm := &LookupHashMulti{}
-	lhm.init(m)
+	if err := lhm.init(m); err != nil {
+		return nil, err
+	}
 	return lhm, nil
 }
 
@@ -30,29 +30,17 @@ func (vind *LookupHashMulti) Cost() int {
 	return 20
 }
 
+// Map looks up the keyspace ids of ids. If there are
+// multiple ids, they are looked up with a single query.
 func (vind *LookupHashMulti) Map(vcursor planbuilder.VCursor, ids []interface{}) ([][]key.KeyspaceId, error) {
-	out := make([][]key.KeyspaceId, 0, len(ids))
-	bq := &tproto.BoundQuery{
-		Sql: vind.sel,
+	values, err := vind.lookup(vcursor, ids)
+	if err != nil {
+		return nil, err
 	}
-	for _, id := range ids {
-		bq.BindVariables = map[string]interface{}{
-			vind.From: id,
-		}
-		result, err := vcursor.Execute(bq)
-		if err != nil {
-			return nil, err
-		}
+	out := make([][]key.KeyspaceId, 0, len(ids))
+	for _, nums := range values {
 		var ksids []key.KeyspaceId
-		for _, row := range result.Rows {
-			inum, err := mproto.Convert(result.Fields[0].Type, row[0])
-			if err != nil {
-				return nil, err
-			}
-			num, err := getNumber(inum)
-			if err != nil {
-				return nil, err
-			}
+		for _, num := range nums {
 			ksids = append(ksids, vhash(num))
 		}
 		out = append(out, ksids)
@@ -7,7 +7,6 @@ package vindexes
 import (
 	"fmt"
 
-	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/vt/key"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
 	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
@@ -24,7 +23,9 @@ type LookupHashUnique struct {
 
 func NewLookupHashUnique(m map[string]interface{}) (planbuilder.Vindex, error) {
 	lhu := &LookupHashUnique{}
-	lhu.init(m)
+	if err := lhu.init(m); err != nil {
+		return nil, err
+	}
 	return lhu, nil
 }
 
@@ -32,35 +33,23 @@ func (vind *LookupHashUnique) Cost() int {
 	return 10
 }
 
+// Map looks up the keyspace ids of ids. If there are
+// multiple ids, they are looked up with a single query.
 func (vind *LookupHashUnique) Map(vcursor planbuilder.VCursor, ids []interface{}) ([]key.KeyspaceId, error) {
-	out := make([]key.KeyspaceId, 0, len(ids))
-	bq := &tproto.BoundQuery{
-		Sql: vind.sel,
+	values, err := vind.lookup(vcursor, ids)
+	if err != nil {
+		return nil, err
 	}
-	for _, id := range ids {
-		bq.BindVariables = map[string]interface{}{
-			vind.From: id,
-		}
-		result, err := vcursor.Execute(bq)
-		if err != nil {
-			return nil, err
-		}
-		if len(result.Rows) == 0 {
+	out := make([]key.KeyspaceId, 0, len(ids))
+	for i, nums := range values {
+		switch len(nums) {
+		case 0:
 			out = append(out, "")
-			continue
-		}
-		if len(result.Rows) != 1 {
-			return nil, fmt.Errorf("unexpected multiple results from vindex %s: %v", vind.Table, id)
-		}
-		inum, err := mproto.Convert(result.Fields[0].Type, result.Rows[0][0])
-		if err != nil {
-			return nil, err
-		}
-		num, err := getNumber(inum)
-		if err != nil {
-			return nil, err
+		case 1:
+			out = append(out, vhash(nums[0]))
+		default:
+			return nil, fmt.Errorf("unexpected multiple results from vindex %s: %v", vind.Table, ids[i])
 		}
-		out = append(out, vhash(num))
 	}
 	return out, nil
 }
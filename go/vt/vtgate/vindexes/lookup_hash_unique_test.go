@@ -8,6 +8,8 @@ import (
 	"reflect"
 	"testing"
 
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
 	"github.com/youtube/vitess/go/vt/key"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
 )
@@ -43,6 +45,59 @@ func TestLookupHashUniqueMap(t *testing.T) {
 	}
 }
 
+func TestLookupHashUniqueMapList(t *testing.T) {
+	vc := &vcursor{}
+	_, err := lhu.Map(vc, []interface{}{1, int32(2)})
+	if err != nil {
+		t.Error(err)
+	}
+	want := &tproto.BoundQuery{
+		Sql: "select fromc, toc from t where fromc in ::fromc",
+		BindVariables: map[string]interface{}{
+			"fromc": []interface{}{1, int32(2)},
+		},
+	}
+	if !reflect.DeepEqual(vc.query, want) {
+		t.Errorf("vc.query = %#v, want %#v", vc.query, want)
+	}
+
+	// An id that's not in the result has no keyspace id.
+	got, err := lhu.Map(&vcursorlist{}, []interface{}{1, "2", []byte("3")})
+	if err != nil {
+		t.Error(err)
+	}
+	wantKsids := []key.KeyspaceId{
+		"\x16k@\xb4J\xbaK\xd6",
+		"",
+		"\x06\xe7\xea\"Βp\x8f",
+	}
+	if !reflect.DeepEqual(got, wantKsids) {
+		t.Errorf("Map(): %#v, want %+v", got, wantKsids)
+	}
+}
+
+// vcursorlist returns a result
+// that maps "1" to 1 and "3" to 2.
+type vcursorlist struct{}
+
+func (vc *vcursorlist) Execute(query *tproto.BoundQuery) (*mproto.QueryResult, error) {
+	return &mproto.QueryResult{
+		Fields: []mproto.Field{{
+			Type: mproto.VT_VAR_STRING,
+		}, {
+			Type: mproto.VT_LONG,
+		}},
+		Rows: [][]sqltypes.Value{{
+			sqltypes.MakeString([]byte("3")),
+			sqltypes.MakeNumeric([]byte("2")),
+		}, {
+			sqltypes.MakeString([]byte("1")),
+			sqltypes.MakeNumeric([]byte("1")),
+		}},
+		RowsAffected: 2,
+	}, nil
+}
+
 func TestLookupHashUniqueVerify(t *testing.T) {
 	vc := &vcursor{}
 	success, err := lhu.Verify(vc, 1, "\x16k@\xb4J\xbaK\xd6")
@@ -26,6 +26,10 @@ func (_ NumKSID) Cost() int {
 	return 0
 }
 
+func (_ NumKSID) AcceptsType(columnType string) bool {
+	return planbuilder.IsIntegralType(columnType)
+}
+
 func (_ NumKSID) Verify(_ planbuilder.VCursor, id interface{}, ks key.KeyspaceId) (bool, error) {
 	var keybytes [8]byte
 	num, err := getNumber(id)
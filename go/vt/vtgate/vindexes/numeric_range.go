@@ -0,0 +1,145 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+var (
+	_ planbuilder.Unique = (*NumericRange)(nil)
+	_ planbuilder.Ranged = (*NumericRange)(nil)
+)
+
+// NumericRange is a vindex that spreads the ids from Start
+// to End (exclusive) evenly across the keyspace ids, while
+// preserving their order. The ids below Start map to the first
+// keyspace id, and the ids from End onwards map to the last one.
+// Because the order is preserved, a range of ids can be routed
+// to only the shards that cover it.
+type NumericRange struct {
+	Start, End int64
+}
+
+// NewNumericRange creates a NumericRange vindex. Start and End
+// are read from the params, and Start defaults to 0.
+func NewNumericRange(m map[string]interface{}) (planbuilder.Vindex, error) {
+	start, err := getNumberParam(m, "Start")
+	if err != nil {
+		return nil, err
+	}
+	end, err := getNumberParam(m, "End")
+	if err != nil {
+		return nil, err
+	}
+	if end <= start {
+		return nil, fmt.Errorf("numeric_range: End %d must be greater than Start %d", end, start)
+	}
+	return &NumericRange{Start: start, End: end}, nil
+}
+
+func (vind *NumericRange) Cost() int {
+	return 0
+}
+
+func (vind *NumericRange) AcceptsType(columnType string) bool {
+	return planbuilder.IsIntegralType(columnType)
+}
+
+func (vind *NumericRange) Verify(_ planbuilder.VCursor, id interface{}, ks key.KeyspaceId) (bool, error) {
+	num, err := getNumber(id)
+	if err != nil {
+		return false, err
+	}
+	return vind.toKeyspaceId(num) == ks, nil
+}
+
+func (vind *NumericRange) Map(_ planbuilder.VCursor, ids []interface{}) ([]key.KeyspaceId, error) {
+	out := make([]key.KeyspaceId, 0, len(ids))
+	for _, id := range ids {
+		num, err := getNumber(id)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, vind.toKeyspaceId(num))
+	}
+	return out, nil
+}
+
+// MapRange returns the keyrange of the ids from start to end,
+// inclusive. A nil start or end leaves that side unbounded.
+func (vind *NumericRange) MapRange(_ planbuilder.VCursor, start, end interface{}) (key.KeyRange, error) {
+	var kr key.KeyRange
+	if start != nil {
+		num, err := getNumber(start)
+		if err != nil {
+			return key.KeyRange{}, err
+		}
+		if num > vind.Start {
+			kr.Start = vind.toKeyspaceId(num)
+		}
+	}
+	if end != nil {
+		num, err := getNumber(end)
+		if err != nil {
+			return key.KeyRange{}, err
+		}
+		// The End of a keyrange is exclusive.
+		if num < vind.End-1 {
+			kr.End = vind.toKeyspaceId(num + 1)
+		}
+	}
+	return kr, nil
+}
+
+// toKeyspaceId scales num from the range of ids
+// to the range of 8 byte keyspace ids.
+func (vind *NumericRange) toKeyspaceId(num int64) key.KeyspaceId {
+	var keybytes [8]byte
+	switch {
+	case num < vind.Start:
+	case num >= vind.End:
+		binary.BigEndian.PutUint64(keybytes[:], ^uint64(0))
+	default:
+		offset := new(big.Int).Sub(big.NewInt(num), big.NewInt(vind.Start))
+		size := new(big.Int).Sub(big.NewInt(vind.End), big.NewInt(vind.Start))
+		scaled := new(big.Int).Lsh(offset, 64)
+		scaled.Quo(scaled, size)
+		binary.BigEndian.PutUint64(keybytes[:], scaled.Uint64())
+	}
+	return key.KeyspaceId(keybytes[:])
+}
+
+// getNumberParam returns the integer value of the param. A missing
+// param is 0. Numbers in a JSON schema are decoded as float64.
+func getNumberParam(m map[string]interface{}, name string) (int64, error) {
+	switch v := m[name].(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return int64(v), nil
+	case string:
+		num, err := strconv.ParseInt(v, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("numeric_range: invalid %s: %v", name, err)
+		}
+		return num, nil
+	}
+	num, err := getNumber(m[name])
+	if err != nil {
+		return 0, fmt.Errorf("numeric_range: invalid %s: %v", name, err)
+	}
+	return num, nil
+}
+
+func init() {
+	planbuilder.Register("numeric_range", NewNumericRange)
+}
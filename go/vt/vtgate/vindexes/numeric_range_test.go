@@ -0,0 +1,105 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/key"
+)
+
+var numericRange *NumericRange
+
+func init() {
+	v, err := NewNumericRange(map[string]interface{}{"Start": float64(0), "End": "256"})
+	if err != nil {
+		panic(err)
+	}
+	numericRange = v.(*NumericRange)
+}
+
+func TestNumericRangeNew(t *testing.T) {
+	_, err := NewNumericRange(map[string]interface{}{"Start": float64(10), "End": float64(10)})
+	want := "numeric_range: End 10 must be greater than Start 10"
+	if err == nil || err.Error() != want {
+		t.Errorf("NewNumericRange: %v, want %s", err, want)
+	}
+	_, err = NewNumericRange(map[string]interface{}{"End": "a"})
+	want = `numeric_range: invalid End: strconv.ParseInt: parsing "a": invalid syntax`
+	if err == nil || err.Error() != want {
+		t.Errorf("NewNumericRange: %v, want %s", err, want)
+	}
+}
+
+func TestNumericRangeCost(t *testing.T) {
+	if numericRange.Cost() != 0 {
+		t.Errorf("Cost(): %d, want 0", numericRange.Cost())
+	}
+}
+
+func TestNumericRangeMap(t *testing.T) {
+	got, err := numericRange.Map(nil, []interface{}{-1, 0, 1, int64(128), uint(255), 256})
+	if err != nil {
+		t.Error(err)
+	}
+	want := []key.KeyspaceId{
+		"\x00\x00\x00\x00\x00\x00\x00\x00",
+		"\x00\x00\x00\x00\x00\x00\x00\x00",
+		"\x01\x00\x00\x00\x00\x00\x00\x00",
+		"\x80\x00\x00\x00\x00\x00\x00\x00",
+		"\xff\x00\x00\x00\x00\x00\x00\x00",
+		"\xff\xff\xff\xff\xff\xff\xff\xff",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Map(): %#v, want %+v", got, want)
+	}
+}
+
+func TestNumericRangeVerify(t *testing.T) {
+	success, err := numericRange.Verify(nil, 1, "\x01\x00\x00\x00\x00\x00\x00\x00")
+	if err != nil {
+		t.Error(err)
+	}
+	if !success {
+		t.Errorf("Verify(): %+v, want true", success)
+	}
+}
+
+func TestNumericRangeMapRange(t *testing.T) {
+	testcases := []struct {
+		start, end interface{}
+		want       key.KeyRange
+	}{{
+		start: 16,
+		end:   31,
+		want:  key.KeyRange{Start: "\x10\x00\x00\x00\x00\x00\x00\x00", End: "\x20\x00\x00\x00\x00\x00\x00\x00"},
+	}, {
+		start: 16,
+		want:  key.KeyRange{Start: "\x10\x00\x00\x00\x00\x00\x00\x00"},
+	}, {
+		end:  31,
+		want: key.KeyRange{End: "\x20\x00\x00\x00\x00\x00\x00\x00"},
+	}, {
+		start: -10,
+		end:   1000,
+		want:  key.KeyRange{},
+	}}
+	for _, tcase := range testcases {
+		got, err := numericRange.MapRange(nil, tcase.start, tcase.end)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got != tcase.want {
+			t.Errorf("MapRange(%v, %v): %#v, want %#v", tcase.start, tcase.end, got, tcase.want)
+		}
+	}
+	_, err := numericRange.MapRange(nil, "a", nil)
+	want := "unexpected type for a: string"
+	if err == nil || err.Error() != want {
+		t.Errorf("MapRange: %v, want %s", err, want)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"crypto/md5"
+	"fmt"
+	"strings"
+
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+var (
+	_ planbuilder.Unique     = (*Region)(nil)
+	_ planbuilder.CellAffine = (*Region)(nil)
+)
+
+// Region is a vindex for geo-partitioned keyspaces. It maps
+// a country to a keyspace id whose first byte is the prefix
+// of the country's region, so that each region can have its
+// own shards. The countries of a region are spread across its
+// keyrange by hashing them. The queries for a country are sent
+// to the tablets in the cell of its region.
+//
+// The regions are configured in the Regions param:
+//
+//	"Regions": {
+//	  "us": {"Prefix": 0, "Cell": "us_east", "Countries": ["US", "CA"]},
+//	  "eu": {"Prefix": 128, "Cell": "eu_west", "Countries": ["DE", "FR"]}
+//	}
+//
+// Countries are matched regardless of case.
+type Region struct {
+	countries map[string]regionInfo
+}
+
+type regionInfo struct {
+	prefix byte
+	cell   string
+}
+
+// NewRegion creates a Region vindex.
+func NewRegion(m map[string]interface{}) (planbuilder.Vindex, error) {
+	regions, ok := m["Regions"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("region: Regions must be a map of regions")
+	}
+	vind := &Region{countries: make(map[string]regionInfo)}
+	for name, v := range regions {
+		region, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("region %s: invalid definition: %v", name, v)
+		}
+		prefix, ok := region["Prefix"].(float64)
+		if !ok || prefix < 0 || prefix > 255 || prefix != float64(int(prefix)) {
+			return nil, fmt.Errorf("region %s: Prefix must be a number from 0 to 255", name)
+		}
+		cell, _ := region["Cell"].(string)
+		countries, _ := region["Countries"].([]interface{})
+		for _, c := range countries {
+			country, ok := c.(string)
+			if !ok {
+				return nil, fmt.Errorf("region %s: invalid country: %v", name, c)
+			}
+			country = strings.ToUpper(country)
+			if _, ok := vind.countries[country]; ok {
+				return nil, fmt.Errorf("region %s: country %s is in multiple regions", name, country)
+			}
+			vind.countries[country] = regionInfo{prefix: byte(prefix), cell: cell}
+		}
+	}
+	return vind, nil
+}
+
+func (vind *Region) Cost() int {
+	return 1
+}
+
+func (vind *Region) Verify(_ planbuilder.VCursor, id interface{}, ks key.KeyspaceId) (bool, error) {
+	ksid, err := vind.toKeyspaceId(id)
+	if err != nil {
+		return false, err
+	}
+	return ksid == ks, nil
+}
+
+func (vind *Region) Map(_ planbuilder.VCursor, ids []interface{}) ([]key.KeyspaceId, error) {
+	out := make([]key.KeyspaceId, 0, len(ids))
+	for _, id := range ids {
+		ksid, err := vind.toKeyspaceId(id)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ksid)
+	}
+	return out, nil
+}
+
+// Cell returns the cell of the region of id.
+func (vind *Region) Cell(_ planbuilder.VCursor, id interface{}) (string, error) {
+	_, region, err := vind.lookup(id)
+	if err != nil {
+		return "", err
+	}
+	return region.cell, nil
+}
+
+// toKeyspaceId returns the prefix of the region of id,
+// followed by the first 7 bytes of the md5 of the country.
+func (vind *Region) toKeyspaceId(id interface{}) (key.KeyspaceId, error) {
+	country, region, err := vind.lookup(id)
+	if err != nil {
+		return "", err
+	}
+	hashed := md5.Sum([]byte(country))
+	var keybytes [8]byte
+	keybytes[0] = region.prefix
+	copy(keybytes[1:], hashed[:7])
+	return key.KeyspaceId(keybytes[:]), nil
+}
+
+func (vind *Region) lookup(id interface{}) (string, regionInfo, error) {
+	s, err := getString(id)
+	if err != nil {
+		return "", regionInfo{}, err
+	}
+	country := strings.ToUpper(s)
+	region, ok := vind.countries[country]
+	if !ok {
+		return "", regionInfo{}, fmt.Errorf("region: unknown country %s", s)
+	}
+	return country, region, nil
+}
+
+func init() {
+	planbuilder.Register("region", NewRegion)
+}
@@ -0,0 +1,112 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/key"
+)
+
+var region *Region
+
+func init() {
+	v, err := NewRegion(map[string]interface{}{
+		"Regions": map[string]interface{}{
+			"us": map[string]interface{}{
+				"Prefix":    float64(0),
+				"Cell":      "us_east",
+				"Countries": []interface{}{"US", "CA"},
+			},
+			"eu": map[string]interface{}{
+				"Prefix":    float64(128),
+				"Cell":      "eu_west",
+				"Countries": []interface{}{"de", "FR"},
+			},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	region = v.(*Region)
+}
+
+func TestRegionCost(t *testing.T) {
+	if region.Cost() != 1 {
+		t.Errorf("Cost(): %d, want 1", region.Cost())
+	}
+}
+
+func TestRegionMap(t *testing.T) {
+	got, err := region.Map(nil, []interface{}{"US", []byte("ca"), "DE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []key.KeyspaceId{
+		"\x00\x75\x16\xfd\x43\xad\xaa\x5e",
+		"\x00\x3e\x8d\x11\x5e\xb4\xb3\x2b",
+		"\x80\x3a\x52\xf3\xc2\x2e\xd6\xfc",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Map(): %#v, want %#v", got, want)
+	}
+
+	_, err = region.Map(nil, []interface{}{"XX"})
+	wantErr := "region: unknown country XX"
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("Map(): %v, want %s", err, wantErr)
+	}
+}
+
+func TestRegionVerify(t *testing.T) {
+	success, err := region.Verify(nil, "us", "\x00\x75\x16\xfd\x43\xad\xaa\x5e")
+	if err != nil {
+		t.Error(err)
+	}
+	if !success {
+		t.Errorf("Verify(): %+v, want true", success)
+	}
+}
+
+func TestRegionCell(t *testing.T) {
+	cell, err := region.Cell(nil, "fr")
+	if err != nil {
+		t.Error(err)
+	}
+	if cell != "eu_west" {
+		t.Errorf("Cell(): %s, want eu_west", cell)
+	}
+}
+
+func TestRegionNew(t *testing.T) {
+	testcases := []struct {
+		params map[string]interface{}
+		err    string
+	}{{
+		params: map[string]interface{}{},
+		err:    "region: Regions must be a map of regions",
+	}, {
+		params: map[string]interface{}{
+			"Regions": map[string]interface{}{
+				"us": map[string]interface{}{"Prefix": float64(256)},
+			},
+		},
+		err: "region us: Prefix must be a number from 0 to 255",
+	}, {
+		params: map[string]interface{}{
+			"Regions": map[string]interface{}{
+				"us": map[string]interface{}{"Prefix": float64(0), "Countries": []interface{}{"US", "us"}},
+			},
+		},
+		err: "region us: country US is in multiple regions",
+	}}
+	for _, tcase := range testcases {
+		_, err := NewRegion(tcase.params)
+		if err == nil || err.Error() != tcase.err {
+			t.Errorf("NewRegion(%v): %v, want %s", tcase.params, err, tcase.err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"crypto/md5"
+	"fmt"
+	"strings"
+
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+var (
+	_ planbuilder.Unique = UnicodeLooseMD5{}
+)
+
+// UnicodeLooseMD5 is a vindex for string columns. It normalizes
+// the strings the way a case-insensitive MySQL collation compares
+// them before hashing: trailing spaces are ignored, and letters
+// are compared regardless of case. So the values that MySQL treats
+// as equal map to the same keyspace id.
+type UnicodeLooseMD5 struct{}
+
+func NewUnicodeLooseMD5(_ map[string]interface{}) (planbuilder.Vindex, error) {
+	return UnicodeLooseMD5{}, nil
+}
+
+func (_ UnicodeLooseMD5) Cost() int {
+	return 1
+}
+
+func (_ UnicodeLooseMD5) AcceptsType(columnType string) bool {
+	return planbuilder.IsTextType(columnType)
+}
+
+func (_ UnicodeLooseMD5) Verify(_ planbuilder.VCursor, id interface{}, ks key.KeyspaceId) (bool, error) {
+	s, err := getString(id)
+	if err != nil {
+		return false, err
+	}
+	return looseMD5(s) == ks, nil
+}
+
+func (_ UnicodeLooseMD5) Map(_ planbuilder.VCursor, ids []interface{}) ([]key.KeyspaceId, error) {
+	out := make([]key.KeyspaceId, 0, len(ids))
+	for _, id := range ids {
+		s, err := getString(id)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, looseMD5(s))
+	}
+	return out, nil
+}
+
+// looseMD5 returns the md5 of the normalized s.
+func looseMD5(s string) key.KeyspaceId {
+	s = strings.ToUpper(strings.TrimRight(s, " "))
+	hashed := md5.Sum([]byte(s))
+	return key.KeyspaceId(hashed[:])
+}
+
+func getString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	}
+	return "", fmt.Errorf("unexpected type for %v: %T", v, v)
+}
+
+func init() {
+	planbuilder.Register("unicode_loose_md5", NewUnicodeLooseMD5)
+}
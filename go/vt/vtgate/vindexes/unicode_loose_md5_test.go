@@ -0,0 +1,81 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/key"
+)
+
+var unicodeLooseMD5 = UnicodeLooseMD5{}
+
+func TestUnicodeLooseMD5Cost(t *testing.T) {
+	if unicodeLooseMD5.Cost() != 1 {
+		t.Errorf("Cost(): %d, want 1", unicodeLooseMD5.Cost())
+	}
+}
+
+func TestUnicodeLooseMD5Map(t *testing.T) {
+	got, err := unicodeLooseMD5.Map(nil, []interface{}{"Test", []byte("test")})
+	if err != nil {
+		t.Error(err)
+	}
+	want := key.KeyspaceId("\x03\x3b\xd9\x4b\x11\x68\xd7\xe4\xf0\xd6\x44\xc3\xc9\x5e\x35\xbf")
+	if got[0] != want || got[1] != want {
+		t.Errorf("Map(): %#v, want %#v", got, want)
+	}
+
+	testcases := []struct {
+		in1, in2 string
+		equal    bool
+	}{{
+		in1:   "abc",
+		in2:   "ABC",
+		equal: true,
+	}, {
+		in1:   "abc  ",
+		in2:   "Abc",
+		equal: true,
+	}, {
+		in1:   "ÀÉÎ",
+		in2:   "àéî",
+		equal: true,
+	}, {
+		in1:   " abc",
+		in2:   "abc",
+		equal: false,
+	}, {
+		in1:   "ab c",
+		in2:   "abc",
+		equal: false,
+	}}
+	for _, tcase := range testcases {
+		got, err := unicodeLooseMD5.Map(nil, []interface{}{tcase.in1, tcase.in2})
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if (got[0] == got[1]) != tcase.equal {
+			t.Errorf("Map(%q) == Map(%q): %v, want %v", tcase.in1, tcase.in2, !tcase.equal, tcase.equal)
+		}
+	}
+
+	_, err = unicodeLooseMD5.Map(nil, []interface{}{1})
+	wantErr := "unexpected type for 1: int"
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("Map(): %v, want %s", err, wantErr)
+	}
+}
+
+func TestUnicodeLooseMD5Verify(t *testing.T) {
+	success, err := unicodeLooseMD5.Verify(nil, "TEST ", "\x03\x3b\xd9\x4b\x11\x68\xd7\xe4\xf0\xd6\x44\xc3\xc9\x5e\x35\xbf")
+	if err != nil {
+		t.Error(err)
+	}
+	if !success {
+		t.Errorf("Verify(): %+v, want true", success)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/timer"
+	"github.com/youtube/vitess/go/vt/concurrency"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// VSchemaSource returns the vschemas of the keyspaces.
+// It's satisfied by topo.Server.
+type VSchemaSource interface {
+	GetKeyspaces() ([]string, error)
+	GetVSchema(keyspace string) (string, error)
+}
+
+// VSchemaWatcher reloads the schema of a Router from the vschemas
+// of the keyspaces in the topology when they change. The keyspaces
+// without a vschema are not part of the schema. Each keyspace is
+// built on its own: a vschema that can't be built is ignored, and
+// its keyspace keeps its previous schema, while the changes to the
+// other keyspaces are applied.
+type VSchemaWatcher struct {
+	source VSchemaSource
+	router *Router
+	ticks  *timer.Timer
+
+	mu sync.Mutex
+	// vschemas are the vschemas that were last read.
+	vschemas map[string]string
+	// loaded is true once the schema was loaded.
+	loaded bool
+}
+
+// NewVSchemaWatcher creates a VSchemaWatcher that checks
+// the vschemas of source every interval.
+func NewVSchemaWatcher(source VSchemaSource, interval time.Duration, router *Router) *VSchemaWatcher {
+	return &VSchemaWatcher{
+		source: source,
+		router: router,
+		ticks:  timer.NewTimer(interval),
+	}
+}
+
+// Open starts checking the vschemas in the background.
+func (vw *VSchemaWatcher) Open() {
+	vw.ticks.Start(func() {
+		if err := vw.Check(); err != nil {
+			log.Errorf("Could not reload the schema from the topology: %v", err)
+		}
+	})
+}
+
+// Close stops checking the vschemas.
+func (vw *VSchemaWatcher) Close() {
+	vw.ticks.Stop()
+}
+
+// Check reads the vschemas of all the keyspaces, and replaces
+// the schema of the router if any of them changed since they
+// were last read. The errors of the keyspaces whose vschema
+// was rejected are returned together.
+func (vw *VSchemaWatcher) Check() error {
+	vw.mu.Lock()
+	defer vw.mu.Unlock()
+	keyspaces, err := vw.source.GetKeyspaces()
+	if err != nil {
+		return err
+	}
+	vschemas := make(map[string]string, len(keyspaces))
+	for _, keyspace := range keyspaces {
+		vschema, err := vw.source.GetVSchema(keyspace)
+		if err == topo.ErrNoNode {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		vschemas[keyspace] = vschema
+	}
+	// A vschema is not built again until it changes,
+	// even if it's invalid.
+	rec := concurrency.AllErrorRecorder{}
+	changed := make(map[string]*planbuilder.KeyspaceSchema)
+	for keyspace, vschema := range vschemas {
+		if previous, ok := vw.vschemas[keyspace]; ok && previous == vschema {
+			continue
+		}
+		ks, err := planbuilder.ParseKeyspaceSchema(keyspace, vschema)
+		if err != nil {
+			rec.RecordError(fmt.Errorf("keyspace %s: %v", keyspace, err))
+			continue
+		}
+		changed[keyspace] = ks
+	}
+	for keyspace := range vw.vschemas {
+		if _, ok := vschemas[keyspace]; !ok {
+			changed[keyspace] = nil
+		}
+	}
+	vw.vschemas = vschemas
+	version := vw.router.planner.SchemaVersion()
+	vw.router.keyspaces.update(changed, false, !vw.loaded, &rec)
+	vw.loaded = true
+	if newVersion := vw.router.planner.SchemaVersion(); newVersion != version {
+		log.Infof("Reloaded the schema from the topology, version %d", newVersion)
+	}
+	return rec.Error()
+}
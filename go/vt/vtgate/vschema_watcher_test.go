@@ -0,0 +1,109 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+// fakeVSchemaSource is a VSchemaSource with the vschemas of a map.
+type fakeVSchemaSource struct {
+	keyspaces []string
+	vschemas  map[string]string
+}
+
+func (fvs *fakeVSchemaSource) GetKeyspaces() ([]string, error) {
+	return fvs.keyspaces, nil
+}
+
+func (fvs *fakeVSchemaSource) GetVSchema(keyspace string) (string, error) {
+	vschema, ok := fvs.vschemas[keyspace]
+	if !ok {
+		return "", topo.ErrNoNode
+	}
+	return vschema, nil
+}
+
+func TestVSchemaWatcher(t *testing.T) {
+	source := &fakeVSchemaSource{
+		keyspaces: []string{"main", "user", "other"},
+		vschemas: map[string]string{
+			"main": `{"Tables": {"main1": {}}}`,
+		},
+	}
+	router := NewRouter(new(sandboxTopo), "aa", nil, "", nil)
+	vw := NewVSchemaWatcher(source, 1*time.Hour, router)
+
+	if err := vw.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if version := router.planner.SchemaVersion(); version != 1 {
+		t.Errorf("SchemaVersion: %d, want 1", version)
+	}
+	if table, _ := router.planner.Schema().FindTable("main1"); table == nil {
+		t.Errorf("main1 not found in the schema loaded from the topology")
+	}
+
+	// The vschemas didn't change.
+	if err := vw.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if version := router.planner.SchemaVersion(); version != 1 {
+		t.Errorf("SchemaVersion: %d, want 1", version)
+	}
+
+	// An invalid vschema is ignored, and reported once.
+	source.vschemas["user"] = `{"Sharded": true, "Vindexes": {"user_index": {"Type": "no_such_type"}}}`
+	if err := vw.Check(); err == nil || !strings.Contains(err.Error(), "no_such_type") {
+		t.Errorf("Check with an invalid vschema: %v, want no_such_type error", err)
+	}
+	if err := vw.Check(); err != nil {
+		t.Errorf("Check with the same invalid vschema: %v, want nil", err)
+	}
+	if version := router.planner.SchemaVersion(); version != 1 {
+		t.Errorf("SchemaVersion: %d, want 1", version)
+	}
+
+	source.vschemas["user"] = `{"Sharded": true, "Vindexes": {"user_index": {"Type": "hash", "Owner": "user"}}, "Tables": {"user": {"ColVindexes": [{"Col": "id", "Name": "user_index"}]}}}`
+	if err := vw.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if version := router.planner.SchemaVersion(); version != 2 {
+		t.Errorf("SchemaVersion: %d, want 2", version)
+	}
+	if table, _ := router.planner.Schema().FindTable("user"); table == nil || table.Keyspace.Name != "user" {
+		t.Errorf("FindTable(user): %v, want a table of keyspace user", table)
+	}
+
+	// A keyspace with an invalid vschema keeps its previous
+	// schema, and the other keyspaces are still reloaded.
+	source.vschemas["user"] = `{"Sharded": true, "Vindexes": {"user_index": {"Type": "no_such_type"}}}`
+	source.vschemas["main"] = `{"Tables": {"main1": {}, "main2": {}}}`
+	if err := vw.Check(); err == nil || !strings.Contains(err.Error(), "keyspace user") {
+		t.Errorf("Check with an invalid vschema: %v, want keyspace user error", err)
+	}
+	if version := router.planner.SchemaVersion(); version != 3 {
+		t.Errorf("SchemaVersion: %d, want 3", version)
+	}
+	if table, _ := router.planner.Schema().FindTable("main2"); table == nil {
+		t.Errorf("main2 not found in the reloaded schema")
+	}
+	if table, _ := router.planner.Schema().FindTable("user"); table == nil {
+		t.Errorf("user not found in the reloaded schema")
+	}
+
+	// The keyspaces whose vschema was deleted are removed.
+	delete(source.vschemas, "user")
+	if err := vw.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if table, _ := router.planner.Schema().FindTable("user"); table != nil {
+		t.Errorf("FindTable(user): %v, want nil", table)
+	}
+}
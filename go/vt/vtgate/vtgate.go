@@ -23,7 +23,6 @@ import (
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
 	"github.com/youtube/vitess/go/vt/vtgate/proto"
-	_ "github.com/youtube/vitess/go/vt/vtgate/vindexes"
 	"golang.org/x/net/context"
 )
 
@@ -51,10 +50,21 @@ var (
 // VTGate is the rpc interface to vtgate. Only one instance
 // can be created.
 type VTGate struct {
-	resolver     *Resolver
-	router       *Router
-	timings      *stats.MultiTimings
-	rowsReturned *stats.MultiCounters
+	resolver        *Resolver
+	router          *Router
+	cursors         *cursorRegistry
+	txResolver      *TxResolver
+	asyncDML        *AsyncDMLQueue
+	schemaWatch     *SchemaWatcher
+	vschemaWatch    *VSchemaWatcher
+	unshardedWatch  *UnshardedWatcher
+	tabletTypeWatch *TabletTypeRuleWatcher
+	schemaTracker   *SchemaTracker
+	timings         *stats.MultiTimings
+	rowsReturned    *stats.MultiCounters
+
+	// audit is the audit log of the DMLs and DDLs, nil if it's off.
+	audit *auditLog
 
 	maxInFlight int64
 	inFlight    sync2.AtomicInt64
@@ -66,6 +76,7 @@ type VTGate struct {
 	logExecuteEntityIds         *logutil.ThrottledLogger
 	logExecuteBatchShard        *logutil.ThrottledLogger
 	logExecuteBatchKeyspaceIds  *logutil.ThrottledLogger
+	logStreamExecute            *logutil.ThrottledLogger
 	logStreamExecuteKeyspaceIds *logutil.ThrottledLogger
 	logStreamExecuteKeyRanges   *logutil.ThrottledLogger
 	logStreamExecuteShard       *logutil.ThrottledLogger
@@ -94,12 +105,35 @@ func Init(serv SrvTopoServer, schema *planbuilder.Schema, cell string, retryDela
 		logExecuteEntityIds:         logutil.NewThrottledLogger("ExecuteEntityIds", 5*time.Second),
 		logExecuteBatchShard:        logutil.NewThrottledLogger("ExecuteBatchShard", 5*time.Second),
 		logExecuteBatchKeyspaceIds:  logutil.NewThrottledLogger("ExecuteBatchKeyspaceIds", 5*time.Second),
+		logStreamExecute:            logutil.NewThrottledLogger("StreamExecute", 5*time.Second),
 		logStreamExecuteKeyspaceIds: logutil.NewThrottledLogger("StreamExecuteKeyspaceIds", 5*time.Second),
 		logStreamExecuteKeyRanges:   logutil.NewThrottledLogger("StreamExecuteKeyRanges", 5*time.Second),
 		logStreamExecuteShard:       logutil.NewThrottledLogger("StreamExecuteShard", 5*time.Second),
 	}
 	// Resuse resolver's scatterConn.
 	RpcVTGate.router = NewRouter(serv, cell, schema, "VTGateRouter", RpcVTGate.resolver.scatterConn)
+	RpcVTGate.cursors = newCursorRegistry(RpcVTGate.router, *cursorIdleTimeout, *cursorFetchRows)
+	if *twopcResolveInterval > 0 {
+		RpcVTGate.txResolver = NewTxResolver(RpcVTGate.resolver.scatterConn, *twopcResolveInterval, *twopcAbandonAge)
+		RpcVTGate.txResolver.Open()
+	} else {
+		log.Warningf("twopc_resolve_interval is 0: the twopc transactions that fail to complete are not resolved by this vtgate, another vtgate must resolve them")
+	}
+	if *asyncDMLDir != "" {
+		router := RpcVTGate.router
+		asyncDML, err := NewAsyncDMLQueue(*asyncDMLDir, "VtgateAsyncDMLQueue", *asyncDMLMaxQueue, *asyncDMLOverflow, *asyncDMLRetryDelay, *asyncDMLMaxAttempts, *asyncDMLTimeout, func(ctx context.Context, query *proto.Query) error {
+			_, err := router.Execute(ctx, query)
+			return err
+		})
+		if err != nil {
+			log.Fatalf("Cannot create the async DML queue: %v", err)
+		}
+		RpcVTGate.asyncDML = asyncDML
+		RpcVTGate.asyncDML.Open()
+	}
+	if *deadlockCheckInterval > 0 {
+		RpcVTGate.resolver.scatterConn.deadlocks.Open(*deadlockCheckInterval, *deadlockTimeout)
+	}
 	normalErrors = stats.NewMultiCounters("VtgateApiErrorCounts", []string{"Operation", "Keyspace", "DbType"})
 	infoErrors = stats.NewCounters("VtgateInfoErrorCounts")
 	internalErrors = stats.NewCounters("VtgateInternalErrorCounts")
@@ -112,6 +146,10 @@ func Init(serv SrvTopoServer, schema *planbuilder.Schema, cell string, retryDela
 	ErrorsByKeyspace = stats.NewRates("ErrorsByKeyspace", stats.CounterForDimension(normalErrors, "Keyspace"), 15, 1*time.Minute)
 	ErrorsByDbType = stats.NewRates("ErrorsByDbType", stats.CounterForDimension(normalErrors, "DbType"), 15, 1*time.Minute)
 
+	initQueryLog()
+	initSlowQueryLog()
+	RpcVTGate.audit = initAuditLog()
+
 	for _, f := range RegisterVTGates {
 		f(RpcVTGate)
 	}
@@ -137,6 +175,9 @@ func (vtg *VTGate) InitializeConnections(ctx context.Context) (err error) {
 func (vtg *VTGate) Execute(ctx context.Context, query *proto.Query, reply *proto.QueryResult) (err error) {
 	defer handlePanic(&err)
 
+	ctx, span := startServerSpan(ctx, "VTGate.Execute")
+	defer span.Finish()
+
 	startTime := time.Now()
 	statsKey := []string{"Execute", "Any", string(query.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -147,10 +188,16 @@ func (vtg *VTGate) Execute(ctx context.Context, query *proto.Query, reply *proto
 		return ErrTooManyInFlight
 	}
 
-	qr, err := vtg.router.Execute(ctx, query)
+	ctx, audit := vtg.audit.start(ctx, "Execute", "", query.TabletType, query.Sql)
+	qr, shardErrors, err := vtg.router.ExecutePartial(ctx, query)
+	audit.finishResult(qr, err)
 	if err == nil {
 		reply.Result = qr
 		vtg.rowsReturned.Add(statsKey, int64(len(qr.Rows)))
+		for _, shardErr := range shardErrors {
+			reply.ShardErrors = append(reply.ShardErrors, shardErr.Error())
+			normalErrors.Add(statsKey, 1)
+		}
 	} else {
 		reply.Error = err.Error()
 		if strings.Contains(reply.Error, errDupKey) {
@@ -168,6 +215,9 @@ func (vtg *VTGate) Execute(ctx context.Context, query *proto.Query, reply *proto
 func (vtg *VTGate) ExecuteShard(ctx context.Context, query *proto.QueryShard, reply *proto.QueryResult) (err error) {
 	defer handlePanic(&err)
 
+	ctx, span := startServerSpan(ctx, "VTGate.ExecuteShard")
+	defer span.Finish()
+
 	startTime := time.Now()
 	statsKey := []string{"ExecuteShard", query.Keyspace, string(query.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -178,6 +228,7 @@ func (vtg *VTGate) ExecuteShard(ctx context.Context, query *proto.QueryShard, re
 		return ErrTooManyInFlight
 	}
 
+	ctx, audit := vtg.audit.start(ctx, "ExecuteShard", query.Keyspace, query.TabletType, query.Sql)
 	qr, err := vtg.resolver.Execute(
 		ctx,
 		query.Sql,
@@ -189,6 +240,7 @@ func (vtg *VTGate) ExecuteShard(ctx context.Context, query *proto.QueryShard, re
 			return query.Keyspace, query.Shards, nil
 		},
 	)
+	audit.finishResult(qr, err)
 	if err == nil {
 		reply.Result = qr
 		vtg.rowsReturned.Add(statsKey, int64(len(qr.Rows)))
@@ -209,6 +261,9 @@ func (vtg *VTGate) ExecuteShard(ctx context.Context, query *proto.QueryShard, re
 func (vtg *VTGate) ExecuteKeyspaceIds(ctx context.Context, query *proto.KeyspaceIdQuery, reply *proto.QueryResult) (err error) {
 	defer handlePanic(&err)
 
+	ctx, span := startServerSpan(ctx, "VTGate.ExecuteKeyspaceIds")
+	defer span.Finish()
+
 	startTime := time.Now()
 	statsKey := []string{"ExecuteKeyspaceIds", query.Keyspace, string(query.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -219,7 +274,9 @@ func (vtg *VTGate) ExecuteKeyspaceIds(ctx context.Context, query *proto.Keyspace
 		return ErrTooManyInFlight
 	}
 
+	ctx, audit := vtg.audit.start(ctx, "ExecuteKeyspaceIds", query.Keyspace, query.TabletType, query.Sql)
 	qr, err := vtg.resolver.ExecuteKeyspaceIds(ctx, query)
+	audit.finishResult(qr, err)
 	if err == nil {
 		reply.Result = qr
 		vtg.rowsReturned.Add(statsKey, int64(len(qr.Rows)))
@@ -240,6 +297,9 @@ func (vtg *VTGate) ExecuteKeyspaceIds(ctx context.Context, query *proto.Keyspace
 func (vtg *VTGate) ExecuteKeyRanges(ctx context.Context, query *proto.KeyRangeQuery, reply *proto.QueryResult) (err error) {
 	defer handlePanic(&err)
 
+	ctx, span := startServerSpan(ctx, "VTGate.ExecuteKeyRanges")
+	defer span.Finish()
+
 	startTime := time.Now()
 	statsKey := []string{"ExecuteKeyRanges", query.Keyspace, string(query.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -250,7 +310,9 @@ func (vtg *VTGate) ExecuteKeyRanges(ctx context.Context, query *proto.KeyRangeQu
 		return ErrTooManyInFlight
 	}
 
+	ctx, audit := vtg.audit.start(ctx, "ExecuteKeyRanges", query.Keyspace, query.TabletType, query.Sql)
 	qr, err := vtg.resolver.ExecuteKeyRanges(ctx, query)
+	audit.finishResult(qr, err)
 	if err == nil {
 		reply.Result = qr
 		vtg.rowsReturned.Add(statsKey, int64(len(qr.Rows)))
@@ -271,6 +333,9 @@ func (vtg *VTGate) ExecuteKeyRanges(ctx context.Context, query *proto.KeyRangeQu
 func (vtg *VTGate) ExecuteEntityIds(ctx context.Context, query *proto.EntityIdsQuery, reply *proto.QueryResult) (err error) {
 	defer handlePanic(&err)
 
+	ctx, span := startServerSpan(ctx, "VTGate.ExecuteEntityIds")
+	defer span.Finish()
+
 	startTime := time.Now()
 	statsKey := []string{"ExecuteEntityIds", query.Keyspace, string(query.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -281,7 +346,9 @@ func (vtg *VTGate) ExecuteEntityIds(ctx context.Context, query *proto.EntityIdsQ
 		return ErrTooManyInFlight
 	}
 
+	ctx, audit := vtg.audit.start(ctx, "ExecuteEntityIds", query.Keyspace, query.TabletType, query.Sql)
 	qr, err := vtg.resolver.ExecuteEntityIds(ctx, query)
+	audit.finishResult(qr, err)
 	if err == nil {
 		reply.Result = qr
 		vtg.rowsReturned.Add(statsKey, int64(len(qr.Rows)))
@@ -302,6 +369,9 @@ func (vtg *VTGate) ExecuteEntityIds(ctx context.Context, query *proto.EntityIdsQ
 func (vtg *VTGate) ExecuteBatchShard(ctx context.Context, batchQuery *proto.BatchQueryShard, reply *proto.QueryResultList) (err error) {
 	defer handlePanic(&err)
 
+	ctx, span := startServerSpan(ctx, "VTGate.ExecuteBatchShard")
+	defer span.Finish()
+
 	startTime := time.Now()
 	statsKey := []string{"ExecuteBatchShard", batchQuery.Keyspace, string(batchQuery.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -312,6 +382,7 @@ func (vtg *VTGate) ExecuteBatchShard(ctx context.Context, batchQuery *proto.Batc
 		return ErrTooManyInFlight
 	}
 
+	ctx, audit := vtg.audit.start(ctx, "ExecuteBatchShard", batchQuery.Keyspace, batchQuery.TabletType, boundQuerySqls(batchQuery.Queries)...)
 	qrs, err := vtg.resolver.ExecuteBatch(
 		ctx,
 		batchQuery.Queries,
@@ -322,6 +393,7 @@ func (vtg *VTGate) ExecuteBatchShard(ctx context.Context, batchQuery *proto.Batc
 			return batchQuery.Keyspace, batchQuery.Shards, nil
 		},
 	)
+	audit.finishList(qrs, err)
 	if err == nil {
 		reply.List = qrs.List
 		var rowCount int64
@@ -346,6 +418,9 @@ func (vtg *VTGate) ExecuteBatchShard(ctx context.Context, batchQuery *proto.Batc
 func (vtg *VTGate) ExecuteBatchKeyspaceIds(ctx context.Context, query *proto.KeyspaceIdBatchQuery, reply *proto.QueryResultList) (err error) {
 	defer handlePanic(&err)
 
+	ctx, span := startServerSpan(ctx, "VTGate.ExecuteBatchKeyspaceIds")
+	defer span.Finish()
+
 	startTime := time.Now()
 	statsKey := []string{"ExecuteBatchKeyspaceIds", query.Keyspace, string(query.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -356,9 +431,11 @@ func (vtg *VTGate) ExecuteBatchKeyspaceIds(ctx context.Context, query *proto.Key
 		return ErrTooManyInFlight
 	}
 
+	ctx, audit := vtg.audit.start(ctx, "ExecuteBatchKeyspaceIds", query.Keyspace, query.TabletType, boundQuerySqls(query.Queries)...)
 	qrs, err := vtg.resolver.ExecuteBatchKeyspaceIds(
 		ctx,
 		query)
+	audit.finishList(qrs, err)
 	if err == nil {
 		reply.List = qrs.List
 		var rowCount int64
@@ -379,6 +456,133 @@ func (vtg *VTGate) ExecuteBatchKeyspaceIds(ctx context.Context, query *proto.Key
 	return nil
 }
 
+// StreamExecute executes a streaming query by routing based on the values in the query.
+func (vtg *VTGate) StreamExecute(ctx context.Context, query *proto.Query, sendReply func(*proto.QueryResult) error) (err error) {
+	defer handlePanic(&err)
+
+	ctx, span := startServerSpan(ctx, "VTGate.StreamExecute")
+	defer span.Finish()
+
+	startTime := time.Now()
+	statsKey := []string{"StreamExecute", "Any", string(query.TabletType)}
+	defer vtg.timings.Record(statsKey, startTime)
+
+	x := vtg.inFlight.Add(1)
+	defer vtg.inFlight.Add(-1)
+	if 0 < vtg.maxInFlight && vtg.maxInFlight < x {
+		return ErrTooManyInFlight
+	}
+
+	var rowCount int64
+	err = vtg.router.StreamExecute(
+		ctx,
+		query,
+		func(mreply *mproto.QueryResult) error {
+			reply := new(proto.QueryResult)
+			reply.Result = mreply
+			rowCount += int64(len(mreply.Rows))
+			// Note we don't populate reply.Session here,
+			// as it may change incrementaly as responses are sent.
+			return sendReply(reply)
+		})
+	vtg.rowsReturned.Add(statsKey, rowCount)
+
+	if err != nil {
+		normalErrors.Add(statsKey, 1)
+		vtg.logStreamExecute.Errorf("%v, query: %+v", err, query)
+	}
+	// Now we can send the final Sessoin info.
+	if query.Session != nil {
+		sendReply(&proto.QueryResult{Session: query.Session})
+	}
+	return err
+}
+
+// BeginStream starts a streaming query, and returns the first page
+// of its results. If there are more results, the id of the cursor
+// for fetching them with FetchNext is returned in the session.
+// Cursors can't be used in a transaction.
+func (vtg *VTGate) BeginStream(ctx context.Context, query *proto.Query, reply *proto.QueryResult) (err error) {
+	defer handlePanic(&err)
+
+	_, span := startServerSpan(ctx, "VTGate.BeginStream")
+	defer span.Finish()
+
+	startTime := time.Now()
+	statsKey := []string{"BeginStream", "Any", string(query.TabletType)}
+	defer vtg.timings.Record(statsKey, startTime)
+
+	x := vtg.inFlight.Add(1)
+	defer vtg.inFlight.Add(-1)
+	if 0 < vtg.maxInFlight && vtg.maxInFlight < x {
+		return ErrTooManyInFlight
+	}
+
+	if query.Session == nil {
+		query.Session = new(proto.Session)
+	}
+	reply.Session = query.Session
+	switch {
+	case query.Session.InTransaction:
+		reply.Error = "cannot begin a stream in a transaction"
+		return nil
+	case query.Session.CursorId != 0:
+		reply.Error = fmt.Sprintf("session already has cursor %d", query.Session.CursorId)
+		return nil
+	}
+	streamQuery := *query
+	streamQuery.Session = nil
+	id := vtg.cursors.begin(&streamQuery)
+	vtg.fetchNext(id, query.Session, statsKey, reply)
+	return nil
+}
+
+// FetchNext returns the next page of results of the cursor
+// of the session. The cursor is closed once all its results
+// have been returned, and CursorId is reset.
+func (vtg *VTGate) FetchNext(ctx context.Context, session *proto.Session, reply *proto.QueryResult) (err error) {
+	defer handlePanic(&err)
+
+	_, span := startServerSpan(ctx, "VTGate.FetchNext")
+	defer span.Finish()
+
+	startTime := time.Now()
+	statsKey := []string{"FetchNext", "Any", ""}
+	defer vtg.timings.Record(statsKey, startTime)
+
+	reply.Session = session
+	vtg.fetchNext(session.CursorId, session, statsKey, reply)
+	return nil
+}
+
+func (vtg *VTGate) fetchNext(id int64, session *proto.Session, statsKey []string, reply *proto.QueryResult) {
+	qr, done, err := vtg.cursors.fetch(id)
+	if done {
+		session.CursorId = 0
+	} else {
+		session.CursorId = id
+	}
+	if err != nil {
+		reply.Error = err.Error()
+		normalErrors.Add(statsKey, 1)
+		vtg.logStreamExecute.Errorf("%v, cursor: %d", err, id)
+		return
+	}
+	reply.Result = qr
+	vtg.rowsReturned.Add(statsKey, int64(len(qr.Rows)))
+}
+
+// FinishStream closes the cursor of the session
+// before all its results have been fetched.
+func (vtg *VTGate) FinishStream(ctx context.Context, session *proto.Session) (err error) {
+	defer handlePanic(&err)
+	_, span := startServerSpan(ctx, "VTGate.FinishStream")
+	defer span.Finish()
+	vtg.cursors.finish(session.CursorId)
+	session.CursorId = 0
+	return nil
+}
+
 // StreamExecuteKeyspaceIds executes a streaming query on the specified KeyspaceIds.
 // The KeyspaceIds are resolved to shards using the serving graph.
 // This function currently temporarily enforces the restriction of executing on
@@ -388,6 +592,9 @@ func (vtg *VTGate) ExecuteBatchKeyspaceIds(ctx context.Context, query *proto.Key
 func (vtg *VTGate) StreamExecuteKeyspaceIds(ctx context.Context, query *proto.KeyspaceIdQuery, sendReply func(*proto.QueryResult) error) (err error) {
 	defer handlePanic(&err)
 
+	ctx, span := startServerSpan(ctx, "VTGate.StreamExecuteKeyspaceIds")
+	defer span.Finish()
+
 	startTime := time.Now()
 	statsKey := []string{"StreamExecuteKeyspaceIds", query.Keyspace, string(query.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -432,6 +639,9 @@ func (vtg *VTGate) StreamExecuteKeyspaceIds(ctx context.Context, query *proto.Ke
 func (vtg *VTGate) StreamExecuteKeyRanges(ctx context.Context, query *proto.KeyRangeQuery, sendReply func(*proto.QueryResult) error) (err error) {
 	defer handlePanic(&err)
 
+	ctx, span := startServerSpan(ctx, "VTGate.StreamExecuteKeyRanges")
+	defer span.Finish()
+
 	startTime := time.Now()
 	statsKey := []string{"StreamExecuteKeyRanges", query.Keyspace, string(query.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -471,6 +681,9 @@ func (vtg *VTGate) StreamExecuteKeyRanges(ctx context.Context, query *proto.KeyR
 func (vtg *VTGate) StreamExecuteShard(ctx context.Context, query *proto.QueryShard, sendReply func(*proto.QueryResult) error) (err error) {
 	defer handlePanic(&err)
 
+	ctx, span := startServerSpan(ctx, "VTGate.StreamExecuteShard")
+	defer span.Finish()
+
 	startTime := time.Now()
 	statsKey := []string{"StreamExecuteShard", query.Keyspace, string(query.TabletType)}
 	defer vtg.timings.Record(statsKey, startTime)
@@ -516,6 +729,8 @@ func (vtg *VTGate) StreamExecuteShard(ctx context.Context, query *proto.QuerySha
 // Begin begins a transaction. It has to be concluded by a Commit or Rollback.
 func (vtg *VTGate) Begin(ctx context.Context, outSession *proto.Session) (err error) {
 	defer handlePanic(&err)
+	_, span := startServerSpan(ctx, "VTGate.Begin")
+	defer span.Finish()
 	outSession.InTransaction = true
 	return nil
 }
@@ -523,15 +738,62 @@ func (vtg *VTGate) Begin(ctx context.Context, outSession *proto.Session) (err er
 // Commit commits a transaction.
 func (vtg *VTGate) Commit(ctx context.Context, inSession *proto.Session) (err error) {
 	defer handlePanic(&err)
+	ctx, span := startServerSpan(ctx, "VTGate.Commit")
+	defer span.Finish()
 	return vtg.resolver.Commit(ctx, inSession)
 }
 
 // Rollback rolls back a transaction.
 func (vtg *VTGate) Rollback(ctx context.Context, inSession *proto.Session) (err error) {
 	defer handlePanic(&err)
+	ctx, span := startServerSpan(ctx, "VTGate.Rollback")
+	defer span.Finish()
 	return vtg.resolver.Rollback(ctx, inSession)
 }
 
+// Prepare prepares the transaction of the session on all its shards,
+// for an external transaction manager. The session must be in the
+// xa transaction mode. Its transaction is concluded, and the prepared
+// shards must be passed to CommitPrepared or RollbackPrepared.
+func (vtg *VTGate) Prepare(ctx context.Context, req *proto.PrepareRequest, reply *proto.PrepareResult) (err error) {
+	defer handlePanic(&err)
+	ctx, span := startServerSpan(ctx, "VTGate.Prepare")
+	defer span.Finish()
+	participants, err := vtg.resolver.Prepare(ctx, req.Session, req.Dtid)
+	if err == nil {
+		reply.Participants = participants
+	} else {
+		reply.Error = err.Error()
+	}
+	reply.Session = req.Session
+	return nil
+}
+
+// CommitPrepared commits a transaction prepared by Prepare.
+func (vtg *VTGate) CommitPrepared(ctx context.Context, req *proto.ResolvePreparedRequest) (err error) {
+	defer handlePanic(&err)
+	ctx, span := startServerSpan(ctx, "VTGate.CommitPrepared")
+	defer span.Finish()
+	return vtg.resolver.CommitPrepared(ctx, req.Dtid, req.Participants)
+}
+
+// RollbackPrepared rolls back a transaction prepared by Prepare.
+func (vtg *VTGate) RollbackPrepared(ctx context.Context, req *proto.ResolvePreparedRequest) (err error) {
+	defer handlePanic(&err)
+	ctx, span := startServerSpan(ctx, "VTGate.RollbackPrepared")
+	defer span.Finish()
+	return vtg.resolver.RollbackPrepared(ctx, req.Dtid, req.Participants)
+}
+
+// Release releases the connections reserved for the session,
+// which drops its session state. It can't be called in a transaction.
+func (vtg *VTGate) Release(ctx context.Context, session *proto.Session) (err error) {
+	defer handlePanic(&err)
+	ctx, span := startServerSpan(ctx, "VTGate.Release")
+	defer span.Finish()
+	return vtg.resolver.Release(ctx, session)
+}
+
 // SplitQuery splits a query into sub queries by appending keyranges and
 // primary key range clauses. Rows corresponding to the sub queries
 // are guaranteed to be non-overlapping and will add up to the rows of
@@ -558,6 +820,279 @@ func (vtg *VTGate) SplitQuery(ctx context.Context, req *proto.SplitQueryRequest,
 	return nil
 }
 
+// MapKeyspaceId returns the shard that a keyspace id belongs to,
+// and the values of the vindexes that can be reverse mapped from it.
+// It can be used to debug the routing of queries, or by tools that
+// need to follow the sharding of a keyspace.
+func (vtg *VTGate) MapKeyspaceId(ctx context.Context, req *proto.MapKeyspaceIdRequest, reply *proto.MapKeyspaceIdResult) (err error) {
+	defer handlePanic(&err)
+
+	ctx, span := startServerSpan(ctx, "VTGate.MapKeyspaceId")
+	defer span.Finish()
+
+	startTime := time.Now()
+	statsKey := []string{"MapKeyspaceId", req.Keyspace, string(req.TabletType)}
+	defer vtg.timings.Record(statsKey, startTime)
+
+	shard, values, err := vtg.router.MapKeyspaceId(ctx, req.Keyspace, req.KeyspaceId, req.TabletType)
+	if err != nil {
+		normalErrors.Add(statsKey, 1)
+		return err
+	}
+	reply.Shard = shard
+	reply.VindexValues = values
+	return nil
+}
+
+// DescribeKeyspaces returns the keyspaces served by vtgate, with
+// their sharding scheme and their shards, as seen in the serving
+// graph of its cell. It only returns req.Keyspace if it's set.
+func (vtg *VTGate) DescribeKeyspaces(ctx context.Context, req *proto.DescribeKeyspacesRequest, reply *proto.DescribeKeyspacesResult) (err error) {
+	defer handlePanic(&err)
+
+	startTime := time.Now()
+	statsKey := []string{"DescribeKeyspaces", req.Keyspace, ""}
+	defer vtg.timings.Record(statsKey, startTime)
+
+	keyspaces, err := vtg.router.DescribeKeyspaces(ctx, req.Keyspace)
+	if err != nil {
+		normalErrors.Add(statsKey, 1)
+		return err
+	}
+	reply.Keyspaces = keyspaces
+	return nil
+}
+
+// ValidateVSchema reports the problems that the proposed vschema
+// of a keyspace would cause if it was applied, like vindex columns
+// that don't exist in the database. The problems are returned in
+// the reply, and an empty list means the vschema can be applied.
+func (vtg *VTGate) ValidateVSchema(ctx context.Context, req *proto.ValidateVSchemaRequest, reply *proto.ValidateVSchemaResult) (err error) {
+	defer handlePanic(&err)
+
+	startTime := time.Now()
+	statsKey := []string{"ValidateVSchema", req.Keyspace, ""}
+	defer vtg.timings.Record(statsKey, startTime)
+
+	problems, err := vtg.router.ValidateVSchema(ctx, req.Keyspace, req.VSchema)
+	if err != nil {
+		reply.Error = err.Error()
+		normalErrors.Add(statsKey, 1)
+		return nil
+	}
+	reply.Problems = problems
+	return nil
+}
+
+// BulkInsert inserts pre-structured rows into a table, without
+// parsing SQL. It's meant for loaders that insert many rows, for
+// which parsing an insert statement per row would be the bottleneck.
+func (vtg *VTGate) BulkInsert(ctx context.Context, req *proto.BulkInsertRequest, reply *proto.QueryResult) (err error) {
+	defer handlePanic(&err)
+
+	ctx, span := startServerSpan(ctx, "VTGate.BulkInsert")
+	defer span.Finish()
+
+	startTime := time.Now()
+	statsKey := []string{"BulkInsert", req.Table, string(req.TabletType)}
+	defer vtg.timings.Record(statsKey, startTime)
+
+	x := vtg.inFlight.Add(1)
+	defer vtg.inFlight.Add(-1)
+	if 0 < vtg.maxInFlight && vtg.maxInFlight < x {
+		return ErrTooManyInFlight
+	}
+
+	qr, err := vtg.router.BulkInsert(ctx, req)
+	if err == nil {
+		reply.Result = qr
+	} else {
+		reply.Error = err.Error()
+		if strings.Contains(reply.Error, errDupKey) {
+			infoErrors.Add("DupKey", 1)
+		} else {
+			normalErrors.Add(statsKey, 1)
+			vtg.logExecuteShard.Errorf("%v, table: %v, rows: %d", err, req.Table, len(req.Rows))
+		}
+	}
+	reply.Session = req.Session
+	return nil
+}
+
+// WatchSchemaFile reloads the V3 schema from filename when the file
+// is modified, checking it every interval. The cached plans are
+// invalidated when the schema is reloaded.
+func (vtg *VTGate) WatchSchemaFile(filename string, interval time.Duration) {
+	if vtg.schemaWatch != nil {
+		vtg.schemaWatch.Close()
+	}
+	vtg.schemaWatch = NewSchemaWatcher(filename, interval, vtg.router)
+	vtg.schemaWatch.Open()
+}
+
+// WatchTopoSchema loads the V3 schema from the vschemas of the
+// keyspaces of source, and reloads it when they change, checking
+// them every interval. The cached plans are invalidated when the
+// schema is reloaded.
+func (vtg *VTGate) WatchTopoSchema(source VSchemaSource, interval time.Duration) {
+	if vtg.vschemaWatch != nil {
+		vtg.vschemaWatch.Close()
+	}
+	vtg.vschemaWatch = NewVSchemaWatcher(source, interval, vtg.router)
+	if err := vtg.vschemaWatch.Check(); err != nil {
+		log.Errorf("Could not load the schema from the topology: %v", err)
+	}
+	vtg.vschemaWatch.Open()
+}
+
+// WatchTabletTypeRules loads the tablet type rules of the V3 API
+// from the keyspaces of source, and reloads them every interval.
+func (vtg *VTGate) WatchTabletTypeRules(source TabletTypeRuleSource, interval time.Duration) {
+	if vtg.tabletTypeWatch != nil {
+		vtg.tabletTypeWatch.Close()
+	}
+	vtg.tabletTypeWatch = NewTabletTypeRuleWatcher(source, interval, vtg.router)
+	if err := vtg.tabletTypeWatch.Check(); err != nil {
+		log.Errorf("Could not load the tablet type rules from the topology: %v", err)
+	}
+	vtg.tabletTypeWatch.Open()
+}
+
+// DiscoverUnshardedTables routes the tables of the unsharded
+// keyspaces that have no V3 schema of their own, as read from
+// their master, and discovers them again every interval. Only
+// the sharded keyspaces then need a schema.
+func (vtg *VTGate) DiscoverUnshardedTables(interval time.Duration) {
+	if vtg.unshardedWatch != nil {
+		vtg.unshardedWatch.Close()
+	}
+	vtg.unshardedWatch = NewUnshardedWatcher(interval, vtg.router)
+	if err := vtg.unshardedWatch.Check(); err != nil {
+		log.Errorf("Could not discover the tables of the unsharded keyspaces: %v", err)
+	}
+	vtg.unshardedWatch.Open()
+}
+
+// TrackSchema learns the columns of the tables of the V3 keyspaces
+// from their master, and learns them again every interval. The
+// planner then knows the columns of the tables.
+func (vtg *VTGate) TrackSchema(interval time.Duration) {
+	if vtg.schemaTracker != nil {
+		vtg.schemaTracker.Close()
+	}
+	vtg.schemaTracker = NewSchemaTracker(interval, vtg.router)
+	if err := vtg.schemaTracker.Check(); err != nil {
+		log.Errorf("Could not learn the columns of the tables: %v", err)
+	}
+	vtg.schemaTracker.Open()
+}
+
+// PrepareQuery plans a query once, and returns the id of the
+// prepared statement for ExecutePrepared, with its bind vars.
+func (vtg *VTGate) PrepareQuery(ctx context.Context, req *proto.PrepareQueryRequest, reply *proto.PrepareQueryResult) (err error) {
+	defer handlePanic(&err)
+
+	_, span := startServerSpan(ctx, "VTGate.PrepareQuery")
+	defer span.Finish()
+
+	startTime := time.Now()
+	statsKey := []string{"PrepareQuery", "Any", ""}
+	defer vtg.timings.Record(statsKey, startTime)
+
+	id, params, err := vtg.router.PrepareQuery(req.Sql)
+	if err != nil {
+		reply.Error = err.Error()
+		normalErrors.Add(statsKey, 1)
+		return nil
+	}
+	reply.StatementId = id
+	reply.Params = params
+	return nil
+}
+
+// ExecutePrepared executes a statement prepared by PrepareQuery,
+// without parsing or planning its query again.
+func (vtg *VTGate) ExecutePrepared(ctx context.Context, req *proto.ExecutePreparedRequest, reply *proto.QueryResult) (err error) {
+	defer handlePanic(&err)
+
+	ctx, span := startServerSpan(ctx, "VTGate.ExecutePrepared")
+	defer span.Finish()
+
+	startTime := time.Now()
+	statsKey := []string{"ExecutePrepared", "Any", string(req.TabletType)}
+	defer vtg.timings.Record(statsKey, startTime)
+
+	x := vtg.inFlight.Add(1)
+	defer vtg.inFlight.Add(-1)
+	if 0 < vtg.maxInFlight && vtg.maxInFlight < x {
+		return ErrTooManyInFlight
+	}
+
+	ctx, audit := vtg.audit.start(ctx, "ExecutePrepared", "", req.TabletType, vtg.router.preparedSql(req.StatementId))
+	qr, err := vtg.router.ExecutePrepared(ctx, req)
+	audit.finishResult(qr, err)
+	if err == nil {
+		reply.Result = qr
+		vtg.rowsReturned.Add(statsKey, int64(len(qr.Rows)))
+	} else {
+		reply.Error = err.Error()
+		if strings.Contains(reply.Error, errDupKey) {
+			infoErrors.Add("DupKey", 1)
+		} else {
+			normalErrors.Add(statsKey, 1)
+			vtg.logExecuteShard.Errorf("%v, prepared statement: %+v", err, req)
+		}
+	}
+	reply.Session = req.Session
+	return nil
+}
+
+// ClosePrepared releases a statement prepared by PrepareQuery.
+func (vtg *VTGate) ClosePrepared(ctx context.Context, req *proto.ClosePreparedRequest) (err error) {
+	defer handlePanic(&err)
+	_, span := startServerSpan(ctx, "VTGate.ClosePrepared")
+	defer span.Finish()
+	vtg.router.ClosePrepared(req.StatementId)
+	return nil
+}
+
+// KillQuery kills the query id in flight, as listed by SHOW
+// PROCESSLIST: it fails, and its calls to the shards are canceled.
+func (vtg *VTGate) KillQuery(ctx context.Context, id int64) (err error) {
+	defer handlePanic(&err)
+	_, span := startServerSpan(ctx, "VTGate.KillQuery")
+	defer span.Finish()
+	return vtg.router.KillQuery(id)
+}
+
+// ExecuteAsync accepts a DML that is applied in the background,
+// for writes of a low priority like audit counters. It returns as
+// soon as the DML is recorded by vtgate, with an empty result. The
+// DML is applied outside of any transaction, and its errors are
+// not returned to the client. It's only available if vtgate has
+// an async_dml_dir.
+func (vtg *VTGate) ExecuteAsync(ctx context.Context, query *proto.Query, reply *proto.QueryResult) (err error) {
+	defer handlePanic(&err)
+
+	_, span := startServerSpan(ctx, "VTGate.ExecuteAsync")
+	defer span.Finish()
+
+	startTime := time.Now()
+	statsKey := []string{"ExecuteAsync", "Any", string(query.TabletType)}
+	defer vtg.timings.Record(statsKey, startTime)
+
+	if vtg.asyncDML == nil {
+		reply.Error = "async DML is not enabled"
+	} else if err := vtg.asyncDML.Enqueue(query); err != nil {
+		reply.Error = err.Error()
+		normalErrors.Add(statsKey, 1)
+	} else {
+		reply.Result = &mproto.QueryResult{}
+	}
+	reply.Session = query.Session
+	return nil
+}
+
 func handlePanic(err *error) {
 	if x := recover(); x != nil {
 		log.Errorf("Uncaught panic:\n%v\n%s", x, tb.Stack(4))
@@ -14,6 +14,7 @@ import (
 	kproto "github.com/youtube/vitess/go/vt/key"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
 	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
 	"github.com/youtube/vitess/go/vt/vtgate/proto"
 	"golang.org/x/net/context"
 )
@@ -21,6 +22,10 @@ import (
 // This file uses the sandbox_test framework.
 
 func init() {
+	// The sandbox counts the calls it gets, so
+	// don't resolve transactions in the background.
+	*twopcResolveInterval = 0
+	*deadlockCheckInterval = 0
 	Init(new(sandboxTopo), nil, "aa", 1*time.Second, 10, 1*time.Millisecond, 0)
 }
 
@@ -645,3 +650,92 @@ func TestVTGateSplitQuery(t *testing.T) {
 		t.Errorf("splits contain the wrong sqls and/or keyranges, got: %v, want: %v", actualSqlsByKeyRange, expectedSqlsByKeyRange)
 	}
 }
+
+func TestVTGateCursor(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	for _, shard := range []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"} {
+		s.MapTestConn(shard, &sandboxConn{})
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	saved := RpcVTGate.cursors
+	defer func() { RpcVTGate.cursors = saved }()
+	RpcVTGate.cursors = newCursorRegistry(router, 1*time.Minute, 3)
+
+	// Every shard returns one row.
+	q := proto.Query{
+		Sql:        "select * from user",
+		TabletType: topo.TYPE_MASTER,
+	}
+	reply := new(proto.QueryResult)
+	if err := RpcVTGate.BeginStream(context.Background(), &q, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Error != "" {
+		t.Fatal(reply.Error)
+	}
+	if reply.Result.Fields == nil {
+		t.Errorf("reply.Result.Fields is nil")
+	}
+	rows := len(reply.Result.Rows)
+	session := reply.Session
+	for session.CursorId != 0 {
+		reply = new(proto.QueryResult)
+		if err := RpcVTGate.FetchNext(context.Background(), session, reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Error != "" {
+			t.Fatal(reply.Error)
+		}
+		rows += len(reply.Result.Rows)
+	}
+	if rows != 8 {
+		t.Errorf("rows: %d, want 8", rows)
+	}
+
+	// A finished cursor can't be fetched from.
+	q.Session = nil
+	reply = new(proto.QueryResult)
+	RpcVTGate.BeginStream(context.Background(), &q, reply)
+	id := reply.Session.CursorId
+	if id == 0 {
+		t.Fatalf("CursorId is 0, want an open cursor")
+	}
+	RpcVTGate.FinishStream(context.Background(), reply.Session)
+	if reply.Session.CursorId != 0 {
+		t.Errorf("CursorId: %d, want 0", reply.Session.CursorId)
+	}
+	reply = new(proto.QueryResult)
+	RpcVTGate.FetchNext(context.Background(), &proto.Session{CursorId: id}, reply)
+	want := fmt.Sprintf("cursor %d not found", id)
+	if reply.Error != want {
+		t.Errorf("FetchNext: %s, want %s", reply.Error, want)
+	}
+
+	// Idle cursors are closed.
+	RpcVTGate.cursors.idleTimeout = 1 * time.Millisecond
+	q.Session = nil
+	reply = new(proto.QueryResult)
+	RpcVTGate.BeginStream(context.Background(), &q, reply)
+	id = reply.Session.CursorId
+	time.Sleep(10 * time.Millisecond)
+	reply = new(proto.QueryResult)
+	RpcVTGate.FetchNext(context.Background(), &proto.Session{CursorId: id}, reply)
+	want = fmt.Sprintf("cursor %d not found", id)
+	if reply.Error != want {
+		t.Errorf("FetchNext: %s, want %s", reply.Error, want)
+	}
+
+	q.Session = &proto.Session{InTransaction: true}
+	reply = new(proto.QueryResult)
+	RpcVTGate.BeginStream(context.Background(), &q, reply)
+	want = "cannot begin a stream in a transaction"
+	if reply.Error != want {
+		t.Errorf("BeginStream: %s, want %s", reply.Error, want)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"fmt"
+	"sync"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// The warnings of a statement are the conditions that didn't fail
+// it, but may have degraded its result. They're collected through
+// the context of the statement, so that its vindex lookups add to
+// them, and replace the warnings of the session once it's done.
+// SHOW WARNINGS returns them, and keeps them.
+
+// maxWarnings is the maximum number of warnings kept for a
+// statement, like the default max_error_count of MySQL.
+const maxWarnings = 64
+
+const warningsKey contextKey = 2
+
+// warningCounts counts the warnings, by code.
+var warningCounts = stats.NewCounters("VtgateWarnings")
+
+var warningsFields = []mproto.Field{
+	{Name: "Level", Type: mproto.VT_VAR_STRING},
+	{Name: "Code", Type: mproto.VT_VAR_STRING},
+	{Name: "Message", Type: mproto.VT_VAR_STRING},
+}
+
+// warningList collects the warnings of a statement.
+// It's safe for concurrent use.
+type warningList struct {
+	mu       sync.Mutex
+	warnings []*proto.Warning
+	// keep is set by SHOW WARNINGS, which
+	// leaves the warnings of the session.
+	keep bool
+}
+
+// withWarnings returns a context that collects the warnings of the
+// statement executed with it. The list is nil if ctx already
+// collects them, for the statement that the query is a part of.
+func withWarnings(ctx context.Context) (context.Context, *warningList) {
+	if _, ok := ctx.Value(warningsKey).(*warningList); ok {
+		return ctx, nil
+	}
+	warnings := new(warningList)
+	return context.WithValue(ctx, warningsKey, warnings), warnings
+}
+
+// addWarning adds a warning to the statement of ctx, if it
+// collects them and doesn't have the same warning already.
+func addWarning(ctx context.Context, code, format string, args ...interface{}) {
+	warnings, ok := ctx.Value(warningsKey).(*warningList)
+	if !ok {
+		return
+	}
+	warning := &proto.Warning{Code: code, Message: fmt.Sprintf(format, args...)}
+	warnings.mu.Lock()
+	defer warnings.mu.Unlock()
+	for _, w := range warnings.warnings {
+		if *w == *warning {
+			return
+		}
+	}
+	warningCounts.Add(code, 1)
+	if len(warnings.warnings) < maxWarnings {
+		warnings.warnings = append(warnings.warnings, warning)
+	}
+}
+
+// save replaces the warnings of the session of query with
+// the warnings of its statement. It does nothing if wl is nil.
+func (wl *warningList) save(query *proto.Query) {
+	if wl == nil || query.Session == nil {
+		return
+	}
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	if !wl.keep {
+		query.Session.Warnings = wl.warnings
+	}
+}
+
+// warnScatter adds a warning to the statement of vcursor
+// if plan sends it to all the shards of its keyspace.
+func warnScatter(vcursor *requestContext, plan *planbuilder.Plan) {
+	switch plan.ID {
+	case planbuilder.SelectScatter, planbuilder.UpdateScatter, planbuilder.DeleteScatter:
+		addWarning(vcursor.ctx, proto.WarningScatter, "the query was sent to all the shards of keyspace %s", plan.Table.Keyspace.Name)
+	}
+}
+
+// execShowWarnings returns the warnings of the last
+// statement of the session, and keeps them.
+func (rtr *Router) execShowWarnings(vcursor *requestContext) (*mproto.QueryResult, error) {
+	if warnings, ok := vcursor.ctx.Value(warningsKey).(*warningList); ok {
+		warnings.mu.Lock()
+		warnings.keep = true
+		warnings.mu.Unlock()
+	}
+	result := &mproto.QueryResult{Fields: warningsFields}
+	if vcursor.query.Session != nil {
+		for _, warning := range vcursor.query.Session.Warnings {
+			result.Rows = append(result.Rows, []sqltypes.Value{
+				sqltypes.MakeString([]byte("Warning")),
+				sqltypes.MakeString([]byte(warning.Code)),
+				sqltypes.MakeString([]byte(warning.Message)),
+			})
+		}
+	}
+	result.RowsAffected = uint64(len(result.Rows))
+	return result, nil
+}
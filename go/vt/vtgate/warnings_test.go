@@ -0,0 +1,107 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestRouterWarnings(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	var conns []*sandboxConn
+	for _, shard := range shards {
+		sbc := &sandboxConn{}
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+	ctx := context.Background()
+
+	conns[0].mustFailServer = 1
+	q := &proto.Query{
+		Sql:        "select * from user",
+		TabletType: topo.TYPE_MASTER,
+		Session:    &proto.Session{AllowPartialResults: true},
+	}
+	if _, _, err := router.ExecutePartial(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+	warnings := q.Session.Warnings
+	if len(warnings) != 2 {
+		t.Fatalf("Warnings: %v, want 2", warnings)
+	}
+	wantScatter := &proto.Warning{Code: proto.WarningScatter, Message: "the query was sent to all the shards of keyspace TestRouter"}
+	if !reflect.DeepEqual(warnings[0], wantScatter) {
+		t.Errorf("Warnings[0]: %v, want %v", warnings[0], wantScatter)
+	}
+	if warnings[1].Code != proto.WarningPartialResult || !strings.Contains(warnings[1].Message, "error: err") {
+		t.Errorf("Warnings[1]: %v, want a partial result", warnings[1])
+	}
+
+	// SHOW WARNINGS keeps the warnings.
+	for i := 0; i < 2; i++ {
+		q.Sql = "show warnings"
+		result, _, err := router.ExecutePartial(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantRow := []sqltypes.Value{
+			sqltypes.MakeString([]byte("Warning")),
+			sqltypes.MakeString([]byte(proto.WarningScatter)),
+			sqltypes.MakeString([]byte(wantScatter.Message)),
+		}
+		if len(result.Rows) != 2 || !reflect.DeepEqual(result.Rows[0], wantRow) {
+			t.Errorf("show warnings: %v, want 2 rows starting with %v", result.Rows, wantRow)
+		}
+		if !reflect.DeepEqual(q.Session.Warnings, warnings) {
+			t.Errorf("Warnings: %v, want %v", q.Session.Warnings, warnings)
+		}
+	}
+
+	// The next statement replaces them.
+	q.Sql = "select * from user where id = 1"
+	if _, _, err := router.ExecutePartial(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Session.Warnings) != 0 {
+		t.Errorf("Warnings: %v, want none", q.Session.Warnings)
+	}
+}
+
+func TestAddWarning(t *testing.T) {
+	// Without a list, the warnings are dropped.
+	addWarning(context.Background(), proto.WarningScatter, "dropped")
+
+	ctx, warnings := withWarnings(context.Background())
+	if nested, list := withWarnings(ctx); nested != ctx || list != nil {
+		t.Errorf("withWarnings of a statement: %v, want the same context and no list", list)
+	}
+	for i := 0; i < maxWarnings+2; i++ {
+		addWarning(ctx, proto.WarningPartialResult, "shard %d failed", i)
+		addWarning(ctx, proto.WarningPartialResult, "shard %d failed", i)
+	}
+	if len(warnings.warnings) != maxWarnings {
+		t.Errorf("warnings: %d, want %d", len(warnings.warnings), maxWarnings)
+	}
+	if got, want := warnings.warnings[1].Message, "shard 1 failed"; got != want {
+		t.Errorf("warnings[1]: %q, want %q", got, want)
+	}
+}
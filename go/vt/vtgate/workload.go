@@ -0,0 +1,104 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// The App, Workload and Team of a session tag its traffic. The
+// queries of a tagged session carry the tags in their context down
+// to the shards, where they're appended to the queries as a comment,
+// so that they show in the logs and the process list of the tablets.
+
+const workloadKey contextKey = 3
+
+// workloadQueries counts the queries sent to the shards
+// for the tagged sessions, by tags.
+var workloadQueries = stats.NewMultiCounters("VtgateWorkloadQueries", []string{"App", "Workload", "Team"})
+
+// workloadTags are the tags of the traffic of a session.
+type workloadTags struct {
+	app, workload, team string
+}
+
+// comment returns the comment appended to the queries
+// sent to the shards with the tags.
+func (tags *workloadTags) comment() string {
+	return fmt.Sprintf(" /* app:%s workload:%s team:%s */", tags.app, tags.workload, tags.team)
+}
+
+// validTag returns true if tag can be used in a query
+// comment and as a stats dimension.
+func validTag(tag string) bool {
+	for _, c := range tag {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// sessionWorkload returns the tags of session, or nil if it's
+// not tagged. It fails if the tags are not valid.
+func sessionWorkload(session *SafeSession) (*workloadTags, error) {
+	if session == nil || session.Session == nil {
+		return nil, nil
+	}
+	tags := &workloadTags{
+		app:      session.App,
+		workload: strings.ToLower(session.Workload),
+		team:     session.Team,
+	}
+	if tags.app == "" && tags.workload == "" && tags.team == "" {
+		return nil, nil
+	}
+	switch tags.workload {
+	case "":
+		tags.workload = proto.WorkloadOLTP
+	case proto.WorkloadOLTP, proto.WorkloadOLAP:
+	default:
+		return nil, fmt.Errorf("invalid workload %q, want %q or %q", session.Workload, proto.WorkloadOLTP, proto.WorkloadOLAP)
+	}
+	if !validTag(tags.app) || !validTag(tags.team) {
+		return nil, fmt.Errorf("invalid app %q or team %q: only letters, digits, '_', '-' and '.' are allowed", tags.app, tags.team)
+	}
+	return tags, nil
+}
+
+// withWorkload returns the context of the shard queries of session,
+// which carries its tags. The queries of the WorkloadOLAP workload
+// are also given the batch priority. ctx is returned as is if the
+// session is not tagged, or if its tags are not valid: multiGo
+// fails the queries of such sessions.
+func withWorkload(ctx context.Context, session *SafeSession) context.Context {
+	tags, err := sessionWorkload(session)
+	if tags == nil || err != nil {
+		return ctx
+	}
+	if tags.workload == proto.WorkloadOLAP {
+		ctx = context.WithValue(ctx, priorityKey, classBatch)
+	}
+	return context.WithValue(ctx, workloadKey, tags)
+}
+
+// addWorkloadComment appends the comment of the tags of
+// ctx to query, and counts it. query is returned as is if
+// ctx has no tags.
+func addWorkloadComment(ctx context.Context, query string) string {
+	tags, ok := ctx.Value(workloadKey).(*workloadTags)
+	if !ok {
+		return query
+	}
+	workloadQueries.Add([]string{tags.app, tags.workload, tags.team}, 1)
+	return query + tags.comment()
+}
@@ -0,0 +1,91 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestRouterWorkload(t *testing.T) {
+	schema, err := planbuilder.LoadSchemaJSON(locateFile("router_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createSandbox("TestRouter")
+	sbc := &sandboxConn{}
+	s.MapTestConn("-20", sbc)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Millisecond, 10, 1*time.Millisecond)
+	router := NewRouter(serv, "aa", schema, "", scatterConn)
+
+	key := "billing.olap.payments"
+	count := workloadQueries.Counts()[key]
+	q := &proto.Query{
+		Sql:        "select * from user where id = 1",
+		TabletType: topo.TYPE_MASTER,
+		Session:    &proto.Session{App: "billing", Workload: "OLAP", Team: "payments"},
+	}
+	if _, err := router.Execute(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	want := "select * from user where id = 1 /* app:billing workload:olap team:payments */"
+	if len(sbc.Queries) != 1 || sbc.Queries[0] != want {
+		t.Errorf("queries: %v, want %v", sbc.Queries, want)
+	}
+	if got := workloadQueries.Counts()[key] - count; got != 1 {
+		t.Errorf("VtgateWorkloadQueries[%s]: %d more, want 1", key, got)
+	}
+
+	// The queries of the untagged sessions are sent as is.
+	sbc.Queries = nil
+	q.Session = &proto.Session{}
+	if _, err := router.Execute(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	if want := "select * from user where id = 1"; len(sbc.Queries) != 1 || sbc.Queries[0] != want {
+		t.Errorf("queries: %v, want %v", sbc.Queries, want)
+	}
+}
+
+func TestWithWorkload(t *testing.T) {
+	ctx := context.Background()
+	if got := withWorkload(ctx, NewSafeSession(&proto.Session{})); got != ctx {
+		t.Errorf("withWorkload of an untagged session: %v, want the same context", got)
+	}
+
+	got := withWorkload(ctx, NewSafeSession(&proto.Session{App: "a"}))
+	if tags := got.Value(workloadKey).(*workloadTags); tags.workload != proto.WorkloadOLTP {
+		t.Errorf("workload: %q, want %q", tags.workload, proto.WorkloadOLTP)
+	}
+	if priorityClass(got) != classOLTP {
+		t.Errorf("priority class of oltp: %d, want %d", priorityClass(got), classOLTP)
+	}
+	got = withWorkload(ctx, NewSafeSession(&proto.Session{Workload: proto.WorkloadOLAP}))
+	if priorityClass(got) != classBatch {
+		t.Errorf("priority class of olap: %d, want %d", priorityClass(got), classBatch)
+	}
+}
+
+func TestSessionWorkloadErrors(t *testing.T) {
+	for _, tcase := range []struct {
+		session *proto.Session
+		want    string
+	}{
+		{&proto.Session{Workload: "batch"}, `invalid workload "batch"`},
+		{&proto.Session{App: "a */ drop table t; /*"}, `invalid app "a */ drop table t; /*"`},
+		{&proto.Session{Team: "a b"}, `invalid app "" or team "a b"`},
+	} {
+		if _, err := sessionWorkload(NewSafeSession(tcase.session)); err == nil || !strings.HasPrefix(err.Error(), tcase.want) {
+			t.Errorf("sessionWorkload(%v): %v, want %s", tcase.session, err, tcase.want)
+		}
+	}
+}
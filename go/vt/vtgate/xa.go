@@ -0,0 +1,107 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/concurrency"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// In the xa transaction mode, vtgate doesn't commit the transactions
+// that span multiple shards: an external transaction manager drives
+// their two-phase commit through Prepare, CommitPrepared and
+// RollbackPrepared. The distributed transaction is not recorded on
+// a coordinator shard, so the TxResolver leaves it alone, and the
+// transaction manager is responsible for its recovery.
+
+// isXA returns true if session must be committed
+// by an external transaction manager.
+func isXA(session *SafeSession) bool {
+	return session.TransactionMode == proto.TxModeXA && len(session.ShardSessions) > 1
+}
+
+// Prepare prepares the transaction of session on all its shards
+// as the distributed transaction dtid, and concludes the session's
+// transaction. It returns the prepared shards. If a shard fails to
+// prepare, the transaction is rolled back on all shards.
+func (stc *ScatterConn) Prepare(context context.Context, session *SafeSession, dtid string) ([]*proto.ShardSession, error) {
+	if !session.InTransaction() {
+		return nil, fmt.Errorf("cannot prepare: not in transaction")
+	}
+	if session.TransactionMode != proto.TxModeXA {
+		return nil, fmt.Errorf("cannot prepare: transaction_mode is not xa")
+	}
+	if dtid == "" {
+		return nil, fmt.Errorf("cannot prepare: empty dtid")
+	}
+	if err := stc.txReaper.checkAborted(session); err != nil {
+		session.Reset()
+		return nil, err
+	}
+	// A prepared transaction must survive until the
+	// transaction manager concludes it.
+	stc.txReaper.untrack(session)
+	stc.deadlocks.forget(session)
+	participants := append([]*proto.ShardSession(nil), session.ShardSessions...)
+	sort.Sort(byShard(participants))
+	session.Reset()
+	for i, shardSession := range participants {
+		sdc := stc.getConnection(context, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		if err := sdc.Prepare(context, shardSession.TransactionId, dtid); err != nil {
+			stc.abortPrepare(context, dtid, participants, i)
+			return nil, err
+		}
+	}
+	return participants, nil
+}
+
+// abortPrepare rolls back the transaction of participants, of
+// which the first prepared ones are prepared as dtid.
+func (stc *ScatterConn) abortPrepare(context context.Context, dtid string, participants []*proto.ShardSession, prepared int) {
+	for i, shardSession := range participants {
+		sdc := stc.getConnection(context, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		var err error
+		if i < prepared {
+			err = sdc.RollbackPrepared(context, dtid, shardSession.TransactionId)
+		} else {
+			err = sdc.Rollback(context, shardSession.TransactionId)
+		}
+		if err != nil {
+			log.Warningf("Could not roll back transaction %s on %s/%s: %v", dtid, shardSession.Keyspace, shardSession.Shard, err)
+		}
+	}
+}
+
+// CommitPrepared commits the prepared transaction dtid on
+// participants. It can be called again if it fails: the
+// participants that are already committed are left as is.
+func (stc *ScatterConn) CommitPrepared(context context.Context, dtid string, participants []*proto.ShardSession) error {
+	var errRecorder concurrency.AllErrorRecorder
+	for _, shardSession := range participants {
+		sdc := stc.getConnection(context, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		if err := sdc.CommitPrepared(context, dtid); err != nil {
+			errRecorder.RecordError(fmt.Errorf("%s/%s: %v", shardSession.Keyspace, shardSession.Shard, err))
+		}
+	}
+	return errRecorder.Error()
+}
+
+// RollbackPrepared rolls back the prepared transaction dtid
+// on participants. Like CommitPrepared, it can be called again.
+func (stc *ScatterConn) RollbackPrepared(context context.Context, dtid string, participants []*proto.ShardSession) error {
+	var errRecorder concurrency.AllErrorRecorder
+	for _, shardSession := range participants {
+		sdc := stc.getConnection(context, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		if err := sdc.RollbackPrepared(context, dtid, shardSession.TransactionId); err != nil {
+			errRecorder.RecordError(fmt.Errorf("%s/%s: %v", shardSession.Keyspace, shardSession.Shard, err))
+		}
+	}
+	return errRecorder.Error()
+}
@@ -0,0 +1,89 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// This file uses the sandbox_test framework.
+
+func TestXAPrepareCommit(t *testing.T) {
+	stc, session, sbc0, sbc1 := twoPCSetup(t, "TestXAPrepareCommit")
+	session.TransactionMode = proto.TxModeXA
+	if err := stc.Commit(context.Background(), session); err == nil {
+		t.Errorf("Commit: nil, want error")
+	}
+	participants, err := stc.Prepare(context.Background(), session, "tm:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(participants) != 2 || participants[0].Shard != "0" || participants[1].Shard != "1" {
+		t.Errorf("participants: %v, want shards 0 and 1", participants)
+	}
+	if session.InTransaction() {
+		t.Errorf("session is still in a transaction")
+	}
+	if err := stc.CommitPrepared(context.Background(), "tm:1", participants); err != nil {
+		t.Fatal(err)
+	}
+	for _, sbc := range []*sandboxConn{sbc0, sbc1} {
+		checkCounts(t, "sbc", twoPCCounts(sbc), map[string]int64{
+			"Prepare":        1,
+			"CommitPrepared": 1,
+		})
+	}
+}
+
+func TestXAPrepareFail(t *testing.T) {
+	stc, session, sbc0, sbc1 := twoPCSetup(t, "TestXAPrepareFail")
+	session.TransactionMode = proto.TxModeXA
+	sbc1.mustFailServer = 1
+	if _, err := stc.Prepare(context.Background(), session, "tm:1"); err == nil {
+		t.Fatalf("Prepare: nil, want error")
+	}
+	checkCounts(t, "sbc0", twoPCCounts(sbc0), map[string]int64{
+		"Prepare":          1,
+		"RollbackPrepared": 1,
+	})
+	checkCounts(t, "sbc1", twoPCCounts(sbc1), map[string]int64{
+		"Prepare":  1,
+		"Rollback": 1,
+	})
+}
+
+func TestXARollbackPrepared(t *testing.T) {
+	stc, session, sbc0, sbc1 := twoPCSetup(t, "TestXARollbackPrepared")
+	session.TransactionMode = proto.TxModeXA
+	participants, err := stc.Prepare(context.Background(), session, "tm:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sbc0.mustFailServer = 1
+	if err := stc.RollbackPrepared(context.Background(), "tm:1", participants); err == nil {
+		t.Errorf("RollbackPrepared: nil, want error")
+	}
+	// It can be retried.
+	if err := stc.RollbackPrepared(context.Background(), "tm:1", participants); err != nil {
+		t.Error(err)
+	}
+	checkCounts(t, "sbc1", twoPCCounts(sbc1), map[string]int64{
+		"Prepare":          1,
+		"RollbackPrepared": 2,
+	})
+}
+
+func TestXAPrepareNotXA(t *testing.T) {
+	stc, session, _, _ := twoPCSetup(t, "TestXAPrepareNotXA")
+	if _, err := stc.Prepare(context.Background(), session, "tm:1"); err == nil {
+		t.Errorf("Prepare: nil, want error")
+	}
+	if !session.InTransaction() {
+		t.Errorf("session is not in a transaction")
+	}
+}
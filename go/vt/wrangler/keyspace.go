@@ -675,6 +675,39 @@ func (wr *Wrangler) setKeyspaceServedFrom(keyspace string, servedType topo.Table
 	return topo.UpdateKeyspace(wr.ts, ki)
 }
 
+// SetKeyspaceTabletTypeRule locks a keyspace and changes the tablet
+// type vtgate routes the queries of tabletType to. An empty
+// targetType removes the rule.
+func (wr *Wrangler) SetKeyspaceTabletTypeRule(keyspace string, tabletType, targetType topo.TabletType) error {
+	actionNode := actionnode.SetKeyspaceTabletTypeRule()
+	lockPath, err := wr.lockKeyspace(keyspace, actionNode)
+	if err != nil {
+		return err
+	}
+
+	err = wr.setKeyspaceTabletTypeRule(keyspace, tabletType, targetType)
+	return wr.unlockKeyspace(keyspace, actionNode, lockPath, err)
+}
+
+func (wr *Wrangler) setKeyspaceTabletTypeRule(keyspace string, tabletType, targetType topo.TabletType) error {
+	if tabletType == topo.TYPE_MASTER || targetType == topo.TYPE_MASTER {
+		return fmt.Errorf("Cannot set a tablet type rule to or from the master in keyspace %v", keyspace)
+	}
+	ki, err := wr.ts.GetKeyspace(keyspace)
+	if err != nil {
+		return err
+	}
+	if targetType == "" || targetType == tabletType {
+		delete(ki.TabletTypeRules, tabletType)
+	} else {
+		if ki.TabletTypeRules == nil {
+			ki.TabletTypeRules = make(map[topo.TabletType]topo.TabletType)
+		}
+		ki.TabletTypeRules[tabletType] = targetType
+	}
+	return topo.UpdateKeyspace(wr.ts, ki)
+}
+
 // RefreshTablesByShard calls RefreshState on all the tables of a
 // given type in a shard. It would work for the master, but the
 // discovery wouldn't be very efficient.
@@ -110,6 +110,27 @@ func (zkts *Server) GetKeyspaces() ([]string, error) {
 	return children, nil
 }
 
+func (zkts *Server) SaveVSchema(keyspace, vschema string) error {
+	vschemaPath := path.Join(globalKeyspacesPath, keyspace, "vschema")
+	_, err := zkts.zconn.Set(vschemaPath, vschema, -1)
+	if zookeeper.IsError(err, zookeeper.ZNONODE) {
+		_, err = zk.CreateRecursive(zkts.zconn, vschemaPath, vschema, 0, zookeeper.WorldACL(zookeeper.PERM_ALL))
+	}
+	return err
+}
+
+func (zkts *Server) GetVSchema(keyspace string) (string, error) {
+	vschemaPath := path.Join(globalKeyspacesPath, keyspace, "vschema")
+	data, _, err := zkts.zconn.Get(vschemaPath)
+	if err != nil {
+		if zookeeper.IsError(err, zookeeper.ZNONODE) {
+			err = topo.ErrNoNode
+		}
+		return "", err
+	}
+	return data, nil
+}
+
 func (zkts *Server) DeleteKeyspaceShards(keyspace string) error {
 	shardsPath := path.Join(globalKeyspacesPath, keyspace, "shards")
 	if err := zk.DeleteRecursive(zkts.zconn, shardsPath, -1); err != nil && !zookeeper.IsError(err, zookeeper.ZNONODE) {
@@ -10,6 +10,7 @@ import (
 	"path"
 	"sort"
 
+	log "github.com/golang/glog"
 	"github.com/youtube/vitess/go/jscfg"
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/zk"
@@ -167,6 +168,57 @@ func (zkts *Server) GetSrvKeyspace(cell, keyspace string) (*topo.SrvKeyspace, er
 	return srvKeyspace, nil
 }
 
+// WatchSrvKeyspace is part of the topo.SrvKeyspaceWatcher interface.
+// It sets a zookeeper watch on the SrvKeyspace node, or on its
+// path if it doesn't exist, and sets it again after every event.
+func (zkts *Server) WatchSrvKeyspace(cell, keyspace string) (<-chan *topo.SrvKeyspace, chan<- struct{}, error) {
+	filePath := zkPathForVtKeyspace(cell, keyspace)
+	notifications := make(chan *topo.SrvKeyspace, 10)
+	stopWatching := make(chan struct{})
+	go func() {
+		defer close(notifications)
+		for {
+			var srvKeyspace *topo.SrvKeyspace
+			data, stat, watch, err := zkts.zconn.GetW(filePath)
+			if err != nil {
+				if !zookeeper.IsError(err, zookeeper.ZNONODE) {
+					log.Warningf("WatchSrvKeyspace: GetW(%v) failed: %v", filePath, err)
+					return
+				}
+				// Wait for the node to be created.
+				stat, watch, err = zkts.zconn.ExistsW(filePath)
+				if err != nil {
+					log.Warningf("WatchSrvKeyspace: ExistsW(%v) failed: %v", filePath, err)
+					return
+				}
+				if stat != nil {
+					// It was created in the meantime.
+					continue
+				}
+			} else {
+				srvKeyspace = topo.NewSrvKeyspace(int64(stat.Version()))
+				if len(data) > 0 {
+					if err := json.Unmarshal([]byte(data), srvKeyspace); err != nil {
+						log.Errorf("WatchSrvKeyspace: SrvKeyspace unmarshal failed: %v %v", data, err)
+						return
+					}
+				}
+			}
+			select {
+			case notifications <- srvKeyspace:
+			case <-stopWatching:
+				return
+			}
+			select {
+			case <-watch:
+			case <-stopWatching:
+				return
+			}
+		}
+	}()
+	return notifications, stopWatching, nil
+}
+
 func (zkts *Server) GetSrvKeyspaceNames(cell string) ([]string, error) {
 	children, _, err := zkts.zconn.Children(zkPathForCell(cell))
 	if err != nil {
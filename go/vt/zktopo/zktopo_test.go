@@ -14,6 +14,12 @@ func TestKeyspace(t *testing.T) {
 	test.CheckKeyspace(t, ts)
 }
 
+func TestVSchema(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckVSchema(t, ts)
+}
+
 func TestShard(t *testing.T) {
 	ts := NewTestServer(t, []string{"test"})
 	defer ts.Close()